@@ -81,7 +81,7 @@ True when the provider has the minimum config to be enabled.
 */}}
 {{- define "ipam.oauthProviderConfigured" -}}
 {{- $p := .provider -}}
-{{- if and $p.clientId $p.authUrl $p.tokenUrl $p.userInfoUrl -}}
+{{- if and $p.clientId (or $p.issuerUrl (and $p.authUrl $p.tokenUrl $p.userInfoUrl)) -}}
 true
 {{- end -}}
 {{- end }}
@@ -134,12 +134,22 @@ OAuth-related container env vars (OAUTH_PROVIDERS and OAUTH_<ID>_*).
       name: {{ $root.Values.existingSecret }}
       key: {{ include "ipam.oauthClientSecretKey" (dict "id" $id "provider" $p) }}
 {{- end }}
+{{- if $p.issuerUrl }}
+- name: {{ $prefix }}ISSUER_URL
+  value: {{ $p.issuerUrl | quote }}
+{{- end }}
+{{- if $p.authUrl }}
 - name: {{ $prefix }}AUTH_URL
   value: {{ $p.authUrl | quote }}
+{{- end }}
+{{- if $p.tokenUrl }}
 - name: {{ $prefix }}TOKEN_URL
   value: {{ $p.tokenUrl | quote }}
+{{- end }}
+{{- if $p.userInfoUrl }}
 - name: {{ $prefix }}USERINFO_URL
   value: {{ $p.userInfoUrl | quote }}
+{{- end }}
 {{- if $p.scopes }}
 - name: {{ $prefix }}SCOPES
   value: {{ join "," $p.scopes | quote }}
@@ -172,6 +182,18 @@ OAuth-related container env vars (OAUTH_PROVIDERS and OAUTH_<ID>_*).
 - name: {{ $prefix }}EMAILS_VERIFIED_CLAIM
   value: {{ $p.emailsVerifiedClaim | quote }}
 {{- end }}
+{{- if $p.groupsClaim }}
+- name: {{ $prefix }}GROUPS_CLAIM
+  value: {{ $p.groupsClaim | quote }}
+{{- end }}
+{{- if $p.groupMappings }}
+{{- $rules := list }}
+{{- range $p.groupMappings }}
+{{- $rules = append $rules (printf "%s=%s:%s" .group .organization .role) }}
+{{- end }}
+- name: {{ $prefix }}GROUP_MAPPINGS
+  value: {{ join "," $rules | quote }}
+{{- end }}
 {{- if $p.allowEmailMatch }}
 - name: {{ $prefix }}ALLOW_EMAIL_MATCH
   value: {{ $p.allowEmailMatch | quote }}
@@ -195,3 +217,136 @@ OAuth-related container env vars (OAUTH_PROVIDERS and OAUTH_<ID>_*).
 {{- end }}
 {{- end }}
 {{- end }}
+
+{{/*
+SAML-related container env vars (SAML_PROVIDERS and SAML_<ID>_*).
+*/}}
+{{- define "ipam.samlEnv" }}
+{{- $ids := list }}
+{{- range $id, $p := .Values.saml.providers | default dict }}
+{{- if and (or $p.idpMetadataUrl $p.idpMetadataFile) $p.spCertFile $p.spKeyFile }}
+{{- $ids = append $ids $id }}
+{{- end }}
+{{- end }}
+{{- if $ids }}
+{{- $_ := required "config.appOrigin is required when SAML providers are configured" .Values.config.appOrigin }}
+
+- name: SAML_PROVIDERS
+  value: {{ join "," $ids | quote }}
+{{- range $id := $ids }}
+{{- $p := index $.Values.saml.providers $id }}
+{{- $prefix := printf "SAML_%s_" ($id | replace "-" "_" | upper) }}
+
+- name: {{ $prefix }}SP_CERT_FILE
+  value: {{ $p.spCertFile | quote }}
+- name: {{ $prefix }}SP_KEY_FILE
+  value: {{ $p.spKeyFile | quote }}
+{{- if $p.idpMetadataUrl }}
+- name: {{ $prefix }}IDP_METADATA_URL
+  value: {{ $p.idpMetadataUrl | quote }}
+{{- end }}
+{{- if $p.idpMetadataFile }}
+- name: {{ $prefix }}IDP_METADATA_FILE
+  value: {{ $p.idpMetadataFile | quote }}
+{{- end }}
+- name: {{ $prefix }}ENTITY_ID
+  value: {{ required (printf "saml.providers.%s.entityId is required" $id) $p.entityId | quote }}
+{{- if $p.emailAttribute }}
+- name: {{ $prefix }}EMAIL_ATTRIBUTE
+  value: {{ $p.emailAttribute | quote }}
+{{- end }}
+{{- if $p.groupsAttribute }}
+- name: {{ $prefix }}GROUPS_ATTRIBUTE
+  value: {{ $p.groupsAttribute | quote }}
+{{- end }}
+{{- if $p.groupMappings }}
+{{- $rules := list }}
+{{- range $p.groupMappings }}
+{{- $rules = append $rules (printf "%s=%s:%s" .group .organization .role) }}
+{{- end }}
+- name: {{ $prefix }}GROUP_MAPPINGS
+  value: {{ join "," $rules | quote }}
+{{- end }}
+{{- if $p.allowEmailMatch }}
+- name: {{ $prefix }}ALLOW_EMAIL_MATCH
+  value: {{ $p.allowEmailMatch | quote }}
+{{- end }}
+{{- if $p.displayName }}
+- name: {{ $prefix }}DISPLAY_NAME
+  value: {{ $p.displayName | quote }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+LDAP env vars. Enabled when ldap.url and ldap.baseDn are set.
+*/}}
+{{- define "ipam.ldapEnv" }}
+{{- $l := .Values.ldap | default dict }}
+{{- if and $l.url $l.baseDn }}
+
+- name: LDAP_URL
+  value: {{ $l.url | quote }}
+- name: LDAP_BASE_DN
+  value: {{ $l.baseDn | quote }}
+{{- if $l.startTls }}
+- name: LDAP_START_TLS
+  value: "true"
+{{- end }}
+{{- if $l.allowInsecure }}
+- name: LDAP_ALLOW_INSECURE
+  value: "true"
+{{- end }}
+{{- if $l.allowEmailMatch }}
+- name: LDAP_ALLOW_EMAIL_MATCH
+  value: "true"
+{{- end }}
+{{- range $key, $env := dict "caFile" "LDAP_CA_FILE" "bindDn" "LDAP_BIND_DN" "userFilter" "LDAP_USER_FILTER" "emailAttribute" "LDAP_EMAIL_ATTRIBUTE" "groupBaseDn" "LDAP_GROUP_BASE_DN" "groupFilter" "LDAP_GROUP_FILTER" "groupAttribute" "LDAP_GROUP_ATTRIBUTE" }}
+{{- if index $l $key }}
+- name: {{ $env }}
+  value: {{ index $l $key | quote }}
+{{- end }}
+{{- end }}
+{{- if $l.bindPassword }}
+- name: LDAP_BIND_PASSWORD
+  value: {{ $l.bindPassword | quote }}
+{{- else if and $l.bindDn .Values.existingSecret }}
+- name: LDAP_BIND_PASSWORD
+  valueFrom:
+    secretKeyRef:
+      name: {{ .Values.existingSecret }}
+      key: ldap-bind-password
+{{- end }}
+{{- if $l.groupMappings }}
+{{- $rules := list }}
+{{- range $l.groupMappings }}
+{{- $rules = append $rules (printf "%s=%s:%s" .group .organization .role) }}
+{{- end }}
+- name: LDAP_GROUP_MAPPINGS
+  value: {{ join "," $rules | quote }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- define "ipam.scimEnv" }}
+{{- $sc := .Values.scim | default dict }}
+{{- if and $sc.organization .Values.existingSecret }}
+
+- name: SCIM_ORGANIZATION
+  value: {{ $sc.organization | quote }}
+- name: SCIM_TOKEN
+  valueFrom:
+    secretKeyRef:
+      name: {{ .Values.existingSecret }}
+      key: scim-token
+{{- if $sc.groupMappings }}
+{{- $rules := list }}
+{{- range $sc.groupMappings }}
+{{- $rules = append $rules (printf "%s=%s:%s" .group .organization .role) }}
+{{- end }}
+- name: SCIM_GROUP_MAPPINGS
+  value: {{ join "," $rules | quote }}
+{{- end }}
+{{- end }}
+{{- end }}
@@ -3,6 +3,7 @@ package aws
 import (
 	"context"
 
+	"github.com/JakeNeyer/ipam/store"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 )
@@ -47,12 +48,12 @@ func (a *ec2APIAdapter) DescribeSubnets(ctx context.Context, vpcID string) ([]ec
 	return describeSubnetsByVPCWithClient(ctx, a.client, vpcID)
 }
 
-// getEC2API returns the EC2 IPAM API for the given region. Tests can set ec2APIForTest to inject a mock.
-var getEC2API = func(ctx context.Context, region string) (EC2IPAMAPI, error) {
+// getEC2API returns the EC2 IPAM API for the given connection and region. Tests can set ec2APIForTest to inject a mock.
+var getEC2API = func(ctx context.Context, conn *store.CloudConnection, region string) (EC2IPAMAPI, error) {
 	if ec2APIForTest != nil {
 		return ec2APIForTest, nil
 	}
-	client, err := newEC2Client(ctx, region)
+	client, err := newEC2Client(ctx, conn, region)
 	if err != nil {
 		return nil, err
 	}
@@ -2,9 +2,12 @@ package aws
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/JakeNeyer/ipam/internal/integrations"
+	"github.com/JakeNeyer/ipam/store"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 )
 
@@ -26,8 +29,24 @@ func init() {
 }
 
 // newEC2Client creates an EC2 client for the given region. Shared by read (via getEC2API) and write.
-func newEC2Client(ctx context.Context, region string) (*ec2.Client, error) {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+// When conn has a CredentialsRef, static credentials (access_key_id, secret_access_key, optional
+// session_token) are resolved from the secrets backend; otherwise the default credential chain is used.
+func newEC2Client(ctx context.Context, conn *store.CloudConnection, region string) (*ec2.Client, error) {
+	opts := []func(*config.LoadOptions) error{config.WithRegion(region)}
+	secret, err := integrations.ResolveCredentials(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if secret != nil {
+		keyID, key := secret.Get("access_key_id"), secret.Get("secret_access_key")
+		if keyID == "" || key == "" {
+			return nil, fmt.Errorf("aws credentials must contain access_key_id and secret_access_key")
+		}
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(keyID, key, secret.Get("session_token")),
+		))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
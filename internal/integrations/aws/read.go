@@ -29,7 +29,7 @@ func (p *Provider) SyncPools(ctx context.Context, conn *store.CloudConnection) (
 	}
 	envID = env.Id
 
-	api, err := getEC2API(ctx, cfg.Region)
+	api, err := getEC2API(ctx, conn, cfg.Region)
 	if err != nil {
 		return nil, fmt.Errorf("ec2 client: %w", err)
 	}
@@ -122,7 +122,7 @@ func (p *Provider) SyncBlocks(ctx context.Context, conn *store.CloudConnection,
 	if env == nil || err != nil {
 		return nil, fmt.Errorf("environment_id not found: %w", err)
 	}
-	api, err := getEC2API(ctx, cfg.Region)
+	api, err := getEC2API(ctx, conn, cfg.Region)
 	if err != nil {
 		return nil, fmt.Errorf("ec2 client: %w", err)
 	}
@@ -207,7 +207,7 @@ func (p *Provider) SyncAllocations(ctx context.Context, conn *store.CloudConnect
 	if err != nil || cfg == nil || cfg.Region == "" {
 		return nil, fmt.Errorf("invalid aws connection config: need region")
 	}
-	api, err := getEC2API(ctx, cfg.Region)
+	api, err := getEC2API(ctx, conn, cfg.Region)
 	if err != nil {
 		return nil, fmt.Errorf("ec2 client: %w", err)
 	}
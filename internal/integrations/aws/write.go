@@ -39,11 +39,11 @@ const ipamPoolCidrProvisionedTimeout = 3 * time.Minute
 // ec2WriteAPIForTest is set by tests to inject a mock; must be reset after each test.
 var ec2WriteAPIForTest ec2WriteAPI
 
-func getWriteClient(ctx context.Context, region string) (ec2WriteAPI, error) {
+func getWriteClient(ctx context.Context, conn *store.CloudConnection, region string) (ec2WriteAPI, error) {
 	if ec2WriteAPIForTest != nil {
 		return ec2WriteAPIForTest, nil
 	}
-	return newEC2Client(ctx, region)
+	return newEC2Client(ctx, conn, region)
 }
 
 // SupportsPush returns true; AWS provider supports write (push to cloud).
@@ -117,7 +117,7 @@ func (p *Provider) CreatePoolInCloud(ctx context.Context, conn *store.CloudConne
 	if cfg.IpamScopeId == "" {
 		return "", fmt.Errorf("aws connection config must set ipam_scope_id to create pools")
 	}
-	client, err := getWriteClient(ctx, cfg.Region)
+	client, err := getWriteClient(ctx, conn, cfg.Region)
 	if err != nil {
 		return "", fmt.Errorf("ec2 client: %w", err)
 	}
@@ -179,7 +179,7 @@ func (p *Provider) DeletePoolInCloud(ctx context.Context, conn *store.CloudConne
 	if err != nil || cfg == nil || cfg.Region == "" {
 		return fmt.Errorf("invalid aws connection config: need region")
 	}
-	client, err := getWriteClient(ctx, cfg.Region)
+	client, err := getWriteClient(ctx, conn, cfg.Region)
 	if err != nil {
 		return fmt.Errorf("ec2 client: %w", err)
 	}
@@ -363,7 +363,7 @@ func (p *Provider) AllocateBlockInCloud(ctx context.Context, conn *store.CloudCo
 	if err != nil || cfg == nil || cfg.Region == "" {
 		return "", fmt.Errorf("invalid aws connection config: need region")
 	}
-	client, err := getWriteClient(ctx, cfg.Region)
+	client, err := getWriteClient(ctx, conn, cfg.Region)
 	if err != nil {
 		return "", fmt.Errorf("ec2 client: %w", err)
 	}
@@ -444,7 +444,7 @@ func (p *Provider) CreateAllocationInCloud(ctx context.Context, conn *store.Clou
 	if err != nil || cfg == nil || cfg.Region == "" {
 		return "", fmt.Errorf("invalid aws connection config: need region")
 	}
-	client, err := getWriteClient(ctx, cfg.Region)
+	client, err := getWriteClient(ctx, conn, cfg.Region)
 	if err != nil {
 		return "", fmt.Errorf("ec2 client: %w", err)
 	}
@@ -489,7 +489,7 @@ func (p *Provider) DeleteBlockInCloud(ctx context.Context, conn *store.CloudConn
 	if err != nil || cfg == nil || cfg.Region == "" {
 		return fmt.Errorf("invalid aws connection config: need region")
 	}
-	client, err := getWriteClient(ctx, cfg.Region)
+	client, err := getWriteClient(ctx, conn, cfg.Region)
 	if err != nil {
 		return fmt.Errorf("ec2 client: %w", err)
 	}
@@ -508,7 +508,7 @@ func (p *Provider) DeleteAllocationInCloud(ctx context.Context, conn *store.Clou
 	if err != nil || cfg == nil || cfg.Region == "" {
 		return fmt.Errorf("invalid aws connection config: need region")
 	}
-	client, err := getWriteClient(ctx, cfg.Region)
+	client, err := getWriteClient(ctx, conn, cfg.Region)
 	if err != nil {
 		return fmt.Errorf("ec2 client: %w", err)
 	}
@@ -0,0 +1,20 @@
+package integrations
+
+import (
+	"context"
+	"strings"
+
+	"github.com/JakeNeyer/ipam/internal/secrets"
+	"github.com/JakeNeyer/ipam/store"
+)
+
+// ResolveCredentials resolves conn.CredentialsRef with the default secrets router, scoped to
+// the connection's organization.
+// Returns nil (and no error) when the connection has no reference; providers then fall back to
+// their ambient credential chain (e.g. the AWS default config).
+func ResolveCredentials(ctx context.Context, conn *store.CloudConnection) (secrets.Secret, error) {
+	if conn == nil || conn.CredentialsRef == nil || strings.TrimSpace(*conn.CredentialsRef) == "" {
+		return nil, nil
+	}
+	return secrets.Default().Resolve(ctx, conn.OrganizationID.String(), *conn.CredentialsRef)
+}
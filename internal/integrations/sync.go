@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/netip"
 	"strings"
 
 	"github.com/JakeNeyer/ipam/internal/logger"
@@ -244,6 +245,7 @@ func applyBlockDiffs(s store.Storer, conn *store.CloudConnection, result *BlockS
 				continue
 			}
 			block.ID = existing.ID
+			block.Isolated = existing.Isolated
 			if err := s.UpdateBlock(block.ID, block); err != nil {
 				return err
 			}
@@ -265,6 +267,7 @@ func applyBlockDiffs(s store.Storer, conn *store.CloudConnection, result *BlockS
 			if adopted.Name != "" {
 				block.Name = adopted.Name
 			}
+			block.Isolated = adopted.Isolated
 			if err := s.UpdateBlock(block.ID, block); err != nil {
 				return err
 			}
@@ -536,30 +539,23 @@ func PushBlocksToCloud(ctx context.Context, s store.Storer, conn *store.CloudCon
 			return err
 		}
 		// When IPAM conflict: also include env blocks with no PoolID whose CIDR is contained in pool (blocks created before pool adoption)
+		poolSet, _ := network.ParsePrefixSet(pool.CIDR)
 		if conn.ConflictResolution == "ipam" && pool.CIDR != "" {
 			envBlocks, _, _ := s.ListBlocksFiltered("", &pool.EnvironmentID, nil, nil, false, "", nil, 10000, 0)
+			// Avoid dupes (block might already be in blocks via ListBlocksByPool)
+			seen := make(map[uuid.UUID]bool, len(blocks))
+			for _, b := range blocks {
+				seen[b.ID] = true
+			}
 			for _, b := range envBlocks {
-				if b.ExternalID != "" || b.PoolID != nil {
-					continue
-				}
-				if b.CIDR == "" {
+				if b.ExternalID != "" || b.PoolID != nil || seen[b.ID] {
 					continue
 				}
-				contained, _ := network.Contains(pool.CIDR, b.CIDR)
-				if !contained {
+				if p, err := netip.ParsePrefix(b.CIDR); err != nil || !poolSet.ContainsPrefix(p) {
 					continue
 				}
-				// Avoid dupes (block might already be in blocks via ListBlocksByPool)
-				seen := false
-				for _, exist := range blocks {
-					if exist.ID == b.ID {
-						seen = true
-						break
-					}
-				}
-				if !seen {
-					blocks = append(blocks, b)
-				}
+				seen[b.ID] = true
+				blocks = append(blocks, b)
 			}
 		}
 		for _, block := range blocks {
@@ -571,8 +567,8 @@ func PushBlocksToCloud(ctx context.Context, s store.Storer, conn *store.CloudCon
 			}
 			// Pre-check: block CIDR must be contained in pool's CIDR (avoids AllocateIpamPoolCidr errors after adoption when app pool CIDR differs from AWS)
 			if pool.CIDR != "" {
-				contained, _ := network.Contains(pool.CIDR, block.CIDR)
-				if !contained {
+				p, err := netip.ParsePrefix(block.CIDR)
+				if err != nil || !poolSet.ContainsPrefix(p) {
 					logger.Info("sync push block skipped (block CIDR not contained in pool CIDR)",
 						slog.String("connection_id", connID.String()), slog.String("block_name", block.Name),
 						slog.String("block_cidr", block.CIDR), slog.String("pool_cidr", pool.CIDR))
@@ -18,6 +18,11 @@ const (
 	KeyEmail         = "email"
 	KeyOperation     = "operation"
 	KeySetupRequired = "setup_required"
+	// Audit entries identify who made a change: a user or a service account.
+	KeyActorType      = "actor_type"
+	KeyActorID        = "actor_id"
+	KeyActor          = "actor"
+	KeyOrganizationID = "organization_id"
 )
 
 // Standard error messages (consistent, non-PII where possible).
@@ -31,6 +36,7 @@ const (
 	MsgAuthInvalidCreds      = "invalid email or password"
 	MsgAuthPasswordMismatch  = "password mismatch"
 	MsgStoreError            = "store error"
+	MsgAudit                 = "audit"
 )
 
 var Log *slog.Logger
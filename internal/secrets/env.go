@@ -0,0 +1,49 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// DefaultEnvPrefix is prepended to every variable read by the env backend so that a
+// credentials reference cannot read unrelated process environment (e.g. DATABASE_URL).
+const DefaultEnvPrefix = "IPAM_SECRET_"
+
+// EnvResolver resolves "env:<name>" by collecting variables named <prefix><SCOPE>_<NAME>_<KEY>,
+// where SCOPE is the organization ID with "-" replaced by "_". For example with the default prefix,
+// "env:aws-prod" in organization 0b5c2d1e-… reads IPAM_SECRET_0B5C2D1E_…_AWS_PROD_ACCESS_KEY_ID
+// and IPAM_SECRET_0B5C2D1E_…_AWS_PROD_SECRET_ACCESS_KEY into keys "access_key_id" and "secret_access_key".
+type EnvResolver struct {
+	prefix  string
+	environ func() []string
+}
+
+// NewEnvResolver returns an env backend reading variables that start with prefix.
+func NewEnvResolver(prefix string) *EnvResolver {
+	return &EnvResolver{prefix: prefix, environ: os.Environ}
+}
+
+func envName(name string) string {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	return strings.NewReplacer("-", "_", ".", "_", "/", "_").Replace(name)
+}
+
+func (r *EnvResolver) Resolve(ctx context.Context, scope, path string) (Secret, error) {
+	if err := validScope(scope); err != nil {
+		return nil, err
+	}
+	want := r.prefix + envName(scope) + "_" + envName(path) + "_"
+	out := make(Secret)
+	for _, kv := range r.environ() {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(k, want) || len(k) == len(want) {
+			continue
+		}
+		out[strings.ToLower(k[len(want):])] = v
+	}
+	if len(out) == 0 {
+		return nil, ErrNotFound
+	}
+	return out, nil
+}
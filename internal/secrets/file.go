@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSecretFileBytes caps a single key file; credentials are small and this guards against mis-mounts.
+const maxSecretFileBytes = 64 << 10
+
+// FileResolver resolves "file:<name>" to the directory <root>/<scope>/<name> (scope is the
+// organization ID), where each regular file is
+// one key (the layout of a mounted Kubernetes Secret). Trailing newlines are trimmed from values.
+// Hidden entries (e.g. the ..data symlinks kubelet maintains) are skipped.
+type FileResolver struct {
+	root string
+}
+
+// NewFileResolver returns a file backend rooted at dir.
+func NewFileResolver(dir string) *FileResolver {
+	return &FileResolver{root: dir}
+}
+
+func (r *FileResolver) Resolve(ctx context.Context, scope, path string) (Secret, error) {
+	if err := validScope(scope); err != nil {
+		return nil, err
+	}
+	if !filepath.IsLocal(path) || strings.ContainsAny(path, `/\`) {
+		return nil, fmt.Errorf("invalid secret name: must be a single directory name")
+	}
+	dir := filepath.Join(r.root, scope, path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("read secret directory: %w", err)
+	}
+	out := make(Secret)
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		p := filepath.Join(dir, name)
+		info, err := os.Stat(p) // follow kubelet symlinks
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if info.Size() > maxSecretFileBytes {
+			return nil, fmt.Errorf("secret key %q exceeds %d bytes", name, maxSecretFileBytes)
+		}
+		b, err := os.ReadFile(p) // #nosec G304 -- path is confined to the configured secrets directory
+		if err != nil {
+			return nil, fmt.Errorf("read secret key %q: %w", name, err)
+		}
+		out[strings.ToLower(name)] = strings.TrimRight(string(b), "\r\n")
+	}
+	if len(out) == 0 {
+		return nil, ErrNotFound
+	}
+	return out, nil
+}
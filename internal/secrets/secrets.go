@@ -0,0 +1,172 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned when a reference points at a secret that does not exist.
+var ErrNotFound = errors.New("secret not found")
+
+// Secret is a resolved set of credential values keyed by lowercase name (e.g. "access_key_id", "secret_access_key").
+type Secret map[string]string
+
+// Get returns the value for key (case-insensitive), or "" when unset.
+func (s Secret) Get(key string) string {
+	return s[strings.ToLower(strings.TrimSpace(key))]
+}
+
+// Resolver resolves the path part of a credentials reference to a Secret.
+// Every path is resolved under scope (the owning organization's ID), so one tenant's
+// reference can never name another tenant's secret. Implementations must never include
+// secret values in returned errors.
+type Resolver interface {
+	Resolve(ctx context.Context, scope, path string) (Secret, error)
+}
+
+// validScope reports whether scope can be used as a single path segment / variable name part.
+func validScope(scope string) error {
+	if scope == "" || scope == "." || strings.Contains(scope, "..") || strings.ContainsAny(scope, `/\`) {
+		return fmt.Errorf("invalid credentials scope")
+	}
+	return nil
+}
+
+// ParseRef splits a credentials reference of the form "<scheme>:<path>" (e.g. "env:aws-prod",
+// "file:aws-prod", "vault:ipam/aws-prod"). The scheme is lowercased; the path must be non-empty.
+func ParseRef(ref string) (scheme, path string, err error) {
+	ref = strings.TrimSpace(ref)
+	i := strings.Index(ref, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid credentials reference: expected <scheme>:<path>")
+	}
+	scheme = strings.ToLower(ref[:i])
+	path = strings.TrimSpace(ref[i+1:])
+	if path == "" {
+		return "", "", fmt.Errorf("invalid credentials reference: path is empty")
+	}
+	return scheme, path, nil
+}
+
+// Router dispatches a credentials reference to the Resolver registered for its scheme.
+type Router struct {
+	mu        sync.RWMutex
+	resolvers map[string]Resolver
+}
+
+// NewRouter returns an empty Router; use Register to add backends.
+func NewRouter() *Router {
+	return &Router{resolvers: make(map[string]Resolver)}
+}
+
+// Register adds (or replaces) the resolver for scheme.
+func (r *Router) Register(scheme string, res Resolver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolvers[strings.ToLower(strings.TrimSpace(scheme))] = res
+}
+
+// Schemes returns the registered schemes in sorted order.
+func (r *Router) Schemes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]string, 0, len(r.resolvers))
+	for s := range r.resolvers {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Validate checks that ref is well-formed and that a backend is configured for its scheme.
+// It does not contact the backend.
+func (r *Router) Validate(ref string) error {
+	scheme, _, err := ParseRef(ref)
+	if err != nil {
+		return err
+	}
+	r.mu.RLock()
+	_, ok := r.resolvers[scheme]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("credentials backend %q is not configured (available: %s)", scheme, strings.Join(r.Schemes(), ", "))
+	}
+	return nil
+}
+
+// Resolve parses ref and resolves it under scope with the backend registered for its scheme.
+func (r *Router) Resolve(ctx context.Context, scope, ref string) (Secret, error) {
+	if err := r.Validate(ref); err != nil {
+		return nil, err
+	}
+	if err := validScope(scope); err != nil {
+		return nil, err
+	}
+	scheme, path, _ := ParseRef(ref)
+	r.mu.RLock()
+	res := r.resolvers[scheme]
+	r.mu.RUnlock()
+	secret, err := res.Resolve(ctx, scope, path)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s credentials %q: %w", scheme, path, err)
+	}
+	return secret, nil
+}
+
+// NewRouterFromEnv builds a Router from the environment:
+//   - "env" is always available and reads SECRETS_ENV_PREFIX (default IPAM_SECRET_) variables.
+//   - "file" is available when SECRETS_DIR is set (e.g. a mounted Kubernetes secret directory).
+//   - "vault" is available when VAULT_ADDR and VAULT_TOKEN (or VAULT_TOKEN_FILE) are set.
+func NewRouterFromEnv() (*Router, error) {
+	r := NewRouter()
+	prefix := DefaultEnvPrefix
+	if v, ok := os.LookupEnv("SECRETS_ENV_PREFIX"); ok {
+		prefix = strings.TrimSpace(v)
+	}
+	r.Register("env", NewEnvResolver(prefix))
+	if dir := strings.TrimSpace(os.Getenv("SECRETS_DIR")); dir != "" {
+		r.Register("file", NewFileResolver(dir))
+	}
+	vault, err := vaultResolverFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if vault != nil {
+		r.Register("vault", vault)
+	}
+	return r, nil
+}
+
+var (
+	defaultRouter   *Router
+	defaultRouterMu sync.Mutex
+)
+
+// Default returns the process-wide Router, building it from the environment on first use.
+// If the environment is invalid, only the "env" backend is available.
+func Default() *Router {
+	defaultRouterMu.Lock()
+	defer defaultRouterMu.Unlock()
+	if defaultRouter == nil {
+		r, err := NewRouterFromEnv()
+		if err != nil {
+			r = NewRouter()
+			r.Register("env", NewEnvResolver(DefaultEnvPrefix))
+		}
+		defaultRouter = r
+	}
+	return defaultRouter
+}
+
+// SetDefault replaces the process-wide Router (used at startup and in tests). Passing nil
+// makes the next Default call rebuild it from the environment.
+func SetDefault(r *Router) {
+	defaultRouterMu.Lock()
+	defer defaultRouterMu.Unlock()
+	defaultRouter = r
+}
@@ -0,0 +1,197 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		ref        string
+		wantScheme string
+		wantPath   string
+		wantErr    bool
+	}{
+		{"env:aws-prod", "env", "aws-prod", false},
+		{" VAULT:ipam/aws ", "vault", "ipam/aws", false},
+		{"file:aws", "file", "aws", false},
+		{"aws-prod", "", "", true},
+		{":path", "", "", true},
+		{"env:", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			scheme, path, err := ParseRef(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRef(%q) err = %v, wantErr %v", tt.ref, err, tt.wantErr)
+			}
+			if scheme != tt.wantScheme || path != tt.wantPath {
+				t.Errorf("ParseRef(%q) = %q, %q; want %q, %q", tt.ref, scheme, path, tt.wantScheme, tt.wantPath)
+			}
+		})
+	}
+}
+
+const (
+	orgA = "0b5c2d1e-7f3a-4c1d-9e2b-6a8f0c4d3e21"
+	orgB = "5e9a1c3b-2d4f-4a6e-8b0c-1f7d9e3a5c42"
+)
+
+func TestEnvResolver(t *testing.T) {
+	r := NewEnvResolver(DefaultEnvPrefix)
+	r.environ = func() []string {
+		return []string{
+			"IPAM_SECRET_0B5C2D1E_7F3A_4C1D_9E2B_6A8F0C4D3E21_AWS_PROD_ACCESS_KEY_ID=AKIA",
+			"IPAM_SECRET_0B5C2D1E_7F3A_4C1D_9E2B_6A8F0C4D3E21_AWS_PROD_SECRET_ACCESS_KEY=s3cr=t",
+			"IPAM_SECRET_0B5C2D1E_7F3A_4C1D_9E2B_6A8F0C4D3E21_AWS_PRODUCTION_ACCESS_KEY_ID=other",
+			"IPAM_SECRET_AWS_PROD_ACCESS_KEY_ID=unscoped",
+			"DATABASE_URL=postgres://",
+		}
+	}
+	got, err := r.Resolve(context.Background(), orgA, "aws-prod")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got.Get("access_key_id") != "AKIA" || got.Get("SECRET_ACCESS_KEY") != "s3cr=t" {
+		t.Errorf("Resolve = %v", got)
+	}
+	if len(got) != 2 {
+		t.Errorf("Resolve returned %d keys, want 2: %v", len(got), got)
+	}
+	if _, err := r.Resolve(context.Background(), orgA, "database"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve(database) err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, orgA, "aws-prod")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "access_key_id"), []byte("AKIA\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret_access_key"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".hidden"), []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r := NewFileResolver(root)
+	got, err := r.Resolve(context.Background(), orgA, "aws-prod")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got.Get("access_key_id") != "AKIA" || got.Get("secret_access_key") != "secret" || len(got) != 2 {
+		t.Errorf("Resolve = %v", got)
+	}
+	if _, err := r.Resolve(context.Background(), orgA, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve(missing) err = %v, want ErrNotFound", err)
+	}
+	for _, bad := range []string{"../etc", "a/b", "/etc"} {
+		if _, err := r.Resolve(context.Background(), orgA, bad); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Resolve(%q) err = %v, want invalid name error", bad, err)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter()
+	env := NewEnvResolver("T_")
+	env.environ = func() []string { return []string{"T_ORG_X_KEY=v"} }
+	r.Register("env", env)
+
+	if err := r.Validate("env:x"); err != nil {
+		t.Errorf("Validate(env:x) = %v", err)
+	}
+	if err := r.Validate("vault:x"); err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Errorf("Validate(vault:x) = %v, want not configured", err)
+	}
+	got, err := r.Resolve(context.Background(), "org", "env:x")
+	if err != nil || got.Get("key") != "v" {
+		t.Errorf("Resolve(env:x) = %v, %v", got, err)
+	}
+	_, err = r.Resolve(context.Background(), "org", "env:missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve(env:missing) err = %v, want wrapped ErrNotFound", err)
+	}
+	for _, scope := range []string{"", "..", "a/b"} {
+		if _, err := r.Resolve(context.Background(), scope, "env:x"); err == nil {
+			t.Errorf("Resolve with scope %q: want error", scope)
+		}
+	}
+}
+
+func TestRouter_ScopesByOrganization(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, orgB, "aws")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "access_key_id"), []byte("org-b"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := NewEnvResolver(DefaultEnvPrefix)
+	env.environ = func() []string {
+		return []string{"IPAM_SECRET_5E9A1C3B_2D4F_4A6E_8B0C_1F7D9E3A5C42_AWS_ACCESS_KEY_ID=org-b"}
+	}
+	srv := newVaultStandIn(t, "root", map[string]interface{}{
+		"/v1/secret/data/" + orgB + "/aws": map[string]interface{}{
+			"data": map[string]interface{}{"data": map[string]interface{}{"access_key_id": "org-b"}},
+		},
+	})
+	vault, err := NewVaultResolver(VaultConfig{Address: srv.URL, Token: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRouter()
+	r.Register("env", env)
+	r.Register("file", NewFileResolver(root))
+	r.Register("vault", vault)
+
+	for _, ref := range []string{"env:aws", "file:aws", "vault:aws"} {
+		got, err := r.Resolve(context.Background(), orgB, ref)
+		if err != nil || got.Get("access_key_id") != "org-b" {
+			t.Errorf("org B Resolve(%s) = %v, %v", ref, got, err)
+		}
+		if _, err := r.Resolve(context.Background(), orgA, ref); !errors.Is(err, ErrNotFound) {
+			t.Errorf("org A Resolve(%s) err = %v, want ErrNotFound", ref, err)
+		}
+	}
+	// org A cannot reach org B's secrets by naming them in the path.
+	for _, ref := range []string{"env:" + orgB + "_aws", "file:../" + orgB + "/aws", "vault:../" + orgB + "/aws"} {
+		if _, err := r.Resolve(context.Background(), orgA, ref); err == nil {
+			t.Errorf("org A Resolve(%s): want error", ref)
+		}
+	}
+}
+
+func TestNewRouterFromEnv(t *testing.T) {
+	t.Setenv("SECRETS_DIR", t.TempDir())
+	t.Setenv("VAULT_ADDR", "")
+	r, err := NewRouterFromEnv()
+	if err != nil {
+		t.Fatalf("NewRouterFromEnv: %v", err)
+	}
+	if got := strings.Join(r.Schemes(), ","); got != "env,file" {
+		t.Errorf("Schemes() = %q, want env,file", got)
+	}
+	t.Setenv("VAULT_ADDR", "http://127.0.0.1:8200")
+	t.Setenv("VAULT_TOKEN", "root")
+	r, err = NewRouterFromEnv()
+	if err != nil {
+		t.Fatalf("NewRouterFromEnv with vault: %v", err)
+	}
+	if got := strings.Join(r.Schemes(), ","); got != "env,file,vault" {
+		t.Errorf("Schemes() = %q, want env,file,vault", got)
+	}
+	t.Setenv("VAULT_KV_VERSION", "3")
+	if _, err := NewRouterFromEnv(); err == nil {
+		t.Error("NewRouterFromEnv with VAULT_KV_VERSION=3: want error")
+	}
+}
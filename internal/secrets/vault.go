@@ -0,0 +1,151 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultConfig configures the HashiCorp Vault KV backend.
+type VaultConfig struct {
+	Address   string // e.g. https://vault.example.com:8200
+	Token     string // #nosec G117 -- Vault token from config, not logged
+	Namespace string // optional Vault Enterprise namespace
+	Mount     string // KV mount path; default "secret"
+	KVVersion int    // 1 or 2; default 2
+	Client    *http.Client
+}
+
+// VaultResolver resolves "vault:<path>" by reading <mount>/<scope>/<path> (scope is the
+// organization ID) from a KV v1 or v2 engine.
+// String values are returned as-is; other JSON values are returned in their JSON encoding.
+type VaultResolver struct {
+	cfg VaultConfig
+}
+
+// NewVaultResolver returns a Vault KV backend. Address and Token are required.
+func NewVaultResolver(cfg VaultConfig) (*VaultResolver, error) {
+	cfg.Address = strings.TrimRight(strings.TrimSpace(cfg.Address), "/")
+	if cfg.Address == "" || cfg.Token == "" {
+		return nil, fmt.Errorf("vault address and token are required")
+	}
+	if _, err := url.Parse(cfg.Address); err != nil {
+		return nil, fmt.Errorf("invalid vault address: %w", err)
+	}
+	cfg.Mount = strings.Trim(strings.TrimSpace(cfg.Mount), "/")
+	if cfg.Mount == "" {
+		cfg.Mount = "secret"
+	}
+	if cfg.KVVersion == 0 {
+		cfg.KVVersion = 2
+	}
+	if cfg.KVVersion != 1 && cfg.KVVersion != 2 {
+		return nil, fmt.Errorf("vault kv version must be 1 or 2")
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &VaultResolver{cfg: cfg}, nil
+}
+
+// vaultResolverFromEnv returns a VaultResolver from VAULT_* variables, or nil when VAULT_ADDR is unset.
+func vaultResolverFromEnv() (*VaultResolver, error) {
+	addr := strings.TrimSpace(os.Getenv("VAULT_ADDR"))
+	if addr == "" {
+		return nil, nil
+	}
+	token := strings.TrimSpace(os.Getenv("VAULT_TOKEN"))
+	if token == "" {
+		if f := strings.TrimSpace(os.Getenv("VAULT_TOKEN_FILE")); f != "" {
+			b, err := os.ReadFile(f) // #nosec G304 -- operator-supplied token file
+			if err != nil {
+				return nil, fmt.Errorf("read VAULT_TOKEN_FILE: %w", err)
+			}
+			token = strings.TrimSpace(string(b))
+		}
+	}
+	cfg := VaultConfig{
+		Address:   addr,
+		Token:     token,
+		Namespace: strings.TrimSpace(os.Getenv("VAULT_NAMESPACE")),
+		Mount:     os.Getenv("VAULT_KV_MOUNT"),
+	}
+	switch strings.TrimSpace(os.Getenv("VAULT_KV_VERSION")) {
+	case "1":
+		cfg.KVVersion = 1
+	case "", "2":
+		cfg.KVVersion = 2
+	default:
+		return nil, fmt.Errorf("VAULT_KV_VERSION must be 1 or 2")
+	}
+	return NewVaultResolver(cfg)
+}
+
+func (r *VaultResolver) Resolve(ctx context.Context, scope, path string) (Secret, error) {
+	if err := validScope(scope); err != nil {
+		return nil, err
+	}
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if path == "" || strings.Contains(path, "..") {
+		return nil, fmt.Errorf("invalid vault path")
+	}
+	apiPath := "/v1/" + r.cfg.Mount + "/" + scope + "/" + path
+	if r.cfg.KVVersion == 2 {
+		apiPath = "/v1/" + r.cfg.Mount + "/data/" + scope + "/" + path
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.cfg.Address+apiPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", r.cfg.Token)
+	if r.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", r.cfg.Namespace)
+	}
+	resp, err := r.cfg.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned status %d", resp.StatusCode)
+	}
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode vault response: %w", err)
+	}
+	data := body.Data
+	if r.cfg.KVVersion == 2 {
+		raw, ok := body.Data["data"]
+		if !ok || string(raw) == "null" {
+			return nil, ErrNotFound // deleted or destroyed version
+		}
+		data = nil
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return nil, fmt.Errorf("decode vault kv v2 data: %w", err)
+		}
+	}
+	out := make(Secret, len(data))
+	for k, raw := range data {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			out[strings.ToLower(k)] = s
+			continue
+		}
+		out[strings.ToLower(k)] = string(raw)
+	}
+	if len(out) == 0 {
+		return nil, ErrNotFound
+	}
+	return out, nil
+}
@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newVaultStandIn returns a minimal Vault KV HTTP server serving data at the given API paths.
+func newVaultStandIn(t *testing.T, token string, paths map[string]interface{}) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, ok := paths[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultResolver_KVv2(t *testing.T) {
+	srv := newVaultStandIn(t, "root", map[string]interface{}{
+		"/v1/secret/data/" + orgA + "/ipam/aws-prod": map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]interface{}{"access_key_id": "AKIA", "secret_access_key": "s", "port": 443},
+				"metadata": map[string]interface{}{"version": 3},
+			},
+		},
+	})
+	r, err := NewVaultResolver(VaultConfig{Address: srv.URL, Token: "root"})
+	if err != nil {
+		t.Fatalf("NewVaultResolver: %v", err)
+	}
+	got, err := r.Resolve(context.Background(), orgA, "ipam/aws-prod")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got.Get("access_key_id") != "AKIA" || got.Get("secret_access_key") != "s" || got.Get("port") != "443" {
+		t.Errorf("Resolve = %v", got)
+	}
+	if _, err := r.Resolve(context.Background(), orgA, "ipam/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve(missing) err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestVaultResolver_KVv1(t *testing.T) {
+	srv := newVaultStandIn(t, "root", map[string]interface{}{
+		"/v1/kv/" + orgA + "/aws": map[string]interface{}{"data": map[string]interface{}{"access_key_id": "AKIA"}},
+	})
+	r, err := NewVaultResolver(VaultConfig{Address: srv.URL, Token: "root", Mount: "/kv/", KVVersion: 1})
+	if err != nil {
+		t.Fatalf("NewVaultResolver: %v", err)
+	}
+	got, err := r.Resolve(context.Background(), orgA, "aws")
+	if err != nil || got.Get("access_key_id") != "AKIA" {
+		t.Errorf("Resolve = %v, %v", got, err)
+	}
+}
+
+func TestVaultResolver_Errors(t *testing.T) {
+	srv := newVaultStandIn(t, "root", map[string]interface{}{
+		"/v1/secret/data/" + orgA + "/deleted": map[string]interface{}{"data": map[string]interface{}{"data": nil}},
+	})
+	if _, err := NewVaultResolver(VaultConfig{Address: srv.URL}); err == nil {
+		t.Error("NewVaultResolver without token: want error")
+	}
+	wrong, _ := NewVaultResolver(VaultConfig{Address: srv.URL, Token: "wrong-token"})
+	_, err := wrong.Resolve(context.Background(), orgA, "ipam/aws")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Resolve with wrong token err = %v, want status 403", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "wrong-token") {
+		t.Errorf("error leaks token: %v", err)
+	}
+	r, _ := NewVaultResolver(VaultConfig{Address: srv.URL, Token: "root"})
+	if _, err := r.Resolve(context.Background(), orgA, "deleted"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve(deleted) err = %v, want ErrNotFound", err)
+	}
+	if _, err := r.Resolve(context.Background(), orgA, "../sys/seal"); err == nil {
+		t.Error("Resolve with .. path: want error")
+	}
+}
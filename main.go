@@ -43,15 +43,19 @@ func main() {
 	}
 	defer closeStore()
 
-	serverCfg := config.LoadFromEnv()
-	oauthEnabled := len(serverCfg.EnabledOAuthProviders()) > 0
+	serverCfg, err := config.LoadFromEnv()
+	if err != nil {
+		logger.Error("config invalid", logger.ErrAttr(err))
+		os.Exit(1)
+	}
+	oauthEnabled := len(serverCfg.EnabledOAuthProviders())+len(serverCfg.EnabledSAMLProviders()) > 0
 	if err := setup.EnsureInitialAdmin(st, oauthEnabled); err != nil {
 		logger.Error("initial admin bootstrap failed", logger.ErrAttr(err))
 		os.Exit(1)
 	}
 	setup.EnsureDemoFixtures(st)
 
-	handlers.StartBackgroundSync(st)
+	handlers.StartBackgroundSync(st, serverCfg.Sync)
 
 	s, err := server.NewServer(st, serverCfg)
 	if err != nil {
@@ -11,6 +11,7 @@ import (
 // PoolID optionally links the block to an environment pool; the block's CIDR must be contained in the pool's CIDR.
 // Provider/ExternalID/ConnectionID support cloud integrations (e.g. AWS allocation -> Block for a VPC).
 // DeletedAt is set when the block is soft-deleted (IPAM conflict); sync will delete it in the cloud then remove the row.
+// Isolated marks an intentional duplicate (e.g. a lab VPC that is never peered); overlap analysis ignores it.
 type Block struct {
 	ID             uuid.UUID  `json:"id"`
 	Name           string     `json:"name"`
@@ -24,6 +25,7 @@ type Block struct {
 	ExternalID     string     `json:"external_id,omitempty"`     // provider resource ID (e.g. vpc-xxxx)
 	ConnectionID   *uuid.UUID `json:"connection_id,omitempty"`   // cloud connection used to sync
 	DeletedAt      *time.Time `json:"deleted_at,omitempty"`      // set when soft-deleted (pending cloud delete on next sync)
+	Isolated       bool       `json:"isolated,omitempty"`        // excluded from cross-environment/connection overlap analysis
 }
 
 type Usage struct {
@@ -0,0 +1,264 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxBlueprintCount bounds the count of any one blueprint entry.
+const maxBlueprintCount = 256
+
+// BlueprintSpec describes a network layout by prefix length and naming pattern rather than by CIDR: pools carved from
+// a parent pool, blocks in each pool, and allocations in each block. Blocks listed at the top level are placed directly
+// in the parent pool.
+//
+// Names are patterns: {name} is the instance name given when the blueprint is instantiated, {pool} and {block} the
+// rendered name of the enclosing pool and block, and {n} the 1-based index of an entry with a count above one.
+type BlueprintSpec struct {
+	Pools  []BlueprintPool  `json:"pools,omitempty"`
+	Blocks []BlueprintBlock `json:"blocks,omitempty"`
+}
+
+// BlueprintPool is a pool in a blueprint, repeated Count times (default 1).
+type BlueprintPool struct {
+	Name         string           `json:"name"`
+	PrefixLength int              `json:"prefix_length"`
+	Count        int              `json:"count,omitempty"`
+	Blocks       []BlueprintBlock `json:"blocks,omitempty"`
+}
+
+// BlueprintBlock is a network block in a blueprint, repeated Count times (default 1).
+type BlueprintBlock struct {
+	Name         string                `json:"name"`
+	PrefixLength int                   `json:"prefix_length"`
+	Count        int                   `json:"count,omitempty"`
+	Allocations  []BlueprintAllocation `json:"allocations,omitempty"`
+}
+
+// BlueprintAllocation is an allocation in a blueprint block, repeated Count times (default 1).
+type BlueprintAllocation struct {
+	Name         string `json:"name"`
+	PrefixLength int    `json:"prefix_length"`
+	Count        int    `json:"count,omitempty"`
+}
+
+// validateEntry checks one entry's name, prefix length (at least parentPrefix, the enclosing entry's) and count.
+func validateEntry(kind, name string, prefixLength, parentPrefix, count int) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("%s name is required", kind)
+	}
+	if prefixLength < 1 || prefixLength > 128 {
+		return fmt.Errorf("%s %q: prefix_length must be between 1 and 128", kind, name)
+	}
+	if prefixLength < parentPrefix {
+		return fmt.Errorf("%s %q: /%d does not fit in its /%d parent", kind, name, prefixLength, parentPrefix)
+	}
+	if count < 0 || count > maxBlueprintCount {
+		return fmt.Errorf("%s %q: count must be between 1 and %d", kind, name, maxBlueprintCount)
+	}
+	if count > 1 && !strings.Contains(name, "{n}") {
+		return fmt.Errorf("%s %q: a name repeated %d times must include {n}", kind, name, count)
+	}
+	return nil
+}
+
+func (b BlueprintBlock) validate(parentPrefix int) error {
+	if err := validateEntry("block", b.Name, b.PrefixLength, parentPrefix, b.Count); err != nil {
+		return err
+	}
+	for _, a := range b.Allocations {
+		if err := validateEntry("allocation", a.Name, a.PrefixLength, b.PrefixLength, a.Count); err != nil {
+			return fmt.Errorf("block %q: %w", b.Name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the spec is well formed: every entry is named, nests inside its parent's prefix length and has a
+// usable count. Whether it fits a given parent pool is only known when it is planned.
+func (spec BlueprintSpec) Validate() error {
+	if len(spec.Pools) == 0 && len(spec.Blocks) == 0 {
+		return errors.New("blueprint must define at least one pool or block")
+	}
+	for _, p := range spec.Pools {
+		if err := validateEntry("pool", p.Name, p.PrefixLength, 0, p.Count); err != nil {
+			return err
+		}
+		for _, b := range p.Blocks {
+			if err := b.validate(p.PrefixLength); err != nil {
+				return fmt.Errorf("pool %q: %w", p.Name, err)
+			}
+		}
+	}
+	for _, b := range spec.Blocks {
+		if err := b.validate(0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PlannedAllocation is an allocation a blueprint instance will create.
+type PlannedAllocation struct {
+	Name string
+	CIDR string
+}
+
+// PlannedBlock is a block a blueprint instance will create, with its allocations.
+type PlannedBlock struct {
+	Name        string
+	CIDR        string
+	Allocations []PlannedAllocation
+}
+
+// PlannedPool is a pool a blueprint instance will create, with its blocks.
+type PlannedPool struct {
+	Name   string
+	CIDR   string
+	Blocks []PlannedBlock
+}
+
+// BlueprintPlan is the concrete layout of one blueprint instance.
+type BlueprintPlan struct {
+	Pools  []PlannedPool
+	Blocks []PlannedBlock // directly in the parent pool
+}
+
+// renderName fills a name pattern. Empty values leave their placeholder in place.
+func renderName(pattern string, vars map[string]string, n int) string {
+	out := strings.ReplaceAll(pattern, "{n}", strconv.Itoa(n))
+	for k, v := range vars {
+		if v != "" {
+			out = strings.ReplaceAll(out, "{"+k+"}", v)
+		}
+	}
+	return strings.TrimSpace(out)
+}
+
+// planEntry is one expanded entry awaiting a CIDR.
+type planEntry struct {
+	name   string
+	prefix int
+}
+
+func countOf(c int) int {
+	if c < 1 {
+		return 1
+	}
+	return c
+}
+
+// packEntries places entries in supernet, largest first, reporting a failure by the entry's rendered name.
+func packEntries(kind, supernet string, entries []planEntry, used []string) ([]string, error) {
+	prefixes := make([]int, len(entries))
+	for i, e := range entries {
+		prefixes[i] = e.prefix
+	}
+	cidrs, err := PackCIDRs(supernet, prefixes, used, Placement{})
+	var packErr *PackError
+	if errors.As(err, &packErr) {
+		e := entries[packErr.Index]
+		return nil, fmt.Errorf("%s %q (/%d) does not fit in %s after placing %d of %d", kind, e.name, e.prefix, supernet, packErr.Placed, packErr.Total)
+	}
+	return cidrs, err
+}
+
+// planBlock expands and places a block's allocations.
+func planBlock(b BlueprintBlock, name, cidr string, vars map[string]string) (PlannedBlock, error) {
+	out := PlannedBlock{Name: name, CIDR: cidr}
+	blockVars := map[string]string{"name": vars["name"], "pool": vars["pool"], "block": name}
+	var entries []planEntry
+	for _, a := range b.Allocations {
+		for n := 1; n <= countOf(a.Count); n++ {
+			entries = append(entries, planEntry{name: renderName(a.Name, blockVars, n), prefix: a.PrefixLength})
+		}
+	}
+	cidrs, err := packEntries("allocation", cidr, entries, nil)
+	if err != nil {
+		return out, fmt.Errorf("block %q: %w", name, err)
+	}
+	for i, e := range entries {
+		out.Allocations = append(out.Allocations, PlannedAllocation{Name: e.name, CIDR: cidrs[i]})
+	}
+	return out, nil
+}
+
+// planBlocks expands and places blocks in supernet (which nothing else uses), then their allocations.
+func planBlocks(blocks []BlueprintBlock, supernet string, vars map[string]string) ([]PlannedBlock, error) {
+	var entries []planEntry
+	var specs []BlueprintBlock
+	for _, b := range blocks {
+		for n := 1; n <= countOf(b.Count); n++ {
+			entries = append(entries, planEntry{name: renderName(b.Name, vars, n), prefix: b.PrefixLength})
+			specs = append(specs, b)
+		}
+	}
+	cidrs, err := packEntries("block", supernet, entries, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]PlannedBlock, len(entries))
+	for i, e := range entries {
+		if out[i], err = planBlock(specs[i], e.name, cidrs[i], vars); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Plan lays out an instance of spec named name in parent, avoiding usedCIDRs. Pools and top-level blocks are packed
+// together in the parent, largest first; blocks are then packed in their pool and allocations in their block. The
+// plan is all or nothing: an error means some entry does not fit.
+func (spec BlueprintSpec) Plan(name, parent string, usedCIDRs []string) (*BlueprintPlan, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	vars := map[string]string{"name": strings.TrimSpace(name)}
+
+	var entries []planEntry
+	var pools []BlueprintPool
+	for _, p := range spec.Pools {
+		for n := 1; n <= countOf(p.Count); n++ {
+			entries = append(entries, planEntry{name: renderName(p.Name, vars, n), prefix: p.PrefixLength})
+			pools = append(pools, p)
+		}
+	}
+	var blocks []BlueprintBlock
+	for _, b := range spec.Blocks {
+		for n := 1; n <= countOf(b.Count); n++ {
+			entries = append(entries, planEntry{name: renderName(b.Name, vars, n), prefix: b.PrefixLength})
+			blocks = append(blocks, b)
+		}
+	}
+	kind := "pool or block"
+	if len(blocks) == 0 {
+		kind = "pool"
+	} else if len(pools) == 0 {
+		kind = "block"
+	}
+	cidrs, err := packEntries(kind, parent, entries, usedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &BlueprintPlan{}
+	for i, p := range pools {
+		pool := PlannedPool{Name: entries[i].name, CIDR: cidrs[i]}
+		poolVars := map[string]string{"name": vars["name"], "pool": pool.Name}
+		if pool.Blocks, err = planBlocks(p.Blocks, pool.CIDR, poolVars); err != nil {
+			return nil, fmt.Errorf("pool %q: %w", pool.Name, err)
+		}
+		plan.Pools = append(plan.Pools, pool)
+	}
+	for i, b := range blocks {
+		j := len(pools) + i
+		block, err := planBlock(b, entries[j].name, cidrs[j], vars)
+		if err != nil {
+			return nil, err
+		}
+		plan.Blocks = append(plan.Blocks, block)
+	}
+	return plan, nil
+}
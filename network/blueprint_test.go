@@ -0,0 +1,92 @@
+package network
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlueprintSpecValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    BlueprintSpec
+		wantErr string
+	}{
+		{"empty", BlueprintSpec{}, "at least one pool or block"},
+		{"ok", BlueprintSpec{Pools: []BlueprintPool{{Name: "{name}-{n}", PrefixLength: 20, Count: 2, Blocks: []BlueprintBlock{{Name: "{pool}-vpc", PrefixLength: 22}}}}}, ""},
+		{"unnamed pool", BlueprintSpec{Pools: []BlueprintPool{{PrefixLength: 20}}}, "pool name is required"},
+		{"bad prefix", BlueprintSpec{Blocks: []BlueprintBlock{{Name: "b", PrefixLength: 0}}}, "prefix_length must be between"},
+		{"block larger than pool", BlueprintSpec{Pools: []BlueprintPool{{Name: "p", PrefixLength: 20, Blocks: []BlueprintBlock{{Name: "b", PrefixLength: 16}}}}}, `pool "p": block "b": /16 does not fit in its /20 parent`},
+		{"allocation larger than block", BlueprintSpec{Blocks: []BlueprintBlock{{Name: "b", PrefixLength: 24, Allocations: []BlueprintAllocation{{Name: "a", PrefixLength: 23}}}}}, `block "b": allocation "a"`},
+		{"count without {n}", BlueprintSpec{Blocks: []BlueprintBlock{{Name: "b", PrefixLength: 24, Count: 3}}}, "must include {n}"},
+		{"count too large", BlueprintSpec{Blocks: []BlueprintBlock{{Name: "b-{n}", PrefixLength: 24, Count: 257}}}, "count must be between"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.spec.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBlueprintSpecPlan(t *testing.T) {
+	spec := BlueprintSpec{
+		Pools: []BlueprintPool{{
+			Name: "{name}-az{n}", PrefixLength: 20, Count: 2,
+			Blocks: []BlueprintBlock{{
+				Name: "{pool}-vpc", PrefixLength: 21,
+				Allocations: []BlueprintAllocation{
+					{Name: "{block}-public-{n}", PrefixLength: 24, Count: 2},
+					{Name: "{block}-private", PrefixLength: 22},
+				},
+			}},
+		}},
+		Blocks: []BlueprintBlock{{Name: "{name}-shared", PrefixLength: 24}},
+	}
+	plan, err := spec.Plan("prod", "10.0.0.0/16", []string{"10.0.0.0/20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Pools) != 2 || plan.Pools[0].Name != "prod-az1" || plan.Pools[0].CIDR != "10.0.16.0/20" || plan.Pools[1].Name != "prod-az2" || plan.Pools[1].CIDR != "10.0.32.0/20" {
+		t.Fatalf("pools = %+v", plan.Pools)
+	}
+	if len(plan.Blocks) != 1 || plan.Blocks[0].Name != "prod-shared" || plan.Blocks[0].CIDR != "10.0.48.0/24" {
+		t.Fatalf("blocks = %+v", plan.Blocks)
+	}
+	vpc := plan.Pools[1].Blocks[0]
+	if vpc.Name != "prod-az2-vpc" || vpc.CIDR != "10.0.32.0/21" {
+		t.Fatalf("vpc = %+v", vpc)
+	}
+	want := []PlannedAllocation{
+		{"prod-az2-vpc-public-1", "10.0.36.0/24"},
+		{"prod-az2-vpc-public-2", "10.0.37.0/24"},
+		{"prod-az2-vpc-private", "10.0.32.0/22"},
+	}
+	if len(vpc.Allocations) != len(want) {
+		t.Fatalf("allocations = %+v", vpc.Allocations)
+	}
+	for i, a := range want {
+		if vpc.Allocations[i] != a {
+			t.Errorf("allocation %d = %+v, want %+v", i, vpc.Allocations[i], a)
+		}
+	}
+
+	// Four /20 pools do not fit in the /18 with its first /20 taken.
+	spec.Pools[0].Count = 4
+	if _, err := spec.Plan("prod", "10.0.0.0/18", []string{"10.0.0.0/20"}); err == nil || !strings.Contains(err.Error(), `"prod-az4" (/20) does not fit in 10.0.0.0/18 after placing 3 of 5`) {
+		t.Fatalf("err = %v", err)
+	}
+	// Allocations that overflow their block name the block.
+	spec.Pools[0].Count = 1
+	spec.Pools[0].Blocks[0].Allocations[1].PrefixLength = 21
+	if _, err := spec.Plan("prod", "10.0.0.0/16", nil); err == nil || !strings.Contains(err.Error(), `pool "prod-az1": block "prod-az1-vpc": allocation`) {
+		t.Fatalf("err = %v", err)
+	}
+}
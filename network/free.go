@@ -0,0 +1,23 @@
+package network
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// FreeRanges returns the ranges of supernet not covered by any of usedCIDRs, in address order (IPv4 and IPv6). Used
+// CIDRs that are invalid, in the other address family or outside the supernet are ignored; one that only partly
+// overlaps it counts for the overlapping part. This is the gap computation behind NextAvailableCIDRWithAllocations.
+func FreeRanges(supernet string, usedCIDRs []string) ([]AddressRange, error) {
+	super, err := netip.ParsePrefix(supernet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid supernet CIDR: %w", err)
+	}
+	used := make([]netip.Prefix, 0, len(usedCIDRs))
+	for _, c := range usedCIDRs {
+		if p, err := netip.ParsePrefix(c); err == nil {
+			used = append(used, p)
+		}
+	}
+	return NewPrefixSet(super).Difference(NewPrefixSet(used...)).Ranges(), nil
+}
@@ -0,0 +1,66 @@
+package network
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestFreeRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		supernet string
+		used     []string
+		want     [][2]string
+	}{
+		{"empty", "10.0.0.0/24", nil, [][2]string{{"10.0.0.0", "10.0.0.255"}}},
+		{"gaps", "10.0.0.0/24", []string{"10.0.0.64/26", "10.0.0.192/27"}, [][2]string{{"10.0.0.0", "10.0.0.63"}, {"10.0.0.128", "10.0.0.191"}, {"10.0.0.224", "10.0.0.255"}}},
+		{"nested and unsorted", "10.0.0.0/24", []string{"10.0.0.16/28", "10.0.0.0/25"}, [][2]string{{"10.0.0.128", "10.0.0.255"}}},
+		{"full", "10.0.0.0/24", []string{"10.0.0.0/25", "10.0.0.128/25"}, nil},
+		{"covering", "10.0.0.0/24", []string{"10.0.0.0/16"}, nil},
+		{"outside and other family ignored", "10.0.0.0/30", []string{"10.0.1.0/24", "::/0", "bogus"}, [][2]string{{"10.0.0.0", "10.0.0.3"}}},
+		{"ipv6", "2001:db8::/64", []string{"2001:db8::/65"}, [][2]string{{"2001:db8:0:0:8000::", "2001:db8::ffff:ffff:ffff:ffff"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FreeRanges(tt.supernet, tt.used)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pairs [][2]string
+			for _, r := range got {
+				pairs = append(pairs, [2]string{r.First.String(), r.Last.String()})
+			}
+			if !reflect.DeepEqual(pairs, tt.want) {
+				t.Errorf("FreeRanges = %v, want %v", pairs, tt.want)
+			}
+		})
+	}
+	if _, err := FreeRanges("nope", nil); err == nil {
+		t.Error("invalid supernet: want error")
+	}
+}
+
+func TestAddressRange_CIDRs(t *testing.T) {
+	tests := []struct {
+		first, last string
+		want        []string
+		size        string
+	}{
+		{"10.0.0.0", "10.0.0.255", []string{"10.0.0.0/24"}, "256"},
+		{"10.0.0.1", "10.0.0.6", []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"}, "6"},
+		{"10.0.0.128", "10.0.1.63", []string{"10.0.0.128/25", "10.0.1.0/26"}, "192"},
+		{"0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}, "4294967296"},
+		{"::", "::2", []string{"::/127", "::2/128"}, "3"},
+	}
+	for _, tt := range tests {
+		r := AddressRange{First: netip.MustParseAddr(tt.first), Last: netip.MustParseAddr(tt.last)}
+		var got []string
+		for _, p := range r.CIDRs() {
+			got = append(got, p.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) || r.Size().String() != tt.size {
+			t.Errorf("%s-%s: CIDRs = %v size %s, want %v size %s", tt.first, tt.last, got, r.Size(), tt.want, tt.size)
+		}
+	}
+}
@@ -1,197 +1,16 @@
 package network
 
 import (
-	"bytes"
 	"fmt"
 	"math/big"
 	"net"
-	"sort"
 )
 
-// ipRange holds the first and last IP of a CIDR (inclusive).
-type ipRange struct {
-	first, last net.IP
-}
-
-func cidrToRange(cidr string) (ipRange, error) {
-	_, n, err := net.ParseCIDR(cidr)
-	if err != nil {
-		return ipRange{}, err
-	}
-	first := make(net.IP, len(n.IP))
-	copy(first, n.IP)
-	last := make(net.IP, len(n.IP))
-	copy(last, n.IP)
-	for i := range last {
-		last[i] = last[i] | (n.Mask[i] ^ 0xff)
-	}
-	return ipRange{first: first, last: last}, nil
-}
-
-// ipLess returns true if a < b (lexicographic order, IPv4).
-func ipLess(a, b net.IP) bool {
-	return bytes.Compare(a.To4(), b.To4()) < 0
-}
-
-// nextAligned returns the smallest network address for prefixLen that is >= ip.
-// For IPv4 /p, the host part is the lower (32-p) bits; we round up ip to the next boundary.
-func nextAligned(ip net.IP, prefixLen int) net.IP {
-	ip = ip.To4()
-	if ip == nil || prefixLen <= 0 || prefixLen > 32 {
-		return ip
-	}
-	hostBits := uint(32 - prefixLen)
-	blockSize := uint32(1 << hostBits)
-	var v uint32
-	for i := 0; i < 4; i++ {
-		v = v<<8 | uint32(ip[i])
-	}
-	rem := v % blockSize
-	if rem != 0 {
-		v += blockSize - rem
-	}
-	out := make(net.IP, 4)
-	for i := 3; i >= 0; i-- {
-		out[i] = byte(v)
-		v >>= 8
-	}
-	return out
-}
-
-// NextAvailableCIDRWithAllocations returns a suggested CIDR of the given prefix length
-// within the supernet, considering existing allocations (IPv4 only). It bin-packs: first
-// tries to fill a gap between allocations; if no gap fits, returns the next available CIDR
-// after the last allocation. If allocatedCIDRs is empty, delegates to NextAvailableCIDR.
+// NextAvailableCIDRWithAllocations returns a suggested CIDR of the given prefix length within the supernet,
+// considering existing allocations. It bin-packs best fit: the smallest gap that holds the requested size is filled
+// first. See NextAvailableCIDRWithStrategy for other placements.
 func NextAvailableCIDRWithAllocations(supernet string, prefixLength int, allocatedCIDRs []string) (string, error) {
-	if len(allocatedCIDRs) == 0 {
-		return NextAvailableCIDR(supernet, prefixLength)
-	}
-	_, supernetNet, err := net.ParseCIDR(supernet)
-	if err != nil {
-		return "", fmt.Errorf("invalid supernet CIDR: %w", err)
-	}
-	if supernetNet.IP.To4() == nil {
-		return "", fmt.Errorf("suggest with allocations is IPv4 only")
-	}
-	supernetRange, err := cidrToRange(supernet)
-	if err != nil {
-		return "", err
-	}
-	supernetPrefix, bits := supernetNet.Mask.Size()
-	if prefixLength < supernetPrefix {
-		return "", fmt.Errorf("prefix length %d must be greater than supernet prefix %d", prefixLength, supernetPrefix)
-	}
-	if prefixLength > bits {
-		return "", fmt.Errorf("prefix length %d exceeds maximum", prefixLength)
-	}
-
-	var ranges []ipRange
-	for _, c := range allocatedCIDRs {
-		if c == "" {
-			continue
-		}
-		contained, err := Contains(supernet, c)
-		if err != nil || !contained {
-			continue
-		}
-		r, err := cidrToRange(c)
-		if err != nil {
-			continue
-		}
-		ranges = append(ranges, r)
-	}
-	sort.Slice(ranges, func(i, j int) bool { return ipLess(ranges[i].first, ranges[j].first) })
-
-	type gap struct{ first, last net.IP }
-	var gaps []gap
-	cur := make(net.IP, len(supernetRange.first))
-	copy(cur, supernetRange.first)
-	for _, r := range ranges {
-		if ipLess(cur, r.first) {
-			gaps = append(gaps, gap{first: dupIP(cur), last: prevIP(r.first)})
-		}
-		cur = nextIP(r.last)
-		if ipLess(supernetRange.last, cur) {
-			cur = nil
-			break
-		}
-	}
-	if cur != nil && !ipLess(supernetRange.last, cur) {
-		gaps = append(gaps, gap{first: dupIP(cur), last: dupIP(supernetRange.last)})
-	}
-
-	subnetSize := uint32(1 << (bits - prefixLength))
-
-	// Best-fit bin packing: choose the smallest gap that fits the requested size to reduce fragmentation.
-	var bestStart net.IP
-	var bestGapSize uint32 = 0
-	for _, g := range gaps {
-		start := nextAligned(g.first, prefixLength)
-		if ipLess(g.last, start) {
-			continue
-		}
-		endU32 := ipToU32(start) + subnetSize - 1
-		endIP := u32ToIP(endU32)
-		if ipLess(g.last, endIP) {
-			continue
-		}
-		if !supernetNet.Contains(endIP) {
-			continue
-		}
-		gapSize := ipToU32(g.last) - ipToU32(g.first) + 1
-		if bestStart == nil || gapSize < bestGapSize {
-			bestStart = start
-			bestGapSize = gapSize
-		}
-	}
-	if bestStart != nil {
-		return fmt.Sprintf("%s/%d", bestStart.String(), prefixLength), nil
-	}
-
-	var start net.IP
-	if len(ranges) == 0 {
-		start = supernetRange.first
-	} else {
-		start = nextIP(ranges[len(ranges)-1].last)
-	}
-	if ipLess(supernetRange.last, start) {
-		return "", fmt.Errorf("no space left in block")
-	}
-	aligned := nextAligned(start, prefixLength)
-	if !supernetNet.Contains(aligned) {
-		return "", fmt.Errorf("no available CIDR in block")
-	}
-	endU32 := ipToU32(aligned) + subnetSize - 1
-	if !supernetNet.Contains(u32ToIP(endU32)) {
-		return "", fmt.Errorf("no available CIDR in block")
-	}
-	return fmt.Sprintf("%s/%d", aligned.String(), prefixLength), nil
-}
-
-func dupIP(ip net.IP) net.IP {
-	d := make(net.IP, len(ip))
-	copy(d, ip)
-	return d
-}
-
-func prevIP(ip net.IP) net.IP {
-	ip = ip.To4()
-	if ip == nil {
-		return ip
-	}
-	v := ipToU32(ip)
-	if v == 0 {
-		return ip
-	}
-	return u32ToIP(v - 1)
-}
-
-func nextIP(ip net.IP) net.IP {
-	ip = ip.To4()
-	if ip == nil {
-		return ip
-	}
-	return u32ToIP(ipToU32(ip) + 1)
+	return NextAvailableCIDRWithStrategy(supernet, prefixLength, allocatedCIDRs, Placement{Strategy: StrategyBestFit})
 }
 
 func ipToU32(ip net.IP) uint32 {
@@ -0,0 +1,94 @@
+package network
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// OverlapPair identifies two overlapping CIDRs by their index in the input slice (I < J).
+// CIDR is the overlapping range, i.e. the more specific of the two prefixes.
+type OverlapPair struct {
+	I, J int
+	CIDR string
+}
+
+type indexedPrefix struct {
+	idx   int
+	p     netip.Prefix
+	first netip.Addr
+	last  netip.Addr
+}
+
+// prefixLastAddr returns the last address covered by p (p must be masked).
+func prefixLastAddr(p netip.Prefix) netip.Addr {
+	a := p.Addr()
+	b := a.AsSlice()
+	bits := p.Bits()
+	for i := range b {
+		hostBits := len(b)*8 - bits - (len(b)-1-i)*8
+		if hostBits <= 0 {
+			continue
+		}
+		if hostBits >= 8 {
+			b[i] = 0xff
+		} else {
+			b[i] |= byte(1<<hostBits) - 1
+		}
+	}
+	out, _ := netip.AddrFromSlice(b)
+	return out
+}
+
+// FindOverlaps returns every pair of overlapping CIDRs in cidrs (IPv4 and IPv6; families never overlap).
+// Invalid or empty entries are skipped. It sorts by start address and sweeps, so the cost is
+// O(n log n + k) for k overlapping pairs instead of comparing every pair.
+func FindOverlaps(cidrs []string) []OverlapPair {
+	items := make([]indexedPrefix, 0, len(cidrs))
+	for i, c := range cidrs {
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			continue
+		}
+		p = p.Masked()
+		items = append(items, indexedPrefix{idx: i, p: p, first: p.Addr(), last: prefixLastAddr(p)})
+	}
+	sort.Slice(items, func(a, b int) bool {
+		if c := items[a].first.Compare(items[b].first); c != 0 {
+			return c < 0
+		}
+		return items[a].p.Bits() < items[b].p.Bits()
+	})
+	var out []OverlapPair
+	var active []indexedPrefix
+	for _, it := range items {
+		// Drop active ranges that end before this one starts (or are in the other address family).
+		kept := active[:0]
+		for _, a := range active {
+			if a.first.Is4() == it.first.Is4() && a.last.Compare(it.first) >= 0 {
+				kept = append(kept, a)
+			}
+		}
+		active = kept
+		for _, a := range active {
+			pair := OverlapPair{I: a.idx, J: it.idx}
+			if pair.I > pair.J {
+				pair.I, pair.J = pair.J, pair.I
+			}
+			// Prefixes either nest or are disjoint, so the overlap is the longer prefix.
+			if a.p.Bits() >= it.p.Bits() {
+				pair.CIDR = a.p.String()
+			} else {
+				pair.CIDR = it.p.String()
+			}
+			out = append(out, pair)
+		}
+		active = append(active, it)
+	}
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].I != out[b].I {
+			return out[a].I < out[b].I
+		}
+		return out[a].J < out[b].J
+	})
+	return out
+}
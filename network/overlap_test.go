@@ -0,0 +1,55 @@
+package network
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindOverlaps(t *testing.T) {
+	tests := []struct {
+		name  string
+		cidrs []string
+		want  []OverlapPair
+	}{
+		{name: "empty", cidrs: nil, want: nil},
+		{name: "disjoint", cidrs: []string{"10.0.0.0/16", "10.1.0.0/16", "192.168.0.0/24"}, want: nil},
+		{name: "identical", cidrs: []string{"10.0.0.0/16", "10.0.0.0/16"}, want: []OverlapPair{{I: 0, J: 1, CIDR: "10.0.0.0/16"}}},
+		{
+			name:  "nested reports more specific prefix",
+			cidrs: []string{"10.0.1.0/24", "10.0.0.0/16", "172.16.0.0/12"},
+			want:  []OverlapPair{{I: 0, J: 1, CIDR: "10.0.1.0/24"}},
+		},
+		{
+			name:  "one supernet covers many",
+			cidrs: []string{"10.0.0.0/8", "10.1.0.0/16", "10.2.0.0/16", "11.0.0.0/8"},
+			want:  []OverlapPair{{I: 0, J: 1, CIDR: "10.1.0.0/16"}, {I: 0, J: 2, CIDR: "10.2.0.0/16"}},
+		},
+		{
+			name:  "adjacent ranges do not overlap",
+			cidrs: []string{"10.0.0.0/25", "10.0.0.128/25"},
+			want:  nil,
+		},
+		{
+			name:  "mixed families",
+			cidrs: []string{"2001:db8::/32", "10.0.0.0/8", "2001:db8:1::/48", "::/0"},
+			want: []OverlapPair{
+				{I: 0, J: 2, CIDR: "2001:db8:1::/48"},
+				{I: 0, J: 3, CIDR: "2001:db8::/32"},
+				{I: 2, J: 3, CIDR: "2001:db8:1::/48"},
+			},
+		},
+		{
+			name:  "invalid entries skipped",
+			cidrs: []string{"invalid", "10.0.0.0/24", "", "10.0.0.5/32"},
+			want:  []OverlapPair{{I: 1, J: 3, CIDR: "10.0.0.5/32"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FindOverlaps(tt.cidrs)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindOverlaps(%v) = %v, want %v", tt.cidrs, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+package network
+
+import (
+	"fmt"
+	"iter"
+	"math/big"
+	"math/bits"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// AddressRange is an inclusive range of addresses in one address family.
+type AddressRange struct {
+	First, Last netip.Addr
+}
+
+// Size returns the number of addresses in r.
+func (r AddressRange) Size() *big.Int {
+	first := new(big.Int).SetBytes(r.First.AsSlice())
+	last := new(big.Int).SetBytes(r.Last.AsSlice())
+	return last.Sub(last, first).Add(last, big.NewInt(1))
+}
+
+// CIDRs returns the minimal list of aligned prefixes that exactly cover r, in address order.
+func (r AddressRange) CIDRs() []netip.Prefix {
+	var out []netip.Prefix
+	for p := range r.prefixes() {
+		out = append(out, p)
+	}
+	return out
+}
+
+// prefixes yields the minimal aligned prefixes covering r: at each step the shortest prefix that starts at the
+// current address (bounded by its trailing zero bits) and still ends inside the range.
+func (r AddressRange) prefixes() iter.Seq[netip.Prefix] {
+	return func(yield func(netip.Prefix) bool) {
+		start := r.First
+		for start.IsValid() && start.Compare(r.Last) <= 0 {
+			p := netip.PrefixFrom(start, start.BitLen()-trailingZeroBits(start))
+			for prefixLastAddr(p).Compare(r.Last) > 0 {
+				p = netip.PrefixFrom(start, p.Bits()+1)
+			}
+			if !yield(p) {
+				return
+			}
+			start = prefixLastAddr(p).Next()
+		}
+	}
+}
+
+// RangeToPrefixes returns the minimal list of aligned prefixes covering first through last inclusive.
+func RangeToPrefixes(first, last netip.Addr) ([]netip.Prefix, error) {
+	if !first.IsValid() || !last.IsValid() || first.BitLen() != last.BitLen() {
+		return nil, fmt.Errorf("invalid range %s-%s", first, last)
+	}
+	if last.Less(first) {
+		return nil, fmt.Errorf("range start %s is after end %s", first, last)
+	}
+	return AddressRange{First: first.WithZone(""), Last: last.WithZone("")}.CIDRs(), nil
+}
+
+// trailingZeroBits returns the number of trailing zero bits of a (its bit length for the zero address).
+func trailingZeroBits(a netip.Addr) int {
+	b := a.AsSlice()
+	n := 0
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] != 0 {
+			return n + bits.TrailingZeros8(b[i])
+		}
+		n += 8
+	}
+	return n
+}
+
+// PrefixSet is a set of IPv4 and IPv6 addresses, held as sorted, disjoint and non-adjacent ranges (IPv4 first). The
+// zero value is the empty set. Sets are immutable: every operation returns a new set, so they are safe to share.
+type PrefixSet struct {
+	ranges []AddressRange
+}
+
+// NewPrefixSet returns the set of addresses covered by prefixes. Invalid prefixes are ignored; host bits are masked.
+func NewPrefixSet(prefixes ...netip.Prefix) PrefixSet {
+	rs := make([]AddressRange, 0, len(prefixes))
+	for _, p := range prefixes {
+		if !p.IsValid() {
+			continue
+		}
+		p = p.Masked()
+		rs = append(rs, AddressRange{First: p.Addr(), Last: prefixLastAddr(p)})
+	}
+	return PrefixSet{ranges: coalesce(rs)}
+}
+
+// ParsePrefixSet parses cidrs into a set, failing on the first invalid CIDR.
+func ParsePrefixSet(cidrs ...string) (PrefixSet, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		p, err := netip.ParsePrefix(strings.TrimSpace(c))
+		if err != nil {
+			return PrefixSet{}, fmt.Errorf("invalid CIDR %q", c)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return NewPrefixSet(prefixes...), nil
+}
+
+// NewRangeSet returns the set of addresses in ranges. Ranges that mix families or end before they start are ignored.
+func NewRangeSet(ranges ...AddressRange) PrefixSet {
+	rs := make([]AddressRange, 0, len(ranges))
+	for _, r := range ranges {
+		if !r.First.IsValid() || !r.Last.IsValid() || r.First.BitLen() != r.Last.BitLen() || r.Last.Less(r.First) {
+			continue
+		}
+		rs = append(rs, AddressRange{First: r.First.WithZone(""), Last: r.Last.WithZone("")})
+	}
+	return PrefixSet{ranges: coalesce(rs)}
+}
+
+// AggregateCIDRs summarizes cidrs to the minimal equivalent prefix list in address order.
+func AggregateCIDRs(cidrs []string) ([]string, error) {
+	set, err := ParsePrefixSet(cidrs...)
+	if err != nil {
+		return nil, err
+	}
+	return set.Strings(), nil
+}
+
+// touches reports whether next starts inside or immediately after r (same family), so the two can be merged.
+func touches(r, next AddressRange) bool {
+	if r.Last.BitLen() != next.First.BitLen() {
+		return false
+	}
+	if next.First.Compare(r.Last) <= 0 {
+		return true
+	}
+	after := r.Last.Next()
+	return after.IsValid() && after == next.First
+}
+
+// coalesce sorts rs and merges overlapping and adjacent ranges. It reuses rs.
+func coalesce(rs []AddressRange) []AddressRange {
+	if len(rs) == 0 {
+		return nil
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].First.Less(rs[j].First) })
+	out := rs[:1]
+	for _, r := range rs[1:] {
+		last := &out[len(out)-1]
+		if touches(*last, r) {
+			if last.Last.Less(r.Last) {
+				last.Last = r.Last
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// maxAddr and minAddr order addresses across families (IPv4 before IPv6).
+func maxAddr(a, b netip.Addr) netip.Addr {
+	if a.Less(b) {
+		return b
+	}
+	return a
+}
+
+func minAddr(a, b netip.Addr) netip.Addr {
+	if a.Less(b) {
+		return a
+	}
+	return b
+}
+
+// Union returns the addresses in s or o.
+func (s PrefixSet) Union(o PrefixSet) PrefixSet {
+	if len(o.ranges) == 0 {
+		return s
+	}
+	if len(s.ranges) == 0 {
+		return o
+	}
+	// Merge the two sorted lists, then coalesce in one pass.
+	merged := make([]AddressRange, 0, len(s.ranges)+len(o.ranges))
+	i, j := 0, 0
+	for i < len(s.ranges) || j < len(o.ranges) {
+		if j == len(o.ranges) || (i < len(s.ranges) && s.ranges[i].First.Less(o.ranges[j].First)) {
+			merged = append(merged, s.ranges[i])
+			i++
+		} else {
+			merged = append(merged, o.ranges[j])
+			j++
+		}
+	}
+	out := merged[:1]
+	for _, r := range merged[1:] {
+		last := &out[len(out)-1]
+		if touches(*last, r) {
+			if last.Last.Less(r.Last) {
+				last.Last = r.Last
+			}
+			continue
+		}
+		out = append(out, r)
+	}
+	return PrefixSet{ranges: out}
+}
+
+// Intersect returns the addresses in both s and o.
+func (s PrefixSet) Intersect(o PrefixSet) PrefixSet {
+	var out []AddressRange
+	i, j := 0, 0
+	for i < len(s.ranges) && j < len(o.ranges) {
+		a, b := s.ranges[i], o.ranges[j]
+		lo, hi := maxAddr(a.First, b.First), minAddr(a.Last, b.Last)
+		if lo.Compare(hi) <= 0 {
+			out = append(out, AddressRange{First: lo, Last: hi})
+		}
+		if a.Last.Less(b.Last) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return PrefixSet{ranges: out}
+}
+
+// Difference returns the addresses in s but not in o (s with o excluded).
+func (s PrefixSet) Difference(o PrefixSet) PrefixSet {
+	var out []AddressRange
+	j := 0
+	for _, a := range s.ranges {
+		for j < len(o.ranges) && o.ranges[j].Last.Less(a.First) {
+			j++
+		}
+		cur, done := a.First, false
+		for k := j; k < len(o.ranges) && o.ranges[k].First.Compare(a.Last) <= 0; k++ {
+			b := o.ranges[k]
+			if cur.Less(b.First) {
+				out = append(out, AddressRange{First: cur, Last: b.First.Prev()})
+			}
+			if b.Last.Compare(a.Last) >= 0 {
+				done = true
+				break
+			}
+			cur = b.Last.Next()
+		}
+		if !done {
+			out = append(out, AddressRange{First: cur, Last: a.Last})
+		}
+	}
+	return PrefixSet{ranges: out}
+}
+
+// find returns the index of the first range ending at or after a.
+func (s PrefixSet) find(a netip.Addr) int {
+	return sort.Search(len(s.ranges), func(i int) bool { return s.ranges[i].Last.Compare(a) >= 0 })
+}
+
+// Contains reports whether a is in s.
+func (s PrefixSet) Contains(a netip.Addr) bool {
+	i := s.find(a)
+	return i < len(s.ranges) && s.ranges[i].First.Compare(a) <= 0
+}
+
+// ContainsPrefix reports whether every address of p is in s.
+func (s PrefixSet) ContainsPrefix(p netip.Prefix) bool {
+	if !p.IsValid() {
+		return false
+	}
+	p = p.Masked()
+	i := s.find(p.Addr())
+	return i < len(s.ranges) && s.ranges[i].First.Compare(p.Addr()) <= 0 && s.ranges[i].Last.Compare(prefixLastAddr(p)) >= 0
+}
+
+// OverlapsPrefix reports whether any address of p is in s.
+func (s PrefixSet) OverlapsPrefix(p netip.Prefix) bool {
+	if !p.IsValid() {
+		return false
+	}
+	p = p.Masked()
+	i := s.find(p.Addr())
+	return i < len(s.ranges) && s.ranges[i].First.Compare(prefixLastAddr(p)) <= 0
+}
+
+// Overlaps reports whether s and o share any address.
+func (s PrefixSet) Overlaps(o PrefixSet) bool {
+	i, j := 0, 0
+	for i < len(s.ranges) && j < len(o.ranges) {
+		a, b := s.ranges[i], o.ranges[j]
+		if maxAddr(a.First, b.First).Compare(minAddr(a.Last, b.Last)) <= 0 {
+			return true
+		}
+		if a.Last.Less(b.Last) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return false
+}
+
+// IsEmpty reports whether s has no addresses.
+func (s PrefixSet) IsEmpty() bool { return len(s.ranges) == 0 }
+
+// Equal reports whether s and o hold the same addresses.
+func (s PrefixSet) Equal(o PrefixSet) bool {
+	if len(s.ranges) != len(o.ranges) {
+		return false
+	}
+	for i := range s.ranges {
+		if s.ranges[i] != o.ranges[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Size returns the number of addresses in s.
+func (s PrefixSet) Size() *big.Int {
+	n := new(big.Int)
+	for _, r := range s.ranges {
+		n.Add(n, r.Size())
+	}
+	return n
+}
+
+// Ranges returns the set's disjoint ranges in address order.
+func (s PrefixSet) Ranges() []AddressRange {
+	return append([]AddressRange(nil), s.ranges...)
+}
+
+// All yields the minimal list of aligned prefixes covering s, in address order, without building the list.
+func (s PrefixSet) All() iter.Seq[netip.Prefix] {
+	return func(yield func(netip.Prefix) bool) {
+		for _, r := range s.ranges {
+			for p := range r.prefixes() {
+				if !yield(p) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Prefixes returns the minimal list of aligned prefixes covering s, in address order.
+func (s PrefixSet) Prefixes() []netip.Prefix {
+	var out []netip.Prefix
+	for p := range s.All() {
+		out = append(out, p)
+	}
+	return out
+}
+
+// Strings returns Prefixes as CIDR strings.
+func (s PrefixSet) Strings() []string {
+	var out []string
+	for p := range s.All() {
+		out = append(out, p.String())
+	}
+	return out
+}
+
+// String returns the set's prefixes separated by commas.
+func (s PrefixSet) String() string {
+	return strings.Join(s.Strings(), ",")
+}
@@ -0,0 +1,273 @@
+package network
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func mustSet(t testing.TB, cidrs ...string) PrefixSet {
+	t.Helper()
+	s, err := ParsePrefixSet(cidrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPrefixSet_Operations(t *testing.T) {
+	tests := []struct {
+		name                  string
+		a, b                  []string
+		union, inter, aMinusB []string
+	}{
+		{
+			name: "adjacent halves aggregate", a: []string{"10.0.0.0/25"}, b: []string{"10.0.0.128/25"},
+			union: []string{"10.0.0.0/24"}, aMinusB: []string{"10.0.0.0/25"},
+		},
+		{
+			name: "nested", a: []string{"10.0.0.0/24"}, b: []string{"10.0.0.64/26"},
+			union: []string{"10.0.0.0/24"}, inter: []string{"10.0.0.64/26"}, aMinusB: []string{"10.0.0.0/26", "10.0.0.128/25"},
+		},
+		{
+			name: "disjoint unsorted", a: []string{"10.0.2.0/24", "10.0.0.0/24"}, b: []string{"192.168.0.0/16"},
+			union: []string{"10.0.0.0/24", "10.0.2.0/24", "192.168.0.0/16"}, aMinusB: []string{"10.0.0.0/24", "10.0.2.0/24"},
+		},
+		{
+			name: "mixed families", a: []string{"10.0.0.0/8", "2001:db8::/32"}, b: []string{"2001:db8::/33", "10.128.0.0/9"},
+			union: []string{"10.0.0.0/8", "2001:db8::/32"}, inter: []string{"10.128.0.0/9", "2001:db8::/33"},
+			aMinusB: []string{"10.0.0.0/9", "2001:db8:8000::/33"},
+		},
+		{
+			name: "family edges", a: []string{"0.0.0.0/0"}, b: []string{"255.255.255.255/32", "0.0.0.0/32"},
+			union: []string{"0.0.0.0/0"}, inter: []string{"0.0.0.0/32", "255.255.255.255/32"},
+			aMinusB: []string{
+				"0.0.0.1/32", "0.0.0.2/31", "0.0.0.4/30", "0.0.0.8/29", "0.0.0.16/28", "0.0.0.32/27", "0.0.0.64/26",
+				"0.0.0.128/25", "0.0.1.0/24", "0.0.2.0/23", "0.0.4.0/22", "0.0.8.0/21", "0.0.16.0/20", "0.0.32.0/19",
+				"0.0.64.0/18", "0.0.128.0/17", "0.1.0.0/16", "0.2.0.0/15", "0.4.0.0/14", "0.8.0.0/13", "0.16.0.0/12",
+				"0.32.0.0/11", "0.64.0.0/10", "0.128.0.0/9", "1.0.0.0/8", "2.0.0.0/7", "4.0.0.0/6", "8.0.0.0/5",
+				"16.0.0.0/4", "32.0.0.0/3", "64.0.0.0/2", "128.0.0.0/2", "192.0.0.0/3", "224.0.0.0/4", "240.0.0.0/5",
+				"248.0.0.0/6", "252.0.0.0/7", "254.0.0.0/8", "255.0.0.0/9", "255.128.0.0/10", "255.192.0.0/11",
+				"255.224.0.0/12", "255.240.0.0/13", "255.248.0.0/14", "255.252.0.0/15", "255.254.0.0/16",
+				"255.255.0.0/17", "255.255.128.0/18", "255.255.192.0/19", "255.255.224.0/20", "255.255.240.0/21",
+				"255.255.248.0/22", "255.255.252.0/23", "255.255.254.0/24", "255.255.255.0/25", "255.255.255.128/26",
+				"255.255.255.192/27", "255.255.255.224/28", "255.255.255.240/29", "255.255.255.248/30",
+				"255.255.255.252/31", "255.255.255.254/32",
+			},
+		},
+		{
+			name: "empty", a: nil, b: []string{"10.0.0.0/24"},
+			union: []string{"10.0.0.0/24"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := mustSet(t, tt.a...), mustSet(t, tt.b...)
+			if got := a.Union(b).Strings(); !reflect.DeepEqual(got, tt.union) {
+				t.Errorf("Union = %v, want %v", got, tt.union)
+			}
+			if got := a.Intersect(b).Strings(); !reflect.DeepEqual(got, tt.inter) {
+				t.Errorf("Intersect = %v, want %v", got, tt.inter)
+			}
+			if got := a.Difference(b).Strings(); !reflect.DeepEqual(got, tt.aMinusB) {
+				t.Errorf("Difference = %v, want %v", got, tt.aMinusB)
+			}
+			if a.Overlaps(b) != (len(tt.inter) > 0) {
+				t.Errorf("Overlaps = %v, want %v", a.Overlaps(b), len(tt.inter) > 0)
+			}
+		})
+	}
+}
+
+func TestPrefixSet_Queries(t *testing.T) {
+	s := mustSet(t, "10.0.0.0/24", "10.0.2.0/24", "2001:db8::/64")
+	for _, tt := range []struct {
+		prefix             string
+		contains, overlaps bool
+	}{
+		{"10.0.0.128/25", true, true},
+		{"10.0.0.0/23", false, true},
+		{"10.0.1.0/24", false, false},
+		{"10.0.2.5/32", true, true},
+		{"2001:db8::/96", true, true},
+		{"2001:db8::/48", false, true},
+		{"::ffff:10.0.0.1/128", false, false},
+	} {
+		p := netip.MustParsePrefix(tt.prefix)
+		if got := s.ContainsPrefix(p); got != tt.contains {
+			t.Errorf("ContainsPrefix(%s) = %v", tt.prefix, got)
+		}
+		if got := s.OverlapsPrefix(p); got != tt.overlaps {
+			t.Errorf("OverlapsPrefix(%s) = %v", tt.prefix, got)
+		}
+	}
+	if !s.Contains(netip.MustParseAddr("10.0.2.255")) || s.Contains(netip.MustParseAddr("10.0.1.0")) {
+		t.Error("Contains")
+	}
+	if got := s.Size().String(); got != "18446744073709552128" {
+		t.Errorf("Size = %s", got)
+	}
+	if got := s.String(); got != "10.0.0.0/24,10.0.2.0/24,2001:db8::/64" {
+		t.Errorf("String = %s", got)
+	}
+	var first []string
+	for p := range s.All() {
+		first = append(first, p.String())
+		break
+	}
+	if !reflect.DeepEqual(first, []string{"10.0.0.0/24"}) {
+		t.Errorf("All stopped early = %v", first)
+	}
+	if !(PrefixSet{}).IsEmpty() || !mustSet(t, "10.0.0.0/25", "10.0.0.128/25").Equal(mustSet(t, "10.0.0.0/24")) {
+		t.Error("IsEmpty/Equal")
+	}
+	if _, err := ParsePrefixSet("10.0.0.0/24", "bogus"); err == nil {
+		t.Error("ParsePrefixSet: want error")
+	}
+}
+
+func TestRangeToPrefixes(t *testing.T) {
+	got, err := RangeToPrefixes(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[10.0.0.1/32 10.0.0.2/31 10.0.0.4/30 10.0.0.8/31 10.0.0.10/32]" {
+		t.Errorf("RangeToPrefixes = %v", got)
+	}
+	if _, err := RangeToPrefixes(netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.1")); err == nil {
+		t.Error("reversed range: want error")
+	}
+	if _, err := RangeToPrefixes(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("::1")); err == nil {
+		t.Error("mixed families: want error")
+	}
+	agg, err := AggregateCIDRs([]string{"10.0.0.0/26", "10.0.0.64/26", "10.0.0.128/25", "10.0.1.0/32"})
+	if err != nil || !reflect.DeepEqual(agg, []string{"10.0.0.0/24", "10.0.1.0/32"}) {
+		t.Errorf("AggregateCIDRs = %v, %v", agg, err)
+	}
+}
+
+// fuzzPrefixes derives prefixes inside 10.0.0.0/24 and 2001:db8::/120 from data, two bytes per prefix.
+func fuzzPrefixes(data []byte) []netip.Prefix {
+	var out []netip.Prefix
+	for i := 0; i+1 < len(data); i += 2 {
+		bits := 24 + int(data[i]%9)
+		if data[i]&0x80 != 0 {
+			out = append(out, netip.PrefixFrom(netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 15: data[i+1]}), 96+bits).Masked())
+			continue
+		}
+		out = append(out, netip.PrefixFrom(netip.AddrFrom4([4]byte{10, 0, 0, data[i+1]}), bits).Masked())
+	}
+	return out
+}
+
+// bitmap models a set over the 512 fuzzed addresses: 0-255 for 10.0.0.x, 256-511 for 2001:db8::xx.
+type bitmap [512]bool
+
+func bitmapOf(prefixes []netip.Prefix) bitmap {
+	var m bitmap
+	for _, p := range prefixes {
+		m.add(p)
+	}
+	return m
+}
+
+func (m *bitmap) add(p netip.Prefix) {
+	off := 0
+	if p.Addr().Is6() {
+		off = 256
+	}
+	b := p.Addr().AsSlice()
+	first := int(b[len(b)-1])
+	last := int(prefixLastAddr(p).AsSlice()[len(b)-1])
+	for i := first; i <= last; i++ {
+		m[off+i] = true
+	}
+}
+
+func checkSet(t *testing.T, op string, s PrefixSet, want bitmap) {
+	t.Helper()
+	prefixes := s.Prefixes()
+	if got := bitmapOf(prefixes); got != want {
+		t.Fatalf("%s: %v does not match model", op, prefixes)
+	}
+	// Minimal: prefixes are disjoint and no two adjacent ones could merge into their common parent.
+	for i := 1; i < len(prefixes); i++ {
+		prev, cur := prefixes[i-1], prefixes[i]
+		if !prev.Addr().Less(cur.Addr()) || prev.Overlaps(cur) {
+			t.Fatalf("%s: %s and %s overlap or are unsorted", op, prev, cur)
+		}
+		if prev.Bits() == cur.Bits() && prev.Bits() > 0 {
+			if parent, _ := prev.Addr().Prefix(prev.Bits() - 1); parent.Addr() == prev.Addr() && parent.Contains(cur.Addr()) {
+				t.Fatalf("%s: %s and %s should aggregate", op, prev, cur)
+			}
+		}
+	}
+	if !NewPrefixSet(prefixes...).Equal(s) {
+		t.Fatalf("%s: round trip through Prefixes changed the set", op)
+	}
+}
+
+func FuzzPrefixSet(f *testing.F) {
+	f.Add([]byte{0, 0, 8, 0}, []byte{2, 64})
+	f.Add([]byte{0x80, 0, 0x88, 255}, []byte{0x81, 128, 1, 0})
+	f.Add([]byte{3, 7, 4, 200, 5, 100}, []byte{8, 255, 6, 16})
+	f.Fuzz(func(t *testing.T, da, db []byte) {
+		pa, pb := fuzzPrefixes(da), fuzzPrefixes(db)
+		a, b := NewPrefixSet(pa...), NewPrefixSet(pb...)
+		ma, mb := bitmapOf(pa), bitmapOf(pb)
+		var union, inter, diff bitmap
+		for i := range ma {
+			union[i] = ma[i] || mb[i]
+			inter[i] = ma[i] && mb[i]
+			diff[i] = ma[i] && !mb[i]
+		}
+		checkSet(t, "a", a, ma)
+		checkSet(t, "union", a.Union(b), union)
+		checkSet(t, "intersect", a.Intersect(b), inter)
+		checkSet(t, "difference", a.Difference(b), diff)
+		if a.Overlaps(b) != !a.Intersect(b).IsEmpty() {
+			t.Fatal("Overlaps disagrees with Intersect")
+		}
+	})
+}
+
+// benchmarkSets returns two sets of n /28s each, interleaved inside 10.0.0.0/8.
+func benchmarkSets(n int) (a, b []netip.Prefix) {
+	for i := 0; i < n; i++ {
+		addr := func(j int) netip.Addr { return netip.AddrFrom4([4]byte{10, byte(j >> 12), byte(j >> 4), byte(j << 4)}) }
+		a = append(a, netip.PrefixFrom(addr(3*i), 28))
+		b = append(b, netip.PrefixFrom(addr(3*i+1), 27).Masked())
+	}
+	return a, b
+}
+
+func BenchmarkPrefixSet(b *testing.B) {
+	pa, pb := benchmarkSets(10000)
+	sa, sb := NewPrefixSet(pa...), NewPrefixSet(pb...)
+	b.Run("build", func(b *testing.B) {
+		for b.Loop() {
+			NewPrefixSet(pa...)
+		}
+	})
+	b.Run("union", func(b *testing.B) {
+		for b.Loop() {
+			sa.Union(sb)
+		}
+	})
+	b.Run("intersect", func(b *testing.B) {
+		for b.Loop() {
+			sa.Intersect(sb)
+		}
+	})
+	b.Run("difference", func(b *testing.B) {
+		for b.Loop() {
+			sa.Difference(sb)
+		}
+	})
+	b.Run("prefixes", func(b *testing.B) {
+		for b.Loop() {
+			sa.Difference(sb).Prefixes()
+		}
+	})
+}
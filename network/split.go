@@ -0,0 +1,77 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"sort"
+)
+
+// SplitCIDR splits cidr into parts equal subnets in address order. parts must be a power of two of at least 2.
+func SplitCIDR(cidr string, parts int) ([]string, error) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", cidr)
+	}
+	if parts < 2 || parts&(parts-1) != 0 {
+		return nil, errors.New("parts must be a power of two of at least 2")
+	}
+	p = p.Masked()
+	childBits := p.Bits() + bits.TrailingZeros(uint(parts))
+	if childBits > p.Addr().BitLen() {
+		return nil, fmt.Errorf("%s is too small to split into %d parts", p, parts)
+	}
+	out := make([]string, 0, parts)
+	addr := p.Addr()
+	for i := 0; i < parts; i++ {
+		child := netip.PrefixFrom(addr, childBits)
+		out = append(out, child.String())
+		addr = prefixLastAddr(child).Next()
+	}
+	return out, nil
+}
+
+// MergeCIDRs returns the supernet exactly covered by cidrs: the CIDRs must be non-overlapping, contiguous and
+// together form one aligned prefix (e.g. 10.0.0.0/25 and 10.0.0.128/25 merge to 10.0.0.0/24).
+func MergeCIDRs(cidrs []string) (string, error) {
+	if len(cidrs) < 2 {
+		return "", errors.New("at least two CIDRs are required")
+	}
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return "", fmt.Errorf("invalid CIDR %q", c)
+		}
+		p = p.Masked()
+		if len(prefixes) > 0 && p.Addr().Is4() != prefixes[0].Addr().Is4() {
+			return "", errors.New("cannot merge IPv4 and IPv6 CIDRs")
+		}
+		prefixes = append(prefixes, p)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return prefixes[i].Addr().Less(prefixes[j].Addr()) })
+	for i := 1; i < len(prefixes); i++ {
+		prev, cur := prefixes[i-1], prefixes[i]
+		next := prefixLastAddr(prev).Next()
+		if cur.Addr().Less(next) {
+			return "", fmt.Errorf("%s and %s overlap", prev, cur)
+		}
+		if cur.Addr() != next {
+			return "", fmt.Errorf("%s and %s are not adjacent", prev, cur)
+		}
+	}
+	first, last := prefixes[0].Addr(), prefixLastAddr(prefixes[len(prefixes)-1])
+	// The smallest prefix holding first and last must start at first and end at last to be covered exactly.
+	for b := prefixes[0].Bits(); b >= 0; b-- {
+		super := netip.PrefixFrom(first, b).Masked()
+		if !super.Contains(last) {
+			continue
+		}
+		if super.Addr() != first || prefixLastAddr(super) != last {
+			return "", fmt.Errorf("%s through %s does not form a single CIDR", prefixes[0], prefixes[len(prefixes)-1])
+		}
+		return super.String(), nil
+	}
+	return "", fmt.Errorf("%s through %s does not form a single CIDR", prefixes[0], prefixes[len(prefixes)-1])
+}
@@ -0,0 +1,65 @@
+package network
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCIDR(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidr    string
+		parts   int
+		want    []string
+		wantErr bool
+	}{
+		{name: "halves", cidr: "10.0.0.0/24", parts: 2, want: []string{"10.0.0.0/25", "10.0.0.128/25"}},
+		{name: "quarters", cidr: "10.0.0.0/22", parts: 4, want: []string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24"}},
+		{name: "host bits are masked", cidr: "10.0.0.5/31", parts: 2, want: []string{"10.0.0.4/32", "10.0.0.5/32"}},
+		{name: "ipv6", cidr: "2001:db8::/32", parts: 2, want: []string{"2001:db8::/33", "2001:db8:8000::/33"}},
+		{name: "not a power of two", cidr: "10.0.0.0/24", parts: 3, wantErr: true},
+		{name: "one part", cidr: "10.0.0.0/24", parts: 1, wantErr: true},
+		{name: "too small", cidr: "10.0.0.0/31", parts: 4, wantErr: true},
+		{name: "invalid", cidr: "10.0.0.0/33", parts: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitCIDR(tt.cidr, tt.parts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitCIDR(%q, %d) err = %v, wantErr %v", tt.cidr, tt.parts, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitCIDR(%q, %d) = %v, want %v", tt.cidr, tt.parts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeCIDRs(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidrs   []string
+		want    string
+		wantErr bool
+	}{
+		{name: "halves", cidrs: []string{"10.0.0.128/25", "10.0.0.0/25"}, want: "10.0.0.0/24"},
+		{name: "uneven sizes", cidrs: []string{"10.0.0.0/25", "10.0.0.128/26", "10.0.0.192/26"}, want: "10.0.0.0/24"},
+		{name: "ipv6", cidrs: []string{"2001:db8::/33", "2001:db8:8000::/33"}, want: "2001:db8::/32"},
+		{name: "gap", cidrs: []string{"10.0.0.0/25", "10.0.1.0/25"}, wantErr: true},
+		{name: "adjacent but unaligned", cidrs: []string{"10.0.1.0/24", "10.0.2.0/24"}, wantErr: true},
+		{name: "overlap", cidrs: []string{"10.0.0.0/24", "10.0.0.0/25"}, wantErr: true},
+		{name: "mixed families", cidrs: []string{"10.0.0.0/25", "2001:db8::/33"}, wantErr: true},
+		{name: "single", cidrs: []string{"10.0.0.0/24"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergeCIDRs(tt.cidrs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergeCIDRs(%v) err = %v, wantErr %v", tt.cidrs, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MergeCIDRs(%v) = %q, want %q", tt.cidrs, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package network
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+)
+
+// AllocationStrategy selects where in the free space of a supernet a new CIDR is placed.
+type AllocationStrategy string
+
+const (
+	// StrategyBestFit places the CIDR at the start of the smallest free range it fits in, to reduce fragmentation.
+	StrategyBestFit AllocationStrategy = "best_fit"
+	// StrategyFirstFit places the CIDR at the lowest free address it fits at.
+	StrategyFirstFit AllocationStrategy = "first_fit"
+	// StrategyLastFit places the CIDR at the highest free address it fits at, filling from the top of the range.
+	StrategyLastFit AllocationStrategy = "last_fit"
+	// StrategySparse places the CIDR in the middle of the largest free aligned prefix, leaving growth room on both
+	// sides (a /24 in a free /22 lands on the third /24).
+	StrategySparse AllocationStrategy = "sparse"
+	// StrategyAlignedTo places the CIDR at the lowest free address on a Placement.AlignTo boundary.
+	StrategyAlignedTo AllocationStrategy = "aligned_to"
+)
+
+// ParseAllocationStrategy returns the strategy named s; empty means best fit.
+func ParseAllocationStrategy(s string) (AllocationStrategy, error) {
+	switch st := AllocationStrategy(s); st {
+	case "":
+		return StrategyBestFit, nil
+	case StrategyBestFit, StrategyFirstFit, StrategyLastFit, StrategySparse, StrategyAlignedTo:
+		return st, nil
+	}
+	return "", fmt.Errorf("unknown allocation strategy %q (want best_fit, first_fit, last_fit, sparse or aligned_to)", s)
+}
+
+// Placement configures NextAvailableCIDRWithStrategy. The zero value is best fit.
+type Placement struct {
+	Strategy AllocationStrategy
+	// AlignTo is the prefix length whose boundaries the CIDR must start on (aligned_to only), e.g. 20 to place a /24 on a
+	// /20 boundary. It must be between the supernet prefix and the requested prefix length.
+	AlignTo int
+}
+
+// alignUp returns the first address at or after a that starts a /bits prefix, or an invalid address if none is left.
+func alignUp(a netip.Addr, bits int) netip.Addr {
+	p := netip.PrefixFrom(a, bits).Masked()
+	if p.Addr() == a {
+		return a
+	}
+	return prefixLastAddr(p).Next()
+}
+
+// firstFit returns the lowest /prefixLength in r whose start is on a /align boundary.
+func firstFit(r AddressRange, prefixLength, align int) (netip.Prefix, bool) {
+	start := alignUp(r.First, align)
+	if !start.IsValid() {
+		return netip.Prefix{}, false
+	}
+	p := netip.PrefixFrom(start, prefixLength)
+	if start.Compare(r.Last) > 0 || prefixLastAddr(p).Compare(r.Last) > 0 {
+		return netip.Prefix{}, false
+	}
+	return p, true
+}
+
+// lastFit returns the highest /prefixLength in r.
+func lastFit(r AddressRange, prefixLength int) (netip.Prefix, bool) {
+	p := netip.PrefixFrom(r.Last, prefixLength).Masked()
+	if p.Addr().Less(r.First) {
+		return netip.Prefix{}, false
+	}
+	return p, true
+}
+
+// NextAvailableCIDRWithStrategy returns a CIDR of the given prefix length within supernet that does not overlap
+// allocatedCIDRs, placed according to placement. Ties between equally good candidates go to the lower address, so
+// the result is deterministic for a given input.
+func NextAvailableCIDRWithStrategy(supernet string, prefixLength int, allocatedCIDRs []string, placement Placement) (string, error) {
+	super, err := netip.ParsePrefix(supernet)
+	if err != nil {
+		return "", fmt.Errorf("invalid supernet CIDR: %w", err)
+	}
+	if prefixLength < super.Bits() {
+		return "", fmt.Errorf("prefix length %d must be greater than supernet prefix %d", prefixLength, super.Bits())
+	}
+	if prefixLength > super.Addr().BitLen() {
+		return "", fmt.Errorf("prefix length %d exceeds maximum", prefixLength)
+	}
+	strategy := placement.Strategy
+	if strategy == "" {
+		strategy = StrategyBestFit
+	}
+	align := prefixLength
+	if strategy == StrategyAlignedTo {
+		if placement.AlignTo < super.Bits() || placement.AlignTo > prefixLength {
+			return "", fmt.Errorf("align_to must be between the supernet prefix /%d and the prefix length /%d", super.Bits(), prefixLength)
+		}
+		align = placement.AlignTo
+	}
+
+	free, err := FreeRanges(supernet, allocatedCIDRs)
+	if err != nil {
+		return "", err
+	}
+	if len(free) == 0 {
+		return "", fmt.Errorf("no space left in block")
+	}
+
+	var best netip.Prefix
+	switch strategy {
+	case StrategyBestFit:
+		var bestRange AddressRange
+		for _, r := range free {
+			p, ok := firstFit(r, prefixLength, align)
+			if !ok {
+				continue
+			}
+			if !best.IsValid() || r.Size().Cmp(bestRange.Size()) < 0 {
+				best, bestRange = p, r
+			}
+		}
+	case StrategyFirstFit, StrategyAlignedTo:
+		for _, r := range free {
+			if p, ok := firstFit(r, prefixLength, align); ok {
+				best = p
+				break
+			}
+		}
+	case StrategyLastFit:
+		for i := len(free) - 1; i >= 0; i-- {
+			if p, ok := lastFit(free[i], prefixLength); ok {
+				best = p
+				break
+			}
+		}
+	case StrategySparse:
+		// The largest free aligned prefix gives the most room around the new CIDR; take its middle.
+		var room netip.Prefix
+		for p := range NewRangeSet(free...).All() {
+			if !room.IsValid() || p.Bits() < room.Bits() {
+				room = p
+			}
+		}
+		switch {
+		case room.Bits() > prefixLength:
+		case room.Bits() == prefixLength:
+			best = room
+		default:
+			upper := netip.PrefixFrom(room.Addr(), room.Bits()+1).Masked()
+			best = netip.PrefixFrom(prefixLastAddr(upper).Next(), prefixLength)
+		}
+	}
+	if !best.IsValid() {
+		return "", fmt.Errorf("no available CIDR in block")
+	}
+	return best.String(), nil
+}
+
+// PackError reports the request PackCIDRs could not place.
+type PackError struct {
+	Index, PrefixLength int // the request, by position in the input
+	Placed, Total       int // how many requests were placed before it
+	Err                 error
+}
+
+func (e *PackError) Error() string {
+	return fmt.Sprintf("/%d (request %d) does not fit after placing %d of %d: %v", e.PrefixLength, e.Index, e.Placed, e.Total, e.Err)
+}
+
+func (e *PackError) Unwrap() error { return e.Err }
+
+// PackCIDRs places one CIDR per entry of prefixLengths within supernet without overlapping allocatedCIDRs or each
+// other, and returns them in input order. Larger requests are placed first (ties in input order), which keeps
+// power-of-two blocks from fragmenting the space the later, smaller ones need. Nothing is returned unless every
+// request fits.
+func PackCIDRs(supernet string, prefixLengths []int, allocatedCIDRs []string, placement Placement) ([]string, error) {
+	order := make([]int, len(prefixLengths))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return prefixLengths[order[a]] < prefixLengths[order[b]] })
+
+	used := append([]string(nil), allocatedCIDRs...)
+	out := make([]string, len(prefixLengths))
+	for placed, i := range order {
+		cidr, err := NextAvailableCIDRWithStrategy(supernet, prefixLengths[i], used, placement)
+		if err != nil {
+			return nil, &PackError{Index: i, PrefixLength: prefixLengths[i], Placed: placed, Total: len(prefixLengths), Err: err}
+		}
+		out[i] = cidr
+		used = append(used, cidr)
+	}
+	return out, nil
+}
@@ -0,0 +1,107 @@
+package network
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNextAvailableCIDRWithStrategy(t *testing.T) {
+	// Free space in 10.0.0.0/20: 10.0.1.0-10.0.2.255, 10.0.4.0/24 and 10.0.8.0/21.
+	allocated := []string{"10.0.0.0/24", "10.0.3.0/24", "10.0.5.0/24", "10.0.6.0/23"}
+	tests := []struct {
+		name      string
+		supernet  string
+		prefix    int
+		allocated []string
+		placement Placement
+		want      string
+		wantErr   string
+	}{
+		{"zero value is best fit", "10.0.0.0/20", 24, allocated, Placement{}, "10.0.4.0/24", ""},
+		{"best fit", "10.0.0.0/20", 24, allocated, Placement{Strategy: StrategyBestFit}, "10.0.4.0/24", ""},
+		{"first fit", "10.0.0.0/20", 24, allocated, Placement{Strategy: StrategyFirstFit}, "10.0.1.0/24", ""},
+		{"last fit", "10.0.0.0/20", 24, allocated, Placement{Strategy: StrategyLastFit}, "10.0.15.0/24", ""},
+		{"sparse", "10.0.0.0/20", 24, allocated, Placement{Strategy: StrategySparse}, "10.0.12.0/24", ""},
+		{"aligned to /23", "10.0.0.0/20", 24, allocated, Placement{Strategy: StrategyAlignedTo, AlignTo: 23}, "10.0.2.0/24", ""},
+		{"aligned to /21", "10.0.0.0/20", 24, allocated, Placement{Strategy: StrategyAlignedTo, AlignTo: 21}, "10.0.8.0/24", ""},
+		{"aligned to own size is first fit", "10.0.0.0/20", 24, allocated, Placement{Strategy: StrategyAlignedTo, AlignTo: 24}, "10.0.1.0/24", ""},
+		{"best fit skips misaligned gap", "10.0.0.0/20", 23, allocated, Placement{Strategy: StrategyBestFit}, "10.0.8.0/23", ""},
+		{"first fit skips misaligned gap", "10.0.0.0/20", 23, allocated, Placement{Strategy: StrategyFirstFit}, "10.0.8.0/23", ""},
+		{"last fit /23", "10.0.0.0/20", 23, allocated, Placement{Strategy: StrategyLastFit}, "10.0.14.0/23", ""},
+		{"sparse /23", "10.0.0.0/20", 23, allocated, Placement{Strategy: StrategySparse}, "10.0.12.0/23", ""},
+		{"sparse fills exact room", "10.0.0.0/20", 21, allocated, Placement{Strategy: StrategySparse}, "10.0.8.0/21", ""},
+		{"sparse empty supernet", "10.0.0.0/22", 24, nil, Placement{Strategy: StrategySparse}, "10.0.2.0/24", ""},
+		{"last fit empty supernet", "10.0.0.0/22", 24, nil, Placement{Strategy: StrategyLastFit}, "10.0.3.0/24", ""},
+		{"ipv6 last fit", "2001:db8::/48", 64, []string{"2001:db8::/64"}, Placement{Strategy: StrategyLastFit}, "2001:db8:0:ffff::/64", ""},
+		{"ipv6 sparse", "2001:db8::/48", 64, []string{"2001:db8::/64"}, Placement{Strategy: StrategySparse}, "2001:db8:0:c000::/64", ""},
+		{"nothing fits", "10.0.0.0/20", 20, allocated, Placement{Strategy: StrategySparse}, "", "no available CIDR"},
+		{"full", "10.0.0.0/24", 26, []string{"10.0.0.0/24"}, Placement{Strategy: StrategyLastFit}, "", "no space left"},
+		{"align coarser than supernet", "10.0.0.0/20", 24, allocated, Placement{Strategy: StrategyAlignedTo, AlignTo: 16}, "", "align_to"},
+		{"align finer than prefix", "10.0.0.0/20", 24, allocated, Placement{Strategy: StrategyAlignedTo, AlignTo: 26}, "", "align_to"},
+		{"prefix shorter than supernet", "10.0.0.0/20", 16, nil, Placement{}, "", "must be greater"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextAvailableCIDRWithStrategy(tt.supernet, tt.prefix, tt.allocated, tt.placement)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("NextAvailableCIDRWithStrategy = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAllocationStrategy(t *testing.T) {
+	if st, err := ParseAllocationStrategy(""); err != nil || st != StrategyBestFit {
+		t.Errorf(`"" = %q, %v`, st, err)
+	}
+	if st, err := ParseAllocationStrategy("sparse"); err != nil || st != StrategySparse {
+		t.Errorf("sparse = %q, %v", st, err)
+	}
+	if _, err := ParseAllocationStrategy("worst_fit"); err == nil {
+		t.Error("worst_fit: want error")
+	}
+}
+
+func TestPackCIDRs(t *testing.T) {
+	// Three /24 public, three /22 private and two /26 data subnets: the /22s take the bottom of the /20 and the smaller
+	// subnets share the last /22, results in request order.
+	got, err := PackCIDRs("10.0.0.0/20", []int{24, 24, 24, 22, 22, 22, 26, 26}, nil, Placement{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.12.0/24", "10.0.13.0/24", "10.0.14.0/24", "10.0.0.0/22", "10.0.4.0/22", "10.0.8.0/22", "10.0.15.0/26", "10.0.15.64/26"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PackCIDRs = %v, want %v", got, want)
+	}
+
+	// Placed one at a time, sparse leaves no aligned /23 for the last request; packed largest first, everything fits.
+	var used []string
+	for _, prefix := range []int{24, 24} {
+		cidr, err := NextAvailableCIDRWithStrategy("10.0.0.0/22", prefix, used, Placement{Strategy: StrategySparse})
+		if err != nil {
+			t.Fatal(err)
+		}
+		used = append(used, cidr)
+	}
+	if _, err := NextAvailableCIDRWithStrategy("10.0.0.0/22", 23, used, Placement{Strategy: StrategySparse}); err == nil {
+		t.Fatalf("sequential placement of %v left room for a /23", used)
+	}
+	got, err = PackCIDRs("10.0.0.0/22", []int{24, 24, 23}, nil, Placement{Strategy: StrategySparse})
+	if err != nil || !reflect.DeepEqual(got, []string{"10.0.1.0/24", "10.0.0.0/24", "10.0.2.0/23"}) {
+		t.Errorf("sparse PackCIDRs = %v, %v", got, err)
+	}
+
+	_, err = PackCIDRs("10.0.0.0/22", []int{26, 23, 23}, []string{"10.0.3.0/24"}, Placement{})
+	var packErr *PackError
+	if !errors.As(err, &packErr) || packErr.Index != 2 || packErr.Placed != 1 || packErr.Total != 3 {
+		t.Fatalf("err = %#v", err)
+	}
+}
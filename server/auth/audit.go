@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/JakeNeyer/ipam/internal/logger"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+)
+
+// Actor types recorded in audit log entries.
+const (
+	ActorUser           = "user"
+	ActorServiceAccount = "service_account"
+)
+
+// statusRecorder captures the status code written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// audited reports whether requests with method change state and are written to the audit log.
+func audited(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+// logAudit writes an audit log entry for an authenticated request that changes state. Service accounts are
+// recorded as such, by ID and name, so automation is never mistaken for the admin who created the account.
+func logAudit(r *http.Request, user *store.User, sa *store.ServiceAccount, status int) {
+	actorType, actorID, actor, orgID := ActorUser, user.ID, user.Email, user.OrganizationID
+	if sa != nil {
+		actorType, actorID, actor, orgID = ActorServiceAccount, sa.ID, sa.Name, sa.OrganizationID
+	}
+	args := []any{
+		logger.KeyActorType, actorType,
+		logger.KeyActorID, actorID.String(),
+		logger.KeyActor, actor,
+		logger.KeyMethod, r.Method,
+		logger.KeyPath, r.URL.Path,
+		logger.KeyStatus, status,
+	}
+	if orgID != uuid.Nil {
+		args = append(args, logger.KeyOrganizationID, orgID.String())
+	}
+	logger.Info(logger.MsgAudit, args...)
+}
@@ -13,6 +13,9 @@ type contextKey string
 const userContextKey contextKey = "user"
 const requestContextKey contextKey = "request"
 const effectiveOrgContextKey contextKey = "effective_organization"
+const sessionContextKey contextKey = "session"
+const serviceAccountContextKey contextKey = "service_account"
+const apiTokenContextKey contextKey = "api_token"
 
 // WithUser returns a context with the user attached.
 func WithUser(ctx context.Context, user *store.User) context.Context {
@@ -34,6 +37,51 @@ func UserIDFromContext(ctx context.Context) uuid.UUID {
 	return u.ID
 }
 
+// WithSessionID records that the request was authenticated with the session sessionID (not an API token).
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionContextKey, sessionID)
+}
+
+// SessionIDFromContext returns the session the request was authenticated with, or "" for API tokens.
+func SessionIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(sessionContextKey).(string)
+	return id
+}
+
+// WithAPITokenID records that the request was authenticated with the API token tokenID.
+func WithAPITokenID(ctx context.Context, tokenID uuid.UUID) context.Context {
+	return context.WithValue(ctx, apiTokenContextKey, tokenID)
+}
+
+// APITokenIDFromContext returns the API token the request was authenticated with, or uuid.Nil for sessions.
+func APITokenIDFromContext(ctx context.Context) uuid.UUID {
+	id, _ := ctx.Value(apiTokenContextKey).(uuid.UUID)
+	return id
+}
+
+// WithServiceAccount records that the request was authenticated with a token of the service account sa. The
+// context's user is then the account's principal (see ServiceAccountPrincipal), not a real user.
+func WithServiceAccount(ctx context.Context, sa *store.ServiceAccount) context.Context {
+	return context.WithValue(ctx, serviceAccountContextKey, sa)
+}
+
+// ServiceAccountFromContext returns the service account the request was authenticated as, or nil for users.
+func ServiceAccountFromContext(ctx context.Context) *store.ServiceAccount {
+	sa, _ := ctx.Value(serviceAccountContextKey).(*store.ServiceAccount)
+	return sa
+}
+
+// ServiceAccountPrincipal returns the user handlers see for requests made with sa's tokens: it has sa's ID, role and
+// organization, the account name in place of an email, and no password, so it can never sign in or be global admin.
+func ServiceAccountPrincipal(sa *store.ServiceAccount) *store.User {
+	return &store.User{
+		ID:             sa.ID,
+		Email:          sa.Name,
+		Role:           sa.Role,
+		OrganizationID: sa.OrganizationID,
+	}
+}
+
 // WithEffectiveOrganization sets the effective organization for this request (e.g. from an org-scoped API token).
 // When set, the request is limited to that org even if the user is global admin.
 func WithEffectiveOrganization(ctx context.Context, orgID uuid.UUID) context.Context {
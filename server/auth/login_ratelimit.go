@@ -3,6 +3,7 @@ package auth
 import (
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 	"sync"
 	"time"
@@ -21,6 +22,7 @@ type LoginAttemptLimiter struct {
 	attempts map[string]*attemptEntry
 	max      int
 	window   time.Duration
+	proxies  []netip.Prefix // peers whose X-Forwarded-For is believed by ClientIP
 }
 
 type attemptEntry struct {
@@ -30,7 +32,8 @@ type attemptEntry struct {
 
 // NewLoginAttemptLimiter returns a limiter that blocks an IP after maxAttempts
 // failed logins within the given window. Pass 0 for max or window to use defaults.
-func NewLoginAttemptLimiter(maxAttempts int, window time.Duration) *LoginAttemptLimiter {
+// trustedProxies are the reverse proxies (RATE_LIMIT_TRUSTED_PROXIES) ClientIP believes.
+func NewLoginAttemptLimiter(maxAttempts int, window time.Duration, trustedProxies []netip.Prefix) *LoginAttemptLimiter {
 	if maxAttempts <= 0 {
 		maxAttempts = DefaultLoginMaxAttempts
 	}
@@ -41,10 +44,25 @@ func NewLoginAttemptLimiter(maxAttempts int, window time.Duration) *LoginAttempt
 		attempts: make(map[string]*attemptEntry),
 		max:      maxAttempts,
 		window:   window,
+		proxies:  trustedProxies,
 	}
 }
 
-// ClientIP returns the client IP from the request (X-Forwarded-For or RemoteAddr).
+// ClientIP returns the IP that r counts against: the peer address, or for requests from a trusted proxy the nearest
+// X-Forwarded-For address that is not one. A nil limiter trusts no proxy.
+func (l *LoginAttemptLimiter) ClientIP(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	var proxies []netip.Prefix
+	if l != nil {
+		proxies = l.proxies
+	}
+	return clientIPBehind(r, proxies)
+}
+
+// ClientIP returns the client IP from the request (X-Forwarded-For or RemoteAddr). Any client can set the header,
+// so it is only fit for display, such as the IP shown on a session; limiters use LoginAttemptLimiter.ClientIP.
 func ClientIP(r *http.Request) string {
 	if r == nil {
 		return ""
@@ -8,25 +8,26 @@ import (
 	"strings"
 	"time"
 
+	"github.com/JakeNeyer/ipam/server/config"
 	"github.com/JakeNeyer/ipam/store"
 	"github.com/google/uuid"
 )
 
-const (
-	SessionCookieName = "ipam_session"
-	SessionDuration   = 24 * time.Hour
-)
+const SessionCookieName = "ipam_session"
 
 // Middleware returns a middleware that requires a valid session or API key for /api/* except login and logout.
-func Middleware(s store.Storer) func(http.Handler) http.Handler {
+// Sessions unused for longer than sessions.IdleTimeout are deleted. Requests that change state are written to the
+// audit log with the user or service account that made them.
+func Middleware(s store.Storer, sessions config.SessionConfig) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			path := r.URL.Path
-			if path == "/api/auth/login" || path == "/api/auth/logout" || path == "/api/auth/config" ||
+			if path == "/api/auth/login" || path == "/api/auth/login/mfa" || path == "/api/auth/logout" || path == "/api/auth/config" ||
+				path == "/api/auth/password-reset" || path == "/api/auth/password-reset/confirm" ||
 				path == "/api/setup/status" || path == "/api/setup" ||
 				path == "/api/signup/validate" || path == "/api/signup/register" ||
 				strings.HasPrefix(path, "/api/signup/") ||
-				strings.HasPrefix(path, "/api/auth/oauth/") {
+				strings.HasPrefix(path, "/api/auth/oauth/") || strings.HasPrefix(path, "/api/auth/saml/") {
 				ctx := WithRequest(r.Context(), r)
 				next.ServeHTTP(w, r.WithContext(ctx))
 				return
@@ -37,28 +38,43 @@ func Middleware(s store.Storer) func(http.Handler) http.Handler {
 			}
 
 			var user *store.User
+			var sessionID string
 
 			if cookie, err := r.Cookie(SessionCookieName); err == nil && cookie != nil && cookie.Value != "" {
 				if sess, err := s.GetSession(cookie.Value); err == nil {
-					if u, err := s.GetUser(sess.UserID); err == nil {
+					now := time.Now()
+					if sessionIdle(sess, sessions.IdleTimeout, now) {
+						_ = s.DeleteSession(cookie.Value) // idle either way; a leftover row expires on its own
+					} else if u, err := s.GetUser(sess.UserID); err == nil && !u.Disabled {
 						user = u
+						sessionID = cookie.Value
+						touchSession(s, sess, r, now)
 					}
 				}
 			}
 
-			var effectiveOrg uuid.UUID
+			var effectiveOrg, tokenID uuid.UUID
+			var serviceAccount *store.ServiceAccount
 			if user == nil {
 				if bearer := r.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
 					rawToken := strings.TrimSpace(strings.TrimPrefix(bearer, "Bearer "))
 					if rawToken != "" {
 						keyHash := hashToken(rawToken)
 						if tok, err := s.GetAPITokenByKeyHash(keyHash); err == nil {
-							if u, err := s.GetUser(tok.UserID); err == nil {
+							if tok.ServiceAccountID != uuid.Nil {
+								if sa, err := s.GetServiceAccount(tok.ServiceAccountID); err == nil && !sa.Disabled {
+									serviceAccount = sa
+									user = ServiceAccountPrincipal(sa)
+								}
+							} else if u, err := s.GetUser(tok.UserID); err == nil && !u.Disabled {
 								user = u
 								if tok.OrganizationID != uuid.Nil {
 									effectiveOrg = tok.OrganizationID
 								}
 							}
+							if user != nil {
+								tokenID = tok.ID
+							}
 						}
 					}
 				}
@@ -68,16 +84,57 @@ func Middleware(s store.Storer) func(http.Handler) http.Handler {
 				WriteJSONError(w, "unauthorized", http.StatusUnauthorized)
 				return
 			}
+			if serviceAccount != nil && (strings.HasPrefix(path, "/api/auth/") || strings.HasPrefix(path, "/api/admin/")) {
+				// Service accounts have no account settings and cannot manage users, organizations or other service accounts.
+				WriteJSONError(w, "not available to service accounts", http.StatusForbidden)
+				return
+			}
+			// Enrollment needs a browser session; until then the user's API tokens are refused everywhere.
+			if serviceAccount == nil && (sessionID == "" || !mfaEnrollmentPath(path)) && MFAEnrollmentRequired(s, user) {
+				WriteJSONError(w, "mfa enrollment required", http.StatusForbidden)
+				return
+			}
 			ctx := WithRequest(r.Context(), r)
 			ctx = WithUser(ctx, user)
+			if sessionID != "" {
+				ctx = WithSessionID(ctx, sessionID)
+			}
 			if effectiveOrg != uuid.Nil {
 				ctx = WithEffectiveOrganization(ctx, effectiveOrg)
 			}
+			if tokenID != uuid.Nil {
+				ctx = WithAPITokenID(ctx, tokenID)
+			}
+			if serviceAccount != nil {
+				ctx = WithServiceAccount(ctx, serviceAccount)
+			}
+			if audited(r.Method) {
+				rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+				next.ServeHTTP(rec, r.WithContext(ctx))
+				logAudit(r, user, serviceAccount, rec.status)
+				return
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// MFAEnrollmentRequired reports whether u must enroll TOTP before using the API, with a session or an API token: u
+// has a local password, has not enrolled, and belongs to an organization that requires MFA. Accounts without a
+// password rely on their identity provider's MFA.
+func MFAEnrollmentRequired(s store.Storer, u *store.User) bool {
+	if u == nil || u.TOTPEnabled || u.PasswordHash == "" || u.OrganizationID == uuid.Nil {
+		return false
+	}
+	org, err := s.GetOrganization(u.OrganizationID)
+	return err == nil && org.RequireMFA
+}
+
+// mfaEnrollmentPath reports whether path stays reachable for users who still have to enroll MFA.
+func mfaEnrollmentPath(path string) bool {
+	return path == "/api/auth/me" || path == "/api/auth/me/mfa" || strings.HasPrefix(path, "/api/auth/me/mfa/")
+}
+
 func hashToken(raw string) string {
 	h := sha256.Sum256([]byte(raw))
 	return hex.EncodeToString(h[:])
@@ -91,13 +148,13 @@ func WriteJSONError(w http.ResponseWriter, msg string, code int) {
 }
 
 // SetSessionCookie sets the session cookie on the response. secure should be true when using HTTPS.
-func SetSessionCookie(w http.ResponseWriter, sessionID string, secure bool) {
+func SetSessionCookie(w http.ResponseWriter, sessionID string, maxAge time.Duration, secure bool) {
 	// #nosec G124 -- Secure is intentionally conditional to support local HTTP development.
 	c := &http.Cookie{
 		Name:     SessionCookieName,
 		Value:    sessionID,
 		Path:     "/",
-		MaxAge:   int(SessionDuration.Seconds()),
+		MaxAge:   int(maxAge.Seconds()),
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
 		Secure:   secure,
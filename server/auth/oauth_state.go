@@ -14,10 +14,14 @@ const (
 )
 
 // OAuthStatePayload is stored in the OAuth state cookie during the authorize redirect.
+// Nonce is the state parameter. CodeVerifier is the PKCE verifier for the token exchange; IDTokenNonce is sent as
+// the OIDC nonce and must come back in the ID token.
 type OAuthStatePayload struct {
-	Nonce       string `json:"nonce"`
-	Provider    string `json:"provider"`
-	InviteToken string `json:"invite_token,omitempty"`
+	Nonce        string `json:"nonce"`
+	Provider     string `json:"provider"`
+	InviteToken  string `json:"invite_token,omitempty"`
+	CodeVerifier string `json:"code_verifier,omitempty"`
+	IDTokenNonce string `json:"id_token_nonce,omitempty"`
 }
 
 // NewOAuthStateNonce returns a URL-safe random nonce for OAuth state.
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JakeNeyer/ipam/internal/logger"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+)
+
+// RouteClass groups API routes that share a rate limit.
+type RouteClass string
+
+const (
+	RouteRead  RouteClass = "read"
+	RouteWrite RouteClass = "write"
+	RouteSync  RouteClass = "sync"
+)
+
+// rateLimitPruneInterval is how often idle buckets are deleted.
+const rateLimitPruneInterval = time.Minute
+
+// RouteClassOf returns the rate limit class of r: starting a cloud sync is sync, other methods than GET, HEAD and
+// OPTIONS are writes, and the rest are reads.
+func RouteClassOf(r *http.Request) RouteClass {
+	if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/api/integrations/") && strings.HasSuffix(r.URL.Path, "/sync") {
+		return RouteSync
+	}
+	if audited(r.Method) {
+		return RouteWrite
+	}
+	return RouteRead
+}
+
+// RateLimiter limits API requests with a token bucket per caller and route class. Callers are API tokens, signed-in
+// users, or the client IP for requests made before sign-in.
+type RateLimiter struct {
+	buckets store.RateLimitStore
+	limits  map[RouteClass]config.RateLimit
+	idle    time.Duration  // after this long unused every bucket is full again
+	proxies []netip.Prefix // peers whose X-Forwarded-For is believed
+
+	pruneMu   sync.Mutex
+	lastPrune time.Time
+}
+
+// NewRateLimiter returns a limiter for cfg, or nil when rate limiting is off. With cfg.Shared buckets are kept in s
+// (shared between replicas for the Postgres store); otherwise they are kept in this process.
+func NewRateLimiter(cfg config.RateLimitConfig, s store.RateLimitStore) *RateLimiter {
+	if !cfg.Enabled {
+		return nil
+	}
+	l := &RateLimiter{limits: make(map[RouteClass]config.RateLimit), proxies: cfg.TrustedProxies}
+	for class, limit := range map[RouteClass]config.RateLimit{RouteRead: cfg.Read, RouteWrite: cfg.Write, RouteSync: cfg.Sync} {
+		if !limit.Limited() {
+			continue
+		}
+		limit = limit.WithDefaults()
+		l.limits[class] = limit
+		if refill := time.Duration(float64(limit.Burst) / perSecond(limit) * float64(time.Second)); refill > l.idle {
+			l.idle = refill
+		}
+	}
+	if len(l.limits) == 0 {
+		return nil
+	}
+	l.idle += time.Minute
+	if cfg.Shared {
+		l.buckets = s
+	} else {
+		l.buckets = store.NewTokenBuckets()
+	}
+	return l
+}
+
+func perSecond(limit config.RateLimit) float64 {
+	return float64(limit.PerMinute) / 60
+}
+
+// key returns the caller r is limited as: its API token, its signed-in user, or its client IP.
+func (l *RateLimiter) key(r *http.Request) string {
+	ctx := r.Context()
+	if id := APITokenIDFromContext(ctx); id != uuid.Nil {
+		return "token:" + id.String()
+	}
+	if u := UserFromContext(ctx); u != nil {
+		return "user:" + u.ID.String()
+	}
+	return "ip:" + l.clientIP(r)
+}
+
+func (l *RateLimiter) clientIP(r *http.Request) string {
+	return clientIPBehind(r, l.proxies)
+}
+
+// clientIPBehind returns the peer address of r. When the peer is one of proxies it returns the nearest
+// X-Forwarded-For address that is not one instead; any client can set the header, so it is ignored from other peers.
+func clientIPBehind(r *http.Request, proxies []netip.Prefix) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !trustedProxy(proxies, addr) {
+		return peer
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop
+		if !trustedProxy(proxies, hop) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+func trustedProxy(proxies []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitMiddleware answers API requests over their limit with 429 Too Many Requests and a Retry-After header.
+// Limited responses and those let through carry X-RateLimit-Limit (the burst), X-RateLimit-Remaining and
+// X-RateLimit-Reset (seconds until the bucket is full). It must run inside Middleware, which identifies the caller.
+// When the bucket store fails, requests are let through.
+func RateLimitMiddleware(l *RateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasPrefix(r.URL.Path, "/api/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			class := RouteClassOf(r)
+			limit, ok := l.limits[class]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			l.maybePrune()
+			rate := perSecond(limit)
+			allowed, tokens, err := l.buckets.TakeRateLimitToken(string(class)+":"+l.key(r), rate, limit.Burst)
+			if err != nil {
+				logger.Error(logger.MsgStoreError, logger.KeyOperation, "rate limit", logger.ErrAttr(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+			h.Set("X-RateLimit-Remaining", strconv.Itoa(int(math.Max(0, math.Floor(tokens)))))
+			h.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil((float64(limit.Burst)-tokens)/rate))))
+			if !allowed {
+				h.Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil((1-tokens)/rate)))))
+				WriteJSONError(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// maybePrune deletes idle buckets in the background at most once per rateLimitPruneInterval.
+func (l *RateLimiter) maybePrune() {
+	l.pruneMu.Lock()
+	defer l.pruneMu.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastPrune) < rateLimitPruneInterval {
+		return
+	}
+	l.lastPrune = now
+	go func() {
+		if err := l.buckets.DeleteIdleRateLimitBuckets(l.idle); err != nil {
+			logger.Error(logger.MsgStoreError, logger.KeyOperation, "rate limit prune", logger.ErrAttr(err))
+		}
+	}()
+}
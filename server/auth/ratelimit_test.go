@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+)
+
+func TestRouteClassOf(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         RouteClass
+	}{
+		{http.MethodGet, "/api/blocks", RouteRead},
+		{http.MethodHead, "/api/blocks", RouteRead},
+		{http.MethodPost, "/api/allocations/auto", RouteWrite},
+		{http.MethodDelete, "/api/blocks/x", RouteWrite},
+		{http.MethodPost, "/api/integrations/x/sync", RouteSync},
+		{http.MethodGet, "/api/integrations/x/sync", RouteRead},
+	}
+	for _, tt := range tests {
+		if got := RouteClassOf(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("RouteClassOf(%s %s) = %s, want %s", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNewRateLimiter_Disabled(t *testing.T) {
+	s := store.NewStore()
+	if NewRateLimiter(config.RateLimitConfig{Read: config.DefaultReadRateLimit}, s) != nil {
+		t.Error("limiter without Enabled")
+	}
+	if NewRateLimiter(config.RateLimitConfig{Enabled: true}, s) != nil {
+		t.Error("limiter without limited classes")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	s := store.NewStore()
+	alice := &store.User{ID: uuid.New(), Email: "alice@example.org", Role: store.RoleUser, OrganizationID: uuid.New()}
+	if err := s.CreateUser(alice); err != nil {
+		t.Fatal(err)
+	}
+	_, tokenA, err := s.CreateAPIToken(alice.ID, "a", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tokenB, err := s.CreateAPIToken(alice.ID, "b", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.CreateSession("sess", alice.ID, time.Now().Add(time.Hour))
+
+	limiter := NewRateLimiter(config.RateLimitConfig{
+		Enabled: true,
+		Read:    config.RateLimit{PerMinute: 1, Burst: 2},
+		Write:   config.RateLimit{PerMinute: 1, Burst: 1},
+	}, s)
+	handler := Middleware(s, config.SessionConfig{})(RateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	do := func(method, path string, prepare func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if prepare != nil {
+			prepare(req)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	bearer := func(raw string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+raw) }
+	}
+	session := func(r *http.Request) { r.AddCookie(&http.Cookie{Name: SessionCookieName, Value: "sess"}) }
+
+	for i, wantRemaining := range []string{"1", "0"} {
+		rec := do(http.MethodGet, "/api/blocks", bearer(tokenA))
+		if rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "2" || rec.Header().Get("X-RateLimit-Remaining") != wantRemaining {
+			t.Fatalf("read %d: %d %v", i, rec.Code, rec.Header())
+		}
+	}
+	rec := do(http.MethodGet, "/api/blocks", bearer(tokenA))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over limit: %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Reset"); got != "120" {
+		t.Errorf("X-RateLimit-Reset = %q, want 120", got)
+	}
+
+	// Each token, the session user and each route class have their own bucket.
+	if rec := do(http.MethodGet, "/api/blocks", bearer(tokenB)); rec.Code != http.StatusOK {
+		t.Errorf("other token: %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/blocks", session); rec.Code != http.StatusOK {
+		t.Errorf("session: %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/blocks", bearer(tokenA)); rec.Code != http.StatusOK {
+		t.Errorf("write: %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/blocks", bearer(tokenA)); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second write: %d", rec.Code)
+	}
+	// Sync is not limited in this configuration.
+	for i := 0; i < 3; i++ {
+		if rec := do(http.MethodPost, "/api/integrations/x/sync", bearer(tokenA)); rec.Code != http.StatusOK || rec.Header().Get("X-RateLimit-Limit") != "" {
+			t.Fatalf("sync %d: %d %v", i, rec.Code, rec.Header())
+		}
+	}
+
+	// Requests before sign-in are limited by client IP.
+	for i := 0; i < 2; i++ {
+		if rec := do(http.MethodGet, "/api/auth/config", nil); rec.Code != http.StatusOK {
+			t.Fatalf("public %d: %d", i, rec.Code)
+		}
+	}
+	if rec := do(http.MethodGet, "/api/auth/config", nil); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("public over limit: %d", rec.Code)
+	}
+	// X-Forwarded-For from a peer that is not a trusted proxy does not get a new bucket.
+	if rec := do(http.MethodGet, "/api/auth/config", func(r *http.Request) { r.Header.Set("X-Forwarded-For", "198.51.100.9") }); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For: %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/auth/config", func(r *http.Request) { r.RemoteAddr = "192.0.2.2:1234" }); rec.Code != http.StatusOK {
+		t.Errorf("other IP: %d", rec.Code)
+	}
+	// Non-API paths are never limited.
+	for i := 0; i < 3; i++ {
+		if rec := do(http.MethodGet, "/", nil); rec.Code != http.StatusOK {
+			t.Fatalf("static %d: %d", i, rec.Code)
+		}
+	}
+}
+
+func TestRateLimiter_ClientIP(t *testing.T) {
+	l := NewRateLimiter(config.RateLimitConfig{
+		Enabled:        true,
+		Read:           config.DefaultReadRateLimit,
+		TrustedProxies: config.ParsePrefixes("10.0.0.0/8,2001:db8::1"),
+	}, store.NewStore())
+	tests := []struct {
+		remoteAddr, xff, want string
+	}{
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "198.51.100.9", "192.0.2.1"},
+		{"10.0.0.5:1234", "", "10.0.0.5"},
+		{"10.0.0.5:1234", "198.51.100.9", "198.51.100.9"},
+		// The client can prepend anything; only hops added by trusted proxies count.
+		{"10.0.0.5:1234", "203.0.113.1, 198.51.100.9, 10.1.2.3", "198.51.100.9"},
+		{"10.0.0.5:1234", "10.1.2.3", "10.1.2.3"},
+		{"10.0.0.5:1234", "garbage, 198.51.100.9", "198.51.100.9"},
+		{"[2001:db8::1]:443", "2001:db8::99", "2001:db8::99"},
+		{"[::ffff:10.0.0.5]:1234", "198.51.100.9", "198.51.100.9"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/config", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if got := l.clientIP(req); got != tt.want {
+			t.Errorf("clientIP(%s, X-Forwarded-For %q) = %s, want %s", tt.remoteAddr, tt.xff, got, tt.want)
+		}
+	}
+}
+
+func TestLoginAttemptLimiter_ClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	var none *LoginAttemptLimiter
+	if got := none.ClientIP(req); got != "10.0.0.5" {
+		t.Errorf("nil limiter: ClientIP = %s, want the peer", got)
+	}
+	if got := NewLoginAttemptLimiter(0, 0, nil).ClientIP(req); got != "10.0.0.5" {
+		t.Errorf("no trusted proxies: ClientIP = %s, want the peer", got)
+	}
+	if got := NewLoginAttemptLimiter(0, 0, config.ParsePrefixes("10.0.0.0/8")).ClientIP(req); got != "198.51.100.9" {
+		t.Errorf("trusted proxy: ClientIP = %s, want the forwarded client", got)
+	}
+}
+
+func TestTokenBuckets_Refill(t *testing.T) {
+	b := store.NewTokenBuckets()
+	if ok, _, _ := b.TakeRateLimitToken("k", 1000, 1); !ok {
+		t.Fatal("first take refused")
+	}
+	if ok, _, _ := b.TakeRateLimitToken("k", 1000, 1); ok {
+		t.Fatal("second take allowed")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if ok, _, _ := b.TakeRateLimitToken("k", 1000, 1); !ok {
+		t.Error("take after refill refused")
+	}
+	if err := b.DeleteIdleRateLimitBuckets(0); err != nil {
+		t.Fatal(err)
+	}
+}
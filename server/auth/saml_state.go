@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+)
+
+const SAMLStateCookieName = "ipam_saml_state"
+
+// SAMLStatePayload is stored in the SAML state cookie between the AuthnRequest redirect and the ACS post. RelayState
+// must come back with the response, and the assertion must be InResponseTo RequestID.
+type SAMLStatePayload struct {
+	RelayState  string `json:"relay_state"`
+	RequestID   string `json:"request_id"`
+	Provider    string `json:"provider"`
+	InviteToken string `json:"invite_token,omitempty"`
+}
+
+// samlStateSameSite returns the SameSite mode of the SAML state cookie. The IdP posts the response cross-site, which
+// only SameSite=None cookies survive, and browsers accept those only when Secure. Plain-HTTP setups fall back to Lax
+// and work when the IdP is same-site (e.g. both on localhost).
+func samlStateSameSite(secure bool) http.SameSite {
+	if secure {
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+// SetSAMLStateCookie stores the SAML request state.
+func SetSAMLStateCookie(w http.ResponseWriter, payload SAMLStatePayload, secure bool) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	// #nosec G124 -- Secure matches session cookie behavior for local HTTP vs HTTPS.
+	http.SetCookie(w, &http.Cookie{
+		Name:     SAMLStateCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(data),
+		Path:     "/api/auth/saml",
+		MaxAge:   int(oAuthStateDuration.Seconds()),
+		HttpOnly: true,
+		SameSite: samlStateSameSite(secure),
+		Secure:   secure,
+	})
+	return nil
+}
+
+// SAMLStateFromRequest reads and validates the SAML state cookie.
+func SAMLStateFromRequest(r *http.Request) (SAMLStatePayload, bool) {
+	c, err := r.Cookie(SAMLStateCookieName)
+	if err != nil || c.Value == "" {
+		return SAMLStatePayload{}, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil {
+		return SAMLStatePayload{}, false
+	}
+	var p SAMLStatePayload
+	if err := json.Unmarshal(raw, &p); err != nil || p.RelayState == "" || p.RequestID == "" || p.Provider == "" {
+		return SAMLStatePayload{}, false
+	}
+	return p, true
+}
+
+// ClearSAMLStateCookie removes the SAML state cookie after the ACS post.
+func ClearSAMLStateCookie(w http.ResponseWriter, secure bool) {
+	// #nosec G124 -- Secure must match the cookie originally set.
+	http.SetCookie(w, &http.Cookie{
+		Name:     SAMLStateCookieName,
+		Value:    "",
+		Path:     "/api/auth/saml",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: samlStateSameSite(secure),
+		Secure:   secure,
+	})
+}
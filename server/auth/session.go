@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+)
+
+// sessionTouchInterval limits how often a session's last-seen time is written; idle timeouts are accurate to it.
+const sessionTouchInterval = time.Minute
+
+// StartSession stores a new session for userID, records the client that opened it and sets the session cookie.
+func StartSession(w http.ResponseWriter, r *http.Request, s store.Storer, userID uuid.UUID, timeouts config.SessionConfig, secure bool) {
+	timeouts = timeouts.WithDefaults()
+	sessionID := NewSessionID()
+	now := time.Now()
+	s.CreateSession(sessionID, userID, now.Add(timeouts.AbsoluteTimeout))
+	if r != nil {
+		s.TouchSession(sessionID, now, ClientIP(r), r.UserAgent())
+	}
+	SetSessionCookie(w, sessionID, timeouts.AbsoluteTimeout, secure)
+}
+
+// PublicSessionID identifies a session in API responses without revealing the cookie value.
+func PublicSessionID(sessionID string) string {
+	h := sha256.Sum256([]byte("session:" + sessionID))
+	return hex.EncodeToString(h[:16])
+}
+
+// sessionIdle reports whether sess has gone unused for longer than idleTimeout (0 disables the check).
+func sessionIdle(sess *store.Session, idleTimeout time.Duration, now time.Time) bool {
+	if idleTimeout <= 0 {
+		return false
+	}
+	last := sess.LastSeenAt
+	if last.IsZero() {
+		last = sess.CreatedAt
+	}
+	return !last.IsZero() && now.Sub(last) > idleTimeout
+}
+
+// touchSession records r on sess when the last write is stale or the client changed.
+func touchSession(s store.Storer, sess *store.Session, r *http.Request, now time.Time) {
+	ip, ua := ClientIP(r), r.UserAgent()
+	if now.Sub(sess.LastSeenAt) < sessionTouchInterval && sess.IP == ip && sess.UserAgent == ua {
+		return
+	}
+	s.TouchSession(sess.ID, now, ip, ua)
+}
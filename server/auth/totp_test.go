@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTOTP_RFC6238Vectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to six digits.
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/30 {
+			t.Errorf("ValidateTOTP(%d, %s) = %d, %v; want step %d", tt.unix, tt.code, step, ok, tt.unix/30)
+		}
+	}
+}
+
+func TestValidateTOTP_Window(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	now := time.Unix(1_700_000_000, 0)
+	step := now.Unix() / 30
+	for _, tt := range []struct {
+		step int64
+		ok   bool
+	}{{step - 1, true}, {step, true}, {step + 1, true}, {step - 2, false}, {step + 2, false}} {
+		if _, ok := ValidateTOTP(secret, totpCode(key, tt.step), now); ok != tt.ok {
+			t.Errorf("code for step offset %d: ok = %v, want %v", tt.step-step, ok, tt.ok)
+		}
+	}
+	for _, code := range []string{"", "12345", "abcdef", "1234567"} {
+		if _, ok := ValidateTOTP(secret, code, now); ok {
+			t.Errorf("ValidateTOTP(%q) accepted", code)
+		}
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(TOTPURI("IPAM", "alice@example.org", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/IPAM:alice@example.org" {
+		t.Errorf("uri = %s", u)
+	}
+	if q := u.Query(); q.Get("secret") != "JBSWY3DPEHPK3PXP" || q.Get("issuer") != "IPAM" || q.Get("digits") != "6" {
+		t.Errorf("query = %v", q)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' || seen[c] {
+			t.Errorf("bad or duplicate code %q", c)
+		}
+		seen[c] = true
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Errorf("codes = %d, want %d", len(codes), RecoveryCodeCount)
+	}
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))) {
+		t.Error("hash depends on case or dashes")
+	}
+}
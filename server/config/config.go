@@ -4,17 +4,121 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type Config struct {
 	OAuth OAuthConfig
+	SAML  SAMLConfig
+	LDAP  LDAPConfig
+	SCIM  SCIMConfig
 	// AppOrigin is the public URL of the frontend (e.g. http://localhost:5173). When set, invite URLs and OAuth redirects use it; non-API requests to this server return 401 Unauthorized.
 	AppOrigin string
+	Sync      SyncConfig
+	Session   SessionConfig
+	Mail      MailConfig
+	RateLimit RateLimitConfig
+	// PasswordResetTTL (PASSWORD_RESET_TTL) is how long an emailed password reset link works; default 1h.
+	PasswordResetTTL time.Duration
 }
 
+// MailConfig configures outgoing email, used for password reset links. Password reset by email is only offered when
+// an SMTP host is set or LogOnly is on.
+type MailConfig struct {
+	SMTPHost     string // SMTP_HOST
+	SMTPPort     int    // SMTP_PORT: default 587; the connection is upgraded with STARTTLS when the server offers it
+	SMTPUsername string // SMTP_USERNAME: empty sends without authentication
+	SMTPPassword string // #nosec G117 -- SMTP_PASSWORD, not logged
+	From         string // MAIL_FROM: sender address, e.g. IPAM <ipam@example.com>
+	// LogOnly (MAIL_LOG_ONLY) writes messages to the server log instead of sending them. For development only: reset
+	// links in the log can be used by anyone who reads it.
+	LogOnly bool
+}
+
+const (
+	DefaultSMTPPort         = 587
+	DefaultPasswordResetTTL = time.Hour
+)
+
+func (c MailConfig) Enabled() bool {
+	return c.LogOnly || (strings.TrimSpace(c.SMTPHost) != "" && strings.TrimSpace(c.From) != "")
+}
+
+// SessionConfig bounds browser sessions. API tokens have their own expiry.
+type SessionConfig struct {
+	AbsoluteTimeout time.Duration // SESSION_ABSOLUTE_TIMEOUT: how long a session lasts after sign-in; default 24h
+	IdleTimeout     time.Duration // SESSION_IDLE_TIMEOUT: end a session unused for this long; default 0 (disabled)
+}
+
+const DefaultSessionAbsoluteTimeout = 24 * time.Hour
+
+// WithDefaults returns c with an unset absolute timeout filled in.
+func (c SessionConfig) WithDefaults() SessionConfig {
+	if c.AbsoluteTimeout <= 0 {
+		c.AbsoluteTimeout = DefaultSessionAbsoluteTimeout
+	}
+	return c
+}
+
+// RateLimitConfig limits how fast each API token, signed-in user or (before sign-in) client IP may call the API.
+// Each caller has a token bucket per route class: reads (GET), writes (other methods) and sync (starting a cloud
+// sync). A class with PerMinute 0 is not limited.
+type RateLimitConfig struct {
+	Enabled bool      // RATE_LIMIT_ENABLED: default false
+	Read    RateLimit // RATE_LIMIT_READ_PER_MINUTE, RATE_LIMIT_READ_BURST: default 600 and 120
+	Write   RateLimit // RATE_LIMIT_WRITE_PER_MINUTE, RATE_LIMIT_WRITE_BURST: default 120 and 30
+	Sync    RateLimit // RATE_LIMIT_SYNC_PER_MINUTE, RATE_LIMIT_SYNC_BURST: default 6 and 3
+	// Shared (RATE_LIMIT_SHARED) keeps buckets in Postgres so every replica enforces the same limits. Off by default:
+	// each replica then limits on its own, and a caller spread over n replicas gets up to n times the limit.
+	Shared bool
+	// TrustedProxies (RATE_LIMIT_TRUSTED_PROXIES, comma-separated IPs or CIDRs) are reverse proxies whose
+	// X-Forwarded-For is believed. Requests from any other peer are limited by the peer address.
+	TrustedProxies []netip.Prefix
+}
+
+// RateLimit is a token bucket: callers may make Burst requests at once, refilled at PerMinute requests a minute.
+type RateLimit struct {
+	PerMinute int
+	Burst     int // default PerMinute when unset
+}
+
+var (
+	DefaultReadRateLimit  = RateLimit{PerMinute: 600, Burst: 120}
+	DefaultWriteRateLimit = RateLimit{PerMinute: 120, Burst: 30}
+	DefaultSyncRateLimit  = RateLimit{PerMinute: 6, Burst: 3}
+)
+
+// Limited reports whether l limits requests.
+func (l RateLimit) Limited() bool {
+	return l.PerMinute > 0
+}
+
+// WithDefaults returns l with an unset burst filled in.
+func (l RateLimit) WithDefaults() RateLimit {
+	if l.Burst <= 0 {
+		l.Burst = l.PerMinute
+	}
+	return l
+}
+
+// SyncConfig controls the background cloud sync scheduler.
+type SyncConfig struct {
+	MaxConcurrency int           // SYNC_MAX_CONCURRENCY: connections synced at the same time; default 4
+	Jitter         time.Duration // SYNC_JITTER: max random delay before a due sync starts; default 30s
+	BackoffMax     time.Duration // SYNC_BACKOFF_MAX: cap on the retry delay after consecutive failures; default 6h
+}
+
+const (
+	DefaultSyncMaxConcurrency = 4
+	DefaultSyncJitter         = 30 * time.Second
+	DefaultSyncBackoffMax     = 6 * time.Hour
+)
+
 type OAuthConfig struct {
 	Providers map[string]OAuthProviderConfig
 }
@@ -23,6 +127,7 @@ type OAuthProviderConfig struct {
 	ClientID            string
 	ClientSecret        string // #nosec G117 -- OAuth client secret from config, not logged
 	Scopes              []string
+	IssuerURL           string // OIDC issuer; when set, endpoints come from its discovery document and identity from the verified ID token
 	AuthURL             string
 	TokenURL            string
 	UserInfoURL         string
@@ -33,10 +138,115 @@ type OAuthProviderConfig struct {
 	EmailVerifiedClaim  string // Userinfo claim that must be true when email comes from userinfo (e.g. email_verified)
 	EmailsVerifiedClaim string // Emails-list entry claim that must be true (e.g. verified on GitHub /user/emails)
 	AllowEmailMatch     bool   // Allow signing in to an existing account by email alone; default false
+	GroupsClaim         string // Claim listing the user's IdP groups (or any claim to map on); default "groups"
+	GroupMappings       []GroupMapping
 	DisplayName         string // Login/signup button label; default derived from provider id
 	ClientMTLS          OAuthClientMTLSConfig
 }
 
+// GroupMapping grants users in an IdP group (a value of the provider's groups claim) a role in an organization.
+// Organization is an organization name or ID. When a provider has mappings they decide access at every sign-in:
+// users in no mapped group cannot sign in, and users whose groups changed are moved or demoted.
+type GroupMapping struct {
+	Group        string
+	Organization string
+	Role         string // "admin" or "user"
+}
+
+type SAMLConfig struct {
+	Providers map[string]SAMLProviderConfig
+}
+
+// SAMLProviderConfig configures this server as a SAML 2.0 service provider for one IdP. The SP's metadata is served at
+// /api/auth/saml/<id>/metadata and its assertion consumer service at /api/auth/saml/<id>/acs.
+type SAMLProviderConfig struct {
+	IDPMetadataURL  string // URL of the IdP's metadata document; fetched on first use
+	IDPMetadataFile string // Path to the IdP's metadata document, instead of IDPMetadataURL
+	EntityID        string // SP entity ID and expected audience; required
+	CertFile        string // PEM certificate published in SP metadata
+	KeyFile         string // PEM private key (RSA) for CertFile, used to sign AuthnRequests
+	EmailAttribute  string // Assertion attribute holding the email; default mail/email/emailaddress, then an email NameID
+	GroupsAttribute string // Assertion attribute listing the user's groups; default "groups"
+	GroupMappings   []GroupMapping
+	AllowEmailMatch bool   // Allow signing in to an existing account by email alone; default false
+	DisplayName     string // Login button label; default derived from provider id
+}
+
+func (p SAMLProviderConfig) Enabled() bool {
+	return (strings.TrimSpace(p.IDPMetadataURL) != "" || strings.TrimSpace(p.IDPMetadataFile) != "") &&
+		strings.TrimSpace(p.CertFile) != "" && strings.TrimSpace(p.KeyFile) != ""
+}
+
+// LDAPConfig enables password login against an LDAP directory or Active Directory. Users are found with a search and
+// authenticated by binding as them; local users still sign in with their own password when the directory rejects them.
+type LDAPConfig struct {
+	URL            string // LDAP_URL: ldap://host:389 or ldaps://host:636
+	StartTLS       bool   // LDAP_START_TLS: upgrade an ldap:// connection with StartTLS before binding
+	CAFile         string // LDAP_CA_FILE: PEM CA bundle for the server certificate; default system roots
+	BindDN         string // LDAP_BIND_DN: service account that searches for users; empty searches anonymously
+	BindPassword   string // #nosec G117 -- LDAP_BIND_PASSWORD, not logged
+	BaseDN         string // LDAP_BASE_DN: subtree searched for users
+	UserFilter     string // LDAP_USER_FILTER: {email} is replaced by the login email; default (mail={email})
+	EmailAttribute string // LDAP_EMAIL_ATTRIBUTE: default "mail"
+	GroupBaseDN    string // LDAP_GROUP_BASE_DN: subtree searched for groups; default BaseDN
+	GroupFilter    string // LDAP_GROUP_FILTER: {dn} is replaced by the user's DN; default (|(member={dn})(uniqueMember={dn}))
+	GroupAttribute string // LDAP_GROUP_ATTRIBUTE: group name matched by GroupMappings; default "cn"
+	GroupMappings  []GroupMapping
+	// AllowEmailMatch (LDAP_ALLOW_EMAIL_MATCH) links a directory user to the unlinked account with its email on
+	// first login. Off by default: whoever controls a directory entry would get that account.
+	AllowEmailMatch bool
+	// AllowInsecure (LDAP_ALLOW_INSECURE) permits an ldap:// URL without StartTLS, which sends bind passwords in
+	// cleartext. For local test directories only.
+	AllowInsecure bool
+}
+
+// SCIMConfig enables the SCIM 2.0 provisioning API at /scim/v2 for an identity provider.
+type SCIMConfig struct {
+	Token string // #nosec G117 -- SCIM_TOKEN: bearer token the identity provider sends, not logged
+	// Organization (SCIM_ORGANIZATION, name or id) receives new users with the user role until a group mapping
+	// places them elsewhere.
+	Organization  string
+	GroupMappings []GroupMapping // SCIM_GROUP_MAPPINGS: SCIM group display names to organization and role
+}
+
+// MinSCIMTokenLength is the shortest SCIM_TOKEN accepted; the token is the only credential on the SCIM API.
+const MinSCIMTokenLength = 32
+
+func (c SCIMConfig) Enabled() bool {
+	return c.Token != "" && strings.TrimSpace(c.Organization) != ""
+}
+
+const (
+	DefaultLDAPUserFilter     = "(mail={email})"
+	DefaultLDAPEmailAttribute = "mail"
+	DefaultLDAPGroupFilter    = "(|(member={dn})(uniqueMember={dn}))"
+	DefaultLDAPGroupAttribute = "cn"
+)
+
+func (c LDAPConfig) Enabled() bool {
+	return strings.TrimSpace(c.URL) != "" && strings.TrimSpace(c.BaseDN) != ""
+}
+
+// WithDefaults returns c with unset filters and attribute names filled in.
+func (c LDAPConfig) WithDefaults() LDAPConfig {
+	if c.UserFilter == "" {
+		c.UserFilter = DefaultLDAPUserFilter
+	}
+	if c.EmailAttribute == "" {
+		c.EmailAttribute = DefaultLDAPEmailAttribute
+	}
+	if c.GroupBaseDN == "" {
+		c.GroupBaseDN = c.BaseDN
+	}
+	if c.GroupFilter == "" {
+		c.GroupFilter = DefaultLDAPGroupFilter
+	}
+	if c.GroupAttribute == "" {
+		c.GroupAttribute = DefaultLDAPGroupAttribute
+	}
+	return c
+}
+
 type OAuthClientMTLSConfig struct {
 	Enabled     bool
 	TLSCertFile string
@@ -53,11 +263,19 @@ func (p OAuthProviderConfig) hasCredentials() bool {
 }
 
 func (p OAuthProviderConfig) hasEndpoints() bool {
+	if p.IsOIDC() {
+		return true
+	}
 	return strings.TrimSpace(p.AuthURL) != "" &&
 		strings.TrimSpace(p.TokenURL) != "" &&
 		strings.TrimSpace(p.UserInfoURL) != ""
 }
 
+// IsOIDC reports whether the provider is configured by OIDC issuer rather than by individual endpoint URLs.
+func (p OAuthProviderConfig) IsOIDC() bool {
+	return strings.TrimSpace(p.IssuerURL) != ""
+}
+
 func (p OAuthProviderConfig) Enabled() bool {
 	return p.hasCredentials() && p.hasEndpoints()
 }
@@ -132,6 +350,32 @@ func (c *Config) OAuthProvider(providerID string) *OAuthProviderConfig {
 	return &p
 }
 
+// EnabledSAMLProviders returns the list of SAML provider IDs that are configured and enabled.
+func (c *Config) EnabledSAMLProviders() []string {
+	if c == nil || c.SAML.Providers == nil {
+		return nil
+	}
+	var out []string
+	for id, p := range c.SAML.Providers {
+		if p.Enabled() {
+			out = append(out, NormalizeOAuthProviderID(id))
+		}
+	}
+	return out
+}
+
+// SAMLProvider returns the config for a SAML provider ID, or nil if not configured.
+func (c *Config) SAMLProvider(providerID string) *SAMLProviderConfig {
+	if c == nil || c.SAML.Providers == nil {
+		return nil
+	}
+	p, ok := c.SAML.Providers[NormalizeOAuthProviderID(providerID)]
+	if !ok || !p.Enabled() {
+		return nil
+	}
+	return &p
+}
+
 // DefaultOAuthDisplayName returns the login button label for a provider.
 func DefaultOAuthDisplayName(providerID string) string {
 	providerID = NormalizeOAuthProviderID(providerID)
@@ -141,12 +385,17 @@ func DefaultOAuthDisplayName(providerID string) string {
 	return "Sign in with " + strings.ToUpper(providerID[:1]) + providerID[1:]
 }
 
-func LoadFromEnv() *Config {
+// LoadFromEnv builds the server configuration from the environment. It fails when a setting is present but invalid,
+// naming the variable, so a typo stops the server at startup instead of silently disabling what it configures.
+func LoadFromEnv() (*Config, error) {
 	var cfg Config
 	providers := make(map[string]OAuthProviderConfig)
 
 	for _, id := range parseOAuthProviderList(os.Getenv("OAUTH_PROVIDERS")) {
-		p := loadOAuthProviderFromEnv(id)
+		p, err := loadOAuthProviderFromEnv(id)
+		if err != nil {
+			return nil, err
+		}
 		if !p.Enabled() {
 			continue
 		}
@@ -160,11 +409,79 @@ func LoadFromEnv() *Config {
 	if len(providers) > 0 {
 		cfg.OAuth = OAuthConfig{Providers: providers}
 	}
+	samlProviders := make(map[string]SAMLProviderConfig)
+	for _, id := range parseOAuthProviderList(os.Getenv("SAML_PROVIDERS")) {
+		p, err := loadSAMLProviderFromEnv(id)
+		if err != nil {
+			return nil, err
+		}
+		if p.Enabled() {
+			samlProviders[id] = p
+		}
+	}
+	if len(samlProviders) > 0 {
+		cfg.SAML = SAMLConfig{Providers: samlProviders}
+	}
+	ldap, err := loadLDAPFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	cfg.LDAP = ldap
+	scimMappings, err := groupMappingsFromEnv("SCIM_GROUP_MAPPINGS")
+	if err != nil {
+		return nil, err
+	}
+	cfg.SCIM = SCIMConfig{
+		Token:         strings.TrimSpace(os.Getenv("SCIM_TOKEN")),
+		Organization:  strings.TrimSpace(os.Getenv("SCIM_ORGANIZATION")),
+		GroupMappings: scimMappings,
+	}
+	if cfg.SCIM.Token != "" && cfg.SCIM.Organization == "" {
+		return nil, fmt.Errorf("SCIM_ORGANIZATION is required when SCIM_TOKEN is set")
+	}
 	if origin := strings.TrimSpace(os.Getenv("APP_ORIGIN")); origin != "" {
 		cfg.AppOrigin = origin
 	}
-
-	return &cfg
+	// SAML metadata, AuthnRequests and response checks use these instead of request headers.
+	if saml := cfg.EnabledSAMLProviders(); len(saml) > 0 {
+		if cfg.AppOrigin == "" {
+			return nil, fmt.Errorf("APP_ORIGIN is required when SAML_PROVIDERS is set")
+		}
+		sort.Strings(saml)
+		for _, id := range saml {
+			if cfg.SAML.Providers[id].EntityID == "" {
+				return nil, fmt.Errorf("%sENTITY_ID is required", samlEnvPrefix(id))
+			}
+		}
+	}
+	cfg.Sync = SyncConfig{
+		MaxConcurrency: envIntDefault("SYNC_MAX_CONCURRENCY", DefaultSyncMaxConcurrency),
+		Jitter:         envDurationDefault("SYNC_JITTER", DefaultSyncJitter),
+		BackoffMax:     envDurationDefault("SYNC_BACKOFF_MAX", DefaultSyncBackoffMax),
+	}
+	cfg.Session = SessionConfig{
+		AbsoluteTimeout: envDurationDefault("SESSION_ABSOLUTE_TIMEOUT", DefaultSessionAbsoluteTimeout),
+		IdleTimeout:     envDurationDefault("SESSION_IDLE_TIMEOUT", 0),
+	}
+	cfg.Mail = MailConfig{
+		SMTPHost:     strings.TrimSpace(os.Getenv("SMTP_HOST")),
+		SMTPPort:     envIntDefault("SMTP_PORT", DefaultSMTPPort),
+		SMTPUsername: strings.TrimSpace(os.Getenv("SMTP_USERNAME")),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		From:         strings.TrimSpace(os.Getenv("MAIL_FROM")),
+		LogOnly:      envBoolDefault("MAIL_LOG_ONLY", false),
+	}
+	cfg.PasswordResetTTL = envDurationDefault("PASSWORD_RESET_TTL", DefaultPasswordResetTTL)
+	cfg.RateLimit = RateLimitConfig{
+		Enabled:        envBoolDefault("RATE_LIMIT_ENABLED", false),
+		Read:           loadRateLimitFromEnv("RATE_LIMIT_READ_", DefaultReadRateLimit),
+		Write:          loadRateLimitFromEnv("RATE_LIMIT_WRITE_", DefaultWriteRateLimit),
+		Sync:           loadRateLimitFromEnv("RATE_LIMIT_SYNC_", DefaultSyncRateLimit),
+		Shared:         envBoolDefault("RATE_LIMIT_SHARED", false),
+		TrustedProxies: ParsePrefixes(os.Getenv("RATE_LIMIT_TRUSTED_PROXIES")),
+	}
+
+	return &cfg, nil
 }
 
 func parseOAuthProviderList(raw string) []string {
@@ -190,11 +507,12 @@ func oauthEnvPrefix(providerID string) string {
 	return "OAUTH_" + strings.ToUpper(strings.ReplaceAll(providerID, "-", "_")) + "_"
 }
 
-func loadOAuthProviderFromEnv(providerID string) OAuthProviderConfig {
+func loadOAuthProviderFromEnv(providerID string) (OAuthProviderConfig, error) {
 	prefix := oauthEnvPrefix(providerID)
 	p := OAuthProviderConfig{
 		ClientID:            strings.TrimSpace(os.Getenv(prefix + "CLIENT_ID")),
 		ClientSecret:        strings.TrimSpace(os.Getenv(prefix + "CLIENT_SECRET")),
+		IssuerURL:           strings.TrimSpace(os.Getenv(prefix + "ISSUER_URL")),
 		AuthURL:             strings.TrimSpace(os.Getenv(prefix + "AUTH_URL")),
 		TokenURL:            strings.TrimSpace(os.Getenv(prefix + "TOKEN_URL")),
 		UserInfoURL:         strings.TrimSpace(os.Getenv(prefix + "USERINFO_URL")),
@@ -216,7 +534,115 @@ func loadOAuthProviderFromEnv(providerID string) OAuthProviderConfig {
 	if scopes := strings.TrimSpace(os.Getenv(prefix + "SCOPES")); scopes != "" {
 		p.Scopes = splitScopes(scopes)
 	}
-	return p
+	p.GroupsClaim = strings.TrimSpace(os.Getenv(prefix + "GROUPS_CLAIM"))
+	mappings, err := groupMappingsFromEnv(prefix + "GROUP_MAPPINGS")
+	if err != nil {
+		return OAuthProviderConfig{}, err
+	}
+	p.GroupMappings = mappings
+	return p, nil
+}
+
+func samlEnvPrefix(providerID string) string {
+	return "SAML_" + strings.ToUpper(strings.ReplaceAll(providerID, "-", "_")) + "_"
+}
+
+func loadSAMLProviderFromEnv(providerID string) (SAMLProviderConfig, error) {
+	prefix := samlEnvPrefix(providerID)
+	mappings, err := groupMappingsFromEnv(prefix + "GROUP_MAPPINGS")
+	if err != nil {
+		return SAMLProviderConfig{}, err
+	}
+	return SAMLProviderConfig{
+		IDPMetadataURL:  strings.TrimSpace(os.Getenv(prefix + "IDP_METADATA_URL")),
+		IDPMetadataFile: strings.TrimSpace(os.Getenv(prefix + "IDP_METADATA_FILE")),
+		EntityID:        strings.TrimSpace(os.Getenv(prefix + "ENTITY_ID")),
+		CertFile:        strings.TrimSpace(os.Getenv(prefix + "SP_CERT_FILE")),
+		KeyFile:         strings.TrimSpace(os.Getenv(prefix + "SP_KEY_FILE")),
+		EmailAttribute:  strings.TrimSpace(os.Getenv(prefix + "EMAIL_ATTRIBUTE")),
+		GroupsAttribute: strings.TrimSpace(os.Getenv(prefix + "GROUPS_ATTRIBUTE")),
+		GroupMappings:   mappings,
+		AllowEmailMatch: envBoolDefault(prefix+"ALLOW_EMAIL_MATCH", false),
+		DisplayName:     strings.TrimSpace(os.Getenv(prefix + "DISPLAY_NAME")),
+	}, nil
+}
+
+func loadLDAPFromEnv() (LDAPConfig, error) {
+	mappings, err := groupMappingsFromEnv("LDAP_GROUP_MAPPINGS")
+	if err != nil {
+		return LDAPConfig{}, err
+	}
+	c := LDAPConfig{
+		URL:             strings.TrimSpace(os.Getenv("LDAP_URL")),
+		StartTLS:        envBoolDefault("LDAP_START_TLS", false),
+		CAFile:          strings.TrimSpace(os.Getenv("LDAP_CA_FILE")),
+		BindDN:          strings.TrimSpace(os.Getenv("LDAP_BIND_DN")),
+		BindPassword:    os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:          strings.TrimSpace(os.Getenv("LDAP_BASE_DN")),
+		UserFilter:      strings.TrimSpace(os.Getenv("LDAP_USER_FILTER")),
+		EmailAttribute:  strings.TrimSpace(os.Getenv("LDAP_EMAIL_ATTRIBUTE")),
+		GroupBaseDN:     strings.TrimSpace(os.Getenv("LDAP_GROUP_BASE_DN")),
+		GroupFilter:     strings.TrimSpace(os.Getenv("LDAP_GROUP_FILTER")),
+		GroupAttribute:  strings.TrimSpace(os.Getenv("LDAP_GROUP_ATTRIBUTE")),
+		GroupMappings:   mappings,
+		AllowEmailMatch: envBoolDefault("LDAP_ALLOW_EMAIL_MATCH", false),
+		AllowInsecure:   envBoolDefault("LDAP_ALLOW_INSECURE", false),
+	}
+	return c.WithDefaults(), nil
+}
+
+// ParseGroupMappings parses comma-separated group=organization:role rules, e.g.
+// "ipam-admins=acme:admin,ipam-users=acme:user". Empty entries are ignored; an entry that does not parse or names
+// a role other than admin or user is an error.
+func ParseGroupMappings(raw string) ([]GroupMapping, error) {
+	var out []GroupMapping
+	for _, entry := range strings.Split(raw, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		eq := strings.LastIndex(entry, "=")
+		colon := strings.LastIndex(entry, ":")
+		if eq <= 0 || colon < eq {
+			return nil, fmt.Errorf("group mapping %q: want group=organization:role", strings.TrimSpace(entry))
+		}
+		m := GroupMapping{
+			Group:        strings.TrimSpace(entry[:eq]),
+			Organization: strings.TrimSpace(entry[eq+1 : colon]),
+			Role:         strings.ToLower(strings.TrimSpace(entry[colon+1:])),
+		}
+		if m.Group == "" || m.Organization == "" {
+			return nil, fmt.Errorf("group mapping %q: want group=organization:role", strings.TrimSpace(entry))
+		}
+		if m.Role != "admin" && m.Role != "user" {
+			return nil, fmt.Errorf("group mapping %q: role must be admin or user", strings.TrimSpace(entry))
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// groupMappingsFromEnv parses the group mappings in the environment variable name.
+func groupMappingsFromEnv(name string) ([]GroupMapping, error) {
+	m, err := ParseGroupMappings(os.Getenv(name))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return m, nil
+}
+
+// ParsePrefixes parses comma-separated CIDRs and IP addresses, an address becoming a single-address prefix. Entries
+// that do not parse are skipped.
+func ParsePrefixes(raw string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if p, err := netip.ParsePrefix(entry); err == nil {
+			out = append(out, p.Masked())
+		} else if a, err := netip.ParseAddr(entry); err == nil {
+			out = append(out, netip.PrefixFrom(a, a.BitLen()))
+		}
+	}
+	return out
 }
 
 func mergeOAuthProvider(base, overlay OAuthProviderConfig) OAuthProviderConfig {
@@ -229,6 +655,9 @@ func mergeOAuthProvider(base, overlay OAuthProviderConfig) OAuthProviderConfig {
 	if len(overlay.Scopes) > 0 {
 		base.Scopes = overlay.Scopes
 	}
+	if overlay.IssuerURL != "" {
+		base.IssuerURL = overlay.IssuerURL
+	}
 	if overlay.AuthURL != "" {
 		base.AuthURL = overlay.AuthURL
 	}
@@ -257,6 +686,12 @@ func mergeOAuthProvider(base, overlay OAuthProviderConfig) OAuthProviderConfig {
 		base.EmailsVerifiedClaim = overlay.EmailsVerifiedClaim
 	}
 	base.AllowEmailMatch = base.AllowEmailMatch || overlay.AllowEmailMatch
+	if overlay.GroupsClaim != "" {
+		base.GroupsClaim = overlay.GroupsClaim
+	}
+	if len(overlay.GroupMappings) > 0 {
+		base.GroupMappings = overlay.GroupMappings
+	}
 	if overlay.DisplayName != "" {
 		base.DisplayName = overlay.DisplayName
 	}
@@ -275,6 +710,20 @@ func mergeOAuthProvider(base, overlay OAuthProviderConfig) OAuthProviderConfig {
 	return base
 }
 
+// loadRateLimitFromEnv reads <prefix>PER_MINUTE and <prefix>BURST. PER_MINUTE=0 turns the limit off; setting only
+// PER_MINUTE makes the burst the same.
+func loadRateLimitFromEnv(prefix string, defaults RateLimit) RateLimit {
+	raw := strings.TrimSpace(os.Getenv(prefix + "PER_MINUTE"))
+	if raw == "" {
+		return RateLimit{PerMinute: defaults.PerMinute, Burst: envIntDefault(prefix+"BURST", defaults.Burst)}
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return defaults
+	}
+	return RateLimit{PerMinute: n, Burst: envIntDefault(prefix+"BURST", n)}.WithDefaults()
+}
+
 // envBoolDefault parses true/1/false/0; returns defaultVal when unset.
 func envBoolDefault(key string, defaultVal bool) bool {
 	raw := strings.TrimSpace(os.Getenv(key))
@@ -291,6 +740,32 @@ func envBoolDefault(key string, defaultVal bool) bool {
 	}
 }
 
+// envIntDefault parses a positive integer; returns defaultVal when unset or invalid.
+func envIntDefault(key string, defaultVal int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return defaultVal
+	}
+	return n
+}
+
+// envDurationDefault parses a Go duration (e.g. 30s, 2h); "0" is allowed. Returns defaultVal when unset or invalid.
+func envDurationDefault(key string, defaultVal time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultVal
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		return defaultVal
+	}
+	return d
+}
+
 func splitScopes(raw string) []string {
 	parts := strings.Split(raw, ",")
 	out := make([]string, 0, len(parts))
@@ -1,6 +1,11 @@
 package config
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestLoadFromEnv_OIDCProvider(t *testing.T) {
 	t.Setenv("OAUTH_PROVIDERS", "sso")
@@ -12,7 +17,7 @@ func TestLoadFromEnv_OIDCProvider(t *testing.T) {
 	t.Setenv("OAUTH_SSO_SCOPES", "openid,email,profile")
 	t.Setenv("OAUTH_SSO_DISPLAY_NAME", "Sign in with SSO")
 
-	cfg := LoadFromEnv()
+	cfg := mustLoadFromEnv(t)
 	p := cfg.OAuthProvider("sso")
 	if p == nil {
 		t.Fatal("sso provider not loaded")
@@ -25,6 +30,21 @@ func TestLoadFromEnv_OIDCProvider(t *testing.T) {
 	}
 }
 
+func TestLoadFromEnv_OIDCIssuer(t *testing.T) {
+	t.Setenv("OAUTH_PROVIDERS", "okta")
+	t.Setenv("OAUTH_OKTA_CLIENT_ID", "client")
+	t.Setenv("OAUTH_OKTA_CLIENT_SECRET", "secret")
+	t.Setenv("OAUTH_OKTA_ISSUER_URL", "https://example.okta.com")
+
+	p := mustLoadFromEnv(t).OAuthProvider("okta")
+	if p == nil {
+		t.Fatal("okta provider not loaded with issuer only")
+	}
+	if !p.IsOIDC() || p.IssuerURL != "https://example.okta.com" {
+		t.Errorf("provider = %+v", p)
+	}
+}
+
 func TestOAuthProviderConfig_Enabled(t *testing.T) {
 	p := OAuthProviderConfig{
 		ClientID: "x", ClientSecret: "y",
@@ -43,3 +63,214 @@ func TestNormalizeOAuthProviderID(t *testing.T) {
 		t.Errorf("NormalizeOAuthProviderID = %q", got)
 	}
 }
+
+func TestLoadFromEnv_Sync(t *testing.T) {
+	cfg := mustLoadFromEnv(t)
+	if cfg.Sync.MaxConcurrency != DefaultSyncMaxConcurrency || cfg.Sync.Jitter != DefaultSyncJitter || cfg.Sync.BackoffMax != DefaultSyncBackoffMax {
+		t.Errorf("default Sync = %+v", cfg.Sync)
+	}
+	t.Setenv("SYNC_MAX_CONCURRENCY", "2")
+	t.Setenv("SYNC_JITTER", "0")
+	t.Setenv("SYNC_BACKOFF_MAX", "90m")
+	cfg = mustLoadFromEnv(t)
+	if cfg.Sync.MaxConcurrency != 2 || cfg.Sync.Jitter != 0 || cfg.Sync.BackoffMax.Minutes() != 90 {
+		t.Errorf("Sync = %+v", cfg.Sync)
+	}
+	t.Setenv("SYNC_MAX_CONCURRENCY", "-1")
+	t.Setenv("SYNC_BACKOFF_MAX", "forever")
+	cfg = mustLoadFromEnv(t)
+	if cfg.Sync.MaxConcurrency != DefaultSyncMaxConcurrency || cfg.Sync.BackoffMax != DefaultSyncBackoffMax {
+		t.Errorf("invalid values should fall back to defaults: %+v", cfg.Sync)
+	}
+}
+
+func TestLoadFromEnv_Session(t *testing.T) {
+	cfg := mustLoadFromEnv(t)
+	if cfg.Session.AbsoluteTimeout != DefaultSessionAbsoluteTimeout || cfg.Session.IdleTimeout != 0 {
+		t.Errorf("default Session = %+v", cfg.Session)
+	}
+	t.Setenv("SESSION_ABSOLUTE_TIMEOUT", "8h")
+	t.Setenv("SESSION_IDLE_TIMEOUT", "30m")
+	cfg = mustLoadFromEnv(t)
+	if cfg.Session.AbsoluteTimeout != 8*time.Hour || cfg.Session.IdleTimeout != 30*time.Minute {
+		t.Errorf("Session = %+v", cfg.Session)
+	}
+	if got := (SessionConfig{}).WithDefaults(); got.AbsoluteTimeout != DefaultSessionAbsoluteTimeout {
+		t.Errorf("WithDefaults = %+v", got)
+	}
+}
+
+func TestLoadFromEnv_Mail(t *testing.T) {
+	cfg := mustLoadFromEnv(t)
+	if cfg.Mail.Enabled() || cfg.Mail.SMTPPort != DefaultSMTPPort || cfg.PasswordResetTTL != DefaultPasswordResetTTL {
+		t.Errorf("default Mail = %+v, PasswordResetTTL = %v", cfg.Mail, cfg.PasswordResetTTL)
+	}
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	t.Setenv("SMTP_PORT", "2525")
+	t.Setenv("MAIL_FROM", "IPAM <ipam@example.com>")
+	t.Setenv("PASSWORD_RESET_TTL", "30m")
+	cfg = mustLoadFromEnv(t)
+	if !cfg.Mail.Enabled() || cfg.Mail.SMTPHost != "smtp.example.com" || cfg.Mail.SMTPPort != 2525 || cfg.PasswordResetTTL != 30*time.Minute {
+		t.Errorf("Mail = %+v, PasswordResetTTL = %v", cfg.Mail, cfg.PasswordResetTTL)
+	}
+	if (MailConfig{SMTPHost: "smtp.example.com"}).Enabled() {
+		t.Error("Enabled without MAIL_FROM")
+	}
+	if !(MailConfig{LogOnly: true}).Enabled() {
+		t.Error("LogOnly not enabled")
+	}
+}
+
+func TestLoadFromEnv_RateLimit(t *testing.T) {
+	cfg := mustLoadFromEnv(t)
+	rl := cfg.RateLimit
+	if rl.Enabled || rl.Shared || len(rl.TrustedProxies) != 0 || rl.Read != DefaultReadRateLimit || rl.Write != DefaultWriteRateLimit || rl.Sync != DefaultSyncRateLimit {
+		t.Errorf("default RateLimit = %+v", rl)
+	}
+	t.Setenv("RATE_LIMIT_SHARED", "true")
+	t.Setenv("RATE_LIMIT_READ_PER_MINUTE", "0")
+	t.Setenv("RATE_LIMIT_WRITE_PER_MINUTE", "30")
+	t.Setenv("RATE_LIMIT_SYNC_PER_MINUTE", "2")
+	t.Setenv("RATE_LIMIT_SYNC_BURST", "1")
+	rl = mustLoadFromEnv(t).RateLimit
+	if !rl.Shared || rl.Read.Limited() || rl.Write != (RateLimit{PerMinute: 30, Burst: 30}) || rl.Sync != (RateLimit{PerMinute: 2, Burst: 1}) {
+		t.Errorf("RateLimit = %+v", rl)
+	}
+	t.Setenv("RATE_LIMIT_ENABLED", "true")
+	t.Setenv("RATE_LIMIT_TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.7,bad,2001:db8::1/64")
+	rl = mustLoadFromEnv(t).RateLimit
+	if !rl.Enabled {
+		t.Error("RATE_LIMIT_ENABLED=true ignored")
+	}
+	if got := fmt.Sprint(rl.TrustedProxies); got != "[10.0.0.0/8 192.0.2.7/32 2001:db8::/64]" {
+		t.Errorf("TrustedProxies = %s", got)
+	}
+}
+
+// mustLoadFromEnv returns LoadFromEnv's config, failing the test on error.
+func mustLoadFromEnv(t *testing.T) *Config {
+	t.Helper()
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv: %v", err)
+	}
+	return cfg
+}
+
+func TestParseGroupMappings(t *testing.T) {
+	got, err := ParseGroupMappings(" ipam-admins = acme:Admin ,ops=team=a:b:user, ,")
+	if err != nil {
+		t.Fatalf("ParseGroupMappings: %v", err)
+	}
+	want := []GroupMapping{
+		{Group: "ipam-admins", Organization: "acme", Role: "admin"},
+		{Group: "ops=team", Organization: "a:b", Role: "user"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ParseGroupMappings = %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("mapping %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	for _, bad := range []string{"bad", "no-org=:user", "=acme:user", "x=acme:owner", "ok=acme:user,x=acme"} {
+		if _, err := ParseGroupMappings(bad); err == nil {
+			t.Errorf("ParseGroupMappings(%q): want error", bad)
+		}
+	}
+}
+
+func TestLoadFromEnv_InvalidGroupMappings(t *testing.T) {
+	for _, name := range []string{"SCIM_GROUP_MAPPINGS", "LDAP_GROUP_MAPPINGS", "OAUTH_OKTA_GROUP_MAPPINGS", "SAML_CORP_GROUP_MAPPINGS"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("OAUTH_PROVIDERS", "okta")
+			t.Setenv("SAML_PROVIDERS", "corp")
+			t.Setenv(name, "netops=acme:admin,x=acme:owner")
+			_, err := LoadFromEnv()
+			if err == nil || !strings.Contains(err.Error(), name) || !strings.Contains(err.Error(), `"x=acme:owner"`) {
+				t.Errorf("LoadFromEnv err = %v, want it to name %s and the bad entry", err, name)
+			}
+		})
+	}
+}
+
+func TestLoadFromEnv_SAMLProvider(t *testing.T) {
+	t.Setenv("SAML_PROVIDERS", "corp-adfs,partial")
+	t.Setenv("SAML_CORP_ADFS_IDP_METADATA_URL", "https://adfs.example/FederationMetadata/2007-06/FederationMetadata.xml")
+	t.Setenv("SAML_CORP_ADFS_SP_CERT_FILE", "/etc/ipam/sp.crt")
+	t.Setenv("SAML_CORP_ADFS_SP_KEY_FILE", "/etc/ipam/sp.key")
+	t.Setenv("SAML_CORP_ADFS_EMAIL_ATTRIBUTE", "upn")
+	t.Setenv("SAML_CORP_ADFS_GROUP_MAPPINGS", "ipam-admins=acme:admin")
+	t.Setenv("SAML_PARTIAL_IDP_METADATA_URL", "https://idp.example/metadata")
+
+	// The entity ID and APP_ORIGIN are required, never derived from request headers.
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "APP_ORIGIN") {
+		t.Errorf("LoadFromEnv without APP_ORIGIN err = %v", err)
+	}
+	t.Setenv("APP_ORIGIN", "https://ipam.example")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "SAML_CORP_ADFS_ENTITY_ID") {
+		t.Errorf("LoadFromEnv without entity ID err = %v", err)
+	}
+	t.Setenv("SAML_CORP_ADFS_ENTITY_ID", "https://ipam.example/saml")
+
+	cfg := mustLoadFromEnv(t)
+	p := cfg.SAMLProvider("corp-adfs")
+	if p == nil {
+		t.Fatal("corp-adfs SAML provider not loaded")
+	}
+	if p.KeyFile != "/etc/ipam/sp.key" || p.EmailAttribute != "upn" || len(p.GroupMappings) != 1 {
+		t.Errorf("provider = %+v", p)
+	}
+	if cfg.SAMLProvider("partial") != nil {
+		t.Error("provider without SP certificate should not be enabled")
+	}
+	if got := cfg.EnabledSAMLProviders(); len(got) != 1 || got[0] != "corp-adfs" {
+		t.Errorf("EnabledSAMLProviders = %v", got)
+	}
+}
+
+func TestLoadFromEnv_LDAP(t *testing.T) {
+	t.Setenv("LDAP_URL", "ldap://dc1.corp.example")
+	t.Setenv("LDAP_START_TLS", "true")
+	t.Setenv("LDAP_BASE_DN", "dc=corp,dc=example")
+	t.Setenv("LDAP_GROUP_MAPPINGS", "ipam-admins=acme:admin")
+
+	c := mustLoadFromEnv(t).LDAP
+	if !c.Enabled() || !c.StartTLS || len(c.GroupMappings) != 1 {
+		t.Errorf("LDAP = %+v", c)
+	}
+	if c.UserFilter != DefaultLDAPUserFilter || c.EmailAttribute != DefaultLDAPEmailAttribute || c.GroupBaseDN != "dc=corp,dc=example" || c.AllowEmailMatch || c.AllowInsecure {
+		t.Errorf("defaults not applied: %+v", c)
+	}
+
+	t.Setenv("LDAP_ALLOW_INSECURE", "true")
+	if !mustLoadFromEnv(t).LDAP.AllowInsecure {
+		t.Error("LDAP_ALLOW_INSECURE not applied")
+	}
+
+	t.Setenv("LDAP_BASE_DN", "")
+	if mustLoadFromEnv(t).LDAP.Enabled() {
+		t.Error("LDAP enabled without a base DN")
+	}
+}
+
+func TestLoadFromEnv_SCIM(t *testing.T) {
+	t.Setenv("SCIM_TOKEN", " scim-token-from-the-identity-provider ")
+	t.Setenv("SCIM_ORGANIZATION", "Acme")
+	t.Setenv("SCIM_GROUP_MAPPINGS", "ipam-admins=acme:admin,ipam-users=acme:user")
+
+	c := mustLoadFromEnv(t).SCIM
+	if !c.Enabled() || c.Token != "scim-token-from-the-identity-provider" || c.Organization != "Acme" || len(c.GroupMappings) != 2 {
+		t.Errorf("SCIM = %+v", c)
+	}
+
+	t.Setenv("SCIM_ORGANIZATION", "")
+	if _, err := LoadFromEnv(); err == nil || !strings.Contains(err.Error(), "SCIM_ORGANIZATION") {
+		t.Errorf("token without organization: err = %v, want SCIM_ORGANIZATION error", err)
+	}
+	t.Setenv("SCIM_TOKEN", "")
+	if mustLoadFromEnv(t).SCIM.Enabled() {
+		t.Error("SCIM enabled without a token")
+	}
+}
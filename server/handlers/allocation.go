@@ -27,7 +27,7 @@ func NewCreateAllocationUseCase(s store.Storer) usecase.Interactor {
 		}
 
 		if valid := network.ValidateCIDR(input.CIDR); !valid {
-			return status.Wrap(errors.New("invalid CIDR format"), status.InvalidArgument)
+			return status.Wrap(errInvalidCIDR, status.InvalidArgument)
 		}
 
 		user := auth.UserFromContext(ctx)
@@ -47,7 +47,7 @@ func NewCreateAllocationUseCase(s store.Storer) usecase.Interactor {
 			}
 		}
 		if parentBlock == nil {
-			return status.Wrap(errors.New("block not found"), status.NotFound)
+			return status.Wrap(errBlockNotFound, status.NotFound)
 		}
 		var reservedOrgID *uuid.UUID
 		if parentBlock.EnvironmentID != uuid.Nil {
@@ -61,7 +61,7 @@ func NewCreateAllocationUseCase(s store.Storer) usecase.Interactor {
 			return status.Wrap(err, status.InvalidArgument)
 		}
 		if !contained {
-			return status.Wrap(errors.New("allocation CIDR must fall within the parent block's CIDR range"), status.InvalidArgument)
+			return status.Wrap(conflictf(errNotContained, "allocation CIDR must fall within the parent block's CIDR range"), status.InvalidArgument)
 		}
 
 		allAllocs, _, err := s.ListAllocationsFiltered("", input.BlockName, uuid.Nil, orgID, "", nil, 0, 0)
@@ -78,7 +78,7 @@ func NewCreateAllocationUseCase(s store.Storer) usecase.Interactor {
 			}
 			if overlap {
 				return status.Wrap(
-					fmt.Errorf("CIDR %s overlaps with existing allocation %q in block %q", input.CIDR, existing.Name, input.BlockName),
+					conflictf(errOverlap, "CIDR %s overlaps with existing allocation %q in block %q", input.CIDR, existing.Name, input.BlockName),
 					status.InvalidArgument,
 				)
 			}
@@ -87,7 +87,7 @@ func NewCreateAllocationUseCase(s store.Storer) usecase.Interactor {
 			return status.Wrap(err, status.Internal)
 		} else if reserved != nil {
 			return status.Wrap(
-				fmt.Errorf("CIDR %s overlaps reserved block %s", input.CIDR, reserved.CIDR),
+				conflictf(errReservedOverlap, "CIDR %s overlaps reserved block %s", input.CIDR, reserved.CIDR),
 				status.InvalidArgument,
 			)
 		}
@@ -140,7 +140,62 @@ func NewCreateAllocationUseCase(s store.Storer) usecase.Interactor {
 	return u
 }
 
-// AutoAllocate handler: find the next available CIDR in a block via bin-packing and create the allocation.
+// placementFromInput validates the strategy and align_to parameters shared by auto-allocate and the suggest endpoints.
+func placementFromInput(strategy string, alignTo int) (network.Placement, error) {
+	st, err := network.ParseAllocationStrategy(strategy)
+	if err != nil {
+		return network.Placement{}, status.Wrap(err, status.InvalidArgument)
+	}
+	if st == network.StrategyAlignedTo && alignTo <= 0 {
+		return network.Placement{}, status.Wrap(errors.New("align_to is required for the aligned_to strategy"), status.InvalidArgument)
+	}
+	return network.Placement{Strategy: st, AlignTo: alignTo}, nil
+}
+
+// autoAllocateBlock finds the caller's block named blockName and the CIDRs in it that are taken: its allocations and
+// the reserved ranges that overlap it.
+func autoAllocateBlock(ctx context.Context, s store.Storer, blockName string) (*network.Block, []string, error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, nil, status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
+	}
+	orgID := auth.ResolveOrgID(ctx, user, uuid.Nil)
+	blocks, _, err := s.ListBlocksFiltered(blockName, nil, nil, orgID, false, "", nil, 0, 0)
+	if err != nil {
+		return nil, nil, status.Wrap(err, status.Internal)
+	}
+	var parentBlock *network.Block
+	for _, b := range blocks {
+		if allocationBlockNamesMatch(b.Name, blockName) {
+			parentBlock = b
+			break
+		}
+	}
+	if parentBlock == nil {
+		return nil, nil, status.Wrap(errBlockNotFound, status.NotFound)
+	}
+
+	allAllocs, _, err := s.ListAllocationsFiltered("", blockName, uuid.Nil, orgID, "", nil, 0, 0)
+	if err != nil {
+		return nil, nil, status.Wrap(err, status.Internal)
+	}
+	var allocatedCIDRs []string
+	for _, a := range allAllocs {
+		if allocationBlockNamesMatch(a.Block.Name, blockName) {
+			allocatedCIDRs = append(allocatedCIDRs, a.Block.CIDR)
+		}
+	}
+
+	reserved, err := s.ListReservedBlocks(orgID)
+	if err != nil {
+		return nil, nil, status.Wrap(err, status.Internal)
+	}
+	allocatedCIDRs = append(allocatedCIDRs, reservedWithin(parentBlock.CIDR, reserved)...)
+	return parentBlock, allocatedCIDRs, nil
+}
+
+// AutoAllocate handler: find the next available CIDR in a block using the requested placement strategy (best-fit
+// bin-packing by default) and create the allocation.
 func NewAutoAllocateUseCase(s store.Storer) usecase.Interactor {
 	u := usecase.NewInteractor(func(ctx context.Context, input autoAllocateInput, output *allocationOutput) error {
 		if input.Name == "" || input.BlockName == "" {
@@ -149,56 +204,17 @@ func NewAutoAllocateUseCase(s store.Storer) usecase.Interactor {
 		if input.PrefixLength < 1 || input.PrefixLength > 32 {
 			return status.Wrap(errors.New("prefix_length must be between 1 and 32"), status.InvalidArgument)
 		}
-
-		user := auth.UserFromContext(ctx)
-		if user == nil {
-			return status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
-		}
-		orgID := auth.ResolveOrgID(ctx, user, uuid.Nil)
-		blocks, _, err := s.ListBlocksFiltered(input.BlockName, nil, nil, orgID, false, "", nil, 0, 0)
-		if err != nil {
-			return status.Wrap(err, status.Internal)
-		}
-		var parentBlock *network.Block
-		for _, b := range blocks {
-			if allocationBlockNamesMatch(b.Name, input.BlockName) {
-				parentBlock = b
-				break
-			}
-		}
-		if parentBlock == nil {
-			return status.Wrap(errors.New("block not found"), status.NotFound)
-		}
-
-		allAllocs, _, err := s.ListAllocationsFiltered("", input.BlockName, uuid.Nil, orgID, "", nil, 0, 0)
+		placement, err := placementFromInput(input.Strategy, input.AlignTo)
 		if err != nil {
-			return status.Wrap(err, status.Internal)
-		}
-		var allocatedCIDRs []string
-		for _, a := range allAllocs {
-			if allocationBlockNamesMatch(a.Block.Name, input.BlockName) {
-				allocatedCIDRs = append(allocatedCIDRs, a.Block.CIDR)
-			}
+			return err
 		}
 
-		reserved, err := s.ListReservedBlocks(orgID)
+		parentBlock, allocatedCIDRs, err := autoAllocateBlock(ctx, s, input.BlockName)
 		if err != nil {
-			return status.Wrap(err, status.Internal)
-		}
-		for _, r := range reserved {
-			overlap, _ := network.Overlaps(parentBlock.CIDR, r.CIDR)
-			if !overlap {
-				continue
-			}
-			contained, _ := network.Contains(parentBlock.CIDR, r.CIDR)
-			if contained {
-				allocatedCIDRs = append(allocatedCIDRs, r.CIDR)
-			} else {
-				allocatedCIDRs = append(allocatedCIDRs, parentBlock.CIDR)
-			}
+			return err
 		}
 
-		cidr, err := network.NextAvailableCIDRWithAllocations(parentBlock.CIDR, input.PrefixLength, allocatedCIDRs)
+		cidr, err := network.NextAvailableCIDRWithStrategy(parentBlock.CIDR, input.PrefixLength, allocatedCIDRs, placement)
 		if err != nil {
 			return status.Wrap(fmt.Errorf("no available CIDR with prefix /%d in block %q: %w", input.PrefixLength, input.BlockName, err), status.FailedPrecondition)
 		}
@@ -246,7 +262,89 @@ func NewAutoAllocateUseCase(s store.Storer) usecase.Interactor {
 	})
 
 	u.SetTitle("Auto-allocate")
-	u.SetDescription("Finds the next available CIDR in a block using bin-packing and creates an allocation")
+	u.SetDescription("Finds the next available CIDR in a block using the placement strategy (best_fit, first_fit, last_fit, sparse or aligned_to; default best_fit) and creates an allocation")
+	u.SetExpectedErrors(status.InvalidArgument, status.FailedPrecondition, status.NotFound, status.Internal)
+	return u
+}
+
+// NewAutoAllocateBatchUseCase places several allocations in one block at once. Larger prefixes are packed first so the
+// set fits wherever it can, every allocation is placed before anything is written, and they are created atomically.
+func NewAutoAllocateBatchUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input autoAllocateBatchInput, output *autoAllocateBatchOutput) error {
+		if input.BlockName == "" {
+			return status.Wrap(errors.New("block_name is required"), status.InvalidArgument)
+		}
+		if len(input.Allocations) == 0 {
+			return status.Wrap(errors.New("allocations is required"), status.InvalidArgument)
+		}
+		prefixLengths := make([]int, len(input.Allocations))
+		names := make(map[string]bool, len(input.Allocations))
+		for i, item := range input.Allocations {
+			if strings.TrimSpace(item.Name) == "" {
+				return status.Wrap(fmt.Errorf("allocation at index %d: name is required", i), status.InvalidArgument)
+			}
+			if item.PrefixLength < 1 || item.PrefixLength > 32 {
+				return status.Wrap(fmt.Errorf("allocation %q: prefix_length must be between 1 and 32", item.Name), status.InvalidArgument)
+			}
+			if names[normalizeBulkName(item.Name)] {
+				return status.Wrap(fmt.Errorf("allocation %q is listed more than once", item.Name), status.InvalidArgument)
+			}
+			names[normalizeBulkName(item.Name)] = true
+			prefixLengths[i] = item.PrefixLength
+		}
+		placement, err := placementFromInput(input.Strategy, input.AlignTo)
+		if err != nil {
+			return err
+		}
+
+		parentBlock, allocatedCIDRs, err := autoAllocateBlock(ctx, s, input.BlockName)
+		if err != nil {
+			return err
+		}
+		// Cloud allocations are created one API call at a time, so a batch could not be rolled back there.
+		if parentBlock.ConnectionID != nil && parentBlock.ExternalID != "" {
+			return status.Wrap(
+				fmt.Errorf("block %q is managed by the %s integration (%s); auto-allocate its subnets one at a time", parentBlock.Name, parentBlock.Provider, parentBlock.ExternalID),
+				status.FailedPrecondition,
+			)
+		}
+
+		cidrs, err := network.PackCIDRs(parentBlock.CIDR, prefixLengths, allocatedCIDRs, placement)
+		if err != nil {
+			var packErr *network.PackError
+			if errors.As(err, &packErr) {
+				item := input.Allocations[packErr.Index]
+				return status.Wrap(
+					fmt.Errorf("allocation %q (/%d) does not fit in block %q after placing %d of %d: %w", item.Name, item.PrefixLength, input.BlockName, packErr.Placed, packErr.Total, packErr.Err),
+					status.FailedPrecondition,
+				)
+			}
+			return status.Wrap(err, status.InvalidArgument)
+		}
+
+		changes := &store.BulkChanges{}
+		output.Allocations = make([]allocationOutput, len(cidrs))
+		for i, cidr := range cidrs {
+			alloc := &network.Allocation{
+				Id:    s.GenerateID(),
+				Name:  input.Allocations[i].Name,
+				Block: network.Block{Name: input.BlockName, CIDR: cidr},
+			}
+			changes.CreateAllocations = append(changes.CreateAllocations, alloc)
+			output.Allocations[i] = allocationOutput{Id: alloc.Id, Name: alloc.Name, BlockName: alloc.Block.Name, CIDR: cidr}
+		}
+		if !input.DryRun {
+			if err := s.ApplyBulk(changes); err != nil {
+				return status.Wrap(fmt.Errorf("create allocations: %w", err), status.Internal)
+			}
+		}
+		output.DryRun = input.DryRun
+		return nil
+	})
+
+	u.SetTitle("Auto-allocate Batch")
+	u.SetDescription("Places several allocations of different prefix lengths in one block, packing the largest first, and creates them all or none. " +
+		"Accepts the same strategy and align_to as auto-allocate. Set dry_run to see the placement without creating anything. Blocks managed by a cloud integration are refused.")
 	u.SetExpectedErrors(status.InvalidArgument, status.FailedPrecondition, status.NotFound, status.Internal)
 	return u
 }
@@ -261,10 +359,10 @@ func NewListAllocationsUseCase(s store.Storer) usecase.Interactor {
 		if input.EnvironmentID != uuid.Nil {
 			env, err := s.GetEnvironment(input.EnvironmentID)
 			if err != nil {
-				return status.Wrap(errors.New("environment not found"), status.NotFound)
+				return status.Wrap(errEnvironmentNotFound, status.NotFound)
 			}
 			if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-				return status.Wrap(errors.New("environment not found"), status.NotFound)
+				return status.Wrap(errEnvironmentNotFound, status.NotFound)
 			}
 		}
 		orgID := auth.ResolveOrgID(ctx, user, input.OrganizationID)
@@ -395,7 +493,7 @@ func NewUpdateAllocationUseCase(s store.Storer) usecase.Interactor {
 			}
 			if overlap {
 				return status.Wrap(
-					fmt.Errorf("CIDR %s overlaps with existing allocation %q in block %q", alloc.Block.CIDR, existing.Name, alloc.Block.Name),
+					conflictf(errOverlap, "CIDR %s overlaps with existing allocation %q in block %q", alloc.Block.CIDR, existing.Name, alloc.Block.Name),
 					status.InvalidArgument,
 				)
 			}
@@ -435,15 +533,11 @@ func NewDeleteAllocationUseCase(s store.Storer) usecase.Interactor {
 		if user != nil && !allocationInEffectiveOrg(ctx, s, user, alloc) {
 			return status.Wrap(errors.New("allocation not found"), status.NotFound)
 		}
-		// Soft-delete when allocation has an external ID and is linked to a read-write connection with IPAM conflict resolution.
-		if alloc.ConnectionID != nil && *alloc.ConnectionID != uuid.Nil && alloc.ExternalID != "" {
-			conn, err := s.GetCloudConnection(*alloc.ConnectionID)
-			if err == nil && conn.SyncMode == "read_write" && conn.ConflictResolution == "ipam" {
-				if err := s.SoftDeleteAllocation(input.Id); err != nil {
-					return status.Wrap(err, status.Internal)
-				}
-				return nil
+		if pendingCloudDelete(s, alloc.ConnectionID, alloc.ExternalID) {
+			if err := s.SoftDeleteAllocation(input.Id); err != nil {
+				return status.Wrap(err, status.Internal)
 			}
+			return nil
 		}
 		if err := s.DeleteAllocation(input.Id); err != nil {
 			return status.Wrap(errors.New("allocation not found"), status.NotFound)
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/store"
+)
+
+func TestAutoAllocateAndSuggest_Strategy(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	pool := &network.Pool{Name: "pool", CIDR: "10.0.0.0/16", EnvironmentID: env.Id, OrganizationID: env.OrganizationID}
+	if err := s.CreatePool(pool); err != nil {
+		t.Fatal(err)
+	}
+	block := createTestBlock(t, s, &network.Block{Name: "vpc", CIDR: "10.0.0.0/22", EnvironmentID: env.Id, PoolID: &pool.ID}, map[string]string{
+		"a": "10.0.0.0/24",
+	})
+	if err := s.CreateReservedBlock(&store.ReservedBlock{Name: "gw", CIDR: "10.0.3.0/24", OrganizationID: env.OrganizationID}); err != nil {
+		t.Fatal(err)
+	}
+
+	var suggested suggestBlockCIDROutput
+	if err := NewSuggestBlockCIDRUseCase(s).Interact(ctx, suggestBlockCIDRInput{ID: block.ID, Prefix: 26, Strategy: "sparse"}, &suggested); err != nil {
+		t.Fatal(err)
+	}
+	if suggested.CIDR != "10.0.1.128/26" {
+		t.Errorf("sparse suggestion = %s, want 10.0.1.128/26", suggested.CIDR)
+	}
+	if err := NewSuggestPoolBlockCIDRUseCase(s).Interact(ctx, suggestPoolBlockCIDRInput{ID: pool.ID, Prefix: 24, Strategy: "aligned_to", AlignTo: 20}, &suggested); err != nil {
+		t.Fatal(err)
+	}
+	if suggested.CIDR != "10.0.16.0/24" {
+		t.Errorf("aligned suggestion = %s, want 10.0.16.0/24", suggested.CIDR)
+	}
+
+	// last_fit fills from the top of the block, skipping the reserved /24.
+	var alloc allocationOutput
+	if err := NewAutoAllocateUseCase(s).Interact(ctx, autoAllocateInput{Name: "top", BlockName: "vpc", PrefixLength: 24, Strategy: "last_fit"}, &alloc); err != nil {
+		t.Fatal(err)
+	}
+	if alloc.CIDR != "10.0.2.0/24" {
+		t.Errorf("last_fit allocation = %s, want 10.0.2.0/24", alloc.CIDR)
+	}
+	if err := NewAutoAllocateUseCase(s).Interact(ctx, autoAllocateInput{Name: "next", BlockName: "vpc", PrefixLength: 24}, &alloc); err != nil {
+		t.Fatal(err)
+	}
+	if alloc.CIDR != "10.0.1.0/24" {
+		t.Errorf("default allocation = %s, want 10.0.1.0/24", alloc.CIDR)
+	}
+
+	wantStatus(t, NewAutoAllocateUseCase(s).Interact(ctx, autoAllocateInput{Name: "x", BlockName: "vpc", PrefixLength: 26, Strategy: "worst_fit"}, &alloc), http.StatusBadRequest, "unknown allocation strategy")
+	wantStatus(t, NewSuggestBlockCIDRUseCase(s).Interact(ctx, suggestBlockCIDRInput{ID: block.ID, Prefix: 26, Strategy: "aligned_to"}, &suggested), http.StatusBadRequest, "align_to is required")
+	wantStatus(t, NewSuggestBlockCIDRUseCase(s).Interact(ctx, suggestBlockCIDRInput{ID: block.ID, Prefix: 26, Strategy: "aligned_to", AlignTo: 28}, &suggested), http.StatusBadRequest, "align_to must be between")
+}
+
+func TestAutoAllocateBatch(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	createTestBlock(t, s, &network.Block{Name: "vpc", CIDR: "10.0.0.0/22", EnvironmentID: env.Id}, map[string]string{
+		"existing": "10.0.3.0/25",
+	})
+	items := []autoAllocateBatchItem{{Name: "data", PrefixLength: 26}, {Name: "private", PrefixLength: 23}, {Name: "public", PrefixLength: 24}}
+
+	var out autoAllocateBatchOutput
+	if err := NewAutoAllocateBatchUseCase(s).Interact(ctx, autoAllocateBatchInput{BlockName: "vpc", Allocations: items, DryRun: true}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.DryRun || len(out.Allocations) != 3 || out.Allocations[0].CIDR != "10.0.3.128/26" || out.Allocations[1].CIDR != "10.0.0.0/23" || out.Allocations[2].CIDR != "10.0.2.0/24" {
+		t.Fatalf("dry run = %+v", out)
+	}
+	if allocs, _ := s.ListAllocations(); len(allocs) != 1 {
+		t.Fatalf("dry run created allocations: %d", len(allocs))
+	}
+
+	if err := NewAutoAllocateBatchUseCase(s).Interact(ctx, autoAllocateBatchInput{BlockName: "vpc", Allocations: items}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range out.Allocations {
+		got, err := s.GetAllocation(a.Id)
+		if err != nil || got.Block.CIDR != a.CIDR || got.Name != a.Name {
+			t.Errorf("allocation %s = %+v, %v", a.Name, got, err)
+		}
+	}
+
+	// The block now has a /26 left: a batch that needs more than that creates nothing.
+	more := []autoAllocateBatchItem{{Name: "a", PrefixLength: 26}, {Name: "b", PrefixLength: 26}}
+	wantStatus(t, NewAutoAllocateBatchUseCase(s).Interact(ctx, autoAllocateBatchInput{BlockName: "vpc", Allocations: more}, &out), http.StatusPreconditionFailed, `allocation "b" (/26) does not fit in block "vpc" after placing 1 of 2`)
+	if allocs, _ := s.ListAllocations(); len(allocs) != 4 {
+		t.Errorf("failed batch changed allocations: %d", len(allocs))
+	}
+	dup := []autoAllocateBatchItem{{Name: "x", PrefixLength: 28}, {Name: " X ", PrefixLength: 28}}
+	wantStatus(t, NewAutoAllocateBatchUseCase(s).Interact(ctx, autoAllocateBatchInput{BlockName: "vpc", Allocations: dup}, &out), http.StatusBadRequest, "listed more than once")
+	wantStatus(t, NewAutoAllocateBatchUseCase(s).Interact(ctx, autoAllocateBatchInput{BlockName: "nope", Allocations: more}, &out), http.StatusNotFound, "block not found")
+}
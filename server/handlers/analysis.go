@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+const (
+	overlapScopeCrossConnection  = "cross_connection"
+	overlapScopeCrossEnvironment = "cross_environment"
+	overlapScopeSameEnvironment  = "same_environment"
+)
+
+func blockOverlapResource(b *network.Block) overlapResourceOutput {
+	return overlapResourceOutput{
+		Type:          "block",
+		ID:            b.ID,
+		Name:          b.Name,
+		CIDR:          b.CIDR,
+		EnvironmentID: b.EnvironmentID,
+		Provider:      b.Provider,
+		ConnectionID:  b.ConnectionID,
+	}
+}
+
+// overlapScope classifies a conflict: different cloud connections first (peering/transit), then environments.
+func overlapScope(a, b overlapResourceOutput) string {
+	if a.ConnectionID != nil && b.ConnectionID != nil && *a.ConnectionID != *b.ConnectionID {
+		return overlapScopeCrossConnection
+	}
+	if a.EnvironmentID != b.EnvironmentID {
+		return overlapScopeCrossEnvironment
+	}
+	return overlapScopeSameEnvironment
+}
+
+// findResourceOverlaps returns a conflict for every overlapping pair in resources.
+// skip, when set, suppresses pairs that are not conflicts (e.g. allocations in the same block).
+func findResourceOverlaps(resources []overlapResourceOutput, skip func(a, b overlapResourceOutput) bool) []overlapConflictOutput {
+	cidrs := make([]string, len(resources))
+	for i, r := range resources {
+		cidrs[i] = r.CIDR
+	}
+	var out []overlapConflictOutput
+	for _, p := range network.FindOverlaps(cidrs) {
+		a, b := resources[p.I], resources[p.J]
+		if skip != nil && skip(a, b) {
+			continue
+		}
+		out = append(out, overlapConflictOutput{A: a, B: b, OverlapCIDR: p.CIDR, Scope: overlapScope(a, b)})
+	}
+	return out
+}
+
+// blockInUserOrg reports whether the caller may see block (blocks in an environment take the environment's org).
+func blockInUserOrg(ctx context.Context, s store.Storer, user *store.User, block *network.Block) bool {
+	if user == nil {
+		return true
+	}
+	userOrg := auth.UserOrgForAccess(ctx, user)
+	if userOrg == uuid.Nil {
+		return true
+	}
+	if block.EnvironmentID == uuid.Nil {
+		return block.OrganizationID == userOrg
+	}
+	env, err := s.GetEnvironment(block.EnvironmentID)
+	if err != nil {
+		return false
+	}
+	return env.OrganizationID == userOrg
+}
+
+// allocationParentBlock returns the block in byName (keyed by lowercased, trimmed name) whose
+// name matches a's block name and whose CIDR contains a's CIDR, or nil.
+func allocationParentBlock(byName map[string][]*network.Block, a *network.Allocation) *network.Block {
+	for _, b := range byName[strings.ToLower(strings.TrimSpace(a.Block.Name))] {
+		if contained, err := network.Contains(b.CIDR, a.Block.CIDR); err == nil && contained {
+			return b
+		}
+	}
+	return nil
+}
+
+// NewOverlapReportUseCase reports overlapping blocks and allocations across an organization's environments and cloud connections.
+func NewOverlapReportUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input overlapReportInput, output *overlapReportOutput) error {
+		user := auth.UserFromContext(ctx)
+		orgID := auth.ResolveOrgID(ctx, user, input.OrganizationID)
+		if orgID == nil || *orgID == uuid.Nil {
+			return status.Wrap(errors.New("organization_id is required"), status.InvalidArgument)
+		}
+		blocks, _, err := s.ListBlocksFiltered("", nil, nil, orgID, false, "", nil, 0, 0)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		allocs, _, err := s.ListAllocationsFiltered("", "", uuid.Nil, orgID, "", nil, 0, 0)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+
+		blockResources := make([]overlapResourceOutput, 0, len(blocks))
+		for _, b := range blocks {
+			if b.Isolated && !input.IncludeIsolated {
+				continue
+			}
+			blockResources = append(blockResources, blockOverlapResource(b))
+		}
+
+		// Allocations inherit environment and connection from their parent block (matched by name
+		// and CIDR containment). blocks are all in orgID, so names cannot resolve across organizations.
+		blocksByName := make(map[string][]*network.Block, len(blocks))
+		for _, b := range blocks {
+			k := strings.ToLower(strings.TrimSpace(b.Name))
+			blocksByName[k] = append(blocksByName[k], b)
+		}
+		allocResources := make([]overlapResourceOutput, 0, len(allocs))
+		for _, a := range allocs {
+			parent := allocationParentBlock(blocksByName, a)
+			if parent == nil || (parent.Isolated && !input.IncludeIsolated) {
+				continue
+			}
+			r := overlapResourceOutput{
+				Type:          "allocation",
+				ID:            a.Id,
+				Name:          a.Name,
+				CIDR:          a.Block.CIDR,
+				EnvironmentID: parent.EnvironmentID,
+				BlockName:     parent.Name,
+				Provider:      a.Provider,
+				ConnectionID:  a.ConnectionID,
+			}
+			if r.ConnectionID == nil {
+				r.ConnectionID = parent.ConnectionID
+			}
+			allocResources = append(allocResources, r)
+		}
+
+		output.Conflicts = findResourceOverlaps(blockResources, nil)
+		output.Conflicts = append(output.Conflicts, findResourceOverlaps(allocResources, func(a, b overlapResourceOutput) bool {
+			// Overlaps inside one block are already rejected on create; across blocks they follow from block overlaps.
+			return allocationBlockNamesMatch(a.BlockName, b.BlockName)
+		})...)
+		if output.Conflicts == nil {
+			output.Conflicts = []overlapConflictOutput{}
+		}
+		output.Total = len(output.Conflicts)
+		return nil
+	})
+
+	u.SetTitle("Overlap Report")
+	u.SetDescription("Finds overlapping blocks and allocations across environments and cloud connections in an organization. Blocks marked isolated (and their allocations) are ignored unless include_isolated is set.")
+	u.SetExpectedErrors(status.InvalidArgument, status.Internal)
+	return u
+}
+
+// NewConnectivityCheckUseCase checks whether a set of blocks can be peered or attached to the same transit without address conflicts.
+func NewConnectivityCheckUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input connectivityCheckInput, output *connectivityCheckOutput) error {
+		if len(input.BlockIDs) < 2 {
+			return status.Wrap(errors.New("at least two block_ids are required"), status.InvalidArgument)
+		}
+		user := auth.UserFromContext(ctx)
+		seen := make(map[uuid.UUID]bool, len(input.BlockIDs))
+		resources := make([]overlapResourceOutput, 0, len(input.BlockIDs))
+		for _, id := range input.BlockIDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			block, err := s.GetBlock(id)
+			if err != nil || !blockInUserOrg(ctx, s, user, block) {
+				return status.Wrap(errors.New("block not found"), status.NotFound)
+			}
+			resources = append(resources, blockOverlapResource(block))
+		}
+
+		// The isolated flag is deliberately not applied: asking to connect a block overrides it.
+		output.Conflicts = findResourceOverlaps(resources, nil)
+		if output.Conflicts == nil {
+			output.Conflicts = []overlapConflictOutput{}
+		}
+		output.Connectable = len(output.Conflicts) == 0
+		return nil
+	})
+
+	u.SetTitle("Connectivity Check")
+	u.SetDescription("Reports whether the given blocks can be connected (e.g. VPC peering or a shared transit gateway) and returns every conflicting pair")
+	u.SetExpectedErrors(status.InvalidArgument, status.NotFound, status.Internal)
+	return u
+}
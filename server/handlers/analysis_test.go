@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+)
+
+// setupOverlapTest creates an org with two environments whose blocks overlap across two cloud connections.
+func setupOverlapTest(t *testing.T) (*store.Store, context.Context, map[string]*network.Block) {
+	t.Helper()
+	s, _, org, orgAdmin := setupGlobalAdminTest(t)
+	ctx := auth.WithUser(context.Background(), orgAdmin)
+	prod := &network.Environment{Id: uuid.New(), Name: "prod", OrganizationID: org.ID}
+	dev := &network.Environment{Id: uuid.New(), Name: "dev", OrganizationID: org.ID}
+	for _, env := range []*network.Environment{prod, dev} {
+		if err := s.CreateEnvironment(env); err != nil {
+			t.Fatalf("create environment: %v", err)
+		}
+	}
+	connA, connB := uuid.New(), uuid.New()
+	blocks := map[string]*network.Block{
+		"prod-vpc": {Name: "prod-vpc", CIDR: "10.0.0.0/16", EnvironmentID: prod.Id, ConnectionID: &connA},
+		"dev-vpc":  {Name: "dev-vpc", CIDR: "10.0.0.0/20", EnvironmentID: dev.Id, ConnectionID: &connB},
+		"dev-lab":  {Name: "dev-lab", CIDR: "10.0.0.0/24", EnvironmentID: dev.Id, Isolated: true},
+		"shared":   {Name: "shared", CIDR: "172.16.0.0/16", EnvironmentID: prod.Id},
+	}
+	for _, b := range blocks {
+		if err := s.CreateBlock(b); err != nil {
+			t.Fatalf("create block: %v", err)
+		}
+	}
+	allocs := []*network.Allocation{
+		{Id: uuid.New(), Name: "prod-a", Block: network.Block{Name: "prod-vpc", CIDR: "10.0.1.0/24"}},
+		{Id: uuid.New(), Name: "dev-a", Block: network.Block{Name: "dev-vpc", CIDR: "10.0.1.0/24"}},
+		{Id: uuid.New(), Name: "lab-a", Block: network.Block{Name: "dev-lab", CIDR: "10.0.0.0/25"}},
+	}
+	for _, a := range allocs {
+		if err := s.CreateAllocation(a.Id, a); err != nil {
+			t.Fatalf("create allocation: %v", err)
+		}
+	}
+	return s, ctx, blocks
+}
+
+func TestOverlapReport(t *testing.T) {
+	s, ctx, blocks := setupOverlapTest(t)
+	uc := NewOverlapReportUseCase(s)
+
+	var out overlapReportOutput
+	if err := uc.Interact(ctx, overlapReportInput{}, &out); err != nil {
+		t.Fatalf("Interact: %v", err)
+	}
+	if out.Total != 2 {
+		t.Fatalf("Total = %d, want 2 (isolated block ignored): %+v", out.Total, out.Conflicts)
+	}
+	byType := map[string]overlapConflictOutput{}
+	for _, c := range out.Conflicts {
+		byType[c.A.Type] = c
+		if c.Scope != overlapScopeCrossConnection {
+			t.Errorf("conflict %s/%s scope = %q, want %q", c.A.Name, c.B.Name, c.Scope, overlapScopeCrossConnection)
+		}
+	}
+	if c := byType["block"]; c.OverlapCIDR != blocks["dev-vpc"].CIDR {
+		t.Errorf("block conflict overlap = %q, want %q", c.OverlapCIDR, blocks["dev-vpc"].CIDR)
+	}
+	if c := byType["allocation"]; c.OverlapCIDR != "10.0.1.0/24" || c.A.BlockName == "" {
+		t.Errorf("allocation conflict = %+v, want 10.0.1.0/24 with parent blocks", c)
+	}
+
+	out = overlapReportOutput{}
+	if err := uc.Interact(ctx, overlapReportInput{IncludeIsolated: true}, &out); err != nil {
+		t.Fatalf("Interact(include_isolated): %v", err)
+	}
+	// dev-lab overlaps prod-vpc (cross-environment) and dev-vpc (same environment); lab-a overlaps no other allocation.
+	if out.Total != 4 {
+		t.Errorf("Total with include_isolated = %d, want 4: %+v", out.Total, out.Conflicts)
+	}
+}
+
+func TestConnectivityCheck(t *testing.T) {
+	s, ctx, blocks := setupOverlapTest(t)
+	uc := NewConnectivityCheckUseCase(s)
+
+	tests := []struct {
+		name        string
+		blocks      []string
+		connectable bool
+		conflicts   int
+	}{
+		{name: "disjoint", blocks: []string{"prod-vpc", "shared"}, connectable: true},
+		{name: "overlapping", blocks: []string{"prod-vpc", "dev-vpc", "shared"}, connectable: false, conflicts: 1},
+		{name: "isolated still checked", blocks: []string{"prod-vpc", "dev-vpc", "dev-lab"}, connectable: false, conflicts: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var input connectivityCheckInput
+			for _, name := range tt.blocks {
+				input.BlockIDs = append(input.BlockIDs, blocks[name].ID)
+			}
+			var out connectivityCheckOutput
+			if err := uc.Interact(ctx, input, &out); err != nil {
+				t.Fatalf("Interact: %v", err)
+			}
+			if out.Connectable != tt.connectable || len(out.Conflicts) != tt.conflicts {
+				t.Errorf("connectable = %v with %d conflicts, want %v with %d", out.Connectable, len(out.Conflicts), tt.connectable, tt.conflicts)
+			}
+		})
+	}
+
+	t.Run("other org block not found", func(t *testing.T) {
+		other := &network.Block{Name: "other", CIDR: "192.168.0.0/24", OrganizationID: uuid.New()}
+		if err := s.CreateBlock(other); err != nil {
+			t.Fatal(err)
+		}
+		var out connectivityCheckOutput
+		err := uc.Interact(ctx, connectivityCheckInput{BlockIDs: []uuid.UUID{blocks["shared"].ID, other.ID}}, &out)
+		if err == nil {
+			t.Error("Interact with another org's block: want error")
+		}
+	})
+}
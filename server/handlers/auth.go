@@ -9,6 +9,7 @@ import (
 	"github.com/JakeNeyer/ipam/internal/logger"
 	"github.com/JakeNeyer/ipam/server/auth"
 	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/server/ldapauth"
 	"github.com/JakeNeyer/ipam/server/validation"
 	"github.com/JakeNeyer/ipam/store"
 	"github.com/google/uuid"
@@ -25,10 +26,13 @@ type UserResponse struct {
 	Role           string `json:"role"`
 	TourCompleted  bool   `json:"tour_completed"`
 	OrganizationID string `json:"organization_id,omitempty"`
+	Disabled       bool   `json:"disabled,omitempty"`
+	MFAEnabled     bool   `json:"mfa_enabled"`
+	HasPassword    bool   `json:"has_password"` // false for accounts that sign in with an identity provider
 }
 
 func userToResponse(u *store.User) UserResponse {
-	resp := UserResponse{ID: u.ID.String(), Email: u.Email, Role: u.Role, TourCompleted: u.TourCompleted}
+	resp := UserResponse{ID: u.ID.String(), Email: u.Email, Role: u.Role, TourCompleted: u.TourCompleted, Disabled: u.Disabled, MFAEnabled: u.TOTPEnabled, HasPassword: u.PasswordHash != ""}
 	if u.OrganizationID != uuid.Nil {
 		resp.OrganizationID = u.OrganizationID.String()
 	}
@@ -42,30 +46,38 @@ type loginInput struct {
 }
 
 // loginOutput is the response for POST /api/auth/login. Embeds response.EmbeddedSetter so the use case can set the session cookie.
+// When MFARequired is set there is no user or session yet; MFAToken identifies the login to POST /api/auth/login/mfa.
 type loginOutput struct {
 	response.EmbeddedSetter
-	User UserResponse `json:"user"`
+	User                  *UserResponse `json:"user,omitempty"`
+	MFARequired           bool          `json:"mfa_required,omitempty"`
+	MFAToken              string        `json:"mfa_token,omitempty"`
+	MFAEnrollmentRequired bool          `json:"mfa_enrollment_required,omitempty"`
 }
 
 // NewLoginUseCase returns a use case for POST /api/auth/login.
 // If limiter is non-nil, failed login attempts per client IP are limited to mitigate brute-force.
-// If cfg has any OAuth providers enabled, password login is rejected except when the user is the only one in the system (e.g. right after setup).
-func NewLoginUseCase(s store.Storer, limiter *auth.LoginAttemptLimiter, cfg *config.Config) usecase.Interactor {
-	u := usecase.NewInteractor(func(ctx context.Context, input loginInput, output *loginOutput) error {
-		if cfg != nil && len(cfg.EnabledOAuthProviders()) > 0 {
-			allowSetupLogin := false
-			if u, err := s.GetUserByEmail(strings.TrimSpace(strings.ToLower(input.Email))); err == nil {
-				users, listErr := s.ListUsers(nil)
-				if listErr == nil && len(users) == 1 && users[0].ID == u.ID {
-					allowSetupLogin = true
-				}
-			}
-			if !allowSetupLogin {
-				return status.Wrap(errors.New("password login is disabled; sign in with your provider"), status.PermissionDenied)
+// If directory is non-nil, the password is first checked against LDAP; local passwords are the fallback.
+// If cfg has any OAuth providers enabled, local password login is rejected except when the user is the only one in the system (e.g. right after setup).
+func NewLoginUseCase(s store.Storer, limiter *auth.LoginAttemptLimiter, cfg *config.Config, directory *ldapauth.Authenticator) usecase.Interactor {
+	localLoginDisabled := func(email string) bool {
+		if cfg == nil || len(cfg.EnabledOAuthProviders()) == 0 {
+			return false
+		}
+		if u, err := s.GetUserByEmail(strings.TrimSpace(strings.ToLower(email))); err == nil {
+			users, listErr := s.ListUsers(nil)
+			if listErr == nil && len(users) == 1 && users[0].ID == u.ID {
+				return false
 			}
 		}
+		return true
+	}
+	u := usecase.NewInteractor(func(ctx context.Context, input loginInput, output *loginOutput) error {
+		if directory == nil && localLoginDisabled(input.Email) {
+			return status.Wrap(errors.New("password login is disabled; sign in with your provider"), status.PermissionDenied)
+		}
 		r := auth.RequestFromContext(ctx)
-		ip := auth.ClientIP(r)
+		ip := limiter.ClientIP(r)
 		if limiter != nil && limiter.IsBlocked(ip) {
 			logger.Info("login blocked: too many attempts", logger.KeyOperation, "login", "ip", ip)
 			return status.Wrap(errors.New("too many failed login attempts; try again later"), status.ResourceExhausted)
@@ -85,48 +97,149 @@ func NewLoginUseCase(s store.Storer, limiter *auth.LoginAttemptLimiter, cfg *con
 			}
 			return status.Wrap(errors.New("password must be at least 8 characters"), status.InvalidArgument)
 		}
-		user, err := s.GetUserByEmail(strings.TrimSpace(strings.ToLower(input.Email)))
-		if err != nil {
-			logger.Error(logger.MsgAuthInvalidCreds, logger.KeyOperation, "login", logger.ErrAttr(err))
-			if limiter != nil {
-				limiter.RecordFailure(ip)
+		var user *store.User
+		if directory != nil {
+			var err error
+			user, err = directoryLogin(s, directory, cfg, input.Email, input.Password)
+			if errors.Is(err, errNoGroupAccess) {
+				return status.Wrap(errors.New("your directory groups do not grant access"), status.PermissionDenied)
+			}
+			if err != nil && !errors.Is(err, ldapauth.ErrInvalidCredentials) {
+				// The directory is down or misconfigured: local users can still sign in.
+				logger.Error("ldap login failed", logger.KeyOperation, "login", logger.ErrAttr(err))
+			}
+			if user == nil && localLoginDisabled(input.Email) {
+				if limiter != nil {
+					limiter.RecordFailure(ip)
+				}
+				return status.Wrap(errors.New("invalid email or password"), status.Unauthenticated)
 			}
-			return status.Wrap(errors.New("invalid email or password"), status.Unauthenticated)
 		}
-		if user.PasswordHash == "" {
-			logger.Info(logger.MsgAuthInvalidCreds, logger.KeyOperation, "login", logger.KeyEmail, input.Email)
-			if limiter != nil {
-				limiter.RecordFailure(ip)
+		if user == nil {
+			var err error
+			if user, err = localLogin(s, input.Email, input.Password); err != nil {
+				if limiter != nil {
+					limiter.RecordFailure(ip)
+				}
+				return err
 			}
-			return status.Wrap(errors.New("invalid email or password"), status.Unauthenticated)
 		}
-		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.Password)); err != nil {
-			logger.Info(logger.MsgAuthPasswordMismatch, logger.KeyOperation, "login", logger.KeyEmail, input.Email)
-			if limiter != nil {
-				limiter.RecordFailure(ip)
+		if user.Disabled {
+			logger.Info("login refused: account disabled", logger.KeyOperation, "login", logger.KeyUserID, user.ID.String())
+			return status.Wrap(errors.New("account is disabled"), status.PermissionDenied)
+		}
+		if user.TOTPEnabled {
+			// The password was right; the failure count is only reset once the second factor is too.
+			challenge := auth.NewSessionID()
+			if err := s.CreateMFAChallenge(challenge, user.ID, time.Now().Add(mfaChallengeDuration)); err != nil {
+				return status.Wrap(err, status.Internal)
 			}
-			return status.Wrap(errors.New("invalid email or password"), status.Unauthenticated)
+			logger.Info("login needs second factor", logger.KeyOperation, "login", logger.KeyUserID, user.ID.String())
+			output.MFARequired = true
+			output.MFAToken = challenge
+			return nil
 		}
 		if limiter != nil {
 			limiter.RecordSuccess(ip)
 		}
-		sessionID := auth.NewSessionID()
-		s.CreateSession(sessionID, user.ID, time.Now().Add(auth.SessionDuration))
-		secure := false
-		if r := auth.RequestFromContext(ctx); r != nil && r.TLS != nil {
-			secure = true
-		}
-		auth.SetSessionCookie(output.ResponseWriter(), sessionID, secure)
-		logger.Info("login success", logger.KeyOperation, "login", logger.KeyUserID, user.ID.String(), logger.KeyEmail, user.Email)
-		output.User = userToResponse(user)
+		startSession(ctx, s, cfg, output, user)
 		return nil
 	})
 	u.SetTitle("Login")
-	u.SetDescription("Authenticate with email and password; sets session cookie")
+	u.SetDescription("Authenticate with email and password (checked against LDAP first when configured); sets session cookie. " +
+		"When the user has MFA enabled, no session is started: mfa_required is set and mfa_token must be sent with a code to POST /api/auth/login/mfa")
 	u.SetExpectedErrors(status.InvalidArgument, status.Unauthenticated, status.ResourceExhausted, status.PermissionDenied)
 	return u
 }
 
+// startSession signs user in: it stores a new session and sets its cookie on the use case's response.
+func startSession(ctx context.Context, s store.Storer, cfg *config.Config, output *loginOutput, user *store.User) {
+	r := auth.RequestFromContext(ctx)
+	auth.StartSession(output.ResponseWriter(), r, s, user.ID, sessionConfig(cfg), r != nil && r.TLS != nil)
+	logger.Info("login success", logger.KeyOperation, "login", logger.KeyUserID, user.ID.String(), logger.KeyEmail, user.Email)
+	resp := userToResponse(user)
+	output.User = &resp
+	output.MFAEnrollmentRequired = auth.MFAEnrollmentRequired(s, user)
+}
+
+// sessionConfig returns the session timeouts from cfg, which is nil in some tests.
+func sessionConfig(cfg *config.Config) config.SessionConfig {
+	if cfg == nil {
+		return config.SessionConfig{}
+	}
+	return cfg.Session
+}
+
+// localLogin checks password against the user's local bcrypt hash.
+func localLogin(s store.Storer, email, password string) (*store.User, error) {
+	user, err := s.GetUserByEmail(strings.TrimSpace(strings.ToLower(email)))
+	if err != nil {
+		logger.Error(logger.MsgAuthInvalidCreds, logger.KeyOperation, "login", logger.ErrAttr(err))
+		return nil, status.Wrap(errors.New("invalid email or password"), status.Unauthenticated)
+	}
+	if user.PasswordHash == "" {
+		logger.Info(logger.MsgAuthInvalidCreds, logger.KeyOperation, "login", logger.KeyEmail, email)
+		return nil, status.Wrap(errors.New("invalid email or password"), status.Unauthenticated)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		logger.Info(logger.MsgAuthPasswordMismatch, logger.KeyOperation, "login", logger.KeyEmail, email)
+		return nil, status.Wrap(errors.New("invalid email or password"), status.Unauthenticated)
+	}
+	return user, nil
+}
+
+// directoryLogin authenticates against LDAP and returns the IPAM account for the directory user: the one linked to
+// its DN, else (with LDAP_ALLOW_EMAIL_MATCH) the unlinked one with its email, which is then linked, else a new one
+// when group mappings grant access. It returns a nil user and no error when the directory user has no account, or
+// when its email belongs to an account it may not sign in to.
+func directoryLogin(s store.Storer, directory *ldapauth.Authenticator, cfg *config.Config, email, password string) (*store.User, error) {
+	du, err := directory.Authenticate(strings.TrimSpace(strings.ToLower(email)), password)
+	if err != nil {
+		return nil, err
+	}
+	access, err := resolveGroupMappings(s, cfg.LDAP.GroupMappings, du.Groups)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.GetUserByOAuth(ldapProvider, du.DN)
+	if err != nil {
+		if existing, lookupErr := s.GetUserByEmail(du.Email); lookupErr == nil {
+			// Whoever controls the directory entry would take over the local account, so only link when allowed.
+			if !cfg.LDAP.AllowEmailMatch || (existing.OAuthProvider != "" && existing.OAuthProviderUserID != "") {
+				logger.Info("ldap user matches an account it is not linked to", logger.KeyOperation, "login", logger.KeyEmail, du.Email)
+				return nil, nil
+			}
+			if err := s.SetUserOAuth(existing.ID, ldapProvider, du.DN); err != nil {
+				return nil, err
+			}
+			user, err = existing, nil
+		}
+	}
+	if err != nil {
+		if access == nil {
+			logger.Info("ldap user has no account", logger.KeyOperation, "login", logger.KeyEmail, du.Email)
+			return nil, nil
+		}
+		user = &store.User{
+			Email:               du.Email,
+			Role:                access.role,
+			OrganizationID:      access.orgID,
+			OAuthProvider:       ldapProvider,
+			OAuthProviderUserID: du.DN,
+		}
+		if err := s.CreateUser(user); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyGroupAccess(s, user, access); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ldapProvider is the OAuth provider recorded on accounts linked to a directory entry; the provider user id is the DN.
+const ldapProvider = "ldap"
+
 // logoutOutput holds the response writer so the use case can clear the session cookie. No body (204).
 type logoutOutput struct {
 	response.EmbeddedSetter
@@ -142,7 +255,9 @@ func NewLogoutUseCase(s store.Storer) usecase.Interactor {
 				secure = true
 			}
 			if c, err := r.Cookie(auth.SessionCookieName); err == nil && c != nil && c.Value != "" {
-				s.DeleteSession(c.Value)
+				if err := s.DeleteSession(c.Value); err != nil {
+					return status.Wrap(err, status.Internal)
+				}
 			}
 		}
 		auth.ClearSessionCookie(output.ResponseWriter(), secure)
@@ -150,22 +265,26 @@ func NewLogoutUseCase(s store.Storer) usecase.Interactor {
 	})
 	u.SetTitle("Logout")
 	u.SetDescription("Clear session cookie")
+	u.SetExpectedErrors(status.Internal)
 	return u
 }
 
-// meOutput is the response for GET /api/auth/me.
+// meOutput is the response for GET /api/auth/me. MFAEnrollmentRequired means the API is blocked until the user
+// enrolls TOTP.
 type meOutput struct {
-	User UserResponse `json:"user"`
+	User                  UserResponse `json:"user"`
+	MFAEnrollmentRequired bool         `json:"mfa_enrollment_required,omitempty"`
 }
 
 // NewMeUseCase returns a use case for GET /api/auth/me.
-func NewMeUseCase() usecase.Interactor {
+func NewMeUseCase(s store.Storer) usecase.Interactor {
 	u := usecase.NewInteractor(func(ctx context.Context, input struct{}, output *meOutput) error {
 		user := auth.UserFromContext(ctx)
 		if user == nil {
 			return status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
 		}
 		output.User = userToResponse(user)
+		output.MFAEnrollmentRequired = auth.MFAEnrollmentRequired(s, user)
 		return nil
 	})
 	u.SetTitle("Get current user")
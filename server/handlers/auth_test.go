@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/server/ldapauth"
+	"github.com/JakeNeyer/ipam/server/ldapauth/ldaptest"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/swaggest/usecase/status"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordLogin runs the login use case and returns the session cookie it set, if any.
+func passwordLogin(t *testing.T, s store.Storer, cfg *config.Config, directory *ldapauth.Authenticator, email, password string) (*loginOutput, *http.Cookie, error) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	out := &loginOutput{}
+	out.SetResponseWriter(rec)
+	ctx := auth.WithRequest(context.Background(), httptest.NewRequest(http.MethodPost, "/api/auth/login", nil))
+	err := NewLoginUseCase(s, nil, cfg, directory).Interact(ctx, loginInput{Email: email, Password: password}, out)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == auth.SessionCookieName && c.Value != "" {
+			return out, c, err
+		}
+	}
+	return out, nil, err
+}
+
+func TestLogin_LDAP(t *testing.T) {
+	const aliceDN = "uid=alice,ou=people,dc=example,dc=org"
+	dir := ldaptest.NewServer(t,
+		ldaptest.Entry{DN: aliceDN, Password: "alice-password", Attributes: map[string][]string{"mail": {"alice@example.org"}}},
+		ldaptest.Entry{DN: "uid=dave,ou=people,dc=example,dc=org", Password: "dave-password", Attributes: map[string][]string{"mail": {"dave@example.org"}}},
+		ldaptest.Entry{DN: "cn=netops,ou=groups,dc=example,dc=org", Attributes: map[string][]string{"cn": {"netops"}, "member": {aliceDN}}},
+		ldaptest.Entry{DN: "cn=contractors,ou=groups,dc=example,dc=org", Attributes: map[string][]string{"cn": {"contractors"}, "member": {"uid=dave,ou=people,dc=example,dc=org"}}},
+	)
+	s := store.NewStore()
+	acme := &store.Organization{Name: "Acme"}
+	if err := s.CreateOrganization(acme); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("local-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateUser(&store.User{Email: "admin@example.org", PasswordHash: string(hash), Role: store.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{LDAP: config.LDAPConfig{
+		URL:           dir.URL,
+		BaseDN:        "dc=example,dc=org",
+		GroupMappings: testGroupMappings(t, "netops=acme:admin"),
+		AllowInsecure: true,
+	}}
+	directory, err := ldapauth.New(cfg.LDAP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A mapped directory group provisions the account and links it to the entry.
+	out, cookie, err := passwordLogin(t, s, cfg, directory, "alice@example.org", "alice-password")
+	if err != nil || cookie == nil {
+		t.Fatalf("directory login: err = %v, cookie = %v", err, cookie)
+	}
+	alice, err := s.GetUserByOAuth("ldap", aliceDN)
+	if err != nil || alice.OrganizationID != acme.ID || alice.Role != store.RoleAdmin || out.User.ID != alice.ID.String() {
+		t.Fatalf("provisioned user = %+v, %v; want admin in Acme", alice, err)
+	}
+	if alice.PasswordHash != "" {
+		t.Error("directory user got a local password")
+	}
+
+	// Local users still sign in when the directory does not know them.
+	if _, cookie, err := passwordLogin(t, s, cfg, directory, "admin@example.org", "local-password"); err != nil || cookie == nil {
+		t.Fatalf("local login: err = %v, cookie = %v", err, cookie)
+	}
+
+	if _, cookie, err := passwordLogin(t, s, cfg, directory, "alice@example.org", "wrong-password"); !errors.Is(err, status.Unauthenticated) || cookie != nil {
+		t.Errorf("wrong directory password: err = %v, cookie = %v", err, cookie)
+	}
+
+	// Directory users whose groups are not mapped are refused.
+	if _, cookie, err := passwordLogin(t, s, cfg, directory, "dave@example.org", "dave-password"); !errors.Is(err, status.PermissionDenied) || cookie != nil {
+		t.Errorf("unmapped groups: err = %v, cookie = %v", err, cookie)
+	}
+}
+
+func TestLogin_LDAPUnavailableFallsBackToLocal(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "ldap://" + ln.Addr().String()
+	_ = ln.Close()
+
+	s := store.NewStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("local-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateUser(&store.User{Email: "admin@example.org", PasswordHash: string(hash), Role: store.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{LDAP: config.LDAPConfig{URL: url, BaseDN: "dc=example,dc=org", AllowInsecure: true}}
+	directory, err := ldapauth.New(cfg.LDAP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, cookie, err := passwordLogin(t, s, cfg, directory, "admin@example.org", "local-password"); err != nil || cookie == nil {
+		t.Fatalf("local login with directory down: err = %v, cookie = %v", err, cookie)
+	}
+	if _, _, err := passwordLogin(t, s, cfg, directory, "admin@example.org", "wrong-password"); !errors.Is(err, status.Unauthenticated) {
+		t.Errorf("wrong local password: err = %v", err)
+	}
+}
+
+func TestLogin_LDAPEmailMatch(t *testing.T) {
+	const bobDN = "uid=bob,ou=people,dc=example,dc=org"
+	dir := ldaptest.NewServer(t,
+		ldaptest.Entry{DN: bobDN, Password: "directory-password", Attributes: map[string][]string{"mail": {"bob@example.org"}}},
+		ldaptest.Entry{DN: "cn=netops,ou=groups,dc=example,dc=org", Attributes: map[string][]string{"cn": {"netops"}, "member": {bobDN}}},
+	)
+	s := store.NewStore()
+	acme := &store.Organization{Name: "Acme"}
+	if err := s.CreateOrganization(acme); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("local-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := &store.User{Email: "bob@example.org", PasswordHash: string(hash), Role: store.RoleUser, OrganizationID: acme.ID}
+	if err := s.CreateUser(bob); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{LDAP: config.LDAPConfig{
+		URL:           dir.URL,
+		BaseDN:        "dc=example,dc=org",
+		GroupMappings: testGroupMappings(t, "netops=acme:admin"),
+		AllowInsecure: true,
+	}}
+	directory, err := ldapauth.New(cfg.LDAP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// By default the directory entry does not get the local account with its email, even with a mapped group.
+	if _, cookie, err := passwordLogin(t, s, cfg, directory, "bob@example.org", "directory-password"); !errors.Is(err, status.Unauthenticated) || cookie != nil {
+		t.Errorf("email match off: err = %v, cookie = %v", err, cookie)
+	}
+	if _, err := s.GetUserByOAuth("ldap", bobDN); err == nil {
+		t.Error("account linked with email match off")
+	}
+	if u, err := s.GetUserByEmail("bob@example.org"); err != nil || u.ID != bob.ID || u.Role != store.RoleUser {
+		t.Errorf("local account = %+v, %v; want unchanged", u, err)
+	}
+	if _, cookie, err := passwordLogin(t, s, cfg, directory, "bob@example.org", "local-password"); err != nil || cookie == nil {
+		t.Fatalf("local login: err = %v, cookie = %v", err, cookie)
+	}
+
+	cfg.LDAP.AllowEmailMatch = true
+	if _, cookie, err := passwordLogin(t, s, cfg, directory, "bob@example.org", "directory-password"); err != nil || cookie == nil {
+		t.Fatalf("email match on: err = %v, cookie = %v", err, cookie)
+	}
+	if u, err := s.GetUserByOAuth("ldap", bobDN); err != nil || u.ID != bob.ID {
+		t.Errorf("linked user = %+v, %v; want %s", u, err, bob.ID)
+	}
+}
@@ -21,34 +21,16 @@ func blockNamesMatch(a, b string) bool {
 	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
 }
 
-// derivedBlockUsage returns total, used, available (as strings) and utilization percent for a block.
-// All values are derived from CIDR; used is the sum of allocation sizes for this block.
-func derivedBlockUsage(s store.Storer, blockName string, blockCIDR string, orgID *uuid.UUID) (totalStr, usedStr, availableStr string, utilPercent float64) {
+// blockUsage returns total, used, available (as strings) and utilization percent for a block with used addresses
+// allocated (nil means none). Total is derived from the CIDR.
+func blockUsage(blockCIDR string, used *big.Int) (totalStr, usedStr, availableStr string, utilPercent float64) {
 	totalStr = network.CIDRAddressCountString(blockCIDR)
 	total, err := network.CIDRAddressCount(blockCIDR)
 	if err != nil {
 		return totalStr, "0", totalStr, 0
 	}
-	var allocs []*network.Allocation
-	var listErr error
-	if orgID != nil {
-		allocs, _, listErr = s.ListAllocationsFiltered("", blockName, uuid.Nil, orgID, "", nil, 0, 0)
-	} else {
-		allocs, listErr = s.ListAllocations()
-	}
-	if listErr != nil {
-		return totalStr, "0", totalStr, 0
-	}
-	used := new(big.Int)
-	for _, a := range allocs {
-		if !blockNamesMatch(a.Block.Name, blockName) {
-			continue
-		}
-		c, err := network.CIDRAddressCount(a.Block.CIDR)
-		if err != nil {
-			continue
-		}
-		used.Add(used, c)
+	if used == nil {
+		used = new(big.Int)
 	}
 	usedStr = used.String()
 	available := new(big.Int).Sub(total, used)
@@ -67,6 +49,33 @@ func derivedBlockUsage(s store.Storer, blockName string, blockCIDR string, orgID
 	return totalStr, usedStr, availableStr, utilPercent
 }
 
+// blocksUsedAddresses returns the allocated address count of each block, keyed by block ID, in one store call. On
+// error every block reports zero used, as a single-block lookup would.
+func blocksUsedAddresses(s store.Storer, blocks []*network.Block) map[uuid.UUID]*big.Int {
+	used, err := s.BlockUsedAddresses(blocks)
+	if err != nil {
+		return map[uuid.UUID]*big.Int{}
+	}
+	return used
+}
+
+// derivedBlockUsage returns total, used, available (as strings) and utilization percent for a block.
+// Used is the sum of allocation sizes for this block.
+func derivedBlockUsage(s store.Storer, block *network.Block) (totalStr, usedStr, availableStr string, utilPercent float64) {
+	used := blocksUsedAddresses(s, []*network.Block{block})
+	return blockUsage(block.CIDR, used[block.ID])
+}
+
+// pendingCloudDelete reports whether deleting a resource with this connection and external ID must be a soft delete:
+// a read-write integration with IPAM conflict resolution deletes it in the cloud on the next sync, then removes the row.
+func pendingCloudDelete(s store.Storer, connectionID *uuid.UUID, externalID string) bool {
+	if connectionID == nil || *connectionID == uuid.Nil || externalID == "" {
+		return false
+	}
+	conn, err := s.GetCloudConnection(*connectionID)
+	return err == nil && conn.SyncMode == "read_write" && conn.ConflictResolution == "ipam"
+}
+
 // CreateBlock handler
 func NewCreateBlockUseCase(s store.Storer) usecase.Interactor {
 	u := usecase.NewInteractor(func(ctx context.Context, input createBlockInput, output *blockOutput) error {
@@ -75,7 +84,7 @@ func NewCreateBlockUseCase(s store.Storer) usecase.Interactor {
 		}
 
 		if valid := network.ValidateCIDR(input.CIDR); !valid {
-			return status.Wrap(errors.New("invalid CIDR format"), status.InvalidArgument)
+			return status.Wrap(errInvalidCIDR, status.InvalidArgument)
 		}
 
 		user := auth.UserFromContext(ctx)
@@ -83,10 +92,10 @@ func NewCreateBlockUseCase(s store.Storer) usecase.Interactor {
 		if user != nil && input.EnvironmentID != uuid.Nil {
 			env, err := s.GetEnvironment(input.EnvironmentID)
 			if err != nil {
-				return status.Wrap(errors.New("environment not found"), status.NotFound)
+				return status.Wrap(errEnvironmentNotFound, status.NotFound)
 			}
 			if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-				return status.Wrap(errors.New("environment not found"), status.NotFound)
+				return status.Wrap(errEnvironmentNotFound, status.NotFound)
 			}
 		}
 
@@ -119,7 +128,7 @@ func NewCreateBlockUseCase(s store.Storer) usecase.Interactor {
 			}
 			if !contained {
 				return status.Wrap(
-					fmt.Errorf("block CIDR %s must be contained within pool %q CIDR %s", input.CIDR, pool.Name, pool.CIDR),
+					conflictf(errNotContained, "block CIDR %s must be contained within pool %q CIDR %s", input.CIDR, pool.Name, pool.CIDR),
 					status.InvalidArgument,
 				)
 			}
@@ -133,6 +142,7 @@ func NewCreateBlockUseCase(s store.Storer) usecase.Interactor {
 			EnvironmentID:  input.EnvironmentID,
 			OrganizationID: blockOrgID,
 			PoolID:         input.PoolID,
+			Isolated:       input.Isolated,
 			Usage: network.Usage{
 				TotalIPs:     totalStored,
 				UsedIPs:      0,
@@ -162,7 +172,7 @@ func NewCreateBlockUseCase(s store.Storer) usecase.Interactor {
 					envLabel = "orphaned blocks"
 				}
 				return status.Wrap(
-					fmt.Errorf("CIDR %s overlaps with existing block %q in %s", block.CIDR, other.Name, envLabel),
+					conflictf(errOverlap, "CIDR %s overlaps with existing block %q in %s", block.CIDR, other.Name, envLabel),
 					status.InvalidArgument,
 				)
 			}
@@ -177,7 +187,7 @@ func NewCreateBlockUseCase(s store.Storer) usecase.Interactor {
 			return status.Wrap(err, status.Internal)
 		} else if reserved != nil {
 			return status.Wrap(
-				fmt.Errorf("CIDR %s overlaps reserved block %s", block.CIDR, reserved.CIDR),
+				conflictf(errReservedOverlap, "CIDR %s overlaps reserved block %s", block.CIDR, reserved.CIDR),
 				status.InvalidArgument,
 			)
 		}
@@ -207,7 +217,7 @@ func NewCreateBlockUseCase(s store.Storer) usecase.Interactor {
 			return status.Wrap(err, status.Internal)
 		}
 
-		totalStr, usedStr, availStr, _ := derivedBlockUsage(s, block.Name, block.CIDR, orgID)
+		totalStr, usedStr, availStr, _ := derivedBlockUsage(s, block)
 		output.ID = block.ID
 		output.Name = block.Name
 		output.CIDR = block.CIDR
@@ -220,6 +230,7 @@ func NewCreateBlockUseCase(s store.Storer) usecase.Interactor {
 		output.Provider = block.Provider
 		output.ExternalID = block.ExternalID
 		output.ConnectionID = block.ConnectionID
+		output.Isolated = block.Isolated
 		return nil
 	})
 
@@ -241,10 +252,10 @@ func NewListBlocksUseCase(s store.Storer) usecase.Interactor {
 			envID = &input.EnvironmentID
 			env, err := s.GetEnvironment(input.EnvironmentID)
 			if err != nil {
-				return status.Wrap(errors.New("environment not found"), status.NotFound)
+				return status.Wrap(errEnvironmentNotFound, status.NotFound)
 			}
 			if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-				return status.Wrap(errors.New("environment not found"), status.NotFound)
+				return status.Wrap(errEnvironmentNotFound, status.NotFound)
 			}
 		}
 		orgID := auth.ResolveOrgID(ctx, user, input.OrganizationID)
@@ -281,9 +292,10 @@ func NewListBlocksUseCase(s store.Storer) usecase.Interactor {
 			return status.Wrap(err, status.Internal)
 		}
 		output.Total = total
+		used := blocksUsedAddresses(s, blocks)
 		output.Blocks = make([]*blockOutput, len(blocks))
 		for i, block := range blocks {
-			totalStr, usedStr, availStr, _ := derivedBlockUsage(s, block.Name, block.CIDR, orgID)
+			totalStr, usedStr, availStr, _ := blockUsage(block.CIDR, used[block.ID])
 			output.Blocks[i] = &blockOutput{
 				ID:             block.ID,
 				Name:           block.Name,
@@ -297,6 +309,7 @@ func NewListBlocksUseCase(s store.Storer) usecase.Interactor {
 				Provider:       block.Provider,
 				ExternalID:     block.ExternalID,
 				ConnectionID:   block.ConnectionID,
+				Isolated:       block.Isolated,
 			}
 		}
 		return nil
@@ -313,21 +326,20 @@ func NewGetBlockUseCase(s store.Storer) usecase.Interactor {
 	u := usecase.NewInteractor(func(ctx context.Context, input getBlockInput, output *blockOutput) error {
 		block, err := s.GetBlock(input.ID)
 		if err != nil {
-			return status.Wrap(errors.New("block not found"), status.NotFound)
+			return status.Wrap(errBlockNotFound, status.NotFound)
 		}
 		user := auth.UserFromContext(ctx)
 		if user != nil && block.EnvironmentID != uuid.Nil {
 			env, err := s.GetEnvironment(block.EnvironmentID)
 			if err != nil {
-				return status.Wrap(errors.New("block not found"), status.NotFound)
+				return status.Wrap(errBlockNotFound, status.NotFound)
 			}
 			if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-				return status.Wrap(errors.New("block not found"), status.NotFound)
+				return status.Wrap(errBlockNotFound, status.NotFound)
 			}
 		}
 
-		orgID := auth.ResolveOrgID(ctx, user, uuid.Nil)
-		totalStr, usedStr, availStr, _ := derivedBlockUsage(s, block.Name, block.CIDR, orgID)
+		totalStr, usedStr, availStr, _ := derivedBlockUsage(s, block)
 		output.ID = block.ID
 		output.Name = block.Name
 		output.CIDR = block.CIDR
@@ -340,6 +352,7 @@ func NewGetBlockUseCase(s store.Storer) usecase.Interactor {
 		output.Provider = block.Provider
 		output.ExternalID = block.ExternalID
 		output.ConnectionID = block.ConnectionID
+		output.Isolated = block.Isolated
 		return nil
 	})
 
@@ -354,16 +367,16 @@ func NewUpdateBlockUseCase(s store.Storer) usecase.Interactor {
 	u := usecase.NewInteractor(func(ctx context.Context, input updateBlockInput, output *blockOutput) error {
 		block, err := s.GetBlock(input.ID)
 		if err != nil {
-			return status.Wrap(errors.New("block not found"), status.NotFound)
+			return status.Wrap(errBlockNotFound, status.NotFound)
 		}
 		user := auth.UserFromContext(ctx)
 		if user != nil && block.EnvironmentID != uuid.Nil {
 			env, err := s.GetEnvironment(block.EnvironmentID)
 			if err != nil {
-				return status.Wrap(errors.New("block not found"), status.NotFound)
+				return status.Wrap(errBlockNotFound, status.NotFound)
 			}
 			if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-				return status.Wrap(errors.New("block not found"), status.NotFound)
+				return status.Wrap(errBlockNotFound, status.NotFound)
 			}
 		}
 
@@ -378,6 +391,9 @@ func NewUpdateBlockUseCase(s store.Storer) usecase.Interactor {
 		if input.PoolID != nil {
 			block.PoolID = input.PoolID
 		}
+		if input.Isolated != nil {
+			block.Isolated = *input.Isolated
+		}
 		orgID := auth.ResolveOrgID(ctx, user, uuid.Nil)
 		if block.EnvironmentID == uuid.Nil && block.OrganizationID == uuid.Nil && orgID != nil {
 			block.OrganizationID = *orgID
@@ -400,7 +416,7 @@ func NewUpdateBlockUseCase(s store.Storer) usecase.Interactor {
 			}
 			if !contained {
 				return status.Wrap(
-					fmt.Errorf("block CIDR %s must be contained within pool %q CIDR %s", block.CIDR, pool.Name, pool.CIDR),
+					conflictf(errNotContained, "block CIDR %s must be contained within pool %q CIDR %s", block.CIDR, pool.Name, pool.CIDR),
 					status.InvalidArgument,
 				)
 			}
@@ -428,7 +444,7 @@ func NewUpdateBlockUseCase(s store.Storer) usecase.Interactor {
 					envLabel = "orphaned blocks"
 				}
 				return status.Wrap(
-					fmt.Errorf("CIDR %s overlaps with existing block %q in %s", block.CIDR, other.Name, envLabel),
+					conflictf(errOverlap, "CIDR %s overlaps with existing block %q in %s", block.CIDR, other.Name, envLabel),
 					status.InvalidArgument,
 				)
 			}
@@ -443,7 +459,7 @@ func NewUpdateBlockUseCase(s store.Storer) usecase.Interactor {
 			return status.Wrap(err, status.Internal)
 		} else if reserved != nil {
 			return status.Wrap(
-				fmt.Errorf("CIDR %s overlaps reserved block %s", block.CIDR, reserved.CIDR),
+				conflictf(errReservedOverlap, "CIDR %s overlaps reserved block %s", block.CIDR, reserved.CIDR),
 				status.InvalidArgument,
 			)
 		}
@@ -451,13 +467,7 @@ func NewUpdateBlockUseCase(s store.Storer) usecase.Interactor {
 			return status.Wrap(err, status.Internal)
 		}
 
-		outOrgID := &block.OrganizationID
-		if block.EnvironmentID != uuid.Nil {
-			if env, err := s.GetEnvironment(block.EnvironmentID); err == nil {
-				outOrgID = &env.OrganizationID
-			}
-		}
-		totalStr, usedStr, availStr, _ := derivedBlockUsage(s, block.Name, block.CIDR, outOrgID)
+		totalStr, usedStr, availStr, _ := derivedBlockUsage(s, block)
 		output.ID = block.ID
 		output.Name = block.Name
 		output.CIDR = block.CIDR
@@ -470,6 +480,7 @@ func NewUpdateBlockUseCase(s store.Storer) usecase.Interactor {
 		output.Provider = block.Provider
 		output.ExternalID = block.ExternalID
 		output.ConnectionID = block.ConnectionID
+		output.Isolated = block.Isolated
 		return nil
 	})
 
@@ -486,36 +497,32 @@ func NewDeleteBlockUseCase(s store.Storer) usecase.Interactor {
 	u := usecase.NewInteractor(func(ctx context.Context, input getBlockInput, output *struct{}) error {
 		block, err := s.GetBlock(input.ID)
 		if err != nil {
-			return status.Wrap(errors.New("block not found"), status.NotFound)
+			return status.Wrap(errBlockNotFound, status.NotFound)
 		}
 		user := auth.UserFromContext(ctx)
 		if user != nil && block.EnvironmentID != uuid.Nil {
 			env, err := s.GetEnvironment(block.EnvironmentID)
 			if err != nil {
-				return status.Wrap(errors.New("block not found"), status.NotFound)
+				return status.Wrap(errBlockNotFound, status.NotFound)
 			}
 			if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-				return status.Wrap(errors.New("block not found"), status.NotFound)
+				return status.Wrap(errBlockNotFound, status.NotFound)
 			}
 		}
-		// Soft-delete when block has an external ID and is linked to a read-write connection with IPAM conflict resolution.
-		if block.ConnectionID != nil && *block.ConnectionID != uuid.Nil && block.ExternalID != "" {
-			conn, err := s.GetCloudConnection(*block.ConnectionID)
-			if err == nil && conn.SyncMode == "read_write" && conn.ConflictResolution == "ipam" {
-				allocs, err := s.ListAllocations()
-				if err != nil {
-					return status.Wrap(err, status.Internal)
-				}
-				for _, a := range allocs {
-					if blockNamesMatch(a.Block.Name, block.Name) {
-						_ = s.SoftDeleteAllocation(a.Id)
-					}
-				}
-				if err := s.SoftDeleteBlock(input.ID); err != nil {
-					return status.Wrap(err, status.Internal)
+		if pendingCloudDelete(s, block.ConnectionID, block.ExternalID) {
+			allocs, err := s.ListAllocations()
+			if err != nil {
+				return status.Wrap(err, status.Internal)
+			}
+			for _, a := range allocs {
+				if blockNamesMatch(a.Block.Name, block.Name) {
+					_ = s.SoftDeleteAllocation(a.Id)
 				}
-				return nil
 			}
+			if err := s.SoftDeleteBlock(input.ID); err != nil {
+				return status.Wrap(err, status.Internal)
+			}
+			return nil
 		}
 		allocs, err := s.ListAllocations()
 		if err != nil {
@@ -527,7 +534,7 @@ func NewDeleteBlockUseCase(s store.Storer) usecase.Interactor {
 			}
 		}
 		if err := s.DeleteBlock(input.ID); err != nil {
-			return status.Wrap(errors.New("block not found"), status.NotFound)
+			return status.Wrap(errBlockNotFound, status.NotFound)
 		}
 		return nil
 	})
@@ -543,21 +550,20 @@ func NewGetBlockUsageUseCase(s store.Storer) usecase.Interactor {
 	u := usecase.NewInteractor(func(ctx context.Context, input getBlockInput, output *blockUsageOutput) error {
 		block, err := s.GetBlock(input.ID)
 		if err != nil {
-			return status.Wrap(errors.New("block not found"), status.NotFound)
+			return status.Wrap(errBlockNotFound, status.NotFound)
 		}
 		user := auth.UserFromContext(ctx)
 		if user != nil && block.EnvironmentID != uuid.Nil {
 			env, err := s.GetEnvironment(block.EnvironmentID)
 			if err != nil {
-				return status.Wrap(errors.New("block not found"), status.NotFound)
+				return status.Wrap(errBlockNotFound, status.NotFound)
 			}
 			if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-				return status.Wrap(errors.New("block not found"), status.NotFound)
+				return status.Wrap(errBlockNotFound, status.NotFound)
 			}
 		}
 
-		orgID := auth.ResolveOrgID(ctx, user, uuid.Nil)
-		totalStr, usedStr, availStr, utilPercent := derivedBlockUsage(s, block.Name, block.CIDR, orgID)
+		totalStr, usedStr, availStr, utilPercent := derivedBlockUsage(s, block)
 		output.Name = block.Name
 		output.CIDR = block.CIDR
 		output.TotalIPs = totalStr
@@ -580,18 +586,22 @@ func NewSuggestBlockCIDRUseCase(s store.Storer) usecase.Interactor {
 		if input.Prefix < 1 || input.Prefix > 32 {
 			return status.Wrap(errors.New("prefix must be between 1 and 32"), status.InvalidArgument)
 		}
+		placement, err := placementFromInput(input.Strategy, input.AlignTo)
+		if err != nil {
+			return err
+		}
 		block, err := s.GetBlock(input.ID)
 		if err != nil {
-			return status.Wrap(errors.New("block not found"), status.NotFound)
+			return status.Wrap(errBlockNotFound, status.NotFound)
 		}
 		user := auth.UserFromContext(ctx)
 		if user != nil && block.EnvironmentID != uuid.Nil {
 			env, err := s.GetEnvironment(block.EnvironmentID)
 			if err != nil {
-				return status.Wrap(errors.New("block not found"), status.NotFound)
+				return status.Wrap(errBlockNotFound, status.NotFound)
 			}
 			if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-				return status.Wrap(errors.New("block not found"), status.NotFound)
+				return status.Wrap(errBlockNotFound, status.NotFound)
 			}
 		}
 
@@ -610,20 +620,9 @@ func NewSuggestBlockCIDRUseCase(s store.Storer) usecase.Interactor {
 		if err != nil {
 			return status.Wrap(err, status.Internal)
 		}
-		for _, r := range reserved {
-			overlap, _ := network.Overlaps(block.CIDR, r.CIDR)
-			if !overlap {
-				continue
-			}
-			contained, _ := network.Contains(block.CIDR, r.CIDR)
-			if contained {
-				allocatedCIDRs = append(allocatedCIDRs, r.CIDR)
-			} else {
-				allocatedCIDRs = append(allocatedCIDRs, block.CIDR)
-			}
-		}
+		allocatedCIDRs = append(allocatedCIDRs, reservedWithin(block.CIDR, reserved)...)
 
-		cidr, err := network.NextAvailableCIDRWithAllocations(block.CIDR, input.Prefix, allocatedCIDRs)
+		cidr, err := network.NextAvailableCIDRWithStrategy(block.CIDR, input.Prefix, allocatedCIDRs, placement)
 		if err != nil {
 			return status.Wrap(err, status.InvalidArgument)
 		}
@@ -633,7 +632,7 @@ func NewSuggestBlockCIDRUseCase(s store.Storer) usecase.Interactor {
 	})
 
 	u.SetTitle("Suggest Block CIDR")
-	u.SetDescription("Suggests the next available CIDR in the block at the given prefix length, bin-packing to fill gaps")
+	u.SetDescription("Suggests the next available CIDR in the block at the given prefix length using the placement strategy (default best_fit, bin-packing to fill gaps)")
 	u.SetExpectedErrors(status.NotFound, status.InvalidArgument, status.Internal)
 	return u
 }
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"sort"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+// loadBlockForChange returns a copy of the block for resize, split, merge or move. Blocks linked to a cloud resource
+// are refused: providers cannot change or re-home a VPC/VNet in place, so the change has to be made there and synced back.
+func loadBlockForChange(ctx context.Context, s store.Storer, id uuid.UUID) (*network.Block, error) {
+	block, err := s.GetBlock(id)
+	if err != nil || !blockInUserOrg(ctx, s, auth.UserFromContext(ctx), block) {
+		return nil, status.Wrap(errors.New("block not found"), status.NotFound)
+	}
+	if block.ConnectionID != nil && block.ExternalID != "" {
+		return nil, status.Wrap(
+			fmt.Errorf("block %q is managed by the %s integration (%s); make this change in the cloud provider and sync", block.Name, block.Provider, block.ExternalID),
+			status.FailedPrecondition,
+		)
+	}
+	b := *block
+	return &b, nil
+}
+
+// blockOrgID returns the organization a block belongs to (blocks in an environment take the environment's org).
+func blockOrgID(s store.Storer, block *network.Block) uuid.UUID {
+	if block.EnvironmentID != uuid.Nil {
+		if env, err := s.GetEnvironment(block.EnvironmentID); err == nil {
+			return env.OrganizationID
+		}
+	}
+	return block.OrganizationID
+}
+
+// blockAllocations returns the allocations in block (allocations reference their block by name).
+func blockAllocations(s store.Storer, block *network.Block, orgID uuid.UUID) ([]*network.Allocation, error) {
+	allocs, _, err := s.ListAllocationsFiltered("", block.Name, uuid.Nil, &orgID, "", nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*network.Allocation, 0, len(allocs))
+	for _, a := range allocs {
+		if blockNamesMatch(a.Block.Name, block.Name) {
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+// validateBlockPlacement checks cidr for block against its pool, the other blocks in its environment (or the org's
+// orphan blocks) except those in ignore, and reserved blocks, with the same messages as create and update.
+func validateBlockPlacement(s store.Storer, block *network.Block, orgID uuid.UUID, cidr string, ignore map[uuid.UUID]bool) error {
+	if block.PoolID != nil && *block.PoolID != uuid.Nil {
+		pool, err := s.GetPool(*block.PoolID)
+		if err != nil {
+			return status.Wrap(errors.New("pool not found"), status.NotFound)
+		}
+		contained, err := network.Contains(pool.CIDR, cidr)
+		if err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+		if !contained {
+			return status.Wrap(
+				fmt.Errorf("block CIDR %s must be contained within pool %q CIDR %s", cidr, pool.Name, pool.CIDR),
+				status.InvalidArgument,
+			)
+		}
+	}
+	var existing []*network.Block
+	var err error
+	envLabel := "the environment"
+	if block.EnvironmentID != uuid.Nil {
+		existing, err = s.ListBlocksByEnvironment(block.EnvironmentID)
+	} else {
+		envLabel = "orphaned blocks"
+		existing, _, err = s.ListBlocksFiltered("", nil, nil, &block.OrganizationID, true, "", nil, 0, 0)
+	}
+	if err != nil {
+		return status.Wrap(err, status.Internal)
+	}
+	for _, other := range existing {
+		if ignore[other.ID] {
+			continue
+		}
+		overlap, err := network.Overlaps(cidr, other.CIDR)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		if overlap {
+			return status.Wrap(
+				fmt.Errorf("CIDR %s overlaps with existing block %q in %s", cidr, other.Name, envLabel),
+				status.InvalidArgument,
+			)
+		}
+	}
+	if reserved, err := s.OverlapsReservedBlock(cidr, &orgID); err != nil {
+		return status.Wrap(err, status.Internal)
+	} else if reserved != nil {
+		return status.Wrap(
+			fmt.Errorf("CIDR %s overlaps reserved block %s", cidr, reserved.CIDR),
+			status.InvalidArgument,
+		)
+	}
+	return nil
+}
+
+// checkBlockNameFree rejects name when a block outside ignore already uses it; allocations link to blocks by name,
+// so two blocks with one name would share allocations.
+func checkBlockNameFree(s store.Storer, orgID uuid.UUID, name string, ignore map[uuid.UUID]bool) error {
+	blocks, _, err := s.ListBlocksFiltered(name, nil, nil, &orgID, false, "", nil, 0, 0)
+	if err != nil {
+		return status.Wrap(err, status.Internal)
+	}
+	for _, b := range blocks {
+		if !ignore[b.ID] && blockNamesMatch(b.Name, name) {
+			return status.Wrap(fmt.Errorf("block name %q is already used by block %s", b.Name, b.CIDR), status.InvalidArgument)
+		}
+	}
+	return nil
+}
+
+// plannedBlockOutput renders block as it will be after the change, with usage computed from allocCIDRs
+// (the allocations it will hold) rather than the store, so previews report the result.
+func plannedBlockOutput(block *network.Block, allocCIDRs []string) blockOutput {
+	total, _ := network.CIDRAddressCount(block.CIDR)
+	if total == nil {
+		total = new(big.Int)
+	}
+	used := new(big.Int)
+	for _, c := range allocCIDRs {
+		if n, err := network.CIDRAddressCount(c); err == nil {
+			used.Add(used, n)
+		}
+	}
+	available := new(big.Int).Sub(total, used)
+	if available.Sign() < 0 {
+		available.SetInt64(0)
+	}
+	return blockOutput{
+		ID:             block.ID,
+		Name:           block.Name,
+		CIDR:           block.CIDR,
+		TotalIPs:       total.String(),
+		UsedIPs:        used.String(),
+		Available:      available.String(),
+		EnvironmentID:  block.EnvironmentID,
+		OrganizationID: block.OrganizationID,
+		PoolID:         block.PoolID,
+		Provider:       block.Provider,
+		ExternalID:     block.ExternalID,
+		ConnectionID:   block.ConnectionID,
+		Isolated:       block.Isolated,
+	}
+}
+
+func setBlockCIDR(block *network.Block, cidr string) {
+	total := int(network.CIDRAddressCountInt64(cidr))
+	block.CIDR = cidr
+	block.Usage = network.Usage{TotalIPs: total, AvailableIPs: total}
+}
+
+// NewResizeBlockUseCase expands or shrinks a block in place, keeping its ID, name and allocations.
+func NewResizeBlockUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input resizeBlockInput, output *blockChangeOutput) error {
+		block, err := loadBlockForChange(ctx, s, input.ID)
+		if err != nil {
+			return err
+		}
+		if !network.ValidateCIDR(input.CIDR) {
+			return status.Wrap(errors.New("invalid CIDR format"), status.InvalidArgument)
+		}
+		orgID := blockOrgID(s, block)
+		allocs, err := blockAllocations(s, block, orgID)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		allocCIDRs := make([]string, 0, len(allocs))
+		output.Allocations = make([]blockChangeAllocationOutput, 0, len(allocs))
+		for _, a := range allocs {
+			contained, err := network.Contains(input.CIDR, a.Block.CIDR)
+			if err != nil || !contained {
+				return status.Wrap(
+					fmt.Errorf("allocation %q (%s) would fall outside %s", a.Name, a.Block.CIDR, input.CIDR),
+					status.InvalidArgument,
+				)
+			}
+			allocCIDRs = append(allocCIDRs, a.Block.CIDR)
+			output.Allocations = append(output.Allocations, blockChangeAllocationOutput{ID: a.Id, Name: a.Name, CIDR: a.Block.CIDR, FromBlock: block.Name, ToBlock: block.Name})
+		}
+		if err := validateBlockPlacement(s, block, orgID, input.CIDR, map[uuid.UUID]bool{block.ID: true}); err != nil {
+			return err
+		}
+
+		setBlockCIDR(block, input.CIDR)
+		if !input.Preview {
+			if err := s.UpdateBlock(block.ID, block); err != nil {
+				return status.Wrap(err, status.Internal)
+			}
+		}
+		output.Preview = input.Preview
+		output.Blocks = []blockOutput{plannedBlockOutput(block, allocCIDRs)}
+		return nil
+	})
+
+	u.SetTitle("Resize Block")
+	u.SetDescription("Changes a block's CIDR (e.g. /20 to /19) keeping its ID, name and allocations. " +
+		"The new CIDR must stay inside the block's pool, must not overlap other blocks or reserved blocks, and must contain every allocation. " +
+		"Set preview to validate without changing anything. Blocks managed by a cloud integration are refused.")
+	u.SetExpectedErrors(status.InvalidArgument, status.NotFound, status.FailedPrecondition, status.Internal)
+	return u
+}
+
+// NewSplitBlockUseCase replaces a block with equal-sized children and moves each allocation into the child holding it.
+func NewSplitBlockUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input splitBlockInput, output *blockChangeOutput) error {
+		block, err := loadBlockForChange(ctx, s, input.ID)
+		if err != nil {
+			return err
+		}
+		cidrs, err := network.SplitCIDR(block.CIDR, input.Parts)
+		if err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+		names := input.Names
+		if len(names) == 0 {
+			names = make([]string, len(cidrs))
+			for i := range cidrs {
+				names[i] = fmt.Sprintf("%s-%d", block.Name, i+1)
+			}
+		}
+		if len(names) != len(cidrs) {
+			return status.Wrap(fmt.Errorf("names must have one entry per part (%d)", len(cidrs)), status.InvalidArgument)
+		}
+		orgID := blockOrgID(s, block)
+		ignore := map[uuid.UUID]bool{block.ID: true}
+		children := make([]*network.Block, len(cidrs))
+		for i, cidr := range cidrs {
+			if names[i] == "" {
+				return status.Wrap(errors.New("names must not be empty"), status.InvalidArgument)
+			}
+			for _, prev := range names[:i] {
+				if blockNamesMatch(prev, names[i]) {
+					return status.Wrap(fmt.Errorf("name %q is used more than once", names[i]), status.InvalidArgument)
+				}
+			}
+			if err := checkBlockNameFree(s, orgID, names[i], ignore); err != nil {
+				return err
+			}
+			child := *block
+			child.ID = s.GenerateID()
+			child.Name = names[i]
+			child.Children = []network.Block{}
+			setBlockCIDR(&child, cidr)
+			if err := validateBlockPlacement(s, &child, orgID, cidr, ignore); err != nil {
+				return err
+			}
+			children[i] = &child
+		}
+
+		allocs, err := blockAllocations(s, block, orgID)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		changes := &store.BulkChanges{DeleteBlocks: []uuid.UUID{block.ID}, CreateBlocks: children}
+		childAllocs := make([][]string, len(children))
+		output.Allocations = make([]blockChangeAllocationOutput, 0, len(allocs))
+		for _, a := range allocs {
+			target := -1
+			for i, child := range children {
+				if contained, _ := network.Contains(child.CIDR, a.Block.CIDR); contained {
+					target = i
+					break
+				}
+			}
+			if target < 0 {
+				return status.Wrap(
+					fmt.Errorf("allocation %q (%s) does not fit in a single /%d child; split into fewer parts", a.Name, a.Block.CIDR, netip.MustParsePrefix(cidrs[0]).Bits()),
+					status.InvalidArgument,
+				)
+			}
+			moved := *a
+			moved.Block.Name = children[target].Name
+			changes.UpdateAllocations = append(changes.UpdateAllocations, &moved)
+			childAllocs[target] = append(childAllocs[target], a.Block.CIDR)
+			output.Allocations = append(output.Allocations, blockChangeAllocationOutput{ID: a.Id, Name: a.Name, CIDR: a.Block.CIDR, FromBlock: block.Name, ToBlock: moved.Block.Name})
+		}
+
+		if !input.Preview {
+			if err := s.ApplyBulk(changes); err != nil {
+				return status.Wrap(fmt.Errorf("split block: %w", err), status.Internal)
+			}
+		}
+		output.Preview = input.Preview
+		output.RemovedBlockIDs = []uuid.UUID{block.ID}
+		for i, child := range children {
+			output.Blocks = append(output.Blocks, plannedBlockOutput(child, childAllocs[i]))
+		}
+		return nil
+	})
+
+	u.SetTitle("Split Block")
+	u.SetDescription("Replaces a block with parts (a power of two) equal-sized child blocks in the same environment and pool, and moves each allocation into the child that contains it. " +
+		"Fails if an allocation would span two children. Set preview to validate without changing anything. Blocks managed by a cloud integration are refused.")
+	u.SetExpectedErrors(status.InvalidArgument, status.NotFound, status.FailedPrecondition, status.Internal)
+	return u
+}
+
+// NewMergeBlocksUseCase merges adjacent blocks into their supernet. The lowest block keeps its ID; the rest are removed
+// and their allocations move to it.
+func NewMergeBlocksUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input mergeBlocksInput, output *blockChangeOutput) error {
+		var blocks []*network.Block
+		ignore := make(map[uuid.UUID]bool, len(input.BlockIDs))
+		for _, id := range input.BlockIDs {
+			if ignore[id] {
+				continue
+			}
+			block, err := loadBlockForChange(ctx, s, id)
+			if err != nil {
+				return err
+			}
+			ignore[id] = true
+			blocks = append(blocks, block)
+		}
+		if len(blocks) < 2 {
+			return status.Wrap(errors.New("at least two distinct block_ids are required"), status.InvalidArgument)
+		}
+		first := blocks[0]
+		cidrs := make([]string, len(blocks))
+		for i, b := range blocks {
+			samePool := (b.PoolID == nil) == (first.PoolID == nil) && (b.PoolID == nil || *b.PoolID == *first.PoolID)
+			if b.EnvironmentID != first.EnvironmentID || b.OrganizationID != first.OrganizationID || !samePool {
+				return status.Wrap(errors.New("blocks must be in the same environment and pool to merge"), status.InvalidArgument)
+			}
+			cidrs[i] = b.CIDR
+		}
+		supernet, err := network.MergeCIDRs(cidrs)
+		if err != nil {
+			return status.Wrap(fmt.Errorf("cannot merge: %w", err), status.InvalidArgument)
+		}
+		sort.Slice(blocks, func(i, j int) bool {
+			return netip.MustParsePrefix(blocks[i].CIDR).Masked().Addr().Less(netip.MustParsePrefix(blocks[j].CIDR).Masked().Addr())
+		})
+		origNames := make([]string, len(blocks))
+		for i, b := range blocks {
+			origNames[i] = b.Name
+		}
+		merged := blocks[0]
+		if input.Name != "" {
+			merged.Name = input.Name
+		}
+		orgID := blockOrgID(s, merged)
+		if err := checkBlockNameFree(s, orgID, merged.Name, ignore); err != nil {
+			return err
+		}
+		setBlockCIDR(merged, supernet)
+		if err := validateBlockPlacement(s, merged, orgID, supernet, ignore); err != nil {
+			return err
+		}
+
+		changes := &store.BulkChanges{UpdateBlocks: []*network.Block{merged}}
+		var allocCIDRs []string
+		output.Allocations = []blockChangeAllocationOutput{}
+		for i, b := range blocks {
+			if i > 0 {
+				changes.DeleteBlocks = append(changes.DeleteBlocks, b.ID)
+				output.RemovedBlockIDs = append(output.RemovedBlockIDs, b.ID)
+			}
+			from := origNames[i]
+			allocs, err := blockAllocations(s, &network.Block{Name: from}, orgID)
+			if err != nil {
+				return status.Wrap(err, status.Internal)
+			}
+			for _, a := range allocs {
+				if a.Block.Name != merged.Name {
+					moved := *a
+					moved.Block.Name = merged.Name
+					changes.UpdateAllocations = append(changes.UpdateAllocations, &moved)
+				}
+				allocCIDRs = append(allocCIDRs, a.Block.CIDR)
+				output.Allocations = append(output.Allocations, blockChangeAllocationOutput{ID: a.Id, Name: a.Name, CIDR: a.Block.CIDR, FromBlock: from, ToBlock: merged.Name})
+			}
+		}
+
+		if !input.Preview {
+			if err := s.ApplyBulk(changes); err != nil {
+				return status.Wrap(fmt.Errorf("merge blocks: %w", err), status.Internal)
+			}
+		}
+		output.Preview = input.Preview
+		output.Blocks = []blockOutput{plannedBlockOutput(merged, allocCIDRs)}
+		return nil
+	})
+
+	u.SetTitle("Merge Blocks")
+	u.SetDescription("Merges adjacent blocks in the same environment and pool into the supernet they exactly cover (e.g. two /25s into a /24). " +
+		"The lowest block keeps its ID (and its name unless name is set); the others are removed and their allocations move to it. " +
+		"Set preview to validate without changing anything. Blocks managed by a cloud integration are refused.")
+	u.SetExpectedErrors(status.InvalidArgument, status.NotFound, status.FailedPrecondition, status.Internal)
+	return u
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/rest"
+)
+
+func createTestBlock(t *testing.T, s *store.Store, b *network.Block, allocs map[string]string) *network.Block {
+	t.Helper()
+	if err := s.CreateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	for name, cidr := range allocs {
+		a := &network.Allocation{Id: uuid.New(), Name: name, Block: network.Block{Name: b.Name, CIDR: cidr}}
+		if err := s.CreateAllocation(a.Id, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+func wantStatus(t *testing.T, err error, code int, contains string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("err = nil, want %d %q", code, contains)
+	}
+	got, resp := rest.Err(err)
+	if got != code || !strings.Contains(resp.ErrorText, contains) {
+		t.Errorf("err = %d %q, want %d containing %q", got, resp.ErrorText, code, contains)
+	}
+}
+
+func TestResizeBlock(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	pool := &network.Pool{Name: "pool", CIDR: "10.0.0.0/16", EnvironmentID: env.Id, OrganizationID: env.OrganizationID}
+	if err := s.CreatePool(pool); err != nil {
+		t.Fatal(err)
+	}
+	block := createTestBlock(t, s, &network.Block{Name: "vpc", CIDR: "10.0.0.0/20", EnvironmentID: env.Id, PoolID: &pool.ID}, map[string]string{"a": "10.0.4.0/24"})
+	createTestBlock(t, s, &network.Block{Name: "other", CIDR: "10.0.64.0/20", EnvironmentID: env.Id}, nil)
+	if err := s.CreateReservedBlock(&store.ReservedBlock{CIDR: "10.0.128.0/24", OrganizationID: env.OrganizationID}); err != nil {
+		t.Fatal(err)
+	}
+	uc := NewResizeBlockUseCase(s)
+
+	var out blockChangeOutput
+	if err := uc.Interact(ctx, resizeBlockInput{ID: block.ID, CIDR: "10.0.0.0/19", Preview: true}, &out); err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if !out.Preview || len(out.Blocks) != 1 || out.Blocks[0].TotalIPs != "8192" || out.Blocks[0].UsedIPs != "256" {
+		t.Errorf("preview = %+v", out)
+	}
+	if b, _ := s.GetBlock(block.ID); b.CIDR != "10.0.0.0/20" {
+		t.Errorf("preview changed block CIDR to %s", b.CIDR)
+	}
+
+	out = blockChangeOutput{}
+	if err := uc.Interact(ctx, resizeBlockInput{ID: block.ID, CIDR: "10.0.0.0/19"}, &out); err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	if b, _ := s.GetBlock(block.ID); b.CIDR != "10.0.0.0/19" || b.Name != "vpc" {
+		t.Errorf("block after resize = %+v", b)
+	}
+
+	tests := []struct {
+		name, cidr, contains string
+	}{
+		{"drops allocation", "10.0.0.0/22", `allocation "a" (10.0.4.0/24) would fall outside 10.0.0.0/22`},
+		{"sibling overlap", "10.0.0.0/17", `overlaps with existing block "other"`},
+		{"outside pool", "10.0.0.0/15", `must be contained within pool "pool"`},
+		{"invalid", "10.0.0.0/40", "invalid CIDR format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantStatus(t, uc.Interact(ctx, resizeBlockInput{ID: block.ID, CIDR: tt.cidr}, &blockChangeOutput{}), http.StatusBadRequest, tt.contains)
+		})
+	}
+
+	orphan := createTestBlock(t, s, &network.Block{Name: "orphan", CIDR: "10.0.129.0/24", OrganizationID: env.OrganizationID}, nil)
+	wantStatus(t, uc.Interact(ctx, resizeBlockInput{ID: orphan.ID, CIDR: "10.0.128.0/23"}, &blockChangeOutput{}), http.StatusBadRequest, "overlaps reserved block 10.0.128.0/24")
+}
+
+func TestBlockOps_RefuseCloudLinked(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	connID := uuid.New()
+	block := createTestBlock(t, s, &network.Block{Name: "vpc", CIDR: "10.0.0.0/24", EnvironmentID: env.Id, Provider: "aws", ExternalID: "vpc-123", ConnectionID: &connID}, nil)
+	native := createTestBlock(t, s, &network.Block{Name: "next", CIDR: "10.0.1.0/24", EnvironmentID: env.Id}, nil)
+
+	wantStatus(t, NewResizeBlockUseCase(s).Interact(ctx, resizeBlockInput{ID: block.ID, CIDR: "10.0.0.0/23"}, &blockChangeOutput{}), http.StatusPreconditionFailed, "managed by the aws integration (vpc-123)")
+	wantStatus(t, NewSplitBlockUseCase(s).Interact(ctx, splitBlockInput{ID: block.ID, Parts: 2}, &blockChangeOutput{}), http.StatusPreconditionFailed, "managed by the aws integration")
+	wantStatus(t, NewMergeBlocksUseCase(s).Interact(ctx, mergeBlocksInput{BlockIDs: []uuid.UUID{native.ID, block.ID}}, &blockChangeOutput{}), http.StatusPreconditionFailed, "managed by the aws integration")
+	wantStatus(t, NewResizeBlockUseCase(s).Interact(context.Background(), resizeBlockInput{ID: uuid.New(), CIDR: "10.0.0.0/23"}, &blockChangeOutput{}), http.StatusNotFound, "block not found")
+}
+
+func TestSplitBlock(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	block := createTestBlock(t, s, &network.Block{Name: "vpc", CIDR: "10.0.0.0/22", EnvironmentID: env.Id}, map[string]string{
+		"a": "10.0.0.0/25",
+		"b": "10.0.2.0/24",
+		"c": "10.0.3.128/25",
+	})
+	uc := NewSplitBlockUseCase(s)
+
+	var out blockChangeOutput
+	if err := uc.Interact(ctx, splitBlockInput{ID: block.ID, Parts: 4, Preview: true}, &out); err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if blocks, _ := s.ListBlocks(); len(blocks) != 1 {
+		t.Errorf("preview created blocks: %d", len(blocks))
+	}
+
+	out = blockChangeOutput{}
+	if err := uc.Interact(ctx, splitBlockInput{ID: block.ID, Parts: 4}, &out); err != nil {
+		t.Fatalf("split: %v", err)
+	}
+	wantBlocks := map[string]string{"vpc-1": "10.0.0.0/24", "vpc-2": "10.0.1.0/24", "vpc-3": "10.0.2.0/24", "vpc-4": "10.0.3.0/24"}
+	blocks, _ := s.ListBlocks()
+	if len(blocks) != 4 {
+		t.Fatalf("blocks after split = %d, want 4", len(blocks))
+	}
+	for _, b := range blocks {
+		if wantBlocks[b.Name] != b.CIDR || b.EnvironmentID != env.Id {
+			t.Errorf("child %s = %s in %s", b.Name, b.CIDR, b.EnvironmentID)
+		}
+	}
+	wantAllocBlock := map[string]string{"a": "vpc-1", "b": "vpc-3", "c": "vpc-4"}
+	allocs, _ := s.ListAllocations()
+	for _, a := range allocs {
+		if a.Block.Name != wantAllocBlock[a.Name] {
+			t.Errorf("allocation %s in block %q, want %q", a.Name, a.Block.Name, wantAllocBlock[a.Name])
+		}
+	}
+	if len(out.RemovedBlockIDs) != 1 || out.RemovedBlockIDs[0] != block.ID || out.Blocks[2].UsedIPs != "256" {
+		t.Errorf("output = %+v", out)
+	}
+
+	// vpc-3 holds a /24 allocation, so it cannot be halved.
+	var third *network.Block
+	for _, b := range blocks {
+		if b.Name == "vpc-3" {
+			third = b
+		}
+	}
+	wantStatus(t, uc.Interact(ctx, splitBlockInput{ID: third.ID, Parts: 2}, &blockChangeOutput{}), http.StatusBadRequest, `allocation "b" (10.0.2.0/24) does not fit in a single /25 child`)
+	wantStatus(t, uc.Interact(ctx, splitBlockInput{ID: third.ID, Parts: 3}, &blockChangeOutput{}), http.StatusBadRequest, "power of two")
+	wantStatus(t, uc.Interact(ctx, splitBlockInput{ID: third.ID, Parts: 2, Names: []string{"x", "vpc-1"}}, &blockChangeOutput{}), http.StatusBadRequest, `block name "vpc-1" is already used`)
+}
+
+func TestMergeBlocks(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	low := createTestBlock(t, s, &network.Block{Name: "low", CIDR: "10.0.0.0/25", EnvironmentID: env.Id}, map[string]string{"a": "10.0.0.0/26"})
+	high := createTestBlock(t, s, &network.Block{Name: "high", CIDR: "10.0.0.128/25", EnvironmentID: env.Id}, map[string]string{"b": "10.0.0.128/26"})
+	far := createTestBlock(t, s, &network.Block{Name: "far", CIDR: "10.0.2.0/25", EnvironmentID: env.Id}, nil)
+	uc := NewMergeBlocksUseCase(s)
+
+	wantStatus(t, uc.Interact(ctx, mergeBlocksInput{BlockIDs: []uuid.UUID{low.ID, far.ID}}, &blockChangeOutput{}), http.StatusBadRequest, "not adjacent")
+
+	var out blockChangeOutput
+	if err := uc.Interact(ctx, mergeBlocksInput{BlockIDs: []uuid.UUID{high.ID, low.ID}, Name: "merged", Preview: true}, &out); err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if b, _ := s.GetBlock(low.ID); b.CIDR != "10.0.0.0/25" {
+		t.Errorf("preview changed block to %s", b.CIDR)
+	}
+
+	out = blockChangeOutput{}
+	if err := uc.Interact(ctx, mergeBlocksInput{BlockIDs: []uuid.UUID{high.ID, low.ID}, Name: "merged"}, &out); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	b, err := s.GetBlock(low.ID)
+	if err != nil || b.CIDR != "10.0.0.0/24" || b.Name != "merged" {
+		t.Errorf("merged block = %+v, %v", b, err)
+	}
+	if _, err := s.GetBlock(high.ID); err == nil {
+		t.Error("merged-away block still exists")
+	}
+	allocs, _ := s.ListAllocations()
+	for _, a := range allocs {
+		if a.Block.Name != "merged" {
+			t.Errorf("allocation %s in block %q, want merged", a.Name, a.Block.Name)
+		}
+	}
+	if len(out.Blocks) != 1 || out.Blocks[0].UsedIPs != "128" || len(out.RemovedBlockIDs) != 1 || len(out.Allocations) != 2 {
+		t.Errorf("output = %+v", out)
+	}
+}
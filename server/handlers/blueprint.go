@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+func toBlueprintOutput(b *store.Blueprint, output *blueprintOutput) {
+	output.ID = b.ID
+	output.OrganizationID = b.OrganizationID
+	output.Name = b.Name
+	output.Description = b.Description
+	output.Spec = b.Spec
+	output.CreatedAt = b.CreatedAt.Format(time.RFC3339)
+	output.UpdatedAt = b.UpdatedAt.Format(time.RFC3339)
+}
+
+// loadBlueprint returns the blueprint if the caller's organization owns it.
+func loadBlueprint(ctx context.Context, s store.Storer, id uuid.UUID) (*store.Blueprint, error) {
+	b, err := s.GetBlueprint(id)
+	if err != nil {
+		return nil, status.Wrap(errors.New("blueprint not found"), status.NotFound)
+	}
+	if userOrg := auth.UserOrgForAccess(ctx, auth.UserFromContext(ctx)); userOrg != uuid.Nil && b.OrganizationID != userOrg {
+		return nil, status.Wrap(errors.New("blueprint not found"), status.NotFound)
+	}
+	return b, nil
+}
+
+// blueprintStoreErr maps store errors on blueprint writes to API status codes.
+func blueprintStoreErr(err error) error {
+	switch err.Error() {
+	case "blueprint name already exists":
+		return status.Wrap(err, status.AlreadyExists)
+	case "blueprint not found":
+		return status.Wrap(err, status.NotFound)
+	}
+	return status.Wrap(err, status.Internal)
+}
+
+// NewCreateBlueprintUseCase returns a use case for POST /api/blueprints.
+func NewCreateBlueprintUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input createBlueprintInput, output *blueprintOutput) error {
+		user := auth.UserFromContext(ctx)
+		if user == nil {
+			return status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
+		}
+		name := strings.TrimSpace(input.Name)
+		if name == "" {
+			return status.Wrap(errors.New("name is required"), status.InvalidArgument)
+		}
+		if err := input.Spec.Validate(); err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+		orgIDPtr := auth.ResolveOrgID(ctx, user, input.OrganizationID)
+		if orgIDPtr == nil {
+			return status.Wrap(errors.New("organization is required"), status.InvalidArgument)
+		}
+		b := &store.Blueprint{
+			OrganizationID: *orgIDPtr,
+			Name:           name,
+			Description:    strings.TrimSpace(input.Description),
+			Spec:           input.Spec,
+		}
+		if err := s.CreateBlueprint(b); err != nil {
+			return blueprintStoreErr(err)
+		}
+		toBlueprintOutput(b, output)
+		return nil
+	})
+	u.SetTitle("Create Blueprint")
+	u.SetDescription("Creates a reusable network layout for the organization: pools, blocks and allocations described by prefix length and naming pattern " +
+		"({name}, {pool}, {block}, {n}). Instantiate it into a parent pool to allocate everything at once.")
+	u.SetExpectedErrors(status.InvalidArgument, status.AlreadyExists, status.Internal)
+	return u
+}
+
+// NewListBlueprintsUseCase returns a use case for GET /api/blueprints.
+func NewListBlueprintsUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input listBlueprintsInput, output *blueprintListOutput) error {
+		user := auth.UserFromContext(ctx)
+		if user == nil {
+			return status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
+		}
+		list, err := s.ListBlueprints(auth.ResolveOrgID(ctx, user, input.OrganizationID))
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		output.Blueprints = make([]*blueprintOutput, len(list))
+		for i, b := range list {
+			output.Blueprints[i] = &blueprintOutput{}
+			toBlueprintOutput(b, output.Blueprints[i])
+		}
+		return nil
+	})
+	u.SetTitle("List Blueprints")
+	u.SetDescription("Lists the organization's network blueprints")
+	u.SetExpectedErrors(status.Internal)
+	return u
+}
+
+// NewGetBlueprintUseCase returns a use case for GET /api/blueprints/{id}.
+func NewGetBlueprintUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input getBlueprintInput, output *blueprintOutput) error {
+		b, err := loadBlueprint(ctx, s, input.ID)
+		if err != nil {
+			return err
+		}
+		toBlueprintOutput(b, output)
+		return nil
+	})
+	u.SetTitle("Get Blueprint")
+	u.SetDescription("Gets a network blueprint by ID")
+	u.SetExpectedErrors(status.NotFound)
+	return u
+}
+
+// NewUpdateBlueprintUseCase returns a use case for PUT /api/blueprints/{id}. Instances already created are unchanged.
+func NewUpdateBlueprintUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input updateBlueprintInput, output *blueprintOutput) error {
+		existing, err := loadBlueprint(ctx, s, input.ID)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSpace(input.Name)
+		if name == "" {
+			return status.Wrap(errors.New("name is required"), status.InvalidArgument)
+		}
+		if err := input.Spec.Validate(); err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+		b := *existing
+		b.Name = name
+		b.Description = strings.TrimSpace(input.Description)
+		b.Spec = input.Spec
+		if err := s.UpdateBlueprint(b.ID, &b); err != nil {
+			return blueprintStoreErr(err)
+		}
+		toBlueprintOutput(&b, output)
+		return nil
+	})
+	u.SetTitle("Update Blueprint")
+	u.SetDescription("Replaces a blueprint's name, description and layout. Pools, blocks and allocations created from it earlier are not changed.")
+	u.SetExpectedErrors(status.InvalidArgument, status.NotFound, status.AlreadyExists, status.Internal)
+	return u
+}
+
+// NewDeleteBlueprintUseCase returns a use case for DELETE /api/blueprints/{id}.
+func NewDeleteBlueprintUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input getBlueprintInput, output *struct{}) error {
+		if _, err := loadBlueprint(ctx, s, input.ID); err != nil {
+			return err
+		}
+		if err := s.DeleteBlueprint(input.ID); err != nil {
+			return blueprintStoreErr(err)
+		}
+		return nil
+	})
+	u.SetTitle("Delete Blueprint")
+	u.SetDescription("Deletes a blueprint. Pools, blocks and allocations created from it are kept.")
+	u.SetExpectedErrors(status.NotFound, status.Internal)
+	return u
+}
+
+// parentPoolUsed returns the space in parent that is already taken: the organization's other pools (sub-pools of the
+// parent, not the pools containing it), its blocks and its reserved ranges, clipped to the parent.
+func parentPoolUsed(s store.Storer, parent *network.Pool) ([]string, error) {
+	parentPrefix, err := netip.ParsePrefix(parent.CIDR)
+	if err != nil {
+		return nil, status.Wrap(fmt.Errorf("parent pool %q has an invalid CIDR", parent.Name), status.FailedPrecondition)
+	}
+	var taken []netip.Prefix
+	pools, err := s.ListPoolsByOrganization(parent.OrganizationID)
+	if err != nil {
+		return nil, status.Wrap(err, status.Internal)
+	}
+	for _, p := range pools {
+		pp, err := netip.ParsePrefix(p.CIDR)
+		if err != nil || network.NewPrefixSet(pp).ContainsPrefix(parentPrefix) {
+			continue // the parent itself and the pools it is nested in
+		}
+		taken = append(taken, pp)
+	}
+	blocks, _, err := s.ListBlocksFiltered("", nil, nil, &parent.OrganizationID, false, "", nil, 0, 0)
+	if err != nil {
+		return nil, status.Wrap(err, status.Internal)
+	}
+	for _, b := range blocks {
+		if bp, err := netip.ParsePrefix(b.CIDR); err == nil && !b.Isolated {
+			taken = append(taken, bp)
+		}
+	}
+	reserved, err := s.ListReservedBlocks(&parent.OrganizationID)
+	if err != nil {
+		return nil, status.Wrap(err, status.Internal)
+	}
+	for _, r := range reserved {
+		if rp, err := netip.ParsePrefix(r.CIDR); err == nil {
+			taken = append(taken, rp)
+		}
+	}
+	return network.NewPrefixSet(parentPrefix).Intersect(network.NewPrefixSet(taken...)).Strings(), nil
+}
+
+// checkPlannedNames rejects a plan whose blocks collide with each other or with existing blocks in the organization
+// (blocks are addressed by name), or that repeats a pool or allocation name within its parent.
+func checkPlannedNames(s store.Storer, orgID uuid.UUID, plan *network.BlueprintPlan) error {
+	var blocks []network.PlannedBlock
+	pools := make(map[string]bool, len(plan.Pools))
+	for _, p := range plan.Pools {
+		if pools[normalizeBulkName(p.Name)] {
+			return status.Wrap(fmt.Errorf("pool name %q is used more than once", p.Name), status.InvalidArgument)
+		}
+		pools[normalizeBulkName(p.Name)] = true
+		blocks = append(blocks, p.Blocks...)
+	}
+	blocks = append(blocks, plan.Blocks...)
+	seen := make(map[string]bool, len(blocks))
+	for _, b := range blocks {
+		if seen[normalizeBulkName(b.Name)] {
+			return status.Wrap(fmt.Errorf("block name %q is used more than once", b.Name), status.InvalidArgument)
+		}
+		seen[normalizeBulkName(b.Name)] = true
+		if err := checkBlockNameFree(s, orgID, b.Name, nil); err != nil {
+			return err
+		}
+		allocs := make(map[string]bool, len(b.Allocations))
+		for _, a := range b.Allocations {
+			if allocs[normalizeBulkName(a.Name)] {
+				return status.Wrap(fmt.Errorf("allocation name %q is used more than once in block %q", a.Name, b.Name), status.InvalidArgument)
+			}
+			allocs[normalizeBulkName(a.Name)] = true
+		}
+	}
+	return nil
+}
+
+// NewInstantiateBlueprintUseCase returns a use case for POST /api/blueprints/{id}/instantiate. It plans every pool,
+// block and allocation in the blueprint inside the parent pool and creates them in one atomic write, or, with
+// preview, only returns the CIDRs it would use.
+func NewInstantiateBlueprintUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input instantiateBlueprintInput, output *blueprintInstanceOutput) error {
+		bp, err := loadBlueprint(ctx, s, input.ID)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSpace(input.Name)
+		if name == "" {
+			return status.Wrap(errors.New("name is required"), status.InvalidArgument)
+		}
+		parent, err := s.GetPool(input.ParentPoolID)
+		if err != nil || parent.OrganizationID != bp.OrganizationID {
+			return status.Wrap(errors.New("parent pool not found"), status.NotFound)
+		}
+		if parent.ConnectionID != nil && parent.ExternalID != "" {
+			return status.Wrap(
+				fmt.Errorf("pool %q is managed by the %s integration (%s); create its sub-pools in the cloud provider and sync", parent.Name, parent.Provider, parent.ExternalID),
+				status.FailedPrecondition,
+			)
+		}
+
+		used, err := parentPoolUsed(s, parent)
+		if err != nil {
+			return err
+		}
+		plan, err := bp.Spec.Plan(name, parent.CIDR, used)
+		if err != nil {
+			return status.Wrap(err, status.FailedPrecondition)
+		}
+		if err := checkPlannedNames(s, parent.OrganizationID, plan); err != nil {
+			return err
+		}
+
+		changes := &store.BulkChanges{}
+		addBlock := func(b network.PlannedBlock, poolID uuid.UUID) blueprintBlockOutput {
+			block := &network.Block{
+				ID:             s.GenerateID(),
+				Name:           b.Name,
+				CIDR:           b.CIDR,
+				EnvironmentID:  parent.EnvironmentID,
+				OrganizationID: parent.OrganizationID,
+				PoolID:         &poolID,
+			}
+			changes.CreateBlocks = append(changes.CreateBlocks, block)
+			out := blueprintBlockOutput{ID: block.ID, Name: block.Name, CIDR: block.CIDR, Allocations: []blueprintAllocationOutput{}}
+			for _, a := range b.Allocations {
+				alloc := &network.Allocation{Id: s.GenerateID(), Name: a.Name, Block: network.Block{Name: block.Name, CIDR: a.CIDR}}
+				changes.CreateAllocations = append(changes.CreateAllocations, alloc)
+				out.Allocations = append(out.Allocations, blueprintAllocationOutput{ID: alloc.Id, Name: alloc.Name, CIDR: a.CIDR})
+			}
+			return out
+		}
+		output.Pools = make([]blueprintPoolOutput, 0, len(plan.Pools))
+		for _, p := range plan.Pools {
+			pool := &network.Pool{
+				ID:             s.GenerateID(),
+				OrganizationID: parent.OrganizationID,
+				EnvironmentID:  parent.EnvironmentID,
+				Name:           p.Name,
+				CIDR:           p.CIDR,
+				ParentPoolID:   &parent.ID,
+			}
+			changes.CreatePools = append(changes.CreatePools, pool)
+			out := blueprintPoolOutput{ID: pool.ID, Name: pool.Name, CIDR: pool.CIDR, Blocks: []blueprintBlockOutput{}}
+			for _, b := range p.Blocks {
+				out.Blocks = append(out.Blocks, addBlock(b, pool.ID))
+			}
+			output.Pools = append(output.Pools, out)
+		}
+		output.Blocks = make([]blueprintBlockOutput, 0, len(plan.Blocks))
+		for _, b := range plan.Blocks {
+			output.Blocks = append(output.Blocks, addBlock(b, parent.ID))
+		}
+
+		if !input.Preview {
+			if err := s.ApplyBulk(changes); err != nil {
+				return status.Wrap(fmt.Errorf("instantiate blueprint: %w", err), status.Internal)
+			}
+		}
+		output.Preview = input.Preview
+		output.ParentPoolID = parent.ID
+		output.EnvironmentID = parent.EnvironmentID
+		return nil
+	})
+	u.SetTitle("Instantiate Blueprint")
+	u.SetDescription("Allocates every pool, block and allocation in the blueprint from free space in the parent pool (largest first) and creates them all or none. " +
+		"Pools become sub-pools of the parent; top-level blocks go directly in it. name fills {name} in the blueprint's naming patterns. " +
+		"Set preview to see the CIDRs without creating anything.")
+	u.SetExpectedErrors(status.InvalidArgument, status.NotFound, status.FailedPrecondition, status.Internal)
+	return u
+}
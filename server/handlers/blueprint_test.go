@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+)
+
+func TestBlueprints(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	parent := &network.Pool{Name: "region", CIDR: "10.0.0.0/16", EnvironmentID: env.Id, OrganizationID: env.OrganizationID}
+	if err := s.CreatePool(parent); err != nil {
+		t.Fatal(err)
+	}
+	createTestBlock(t, s, &network.Block{Name: "legacy", CIDR: "10.0.0.0/20", EnvironmentID: env.Id, PoolID: &parent.ID}, nil)
+	spec := network.BlueprintSpec{
+		Pools: []network.BlueprintPool{{
+			Name: "{name}-az{n}", PrefixLength: 20, Count: 2,
+			Blocks: []network.BlueprintBlock{{
+				Name: "{pool}-vpc", PrefixLength: 21,
+				Allocations: []network.BlueprintAllocation{{Name: "public-{n}", PrefixLength: 24, Count: 2}},
+			}},
+		}},
+		Blocks: []network.BlueprintBlock{{Name: "{name}-shared", PrefixLength: 24}},
+	}
+
+	var bp blueprintOutput
+	if err := NewCreateBlueprintUseCase(s).Interact(ctx, createBlueprintInput{Name: "three-tier", Spec: spec}, &bp); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, NewCreateBlueprintUseCase(s).Interact(ctx, createBlueprintInput{Name: "Three-Tier", Spec: spec}, &blueprintOutput{}), http.StatusConflict, "already exists")
+	wantStatus(t, NewCreateBlueprintUseCase(s).Interact(ctx, createBlueprintInput{Name: "empty"}, &blueprintOutput{}), http.StatusBadRequest, "at least one pool or block")
+	var list blueprintListOutput
+	if err := NewListBlueprintsUseCase(s).Interact(ctx, listBlueprintsInput{}, &list); err != nil || len(list.Blueprints) != 1 {
+		t.Fatalf("list = %+v, %v", list, err)
+	}
+
+	var preview blueprintInstanceOutput
+	if err := NewInstantiateBlueprintUseCase(s).Interact(ctx, instantiateBlueprintInput{ID: bp.ID, ParentPoolID: parent.ID, Name: "prod", Preview: true}, &preview); err != nil {
+		t.Fatal(err)
+	}
+	if !preview.Preview || len(preview.Pools) != 2 || preview.Pools[0].CIDR != "10.0.16.0/20" || preview.Pools[1].Blocks[0].Name != "prod-az2-vpc" || preview.Blocks[0].CIDR != "10.0.48.0/24" {
+		t.Fatalf("preview = %+v", preview)
+	}
+	if pools, _ := s.ListPoolsByOrganization(env.OrganizationID); len(pools) != 1 {
+		t.Fatalf("preview created pools: %d", len(pools))
+	}
+
+	var inst blueprintInstanceOutput
+	if err := NewInstantiateBlueprintUseCase(s).Interact(ctx, instantiateBlueprintInput{ID: bp.ID, ParentPoolID: parent.ID, Name: "prod"}, &inst); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range inst.Pools {
+		got, err := s.GetPool(p.ID)
+		if err != nil || got.CIDR != p.CIDR || got.ParentPoolID == nil || *got.ParentPoolID != parent.ID || got.EnvironmentID != env.Id {
+			t.Fatalf("pool %s = %+v, %v", p.Name, got, err)
+		}
+		block, err := s.GetBlock(p.Blocks[0].ID)
+		if err != nil || block.PoolID == nil || *block.PoolID != p.ID {
+			t.Fatalf("block %s = %+v, %v", p.Blocks[0].Name, block, err)
+		}
+		for _, a := range p.Blocks[0].Allocations {
+			got, err := s.GetAllocation(a.ID)
+			if err != nil || got.Block.Name != block.Name || got.Block.CIDR != a.CIDR {
+				t.Fatalf("allocation %s = %+v, %v", a.Name, got, err)
+			}
+		}
+	}
+	if shared, err := s.GetBlock(inst.Blocks[0].ID); err != nil || shared.PoolID == nil || *shared.PoolID != parent.ID {
+		t.Fatalf("shared block = %+v, %v", shared, err)
+	}
+
+	// The same instance name would repeat block names; a new name fits in the space left.
+	wantStatus(t, NewInstantiateBlueprintUseCase(s).Interact(ctx, instantiateBlueprintInput{ID: bp.ID, ParentPoolID: parent.ID, Name: "prod"}, &inst), http.StatusBadRequest, `block name "prod-az1-vpc" is already used`)
+	if err := NewInstantiateBlueprintUseCase(s).Interact(ctx, instantiateBlueprintInput{ID: bp.ID, ParentPoolID: parent.ID, Name: "dev"}, &inst); err != nil {
+		t.Fatal(err)
+	}
+	if inst.Pools[0].CIDR != "10.0.64.0/20" || inst.Blocks[0].CIDR != "10.0.49.0/24" {
+		t.Fatalf("second instance = %+v", inst)
+	}
+
+	small := &network.Pool{Name: "small", CIDR: "172.16.0.0/20", EnvironmentID: env.Id, OrganizationID: env.OrganizationID}
+	if err := s.CreatePool(small); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, NewInstantiateBlueprintUseCase(s).Interact(ctx, instantiateBlueprintInput{ID: bp.ID, ParentPoolID: small.ID, Name: "x"}, &inst), http.StatusPreconditionFailed, "does not fit")
+
+	var updated blueprintOutput
+	spec.Blocks = nil
+	if err := NewUpdateBlueprintUseCase(s).Interact(ctx, updateBlueprintInput{ID: bp.ID, Name: "pools-only", Spec: spec}, &updated); err != nil || updated.Name != "pools-only" || len(updated.Spec.Blocks) != 0 {
+		t.Fatalf("update = %+v, %v", updated, err)
+	}
+
+	// Another organization sees neither the blueprint nor can it use it.
+	other := &store.Organization{Name: "Other"}
+	if err := s.CreateOrganization(other); err != nil {
+		t.Fatal(err)
+	}
+	otherCtx := auth.WithUser(context.Background(), &store.User{Email: "other@example.com", Role: store.RoleUser, OrganizationID: other.ID})
+	wantStatus(t, NewGetBlueprintUseCase(s).Interact(otherCtx, getBlueprintInput{ID: bp.ID}, &blueprintOutput{}), http.StatusNotFound, "blueprint not found")
+	wantStatus(t, NewInstantiateBlueprintUseCase(s).Interact(otherCtx, instantiateBlueprintInput{ID: bp.ID, ParentPoolID: parent.ID, Name: "x"}, &inst), http.StatusNotFound, "blueprint not found")
+
+	if err := NewDeleteBlueprintUseCase(s).Interact(ctx, getBlueprintInput{ID: bp.ID}, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, NewGetBlueprintUseCase(s).Interact(ctx, getBlueprintInput{ID: bp.ID}, &blueprintOutput{}), http.StatusNotFound, "blueprint not found")
+}
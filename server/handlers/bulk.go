@@ -0,0 +1,689 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/rest"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+// bulkStaging is a private in-memory store holding the rows a batch touches. Bulk operations run against it through
+// the single-endpoint use cases, so validation and error messages match exactly and overlaps within the batch are
+// seen. Rows are copied from the real store on first use, one scope at a time (an environment's pools and blocks, an
+// organization's orphan blocks and reserved blocks, a block's allocations), together with their versions so
+// ApplyBulk rejects the batch if any of them is written, or a row is added to any of those scopes, in the meantime.
+// Cloud connections are not copied, so nothing is pushed to the cloud while validating.
+type bulkStaging struct {
+	s           store.Storer
+	st          *store.Store
+	orgID       *uuid.UUID // caller's organization scope; nil for an unscoped global admin
+	loaded      map[string]bool
+	envs        map[uuid.UUID]bool
+	pools       map[uuid.UUID]bool
+	blocks      map[uuid.UUID]network.Block
+	allocations map[uuid.UUID]network.Allocation
+	reserved    map[uuid.UUID]store.ReservedBlock
+	versions    map[uuid.UUID]int64
+	scopes      []store.BulkScope
+}
+
+func newBulkStaging(s store.Storer, orgID *uuid.UUID) *bulkStaging {
+	return &bulkStaging{
+		s:           s,
+		st:          store.NewStore(),
+		orgID:       orgID,
+		loaded:      make(map[string]bool),
+		envs:        make(map[uuid.UUID]bool),
+		pools:       make(map[uuid.UUID]bool),
+		blocks:      make(map[uuid.UUID]network.Block),
+		allocations: make(map[uuid.UUID]network.Allocation),
+		reserved:    make(map[uuid.UUID]store.ReservedBlock),
+		versions:    make(map[uuid.UUID]int64),
+	}
+}
+
+// inScope reports whether rows of orgID are visible to the caller.
+func (b *bulkStaging) inScope(orgID uuid.UUID) bool {
+	return b.orgID == nil || *b.orgID == orgID
+}
+
+// once reports whether scope still has to be loaded and marks it loaded.
+func (b *bulkStaging) once(scope string) bool {
+	if b.loaded[scope] {
+		return false
+	}
+	b.loaded[scope] = true
+	return true
+}
+
+// readScope reads the versions of the rows in sc, then the rows themselves with read, and records both so ApplyBulk
+// rejects the batch if the scope changes before it is applied. Reading versions first means a write in between makes
+// ApplyBulk fail instead of going unnoticed; a row inserted in between has no version yet and fails the batch now.
+func (b *bulkStaging) readScope(sc store.BulkScope, read func() (interface{}, []uuid.UUID, error)) (interface{}, error) {
+	versions, err := b.s.BulkScopeRows(sc)
+	if err != nil {
+		return nil, err
+	}
+	rows, ids, err := read()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		v, ok := versions[id]
+		if !ok {
+			return nil, store.ErrBulkConflict
+		}
+		b.versions[id] = v
+	}
+	sc.Rows = versions
+	b.scopes = append(b.scopes, sc)
+	return rows, nil
+}
+
+// stageBlocks copies blocks not yet staged. Caller loads their environment or organization first.
+func (b *bulkStaging) stageBlocks(blocks []network.Block) {
+	for _, block := range blocks {
+		if _, ok := b.blocks[block.ID]; ok {
+			continue
+		}
+		b.blocks[block.ID] = block
+		c := block
+		_ = b.st.CreateBlock(&c)
+	}
+}
+
+func (b *bulkStaging) readBlocks(sc store.BulkScope, list func() ([]*network.Block, error)) error {
+	rows, err := b.readScope(sc, func() (interface{}, []uuid.UUID, error) {
+		blocks, err := list()
+		if err != nil {
+			return nil, nil, err
+		}
+		out := make([]network.Block, len(blocks))
+		ids := make([]uuid.UUID, len(blocks))
+		for i, block := range blocks {
+			out[i], ids[i] = *block, block.ID
+		}
+		return out, ids, nil
+	})
+	if err != nil {
+		return err
+	}
+	b.stageBlocks(rows.([]network.Block))
+	return nil
+}
+
+// loadOrganization stages the organization and its reserved blocks, which every block and allocation is checked against.
+func (b *bulkStaging) loadOrganization(orgID uuid.UUID) error {
+	if orgID == uuid.Nil || !b.inScope(orgID) || !b.once("org:"+orgID.String()) {
+		return nil
+	}
+	org, err := b.s.GetOrganization(orgID)
+	if err != nil {
+		return nil // the use case reports it
+	}
+	o := *org
+	_ = b.st.CreateOrganization(&o)
+	sc := store.BulkScope{Kind: store.BulkScopeReservedBlocks, OrganizationID: orgID}
+	rows, err := b.readScope(sc, func() (interface{}, []uuid.UUID, error) {
+		list, err := b.s.ListReservedBlocks(&orgID)
+		if err != nil {
+			return nil, nil, err
+		}
+		out := make([]store.ReservedBlock, len(list))
+		ids := make([]uuid.UUID, len(list))
+		for i, r := range list {
+			out[i], ids[i] = *r, r.ID
+		}
+		return out, ids, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range rows.([]store.ReservedBlock) {
+		b.reserved[r.ID] = r
+		c := r
+		_ = b.st.CreateReservedBlock(&c)
+	}
+	return nil
+}
+
+// loadEnvironment stages an environment of the caller's organization and its pools.
+func (b *bulkStaging) loadEnvironment(envID uuid.UUID) error {
+	if envID == uuid.Nil || !b.once("env:"+envID.String()) {
+		return nil
+	}
+	env, err := b.s.GetEnvironment(envID)
+	if err != nil || !b.inScope(env.OrganizationID) {
+		return nil // not found, or created in this batch
+	}
+	if err := b.loadOrganization(env.OrganizationID); err != nil {
+		return err
+	}
+	b.envs[env.Id] = true
+	e := *env
+	_ = b.st.CreateEnvironment(&e)
+	pools, err := b.s.ListPoolsByEnvironment(env.Id)
+	if err != nil {
+		return err
+	}
+	for _, pool := range pools {
+		b.pools[pool.ID] = true
+		p := *pool
+		_ = b.st.CreatePool(&p)
+	}
+	return nil
+}
+
+// loadEnvironments stages every environment of orgID and their pools, which import checks names and pool overlaps against.
+func (b *bulkStaging) loadEnvironments(orgID uuid.UUID) error {
+	if !b.once("envs:" + orgID.String()) {
+		return nil
+	}
+	if err := b.loadOrganization(orgID); err != nil {
+		return err
+	}
+	envs, _, err := b.s.ListEnvironmentsFiltered("", &orgID, 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, env := range envs {
+		if err := b.loadEnvironment(env.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSiblings stages the blocks a block in envID (or, without an environment, orphaned in orgID) must not overlap.
+func (b *bulkStaging) loadSiblings(envID, orgID uuid.UUID) error {
+	if envID != uuid.Nil {
+		if err := b.loadEnvironment(envID); err != nil || !b.envs[envID] || !b.once("env-blocks:"+envID.String()) {
+			return err
+		}
+		env, err := b.st.GetEnvironment(envID)
+		if err != nil {
+			return err
+		}
+		sc := store.BulkScope{Kind: store.BulkScopeEnvironmentBlocks, OrganizationID: env.OrganizationID, EnvironmentID: envID}
+		return b.readBlocks(sc, func() ([]*network.Block, error) { return b.s.ListBlocksByEnvironment(envID) })
+	}
+	if orgID == uuid.Nil || !b.inScope(orgID) {
+		return nil
+	}
+	if err := b.loadOrganization(orgID); err != nil || !b.once("orphans:"+orgID.String()) {
+		return err
+	}
+	sc := store.BulkScope{Kind: store.BulkScopeOrphanBlocks, OrganizationID: orgID}
+	return b.readBlocks(sc, func() ([]*network.Block, error) {
+		blocks, _, err := b.s.ListBlocksFiltered("", nil, nil, &orgID, true, "", nil, 0, 0)
+		return blocks, err
+	})
+}
+
+// loadPool stages a pool with its environment.
+func (b *bulkStaging) loadPool(poolID *uuid.UUID) error {
+	if poolID == nil || *poolID == uuid.Nil {
+		return nil
+	}
+	pool, err := b.s.GetPool(*poolID)
+	if err != nil {
+		return nil
+	}
+	return b.loadEnvironment(pool.EnvironmentID)
+}
+
+// blockOrganization returns the organization a block belongs to.
+func (b *bulkStaging) blockOrganization(block *network.Block) uuid.UUID {
+	if block.EnvironmentID != uuid.Nil {
+		if env, err := b.s.GetEnvironment(block.EnvironmentID); err == nil {
+			return env.OrganizationID
+		}
+	}
+	return block.OrganizationID
+}
+
+// loadBlock stages a block, the blocks around it and its allocations.
+func (b *bulkStaging) loadBlock(block *network.Block) error {
+	orgID := b.blockOrganization(block)
+	if !b.inScope(orgID) {
+		return nil
+	}
+	if err := b.loadSiblings(block.EnvironmentID, orgID); err != nil {
+		return err
+	}
+	if !b.once("allocs:" + orgID.String() + ":" + normalizeBulkName(block.Name)) {
+		return nil
+	}
+	sc := store.BulkScope{Kind: store.BulkScopeBlockAllocations, OrganizationID: orgID, BlockName: block.Name}
+	rows, err := b.readScope(sc, func() (interface{}, []uuid.UUID, error) {
+		allocs, _, err := b.s.ListAllocationsFiltered("", block.Name, uuid.Nil, &orgID, "", nil, 0, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		out := make([]network.Allocation, len(allocs))
+		ids := make([]uuid.UUID, len(allocs))
+		for i, a := range allocs {
+			out[i], ids[i] = *a, a.Id
+		}
+		return out, ids, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, a := range rows.([]network.Allocation) {
+		if _, ok := b.allocations[a.Id]; ok {
+			continue
+		}
+		b.allocations[a.Id] = a
+		c := a
+		_ = b.st.CreateAllocation(c.Id, &c)
+	}
+	return nil
+}
+
+// loadBlockByID stages the block id as loadBlock does.
+func (b *bulkStaging) loadBlockByID(id uuid.UUID) error {
+	if !b.once("block:" + id.String()) {
+		return nil
+	}
+	block, err := b.s.GetBlock(id)
+	if err != nil {
+		return nil
+	}
+	return b.loadBlock(block)
+}
+
+// loadBlockNamed stages the blocks named name in the caller's scope as loadBlock does. Allocations refer to blocks by name.
+func (b *bulkStaging) loadBlockNamed(orgID *uuid.UUID, name string) error {
+	scope := "any"
+	if orgID != nil {
+		scope = orgID.String()
+	}
+	if strings.TrimSpace(name) == "" || !b.once("block-name:"+scope+":"+normalizeBulkName(name)) {
+		return nil
+	}
+	blocks, _, err := b.s.ListBlocksFiltered(name, nil, nil, orgID, false, "", nil, 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if blockNamesMatch(block.Name, name) {
+			if err := b.loadBlock(block); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadAllocation stages an allocation with its block.
+func (b *bulkStaging) loadAllocation(id uuid.UUID) error {
+	alloc, err := b.s.GetAllocation(id)
+	if err != nil {
+		return nil
+	}
+	return b.loadBlockNamed(b.orgID, alloc.Block.Name)
+}
+
+// loadReservedBlock stages a reserved block with its organization.
+func (b *bulkStaging) loadReservedBlock(id uuid.UUID) error {
+	r, err := b.s.GetReservedBlock(id)
+	if err != nil {
+		return nil
+	}
+	return b.loadOrganization(r.OrganizationID)
+}
+
+// decodeBulkData decodes an operation's data into the single endpoint's input, rejecting unknown fields like the API does.
+func decodeBulkData(data json.RawMessage, v interface{}) error {
+	if len(data) == 0 {
+		return status.Wrap(errors.New("data is required"), status.InvalidArgument)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return status.Wrap(fmt.Errorf("invalid data: %w", err), status.InvalidArgument)
+	}
+	return nil
+}
+
+// scopeOrg returns orgID, or the caller's organization when it is unset.
+func (b *bulkStaging) scopeOrg(orgID uuid.UUID) uuid.UUID {
+	if orgID == uuid.Nil && b.orgID != nil {
+		return *b.orgID
+	}
+	return orgID
+}
+
+// run applies one operation to the staging store using the matching single-endpoint use case, after staging the rows
+// it reads. It returns the endpoint's output and the ID of the affected resource.
+func (b *bulkStaging) run(ctx context.Context, op bulkOperationInput) (interface{}, uuid.UUID, error) {
+	out, id, err := b.runStaged(ctx, op)
+	if errors.Is(err, store.ErrBulkConflict) {
+		err = status.Wrap(err, status.Aborted)
+	}
+	return out, id, err
+}
+
+func (b *bulkStaging) runStaged(ctx context.Context, op bulkOperationInput) (interface{}, uuid.UUID, error) {
+	if op.Op != "create" && op.ID == uuid.Nil {
+		return nil, uuid.Nil, status.Wrap(errors.New("id is required for update and delete"), status.InvalidArgument)
+	}
+	switch op.Resource + ":" + op.Op {
+	case "block:create":
+		var in createBlockInput
+		if err := decodeBulkData(op.Data, &in); err != nil {
+			return nil, uuid.Nil, err
+		}
+		if err := b.loadPool(in.PoolID); err != nil {
+			return nil, uuid.Nil, err
+		}
+		if err := b.loadSiblings(in.EnvironmentID, b.scopeOrg(in.OrganizationID)); err != nil {
+			return nil, uuid.Nil, err
+		}
+		var out blockOutput
+		err := NewCreateBlockUseCase(b.st).Interact(ctx, in, &out)
+		return &out, out.ID, err
+	case "block:update":
+		var in updateBlockInput
+		if err := decodeBulkData(op.Data, &in); err != nil {
+			return nil, op.ID, err
+		}
+		in.ID = op.ID
+		if err := b.loadBlockByID(op.ID); err != nil {
+			return nil, op.ID, err
+		}
+		if err := b.loadPool(in.PoolID); err != nil {
+			return nil, op.ID, err
+		}
+		if in.EnvironmentID != nil {
+			orgID := uuid.Nil
+			if in.OrganizationID != nil {
+				orgID = *in.OrganizationID
+			}
+			if err := b.loadSiblings(*in.EnvironmentID, b.scopeOrg(orgID)); err != nil {
+				return nil, op.ID, err
+			}
+		} else if in.OrganizationID != nil {
+			if err := b.loadSiblings(uuid.Nil, *in.OrganizationID); err != nil {
+				return nil, op.ID, err
+			}
+		}
+		var out blockOutput
+		err := NewUpdateBlockUseCase(b.st).Interact(ctx, in, &out)
+		return &out, op.ID, err
+	case "block:delete":
+		if err := b.loadBlockByID(op.ID); err != nil {
+			return nil, op.ID, err
+		}
+		err := NewDeleteBlockUseCase(b.st).Interact(ctx, getBlockInput{ID: op.ID}, &struct{}{})
+		return nil, op.ID, err
+	case "allocation:create":
+		var in createAllocationInput
+		if err := decodeBulkData(op.Data, &in); err != nil {
+			return nil, uuid.Nil, err
+		}
+		if err := b.loadBlockNamed(b.orgID, in.BlockName); err != nil {
+			return nil, uuid.Nil, err
+		}
+		var out allocationOutput
+		err := NewCreateAllocationUseCase(b.st).Interact(ctx, in, &out)
+		return &out, out.Id, err
+	case "allocation:update":
+		var in updateAllocationInput
+		if err := decodeBulkData(op.Data, &in); err != nil {
+			return nil, op.ID, err
+		}
+		in.ID = op.ID
+		if err := b.loadAllocation(op.ID); err != nil {
+			return nil, op.ID, err
+		}
+		var out allocationOutput
+		err := NewUpdateAllocationUseCase(b.st).Interact(ctx, in, &out)
+		return &out, op.ID, err
+	case "allocation:delete":
+		if err := b.loadAllocation(op.ID); err != nil {
+			return nil, op.ID, err
+		}
+		in := struct {
+			Id uuid.UUID `path:"id"`
+		}{Id: op.ID}
+		err := NewDeleteAllocationUseCase(b.st).Interact(ctx, in, &struct{}{})
+		return nil, op.ID, err
+	case "reserved_block:create":
+		var in createReservedBlockInput
+		if err := decodeBulkData(op.Data, &in); err != nil {
+			return nil, uuid.Nil, err
+		}
+		if err := b.loadOrganization(b.scopeOrg(in.OrganizationID)); err != nil {
+			return nil, uuid.Nil, err
+		}
+		var out reservedBlockOutput
+		if err := NewCreateReservedBlockUseCase(b.st).Interact(ctx, in, &out); err != nil {
+			return nil, uuid.Nil, err
+		}
+		id, _ := uuid.Parse(out.ID)
+		return &out, id, nil
+	case "reserved_block:update":
+		var in updateReservedBlockInput
+		if err := decodeBulkData(op.Data, &in); err != nil {
+			return nil, op.ID, err
+		}
+		in.ID = op.ID
+		if err := b.loadReservedBlock(op.ID); err != nil {
+			return nil, op.ID, err
+		}
+		var out reservedBlockOutput
+		err := NewUpdateReservedBlockUseCase(b.st).Interact(ctx, in, &out)
+		return &out, op.ID, err
+	case "reserved_block:delete":
+		if err := b.loadReservedBlock(op.ID); err != nil {
+			return nil, op.ID, err
+		}
+		err := NewDeleteReservedBlockUseCase(b.st).Interact(ctx, getReservedBlockInput{ID: op.ID}, &struct{}{})
+		return nil, op.ID, err
+	}
+	return nil, op.ID, status.Wrap(fmt.Errorf("unsupported operation %q on %q", op.Op, op.Resource), status.InvalidArgument)
+}
+
+// changes diffs the staging store against the rows copied into it. Deletes of cloud-linked resources become soft
+// deletes using the same rule as the single delete endpoints (checked against the real store's connections). Updated
+// and deleted rows carry the versions they were read at.
+func (b *bulkStaging) changes(s store.Storer) (*store.BulkChanges, error) {
+	c := &store.BulkChanges{Versions: make(map[uuid.UUID]int64), Scopes: b.scopes}
+
+	// Environments and pools are only ever added in staging (by import), never changed or removed.
+	envs, err := b.st.ListEnvironments()
+	if err != nil {
+		return nil, err
+	}
+	for _, env := range envs {
+		if !b.envs[env.Id] {
+			c.CreateEnvironments = append(c.CreateEnvironments, env)
+		}
+		pools, err := b.st.ListPoolsByEnvironment(env.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, pool := range pools {
+			if !b.pools[pool.ID] {
+				c.CreatePools = append(c.CreatePools, pool)
+			}
+		}
+	}
+	// Parents before children so parent_pool_id references an existing row.
+	sort.SliceStable(c.CreatePools, func(i, j int) bool {
+		return c.CreatePools[i].ParentPoolID == nil && c.CreatePools[j].ParentPoolID != nil
+	})
+
+	blocks, _, err := b.st.ListBlocksFiltered("", nil, nil, nil, false, "", nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uuid.UUID]bool, len(blocks))
+	for _, block := range blocks {
+		seen[block.ID] = true
+		orig, ok := b.blocks[block.ID]
+		switch {
+		case !ok:
+			c.CreateBlocks = append(c.CreateBlocks, block)
+		case !reflect.DeepEqual(orig, *block):
+			c.UpdateBlocks = append(c.UpdateBlocks, block)
+			c.Versions[block.ID] = b.versions[block.ID]
+		}
+	}
+	softDeletedBlockNames := make(map[string]bool)
+	for id, orig := range b.blocks {
+		if seen[id] {
+			continue
+		}
+		c.Versions[id] = b.versions[id]
+		if pendingCloudDelete(s, orig.ConnectionID, orig.ExternalID) {
+			c.SoftDeleteBlocks = append(c.SoftDeleteBlocks, id)
+			softDeletedBlockNames[normalizeBulkName(orig.Name)] = true
+		} else {
+			c.DeleteBlocks = append(c.DeleteBlocks, id)
+		}
+	}
+
+	allocs, err := b.st.ListAllocations()
+	if err != nil {
+		return nil, err
+	}
+	seen = make(map[uuid.UUID]bool, len(allocs))
+	for _, alloc := range allocs {
+		seen[alloc.Id] = true
+		orig, ok := b.allocations[alloc.Id]
+		switch {
+		case !ok:
+			c.CreateAllocations = append(c.CreateAllocations, alloc)
+		case !reflect.DeepEqual(orig, *alloc):
+			c.UpdateAllocations = append(c.UpdateAllocations, alloc)
+			c.Versions[alloc.Id] = b.versions[alloc.Id]
+		}
+	}
+	for id, orig := range b.allocations {
+		if seen[id] {
+			continue
+		}
+		c.Versions[id] = b.versions[id]
+		if softDeletedBlockNames[normalizeBulkName(orig.Block.Name)] || pendingCloudDelete(s, orig.ConnectionID, orig.ExternalID) {
+			c.SoftDeleteAllocations = append(c.SoftDeleteAllocations, id)
+		} else {
+			c.DeleteAllocations = append(c.DeleteAllocations, id)
+		}
+	}
+
+	reserved, err := b.st.ListReservedBlocks(nil)
+	if err != nil {
+		return nil, err
+	}
+	seen = make(map[uuid.UUID]bool, len(reserved))
+	for _, r := range reserved {
+		seen[r.ID] = true
+		orig, ok := b.reserved[r.ID]
+		switch {
+		case !ok:
+			c.CreateReservedBlocks = append(c.CreateReservedBlocks, r)
+		case !reflect.DeepEqual(orig, *r):
+			c.UpdateReservedBlocks = append(c.UpdateReservedBlocks, r)
+			c.Versions[r.ID] = b.versions[r.ID]
+		}
+	}
+	for id := range b.reserved {
+		if !seen[id] {
+			c.DeleteReservedBlocks = append(c.DeleteReservedBlocks, id)
+			c.Versions[id] = b.versions[id]
+		}
+	}
+
+	for _, ids := range [][]uuid.UUID{c.DeleteAllocations, c.SoftDeleteAllocations, c.DeleteBlocks, c.SoftDeleteBlocks, c.DeleteReservedBlocks} {
+		sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	}
+	return c, nil
+}
+
+// bulkStoreError wraps an error from staging or applying changes: Aborted when rows changed since they were read, else Internal.
+func bulkStoreError(what string, err error) error {
+	if errors.Is(err, store.ErrBulkConflict) {
+		return status.Wrap(fmt.Errorf("%s: %w", what, err), status.Aborted)
+	}
+	return status.Wrap(fmt.Errorf("%s: %w", what, err), status.Internal)
+}
+
+func normalizeBulkName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// NewBulkUseCase handles POST /api/bulk: validates every operation together and applies all of them or none.
+func NewBulkUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input bulkInput, output *bulkOutput) error {
+		user := auth.UserFromContext(ctx)
+		if user == nil {
+			return status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
+		}
+		if len(input.Operations) == 0 {
+			return status.Wrap(errors.New("at least one operation is required"), status.InvalidArgument)
+		}
+		staging := newBulkStaging(s, auth.ResolveOrgID(ctx, user, uuid.Nil))
+
+		results := make([]bulkResultOutput, len(input.Operations))
+		failed := 0
+		for i, op := range input.Operations {
+			res := bulkResultOutput{Index: i, Op: op.Op, Resource: op.Resource, Status: "ok"}
+			out, id, err := staging.run(ctx, op)
+			if id != uuid.Nil {
+				res.ID = &id
+			}
+			if err != nil {
+				code, errResp := rest.Err(err)
+				res.Status, res.Code, res.Error, res.Result = "error", code, errResp.ErrorText, nil
+				failed++
+			} else {
+				res.Result = out
+			}
+			results[i] = res
+		}
+		if failed > 0 {
+			return usecase.Error{
+				StatusCode: status.InvalidArgument,
+				Value:      fmt.Errorf("%d of %d operations failed; nothing was applied", failed, len(input.Operations)),
+				Context:    map[string]interface{}{"results": results},
+			}
+		}
+
+		changes, err := staging.changes(s)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		if !changes.Empty() {
+			if err := s.ApplyBulk(changes); err != nil {
+				return bulkStoreError("apply bulk changes", err)
+			}
+		}
+		output.Applied = true
+		output.Results = results
+		return nil
+	})
+
+	u.SetTitle("Bulk Operations")
+	u.SetDescription("Creates, updates and deletes blocks, allocations and reserved blocks in one request. " +
+		"Operations run in order and are validated together (including overlaps within the batch) with the same rules and error messages as the single endpoints. " +
+		"Either every operation is applied or none is: on failure the response is 400 with per-operation results in context.results. " +
+		"If another request changed a row the batch touches before it was applied, nothing is applied and the response is 409. " +
+		"Blocks and allocations created in cloud-linked pools or blocks are pushed to the cloud on the next sync.")
+	u.SetExpectedErrors(status.Unauthenticated, status.InvalidArgument, status.Aborted, status.Internal)
+	return u
+}
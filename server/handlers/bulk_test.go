@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/rest"
+	"github.com/swaggest/usecase"
+)
+
+func setupBulkTest(t *testing.T) (*store.Store, context.Context, *network.Environment) {
+	t.Helper()
+	s, _, org, orgAdmin := setupGlobalAdminTest(t)
+	env := &network.Environment{Id: uuid.New(), Name: "prod", OrganizationID: org.ID}
+	if err := s.CreateEnvironment(env); err != nil {
+		t.Fatalf("create environment: %v", err)
+	}
+	return s, auth.WithUser(context.Background(), orgAdmin), env
+}
+
+func bulkOp(op, resource string, id uuid.UUID, data interface{}) bulkOperationInput {
+	in := bulkOperationInput{Op: op, Resource: resource, ID: id}
+	if data != nil {
+		raw, _ := json.Marshal(data)
+		in.Data = raw
+	}
+	return in
+}
+
+func TestBulk_AppliesAllOperations(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	existing := &network.Block{Name: "old", CIDR: "10.1.0.0/16", EnvironmentID: env.Id}
+	if err := s.CreateBlock(existing); err != nil {
+		t.Fatal(err)
+	}
+	oldAlloc := &network.Allocation{Id: uuid.New(), Name: "old-a", Block: network.Block{Name: "old", CIDR: "10.1.0.0/24"}}
+	if err := s.CreateAllocation(oldAlloc.Id, oldAlloc); err != nil {
+		t.Fatal(err)
+	}
+
+	input := bulkInput{Operations: []bulkOperationInput{
+		bulkOp("create", "block", uuid.Nil, map[string]interface{}{"name": "vpc", "cidr": "10.0.0.0/16", "environment_id": env.Id}),
+		bulkOp("create", "allocation", uuid.Nil, map[string]interface{}{"name": "a", "block_name": "vpc", "cidr": "10.0.0.0/24"}),
+		bulkOp("create", "allocation", uuid.Nil, map[string]interface{}{"name": "b", "block_name": "vpc", "cidr": "10.0.1.0/24"}),
+		bulkOp("delete", "block", existing.ID, nil),
+	}}
+	var out bulkOutput
+	if err := NewBulkUseCase(s).Interact(ctx, input, &out); err != nil {
+		t.Fatalf("Interact: %v", err)
+	}
+	if !out.Applied || len(out.Results) != 4 {
+		t.Fatalf("output = %+v, want 4 applied results", out)
+	}
+	for _, r := range out.Results {
+		if r.Status != "ok" || (r.Op == "create" && r.ID == nil) {
+			t.Errorf("result %d = %+v", r.Index, r)
+		}
+	}
+	block, err := s.GetBlock(*out.Results[0].ID)
+	if err != nil || block.CIDR != "10.0.0.0/16" {
+		t.Errorf("created block = %v, %v", block, err)
+	}
+	allocs, _ := s.ListAllocations()
+	names := make([]string, 0, len(allocs))
+	for _, a := range allocs {
+		names = append(names, a.Name)
+	}
+	if len(allocs) != 2 {
+		t.Errorf("allocations after bulk = %v, want a and b (old-a cascaded with its block)", names)
+	}
+	if _, err := s.GetBlock(existing.ID); err == nil {
+		t.Error("deleted block still exists")
+	}
+}
+
+func TestBulk_RejectsWholeBatch(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	input := bulkInput{Operations: []bulkOperationInput{
+		bulkOp("create", "block", uuid.Nil, map[string]interface{}{"name": "vpc", "cidr": "10.0.0.0/16", "environment_id": env.Id}),
+		bulkOp("create", "allocation", uuid.Nil, map[string]interface{}{"name": "a", "block_name": "vpc", "cidr": "10.0.0.0/24"}),
+		bulkOp("create", "allocation", uuid.Nil, map[string]interface{}{"name": "b", "block_name": "vpc", "cidr": "10.0.0.128/25"}),
+		bulkOp("update", "allocation", uuid.New(), map[string]interface{}{"name": "missing"}),
+		bulkOp("create", "allocation", uuid.Nil, map[string]interface{}{"name": "c", "block_name": "vpc", "cidr": "10.0.2.0/24", "extra": true}),
+	}}
+	var out bulkOutput
+	err := NewBulkUseCase(s).Interact(ctx, input, &out)
+	var ucErr usecase.Error
+	if !errors.As(err, &ucErr) {
+		t.Fatalf("Interact err = %v, want usecase.Error with results", err)
+	}
+	results, _ := ucErr.Context["results"].([]bulkResultOutput)
+	if len(results) != 5 {
+		t.Fatalf("results = %+v, want 5", results)
+	}
+	want := []struct {
+		status, errContains string
+		code                int
+	}{
+		{"ok", "", 0},
+		{"ok", "", 0},
+		{"error", `CIDR 10.0.0.128/25 overlaps with existing allocation "a" in block "vpc"`, 400},
+		{"error", "allocation not found", 404},
+		{"error", "invalid data", 400},
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Status != w.status || r.Code != w.code || !strings.Contains(r.Error, w.errContains) {
+			t.Errorf("result %d = %+v, want status %q code %d error containing %q", i, r, w.status, w.code, w.errContains)
+		}
+	}
+	if blocks, _ := s.ListBlocks(); len(blocks) != 0 {
+		t.Errorf("blocks after rejected batch = %d, want 0 (nothing applied)", len(blocks))
+	}
+}
+
+// racingStore commits a concurrent edit right before the batch is applied.
+type racingStore struct {
+	store.Storer
+	race func()
+}
+
+func (s racingStore) ApplyBulk(c *store.BulkChanges) error {
+	s.race()
+	return s.Storer.ApplyBulk(c)
+}
+
+func TestBulk_StagesOnlyTouchedRowsAndRejectsStaleWrites(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	other := &network.Environment{Id: uuid.New(), Name: "staging", OrganizationID: env.OrganizationID}
+	if err := s.CreateEnvironment(other); err != nil {
+		t.Fatal(err)
+	}
+	vpc := &network.Block{Name: "vpc", CIDR: "10.0.0.0/16", EnvironmentID: env.Id}
+	untouched := &network.Block{Name: "elsewhere", CIDR: "10.9.0.0/16", EnvironmentID: other.Id}
+	for _, b := range []*network.Block{vpc, untouched} {
+		if err := s.CreateBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	staging := newBulkStaging(s, &env.OrganizationID)
+	if _, _, err := staging.run(ctx, bulkOp("update", "block", vpc.ID, map[string]interface{}{"name": "vpc-renamed"})); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if _, ok := staging.blocks[untouched.ID]; ok || len(staging.blocks) != 1 {
+		t.Errorf("staged blocks = %d, want only the updated block's environment", len(staging.blocks))
+	}
+
+	racing := racingStore{Storer: s, race: func() {
+		theirs := *vpc
+		theirs.Name = "theirs"
+		_ = s.UpdateBlock(vpc.ID, &theirs)
+	}}
+	input := bulkInput{Operations: []bulkOperationInput{
+		bulkOp("update", "block", vpc.ID, map[string]interface{}{"name": "mine"}),
+	}}
+	var out bulkOutput
+	err := NewBulkUseCase(racing).Interact(ctx, input, &out)
+	if code, _ := rest.Err(err); code != http.StatusConflict {
+		t.Fatalf("Interact err = %v (HTTP %d), want 409 Aborted", err, code)
+	}
+	if b, _ := s.GetBlock(vpc.ID); b.Name != "theirs" {
+		t.Errorf("block name = %q, want the concurrent edit kept", b.Name)
+	}
+}
+
+func TestBulk_RejectsConcurrentInsertIntoValidatedScope(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	racing := racingStore{Storer: s, race: func() {
+		_ = s.CreateBlock(&network.Block{Name: "theirs", CIDR: "10.0.0.0/16", EnvironmentID: env.Id})
+	}}
+	input := bulkInput{Operations: []bulkOperationInput{
+		bulkOp("create", "block", uuid.Nil, map[string]interface{}{"name": "mine", "cidr": "10.0.0.0/16", "environment_id": env.Id}),
+	}}
+	var out bulkOutput
+	err := NewBulkUseCase(racing).Interact(ctx, input, &out)
+	if code, _ := rest.Err(err); code != http.StatusConflict {
+		t.Fatalf("Interact err = %v (HTTP %d), want 409 Aborted", err, code)
+	}
+	blocks, _ := s.ListBlocksByEnvironment(env.Id)
+	if len(blocks) != 1 || blocks[0].Name != "theirs" {
+		t.Errorf("blocks = %v, want only the concurrent insert", blocks)
+	}
+}
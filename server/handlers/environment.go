@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/netip"
 	"strings"
 
 	"github.com/JakeNeyer/ipam/network"
@@ -36,36 +37,36 @@ func NewCreateEnvironmentUseCase(s store.Storer) usecase.Interactor {
 					return status.Wrap(fmt.Errorf("pool at index %d: name and cidr are required", i), status.InvalidArgument)
 				}
 				if valid := network.ValidateCIDR(p.CIDR); !valid {
-					return status.Wrap(fmt.Errorf("pool %q: invalid CIDR format", p.Name), status.InvalidArgument)
+					return status.Wrap(fmt.Errorf("pool %q: %w", p.Name, errInvalidCIDR), status.InvalidArgument)
 				}
 			}
-			for i := 0; i < len(input.Pools); i++ {
-				for j := i + 1; j < len(input.Pools); j++ {
-					overlap, err := network.Overlaps(input.Pools[i].CIDR, input.Pools[j].CIDR)
-					if err != nil {
-						return status.Wrap(err, status.InvalidArgument)
-					}
-					if overlap {
-						return status.Wrap(
-							fmt.Errorf("pools %q and %q overlap", input.Pools[i].Name, input.Pools[j].Name),
-							status.InvalidArgument,
-						)
-					}
-				}
+			cidrs := make([]string, len(input.Pools))
+			for i, p := range input.Pools {
+				cidrs[i] = p.CIDR
+			}
+			if pairs := network.FindOverlaps(cidrs); len(pairs) > 0 {
+				return status.Wrap(
+					conflictf(errOverlap, "pools %q and %q overlap", input.Pools[pairs[0].I].Name, input.Pools[pairs[0].J].Name),
+					status.InvalidArgument,
+				)
 			}
 			existingPools, err := s.ListPoolsByOrganization(orgID)
 			if err != nil {
 				return status.Wrap(err, status.Internal)
 			}
-			for _, newPool := range input.Pools {
-				for _, other := range existingPools {
-					overlap, err := network.Overlaps(newPool.CIDR, other.CIDR)
-					if err != nil {
-						return status.Wrap(err, status.Internal)
-					}
-					if overlap {
+			newSet, err := network.ParsePrefixSet(cidrs...)
+			if err != nil {
+				return status.Wrap(err, status.InvalidArgument)
+			}
+			for _, other := range existingPools {
+				otherPrefix, err := netip.ParsePrefix(other.CIDR)
+				if err != nil || !newSet.OverlapsPrefix(otherPrefix) {
+					continue
+				}
+				for _, newPool := range input.Pools {
+					if overlap, _ := network.Overlaps(newPool.CIDR, other.CIDR); overlap {
 						return status.Wrap(
-							fmt.Errorf("pool CIDR %s overlaps with existing pool %q (%s) in this organization", newPool.CIDR, other.Name, other.CIDR),
+							conflictf(errOverlap, "pool CIDR %s overlaps with existing pool %q (%s) in this organization", newPool.CIDR, other.Name, other.CIDR),
 							status.InvalidArgument,
 						)
 					}
@@ -157,11 +158,11 @@ func NewGetEnvironmentUseCase(s store.Storer) usecase.Interactor {
 	u := usecase.NewInteractor(func(ctx context.Context, input getEnvironmentInput, output *environmentDetailOutput) error {
 		env, err := s.GetEnvironment(input.ID)
 		if err != nil {
-			return status.Wrap(errors.New("environment not found"), status.NotFound)
+			return status.Wrap(errEnvironmentNotFound, status.NotFound)
 		}
 		user := auth.UserFromContext(ctx)
 		if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-			return status.Wrap(errors.New("environment not found"), status.NotFound)
+			return status.Wrap(errEnvironmentNotFound, status.NotFound)
 		}
 
 		blocks, err := s.ListBlocksByEnvironment(env.Id)
@@ -171,10 +172,10 @@ func NewGetEnvironmentUseCase(s store.Storer) usecase.Interactor {
 
 		output.Id = env.Id
 		output.Name = env.Name
-		envOrgID := &env.OrganizationID
+		used := blocksUsedAddresses(s, blocks)
 		output.Blocks = make([]*blockOutput, len(blocks))
 		for i, b := range blocks {
-			totalStr, usedStr, availStr, _ := derivedBlockUsage(s, b.Name, b.CIDR, envOrgID)
+			totalStr, usedStr, availStr, _ := blockUsage(b.CIDR, used[b.ID])
 			blockOrgID := b.OrganizationID
 			if blockOrgID == uuid.Nil {
 				blockOrgID = env.OrganizationID
@@ -216,11 +217,11 @@ func NewUpdateEnvironmentUseCase(s store.Storer) usecase.Interactor {
 
 		env, err := s.GetEnvironment(input.ID)
 		if err != nil {
-			return status.Wrap(errors.New("environment not found"), status.NotFound)
+			return status.Wrap(errEnvironmentNotFound, status.NotFound)
 		}
 		user := auth.UserFromContext(ctx)
 		if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-			return status.Wrap(errors.New("environment not found"), status.NotFound)
+			return status.Wrap(errEnvironmentNotFound, status.NotFound)
 		}
 
 		env.Name = name
@@ -244,14 +245,14 @@ func NewDeleteEnvironmentUseCase(s store.Storer) usecase.Interactor {
 	u := usecase.NewInteractor(func(ctx context.Context, input getEnvironmentInput, output *struct{}) error {
 		env, err := s.GetEnvironment(input.ID)
 		if err != nil {
-			return status.Wrap(errors.New("environment not found"), status.NotFound)
+			return status.Wrap(errEnvironmentNotFound, status.NotFound)
 		}
 		user := auth.UserFromContext(ctx)
 		if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-			return status.Wrap(errors.New("environment not found"), status.NotFound)
+			return status.Wrap(errEnvironmentNotFound, status.NotFound)
 		}
 		if err := s.DeleteEnvironment(input.ID); err != nil {
-			return status.Wrap(errors.New("environment not found"), status.NotFound)
+			return status.Wrap(errEnvironmentNotFound, status.NotFound)
 		}
 		return nil
 	})
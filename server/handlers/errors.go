@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Validation errors shared by the create and update use cases. Callers that need to tell them apart, such as the
+// import row report, match them with errors.Is rather than on the message.
+var (
+	errInvalidCIDR         = errors.New("invalid CIDR format")
+	errEnvironmentNotFound = errors.New("environment not found")
+	errBlockNotFound       = errors.New("block not found")
+
+	// Kinds of CIDR conflict; conflictf attaches one to an error describing the conflicting ranges.
+	errOverlap         = errors.New("overlap")
+	errReservedOverlap = errors.New("reserved overlap")
+	errNotContained    = errors.New("not contained")
+)
+
+// conflictError is a CIDR conflict of a given kind. Its message is only the description, so clients see the same
+// text as before, while errors.Is(err, kind) reports its kind.
+type conflictError struct {
+	kind error
+	msg  string
+}
+
+func (e *conflictError) Error() string { return e.msg }
+
+func (e *conflictError) Is(target error) bool { return target == e.kind }
+
+func conflictf(kind error, format string, args ...any) error {
+	return &conflictError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
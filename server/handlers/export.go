@@ -6,10 +6,12 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"math/big"
 	"net"
 	"net/http"
 	"strconv"
 
+	"github.com/JakeNeyer/ipam/internal/logger"
 	"github.com/JakeNeyer/ipam/network"
 	"github.com/JakeNeyer/ipam/server/auth"
 	"github.com/JakeNeyer/ipam/store"
@@ -20,6 +22,9 @@ import (
 	"github.com/swaggest/usecase/status"
 )
 
+// exportAllocationPageSize is how many allocations an export without a limit reads from the store at a time.
+var exportAllocationPageSize = 500
+
 // cidrStartEnd returns the first and last IP of a CIDR as strings, or "", "" if invalid.
 func cidrStartEnd(cidr string) (start, end string) {
 	_, n, err := net.ParseCIDR(cidr)
@@ -67,31 +72,19 @@ func NewExportCSVUseCase(s store.Storer) usecase.Interactor {
 			return status.Wrap(err, status.Internal)
 		}
 
-		envByID := make(map[string]string)
+		envByID := make(map[uuid.UUID]string)
 		for _, e := range envs {
-			envByID[e.Id.String()] = e.Name
+			envByID[e.Id] = e.Name
 		}
+		used := blocksUsedAddresses(s, blocks)
 
 		var buf bytes.Buffer
 		wr := csv.NewWriter(&buf)
-		if err := wr.Write([]string{"name", "cidr", "cidr_start", "cidr_end", "environment_name", "total_ips", "used_ips", "available_ips"}); err != nil {
+		if err := wr.Write(exportBlockColumns); err != nil {
 			return status.Wrap(err, status.Internal)
 		}
-
 		for _, b := range blocks {
-			totalStr, usedStr, availStr, _ := derivedBlockUsage(s, b.Name, b.CIDR, orgID)
-			envName := envByID[b.EnvironmentID.String()]
-			start, end := cidrStartEnd(b.CIDR)
-			_ = wr.Write([]string{
-				b.Name,
-				b.CIDR,
-				start,
-				end,
-				envName,
-				totalStr,
-				usedStr,
-				availStr,
-			})
+			_ = wr.Write(blockCSVRow(b, envByID[b.EnvironmentID], used[b.ID]))
 		}
 
 		wr.Flush()
@@ -139,8 +132,72 @@ func (e *csvResponseEncoder) MakeOutput(w http.ResponseWriter, _ rest.HandlerTra
 	return &exportCSVOutput{}
 }
 
-// ExportCSVHandler returns an http.Handler that writes CSV directly to the response.
-// Use this when the use-case + custom encoder chain does not write the body (e.g. empty download).
+// exportBlockColumns and exportAllocationColumns are the headers of the block and allocation CSV exports.
+var (
+	exportBlockColumns      = []string{"name", "cidr", "cidr_start", "cidr_end", "environment_name", "total_ips", "used_ips", "available_ips"}
+	exportAllocationColumns = []string{"name", "cidr", "cidr_start", "cidr_end", "block_name", "environment_name", "total_ips"}
+)
+
+// blockCSVRow renders one row of the block export.
+func blockCSVRow(b *network.Block, envName string, used *big.Int) []string {
+	start, end := cidrStartEnd(b.CIDR)
+	totalStr, usedStr, availStr, _ := blockUsage(b.CIDR, used)
+	return []string{b.Name, b.CIDR, start, end, envName, totalStr, usedStr, availStr}
+}
+
+// allocationCSVRow renders one row of the allocation export.
+func allocationCSVRow(a *network.Allocation, envName string) []string {
+	start, end := cidrStartEnd(a.Block.CIDR)
+	return []string{a.Name, a.Block.CIDR, start, end, a.Block.Name, envName, network.CIDRAddressCountString(a.Block.CIDR)}
+}
+
+// exportScope lists the environments and blocks visible for orgID (everything when nil) and maps block names to
+// environment names for the allocation export.
+func exportScope(s store.Storer, orgID *uuid.UUID) (envByID map[uuid.UUID]string, blocks []*network.Block, err error) {
+	var envs []*network.Environment
+	if orgID != nil {
+		if envs, _, err = s.ListEnvironmentsFiltered("", orgID, 0, 0); err != nil {
+			return nil, nil, err
+		}
+		blocks, _, err = s.ListBlocksFiltered("", nil, nil, orgID, false, "", nil, 0, 0)
+	} else {
+		if envs, err = s.ListEnvironments(); err != nil {
+			return nil, nil, err
+		}
+		blocks, err = s.ListBlocks()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	envByID = make(map[uuid.UUID]string, len(envs))
+	for _, e := range envs {
+		envByID[e.Id] = e.Name
+	}
+	return envByID, blocks, nil
+}
+
+// startCSVDownload writes the headers of a streamed CSV download. There is no Content-Length: rows go to the client
+// as they are produced, so a large export is never held in memory and the first bytes are sent immediately.
+func startCSVDownload(w http.ResponseWriter, filename string, extra map[string]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	for k, v := range extra {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// finishCSV flushes wr. The status has already been sent, so a failure (usually the client going away) is only logged.
+func finishCSV(wr *csv.Writer, level string) {
+	wr.Flush()
+	if err := wr.Error(); err != nil {
+		logger.Error("csv export interrupted", logger.KeyOperation, "export_csv", "level", level, logger.ErrAttr(err))
+	}
+}
+
+// ExportCSVHandler returns an http.Handler that streams CSV directly to the response.
+// GET /api/export/csv exports blocks with their usage; ?level=allocations exports allocations instead, optionally a
+// page at a time with ?limit=N&cursor=<id>, where the X-Next-Cursor response header carries the cursor of the next page.
 func ExportCSVHandler(s store.Storer) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet && r.Method != http.MethodHead {
@@ -150,63 +207,110 @@ func ExportCSVHandler(s store.Storer) http.Handler {
 		ctx := r.Context()
 		user := auth.UserFromContext(ctx)
 		orgID := auth.ResolveOrgID(ctx, user, uuid.Nil)
+		switch level := r.URL.Query().Get("level"); level {
+		case "", "blocks":
+			exportBlocksCSV(w, r, s, orgID)
+		case "allocations":
+			exportAllocationsCSV(w, r, s, orgID)
+		default:
+			http.Error(w, "level must be blocks or allocations", http.StatusBadRequest)
+		}
+	})
+}
 
-		var envs []*network.Environment
-		var blocks []*network.Block
-		var err error
-		if orgID != nil {
-			envs, _, err = s.ListEnvironmentsFiltered("", orgID, 0, 0)
-			if err != nil {
-				http.Error(w, "Failed to list environments", http.StatusInternalServerError)
-				return
-			}
-			blocks, _, err = s.ListBlocksFiltered("", nil, nil, orgID, false, "", nil, 0, 0)
-		} else {
-			envs, err = s.ListEnvironments()
-			if err != nil {
-				http.Error(w, "Failed to list environments", http.StatusInternalServerError)
-				return
-			}
-			blocks, err = s.ListBlocks()
+// exportBlocksCSV streams one row per block. Usage for all blocks comes from one store aggregate, so the export is
+// linear in the number of blocks.
+func exportBlocksCSV(w http.ResponseWriter, r *http.Request, s store.Storer, orgID *uuid.UUID) {
+	envByID, blocks, err := exportScope(s, orgID)
+	if err != nil {
+		http.Error(w, "Failed to list blocks", http.StatusInternalServerError)
+		return
+	}
+	used, err := s.BlockUsedAddresses(blocks)
+	if err != nil {
+		http.Error(w, "Failed to compute block usage", http.StatusInternalServerError)
+		return
+	}
+	startCSVDownload(w, "ipam-export.csv", nil)
+	if r.Method == http.MethodHead {
+		return
+	}
+	wr := csv.NewWriter(w)
+	_ = wr.Write(exportBlockColumns)
+	for _, b := range blocks {
+		if err := wr.Write(blockCSVRow(b, envByID[b.EnvironmentID], used[b.ID])); err != nil {
+			break
 		}
+	}
+	finishCSV(wr, "blocks")
+}
+
+// exportAllocationsCSV streams one row per allocation in ID order. The columns (name, cidr, block_name) are accepted
+// by POST /api/import.
+func exportAllocationsCSV(w http.ResponseWriter, r *http.Request, s store.Storer, orgID *uuid.UUID) {
+	cursor := uuid.Nil
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		id, err := uuid.Parse(v)
 		if err != nil {
-			http.Error(w, "Failed to list blocks", http.StatusInternalServerError)
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
 			return
 		}
-		envByID := make(map[string]string)
-		for _, e := range envs {
-			envByID[e.Id.String()] = e.Name
+		cursor = id
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
 		}
-		var buf bytes.Buffer
-		wr := csv.NewWriter(&buf)
-		_ = wr.Write([]string{"name", "cidr", "cidr_start", "cidr_end", "environment_name", "total_ips", "used_ips", "available_ips"})
-		for _, b := range blocks {
-			totalStr, usedStr, availStr, _ := derivedBlockUsage(s, b.Name, b.CIDR, orgID)
-			envName := envByID[b.EnvironmentID.String()]
-			start, end := cidrStartEnd(b.CIDR)
-			_ = wr.Write([]string{
-				b.Name,
-				b.CIDR,
-				start,
-				end,
-				envName,
-				totalStr,
-				usedStr,
-				availStr,
-			})
+		limit = n
+	}
+	envByID, blocks, err := exportScope(s, orgID)
+	if err != nil {
+		http.Error(w, "Failed to list blocks", http.StatusInternalServerError)
+		return
+	}
+	envByBlock := make(map[string]string, len(blocks))
+	for _, b := range blocks {
+		envByBlock[normalizeBulkName(b.Name)] = envByID[b.EnvironmentID]
+	}
+	page := limit
+	if page == 0 {
+		page = exportAllocationPageSize
+	}
+	allocs, err := s.ListAllocationsAfter(orgID, cursor, page)
+	if err != nil {
+		http.Error(w, "Failed to list allocations", http.StatusInternalServerError)
+		return
+	}
+	var extra map[string]string
+	if limit > 0 && len(allocs) == limit {
+		extra = map[string]string{"X-Next-Cursor": allocs[len(allocs)-1].Id.String()}
+	}
+	startCSVDownload(w, "ipam-allocations.csv", extra)
+	if r.Method == http.MethodHead {
+		return
+	}
+	wr := csv.NewWriter(w)
+	_ = wr.Write(exportAllocationColumns)
+	for {
+		for _, a := range allocs {
+			if err := wr.Write(allocationCSVRow(a, envByBlock[normalizeBulkName(a.Block.Name)])); err != nil {
+				finishCSV(wr, "allocations")
+				return
+			}
 		}
-		wr.Flush()
-		if wr.Error() != nil {
-			http.Error(w, "Failed to write CSV", http.StatusInternalServerError)
-			return
+		if limit > 0 || len(allocs) < page {
+			break
 		}
-		body := buf.Bytes()
-		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
-		w.Header().Set("Content-Disposition", `attachment; filename="ipam-export.csv"`)
-		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
-		w.WriteHeader(http.StatusOK)
-		if r.Method != http.MethodHead {
-			_, _ = w.Write(body)
+		// Without a limit every page is written; flush each one so memory stays bounded by the page size.
+		wr.Flush()
+		allocs, err = s.ListAllocationsAfter(orgID, allocs[len(allocs)-1].Id, page)
+		if err != nil {
+			logger.Error(logger.MsgStoreError, logger.KeyOperation, "export_csv", "level", "allocations", logger.ErrAttr(err))
+			break
 		}
-	})
+	}
+	finishCSV(wr, "allocations")
 }
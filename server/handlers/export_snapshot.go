@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// cidrLess orders CIDRs by address, then by prefix length; unparsable values sort last by string.
+func cidrLess(a, b string) bool {
+	pa, errA := netip.ParsePrefix(a)
+	pb, errB := netip.ParsePrefix(b)
+	if errA != nil || errB != nil {
+		if (errA == nil) != (errB == nil) {
+			return errA == nil
+		}
+		return a < b
+	}
+	if c := pa.Masked().Addr().Compare(pb.Masked().Addr()); c != 0 {
+		return c < 0
+	}
+	return pa.Bits() < pb.Bits()
+}
+
+// buildExportSnapshot collects an organization's environments, pools, blocks, allocations and reserved blocks,
+// sorted by name (environments) or address (everything else) so repeated exports diff cleanly.
+func buildExportSnapshot(s store.Storer, orgID uuid.UUID, now time.Time) (*exportSnapshot, error) {
+	org, err := s.GetOrganization(orgID)
+	if err != nil {
+		return nil, err
+	}
+	envs, _, err := s.ListEnvironmentsFiltered("", &orgID, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	blocks, _, err := s.ListBlocksFiltered("", nil, nil, &orgID, false, "", nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	allocs, _, err := s.ListAllocationsFiltered("", "", uuid.Nil, &orgID, "", nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := s.ListReservedBlocks(&orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	allocsByBlock := make(map[string][]*network.Allocation)
+	for _, a := range allocs {
+		key := normalizeBulkName(a.Block.Name)
+		allocsByBlock[key] = append(allocsByBlock[key], a)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return cidrLess(blocks[i].CIDR, blocks[j].CIDR) })
+	blocksByPool := make(map[uuid.UUID][]exportBlock)
+	blocksByEnv := make(map[uuid.UUID][]exportBlock)
+	snap := &exportSnapshot{
+		Organization: exportOrganization{ID: org.ID, Name: org.Name},
+		ExportedAt:   now.UTC().Format(time.RFC3339),
+		Environments: []exportEnvironment{},
+	}
+	for _, b := range blocks {
+		blockAllocs := allocsByBlock[normalizeBulkName(b.Name)]
+		sort.Slice(blockAllocs, func(i, j int) bool { return cidrLess(blockAllocs[i].Block.CIDR, blockAllocs[j].Block.CIDR) })
+		cidrs := make([]string, len(blockAllocs))
+		eb := exportBlock{ID: b.ID, Name: b.Name, CIDR: b.CIDR, Isolated: b.Isolated, ExternalID: b.ExternalID}
+		if b.Provider != "native" {
+			eb.Provider = b.Provider
+		}
+		for i, a := range blockAllocs {
+			cidrs[i] = a.Block.CIDR
+			ea := exportAllocation{ID: a.Id, Name: a.Name, CIDR: a.Block.CIDR, ExternalID: a.ExternalID}
+			if a.Provider != "native" {
+				ea.Provider = a.Provider
+			}
+			eb.Allocations = append(eb.Allocations, ea)
+		}
+		usage := plannedBlockOutput(b, cidrs)
+		eb.TotalIPs, eb.UsedIPs, eb.AvailableIPs = usage.TotalIPs, usage.UsedIPs, usage.Available
+		switch {
+		case b.EnvironmentID == uuid.Nil:
+			snap.Blocks = append(snap.Blocks, eb)
+		case b.PoolID != nil:
+			blocksByPool[*b.PoolID] = append(blocksByPool[*b.PoolID], eb)
+		default:
+			blocksByEnv[b.EnvironmentID] = append(blocksByEnv[b.EnvironmentID], eb)
+		}
+	}
+
+	sort.Slice(envs, func(i, j int) bool { return strings.ToLower(envs[i].Name) < strings.ToLower(envs[j].Name) })
+	for _, env := range envs {
+		ee := exportEnvironment{ID: env.Id, Name: env.Name, Blocks: blocksByEnv[env.Id]}
+		pools, err := s.ListPoolsByEnvironment(env.Id)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(pools, func(i, j int) bool { return cidrLess(pools[i].CIDR, pools[j].CIDR) })
+		for _, p := range pools {
+			ep := exportPool{ID: p.ID, Name: p.Name, CIDR: p.CIDR, ParentPoolID: p.ParentPoolID, ExternalID: p.ExternalID, Blocks: blocksByPool[p.ID]}
+			if p.Provider != "native" {
+				ep.Provider = p.Provider
+			}
+			ee.Pools = append(ee.Pools, ep)
+		}
+		snap.Environments = append(snap.Environments, ee)
+	}
+
+	sort.Slice(reserved, func(i, j int) bool { return cidrLess(reserved[i].CIDR, reserved[j].CIDR) })
+	for _, r := range reserved {
+		snap.ReservedBlocks = append(snap.ReservedBlocks, exportReservedBlock{ID: r.ID, Name: r.Name, CIDR: r.CIDR, Reason: r.Reason})
+	}
+	return snap, nil
+}
+
+// jsonToYAML re-encodes JSON as block-style YAML, keeping key order and the JSON field names.
+func jsonToYAML(data []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	var clearStyle func(n *yaml.Node)
+	clearStyle = func(n *yaml.Node) {
+		n.Style = 0
+		for _, c := range n.Content {
+			clearStyle(c)
+		}
+	}
+	clearStyle(&node)
+	return marshalYAML(&node)
+}
+
+// marshalYAML encodes v with the two-space indentation used by Ansible and Kubernetes tooling.
+func marshalYAML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var (
+	tfLabelInvalid      = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+	ansibleGroupInvalid = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+)
+
+// uniqueLabel sanitizes name with invalid and returns it, suffixed with _2, _3, ... when already taken in used.
+func uniqueLabel(name string, invalid *regexp.Regexp, used map[string]bool) string {
+	label := strings.Trim(invalid.ReplaceAllString(name, "_"), "_")
+	if label == "" || (label[0] >= '0' && label[0] <= '9') || label[0] == '-' {
+		label = "_" + label
+	}
+	base := label
+	for i := 2; used[label]; i++ {
+		label = base + "_" + strconv.Itoa(i)
+	}
+	used[label] = true
+	return label
+}
+
+// hclString quotes s as an HCL string literal, escaping template sequences.
+func hclString(s string) string {
+	b, _ := json.Marshal(s)
+	out := strings.ReplaceAll(string(b), "${", "$${")
+	return strings.ReplaceAll(out, "%{", "%%{")
+}
+
+// writeHCLResource writes one resource block with its attributes aligned as terraform fmt would.
+func writeHCLResource(buf *bytes.Buffer, typ, label string, attrs [][2]string) {
+	width := 0
+	for _, a := range attrs {
+		if len(a[0]) > width {
+			width = len(a[0])
+		}
+	}
+	fmt.Fprintf(buf, "resource %q %q {\n", typ, label)
+	for _, a := range attrs {
+		fmt.Fprintf(buf, "  %-*s = %s\n", width, a[0], a[1])
+	}
+	buf.WriteString("}\n\n")
+}
+
+func writeHCLImport(buf *bytes.Buffer, typ, label string, id uuid.UUID) {
+	fmt.Fprintf(buf, "import {\n  to = %s.%s\n  id = %q\n}\n\n", typ, label, id.String())
+}
+
+// exportHCL renders the snapshot as resources of the jakeneyer/ipam Terraform provider, each followed by an import
+// block (Terraform >= 1.5) so applying the file adopts the existing objects instead of creating new ones.
+func exportHCL(snap *exportSnapshot) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# IPAM export of organization %s at %s.\n", hclString(snap.Organization.Name), snap.ExportedAt)
+	buf.WriteString("# Resources use the jakeneyer/ipam provider schema; import blocks adopt the existing objects into state.\n\n")
+
+	envLabels, blockLabels, allocLabels := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	writeBlock := func(b exportBlock, envRef, poolRef string) {
+		label := uniqueLabel(b.Name, tfLabelInvalid, blockLabels)
+		attrs := [][2]string{{"name", hclString(b.Name)}, {"cidr", hclString(b.CIDR)}}
+		if envRef != "" {
+			attrs = append(attrs, [2]string{"environment_id", envRef + ".id"})
+		}
+		if poolRef != "" {
+			attrs = append(attrs, [2]string{"pool_id", poolRef})
+		}
+		writeHCLResource(&buf, "ipam_block", label, attrs)
+		writeHCLImport(&buf, "ipam_block", label, b.ID)
+		for _, a := range b.Allocations {
+			allocLabel := uniqueLabel(a.Name, tfLabelInvalid, allocLabels)
+			writeHCLResource(&buf, "ipam_allocation", allocLabel, [][2]string{
+				{"name", hclString(a.Name)},
+				{"block_name", "ipam_block." + label + ".name"},
+				{"cidr", hclString(a.CIDR)},
+			})
+			writeHCLImport(&buf, "ipam_allocation", allocLabel, a.ID)
+		}
+	}
+
+	for _, env := range snap.Environments {
+		label := uniqueLabel(env.Name, tfLabelInvalid, envLabels)
+		envRef := "ipam_environment." + label
+		attrs := [][2]string{{"name", hclString(env.Name)}}
+		if len(env.Pools) > 0 {
+			var pools strings.Builder
+			pools.WriteString("[\n")
+			for _, p := range env.Pools {
+				fmt.Fprintf(&pools, "    { name = %s, cidr = %s },\n", hclString(p.Name), hclString(p.CIDR))
+			}
+			pools.WriteString("  ]")
+			attrs = append(attrs, [2]string{"pools", pools.String()})
+		}
+		writeHCLResource(&buf, "ipam_environment", label, attrs)
+		writeHCLImport(&buf, "ipam_environment", label, env.ID)
+		for i, p := range env.Pools {
+			for _, b := range p.Blocks {
+				writeBlock(b, envRef, fmt.Sprintf("%s.pool_ids[%d]", envRef, i))
+			}
+		}
+		for _, b := range env.Blocks {
+			writeBlock(b, envRef, "")
+		}
+	}
+	for _, b := range snap.Blocks {
+		writeBlock(b, "", "")
+	}
+	if len(snap.ReservedBlocks) > 0 {
+		buf.WriteString("# Reserved blocks (not managed by the provider):\n")
+		for _, r := range snap.ReservedBlocks {
+			fmt.Fprintf(&buf, "#   %s %s\n", r.CIDR, strings.TrimSpace(r.Name+" "+r.Reason))
+		}
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
+
+// exportAnsible renders the snapshot as a YAML Ansible inventory: one group per environment and per block, with each
+// allocation as a host carrying its CIDR in host vars and the block CIDR in group vars.
+func exportAnsible(snap *exportSnapshot) ([]byte, error) {
+	groups, hosts := map[string]bool{}, map[string]bool{}
+	blockGroup := func(b exportBlock, envName string) (string, map[string]interface{}) {
+		groupVars := map[string]interface{}{"ipam_block": b.Name, "ipam_block_cidr": b.CIDR}
+		if envName != "" {
+			groupVars["ipam_environment"] = envName
+		}
+		group := map[string]interface{}{"vars": groupVars}
+		if len(b.Allocations) > 0 {
+			groupHosts := make(map[string]interface{}, len(b.Allocations))
+			for _, a := range b.Allocations {
+				host := a.Name
+				if hosts[host] {
+					host = b.Name + "-" + a.Name
+				}
+				hosts[host] = true
+				groupHosts[host] = map[string]interface{}{"ipam_cidr": a.CIDR, "ipam_allocation": a.Name, "ipam_allocation_id": a.ID.String()}
+			}
+			group["hosts"] = groupHosts
+		}
+		return uniqueLabel("block_"+b.Name, ansibleGroupInvalid, groups), group
+	}
+
+	children := make(map[string]interface{})
+	for _, env := range snap.Environments {
+		envChildren := make(map[string]interface{})
+		var blocks []exportBlock
+		for _, p := range env.Pools {
+			blocks = append(blocks, p.Blocks...)
+		}
+		for _, b := range append(blocks, env.Blocks...) {
+			name, group := blockGroup(b, env.Name)
+			envChildren[name] = group
+		}
+		envGroup := map[string]interface{}{"vars": map[string]interface{}{"ipam_environment": env.Name}}
+		if len(envChildren) > 0 {
+			envGroup["children"] = envChildren
+		}
+		children[uniqueLabel("env_"+env.Name, ansibleGroupInvalid, groups)] = envGroup
+	}
+	for _, b := range snap.Blocks {
+		name, group := blockGroup(b, "")
+		children[name] = group
+	}
+	all := map[string]interface{}{"vars": map[string]interface{}{"ipam_organization": snap.Organization.Name}}
+	if len(children) > 0 {
+		all["children"] = children
+	}
+	return marshalYAML(map[string]interface{}{"all": all})
+}
+
+// ExportHandler writes a complete snapshot of one organization for GET /api/export?format=json|yaml|hcl|ansible.
+// A global admin picks the organization with organization_id.
+func ExportHandler(s store.Storer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ctx := r.Context()
+		user := auth.UserFromContext(ctx)
+		inputOrgID := uuid.Nil
+		if v := r.URL.Query().Get("organization_id"); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				http.Error(w, "Invalid organization_id", http.StatusBadRequest)
+				return
+			}
+			inputOrgID = id
+		}
+		orgID := auth.ResolveOrgID(ctx, user, inputOrgID)
+		if orgID == nil || *orgID == uuid.Nil {
+			http.Error(w, "organization_id is required", http.StatusBadRequest)
+			return
+		}
+		format := strings.ToLower(r.URL.Query().Get("format"))
+		if format == "" {
+			format = "json"
+		}
+		var contentType, filename string
+		switch format {
+		case "json":
+			contentType, filename = "application/json", "ipam-export.json"
+		case "yaml":
+			contentType, filename = "application/yaml", "ipam-export.yaml"
+		case "hcl":
+			contentType, filename = "text/plain; charset=utf-8", "ipam.tf"
+		case "ansible":
+			contentType, filename = "application/yaml", "inventory.yml"
+		default:
+			http.Error(w, "format must be one of json, yaml, hcl, ansible", http.StatusBadRequest)
+			return
+		}
+
+		snap, err := buildExportSnapshot(s, *orgID, time.Now())
+		if err != nil {
+			if _, orgErr := s.GetOrganization(*orgID); orgErr != nil {
+				http.Error(w, "Organization not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to build export", http.StatusInternalServerError)
+			return
+		}
+		var body []byte
+		switch format {
+		case "json":
+			body, err = json.MarshalIndent(snap, "", "  ")
+		case "yaml":
+			if body, err = json.Marshal(snap); err == nil {
+				body, err = jsonToYAML(body)
+			}
+		case "hcl":
+			body = exportHCL(snap)
+		case "ansible":
+			body, err = exportAnsible(snap)
+		}
+		if err != nil {
+			http.Error(w, "Failed to write export", http.StatusInternalServerError)
+			return
+		}
+		if len(body) > 0 && body[len(body)-1] != '\n' {
+			body = append(body, '\n')
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(body)
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"gopkg.in/yaml.v3"
+)
+
+// setupExportTest builds prod (pool "main" holding block "vpc" with two allocations) and an orphan block.
+func setupExportTest(t *testing.T) (*store.Store, context.Context, *network.Environment) {
+	t.Helper()
+	s, ctx, env := setupBulkTest(t)
+	pool := &network.Pool{Name: "main", CIDR: "10.0.0.0/16", EnvironmentID: env.Id, OrganizationID: env.OrganizationID}
+	if err := s.CreatePool(pool); err != nil {
+		t.Fatal(err)
+	}
+	createTestBlock(t, s, &network.Block{Name: "vpc", CIDR: "10.0.0.0/24", EnvironmentID: env.Id, PoolID: &pool.ID}, map[string]string{
+		"web": "10.0.0.0/26",
+		"db":  "10.0.0.64/26",
+	})
+	createTestBlock(t, s, &network.Block{Name: "spare", CIDR: "192.168.0.0/24", OrganizationID: env.OrganizationID}, nil)
+	if err := s.CreateReservedBlock(&store.ReservedBlock{Name: "vpn", CIDR: "172.16.0.0/16", OrganizationID: env.OrganizationID}); err != nil {
+		t.Fatal(err)
+	}
+	return s, ctx, env
+}
+
+func getExport(t *testing.T, s store.Storer, ctx context.Context, h func(store.Storer) http.Handler, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/export"+query, nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	h(s).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestExport_JSONAndYAML(t *testing.T) {
+	s, ctx, env := setupExportTest(t)
+
+	rr := getExport(t, s, ctx, ExportHandler, "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("json export = %d %s: %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	var snap exportSnapshot
+	if err := json.Unmarshal(rr.Body.Bytes(), &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Organization.ID != env.OrganizationID || len(snap.Environments) != 1 || len(snap.Environments[0].Pools) != 1 {
+		t.Fatalf("snapshot = %+v", snap)
+	}
+	vpc := snap.Environments[0].Pools[0].Blocks[0]
+	if vpc.Name != "vpc" || vpc.UsedIPs != "128" || len(vpc.Allocations) != 2 || vpc.Allocations[0].Name != "web" || vpc.Allocations[1].Name != "db" {
+		t.Errorf("pool block = %+v", vpc)
+	}
+	if len(snap.Blocks) != 1 || snap.Blocks[0].Name != "spare" || len(snap.ReservedBlocks) != 1 {
+		t.Errorf("orphans = %+v, reserved = %+v", snap.Blocks, snap.ReservedBlocks)
+	}
+
+	rr = getExport(t, s, ctx, ExportHandler, "?format=yaml")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("yaml export = %d: %s", rr.Code, rr.Body.String())
+	}
+	var fromYAML exportSnapshot
+	var generic map[string]interface{}
+	if err := yaml.Unmarshal(rr.Body.Bytes(), &generic); err != nil {
+		t.Fatalf("yaml: %v", err)
+	}
+	raw, _ := json.Marshal(generic)
+	if err := json.Unmarshal(raw, &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	if fromYAML.Environments[0].Pools[0].Blocks[0].Allocations[1].CIDR != "10.0.0.64/26" || fromYAML.ExportedAt != snap.ExportedAt {
+		t.Errorf("yaml snapshot = %+v", fromYAML)
+	}
+	if !strings.Contains(rr.Body.String(), "cidr: 10.0.0.64/26") {
+		t.Errorf("yaml is not block style:\n%s", rr.Body.String())
+	}
+}
+
+func TestExport_HCL(t *testing.T) {
+	s, ctx, _ := setupExportTest(t)
+	rr := getExport(t, s, ctx, ExportHandler, "?format=hcl")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("hcl export = %d: %s", rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	for _, want := range []string{
+		`resource "ipam_environment" "prod" {`,
+		`{ name = "main", cidr = "10.0.0.0/16" },`,
+		`environment_id = ipam_environment.prod.id`,
+		`pool_id        = ipam_environment.prod.pool_ids[0]`,
+		`block_name = ipam_block.vpc.name`,
+		"import {\n  to = ipam_allocation.web\n",
+		`resource "ipam_block" "spare" {`,
+		"#   172.16.0.0/16 vpn",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("hcl missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestExport_Ansible(t *testing.T) {
+	s, ctx, _ := setupExportTest(t)
+	rr := getExport(t, s, ctx, ExportHandler, "?format=ansible")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ansible export = %d: %s", rr.Code, rr.Body.String())
+	}
+	var inv struct {
+		All struct {
+			Children map[string]struct {
+				Vars     map[string]string `yaml:"vars"`
+				Children map[string]struct {
+					Vars  map[string]string            `yaml:"vars"`
+					Hosts map[string]map[string]string `yaml:"hosts"`
+				} `yaml:"children"`
+			} `yaml:"children"`
+		} `yaml:"all"`
+	}
+	if err := yaml.Unmarshal(rr.Body.Bytes(), &inv); err != nil {
+		t.Fatalf("inventory: %v\n%s", err, rr.Body.String())
+	}
+	block := inv.All.Children["env_prod"].Children["block_vpc"]
+	if block.Vars["ipam_block_cidr"] != "10.0.0.0/24" || block.Hosts["db"]["ipam_cidr"] != "10.0.0.64/26" {
+		t.Errorf("inventory = %+v", inv)
+	}
+	if _, ok := inv.All.Children["block_spare"]; !ok {
+		t.Errorf("orphan block group missing: %+v", inv.All.Children)
+	}
+}
+
+func TestExport_Errors(t *testing.T) {
+	s, ctx, _ := setupExportTest(t)
+	if rr := getExport(t, s, ctx, ExportHandler, "?format=xml"); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown format = %d", rr.Code)
+	}
+	_, globalAdmin, _, _ := setupGlobalAdminTest(t)
+	if rr := getExport(t, s, auth.WithUser(context.Background(), globalAdmin), ExportHandler, ""); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "organization_id is required") {
+		t.Errorf("unscoped global admin = %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestExportCSV_AllocationLevel(t *testing.T) {
+	s, ctx, env := setupExportTest(t)
+	rr := getExport(t, s, ctx, ExportCSVHandler, "/csv?level=allocations")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Header().Get("Content-Disposition"), "ipam-allocations.csv") {
+		t.Fatalf("allocation csv = %d %v", rr.Code, rr.Header())
+	}
+	records, err := csv.NewReader(strings.NewReader(rr.Body.String())).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("records = %v, %v", records, err)
+	}
+	if strings.Join(records[0], ",") != "name,cidr,cidr_start,cidr_end,block_name,environment_name,total_ips" {
+		t.Errorf("header = %v", records[0])
+	}
+	for _, r := range records[1:] {
+		if r[4] != "vpc" || r[5] != "prod" || r[6] != "64" {
+			t.Errorf("row = %v", r)
+		}
+	}
+
+	// The allocation CSV is accepted by the import; rewrite it to target a copy of the block.
+	createTestBlock(t, s, &network.Block{Name: "VPC2", CIDR: "10.0.1.0/24", EnvironmentID: env.Id}, nil)
+	csvBody := strings.ReplaceAll(strings.ReplaceAll(rr.Body.String(), "10.0.0.", "10.0.1."), ",vpc,", ",vpc2,")
+	csvBody = strings.ReplaceAll(strings.ReplaceAll(csvBody, "web,", "web2,"), "db,", "db2,")
+	var out importOutput
+	if err := NewImportUseCase(s).Interact(ctx, importInput{CSV: csvBody}, &out); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if out.Created != 2 || out.Rows[0].Resource != "allocation" {
+		t.Errorf("import = %+v", out)
+	}
+}
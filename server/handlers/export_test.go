@@ -1,7 +1,18 @@
 package handlers
 
 import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
 )
 
 // TestCidrStartEnd tests cidrStartEnd with table-driven cases.
@@ -55,3 +66,161 @@ func TestCidrStartEnd(t *testing.T) {
 		})
 	}
 }
+
+func TestExportCSV_BlockUsage(t *testing.T) {
+	s, ctx, env := setupExportTest(t)
+	createTestBlock(t, s, &network.Block{Name: "edge", CIDR: "10.0.1.0/24", EnvironmentID: env.Id}, map[string]string{"lb": "10.0.1.0/25"})
+	rr := getExport(t, s, ctx, ExportCSVHandler, "/csv")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Length") != "" {
+		t.Fatalf("block csv = %d %v", rr.Code, rr.Header())
+	}
+	records, err := csv.NewReader(strings.NewReader(rr.Body.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := make(map[string]string)
+	for _, r := range records[1:] {
+		used[r[0]] = r[6] + "/" + r[7]
+	}
+	want := map[string]string{"vpc": "128/128", "edge": "128/128", "spare": "0/256"}
+	for name, u := range want {
+		if used[name] != u {
+			t.Errorf("%s used/available = %q, want %q", name, used[name], u)
+		}
+	}
+	if rr := getExport(t, s, ctx, ExportCSVHandler, "/csv?level=subnets"); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown level = %d", rr.Code)
+	}
+}
+
+func TestExportCSV_AllocationCursor(t *testing.T) {
+	s, ctx, env := setupExportTest(t)
+	createTestBlock(t, s, &network.Block{Name: "edge", CIDR: "10.0.1.0/24", EnvironmentID: env.Id}, map[string]string{"lb": "10.0.1.0/25", "nat": "10.0.1.128/25"})
+
+	var names []string
+	query := "/csv?level=allocations&limit=3"
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("cursor did not terminate")
+		}
+		rr := getExport(t, s, ctx, ExportCSVHandler, query)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("page = %d %s", rr.Code, rr.Body.String())
+		}
+		records, err := csv.NewReader(strings.NewReader(rr.Body.String())).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range records[1:] {
+			names = append(names, r[0])
+		}
+		next := rr.Header().Get("X-Next-Cursor")
+		if next == "" {
+			break
+		}
+		query = "/csv?level=allocations&limit=3&cursor=" + next
+	}
+	if len(names) != 4 {
+		t.Errorf("paged allocations = %v, want 4", names)
+	}
+	if rr := getExport(t, s, ctx, ExportCSVHandler, "/csv?level=allocations&cursor=nope"); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid cursor = %d", rr.Code)
+	}
+	if rr := getExport(t, s, ctx, ExportCSVHandler, "/csv?level=allocations&limit=0"); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid limit = %d", rr.Code)
+	}
+}
+
+func TestExportCSV_AllocationsAcrossPages(t *testing.T) {
+	defer func(n int) { exportAllocationPageSize = n }(exportAllocationPageSize)
+	exportAllocationPageSize = 2
+	s, ctx, env := setupExportTest(t)
+	createTestBlock(t, s, &network.Block{Name: "edge", CIDR: "10.0.1.0/24", EnvironmentID: env.Id}, map[string]string{"a": "10.0.1.0/26", "b": "10.0.1.64/26", "c": "10.0.1.128/26", "d": "10.0.1.192/26"})
+	createTestBlock(t, s, &network.Block{Name: "core", CIDR: "10.0.2.0/24", EnvironmentID: env.Id}, map[string]string{"e": "10.0.2.0/25"})
+
+	rr := getExport(t, s, ctx, ExportCSVHandler, "/csv?level=allocations")
+	if rr.Code != http.StatusOK || rr.Header().Get("X-Next-Cursor") != "" {
+		t.Fatalf("export = %d %v", rr.Code, rr.Header())
+	}
+	records, err := csv.NewReader(strings.NewReader(rr.Body.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, r := range records[1:] {
+		seen[r[0]] = true
+	}
+	// Five allocations here plus the two setupExportTest creates, each exported once.
+	if len(records)-1 != 7 || len(seen) != 7 {
+		t.Errorf("exported %d rows (%d distinct), want 7", len(records)-1, len(seen))
+	}
+}
+
+// BenchmarkExportCSV exports organizations of growing size; ns/allocation stays flat because usage for all blocks
+// comes from one store aggregate rather than a scan of the allocations per block.
+func BenchmarkExportCSV(b *testing.B) {
+	for _, n := range []int{1000, 10000, 40000} {
+		s := store.NewStore()
+		org := &store.Organization{Name: "bench"}
+		if err := s.CreateOrganization(org); err != nil {
+			b.Fatal(err)
+		}
+		user := &store.User{Email: "bench@example.com", Role: store.RoleAdmin, OrganizationID: org.ID}
+		if err := s.CreateUser(user); err != nil {
+			b.Fatal(err)
+		}
+		env := &network.Environment{Id: uuid.New(), Name: "prod", OrganizationID: org.ID}
+		if err := s.CreateEnvironment(env); err != nil {
+			b.Fatal(err)
+		}
+		// 64 /26 allocations per /20 block.
+		for i := 0; i < n; i++ {
+			if i%64 == 0 {
+				block := &network.Block{Name: fmt.Sprintf("block-%d", i/64), CIDR: fmt.Sprintf("10.%d.%d.0/20", i/64/16, i/64%16*16), EnvironmentID: env.Id}
+				if err := s.CreateBlock(block); err != nil {
+					b.Fatal(err)
+				}
+			}
+			a := &network.Allocation{Id: uuid.New(), Name: fmt.Sprintf("alloc-%d", i), Block: network.Block{
+				Name: fmt.Sprintf("block-%d", i/64),
+				CIDR: fmt.Sprintf("10.%d.%d.%d/26", i/64/16, i/64%16*16+i%64/4, i%4*64),
+			}}
+			if err := s.CreateAllocation(a.Id, a); err != nil {
+				b.Fatal(err)
+			}
+		}
+		ctx := auth.WithUser(httptest.NewRequest(http.MethodGet, "/", nil).Context(), user)
+		for _, level := range []string{"blocks", "allocations"} {
+			b.Run(fmt.Sprintf("%s/allocations=%d", level, n), func(b *testing.B) {
+				h := ExportCSVHandler(s)
+				req := httptest.NewRequest(http.MethodGet, "/api/export/csv?level="+level, nil).WithContext(ctx)
+				rows := n
+				if level == "blocks" {
+					rows = (n + 63) / 64
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					w := &discardResponseWriter{header: make(http.Header)}
+					h.ServeHTTP(w, req)
+					if w.status != http.StatusOK {
+						b.Fatalf("status %d", w.status)
+					}
+				}
+				b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/float64(n), "ns/allocation")
+				b.ReportMetric(float64(rows), "rows")
+			})
+		}
+	}
+}
+
+// discardResponseWriter drops the body so benchmarks measure the export rather than buffering.
+type discardResponseWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *discardResponseWriter) Header() http.Header { return w.header }
+
+func (w *discardResponseWriter) Write(p []byte) (int, error) { return io.Discard.Write(p) }
+
+func (w *discardResponseWriter) WriteHeader(status int) { w.status = status }
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"net/netip"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+// sumRanges returns the number of addresses in ranges.
+func sumRanges(ranges []network.AddressRange) *big.Int {
+	sum := new(big.Int)
+	for _, r := range ranges {
+		sum.Add(sum, r.Size())
+	}
+	return sum
+}
+
+// reservedWithin returns the parts of cidr covered by reserved blocks, aggregated to a minimal prefix list. Suggest
+// handlers treat these as used so no suggestion lands in reserved space.
+func reservedWithin(cidr string, reserved []*store.ReservedBlock) []string {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil
+	}
+	prefixes := make([]netip.Prefix, 0, len(reserved))
+	for _, r := range reserved {
+		if rp, err := netip.ParsePrefix(r.CIDR); err == nil {
+			prefixes = append(prefixes, rp)
+		}
+	}
+	return network.NewPrefixSet(prefix).Intersect(network.NewPrefixSet(prefixes...)).Strings()
+}
+
+// freeSpaceMap fills output with the free space of cidr given the used CIDRs (allocations of a block, blocks of a
+// pool) and the organization's reserved blocks. Reserved space is reported separately and never counted as free.
+func freeSpaceMap(cidr string, used []string, reserved []*store.ReservedBlock, output *freeSpaceOutput) error {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return status.Wrap(errors.New("invalid CIDR format"), status.InvalidArgument)
+	}
+	prefix = prefix.Masked()
+	output.Reserved = []reservedRangeOutput{}
+	blocked := append([]string(nil), used...)
+	for _, r := range reserved {
+		rp, err := netip.ParsePrefix(r.CIDR)
+		if err != nil || !rp.Overlaps(prefix) {
+			continue
+		}
+		// Prefixes nest, so the part inside is the longer of the two.
+		inside := rp.Masked()
+		if inside.Bits() < prefix.Bits() {
+			inside = prefix
+		}
+		output.Reserved = append(output.Reserved, reservedRangeOutput{ID: r.ID, Name: r.Name, CIDR: inside.String(), Reason: r.Reason})
+		blocked = append(blocked, inside.String())
+	}
+
+	afterUsed, err := network.FreeRanges(cidr, used)
+	if err != nil {
+		return status.Wrap(err, status.InvalidArgument)
+	}
+	free, err := network.FreeRanges(cidr, blocked)
+	if err != nil {
+		return status.Wrap(err, status.InvalidArgument)
+	}
+	total, _ := network.CIDRAddressCount(cidr)
+	unused, freeIPs := sumRanges(afterUsed), sumRanges(free)
+	output.TotalIPs = total.String()
+	output.UsedIPs = new(big.Int).Sub(total, unused).String()
+	output.ReservedIPs = new(big.Int).Sub(unused, freeIPs).String()
+	output.FreeIPs = freeIPs.String()
+
+	output.FreeCIDRs = []string{}
+	output.Free = make([]freeRangeOutput, 0, len(free))
+	var largest netip.Prefix
+	for _, r := range free {
+		out := freeRangeOutput{First: r.First.String(), Last: r.Last.String(), Addresses: r.Size().String()}
+		for _, p := range r.CIDRs() {
+			out.CIDRs = append(out.CIDRs, p.String())
+			if !largest.IsValid() || p.Bits() < largest.Bits() {
+				largest = p
+			}
+		}
+		output.FreeCIDRs = append(output.FreeCIDRs, out.CIDRs...)
+		output.Free = append(output.Free, out)
+	}
+	if largest.IsValid() {
+		output.LargestFreeCIDR = largest.String()
+		output.LargestFreePrefix = largest.Bits()
+		largestSize, _ := network.CIDRAddressCount(largest.String())
+		ratio, _ := new(big.Float).Quo(new(big.Float).SetInt(largestSize), new(big.Float).SetInt(freeIPs)).Float64()
+		output.Fragmentation = math.Round((1-ratio)*10000) / 10000
+	}
+	return nil
+}
+
+// NewGetBlockFreeSpaceUseCase lists the unallocated space of a block.
+func NewGetBlockFreeSpaceUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input getBlockInput, output *freeSpaceOutput) error {
+		block, err := s.GetBlock(input.ID)
+		if err != nil || !blockInUserOrg(ctx, s, auth.UserFromContext(ctx), block) {
+			return status.Wrap(errors.New("block not found"), status.NotFound)
+		}
+		orgID := blockOrgID(s, block)
+		allocs, err := blockAllocations(s, block, orgID)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		used := make([]string, 0, len(allocs))
+		for _, a := range allocs {
+			used = append(used, a.Block.CIDR)
+		}
+		reserved, err := s.ListReservedBlocks(&orgID)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		output.ID, output.Name, output.CIDR = block.ID, block.Name, block.CIDR
+		return freeSpaceMap(block.CIDR, used, reserved, output)
+	})
+
+	u.SetTitle("Get Block Free Space")
+	u.SetDescription("Lists every unallocated range in the block as the minimal set of aligned CIDRs, with the largest available prefix " +
+		"and a fragmentation score (1 - largest free CIDR / free addresses). Reserved blocks overlapping the block are listed separately and are not free.")
+	u.SetExpectedErrors(status.NotFound, status.InvalidArgument, status.Internal)
+	return u
+}
+
+// NewGetPoolFreeSpaceUseCase lists the space of a pool not taken by its blocks.
+func NewGetPoolFreeSpaceUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input getPoolInput, output *freeSpaceOutput) error {
+		pool, err := s.GetPool(input.ID)
+		if err != nil {
+			return status.Wrap(errors.New("pool not found"), status.NotFound)
+		}
+		if userOrg := auth.UserOrgForAccess(ctx, auth.UserFromContext(ctx)); userOrg != uuid.Nil && pool.OrganizationID != userOrg {
+			return status.Wrap(errors.New("pool not found"), status.NotFound)
+		}
+		blocks, err := s.ListBlocksByPool(pool.ID)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		used := make([]string, 0, len(blocks))
+		for _, b := range blocks {
+			used = append(used, b.CIDR)
+		}
+		reserved, err := s.ListReservedBlocks(&pool.OrganizationID)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		output.ID, output.Name, output.CIDR = pool.ID, pool.Name, pool.CIDR
+		return freeSpaceMap(pool.CIDR, used, reserved, output)
+	})
+
+	u.SetTitle("Get Pool Free Space")
+	u.SetDescription("Lists every range of the pool not taken by its blocks as the minimal set of aligned CIDRs, with the largest available prefix " +
+		"and a fragmentation score. Reserved blocks overlapping the pool are listed separately and are not free.")
+	u.SetExpectedErrors(status.NotFound, status.InvalidArgument, status.Internal)
+	return u
+}
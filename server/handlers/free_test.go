@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+)
+
+func TestBlockFreeSpace(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	block := createTestBlock(t, s, &network.Block{Name: "vpc", CIDR: "10.0.0.0/24", EnvironmentID: env.Id}, map[string]string{
+		"a": "10.0.0.0/26",
+		"b": "10.0.0.160/27",
+	})
+	if err := s.CreateReservedBlock(&store.ReservedBlock{Name: "gw", CIDR: "10.0.0.240/28", OrganizationID: env.OrganizationID, Reason: "gateways"}); err != nil {
+		t.Fatal(err)
+	}
+	var out freeSpaceOutput
+	if err := NewGetBlockFreeSpaceUseCase(s).Interact(ctx, getBlockInput{ID: block.ID}, &out); err != nil {
+		t.Fatal(err)
+	}
+	wantFree := []string{"10.0.0.64/26", "10.0.0.128/27", "10.0.0.192/27", "10.0.0.224/28"}
+	if !reflect.DeepEqual(out.FreeCIDRs, wantFree) {
+		t.Errorf("free CIDRs = %v, want %v", out.FreeCIDRs, wantFree)
+	}
+	if len(out.Free) != 2 || out.Free[0].Addresses != "96" || out.Free[1].First != "10.0.0.192" || out.Free[1].Last != "10.0.0.239" {
+		t.Errorf("free ranges = %+v", out.Free)
+	}
+	if out.UsedIPs != "96" || out.ReservedIPs != "16" || out.FreeIPs != "144" {
+		t.Errorf("used/reserved/free = %s/%s/%s", out.UsedIPs, out.ReservedIPs, out.FreeIPs)
+	}
+	if out.LargestFreeCIDR != "10.0.0.64/26" || out.LargestFreePrefix != 26 || out.Fragmentation != 0.5556 {
+		t.Errorf("largest %s /%d fragmentation %v", out.LargestFreeCIDR, out.LargestFreePrefix, out.Fragmentation)
+	}
+	if len(out.Reserved) != 1 || out.Reserved[0].CIDR != "10.0.0.240/28" || out.Reserved[0].Reason != "gateways" {
+		t.Errorf("reserved = %+v", out.Reserved)
+	}
+
+	wantStatus(t, NewGetBlockFreeSpaceUseCase(s).Interact(ctx, getBlockInput{ID: uuid.New()}, &freeSpaceOutput{}), http.StatusNotFound, "block not found")
+}
+
+func TestPoolFreeSpace(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	pool := &network.Pool{Name: "pool", CIDR: "10.0.0.0/16", EnvironmentID: env.Id, OrganizationID: env.OrganizationID}
+	if err := s.CreatePool(pool); err != nil {
+		t.Fatal(err)
+	}
+	createTestBlock(t, s, &network.Block{Name: "a", CIDR: "10.0.0.0/17", EnvironmentID: env.Id, PoolID: &pool.ID}, nil)
+	// A reserved supernet covers the rest of the pool.
+	if err := s.CreateReservedBlock(&store.ReservedBlock{CIDR: "10.0.0.0/8", OrganizationID: env.OrganizationID}); err != nil {
+		t.Fatal(err)
+	}
+	var out freeSpaceOutput
+	if err := NewGetPoolFreeSpaceUseCase(s).Interact(ctx, getPoolInput{ID: pool.ID}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.FreeIPs != "0" || len(out.FreeCIDRs) != 0 || out.LargestFreeCIDR != "" || out.UsedIPs != "32768" || out.ReservedIPs != "32768" {
+		t.Errorf("pool free space = %+v", out)
+	}
+	if len(out.Reserved) != 1 || out.Reserved[0].CIDR != "10.0.0.0/16" {
+		t.Errorf("reserved = %+v", out.Reserved)
+	}
+
+	other := &store.User{Email: "other@example.com", Role: store.RoleAdmin, OrganizationID: uuid.New()}
+	wantStatus(t, NewGetPoolFreeSpaceUseCase(s).Interact(auth.WithUser(context.Background(), other), getPoolInput{ID: pool.ID}, &freeSpaceOutput{}), http.StatusNotFound, "pool not found")
+}
@@ -0,0 +1,409 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/rest"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+const (
+	importStatusCreate = "create"
+	importStatusExists = "exists"
+	importStatusError  = "error"
+)
+
+// importer replays an import against a bulkStaging copy of the organization, one row at a time, so every row is
+// validated by the same use case (and against the rows before it) and failures are reported per row.
+type importer struct {
+	ctx     context.Context
+	staging *bulkStaging
+	orgID   uuid.UUID
+	rows    []importRowOutput
+	err     error // first error staging rows from the store; the import fails with it
+}
+
+// staged records err from loading rows into staging and reports whether the import can go on.
+func (im *importer) staged(err error) bool {
+	if err != nil && im.err == nil {
+		im.err = err
+	}
+	return im.err == nil
+}
+
+// importErrorCode classifies an error returned by the create use cases for the row report.
+func importErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errInvalidCIDR):
+		return "invalid_cidr"
+	case errors.Is(err, errReservedOverlap):
+		return "reserved_overlap"
+	case errors.Is(err, errOverlap):
+		return "overlap"
+	case errors.Is(err, errNotContained):
+		return "not_contained"
+	case errors.Is(err, errEnvironmentNotFound):
+		return "unknown_environment"
+	case errors.Is(err, errBlockNotFound):
+		return "unknown_block"
+	}
+	return "invalid"
+}
+
+// record appends row with the outcome of creating it and reports whether its children can be imported.
+func (im *importer) record(row importRowOutput, id uuid.UUID, err error) bool {
+	if err != nil {
+		_, errResp := rest.Err(err)
+		row.Status, row.Code, row.Error = importStatusError, importErrorCode(err), errResp.ErrorText
+		im.rows = append(im.rows, row)
+		return false
+	}
+	row.Status = importStatusCreate
+	if id != uuid.Nil {
+		row.ID = &id
+	}
+	im.rows = append(im.rows, row)
+	return true
+}
+
+func (im *importer) fail(row importRowOutput, code string, err error) bool {
+	row.Status, row.Code, row.Error = importStatusError, code, err.Error()
+	im.rows = append(im.rows, row)
+	return false
+}
+
+func (im *importer) exists(row importRowOutput, id uuid.UUID) bool {
+	row.Status, row.ID = importStatusExists, &id
+	im.rows = append(im.rows, row)
+	return true
+}
+
+func (im *importer) findEnvironment(name string) *network.Environment {
+	envs, _, err := im.staging.st.ListEnvironmentsFiltered("", &im.orgID, 0, 0)
+	if err != nil {
+		return nil
+	}
+	for _, env := range envs {
+		if strings.EqualFold(strings.TrimSpace(env.Name), strings.TrimSpace(name)) {
+			return env
+		}
+	}
+	return nil
+}
+
+func (im *importer) findBlock(name string) *network.Block {
+	if !im.staged(im.staging.loadBlockNamed(&im.orgID, name)) {
+		return nil
+	}
+	blocks, _, err := im.staging.st.ListBlocksFiltered(name, nil, nil, &im.orgID, false, "", nil, 0, 0)
+	if err != nil {
+		return nil
+	}
+	for _, b := range blocks {
+		if allocationBlockNamesMatch(b.Name, name) {
+			return b
+		}
+	}
+	return nil
+}
+
+func (im *importer) environment(path string, in importEnvironmentInput) {
+	row := importRowOutput{Path: path, Resource: "environment", Name: in.Name}
+	env := im.findEnvironment(in.Name)
+	if env != nil {
+		im.exists(row, env.Id)
+	} else {
+		var out environmentOutput
+		err := NewCreateEnvironmentUseCase(im.staging.st).Interact(im.ctx, createEnvironmentInput{Name: in.Name, OrganizationID: im.orgID}, &out)
+		if !im.record(row, out.Id, err) {
+			return
+		}
+		env = im.findEnvironment(in.Name)
+	}
+	for i, p := range in.Pools {
+		im.pool(fmt.Sprintf("%s.pools[%d]", path, i), env, p)
+	}
+	for i, b := range in.Blocks {
+		im.block(0, fmt.Sprintf("%s.blocks[%d]", path, i), b, env.Id, nil)
+	}
+}
+
+func (im *importer) pool(path string, env *network.Environment, in importPoolInput) {
+	row := importRowOutput{Path: path, Resource: "pool", Name: in.Name, CIDR: in.CIDR}
+	pools, err := im.staging.st.ListPoolsByEnvironment(env.Id)
+	if err != nil {
+		im.fail(row, "invalid", err)
+		return
+	}
+	var poolID uuid.UUID
+	for _, p := range pools {
+		if strings.EqualFold(strings.TrimSpace(p.Name), strings.TrimSpace(in.Name)) {
+			if p.CIDR != in.CIDR || !im.staging.pools[p.ID] {
+				im.fail(row, "duplicate_name", fmt.Errorf("pool %q already exists in environment %q with CIDR %s", p.Name, env.Name, p.CIDR))
+				return
+			}
+			poolID = p.ID
+			im.exists(row, poolID)
+			break
+		}
+	}
+	if poolID == uuid.Nil {
+		var out poolOutput
+		err := NewCreatePoolUseCase(im.staging.st).Interact(im.ctx, createPoolInput{EnvironmentID: env.Id, Name: in.Name, CIDR: in.CIDR}, &out)
+		if !im.record(row, out.ID, err) {
+			return
+		}
+		poolID = out.ID
+	}
+	for i, b := range in.Blocks {
+		im.block(0, fmt.Sprintf("%s.blocks[%d]", path, i), b, env.Id, &poolID)
+	}
+}
+
+// block imports one block. Allocations link to blocks by name, so a name already used in the organization is only
+// accepted when it is the same block (same CIDR and environment) that existed before the import.
+func (im *importer) block(line int, path string, in importBlockInput, envID uuid.UUID, poolID *uuid.UUID) {
+	row := importRowOutput{Row: line, Path: path, Resource: "block", Name: in.Name, CIDR: in.CIDR}
+	if existing := im.findBlock(in.Name); existing != nil {
+		if _, original := im.staging.blocks[existing.ID]; !original || existing.CIDR != in.CIDR || existing.EnvironmentID != envID {
+			im.fail(row, "duplicate_name", fmt.Errorf("block %q already exists with CIDR %s", existing.Name, existing.CIDR))
+			return
+		}
+		im.exists(row, existing.ID)
+	} else {
+		if !im.staged(im.staging.loadSiblings(envID, im.orgID)) {
+			return
+		}
+		create := createBlockInput{Name: in.Name, CIDR: in.CIDR, EnvironmentID: envID, PoolID: poolID, Isolated: in.Isolated}
+		if envID == uuid.Nil {
+			create.OrganizationID = im.orgID
+		}
+		var out blockOutput
+		err := NewCreateBlockUseCase(im.staging.st).Interact(im.ctx, create, &out)
+		if !im.record(row, out.ID, err) {
+			return
+		}
+	}
+	for i, a := range in.Allocations {
+		im.allocation(0, fmt.Sprintf("%s.allocations[%d]", path, i), in.Name, a)
+	}
+}
+
+func (im *importer) allocation(line int, path, blockName string, in importAllocationInput) {
+	row := importRowOutput{Row: line, Path: path, Resource: "allocation", Name: in.Name, CIDR: in.CIDR}
+	if !im.staged(im.staging.loadBlockNamed(&im.orgID, blockName)) {
+		return
+	}
+	allocs, _, err := im.staging.st.ListAllocationsFiltered("", blockName, uuid.Nil, &im.orgID, "", nil, 0, 0)
+	if err != nil {
+		im.fail(row, "invalid", err)
+		return
+	}
+	for _, a := range allocs {
+		if allocationBlockNamesMatch(a.Block.Name, blockName) && strings.EqualFold(strings.TrimSpace(a.Name), strings.TrimSpace(in.Name)) {
+			if _, original := im.staging.allocations[a.Id]; !original || a.Block.CIDR != in.CIDR {
+				im.fail(row, "duplicate_name", fmt.Errorf("allocation %q already exists in block %q with CIDR %s", a.Name, blockName, a.Block.CIDR))
+				return
+			}
+			im.exists(row, a.Id)
+			return
+		}
+	}
+	var out allocationOutput
+	err = NewCreateAllocationUseCase(im.staging.st).Interact(im.ctx, createAllocationInput{Name: in.Name, BlockName: blockName, CIDR: in.CIDR}, &out)
+	im.record(row, out.Id, err)
+}
+
+// importCSV imports blocks from CSV with the columns written by GET /api/export/csv. Only name, cidr and
+// environment_name are read; the derived columns (cidr_start, total_ips, ...) are ignored. A block_name column (as
+// written by ?level=allocations) makes every row an allocation in that block instead.
+func (im *importer) importCSV(data string) error {
+	r := csv.NewReader(strings.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return errors.New("csv is empty")
+	}
+	if err != nil {
+		return fmt.Errorf("invalid csv: %w", err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	for _, required := range []string{"name", "cidr"} {
+		if _, ok := cols[required]; !ok {
+			return fmt.Errorf("csv header must include %q", required)
+		}
+	}
+	resource := "block"
+	_, allocationRows := cols["block_name"]
+	if allocationRows {
+		resource = "allocation"
+	}
+	field := func(record []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return fmt.Errorf("invalid csv: %w", err)
+			}
+			im.fail(importRowOutput{Row: parseErr.StartLine, Resource: resource}, "invalid", err)
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		in := importBlockInput{Name: field(record, "name"), CIDR: field(record, "cidr")}
+		if in.Name == "" && in.CIDR == "" {
+			continue // blank line
+		}
+		if allocationRows {
+			im.allocation(line, "", field(record, "block_name"), importAllocationInput{Name: in.Name, CIDR: in.CIDR})
+			continue
+		}
+		envID := uuid.Nil
+		if envName := field(record, "environment_name"); envName != "" {
+			env := im.findEnvironment(envName)
+			if env == nil {
+				im.fail(importRowOutput{Row: line, Resource: "block", Name: in.Name, CIDR: in.CIDR}, "unknown_environment", fmt.Errorf("environment %q not found", envName))
+				continue
+			}
+			envID = env.Id
+		}
+		im.block(line, "", in, envID, nil)
+	}
+}
+
+func (im *importer) importDocument(doc *importDocumentInput) {
+	for i, env := range doc.Environments {
+		im.environment(fmt.Sprintf("environments[%d]", i), env)
+	}
+	for i, b := range doc.Blocks {
+		im.block(0, fmt.Sprintf("blocks[%d]", i), b, uuid.Nil, nil)
+	}
+}
+
+// importOrganization resolves the target organization. A global admin without an org scope names it with
+// organization_id or, for documents, the document's organization name.
+func importOrganization(ctx context.Context, s store.Storer, user *store.User, input importInput) (uuid.UUID, error) {
+	if orgID := auth.ResolveOrgID(ctx, user, input.OrganizationID); orgID != nil && *orgID != uuid.Nil {
+		if _, err := s.GetOrganization(*orgID); err != nil {
+			return uuid.Nil, status.Wrap(errors.New("organization not found"), status.NotFound)
+		}
+		return *orgID, nil
+	}
+	if input.Document != nil && strings.TrimSpace(input.Document.Organization) != "" {
+		orgs, err := s.ListOrganizations()
+		if err != nil {
+			return uuid.Nil, status.Wrap(err, status.Internal)
+		}
+		for _, org := range orgs {
+			if strings.EqualFold(strings.TrimSpace(org.Name), strings.TrimSpace(input.Document.Organization)) {
+				return org.ID, nil
+			}
+		}
+		return uuid.Nil, status.Wrap(fmt.Errorf("organization %q not found", input.Document.Organization), status.NotFound)
+	}
+	return uuid.Nil, status.Wrap(errors.New("organization_id is required"), status.InvalidArgument)
+}
+
+// NewImportUseCase handles POST /api/import: imports blocks from the export CSV, or a full environment hierarchy from
+// a JSON document, into one organization. Nothing is written unless every row is valid.
+func NewImportUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input importInput, output *importOutput) error {
+		user := auth.UserFromContext(ctx)
+		if user == nil {
+			return status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
+		}
+		if (strings.TrimSpace(input.CSV) == "") == (input.Document == nil) {
+			return status.Wrap(errors.New("exactly one of csv or document is required"), status.InvalidArgument)
+		}
+		orgID, err := importOrganization(ctx, s, user, input)
+		if err != nil {
+			return err
+		}
+		staging := newBulkStaging(s, &orgID)
+		if err := staging.loadEnvironments(orgID); err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+
+		im := &importer{ctx: ctx, staging: staging, orgID: orgID}
+		if input.Document != nil {
+			im.importDocument(input.Document)
+		} else if err := im.importCSV(input.CSV); err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
+		if im.err != nil {
+			return bulkStoreError("stage import", im.err)
+		}
+
+		output.Rows = im.rows
+		if output.Rows == nil {
+			output.Rows = []importRowOutput{}
+		}
+		for i := range output.Rows {
+			switch output.Rows[i].Status {
+			case importStatusCreate:
+				output.Created++
+				if input.ValidateOnly {
+					output.Rows[i].ID = nil // staging IDs are never written
+				}
+			case importStatusExists:
+				output.Existed++
+			case importStatusError:
+				output.Errors++
+			}
+		}
+		if input.ValidateOnly {
+			return nil
+		}
+		if output.Errors > 0 {
+			return usecase.Error{
+				StatusCode: status.InvalidArgument,
+				Value:      fmt.Errorf("%d of %d rows failed; nothing was imported", output.Errors, len(output.Rows)),
+				Context:    map[string]interface{}{"rows": output.Rows},
+			}
+		}
+
+		changes, err := staging.changes(s)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		if !changes.Empty() {
+			if err := s.ApplyBulk(changes); err != nil {
+				return bulkStoreError("apply import", err)
+			}
+		}
+		output.Applied = true
+		return nil
+	})
+
+	u.SetTitle("Import")
+	u.SetDescription("Imports blocks from CSV in the format of GET /api/export/csv (name, cidr, environment_name; environments must exist), " +
+		"or a JSON document describing environments, pools, blocks and allocations (missing environments and pools are created). " +
+		"Rows that already exist with the same CIDR are left unchanged, so an export can be re-imported. " +
+		"Every row is validated with the same rules as the single create endpoints; with validate_only the row report is returned without writing anything. " +
+		"Otherwise either every row is imported or none is: on failure the response is 400 with the row report in context.rows, " +
+		"and 409 when rows it depends on were changed by another request in the meantime.")
+	u.SetExpectedErrors(status.Unauthenticated, status.InvalidArgument, status.NotFound, status.Aborted, status.Internal)
+	return u
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/usecase"
+)
+
+func TestImport_CSVValidateOnlyReportsRowErrors(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	if err := s.CreateBlock(&network.Block{Name: "existing", CIDR: "10.9.0.0/16", EnvironmentID: env.Id}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateReservedBlock(&store.ReservedBlock{CIDR: "10.8.0.0/16", OrganizationID: env.OrganizationID}); err != nil {
+		t.Fatal(err)
+	}
+
+	csv := "name,cidr,cidr_start,cidr_end,environment_name,total_ips,used_ips,available_ips\n" +
+		"vpc-a,10.0.0.0/16,10.0.0.0,10.0.255.255,prod,65536,0,65536\n" +
+		"bad,10.0.0.0/33,,,prod,,,\n" +
+		"vpc-b,10.0.128.0/17,,,prod,,,\n" +
+		"vpc-c,10.8.1.0/24,,,prod,,,\n" +
+		"vpc-d,10.1.0.0/16,,,staging,,,\n" +
+		"existing,10.9.0.0/16,,,prod,,,\n"
+	var out importOutput
+	if err := NewImportUseCase(s).Interact(ctx, importInput{CSV: csv, ValidateOnly: true}, &out); err != nil {
+		t.Fatalf("Interact: %v", err)
+	}
+	want := []struct {
+		row          int
+		status, code string
+	}{
+		{2, importStatusCreate, ""},
+		{3, importStatusError, "invalid_cidr"},
+		{4, importStatusError, "overlap"},
+		{5, importStatusError, "reserved_overlap"},
+		{6, importStatusError, "unknown_environment"},
+		{7, importStatusExists, ""},
+	}
+	if len(out.Rows) != len(want) {
+		t.Fatalf("rows = %+v, want %d", out.Rows, len(want))
+	}
+	for i, w := range want {
+		r := out.Rows[i]
+		if r.Row != w.row || r.Status != w.status || r.Code != w.code {
+			t.Errorf("row %d = %+v, want line %d status %q code %q", i, r, w.row, w.status, w.code)
+		}
+	}
+	if out.Applied || out.Created != 1 || out.Existed != 1 || out.Errors != 4 {
+		t.Errorf("summary = applied %v created %d existed %d errors %d", out.Applied, out.Created, out.Existed, out.Errors)
+	}
+	if blocks, _ := s.ListBlocks(); len(blocks) != 1 {
+		t.Errorf("blocks after validate_only = %d, want 1 (nothing written)", len(blocks))
+	}
+
+	// Without validate_only the same input is rejected as a whole.
+	err := NewImportUseCase(s).Interact(ctx, importInput{CSV: csv}, &importOutput{})
+	var ucErr usecase.Error
+	if !errors.As(err, &ucErr) || ucErr.Context["rows"] == nil {
+		t.Fatalf("Interact err = %v, want usecase.Error with rows", err)
+	}
+	if blocks, _ := s.ListBlocks(); len(blocks) != 1 {
+		t.Errorf("blocks after failed import = %d, want 1", len(blocks))
+	}
+}
+
+func TestImport_DocumentCreatesHierarchy(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	doc := &importDocumentInput{
+		Environments: []importEnvironmentInput{
+			{
+				Name: "prod", // exists
+				Pools: []importPoolInput{{
+					Name: "prod-pool", CIDR: "10.0.0.0/8",
+					Blocks: []importBlockInput{{
+						Name: "vpc-1", CIDR: "10.1.0.0/16",
+						Allocations: []importAllocationInput{{Name: "web", CIDR: "10.1.0.0/24"}, {Name: "db", CIDR: "10.1.1.0/24"}},
+					}},
+				}},
+			},
+			{
+				Name:   "dev",
+				Blocks: []importBlockInput{{Name: "dev-vpc", CIDR: "172.16.0.0/16"}},
+			},
+		},
+		Blocks: []importBlockInput{{Name: "orphan", CIDR: "192.168.0.0/24"}},
+	}
+	var out importOutput
+	if err := NewImportUseCase(s).Interact(ctx, importInput{Document: doc}, &out); err != nil {
+		t.Fatalf("Interact: %v", err)
+	}
+	if !out.Applied || out.Created != 7 || out.Existed != 1 || out.Errors != 0 {
+		t.Fatalf("output = %+v", out)
+	}
+	for _, r := range out.Rows {
+		if r.ID == nil {
+			t.Errorf("row %s has no id", r.Path)
+		}
+	}
+
+	envs, _ := s.ListEnvironments()
+	if len(envs) != 2 {
+		t.Errorf("environments = %d, want 2", len(envs))
+	}
+	pools, _ := s.ListPoolsByEnvironment(env.Id)
+	if len(pools) != 1 || pools[0].CIDR != "10.0.0.0/8" {
+		t.Fatalf("pools in prod = %+v", pools)
+	}
+	block, err := s.GetBlock(*out.Rows[2].ID)
+	if err != nil || block.Name != "vpc-1" || block.PoolID == nil || *block.PoolID != pools[0].ID {
+		t.Errorf("vpc-1 = %+v, %v; want in pool %s", block, err, pools[0].ID)
+	}
+	if allocs, _ := s.ListAllocations(); len(allocs) != 2 {
+		t.Errorf("allocations = %d, want 2", len(allocs))
+	}
+
+	// Re-importing the same document changes nothing.
+	out = importOutput{}
+	if err := NewImportUseCase(s).Interact(ctx, importInput{Document: doc}, &out); err != nil {
+		t.Fatalf("re-import: %v", err)
+	}
+	if out.Created != 0 || out.Existed != 8 {
+		t.Errorf("re-import created %d existed %d, want 0 and 8", out.Created, out.Existed)
+	}
+}
+
+func TestImport_GlobalAdminNeedsOrganization(t *testing.T) {
+	s, globalAdmin, org, _ := setupGlobalAdminTest(t)
+	ctx := auth.WithUser(context.Background(), globalAdmin)
+	doc := &importDocumentInput{Blocks: []importBlockInput{{Name: "orphan", CIDR: "10.0.0.0/24"}}}
+
+	err := NewImportUseCase(s).Interact(ctx, importInput{Document: doc}, &importOutput{})
+	if err == nil {
+		t.Fatal("import without organization succeeded")
+	}
+
+	doc.Organization = org.Name
+	var out importOutput
+	if err := NewImportUseCase(s).Interact(ctx, importInput{Document: doc}, &out); err != nil {
+		t.Fatalf("Interact: %v", err)
+	}
+	block, err := s.GetBlock(*out.Rows[0].ID)
+	if err != nil || block.OrganizationID != org.ID || block.EnvironmentID != uuid.Nil {
+		t.Errorf("orphan block = %+v, %v; want in org %s", block, err, org.ID)
+	}
+}
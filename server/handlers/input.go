@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 
+	"github.com/JakeNeyer/ipam/network"
 	"github.com/google/uuid"
 )
 
@@ -82,9 +83,11 @@ type getPoolInput struct {
 }
 
 type suggestPoolBlockCIDRInput struct {
-	ID     uuid.UUID `path:"id" required:"true" format:"uuid"`
-	Prefix int       `query:"prefix" minimum:"1" maximum:"32"`
-	_      struct{}  `additionalProperties:"false"`
+	ID       uuid.UUID `path:"id" required:"true" format:"uuid"`
+	Prefix   int       `query:"prefix" minimum:"1" maximum:"32"`
+	Strategy string    `query:"strategy" enum:"best_fit,first_fit,last_fit,sparse,aligned_to"` // optional; default best_fit
+	AlignTo  int       `query:"align_to" minimum:"0" maximum:"32"`                             // boundary prefix length for aligned_to
+	_        struct{}  `additionalProperties:"false"`
 }
 
 type listPoolsInput struct {
@@ -109,6 +112,7 @@ type createBlockInput struct {
 	EnvironmentID  uuid.UUID  `json:"environment_id,omitempty" format:"uuid"`
 	OrganizationID uuid.UUID  `json:"organization_id,omitempty" format:"uuid"` // required for orphan blocks (no environment)
 	PoolID         *uuid.UUID `json:"pool_id,omitempty" format:"uuid"`         // optional; block CIDR must be contained in pool's CIDR
+	Isolated       bool       `json:"isolated,omitempty"`                      // optional; intentional duplicate excluded from overlap analysis
 	_              struct{}   `additionalProperties:"false"`
 }
 
@@ -118,9 +122,11 @@ type getBlockInput struct {
 }
 
 type suggestBlockCIDRInput struct {
-	ID     uuid.UUID `path:"id" required:"true" format:"uuid"`
-	Prefix int       `query:"prefix" minimum:"1" maximum:"32"`
-	_      struct{}  `additionalProperties:"false"`
+	ID       uuid.UUID `path:"id" required:"true" format:"uuid"`
+	Prefix   int       `query:"prefix" minimum:"1" maximum:"32"`
+	Strategy string    `query:"strategy" enum:"best_fit,first_fit,last_fit,sparse,aligned_to"` // optional; default best_fit
+	AlignTo  int       `query:"align_to" minimum:"0" maximum:"32"`                             // boundary prefix length for aligned_to
+	_        struct{}  `additionalProperties:"false"`
 }
 
 type updateBlockInput struct {
@@ -129,6 +135,38 @@ type updateBlockInput struct {
 	EnvironmentID  *uuid.UUID `json:"environment_id,omitempty" format:"uuid"`
 	OrganizationID *uuid.UUID `json:"organization_id,omitempty" format:"uuid"` // for orphan blocks
 	PoolID         *uuid.UUID `json:"pool_id,omitempty" format:"uuid"`         // optional; block CIDR must be contained in pool's CIDR
+	Isolated       *bool      `json:"isolated,omitempty"`                      // optional; omit to keep current value
+	_              struct{}   `additionalProperties:"false"`
+}
+
+type resizeBlockInput struct {
+	ID      uuid.UUID `json:"id" path:"id" required:"true" format:"uuid"`
+	CIDR    string    `json:"cidr" required:"true" minLength:"9" maxLength:"50"` // new CIDR; must still contain every allocation
+	Preview bool      `json:"preview,omitempty"`                                 // validate and return the result without changing anything
+	_       struct{}  `additionalProperties:"false"`
+}
+
+type splitBlockInput struct {
+	ID      uuid.UUID `json:"id" path:"id" required:"true" format:"uuid"`
+	Parts   int       `json:"parts" required:"true" minimum:"2" maximum:"256"` // power of two
+	Names   []string  `json:"names,omitempty"`                                 // optional; one per part, default <name>-1 ... <name>-N
+	Preview bool      `json:"preview,omitempty"`
+	_       struct{}  `additionalProperties:"false"`
+}
+
+type mergeBlocksInput struct {
+	BlockIDs []uuid.UUID `json:"block_ids" required:"true" minItems:"2" maxItems:"256"`
+	Name     string      `json:"name,omitempty" maxLength:"255"` // optional; default is the name of the lowest block
+	Preview  bool        `json:"preview,omitempty"`
+	_        struct{}    `additionalProperties:"false"`
+}
+
+type moveBlockInput struct {
+	ID             uuid.UUID  `json:"id" path:"id" required:"true" format:"uuid"`
+	EnvironmentID  *uuid.UUID `json:"environment_id,omitempty" format:"uuid"`  // destination environment; omit to make the block an orphan of organization_id
+	PoolID         *uuid.UUID `json:"pool_id,omitempty" format:"uuid"`         // optional pool in the destination environment
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty" format:"uuid"` // destination organization for orphan blocks
+	DryRun         bool       `json:"dry_run,omitempty"`                       // validate and return the result without moving anything
 	_              struct{}   `additionalProperties:"false"`
 }
 
@@ -144,9 +182,33 @@ type autoAllocateInput struct {
 	Name         string   `json:"name" required:"true" minLength:"1" maxLength:"255"`
 	BlockName    string   `json:"block_name" required:"true" minLength:"1" maxLength:"255"`
 	PrefixLength int      `json:"prefix_length" required:"true" minimum:"1" maximum:"32"`
+	Strategy     string   `json:"strategy,omitempty" enum:"best_fit,first_fit,last_fit,sparse,aligned_to"` // optional; default best_fit
+	AlignTo      int      `json:"align_to,omitempty" minimum:"0" maximum:"32"`                             // boundary prefix length for aligned_to
 	_            struct{} `additionalProperties:"false"`
 }
 
+type autoAllocateBatchItem struct {
+	Name         string   `json:"name" required:"true" minLength:"1" maxLength:"255"`
+	PrefixLength int      `json:"prefix_length" required:"true" minimum:"1" maximum:"32"`
+	_            struct{} `additionalProperties:"false"`
+}
+
+type autoAllocateBatchInput struct {
+	BlockName   string                  `json:"block_name" required:"true" minLength:"1" maxLength:"255"`
+	Allocations []autoAllocateBatchItem `json:"allocations" required:"true" minItems:"1" maxItems:"256"`
+	Strategy    string                  `json:"strategy,omitempty" enum:"best_fit,first_fit,last_fit,sparse,aligned_to"` // optional; default best_fit
+	AlignTo     int                     `json:"align_to,omitempty" minimum:"0" maximum:"32"`                             // boundary prefix length for aligned_to
+	DryRun      bool                    `json:"dry_run,omitempty"`
+	_           struct{}                `additionalProperties:"false"`
+}
+
+type moveAllocationInput struct {
+	ID      uuid.UUID `json:"id" path:"id" required:"true" format:"uuid"`
+	BlockID uuid.UUID `json:"block_id" required:"true" format:"uuid"` // destination block; its CIDR must contain the allocation
+	DryRun  bool      `json:"dry_run,omitempty"`
+	_       struct{}  `additionalProperties:"false"`
+}
+
 type getAllocationInput struct {
 	ID uuid.UUID `json:"id" path:"id" required:"true" format:"uuid"`
 	_  struct{}  `additionalProperties:"false"`
@@ -189,10 +251,11 @@ type createIntegrationInput struct {
 	OrganizationID      uuid.UUID       `json:"organization_id,omitempty" format:"uuid"` // optional; resolved from auth when nil (org user or global admin with selected org)
 	Provider            string          `json:"provider" required:"true" minLength:"1" maxLength:"32"`
 	Name                string          `json:"name" required:"true" minLength:"1" maxLength:"255"`
-	Config              json.RawMessage `json:"config"`                          // provider-specific (e.g. aws: region, environment_id)
-	SyncIntervalMinutes *int            `json:"sync_interval_minutes,omitempty"` // 0=off; 1-1440=minutes; default 5
-	SyncMode            string          `json:"sync_mode,omitempty"`             // "read_only" | "read_write"; default "read_only"
-	ConflictResolution  string          `json:"conflict_resolution,omitempty"`   // "cloud" | "ipam"; default "cloud"
+	Config              json.RawMessage `json:"config"`                                    // provider-specific (e.g. aws: region, environment_id)
+	SyncIntervalMinutes *int            `json:"sync_interval_minutes,omitempty"`           // 0=off; 1-1440=minutes; default 5
+	SyncMode            string          `json:"sync_mode,omitempty"`                       // "read_only" | "read_write"; default "read_only"
+	ConflictResolution  string          `json:"conflict_resolution,omitempty"`             // "cloud" | "ipam"; default "cloud"
+	CredentialsRef      *string         `json:"credentials_ref,omitempty" maxLength:"512"` // optional; "env:<name>", "file:<name>" or "vault:<path>"; unset = ambient credentials
 	_                   struct{}        `additionalProperties:"false"`
 }
 
@@ -205,9 +268,11 @@ type updateIntegrationInput struct {
 	ID                  uuid.UUID       `path:"id" required:"true" format:"uuid"`
 	Name                string          `json:"name" required:"true" minLength:"1" maxLength:"255"`
 	Config              json.RawMessage `json:"config"`
-	SyncIntervalMinutes *int            `json:"sync_interval_minutes,omitempty"` // 0=off; 1-1440=minutes
-	SyncMode            string          `json:"sync_mode,omitempty"`             // "read_only" | "read_write"
-	ConflictResolution  string          `json:"conflict_resolution,omitempty"`   // "cloud" | "ipam"
+	SyncIntervalMinutes *int            `json:"sync_interval_minutes,omitempty"`           // 0=off; 1-1440=minutes
+	SyncMode            string          `json:"sync_mode,omitempty"`                       // "read_only" | "read_write"
+	ConflictResolution  string          `json:"conflict_resolution,omitempty"`             // "cloud" | "ipam"
+	CredentialsRef      *string         `json:"credentials_ref,omitempty" maxLength:"512"` // optional; empty string clears the reference
+	SyncPaused          *bool           `json:"sync_paused,omitempty"`                     // optional; true stops background sync until set back to false
 	_                   struct{}        `additionalProperties:"false"`
 }
 
@@ -220,3 +285,109 @@ type syncIntegrationInput struct {
 	ID uuid.UUID `path:"id" required:"true" format:"uuid"`
 	_  struct{}  `additionalProperties:"false"`
 }
+
+// Analysis Input Types
+type overlapReportInput struct {
+	OrganizationID  uuid.UUID `query:"organization_id" format:"uuid"` // optional; global admin uses this to pick the org
+	IncludeIsolated bool      `query:"include_isolated"`              // optional; also report blocks marked isolated
+	_               struct{}  `additionalProperties:"false"`
+}
+
+type connectivityCheckInput struct {
+	BlockIDs []uuid.UUID `json:"block_ids" required:"true" minItems:"2" maxItems:"500"` // blocks that would be peered or attached to the same transit
+	_        struct{}    `additionalProperties:"false"`
+}
+
+// Bulk Input Types
+type bulkOperationInput struct {
+	Op       string          `json:"op" required:"true" enum:"create,update,delete"`
+	Resource string          `json:"resource" required:"true" enum:"block,allocation,reserved_block"`
+	ID       uuid.UUID       `json:"id,omitempty" format:"uuid"` // required for update and delete
+	Data     json.RawMessage `json:"data,omitempty"`             // create/update body of the matching single endpoint
+	_        struct{}        `additionalProperties:"false"`
+}
+
+type bulkInput struct {
+	Operations []bulkOperationInput `json:"operations" required:"true" minItems:"1" maxItems:"1000"`
+	_          struct{}             `additionalProperties:"false"`
+}
+
+// Import Input Types
+type importAllocationInput struct {
+	Name string   `json:"name" required:"true" minLength:"1" maxLength:"255"`
+	CIDR string   `json:"cidr" required:"true"`
+	_    struct{} `additionalProperties:"false"`
+}
+
+type importBlockInput struct {
+	Name        string                  `json:"name" required:"true" minLength:"1" maxLength:"255"`
+	CIDR        string                  `json:"cidr" required:"true"`
+	Isolated    bool                    `json:"isolated,omitempty"`
+	Allocations []importAllocationInput `json:"allocations,omitempty"`
+	_           struct{}                `additionalProperties:"false"`
+}
+
+type importPoolInput struct {
+	Name   string             `json:"name" required:"true" minLength:"1" maxLength:"255"`
+	CIDR   string             `json:"cidr" required:"true"`
+	Blocks []importBlockInput `json:"blocks,omitempty"`
+	_      struct{}           `additionalProperties:"false"`
+}
+
+type importEnvironmentInput struct {
+	Name   string             `json:"name" required:"true" minLength:"1" maxLength:"255"`
+	Pools  []importPoolInput  `json:"pools,omitempty"`
+	Blocks []importBlockInput `json:"blocks,omitempty"` // blocks not drawn from a pool
+	_      struct{}           `additionalProperties:"false"`
+}
+
+// importDocumentInput is one organization's Environment → Pool → Block → Allocation hierarchy.
+type importDocumentInput struct {
+	Organization string                   `json:"organization,omitempty"` // organization name; global admin may use it instead of organization_id
+	Environments []importEnvironmentInput `json:"environments,omitempty"`
+	Blocks       []importBlockInput       `json:"blocks,omitempty"` // orphan blocks (no environment)
+	_            struct{}                 `additionalProperties:"false"`
+}
+
+type importInput struct {
+	CSV            string               `json:"csv,omitempty"`      // CSV with the columns of GET /api/export/csv (name, cidr, environment_name; others ignored)
+	Document       *importDocumentInput `json:"document,omitempty"` // alternative to csv
+	OrganizationID uuid.UUID            `json:"organization_id,omitempty" format:"uuid"`
+	ValidateOnly   bool                 `json:"validate_only,omitempty"` // report what would happen without applying anything
+	_              struct{}             `additionalProperties:"false"`
+}
+
+// Blueprint Input Types
+type createBlueprintInput struct {
+	Name           string                `json:"name" required:"true" minLength:"1" maxLength:"255"`
+	Description    string                `json:"description,omitempty" maxLength:"1024"`
+	OrganizationID uuid.UUID             `json:"organization_id,omitempty" format:"uuid"` // required for global admin
+	Spec           network.BlueprintSpec `json:"spec" required:"true"`
+	_              struct{}              `additionalProperties:"false"`
+}
+
+type listBlueprintsInput struct {
+	OrganizationID uuid.UUID `query:"organization_id" format:"uuid"`
+	_              struct{}  `additionalProperties:"false"`
+}
+
+type getBlueprintInput struct {
+	ID uuid.UUID `path:"id" required:"true" format:"uuid"`
+	_  struct{}  `additionalProperties:"false"`
+}
+
+type updateBlueprintInput struct {
+	ID          uuid.UUID             `json:"id" path:"id" required:"true" format:"uuid"`
+	Name        string                `json:"name" required:"true" minLength:"1" maxLength:"255"`
+	Description string                `json:"description,omitempty" maxLength:"1024"`
+	Spec        network.BlueprintSpec `json:"spec" required:"true"`
+	_           struct{}              `additionalProperties:"false"`
+}
+
+type instantiateBlueprintInput struct {
+	ID           uuid.UUID `json:"id" path:"id" required:"true" format:"uuid"`
+	ParentPoolID uuid.UUID `json:"parent_pool_id" required:"true" format:"uuid"`
+	Name         string    `json:"name" required:"true" minLength:"1" maxLength:"255"` // fills {name} in naming patterns
+	Preview      bool      `json:"preview,omitempty"`
+	_            struct{}  `additionalProperties:"false"`
+}
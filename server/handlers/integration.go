@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/JakeNeyer/ipam/internal/integrations"
 	"github.com/JakeNeyer/ipam/internal/integrations/aws" // register AWS provider + ParseAWSConfig
 	"github.com/JakeNeyer/ipam/internal/logger"
+	"github.com/JakeNeyer/ipam/internal/secrets"
 	"github.com/JakeNeyer/ipam/server/auth"
 	"github.com/JakeNeyer/ipam/store"
 	"github.com/google/uuid"
@@ -39,6 +41,24 @@ func normalizeConflictResolution(v string) string {
 	}
 }
 
+// normalizeCredentialsRef trims ref and validates it against the configured secrets backends.
+// Returns nil for an empty reference (use the provider's ambient credentials). References are
+// resolved under the connection's organization at sync time, so they cannot name another
+// organization's secrets.
+func normalizeCredentialsRef(ref *string) (*string, error) {
+	if ref == nil {
+		return nil, nil
+	}
+	v := strings.TrimSpace(*ref)
+	if v == "" {
+		return nil, nil
+	}
+	if err := secrets.Default().Validate(v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
 func cloudConnectionToOutput(c *store.CloudConnection) *integrationOutput {
 	syncMode := c.SyncMode
 	if syncMode == "" {
@@ -57,6 +77,7 @@ func cloudConnectionToOutput(c *store.CloudConnection) *integrationOutput {
 		SyncIntervalMinutes: c.SyncIntervalMinutes,
 		SyncMode:            syncMode,
 		ConflictResolution:  conflictRes,
+		CredentialsRef:      c.CredentialsRef,
 		CreatedAt:           c.CreatedAt.Format(time.RFC3339),
 		UpdatedAt:           c.UpdatedAt.Format(time.RFC3339),
 	}
@@ -66,6 +87,12 @@ func cloudConnectionToOutput(c *store.CloudConnection) *integrationOutput {
 	}
 	out.LastSyncStatus = c.LastSyncStatus
 	out.LastSyncError = c.LastSyncError
+	out.SyncPaused = c.SyncPaused
+	out.ConsecutiveSyncFailures = c.ConsecutiveSyncFailures
+	if c.SyncBackoffUntil != nil {
+		s := c.SyncBackoffUntil.Format(time.RFC3339)
+		out.SyncBackoffUntil = &s
+	}
 	return out
 }
 
@@ -127,6 +154,10 @@ func NewCreateIntegrationUseCase(s store.Storer) usecase.Interactor {
 		}
 		syncMode := normalizeSyncMode(input.SyncMode)
 		conflictRes := normalizeConflictResolution(input.ConflictResolution)
+		credRef, err := normalizeCredentialsRef(input.CredentialsRef)
+		if err != nil {
+			return status.Wrap(err, status.InvalidArgument)
+		}
 		c := &store.CloudConnection{
 			ID:                  s.GenerateID(),
 			OrganizationID:      *orgID,
@@ -136,6 +167,7 @@ func NewCreateIntegrationUseCase(s store.Storer) usecase.Interactor {
 			SyncIntervalMinutes: syncInterval,
 			SyncMode:            syncMode,
 			ConflictResolution:  conflictRes,
+			CredentialsRef:      credRef,
 		}
 		if err := s.CreateCloudConnection(c); err != nil {
 			return status.Wrap(err, status.Internal)
@@ -206,6 +238,16 @@ func NewUpdateIntegrationUseCase(s store.Storer) usecase.Interactor {
 		if input.ConflictResolution != "" {
 			c.ConflictResolution = normalizeConflictResolution(input.ConflictResolution)
 		}
+		if input.CredentialsRef != nil {
+			credRef, err := normalizeCredentialsRef(input.CredentialsRef)
+			if err != nil {
+				return status.Wrap(err, status.InvalidArgument)
+			}
+			c.CredentialsRef = credRef
+		}
+		if input.SyncPaused != nil {
+			c.SyncPaused = *input.SyncPaused
+		}
 		if err := s.UpdateCloudConnection(input.ID, c); err != nil {
 			return status.Wrap(err, status.Internal)
 		}
@@ -215,7 +257,7 @@ func NewUpdateIntegrationUseCase(s store.Storer) usecase.Interactor {
 	})
 	u.SetTitle("Update Integration")
 	u.SetDescription("Update a cloud connection")
-	u.SetExpectedErrors(status.Unauthenticated, status.NotFound, status.Internal)
+	u.SetExpectedErrors(status.Unauthenticated, status.InvalidArgument, status.NotFound, status.Internal)
 	return u
 }
 
@@ -255,10 +297,11 @@ func RunSyncForConnection(ctx context.Context, s store.Storer, connID uuid.UUID)
 	logger.Info("sync full started", slog.String("connection_id", connID.String()), slog.String("connection_name", c.Name))
 	now := time.Now()
 	statusStr := "syncing"
-	c.LastSyncAt = &now
-	c.LastSyncStatus = &statusStr
-	c.LastSyncError = nil
-	if err := s.UpdateCloudConnection(connID, c); err != nil {
+	st := c.SyncState()
+	st.LastSyncAt = &now
+	st.LastSyncStatus = &statusStr
+	st.LastSyncError = nil
+	if err := s.UpdateCloudConnectionSyncState(connID, st); err != nil {
 		return err
 	}
 	// Which resources to sync (AWS config; default all true for other providers)
@@ -271,24 +314,14 @@ func RunSyncForConnection(ctx context.Context, s store.Storer, connID uuid.UUID)
 	if syncPools {
 		if err := integrations.SyncPools(ctx, s, connID); err != nil {
 			logger.Error("sync full failed: pools", slog.String("connection_id", connID.String()), slog.String("connection_name", c.Name), logger.ErrAttr(err))
-			errStr := err.Error()
-			c.LastSyncError = &errStr
-			statusStr = "failed"
-			c.LastSyncStatus = &statusStr
-			_ = s.UpdateCloudConnection(connID, c)
-			return err
+			return recordSyncFailure(s, connID, err)
 		}
 		// Push app pools (in target env with no external_id yet) to the cloud when read-write
 		if c.SyncMode == "read_write" && c.Provider == "aws" {
 			if cfg, _ := aws.ParseAWSConfig(c.Config); cfg != nil && cfg.EnvironmentID != uuid.Nil {
 				if err := integrations.PushPoolsToCloud(ctx, s, c, cfg.EnvironmentID); err != nil {
 					logger.Error("sync full failed: push pools to cloud", slog.String("connection_id", connID.String()), slog.String("connection_name", c.Name), logger.ErrAttr(err))
-					errStr := err.Error()
-					c.LastSyncError = &errStr
-					statusStr = "failed"
-					c.LastSyncStatus = &statusStr
-					_ = s.UpdateCloudConnection(connID, c)
-					return err
+					return recordSyncFailure(s, connID, err)
 				}
 			}
 		}
@@ -296,35 +329,20 @@ func RunSyncForConnection(ctx context.Context, s store.Storer, connID uuid.UUID)
 		if c.SyncMode == "read_write" && c.ConflictResolution == "ipam" {
 			if err := integrations.ApplyPoolDeletesInCloud(ctx, s, c); err != nil {
 				logger.Error("sync full failed: apply pool deletes in cloud", slog.String("connection_id", connID.String()), slog.String("connection_name", c.Name), logger.ErrAttr(err))
-				errStr := err.Error()
-				c.LastSyncError = &errStr
-				statusStr = "failed"
-				c.LastSyncStatus = &statusStr
-				_ = s.UpdateCloudConnection(connID, c)
-				return err
+				return recordSyncFailure(s, connID, err)
 			}
 		}
 	}
 	if syncBlocks {
 		if err := integrations.SyncBlocks(ctx, s, connID); err != nil {
 			logger.Error("sync full failed: blocks", slog.String("connection_id", connID.String()), slog.String("connection_name", c.Name), logger.ErrAttr(err))
-			errStr := err.Error()
-			c.LastSyncError = &errStr
-			statusStr = "failed"
-			c.LastSyncStatus = &statusStr
-			_ = s.UpdateCloudConnection(connID, c)
-			return err
+			return recordSyncFailure(s, connID, err)
 		}
 		// Push app blocks (in synced pools with no external_id yet) to the cloud when read-write
 		if c.SyncMode == "read_write" {
 			if err := integrations.PushBlocksToCloud(ctx, s, c); err != nil {
 				logger.Error("sync full failed: push blocks to cloud", slog.String("connection_id", connID.String()), slog.String("connection_name", c.Name), logger.ErrAttr(err))
-				errStr := err.Error()
-				c.LastSyncError = &errStr
-				statusStr = "failed"
-				c.LastSyncStatus = &statusStr
-				_ = s.UpdateCloudConnection(connID, c)
-				return err
+				return recordSyncFailure(s, connID, err)
 			}
 		}
 	}
@@ -332,23 +350,13 @@ func RunSyncForConnection(ctx context.Context, s store.Storer, connID uuid.UUID)
 	if syncAllocations {
 		if err := integrations.SyncAllocations(ctx, s, connID, syncedBlocks); err != nil {
 			logger.Error("sync full failed: allocations", slog.String("connection_id", connID.String()), slog.String("connection_name", c.Name), logger.ErrAttr(err))
-			errStr := err.Error()
-			c.LastSyncError = &errStr
-			statusStr = "failed"
-			c.LastSyncStatus = &statusStr
-			_ = s.UpdateCloudConnection(connID, c)
-			return err
+			return recordSyncFailure(s, connID, err)
 		}
 		// Push app allocations (in synced blocks with no external_id yet) to the cloud when read-write
 		if c.SyncMode == "read_write" {
 			if err := integrations.PushAllocationsToCloud(ctx, s, c); err != nil {
 				logger.Error("sync full failed: push allocations to cloud", slog.String("connection_id", connID.String()), slog.String("connection_name", c.Name), logger.ErrAttr(err))
-				errStr := err.Error()
-				c.LastSyncError = &errStr
-				statusStr = "failed"
-				c.LastSyncStatus = &statusStr
-				_ = s.UpdateCloudConnection(connID, c)
-				return err
+				return recordSyncFailure(s, connID, err)
 			}
 		}
 	}
@@ -358,31 +366,49 @@ func RunSyncForConnection(ctx context.Context, s store.Storer, connID uuid.UUID)
 		if syncAllocations {
 			if err := integrations.ApplyAllocationDeletesInCloud(ctx, s, c); err != nil {
 				logger.Error("sync full failed: apply allocation deletes in cloud", slog.String("connection_id", connID.String()), slog.String("connection_name", c.Name), logger.ErrAttr(err))
-				errStr := err.Error()
-				c.LastSyncError = &errStr
-				statusStr = "failed"
-				c.LastSyncStatus = &statusStr
-				_ = s.UpdateCloudConnection(connID, c)
-				return err
+				return recordSyncFailure(s, connID, err)
 			}
 		}
 		if syncBlocks {
 			if err := integrations.ApplyBlockDeletesInCloud(ctx, s, c); err != nil {
 				logger.Error("sync full failed: apply block deletes in cloud", slog.String("connection_id", connID.String()), slog.String("connection_name", c.Name), logger.ErrAttr(err))
-				errStr := err.Error()
-				c.LastSyncError = &errStr
-				statusStr = "failed"
-				c.LastSyncStatus = &statusStr
-				_ = s.UpdateCloudConnection(connID, c)
-				return err
+				return recordSyncFailure(s, connID, err)
 			}
 		}
 	}
 	logger.Info("sync full completed", slog.String("connection_id", connID.String()), slog.String("connection_name", c.Name))
-	statusStr = "success"
-	c.LastSyncStatus = &statusStr
-	c.LastSyncError = nil
-	return s.UpdateCloudConnection(connID, c)
+	return recordSyncSuccess(s, connID)
+}
+
+// recordSyncFailure marks the last sync as failed and counts it toward background sync backoff.
+// Only the sync state is written, so settings changed while the sync ran (e.g. pausing it) are kept.
+func recordSyncFailure(s store.Storer, connID uuid.UUID, syncErr error) error {
+	c, err := s.GetCloudConnection(connID)
+	if err != nil {
+		return syncErr
+	}
+	statusStr, errStr := "failed", syncErr.Error()
+	st := c.SyncState()
+	st.LastSyncStatus = &statusStr
+	st.LastSyncError = &errStr
+	st.ConsecutiveSyncFailures++
+	_ = s.UpdateCloudConnectionSyncState(connID, st)
+	return syncErr
+}
+
+// recordSyncSuccess marks the last sync as successful and clears any backoff.
+func recordSyncSuccess(s store.Storer, connID uuid.UUID) error {
+	c, err := s.GetCloudConnection(connID)
+	if err != nil {
+		return err
+	}
+	statusStr := "success"
+	st := c.SyncState()
+	st.LastSyncStatus = &statusStr
+	st.LastSyncError = nil
+	st.ConsecutiveSyncFailures = 0
+	st.SyncBackoffUntil = nil
+	return s.UpdateCloudConnectionSyncState(connID, st)
 }
 
 func NewSyncIntegrationUseCase(s store.Storer) usecase.Interactor {
@@ -399,7 +425,11 @@ func NewSyncIntegrationUseCase(s store.Storer) usecase.Interactor {
 		if userOrg != uuid.Nil && c.OrganizationID != userOrg {
 			return status.Wrap(errors.New("integration not found"), status.NotFound)
 		}
-		if err := RunSyncForConnection(ctx, s, input.ID); err != nil {
+		acquired, err := s.WithSyncLock(ctx, input.ID, func() error { return RunSyncForConnection(ctx, s, input.ID) })
+		if !acquired && err == nil {
+			return status.Wrap(errors.New("sync already in progress for this integration"), status.Aborted)
+		}
+		if err != nil {
 			return status.Wrap(err, status.Internal)
 		}
 		updated, _ := s.GetCloudConnection(input.ID)
@@ -407,46 +437,7 @@ func NewSyncIntegrationUseCase(s store.Storer) usecase.Interactor {
 		return nil
 	})
 	u.SetTitle("Sync Integration")
-	u.SetDescription("Trigger sync for a cloud connection (pools, blocks, and allocations e.g. VPC subnets)")
-	u.SetExpectedErrors(status.Unauthenticated, status.NotFound, status.Internal)
+	u.SetDescription("Trigger sync for a cloud connection (pools, blocks, and allocations e.g. VPC subnets). Runs even when background sync is paused or backing off.")
+	u.SetExpectedErrors(status.Unauthenticated, status.NotFound, status.Aborted, status.Internal)
 	return u
 }
-
-// StartBackgroundSync starts a goroutine that syncs cloud connections on their configured interval (default 5 min).
-func StartBackgroundSync(s store.Storer) {
-	const tickInterval = time.Minute
-	go func() {
-		ticker := time.NewTicker(tickInterval)
-		defer ticker.Stop()
-		for range ticker.C {
-			list, err := s.ListCloudConnections()
-			if err != nil {
-				logger.Error("sync background: list connections", logger.ErrAttr(err))
-				continue
-			}
-			now := time.Now()
-			for _, c := range list {
-				if c.SyncIntervalMinutes <= 0 {
-					continue
-				}
-				interval := time.Duration(c.SyncIntervalMinutes) * time.Minute
-				due := c.LastSyncAt == nil || now.Sub(*c.LastSyncAt) >= interval
-				if !due {
-					continue
-				}
-				connID := c.ID
-				go func() {
-					ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-					defer cancel()
-					acquired, err := s.WithSyncLock(ctx, connID, func() error { return RunSyncForConnection(ctx, s, connID) })
-					if !acquired {
-						return // another instance is syncing this connection
-					}
-					if err != nil {
-						logger.Error("sync background failed", slog.String("connection_id", connID.String()), logger.ErrAttr(err))
-					}
-				}()
-			}
-		}
-	}()
-}
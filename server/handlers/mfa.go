@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JakeNeyer/ipam/internal/logger"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/skip2/go-qrcode"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+const (
+	// mfaChallengeDuration is how long a user has to enter their code after the password step.
+	mfaChallengeDuration = 5 * time.Minute
+	// mfaChallengeAttempts is how many codes may be tried per password step before the password is needed again.
+	mfaChallengeAttempts = 5
+	totpIssuer           = "IPAM"
+)
+
+// loginMFAInput is the request body for POST /api/auth/login/mfa.
+type loginMFAInput struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"` // TOTP code or a recovery code
+}
+
+// NewLoginMFAUseCase returns a use case for POST /api/auth/login/mfa, the second step of a login for users with MFA.
+// Failed codes count against the same per-IP limiter as passwords, and after mfaChallengeAttempts codes the user must
+// enter their password again.
+func NewLoginMFAUseCase(s store.Storer, limiter *auth.LoginAttemptLimiter, cfg *config.Config) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input loginMFAInput, output *loginOutput) error {
+		ip := limiter.ClientIP(auth.RequestFromContext(ctx))
+		if limiter != nil && limiter.IsBlocked(ip) {
+			logger.Info("login blocked: too many attempts", logger.KeyOperation, "login_mfa", "ip", ip)
+			return status.Wrap(errors.New("too many failed login attempts; try again later"), status.ResourceExhausted)
+		}
+		challenge, err := s.AttemptMFAChallenge(strings.TrimSpace(input.MFAToken), mfaChallengeAttempts)
+		if err != nil {
+			return status.Wrap(errors.New("sign-in expired; enter your password again"), status.Unauthenticated)
+		}
+		user, err := s.GetUser(challenge.UserID)
+		if err != nil || !user.TOTPEnabled {
+			s.DeleteMFAChallenge(input.MFAToken)
+			return status.Wrap(errors.New("sign-in expired; enter your password again"), status.Unauthenticated)
+		}
+		if user.Disabled {
+			s.DeleteMFAChallenge(input.MFAToken)
+			return status.Wrap(errors.New("account is disabled"), status.PermissionDenied)
+		}
+		if err := verifySecondFactor(s, user, input.Code, true); err != nil {
+			if limiter != nil {
+				limiter.RecordFailure(ip)
+			}
+			if challenge.Attempts >= mfaChallengeAttempts {
+				s.DeleteMFAChallenge(input.MFAToken)
+				return status.Wrap(errors.New("too many invalid codes; enter your password again"), status.Unauthenticated)
+			}
+			return err
+		}
+		s.DeleteMFAChallenge(input.MFAToken)
+		if limiter != nil {
+			limiter.RecordSuccess(ip)
+		}
+		startSession(ctx, s, cfg, output, user)
+		return nil
+	})
+	u.SetTitle("Login: second factor")
+	u.SetDescription("Completes a login that returned mfa_required with a TOTP code or a recovery code; sets session cookie")
+	u.SetExpectedErrors(status.Unauthenticated, status.ResourceExhausted, status.PermissionDenied, status.Internal)
+	return u
+}
+
+// verifySecondFactor checks code as a TOTP code for user, or as one of their recovery codes when allowRecovery is
+// set. Accepted TOTP codes cannot be used again and recovery codes are consumed.
+func verifySecondFactor(s store.Storer, user *store.User, code string, allowRecovery bool) error {
+	code = strings.TrimSpace(code)
+	if step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		if err := s.UseTOTPStep(user.ID, step); err != nil {
+			return status.Wrap(errors.New("code already used; wait for the next one"), status.Unauthenticated)
+		}
+		return nil
+	}
+	if allowRecovery && len(code) > auth.TOTPDigits {
+		if err := s.UseRecoveryCode(user.ID, auth.HashRecoveryCode(code)); err == nil {
+			logger.Info("recovery code used", logger.KeyUserID, user.ID.String(), logger.KeyEmail, user.Email)
+			return nil
+		}
+	}
+	return status.Wrap(errors.New("invalid code"), status.Unauthenticated)
+}
+
+// mfaStatusOutput is the response for GET /api/auth/me/mfa.
+type mfaStatusOutput struct {
+	Enabled                bool `json:"enabled"`
+	Required               bool `json:"required"` // by the user's organization
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// mfaEnrollOutput is the response for POST /api/auth/me/mfa/enroll.
+type mfaEnrollOutput struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`     // otpauth:// provisioning URI
+	QRCode string `json:"qr_code"` // URI as a QR code, a data:image/png;base64 URL
+}
+
+// mfaCodeInput is the request body for MFA changes that need a current code.
+type mfaCodeInput struct {
+	Code string `json:"code"`
+}
+
+// mfaRecoveryCodesOutput returns freshly generated recovery codes; they are not shown again.
+type mfaRecoveryCodesOutput struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// orgRequiresMFA reports whether user's organization requires MFA.
+func orgRequiresMFA(s store.Storer, user *store.User) bool {
+	if user.OrganizationID == uuid.Nil {
+		return false
+	}
+	org, err := s.GetOrganization(user.OrganizationID)
+	return err == nil && org.RequireMFA
+}
+
+// mfaUser returns the signed-in user; MFA is managed with a session, not with API tokens.
+func mfaUser(ctx context.Context) (*store.User, error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
+	}
+	if auth.SessionIDFromContext(ctx) == "" {
+		return nil, status.Wrap(errors.New("sign in to manage MFA"), status.PermissionDenied)
+	}
+	return user, nil
+}
+
+// NewGetMFAStatusUseCase returns a use case for GET /api/auth/me/mfa.
+func NewGetMFAStatusUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input struct{}, output *mfaStatusOutput) error {
+		user := auth.UserFromContext(ctx)
+		if user == nil {
+			return status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
+		}
+		output.Enabled = user.TOTPEnabled
+		output.Required = orgRequiresMFA(s, user)
+		if user.TOTPEnabled {
+			n, err := s.CountRecoveryCodes(user.ID)
+			if err != nil {
+				return status.Wrap(err, status.Internal)
+			}
+			output.RecoveryCodesRemaining = n
+		}
+		return nil
+	})
+	u.SetTitle("Get MFA status")
+	u.SetDescription("Whether the current user has TOTP enabled, whether their organization requires it, and how many recovery codes are left")
+	u.SetExpectedErrors(status.Unauthenticated, status.Internal)
+	return u
+}
+
+// NewEnrollMFAUseCase returns a use case for POST /api/auth/me/mfa/enroll.
+func NewEnrollMFAUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input struct{}, output *mfaEnrollOutput) error {
+		user, err := mfaUser(ctx)
+		if err != nil {
+			return err
+		}
+		if user.TOTPEnabled {
+			return status.Wrap(errors.New("MFA is already enabled; disable it before enrolling a new device"), status.FailedPrecondition)
+		}
+		secret, err := auth.NewTOTPSecret()
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		if err := s.SetUserTOTP(user.ID, secret, false); err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		output.Secret = secret
+		output.URI = auth.TOTPURI(totpIssuer, user.Email, secret)
+		png, err := qrcode.Encode(output.URI, qrcode.Medium, 256)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		output.QRCode = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+		return nil
+	})
+	u.SetTitle("Start MFA enrollment")
+	u.SetDescription("Generates a TOTP secret for the current user. MFA is enabled once a code from it is sent to POST /api/auth/me/mfa/verify")
+	u.SetExpectedErrors(status.Unauthenticated, status.PermissionDenied, status.FailedPrecondition, status.Internal)
+	return u
+}
+
+// NewVerifyMFAUseCase returns a use case for POST /api/auth/me/mfa/verify.
+func NewVerifyMFAUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input mfaCodeInput, output *mfaRecoveryCodesOutput) error {
+		user, err := mfaUser(ctx)
+		if err != nil {
+			return err
+		}
+		if user.TOTPEnabled {
+			return status.Wrap(errors.New("MFA is already enabled"), status.FailedPrecondition)
+		}
+		if user.TOTPSecret == "" {
+			return status.Wrap(errors.New("start enrollment first"), status.FailedPrecondition)
+		}
+		if err := verifySecondFactor(s, user, input.Code, false); err != nil {
+			return status.Wrap(errors.New("invalid code; check the time on your device"), status.InvalidArgument)
+		}
+		codes, err := newRecoveryCodes(s, user.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.SetUserTOTP(user.ID, user.TOTPSecret, true); err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		logger.Info("mfa enabled", logger.KeyUserID, user.ID.String(), logger.KeyEmail, user.Email)
+		output.RecoveryCodes = codes
+		return nil
+	})
+	u.SetTitle("Confirm MFA enrollment")
+	u.SetDescription("Enables TOTP with a code from the enrolled secret and returns single-use recovery codes (shown only once)")
+	u.SetExpectedErrors(status.Unauthenticated, status.PermissionDenied, status.InvalidArgument, status.FailedPrecondition, status.Internal)
+	return u
+}
+
+// NewRegenerateRecoveryCodesUseCase returns a use case for POST /api/auth/me/mfa/recovery-codes.
+func NewRegenerateRecoveryCodesUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input mfaCodeInput, output *mfaRecoveryCodesOutput) error {
+		user, err := mfaUser(ctx)
+		if err != nil {
+			return err
+		}
+		if !user.TOTPEnabled {
+			return status.Wrap(errors.New("MFA is not enabled"), status.FailedPrecondition)
+		}
+		if err := verifySecondFactor(s, user, input.Code, false); err != nil {
+			return err
+		}
+		codes, err := newRecoveryCodes(s, user.ID)
+		if err != nil {
+			return err
+		}
+		output.RecoveryCodes = codes
+		return nil
+	})
+	u.SetTitle("Regenerate MFA recovery codes")
+	u.SetDescription("Replaces the current user's recovery codes; requires a current TOTP code")
+	u.SetExpectedErrors(status.Unauthenticated, status.PermissionDenied, status.FailedPrecondition, status.Internal)
+	return u
+}
+
+// NewDisableMFAUseCase returns a use case for POST /api/auth/me/mfa/disable.
+func NewDisableMFAUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input mfaCodeInput, output *struct{}) error {
+		user, err := mfaUser(ctx)
+		if err != nil {
+			return err
+		}
+		if !user.TOTPEnabled {
+			return status.Wrap(errors.New("MFA is not enabled"), status.FailedPrecondition)
+		}
+		if user.PasswordHash != "" && orgRequiresMFA(s, user) {
+			return status.Wrap(errors.New("your organization requires MFA"), status.FailedPrecondition)
+		}
+		if err := verifySecondFactor(s, user, input.Code, true); err != nil {
+			return err
+		}
+		if err := s.SetUserTOTP(user.ID, "", false); err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		logger.Info("mfa disabled", logger.KeyUserID, user.ID.String(), logger.KeyEmail, user.Email)
+		return nil
+	})
+	u.SetTitle("Disable MFA")
+	u.SetDescription("Turns TOTP off for the current user; requires a current TOTP or recovery code")
+	u.SetExpectedErrors(status.Unauthenticated, status.PermissionDenied, status.FailedPrecondition, status.Internal)
+	return u
+}
+
+// newRecoveryCodes generates recovery codes for userID and stores their hashes.
+func newRecoveryCodes(s store.Storer, userID uuid.UUID) ([]string, error) {
+	codes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		return nil, status.Wrap(err, status.Internal)
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = auth.HashRecoveryCode(c)
+	}
+	if err := s.SetRecoveryCodes(userID, hashes); err != nil {
+		return nil, status.Wrap(err, status.Internal)
+	}
+	return codes, nil
+}
+
+// ResetUserMFAHandler handles DELETE /api/admin/users/:id/mfa: it turns MFA off for a user who lost their device, so
+// they can sign in with their password and enroll again. Admins may reset users in their organization; global admins
+// anyone.
+func ResetUserMFAHandler(s store.Storer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		requester := auth.UserFromContext(r.Context())
+		if requester == nil || requester.Role != store.RoleAdmin {
+			auth.WriteJSONError(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		idStr := strings.Trim(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/admin/users/"), "/mfa"), "/")
+		userID, err := uuid.Parse(idStr)
+		if err != nil {
+			auth.WriteJSONError(w, "invalid user id", http.StatusBadRequest)
+			return
+		}
+		target, err := s.GetUser(userID)
+		if err != nil || (!auth.IsGlobalAdminRequest(r.Context(), requester) && target.OrganizationID != auth.UserOrgForAccess(r.Context(), requester)) {
+			auth.WriteJSONError(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err := s.SetUserTOTP(target.ID, "", false); err != nil {
+			auth.WriteJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("mfa reset by admin", logger.KeyUserID, target.ID.String(), logger.KeyEmail, target.Email, "admin", requester.Email)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
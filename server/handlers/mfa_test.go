@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/swaggest/usecase/status"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordUser creates a local account with password "user-password".
+func passwordUser(t *testing.T, s store.Storer, email, role string, orgID [16]byte) *store.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("user-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &store.User{Email: email, PasswordHash: string(hash), Role: role, OrganizationID: orgID}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// sessionCtx is the context the auth middleware builds for a request signed in with a session.
+func sessionCtx(s store.Storer, userID [16]byte) context.Context {
+	u, _ := s.GetUser(userID)
+	ctx := auth.WithUser(context.Background(), u)
+	return auth.WithSessionID(ctx, "test-session")
+}
+
+// enrollMFA enables TOTP for user through the enrollment use cases and returns the secret and recovery codes.
+func enrollMFA(t *testing.T, s store.Storer, user *store.User) (string, []string) {
+	t.Helper()
+	var enrolled mfaEnrollOutput
+	if err := NewEnrollMFAUseCase(s).Interact(sessionCtx(s, user.ID), struct{}{}, &enrolled); err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	if !strings.HasPrefix(enrolled.QRCode, "data:image/png;base64,") {
+		t.Errorf("qr_code = %.40q", enrolled.QRCode)
+	}
+	code, err := auth.TOTPCode(enrolled.Secret, time.Now().Add(-auth.TOTPPeriod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var verified mfaRecoveryCodesOutput
+	if err := NewVerifyMFAUseCase(s).Interact(sessionCtx(s, user.ID), mfaCodeInput{Code: code}, &verified); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if len(verified.RecoveryCodes) != auth.RecoveryCodeCount {
+		t.Fatalf("recovery codes = %d", len(verified.RecoveryCodes))
+	}
+	return enrolled.Secret, verified.RecoveryCodes
+}
+
+func loginMFA(t *testing.T, s store.Storer, token, code string) (*http.Cookie, error) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	out := &loginOutput{}
+	out.SetResponseWriter(rec)
+	ctx := auth.WithRequest(context.Background(), httptest.NewRequest(http.MethodPost, "/api/auth/login/mfa", nil))
+	err := NewLoginMFAUseCase(s, nil, nil).Interact(ctx, loginMFAInput{MFAToken: token, Code: code}, out)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == auth.SessionCookieName && c.Value != "" {
+			return c, err
+		}
+	}
+	return nil, err
+}
+
+func TestLogin_MFA(t *testing.T) {
+	s := store.NewStore()
+	alice := passwordUser(t, s, "alice@example.org", store.RoleAdmin, [16]byte{})
+	secret, recovery := enrollMFA(t, s, alice)
+	cfg := &config.Config{}
+
+	out, cookie, err := passwordLogin(t, s, cfg, nil, "alice@example.org", "user-password")
+	if err != nil || cookie != nil || !out.MFARequired || out.MFAToken == "" || out.User != nil {
+		t.Fatalf("password step: err = %v, cookie = %v, out = %+v; want mfa_required without a session", err, cookie, out)
+	}
+	if _, err := loginMFA(t, s, out.MFAToken, "000000"); !errors.Is(err, status.Unauthenticated) {
+		t.Errorf("wrong code: err = %v", err)
+	}
+	// The code used for enrollment cannot be replayed.
+	used, _ := auth.TOTPCode(secret, time.Now().Add(-auth.TOTPPeriod))
+	if _, err := loginMFA(t, s, out.MFAToken, used); !errors.Is(err, status.Unauthenticated) {
+		t.Errorf("replayed code: err = %v", err)
+	}
+	code, _ := auth.TOTPCode(secret, time.Now())
+	if cookie, err := loginMFA(t, s, out.MFAToken, code); err != nil || cookie == nil {
+		t.Fatalf("second factor: err = %v, cookie = %v", err, cookie)
+	}
+	if _, err := loginMFA(t, s, out.MFAToken, code); !errors.Is(err, status.Unauthenticated) {
+		t.Errorf("reused mfa_token: err = %v", err)
+	}
+
+	// A recovery code works once.
+	out, _, _ = passwordLogin(t, s, cfg, nil, "alice@example.org", "user-password")
+	if cookie, err := loginMFA(t, s, out.MFAToken, recovery[0]); err != nil || cookie == nil {
+		t.Fatalf("recovery code: err = %v, cookie = %v", err, cookie)
+	}
+	out, _, _ = passwordLogin(t, s, cfg, nil, "alice@example.org", "user-password")
+	if _, err := loginMFA(t, s, out.MFAToken, recovery[0]); !errors.Is(err, status.Unauthenticated) {
+		t.Errorf("reused recovery code: err = %v", err)
+	}
+	if n, _ := s.CountRecoveryCodes(alice.ID); n != auth.RecoveryCodeCount-1 {
+		t.Errorf("recovery codes left = %d, want %d", n, auth.RecoveryCodeCount-1)
+	}
+}
+
+func TestLogin_MFATooManyCodes(t *testing.T) {
+	s := store.NewStore()
+	alice := passwordUser(t, s, "alice@example.org", store.RoleAdmin, [16]byte{})
+	secret, _ := enrollMFA(t, s, alice)
+	cfg := &config.Config{}
+
+	out, _, _ := passwordLogin(t, s, cfg, nil, "alice@example.org", "user-password")
+	for i := 0; i < mfaChallengeAttempts; i++ {
+		if _, err := loginMFA(t, s, out.MFAToken, "000000"); !errors.Is(err, status.Unauthenticated) {
+			t.Fatalf("wrong code %d: err = %v", i, err)
+		}
+	}
+	// The challenge is gone, so even a correct code needs the password step again.
+	code, _ := auth.TOTPCode(secret, time.Now())
+	if cookie, err := loginMFA(t, s, out.MFAToken, code); !errors.Is(err, status.Unauthenticated) || cookie != nil {
+		t.Fatalf("code after too many failures: err = %v, cookie = %v", err, cookie)
+	}
+	out, _, _ = passwordLogin(t, s, cfg, nil, "alice@example.org", "user-password")
+	if cookie, err := loginMFA(t, s, out.MFAToken, code); err != nil || cookie == nil {
+		t.Errorf("new challenge: err = %v, cookie = %v", err, cookie)
+	}
+}
+
+func TestMFA_OrganizationPolicy(t *testing.T) {
+	s := store.NewStore()
+	acme := &store.Organization{Name: "Acme", RequireMFA: true}
+	if err := s.CreateOrganization(acme); err != nil {
+		t.Fatal(err)
+	}
+	bob := passwordUser(t, s, "bob@example.org", store.RoleUser, acme.ID)
+
+	out, cookie, err := passwordLogin(t, s, &config.Config{}, nil, "bob@example.org", "user-password")
+	if err != nil || cookie == nil || !out.MFAEnrollmentRequired {
+		t.Fatalf("login: err = %v, cookie = %v, out = %+v; want a session that must enroll", err, cookie, out)
+	}
+	_, userToken, err := s.CreateAPIToken(bob.ID, "cli", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa := &store.ServiceAccount{OrganizationID: acme.ID, Name: "ci", Role: store.RoleUser}
+	if err := s.CreateServiceAccount(sa); err != nil {
+		t.Fatal(err)
+	}
+	_, saToken, err := s.CreateServiceAccountToken(sa.ID, "ci", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected := auth.Middleware(s, config.SessionConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	get := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		protected.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	getWithToken := func(path, token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		protected.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := get("/api/blocks"); code != http.StatusForbidden {
+		t.Errorf("API before enrollment: status = %d, want 403", code)
+	}
+	if code := get("/api/auth/me/mfa"); code != http.StatusOK {
+		t.Errorf("MFA endpoints before enrollment: status = %d, want 200", code)
+	}
+	for _, path := range []string{"/api/blocks", "/api/auth/me/mfa"} {
+		if code := getWithToken(path, userToken); code != http.StatusForbidden {
+			t.Errorf("user API token on %s before enrollment: status = %d, want 403", path, code)
+		}
+	}
+	if code := getWithToken("/api/blocks", saToken); code != http.StatusOK {
+		t.Errorf("service account token under policy: status = %d, want 200", code)
+	}
+
+	secret, _ := enrollMFA(t, s, bob)
+	if code := get("/api/blocks"); code != http.StatusOK {
+		t.Errorf("API after enrollment: status = %d, want 200", code)
+	}
+	if code := getWithToken("/api/blocks", userToken); code != http.StatusOK {
+		t.Errorf("user API token after enrollment: status = %d, want 200", code)
+	}
+	code, _ := auth.TOTPCode(secret, time.Now().Add(auth.TOTPPeriod))
+	if err := NewDisableMFAUseCase(s).Interact(sessionCtx(s, bob.ID), mfaCodeInput{Code: code}, &struct{}{}); !errors.Is(err, status.FailedPrecondition) {
+		t.Errorf("disable under policy: err = %v", err)
+	}
+}
+
+func TestResetUserMFA(t *testing.T) {
+	s := store.NewStore()
+	acme := &store.Organization{Name: "Acme"}
+	other := &store.Organization{Name: "Other"}
+	for _, o := range []*store.Organization{acme, other} {
+		if err := s.CreateOrganization(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	carol := passwordUser(t, s, "carol@example.org", store.RoleUser, acme.ID)
+	enrollMFA(t, s, carol)
+	acmeAdmin := passwordUser(t, s, "admin@acme.example", store.RoleAdmin, acme.ID)
+	otherAdmin := passwordUser(t, s, "admin@other.example", store.RoleAdmin, other.ID)
+
+	reset := func(admin *store.User) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/admin/users/"+carol.ID.String()+"/mfa", nil)
+		req = req.WithContext(auth.WithUser(req.Context(), admin))
+		rec := httptest.NewRecorder()
+		ResetUserMFAHandler(s).ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := reset(otherAdmin); code != http.StatusNotFound {
+		t.Errorf("admin of another organization: status = %d, want 404", code)
+	}
+	if code := reset(acmeAdmin); code != http.StatusNoContent {
+		t.Fatalf("admin of the organization: status = %d, want 204", code)
+	}
+	if u, _ := s.GetUser(carol.ID); u.TOTPEnabled || u.TOTPSecret != "" {
+		t.Errorf("MFA still set after reset: %+v", u)
+	}
+	if n, _ := s.CountRecoveryCodes(carol.ID); n != 0 {
+		t.Errorf("recovery codes after reset = %d", n)
+	}
+	if _, cookie, err := passwordLogin(t, s, &config.Config{}, nil, "carol@example.org", "user-password"); err != nil || cookie == nil {
+		t.Errorf("password login after reset: err = %v, cookie = %v", err, cookie)
+	}
+}
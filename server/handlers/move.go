@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+// moveBlockDestination applies the requested environment, pool or organization to block, checking the caller can
+// see the destination.
+func moveBlockDestination(ctx context.Context, s store.Storer, block *network.Block, input moveBlockInput) error {
+	userOrg := auth.UserOrgForAccess(ctx, auth.UserFromContext(ctx))
+	block.PoolID = nil
+	if input.EnvironmentID != nil && *input.EnvironmentID != uuid.Nil {
+		env, err := s.GetEnvironment(*input.EnvironmentID)
+		if err != nil || (userOrg != uuid.Nil && env.OrganizationID != userOrg) {
+			return status.Wrap(errors.New("environment not found"), status.NotFound)
+		}
+		if input.OrganizationID != nil && *input.OrganizationID != env.OrganizationID {
+			return status.Wrap(errors.New("organization_id does not match the destination environment's organization"), status.InvalidArgument)
+		}
+		block.EnvironmentID = env.Id
+		block.OrganizationID = env.OrganizationID
+		if input.PoolID != nil && *input.PoolID != uuid.Nil {
+			pool, err := s.GetPool(*input.PoolID)
+			if err != nil {
+				return status.Wrap(errors.New("pool not found"), status.NotFound)
+			}
+			if pool.EnvironmentID != env.Id {
+				return status.Wrap(errors.New("pool does not belong to the destination environment"), status.InvalidArgument)
+			}
+			block.PoolID = &pool.ID
+		}
+		return nil
+	}
+	if input.PoolID != nil && *input.PoolID != uuid.Nil {
+		return status.Wrap(errors.New("pool_id can only be set for blocks in an environment"), status.InvalidArgument)
+	}
+	if input.OrganizationID == nil || *input.OrganizationID == uuid.Nil {
+		return status.Wrap(errors.New("environment_id or organization_id is required"), status.InvalidArgument)
+	}
+	if _, err := s.GetOrganization(*input.OrganizationID); err != nil || (userOrg != uuid.Nil && *input.OrganizationID != userOrg) {
+		return status.Wrap(errors.New("organization not found"), status.NotFound)
+	}
+	block.EnvironmentID = uuid.Nil
+	block.OrganizationID = *input.OrganizationID
+	return nil
+}
+
+// NewMoveBlockUseCase moves a block to another environment, pool or organization. Its allocations reference it by
+// name, so they move with it.
+func NewMoveBlockUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input moveBlockInput, output *moveBlockOutput) error {
+		block, err := loadBlockForChange(ctx, s, input.ID)
+		if err != nil {
+			return err
+		}
+		srcOrgID := blockOrgID(s, block)
+		allocs, err := blockAllocations(s, block, srcOrgID)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		if err := moveBlockDestination(ctx, s, block, input); err != nil {
+			return err
+		}
+		dstOrgID := blockOrgID(s, block)
+		if dstOrgID != srcOrgID {
+			if err := checkBlockNameFree(s, dstOrgID, block.Name, map[uuid.UUID]bool{block.ID: true}); err != nil {
+				return err
+			}
+		}
+		if err := validateBlockPlacement(s, block, dstOrgID, block.CIDR, map[uuid.UUID]bool{block.ID: true}); err != nil {
+			return err
+		}
+
+		allocCIDRs := make([]string, 0, len(allocs))
+		output.Allocations = make([]blockChangeAllocationOutput, 0, len(allocs))
+		for _, a := range allocs {
+			allocCIDRs = append(allocCIDRs, a.Block.CIDR)
+			output.Allocations = append(output.Allocations, blockChangeAllocationOutput{ID: a.Id, Name: a.Name, CIDR: a.Block.CIDR, FromBlock: block.Name, ToBlock: block.Name})
+		}
+		if !input.DryRun {
+			if err := s.UpdateBlock(block.ID, block); err != nil {
+				return status.Wrap(err, status.Internal)
+			}
+		}
+		output.DryRun = input.DryRun
+		output.Block = plannedBlockOutput(block, allocCIDRs)
+		return nil
+	})
+
+	u.SetTitle("Move Block")
+	u.SetDescription("Moves a block, with its allocations, to another environment (optionally into one of its pools) or makes it an orphan block of an organization. " +
+		"The block is re-validated in the destination: pool containment, overlap with the destination's blocks, reserved blocks and (across organizations) block name. " +
+		"Set dry_run to validate without moving. Blocks managed by a cloud integration are refused.")
+	u.SetExpectedErrors(status.InvalidArgument, status.NotFound, status.FailedPrecondition, status.Internal)
+	return u
+}
+
+// NewMoveAllocationUseCase moves an allocation to another block whose CIDR contains it.
+func NewMoveAllocationUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input moveAllocationInput, output *moveAllocationOutput) error {
+		alloc, err := s.GetAllocation(input.ID)
+		if err != nil {
+			return status.Wrap(errors.New("allocation not found"), status.NotFound)
+		}
+		user := auth.UserFromContext(ctx)
+		if user != nil && !allocationInEffectiveOrg(ctx, s, user, alloc) {
+			return status.Wrap(errors.New("allocation not found"), status.NotFound)
+		}
+		if alloc.ConnectionID != nil && alloc.ExternalID != "" {
+			return status.Wrap(
+				fmt.Errorf("allocation %q is managed by the %s integration (%s); subnets cannot move between cloud networks", alloc.Name, alloc.Provider, alloc.ExternalID),
+				status.FailedPrecondition,
+			)
+		}
+		target, err := s.GetBlock(input.BlockID)
+		if err != nil || !blockInUserOrg(ctx, s, user, target) {
+			return status.Wrap(errors.New("block not found"), status.NotFound)
+		}
+		if target.ConnectionID != nil && target.ExternalID != "" {
+			return status.Wrap(
+				fmt.Errorf("block %q is managed by the %s integration (%s); create the allocation there and sync instead", target.Name, target.Provider, target.ExternalID),
+				status.FailedPrecondition,
+			)
+		}
+		if blockNamesMatch(alloc.Block.Name, target.Name) {
+			return status.Wrap(fmt.Errorf("allocation %q is already in block %q", alloc.Name, target.Name), status.InvalidArgument)
+		}
+		contained, err := network.Contains(target.CIDR, alloc.Block.CIDR)
+		if err != nil || !contained {
+			return status.Wrap(
+				fmt.Errorf("allocation CIDR %s is not within block %q CIDR %s", alloc.Block.CIDR, target.Name, target.CIDR),
+				status.InvalidArgument,
+			)
+		}
+		orgID := blockOrgID(s, target)
+		existing, err := blockAllocations(s, target, orgID)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		for _, other := range existing {
+			if overlap, _ := network.Overlaps(alloc.Block.CIDR, other.Block.CIDR); overlap {
+				return status.Wrap(
+					fmt.Errorf("CIDR %s overlaps with existing allocation %q in block %q", alloc.Block.CIDR, other.Name, target.Name),
+					status.InvalidArgument,
+				)
+			}
+		}
+		if reserved, err := s.OverlapsReservedBlock(alloc.Block.CIDR, &orgID); err != nil {
+			return status.Wrap(err, status.Internal)
+		} else if reserved != nil {
+			return status.Wrap(fmt.Errorf("CIDR %s overlaps reserved block %s", alloc.Block.CIDR, reserved.CIDR), status.InvalidArgument)
+		}
+
+		moved := *alloc
+		output.FromBlock = alloc.Block.Name
+		moved.Block.Name = target.Name
+		if !input.DryRun {
+			if err := s.UpdateAllocation(moved.Id, &moved); err != nil {
+				return status.Wrap(err, status.Internal)
+			}
+		}
+		output.DryRun = input.DryRun
+		output.Allocation = allocationOutput{
+			Id:           moved.Id,
+			Name:         moved.Name,
+			BlockName:    moved.Block.Name,
+			CIDR:         moved.Block.CIDR,
+			Provider:     moved.Provider,
+			ExternalID:   moved.ExternalID,
+			ConnectionID: moved.ConnectionID,
+		}
+		return nil
+	})
+
+	u.SetTitle("Move Allocation")
+	u.SetDescription("Moves an allocation to another block whose CIDR contains it, checking overlap with the destination block's allocations and reserved blocks. " +
+		"Set dry_run to validate without moving. Allocations in, or moving into, blocks managed by a cloud integration are refused.")
+	u.SetExpectedErrors(status.InvalidArgument, status.NotFound, status.FailedPrecondition, status.Internal)
+	return u
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+)
+
+func TestMoveBlock(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	dst := &network.Environment{Id: uuid.New(), Name: "staging", OrganizationID: env.OrganizationID}
+	if err := s.CreateEnvironment(dst); err != nil {
+		t.Fatal(err)
+	}
+	pool := &network.Pool{Name: "staging-pool", CIDR: "10.0.0.0/16", EnvironmentID: dst.Id, OrganizationID: dst.OrganizationID}
+	if err := s.CreatePool(pool); err != nil {
+		t.Fatal(err)
+	}
+	block := createTestBlock(t, s, &network.Block{Name: "vpc", CIDR: "10.0.1.0/24", EnvironmentID: env.Id}, map[string]string{"a": "10.0.1.0/26"})
+	uc := NewMoveBlockUseCase(s)
+
+	var out moveBlockOutput
+	if err := uc.Interact(ctx, moveBlockInput{ID: block.ID, EnvironmentID: &dst.Id, PoolID: &pool.ID, DryRun: true}, &out); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if !out.DryRun || out.Block.EnvironmentID != dst.Id || len(out.Allocations) != 1 || out.Block.UsedIPs != "64" {
+		t.Errorf("dry run output = %+v", out)
+	}
+	if b, _ := s.GetBlock(block.ID); b.EnvironmentID != env.Id {
+		t.Error("dry run moved the block")
+	}
+
+	if err := uc.Interact(ctx, moveBlockInput{ID: block.ID, EnvironmentID: &dst.Id, PoolID: &pool.ID}, &moveBlockOutput{}); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	b, _ := s.GetBlock(block.ID)
+	if b.EnvironmentID != dst.Id || b.PoolID == nil || *b.PoolID != pool.ID {
+		t.Errorf("block after move = %+v", b)
+	}
+	if allocs, _, _ := s.ListAllocationsFiltered("", "", dst.Id, nil, "", nil, 0, 0); len(allocs) != 1 {
+		t.Errorf("allocations in destination = %d, want 1", len(allocs))
+	}
+
+	// Moving back is refused once prod has an overlapping block.
+	createTestBlock(t, s, &network.Block{Name: "prod-vpc", CIDR: "10.0.0.0/20", EnvironmentID: env.Id}, nil)
+	wantStatus(t, uc.Interact(ctx, moveBlockInput{ID: block.ID, EnvironmentID: &env.Id}, &moveBlockOutput{}), http.StatusBadRequest, `overlaps with existing block "prod-vpc"`)
+
+	outside := createTestBlock(t, s, &network.Block{Name: "outside", CIDR: "192.168.0.0/24", EnvironmentID: env.Id}, nil)
+	wantStatus(t, uc.Interact(ctx, moveBlockInput{ID: outside.ID, EnvironmentID: &dst.Id, PoolID: &pool.ID}, &moveBlockOutput{}), http.StatusBadRequest, `must be contained within pool "staging-pool"`)
+	wantStatus(t, uc.Interact(ctx, moveBlockInput{ID: outside.ID}, &moveBlockOutput{}), http.StatusBadRequest, "environment_id or organization_id is required")
+
+	// Orphaning within the org.
+	if err := uc.Interact(ctx, moveBlockInput{ID: outside.ID, OrganizationID: &env.OrganizationID}, &moveBlockOutput{}); err != nil {
+		t.Fatalf("orphan: %v", err)
+	}
+	if b, _ := s.GetBlock(outside.ID); b.EnvironmentID != uuid.Nil || b.OrganizationID != env.OrganizationID {
+		t.Errorf("orphaned block = %+v", b)
+	}
+
+	// Org admins cannot move into another organization.
+	other := &store.Organization{ID: uuid.New(), Name: "other"}
+	if err := s.CreateOrganization(other); err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, uc.Interact(ctx, moveBlockInput{ID: outside.ID, OrganizationID: &other.ID}, &moveBlockOutput{}), http.StatusNotFound, "organization not found")
+}
+
+func TestMoveAllocation(t *testing.T) {
+	s, ctx, env := setupBulkTest(t)
+	src := createTestBlock(t, s, &network.Block{Name: "src", CIDR: "10.0.0.0/24", EnvironmentID: env.Id}, map[string]string{"a": "10.0.0.0/26"})
+	wide := createTestBlock(t, s, &network.Block{Name: "wide", CIDR: "10.0.0.0/23", Isolated: true, EnvironmentID: env.Id}, map[string]string{"busy": "10.0.0.32/27"})
+	far := createTestBlock(t, s, &network.Block{Name: "far", CIDR: "172.16.0.0/24", EnvironmentID: env.Id}, nil)
+	allocs, _ := blockAllocations(s, src, env.OrganizationID)
+	alloc := allocs[0]
+	uc := NewMoveAllocationUseCase(s)
+
+	wantStatus(t, uc.Interact(ctx, moveAllocationInput{ID: alloc.Id, BlockID: far.ID}, &moveAllocationOutput{}), http.StatusBadRequest, `not within block "far"`)
+	wantStatus(t, uc.Interact(ctx, moveAllocationInput{ID: alloc.Id, BlockID: wide.ID}, &moveAllocationOutput{}), http.StatusBadRequest, `overlaps with existing allocation "busy"`)
+	wantStatus(t, uc.Interact(ctx, moveAllocationInput{ID: alloc.Id, BlockID: src.ID}, &moveAllocationOutput{}), http.StatusBadRequest, "already in block")
+
+	busy, _ := blockAllocations(s, wide, env.OrganizationID)
+	if err := s.DeleteAllocation(busy[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	var out moveAllocationOutput
+	if err := uc.Interact(ctx, moveAllocationInput{ID: alloc.Id, BlockID: wide.ID, DryRun: true}, &out); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if got, _ := s.GetAllocation(alloc.Id); got.Block.Name != "src" {
+		t.Error("dry run moved the allocation")
+	}
+	if err := uc.Interact(ctx, moveAllocationInput{ID: alloc.Id, BlockID: wide.ID}, &out); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if got, _ := s.GetAllocation(alloc.Id); got.Block.Name != "wide" || out.FromBlock != "src" || out.Allocation.BlockName != "wide" {
+		t.Errorf("allocation after move = %+v, output %+v", got, out)
+	}
+
+	connID := uuid.New()
+	linked := createTestBlock(t, s, &network.Block{Name: "linked", CIDR: "10.0.0.0/22", Isolated: true, EnvironmentID: env.Id, Provider: "aws", ExternalID: "vpc-1", ConnectionID: &connID}, nil)
+	wantStatus(t, uc.Interact(ctx, moveAllocationInput{ID: alloc.Id, BlockID: linked.ID}, &moveAllocationOutput{}), http.StatusPreconditionFailed, "managed by the aws integration")
+}
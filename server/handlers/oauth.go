@@ -3,10 +3,12 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
-	"time"
 
 	"github.com/JakeNeyer/ipam/internal/logger"
 	"github.com/JakeNeyer/ipam/server/auth"
@@ -21,11 +23,14 @@ import (
 type OAuthProviderOption struct {
 	ID          string `json:"id"`
 	DisplayName string `json:"display_name"`
+	StartPath   string `json:"start_path"` // where the login button sends the browser
 }
 
 type AuthConfigResponse struct {
 	OAuthProviders       []string              `json:"oauth_providers"`
 	OAuthProviderOptions []OAuthProviderOption `json:"oauth_provider_options"`
+	LDAPEnabled          bool                  `json:"ldap_enabled"` // password form stays available next to the providers
+	PasswordResetEnabled bool                  `json:"password_reset_enabled"`
 }
 
 func AuthConfigHandler(cfg *config.Config) http.HandlerFunc {
@@ -41,12 +46,23 @@ func AuthConfigHandler(cfg *config.Config) http.HandlerFunc {
 			if pc := cfg.OAuthProvider(id); pc != nil && pc.DisplayName != "" {
 				label = pc.DisplayName
 			}
-			options = append(options, OAuthProviderOption{ID: id, DisplayName: label})
+			options = append(options, OAuthProviderOption{ID: id, DisplayName: label, StartPath: "/api/auth/oauth/" + id + "/start"})
+		}
+		// SAML providers are listed with the OAuth ones; their ids are prefixed so the two cannot collide.
+		for _, id := range cfg.EnabledSAMLProviders() {
+			label := config.DefaultOAuthDisplayName(id)
+			if pc := cfg.SAMLProvider(id); pc != nil && pc.DisplayName != "" {
+				label = pc.DisplayName
+			}
+			providers = append(providers, "saml:"+id)
+			options = append(options, OAuthProviderOption{ID: "saml:" + id, DisplayName: label, StartPath: "/api/auth/saml/" + id + "/start"})
 		}
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(AuthConfigResponse{
 			OAuthProviders:       providers,
 			OAuthProviderOptions: options,
+			LDAPEnabled:          cfg != nil && cfg.LDAP.Enabled(),
+			PasswordResetEnabled: cfg != nil && cfg.Mail.Enabled() && strings.TrimSpace(cfg.AppOrigin) != "",
 		})
 	}
 }
@@ -69,11 +85,16 @@ func OAuthStartHandler(cfg *config.Config, registry *oauth.ProviderRegistry) htt
 			auth.WriteJSONError(w, "OAuth provider not enabled", http.StatusNotFound)
 			return
 		}
-		endpoint, ok := registry.Endpoint(provider)
-		if !ok {
+		endpoint, err := registry.ResolveEndpoint(r.Context(), provider)
+		if errors.Is(err, oauth.ErrUnknownProvider) {
 			auth.WriteJSONError(w, "OAuth provider not supported", http.StatusNotFound)
 			return
 		}
+		if err != nil {
+			logger.Error("oauth provider discovery failed", logger.ErrAttr(err))
+			auth.WriteJSONError(w, "identity provider unavailable", http.StatusBadGateway)
+			return
+		}
 		inviteToken := strings.TrimSpace(r.URL.Query().Get("invite_token"))
 		secure := requestSecure(r)
 		nonce, err := auth.NewOAuthStateNonce()
@@ -81,9 +102,19 @@ func OAuthStartHandler(cfg *config.Config, registry *oauth.ProviderRegistry) htt
 			auth.WriteJSONError(w, "could not start OAuth", http.StatusInternalServerError)
 			return
 		}
-		if err := auth.SetOAuthStateCookie(w, auth.OAuthStatePayload{
+		state := auth.OAuthStatePayload{
 			Nonce: nonce, Provider: provider, InviteToken: inviteToken,
-		}, secure); err != nil {
+			CodeVerifier: oauth2.GenerateVerifier(),
+		}
+		opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(state.CodeVerifier)}
+		if registry.IsOIDC(provider) {
+			if state.IDTokenNonce, err = auth.NewOAuthStateNonce(); err != nil {
+				auth.WriteJSONError(w, "could not start OAuth", http.StatusInternalServerError)
+				return
+			}
+			opts = append(opts, oauth2.SetAuthURLParam("nonce", state.IDTokenNonce))
+		}
+		if err := auth.SetOAuthStateCookie(w, state, secure); err != nil {
 			auth.WriteJSONError(w, "could not start OAuth", http.StatusInternalServerError)
 			return
 		}
@@ -96,7 +127,7 @@ func OAuthStartHandler(cfg *config.Config, registry *oauth.ProviderRegistry) htt
 			Scopes:       pc.Scopes,
 		}
 		conf.Scopes = defaultOAuthScopes(provider, conf.Scopes)
-		authURL := conf.AuthCodeURL(nonce)
+		authURL := conf.AuthCodeURL(nonce, opts...)
 		// #nosec G710 -- OAuth provider authorize URL is generated from trusted configured endpoint.
 		http.Redirect(w, r, authURL, http.StatusFound)
 	}
@@ -120,8 +151,7 @@ func OAuthCallbackHandler(s store.Storer, cfg *config.Config, registry *oauth.Pr
 			redirectWithError(w, r, "OAuth provider not enabled", cfg.AppOrigin)
 			return
 		}
-		endpoint, ok := registry.Endpoint(provider)
-		if !ok {
+		if _, ok := registry.Endpoint(provider); !ok && !registry.IsOIDC(provider) {
 			redirectWithError(w, r, "OAuth provider not supported", cfg.AppOrigin)
 			return
 		}
@@ -139,6 +169,17 @@ func OAuthCallbackHandler(s store.Storer, cfg *config.Config, registry *oauth.Pr
 		inviteToken := strings.TrimSpace(stored.InviteToken)
 		secure := requestSecure(r)
 		auth.ClearOAuthStateCookie(w, secure)
+		ctx := context.WithValue(
+			r.Context(),
+			oauth2.HTTPClient,
+			registry.HTTPClient(provider),
+		)
+		endpoint, err := registry.ResolveEndpoint(ctx, provider)
+		if err != nil {
+			logger.Error("oauth provider discovery failed", logger.ErrAttr(err))
+			redirectWithError(w, r, "identity provider unavailable", cfg.AppOrigin)
+			return
+		}
 		redirectURI := redirectBase(r) + "/api/auth/oauth/" + provider + "/callback"
 		conf := &oauth2.Config{
 			ClientID:     pc.ClientID,
@@ -148,94 +189,228 @@ func OAuthCallbackHandler(s store.Storer, cfg *config.Config, registry *oauth.Pr
 			Scopes:       pc.Scopes,
 		}
 		conf.Scopes = defaultOAuthScopes(provider, conf.Scopes)
-		ctx := context.WithValue(
-			r.Context(),
-			oauth2.HTTPClient,
-			registry.HTTPClient(provider),
-		)
-		token, err := conf.Exchange(ctx, code)
+		var exchangeOpts []oauth2.AuthCodeOption
+		if stored.CodeVerifier != "" {
+			exchangeOpts = append(exchangeOpts, oauth2.VerifierOption(stored.CodeVerifier))
+		}
+		token, err := conf.Exchange(ctx, code, exchangeOpts...)
 		if err != nil {
 			logger.Error("failed to fetch user exchange code", logger.ErrAttr(err))
 			redirectWithError(w, r, "failed to exchange code", cfg.AppOrigin)
 			return
 		}
-		providerUserID, email, err := registry.UserInfo(ctx, provider, token)
-		if err != nil || providerUserID == "" || email == "" {
+		identity, err := registry.Identify(ctx, provider, token, stored.IDTokenNonce)
+		if err != nil || identity.ProviderUserID == "" || identity.Email == "" {
 			logger.Error("failed to fetch user info", logger.ErrAttr(err))
 			redirectWithError(w, r, "failed to fetch user info", cfg.AppOrigin)
 			return
 		}
-		email = strings.TrimSpace(strings.ToLower(email))
-		if !validation.ValidateEmail(email) {
-			redirectWithError(w, r, "invalid email from provider", cfg.AppOrigin)
+		completeSSOLogin(w, r, s, cfg, ssoLogin{
+			provider:        provider,
+			providerUserID:  identity.ProviderUserID,
+			email:           identity.Email,
+			groups:          identity.Groups,
+			inviteToken:     inviteToken,
+			allowEmailMatch: pc.AllowEmailMatch,
+			groupMappings:   pc.GroupMappings,
+		})
+	}
+}
+
+// ssoLogin is a user an identity provider has vouched for at the end of an OAuth or SAML sign-in.
+type ssoLogin struct {
+	provider        string // stored on the user as its OAuth provider; "saml:<id>" for SAML providers
+	providerUserID  string
+	email           string
+	groups          []string
+	inviteToken     string
+	allowEmailMatch bool
+	groupMappings   []config.GroupMapping
+}
+
+// completeSSOLogin finds, links or provisions the account for l and signs it in with a session cookie, or redirects
+// to the login page with an error.
+func completeSSOLogin(w http.ResponseWriter, r *http.Request, s store.Storer, cfg *config.Config, l ssoLogin) {
+	provider, providerUserID, inviteToken := l.provider, l.providerUserID, strings.TrimSpace(l.inviteToken)
+	secure := requestSecure(r)
+	email := strings.TrimSpace(strings.ToLower(l.email))
+	if !validation.ValidateEmail(email) {
+		redirectWithError(w, r, "invalid email from provider", cfg.AppOrigin)
+		return
+	}
+	access, err := resolveGroupMappings(s, l.groupMappings, l.groups)
+	if errors.Is(err, errNoGroupAccess) {
+		redirectWithError(w, r, "Your identity provider groups do not grant access", cfg.AppOrigin)
+		return
+	}
+	if err != nil {
+		logger.Error("failed to apply OAuth group mappings", logger.ErrAttr(err))
+		redirectWithError(w, r, "could not apply group mappings", cfg.AppOrigin)
+		return
+	}
+
+	appOrigin := cfg.AppOrigin
+	appRedirect := appRedirectBase(appOrigin) + appHashPath("dashboard")
+	login := func(user *store.User) {
+		if user.Disabled {
+			redirectWithError(w, r, "Your account is disabled", appOrigin)
+			return
+		}
+		if err := applyGroupAccess(s, user, access); err != nil {
+			logger.Error("failed to apply OAuth group mappings", logger.ErrAttr(err))
+			redirectWithError(w, r, "could not apply group mappings", cfg.AppOrigin)
 			return
 		}
+		setSessionAndRedirect(w, r, s, cfg, user, secure, appRedirect)
+	}
 
-		appOrigin := cfg.AppOrigin
-		appRedirect := appRedirectBase(appOrigin) + appHashPath("dashboard")
-
-		if inviteToken != "" {
-			inv, err := s.GetSignupInviteByToken(inviteToken)
-			if err != nil {
-				redirectWithError(w, r, "invalid or expired invite link", appOrigin)
-				return
-			}
-			inviter, err := s.GetUser(inv.CreatedBy)
-			if err != nil {
-				redirectWithError(w, r, "invalid invite", appOrigin)
-				return
-			}
-			orgID := inv.OrganizationID
-			if orgID == uuid.Nil {
-				orgID = inviter.OrganizationID
-			}
-			role := inv.Role
-			if role != store.RoleAdmin {
-				role = store.RoleUser
-			}
-			newUser := &store.User{
-				Email:               email,
-				PasswordHash:        "",
-				Role:                role,
-				OrganizationID:      orgID,
-				OAuthProvider:       provider,
-				OAuthProviderUserID: providerUserID,
-			}
-			if err := s.CreateUser(newUser); err != nil {
-				redirectWithError(w, r, "could not create account", appOrigin)
-				return
-			}
-			_ = s.MarkSignupInviteUsed(inv.ID, newUser.ID)
-			setSessionAndRedirect(w, r, s, newUser, secure, appRedirect)
+	if inviteToken != "" {
+		inv, err := s.GetSignupInviteByToken(inviteToken)
+		if err != nil {
+			redirectWithError(w, r, "invalid or expired invite link", appOrigin)
+			return
+		}
+		inviter, err := s.GetUser(inv.CreatedBy)
+		if err != nil {
+			redirectWithError(w, r, "invalid invite", appOrigin)
+			return
+		}
+		orgID := inv.OrganizationID
+		if orgID == uuid.Nil {
+			orgID = inviter.OrganizationID
+		}
+		role := inv.Role
+		if role != store.RoleAdmin {
+			role = store.RoleUser
+		}
+		newUser := &store.User{
+			Email:               email,
+			PasswordHash:        "",
+			Role:                role,
+			OrganizationID:      orgID,
+			OAuthProvider:       provider,
+			OAuthProviderUserID: providerUserID,
+		}
+		if err := s.CreateUser(newUser); err != nil {
+			redirectWithError(w, r, "could not create account", appOrigin)
 			return
 		}
+		_ = s.MarkSignupInviteUsed(inv.ID, newUser.ID)
+		login(newUser)
+		return
+	}
 
-		user, err := s.GetUserByOAuth(provider, providerUserID)
+	user, err := s.GetUserByOAuth(provider, providerUserID)
+	if err == nil {
+		login(user)
+		return
+	}
+	if l.allowEmailMatch {
+		user, err = s.GetUserByEmail(email)
 		if err == nil {
-			setSessionAndRedirect(w, r, s, user, secure, appRedirect)
+			if user.OAuthProvider == "" || user.OAuthProviderUserID == "" {
+				_ = s.SetUserOAuth(user.ID, provider, providerUserID)
+			}
+			login(user)
+			return
+		}
+	}
+	users, listErr := s.ListUsers(nil)
+	if listErr == nil && len(users) == 1 {
+		onlyUser := users[0]
+		if onlyUser.OrganizationID == uuid.Nil && onlyUser.Role == store.RoleAdmin {
+			_ = s.SetUserOAuth(onlyUser.ID, provider, providerUserID)
+			setSessionAndRedirect(w, r, s, cfg, onlyUser, secure, appRedirect)
+			return
+		}
+	}
+	if access != nil {
+		// A mapped group is enough to get an account: provision it in the mapped organization.
+		newUser := &store.User{
+			Email:               email,
+			Role:                access.role,
+			OrganizationID:      access.orgID,
+			OAuthProvider:       provider,
+			OAuthProviderUserID: providerUserID,
+		}
+		if err := s.CreateUser(newUser); err != nil {
+			redirectWithError(w, r, "could not create account", appOrigin)
 			return
 		}
-		if pc.AllowEmailMatch {
-			user, err = s.GetUserByEmail(email)
-			if err == nil {
-				if user.OAuthProvider == "" || user.OAuthProviderUserID == "" {
-					_ = s.SetUserOAuth(user.ID, provider, providerUserID)
+		setSessionAndRedirect(w, r, s, cfg, newUser, secure, appRedirect)
+		return
+	}
+	redirectWithError(w, r, "Use a signup link or ask an admin to create your account", appOrigin)
+}
+
+// errNoGroupAccess means a provider has group mappings and none matched the user's groups.
+var errNoGroupAccess = errors.New("no group mapping matches")
+
+// groupAccess is the organization and role a provider's group mappings grant.
+type groupAccess struct {
+	orgID uuid.UUID
+	role  string
+}
+
+// resolveGroupMappings returns what mappings grant a user in groups, or nil when there are no mappings. The first
+// matching mapping picks the organization; the user is admin if any matching mapping for that organization says so.
+func resolveGroupMappings(s store.Storer, mappings []config.GroupMapping, groups []string) (*groupAccess, error) {
+	if len(mappings) == 0 {
+		return nil, nil
+	}
+	var access *groupAccess
+	var orgs []*store.Organization
+	for _, m := range mappings {
+		if !slices.Contains(groups, m.Group) {
+			continue
+		}
+		orgID, err := uuid.Parse(m.Organization)
+		if err != nil {
+			if orgs == nil {
+				if orgs, err = s.ListOrganizations(); err != nil {
+					return nil, err
 				}
-				setSessionAndRedirect(w, r, s, user, secure, appRedirect)
-				return
 			}
-		}
-		users, listErr := s.ListUsers(nil)
-		if listErr == nil && len(users) == 1 {
-			onlyUser := users[0]
-			if onlyUser.OrganizationID == uuid.Nil && onlyUser.Role == store.RoleAdmin {
-				_ = s.SetUserOAuth(onlyUser.ID, provider, providerUserID)
-				setSessionAndRedirect(w, r, s, onlyUser, secure, appRedirect)
-				return
+			idx := slices.IndexFunc(orgs, func(o *store.Organization) bool { return strings.EqualFold(o.Name, m.Organization) })
+			if idx < 0 {
+				return nil, fmt.Errorf("group %q maps to unknown organization %q", m.Group, m.Organization)
 			}
+			orgID = orgs[idx].ID
+		} else if _, err := s.GetOrganization(orgID); err != nil {
+			return nil, fmt.Errorf("group %q maps to unknown organization %q", m.Group, m.Organization)
+		}
+		switch {
+		case access == nil:
+			access = &groupAccess{orgID: orgID, role: m.Role}
+		case access.orgID == orgID && m.Role == store.RoleAdmin:
+			access.role = store.RoleAdmin
+		}
+	}
+	if access == nil {
+		return nil, errNoGroupAccess
+	}
+	return access, nil
+}
+
+// applyGroupAccess moves user to the organization and role access grants, promoting or demoting as needed. Global
+// admins are left alone so that group mappings cannot lock out the instance administrator.
+func applyGroupAccess(s store.Storer, user *store.User, access *groupAccess) error {
+	if access == nil || (user.OrganizationID == uuid.Nil && user.Role == store.RoleAdmin) {
+		return nil
+	}
+	if user.OrganizationID != access.orgID {
+		if err := s.SetUserOrganization(user.ID, access.orgID); err != nil {
+			return err
+		}
+		user.OrganizationID = access.orgID
+	}
+	if user.Role != access.role {
+		if err := s.SetUserRole(user.ID, access.role); err != nil {
+			return err
 		}
-		redirectWithError(w, r, "Use a signup link or ask an admin to create your account", appOrigin)
+		user.Role = access.role
 	}
+	return nil
 }
 
 func defaultOAuthScopes(_ string, scopes []string) []string {
@@ -282,9 +457,7 @@ func redirectWithError(w http.ResponseWriter, r *http.Request, msg string, appOr
 	http.Redirect(w, r, u, http.StatusFound)
 }
 
-func setSessionAndRedirect(w http.ResponseWriter, r *http.Request, s store.Storer, user *store.User, secure bool, redirectURL string) {
-	sessionID := auth.NewSessionID()
-	s.CreateSession(sessionID, user.ID, time.Now().Add(auth.SessionDuration))
-	auth.SetSessionCookie(w, sessionID, secure)
+func setSessionAndRedirect(w http.ResponseWriter, r *http.Request, s store.Storer, cfg *config.Config, user *store.User, secure bool, redirectURL string) {
+	auth.StartSession(w, r, s, user.ID, sessionConfig(cfg), secure)
 	http.Redirect(w, r, redirectURL, http.StatusFound)
 }
@@ -1,16 +1,25 @@
 package handlers
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/JakeNeyer/ipam/server/auth"
 	"github.com/JakeNeyer/ipam/server/config"
 	"github.com/JakeNeyer/ipam/server/oauth"
 	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
 )
 
 func oauthTestProvider() config.OAuthProviderConfig {
@@ -182,3 +191,194 @@ func TestOAuthCallbackHandler_MissingCode(t *testing.T) {
 		t.Errorf("Location = %s", loc)
 	}
 }
+
+// oidcTestIssuer is a mock OpenID provider whose token endpoint checks the PKCE verifier against the challenge sent to
+// its authorize endpoint and returns an ID token carrying the authorize request's nonce.
+type oidcTestIssuer struct {
+	srv       *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	email     string
+	groups    []string
+}
+
+func newOIDCTestIssuer(t *testing.T) *oidcTestIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &oidcTestIssuer{key: key, email: "sso@example.com"}
+	enc := base64.RawURLEncoding.EncodeToString
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer": m.srv.URL, "authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint": m.srv.URL + "/token", "jwks_uri": m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "n": enc(key.N.Bytes()), "e": enc(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "good" || enc(sum[:]) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+		claims, _ := json.Marshal(map[string]any{
+			"iss": m.srv.URL, "aud": "cid", "sub": "idp-user", "nonce": m.nonce,
+			"exp": time.Now().Add(time.Minute).Unix(), "email": m.email, "email_verified": true,
+			"groups": m.groups,
+		})
+		input := enc(header) + "." + enc(claims)
+		digest := sha256.Sum256([]byte(input))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at", "token_type": "Bearer", "id_token": input + "." + enc(sig),
+		})
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+// login runs the start and callback handlers against the issuer; idTokenNonce picks the nonce the issuer puts in the
+// ID token given the one the start handler sent.
+func (m *oidcTestIssuer) login(t *testing.T, s store.Storer, cfg *config.Config, registry *oauth.ProviderRegistry, idTokenNonce func(sent string) string) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	OAuthStartHandler(cfg, registry)(rr, httptest.NewRequest(http.MethodGet, "/api/auth/oauth/sso/start", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("start status = %d: %s", rr.Code, rr.Body)
+	}
+	authURL, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(authURL.String(), m.srv.URL+"/authorize") {
+		t.Fatalf("authorize URL = %v, %v", authURL, err)
+	}
+	q := authURL.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" || q.Get("nonce") == "" {
+		t.Fatalf("authorize query = %v", q)
+	}
+	m.challenge, m.nonce = q.Get("code_challenge"), idTokenNonce(q.Get("nonce"))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/oauth/sso/callback?code=good&state="+url.QueryEscape(q.Get("state")), nil)
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	cb := httptest.NewRecorder()
+	OAuthCallbackHandler(s, cfg, registry)(cb, req)
+	return cb
+}
+
+func TestOAuthOIDC_LoginWithPKCEAndNonce(t *testing.T) {
+	idp := newOIDCTestIssuer(t)
+	cfg := &config.Config{OAuth: config.OAuthConfig{Providers: map[string]config.OAuthProviderConfig{
+		"sso": {ClientID: "cid", ClientSecret: "secret", IssuerURL: idp.srv.URL, AllowEmailMatch: true},
+	}}}
+	registry, err := oauth.NewProviderRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := store.NewStore()
+	user := &store.User{Email: "sso@example.com", Role: store.RoleUser, OrganizationID: uuid.New()}
+	if err := s.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	cb := idp.login(t, s, cfg, registry, func(sent string) string { return sent })
+	if loc := cb.Header().Get("Location"); loc != "/#dashboard" {
+		t.Fatalf("callback Location = %s", loc)
+	}
+	var session *http.Cookie
+	for _, c := range cb.Result().Cookies() {
+		if c.Name == auth.SessionCookieName && c.Value != "" {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("no session cookie after OIDC login")
+	}
+	if got, err := s.GetUser(user.ID); err != nil || got.OAuthProvider != "sso" || got.OAuthProviderUserID != "idp-user" {
+		t.Errorf("user = %+v, %v", got, err)
+	}
+
+	// An ID token minted for another authorization request (replayed nonce) is rejected.
+	cb = idp.login(t, s, cfg, registry, func(string) string { return "replayed" })
+	if loc := cb.Header().Get("Location"); !strings.Contains(loc, "error=") {
+		t.Errorf("replayed nonce: Location = %s", loc)
+	}
+}
+
+func TestOAuthOIDC_GroupMappings(t *testing.T) {
+	idp := newOIDCTestIssuer(t)
+	s := store.NewStore()
+	acme := &store.Organization{Name: "Acme"}
+	globex := &store.Organization{Name: "Globex"}
+	for _, o := range []*store.Organization{acme, globex} {
+		if err := s.CreateOrganization(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{OAuth: config.OAuthConfig{Providers: map[string]config.OAuthProviderConfig{
+		"sso": {ClientID: "cid", ClientSecret: "secret", IssuerURL: idp.srv.URL, GroupMappings: testGroupMappings(t,
+			"ipam-users=acme:user, ipam-admins=acme:admin, globex-staff="+globex.ID.String()+":user",
+		)},
+	}}}
+	registry, err := oauth.NewProviderRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameNonce := func(sent string) string { return sent }
+	wantUser := func(orgID uuid.UUID, role string) {
+		t.Helper()
+		u, err := s.GetUserByOAuth("sso", "idp-user")
+		if err != nil || u.OrganizationID != orgID || u.Role != role {
+			t.Fatalf("user = %+v, %v; want org %s role %s", u, err, orgID, role)
+		}
+	}
+
+	// No invite needed: a mapped group provisions the account, and admin wins within the first matching organization.
+	idp.groups = []string{"ipam-users", "ipam-admins", "globex-staff"}
+	if loc := idp.login(t, s, cfg, registry, sameNonce).Header().Get("Location"); loc != "/#dashboard" {
+		t.Fatalf("first login Location = %s", loc)
+	}
+	wantUser(acme.ID, store.RoleAdmin)
+
+	idp.groups = []string{"ipam-users"}
+	idp.login(t, s, cfg, registry, sameNonce)
+	wantUser(acme.ID, store.RoleUser)
+
+	idp.groups = []string{"globex-staff"}
+	idp.login(t, s, cfg, registry, sameNonce)
+	wantUser(globex.ID, store.RoleUser)
+
+	idp.groups = []string{"contractors"}
+	if loc := idp.login(t, s, cfg, registry, sameNonce).Header().Get("Location"); !strings.Contains(loc, "error=") {
+		t.Errorf("unmapped groups: Location = %s", loc)
+	}
+	wantUser(globex.ID, store.RoleUser)
+
+	// A mapping to an organization that does not exist denies access rather than guessing.
+	cfg.OAuth.Providers["sso"] = config.OAuthProviderConfig{ClientID: "cid", ClientSecret: "secret", IssuerURL: idp.srv.URL,
+		GroupMappings: []config.GroupMapping{{Group: "globex-staff", Organization: "Initech", Role: "user"}}}
+	if loc := idp.login(t, s, cfg, registry, sameNonce).Header().Get("Location"); !strings.Contains(loc, "error=") {
+		t.Errorf("unknown organization: Location = %s", loc)
+	}
+}
+
+// testGroupMappings parses group mapping rules, failing the test on error.
+func testGroupMappings(t *testing.T, raw string) []config.GroupMapping {
+	t.Helper()
+	m, err := config.ParseGroupMappings(raw)
+	if err != nil {
+		t.Fatalf("ParseGroupMappings: %v", err)
+	}
+	return m
+}
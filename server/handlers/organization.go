@@ -17,9 +17,19 @@ type CreateOrganizationRequest struct {
 
 // OrganizationResponse is one organization in list or create response.
 type OrganizationResponse struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	CreatedAt string `json:"created_at"`
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	RequireMFA bool   `json:"require_mfa"`
+	CreatedAt  string `json:"created_at"`
+}
+
+func organizationToResponse(o *store.Organization) OrganizationResponse {
+	return OrganizationResponse{
+		ID:         o.ID.String(),
+		Name:       o.Name,
+		RequireMFA: o.RequireMFA,
+		CreatedAt:  o.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+	}
 }
 
 // AdminOrganizationsHandler handles GET (list) and POST (create) /api/admin/organizations. Global admin only.
@@ -39,11 +49,7 @@ func AdminOrganizationsHandler(s store.Storer) http.HandlerFunc {
 			}
 			out := make([]OrganizationResponse, 0, len(orgs))
 			for _, o := range orgs {
-				out = append(out, OrganizationResponse{
-					ID:        o.ID.String(),
-					Name:      o.Name,
-					CreatedAt: o.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-				})
+				out = append(out, organizationToResponse(o))
 			}
 			w.Header().Set("Content-Type", "application/json")
 			_ = json.NewEncoder(w).Encode(map[string]interface{}{"organizations": out})
@@ -65,25 +71,20 @@ func AdminOrganizationsHandler(s store.Storer) http.HandlerFunc {
 			}
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusCreated)
-			_ = json.NewEncoder(w).Encode(map[string]OrganizationResponse{
-				"organization": {
-					ID:        org.ID.String(),
-					Name:      org.Name,
-					CreatedAt: org.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-				},
-			})
+			_ = json.NewEncoder(w).Encode(map[string]OrganizationResponse{"organization": organizationToResponse(org)})
 		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
 	}
 }
 
-// UpdateOrganizationRequest is the body for PATCH /api/admin/organizations/:id.
+// UpdateOrganizationRequest is the body for PATCH /api/admin/organizations/:id. Omitted fields are left unchanged.
 type UpdateOrganizationRequest struct {
-	Name string `json:"name"`
+	Name       string `json:"name"`
+	RequireMFA *bool  `json:"require_mfa,omitempty"`
 }
 
-// AdminOrganizationByIDHandler handles PATCH (update name) and DELETE for /api/admin/organizations/:id. Global admin only.
+// AdminOrganizationByIDHandler handles PATCH (update name or MFA policy) and DELETE for /api/admin/organizations/:id. Global admin only.
 func AdminOrganizationByIDHandler(s store.Storer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := auth.UserFromContext(r.Context())
@@ -112,7 +113,7 @@ func AdminOrganizationByIDHandler(s store.Storer) http.HandlerFunc {
 				return
 			}
 			name := strings.TrimSpace(req.Name)
-			if name == "" {
+			if name == "" && req.RequireMFA == nil {
 				auth.WriteJSONError(w, "name is required", http.StatusBadRequest)
 				return
 			}
@@ -121,19 +122,20 @@ func AdminOrganizationByIDHandler(s store.Storer) http.HandlerFunc {
 				auth.WriteJSONError(w, err.Error(), http.StatusNotFound)
 				return
 			}
-			org.Name = name
+			updated := *org
+			if name != "" {
+				updated.Name = name
+			}
+			if req.RequireMFA != nil {
+				updated.RequireMFA = *req.RequireMFA
+			}
+			org = &updated
 			if err := s.UpdateOrganization(org); err != nil {
 				auth.WriteJSONError(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 			w.Header().Set("Content-Type", "application/json")
-			_ = json.NewEncoder(w).Encode(map[string]OrganizationResponse{
-				"organization": {
-					ID:        org.ID.String(),
-					Name:      org.Name,
-					CreatedAt: org.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
-				},
-			})
+			_ = json.NewEncoder(w).Encode(map[string]OrganizationResponse{"organization": organizationToResponse(org)})
 		case http.MethodDelete:
 			_, err := s.GetOrganization(id)
 			if err != nil {
@@ -3,6 +3,7 @@ package handlers
 import (
 	"encoding/json"
 
+	"github.com/JakeNeyer/ipam/network"
 	"github.com/google/uuid"
 )
 
@@ -68,9 +69,32 @@ type blockOutput struct {
 	Provider       string     `json:"provider,omitempty" minLength:"0" maxLength:"32"`     // "native", "aws", etc.; omitted if native
 	ExternalID     string     `json:"external_id,omitempty" minLength:"0" maxLength:"255"` // provider resource ID
 	ConnectionID   *uuid.UUID `json:"connection_id,omitempty" format:"uuid"`               // cloud connection used to sync
+	Isolated       bool       `json:"isolated,omitempty"`                                  // intentional duplicate; ignored by overlap analysis
 	_              struct{}   `additionalProperties:"false"`
 }
 
+// blockChangeOutput is the result (or preview) of a resize, split or merge.
+type blockChangeOutput struct {
+	Preview         bool                          `json:"preview"`                                   // true when nothing was changed
+	Blocks          []blockOutput                 `json:"blocks"`                                    // resulting blocks, in address order
+	RemovedBlockIDs []uuid.UUID                   `json:"removed_block_ids,omitempty" format:"uuid"` // blocks replaced by the result
+	Allocations     []blockChangeAllocationOutput `json:"allocations"`                               // every allocation involved and the block it ends up in
+}
+
+type blockChangeAllocationOutput struct {
+	ID        uuid.UUID `json:"id" format:"uuid"`
+	Name      string    `json:"name"`
+	CIDR      string    `json:"cidr"`
+	FromBlock string    `json:"from_block"`
+	ToBlock   string    `json:"to_block"`
+}
+
+type moveBlockOutput struct {
+	DryRun      bool                          `json:"dry_run"` // true when nothing was moved
+	Block       blockOutput                   `json:"block"`
+	Allocations []blockChangeAllocationOutput `json:"allocations"` // allocations carried along with the block
+}
+
 type suggestBlockCIDROutput struct {
 	CIDR string   `json:"cidr" minLength:"9" maxLength:"50"`
 	_    struct{} `additionalProperties:"false"`
@@ -98,6 +122,17 @@ type allocationOutput struct {
 	_            struct{}   `additionalProperties:"false"`
 }
 
+type autoAllocateBatchOutput struct {
+	DryRun      bool               `json:"dry_run"`     // true when nothing was created
+	Allocations []allocationOutput `json:"allocations"` // in request order
+}
+
+type moveAllocationOutput struct {
+	DryRun     bool             `json:"dry_run"` // true when nothing was moved
+	Allocation allocationOutput `json:"allocation"`
+	FromBlock  string           `json:"from_block"`
+}
+
 type allocationListOutput struct {
 	Allocations []*allocationOutput `json:"allocations"`
 	Total       int                 `json:"total" minimum:"0"`
@@ -121,23 +156,229 @@ type reservedBlockListOutput struct {
 
 // Integration (cloud connection) output types
 type integrationOutput struct {
-	ID                  uuid.UUID       `json:"id" format:"uuid"`
-	OrganizationID      uuid.UUID       `json:"organization_id" format:"uuid"`
-	Provider            string          `json:"provider" minLength:"1" maxLength:"32"`
-	Name                string          `json:"name" minLength:"1" maxLength:"255"`
-	Config              json.RawMessage `json:"config"`
-	SyncIntervalMinutes int             `json:"sync_interval_minutes"` // 0=off; default 5
-	SyncMode            string          `json:"sync_mode"`             // "read_only" | "read_write"
-	ConflictResolution  string          `json:"conflict_resolution"`   // "cloud" | "ipam"
-	LastSyncAt          *string         `json:"last_sync_at,omitempty" format:"date-time"`
-	LastSyncStatus      *string         `json:"last_sync_status,omitempty"`
-	LastSyncError       *string         `json:"last_sync_error,omitempty"`
-	CreatedAt           string          `json:"created_at" format:"date-time"`
-	UpdatedAt           string          `json:"updated_at" format:"date-time"`
-	_                   struct{}        `additionalProperties:"false"`
+	ID                      uuid.UUID       `json:"id" format:"uuid"`
+	OrganizationID          uuid.UUID       `json:"organization_id" format:"uuid"`
+	Provider                string          `json:"provider" minLength:"1" maxLength:"32"`
+	Name                    string          `json:"name" minLength:"1" maxLength:"255"`
+	Config                  json.RawMessage `json:"config"`
+	SyncIntervalMinutes     int             `json:"sync_interval_minutes"`     // 0=off; default 5
+	SyncMode                string          `json:"sync_mode"`                 // "read_only" | "read_write"
+	ConflictResolution      string          `json:"conflict_resolution"`       // "cloud" | "ipam"
+	CredentialsRef          *string         `json:"credentials_ref,omitempty"` // reference only; secret values are never returned
+	LastSyncAt              *string         `json:"last_sync_at,omitempty" format:"date-time"`
+	LastSyncStatus          *string         `json:"last_sync_status,omitempty"`
+	LastSyncError           *string         `json:"last_sync_error,omitempty"`
+	SyncPaused              bool            `json:"sync_paused"`                                     // background sync stopped manually
+	ConsecutiveSyncFailures int             `json:"consecutive_sync_failures"`                       // reset by the next successful sync
+	SyncBackoffUntil        *string         `json:"sync_backoff_until,omitempty" format:"date-time"` // background sync retries after this time
+	CreatedAt               string          `json:"created_at" format:"date-time"`
+	UpdatedAt               string          `json:"updated_at" format:"date-time"`
+	_                       struct{}        `additionalProperties:"false"`
 }
 
 type integrationListOutput struct {
 	Integrations []*integrationOutput `json:"integrations"`
 	_            struct{}             `additionalProperties:"false"`
 }
+
+// Analysis Output Types
+type overlapResourceOutput struct {
+	Type          string     `json:"type"` // "block" or "allocation"
+	ID            uuid.UUID  `json:"id" format:"uuid"`
+	Name          string     `json:"name"`
+	CIDR          string     `json:"cidr"`
+	EnvironmentID uuid.UUID  `json:"environment_id,omitempty" format:"uuid"`
+	BlockName     string     `json:"block_name,omitempty"` // parent block for allocations
+	Provider      string     `json:"provider,omitempty"`
+	ConnectionID  *uuid.UUID `json:"connection_id,omitempty" format:"uuid"`
+}
+
+type overlapConflictOutput struct {
+	A           overlapResourceOutput `json:"a"`
+	B           overlapResourceOutput `json:"b"`
+	OverlapCIDR string                `json:"overlap_cidr"` // range covered by both
+	Scope       string                `json:"scope"`        // "cross_connection", "cross_environment" or "same_environment"
+}
+
+type overlapReportOutput struct {
+	Conflicts []overlapConflictOutput `json:"conflicts"`
+	Total     int                     `json:"total"`
+}
+
+type connectivityCheckOutput struct {
+	Connectable bool                    `json:"connectable"`
+	Conflicts   []overlapConflictOutput `json:"conflicts"`
+}
+
+// Bulk Output Types
+type bulkResultOutput struct {
+	Index    int         `json:"index"`
+	Op       string      `json:"op"`
+	Resource string      `json:"resource"`
+	ID       *uuid.UUID  `json:"id,omitempty" format:"uuid"`
+	Status   string      `json:"status"`           // "ok" or "error"
+	Code     int         `json:"code,omitempty"`   // HTTP status the single endpoint would return on error
+	Error    string      `json:"error,omitempty"`  // same message as the single endpoint
+	Result   interface{} `json:"result,omitempty"` // same body as the single endpoint on success
+}
+
+type bulkOutput struct {
+	Applied bool               `json:"applied"`
+	Results []bulkResultOutput `json:"results"`
+}
+
+// Import Output Types
+type importRowOutput struct {
+	Row      int        `json:"row,omitempty"`  // CSV line number (the header is line 1)
+	Path     string     `json:"path,omitempty"` // JSON location, e.g. environments[0].pools[1].blocks[0]
+	Resource string     `json:"resource"`       // environment, pool, block or allocation
+	Name     string     `json:"name"`
+	CIDR     string     `json:"cidr,omitempty"`
+	ID       *uuid.UUID `json:"id,omitempty" format:"uuid"` // set once applied
+	Status   string     `json:"status"`                     // "create", "exists" (unchanged) or "error"
+	Code     string     `json:"code,omitempty"`             // invalid_cidr, overlap, reserved_overlap, unknown_environment, unknown_block, not_contained, duplicate_name, invalid
+	Error    string     `json:"error,omitempty"`
+}
+
+type importOutput struct {
+	Applied bool              `json:"applied"`
+	Created int               `json:"created"`
+	Existed int               `json:"existed"`
+	Errors  int               `json:"errors"`
+	Rows    []importRowOutput `json:"rows"`
+}
+
+// Export Output Types
+
+// exportSnapshot is a complete organization written by GET /api/export (format json or yaml).
+type exportSnapshot struct {
+	Organization   exportOrganization    `json:"organization"`
+	ExportedAt     string                `json:"exported_at" format:"date-time"`
+	Environments   []exportEnvironment   `json:"environments"`
+	Blocks         []exportBlock         `json:"blocks,omitempty"` // orphan blocks (no environment)
+	ReservedBlocks []exportReservedBlock `json:"reserved_blocks,omitempty"`
+}
+
+type exportOrganization struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+type exportEnvironment struct {
+	ID     uuid.UUID     `json:"id"`
+	Name   string        `json:"name"`
+	Pools  []exportPool  `json:"pools,omitempty"`
+	Blocks []exportBlock `json:"blocks,omitempty"` // blocks not in a pool
+}
+
+type exportPool struct {
+	ID           uuid.UUID     `json:"id"`
+	Name         string        `json:"name"`
+	CIDR         string        `json:"cidr"`
+	ParentPoolID *uuid.UUID    `json:"parent_pool_id,omitempty"`
+	Provider     string        `json:"provider,omitempty"`
+	ExternalID   string        `json:"external_id,omitempty"`
+	Blocks       []exportBlock `json:"blocks,omitempty"`
+}
+
+type exportBlock struct {
+	ID           uuid.UUID          `json:"id"`
+	Name         string             `json:"name"`
+	CIDR         string             `json:"cidr"`
+	Isolated     bool               `json:"isolated,omitempty"`
+	Provider     string             `json:"provider,omitempty"`
+	ExternalID   string             `json:"external_id,omitempty"`
+	TotalIPs     string             `json:"total_ips"`
+	UsedIPs      string             `json:"used_ips"`
+	AvailableIPs string             `json:"available_ips"`
+	Allocations  []exportAllocation `json:"allocations,omitempty"`
+}
+
+type exportAllocation struct {
+	ID         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	CIDR       string    `json:"cidr"`
+	Provider   string    `json:"provider,omitempty"`
+	ExternalID string    `json:"external_id,omitempty"`
+}
+
+type exportReservedBlock struct {
+	ID     uuid.UUID `json:"id"`
+	Name   string    `json:"name,omitempty"`
+	CIDR   string    `json:"cidr"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Free Space Output Types
+type freeRangeOutput struct {
+	First     string   `json:"first"`
+	Last      string   `json:"last"`
+	Addresses string   `json:"addresses"`
+	CIDRs     []string `json:"cidrs"` // minimal aligned CIDRs covering first..last
+}
+
+type reservedRangeOutput struct {
+	ID     uuid.UUID `json:"id"`
+	Name   string    `json:"name,omitempty"`
+	CIDR   string    `json:"cidr"` // the part of the reserved block inside the block or pool
+	Reason string    `json:"reason,omitempty"`
+}
+
+type freeSpaceOutput struct {
+	ID                uuid.UUID             `json:"id"`
+	Name              string                `json:"name"`
+	CIDR              string                `json:"cidr"`
+	TotalIPs          string                `json:"total_ips"`
+	UsedIPs           string                `json:"used_ips"`
+	ReservedIPs       string                `json:"reserved_ips"`
+	FreeIPs           string                `json:"free_ips"`
+	LargestFreeCIDR   string                `json:"largest_free_cidr,omitempty"`
+	LargestFreePrefix int                   `json:"largest_free_prefix,omitempty"`
+	Fragmentation     float64               `json:"fragmentation"` // 0 = all free space in one CIDR, approaching 1 = scattered
+	FreeCIDRs         []string              `json:"free_cidrs"`
+	Free              []freeRangeOutput     `json:"free"`
+	Reserved          []reservedRangeOutput `json:"reserved"`
+}
+
+// Blueprint Output Types
+type blueprintOutput struct {
+	ID             uuid.UUID             `json:"id" format:"uuid"`
+	OrganizationID uuid.UUID             `json:"organization_id" format:"uuid"`
+	Name           string                `json:"name"`
+	Description    string                `json:"description,omitempty"`
+	Spec           network.BlueprintSpec `json:"spec"`
+	CreatedAt      string                `json:"created_at"`
+	UpdatedAt      string                `json:"updated_at"`
+}
+
+type blueprintListOutput struct {
+	Blueprints []*blueprintOutput `json:"blueprints"`
+}
+
+type blueprintAllocationOutput struct {
+	ID   uuid.UUID `json:"id" format:"uuid"`
+	Name string    `json:"name"`
+	CIDR string    `json:"cidr"`
+}
+
+type blueprintBlockOutput struct {
+	ID          uuid.UUID                   `json:"id" format:"uuid"`
+	Name        string                      `json:"name"`
+	CIDR        string                      `json:"cidr"`
+	Allocations []blueprintAllocationOutput `json:"allocations"`
+}
+
+type blueprintPoolOutput struct {
+	ID     uuid.UUID              `json:"id" format:"uuid"`
+	Name   string                 `json:"name"`
+	CIDR   string                 `json:"cidr"`
+	Blocks []blueprintBlockOutput `json:"blocks"`
+}
+
+type blueprintInstanceOutput struct {
+	Preview       bool                   `json:"preview"` // true when nothing was created
+	EnvironmentID uuid.UUID              `json:"environment_id" format:"uuid"`
+	ParentPoolID  uuid.UUID              `json:"parent_pool_id" format:"uuid"`
+	Pools         []blueprintPoolOutput  `json:"pools"`  // sub-pools of the parent pool
+	Blocks        []blueprintBlockOutput `json:"blocks"` // blocks placed directly in the parent pool
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JakeNeyer/ipam/internal/logger"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/server/mail"
+	"github.com/JakeNeyer/ipam/server/validation"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// changePasswordInput is the request body for POST /api/auth/me/password.
+type changePasswordInput struct {
+	CurrentPassword string `json:"current_password"` // #nosec G117 -- request DTO, not a log/secret leak
+	NewPassword     string `json:"new_password"`     // #nosec G117 -- request DTO, not a log/secret leak
+}
+
+// requestPasswordResetInput is the request body for POST /api/auth/password-reset.
+type requestPasswordResetInput struct {
+	Email string `json:"email"`
+}
+
+// resetPasswordInput is the request body for POST /api/auth/password-reset/confirm.
+type resetPasswordInput struct {
+	Token    string `json:"token"`
+	Password string `json:"password"` // #nosec G117 -- request DTO, not a log/secret leak
+}
+
+// NewChangePasswordUseCase returns a use case for POST /api/auth/me/password. The user's other sessions are signed
+// out; the one making the request stays signed in. Wrong current passwords count against limiter.
+func NewChangePasswordUseCase(s store.Storer, limiter *auth.LoginAttemptLimiter) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input changePasswordInput, _ *struct{}) error {
+		user := auth.UserFromContext(ctx)
+		if user == nil {
+			return status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
+		}
+		if user.PasswordHash == "" {
+			return status.Wrap(errors.New("your account signs in with an identity provider and has no password"), status.FailedPrecondition)
+		}
+		ip := limiter.ClientIP(auth.RequestFromContext(ctx))
+		if limiter != nil && limiter.IsBlocked(ip) {
+			return status.Wrap(errors.New("too many failed attempts; try again later"), status.ResourceExhausted)
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.CurrentPassword)); err != nil {
+			if limiter != nil {
+				limiter.RecordFailure(ip)
+			}
+			logger.Info(logger.MsgAuthPasswordMismatch, logger.KeyOperation, "change_password", logger.KeyUserID, user.ID.String())
+			return status.Wrap(errors.New("current password is incorrect"), status.InvalidArgument)
+		}
+		if !validation.ValidatePassword(input.NewPassword) {
+			return status.Wrap(errors.New("password must be at least 8 characters"), status.InvalidArgument)
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		if err := s.SetUserPassword(user.ID, string(hash), auth.SessionIDFromContext(ctx)); err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		logger.Info("password changed", logger.KeyOperation, "change_password", logger.KeyUserID, user.ID.String())
+		return nil
+	})
+	u.SetTitle("Change password")
+	u.SetDescription("Set a new password after confirming the current one. Signs out the user's other sessions")
+	u.SetExpectedErrors(status.Unauthenticated, status.FailedPrecondition, status.InvalidArgument, status.ResourceExhausted, status.Internal)
+	return u
+}
+
+// passwordResetSendTimeout bounds creating a reset token and sending its email, which happen after the response.
+const passwordResetSendTimeout = time.Minute
+
+// An address is sent at most passwordResetsPerEmail reset emails per passwordResetEmailWindow, however many client
+// IPs ask for them.
+const (
+	passwordResetsPerEmail   = 3
+	passwordResetEmailWindow = time.Hour
+)
+
+// NewRequestPasswordResetUseCase returns a use case for POST /api/auth/password-reset. It emails a single-use reset
+// link when the address belongs to an enabled account with a local password. The response is the same whether or not
+// it does, and the token and email are created after responding so the response time does not tell either; the
+// endpoint does not reveal which addresses have accounts. Every request counts against limiter, and against a
+// per-address limit past which requests are answered as usual but send nothing. Links always point at cfg.AppOrigin,
+// never at a host taken from the request.
+func NewRequestPasswordResetUseCase(s store.Storer, sender mail.Sender, limiter *auth.LoginAttemptLimiter, cfg *config.Config) usecase.Interactor {
+	perEmail := auth.NewLoginAttemptLimiter(passwordResetsPerEmail, passwordResetEmailWindow, nil)
+	u := usecase.NewInteractor(func(ctx context.Context, input requestPasswordResetInput, _ *struct{}) error {
+		if sender == nil {
+			return status.Wrap(errors.New("password reset by email is not configured"), status.FailedPrecondition)
+		}
+		if cfg == nil || strings.TrimSpace(cfg.AppOrigin) == "" {
+			return status.Wrap(errors.New("password reset by email requires APP_ORIGIN"), status.FailedPrecondition)
+		}
+		ip := limiter.ClientIP(auth.RequestFromContext(ctx))
+		if limiter != nil {
+			if limiter.IsBlocked(ip) {
+				return status.Wrap(errors.New("too many password reset requests; try again later"), status.ResourceExhausted)
+			}
+			limiter.RecordFailure(ip)
+		}
+		if !validation.ValidateEmail(input.Email) {
+			return status.Wrap(errors.New("valid email required"), status.InvalidArgument)
+		}
+		email := strings.TrimSpace(strings.ToLower(input.Email))
+		if perEmail.IsBlocked(email) {
+			logger.Info("password reset not sent: too many requests for the address", logger.KeyOperation, "password_reset")
+			return nil
+		}
+		perEmail.RecordFailure(email)
+		user, err := s.GetUserByEmail(email)
+		if err != nil || user.PasswordHash == "" || user.Disabled {
+			logger.Info("password reset not sent: no eligible account", logger.KeyOperation, "password_reset")
+			return nil
+		}
+		go sendPasswordReset(context.WithoutCancel(ctx), s, sender, cfg, user)
+		return nil
+	})
+	u.SetTitle("Request password reset")
+	u.SetDescription("Email a single-use link for choosing a new password. Succeeds whether or not the email has an account")
+	u.SetExpectedErrors(status.InvalidArgument, status.FailedPrecondition, status.ResourceExhausted)
+	return u
+}
+
+// sendPasswordReset creates a reset token for user and emails the link. It runs after the request has been answered,
+// so failures are only logged.
+func sendPasswordReset(ctx context.Context, s store.Storer, sender mail.Sender, cfg *config.Config, user *store.User) {
+	ctx, cancel := context.WithTimeout(ctx, passwordResetSendTimeout)
+	defer cancel()
+	ttl := config.DefaultPasswordResetTTL
+	if cfg.PasswordResetTTL > 0 {
+		ttl = cfg.PasswordResetTTL
+	}
+	_, rawToken, err := s.CreatePasswordReset(user.ID, time.Now().Add(ttl))
+	if err != nil {
+		logger.Error(logger.MsgStoreError, logger.KeyOperation, "password_reset", logger.KeyUserID, user.ID.String(), logger.ErrAttr(err))
+		return
+	}
+	link := strings.TrimSuffix(strings.TrimSpace(cfg.AppOrigin), "/") + "/#reset-password?token=" + rawToken
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your IPAM password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your IPAM account %s.\n\n"+
+			"Open this link within %d minutes to choose a new password:\n%s\n\n"+
+			"If it wasn't you, ignore this email; your password stays the same.\n",
+			user.Email, int(ttl.Minutes()), link),
+	}
+	if err := sender.Send(ctx, msg); err != nil {
+		logger.Error("password reset email failed", logger.KeyOperation, "password_reset", logger.KeyUserID, user.ID.String(), logger.ErrAttr(err))
+		return
+	}
+	logger.Info("password reset sent", logger.KeyOperation, "password_reset", logger.KeyUserID, user.ID.String())
+}
+
+// NewResetPasswordUseCase returns a use case for POST /api/auth/password-reset/confirm. It consumes the reset token,
+// sets the new password and signs the user out everywhere; the user then signs in with the new password.
+func NewResetPasswordUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input resetPasswordInput, _ *struct{}) error {
+		invalid := status.Wrap(errors.New("invalid or expired reset link"), status.InvalidArgument)
+		pr, err := s.GetPasswordResetByToken(strings.TrimSpace(input.Token))
+		if err != nil {
+			return invalid
+		}
+		user, err := s.GetUser(pr.UserID)
+		if err != nil || user.Disabled {
+			return invalid
+		}
+		if !validation.ValidatePassword(input.Password) {
+			return status.Wrap(errors.New("password must be at least 8 characters"), status.InvalidArgument)
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		if err := s.UsePasswordReset(pr.ID, string(hash)); err != nil {
+			return invalid
+		}
+		logger.Info("password reset", logger.KeyOperation, "password_reset", logger.KeyUserID, user.ID.String())
+		return nil
+	})
+	u.SetTitle("Reset password")
+	u.SetDescription("Set a new password with the token from a password reset email. Signs out all of the user's sessions")
+	u.SetExpectedErrors(status.InvalidArgument, status.Internal)
+	return u
+}
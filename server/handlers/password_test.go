@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/server/mail"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/swaggest/usecase/status"
+)
+
+var resetLinkPattern = regexp.MustCompile(`https://ipam\.example\.com/#reset-password\?token=(reset_[0-9a-f]+)`)
+
+// chanSender delivers sent mail on a channel; reset emails are sent after the request returns.
+type chanSender chan mail.Message
+
+func (c chanSender) Send(_ context.Context, msg mail.Message) error {
+	c <- msg
+	return nil
+}
+
+// next waits for the next sent message.
+func (c chanSender) next(t *testing.T) mail.Message {
+	t.Helper()
+	select {
+	case msg := <-c:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email sent")
+		return mail.Message{}
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	s := store.NewStore()
+	alice := passwordUser(t, s, "alice@example.org", store.RoleUser, [16]byte{})
+	sso := &store.User{Email: "sso@example.org", Role: store.RoleUser, OAuthProvider: "github", OAuthProviderUserID: "42"}
+	if err := s.CreateUser(sso); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{AppOrigin: "https://ipam.example.com/", PasswordResetTTL: 30 * time.Minute}
+	sender := make(chanSender, 4)
+	request := func(email string) error {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/password-reset", nil)
+		req.Header.Set("X-Forwarded-Host", "evil.example.net")
+		ctx := auth.WithRequest(context.Background(), req)
+		return NewRequestPasswordResetUseCase(s, sender, nil, cfg).Interact(ctx, requestPasswordResetInput{Email: email}, &struct{}{})
+	}
+	confirm := func(token, password string) error {
+		return NewResetPasswordUseCase(s).Interact(context.Background(), resetPasswordInput{Token: token, Password: password}, &struct{}{})
+	}
+
+	// Unknown addresses and accounts without a password get the same answer and no email.
+	for _, email := range []string{"nobody@example.org", "sso@example.org"} {
+		if err := request(email); err != nil {
+			t.Errorf("request for %s: %v", email, err)
+		}
+	}
+	select {
+	case msg := <-sender:
+		t.Fatalf("mail sent for an ineligible account: %+v", msg)
+	default:
+	}
+
+	if err := request("Alice@Example.org"); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	msg := sender.next(t)
+	m := resetLinkPattern.FindStringSubmatch(msg.Body)
+	if m == nil || msg.To != "alice@example.org" {
+		t.Fatalf("no reset link for alice in %+v", msg)
+	}
+	if !strings.Contains(msg.Body, "within 30 minutes") {
+		t.Errorf("mail does not mention the link lifetime: %s", msg.Body)
+	}
+	token := m[1]
+
+	_, cookie, err := passwordLogin(t, s, cfg, nil, "alice@example.org", "user-password")
+	if err != nil || cookie == nil {
+		t.Fatalf("login: %v", err)
+	}
+	if err := confirm(token, "short"); !errors.Is(err, status.InvalidArgument) {
+		t.Errorf("short password: err = %v", err)
+	}
+	if err := confirm(token, "brand-new-password"); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if _, err := s.GetSession(cookie.Value); err == nil {
+		t.Error("session survived the reset")
+	}
+	if err := confirm(token, "another-password"); !errors.Is(err, status.InvalidArgument) {
+		t.Errorf("reused token: err = %v", err)
+	}
+	if _, _, err := passwordLogin(t, s, cfg, nil, "alice@example.org", "user-password"); !errors.Is(err, status.Unauthenticated) {
+		t.Errorf("old password: err = %v", err)
+	}
+	if _, cookie, err := passwordLogin(t, s, cfg, nil, "alice@example.org", "brand-new-password"); err != nil || cookie == nil {
+		t.Errorf("new password: err = %v", err)
+	}
+
+	// Disabled accounts cannot be reset, even with a link sent before they were disabled.
+	if err := request("alice@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	m = resetLinkPattern.FindStringSubmatch(sender.next(t).Body)
+	if m == nil {
+		t.Fatal("no reset link")
+	}
+	token = m[1]
+	if err := s.SetUserDisabled(alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := confirm(token, "brand-new-password"); !errors.Is(err, status.InvalidArgument) {
+		t.Errorf("disabled account: err = %v", err)
+	}
+
+	if err := NewRequestPasswordResetUseCase(s, nil, nil, cfg).Interact(context.Background(), requestPasswordResetInput{Email: "alice@example.org"}, &struct{}{}); !errors.Is(err, status.FailedPrecondition) {
+		t.Errorf("without a mail sender: err = %v", err)
+	}
+	// Links are never built from request headers, so a reset needs the configured app origin.
+	if err := NewRequestPasswordResetUseCase(s, sender, nil, &config.Config{}).Interact(context.Background(), requestPasswordResetInput{Email: "alice@example.org"}, &struct{}{}); !errors.Is(err, status.FailedPrecondition) {
+		t.Errorf("without APP_ORIGIN: err = %v", err)
+	}
+}
+
+func TestPasswordReset_RateLimited(t *testing.T) {
+	s := store.NewStore()
+	limiter := auth.NewLoginAttemptLimiter(2, time.Minute, nil)
+	uc := NewRequestPasswordResetUseCase(s, &mail.LogSender{Logger: slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil))}, limiter, &config.Config{AppOrigin: "https://ipam.example.com"})
+	// A client that makes up X-Forwarded-For still counts as its peer address.
+	request := func(i int) error {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/password-reset", nil)
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i))
+		ctx := auth.WithRequest(context.Background(), req)
+		return uc.Interact(ctx, requestPasswordResetInput{Email: "nobody@example.org"}, &struct{}{})
+	}
+	for i := 0; i < 2; i++ {
+		if err := request(i); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	if err := request(2); !errors.Is(err, status.ResourceExhausted) {
+		t.Errorf("third request: err = %v", err)
+	}
+}
+
+func TestPasswordReset_PerEmailLimit(t *testing.T) {
+	s := store.NewStore()
+	passwordUser(t, s, "alice@example.org", store.RoleUser, [16]byte{})
+	sender := make(chanSender, passwordResetsPerEmail+1)
+	uc := NewRequestPasswordResetUseCase(s, sender, nil, &config.Config{AppOrigin: "https://ipam.example.com"})
+	for i := 0; i <= passwordResetsPerEmail; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/password-reset", nil)
+		req.RemoteAddr = fmt.Sprintf("198.51.100.%d:1234", i+1)
+		if err := uc.Interact(auth.WithRequest(context.Background(), req), requestPasswordResetInput{Email: "alice@example.org"}, &struct{}{}); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+	for i := 0; i < passwordResetsPerEmail; i++ {
+		sender.next(t)
+	}
+	select {
+	case msg := <-sender:
+		t.Errorf("mail sent past the per-address limit: %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	s := store.NewStore()
+	bob := passwordUser(t, s, "bob@example.org", store.RoleUser, [16]byte{})
+	now := time.Now()
+	s.CreateSession("current", bob.ID, now.Add(time.Hour))
+	s.CreateSession("laptop", bob.ID, now.Add(time.Hour))
+	change := func(user *store.User, current, next string) error {
+		ctx := auth.WithSessionID(auth.WithUser(context.Background(), user), "current")
+		return NewChangePasswordUseCase(s, nil).Interact(ctx, changePasswordInput{CurrentPassword: current, NewPassword: next}, &struct{}{})
+	}
+
+	if err := change(bob, "wrong-password", "brand-new-password"); !errors.Is(err, status.InvalidArgument) {
+		t.Errorf("wrong current password: err = %v", err)
+	}
+	if err := change(bob, "user-password", "short"); !errors.Is(err, status.InvalidArgument) {
+		t.Errorf("short password: err = %v", err)
+	}
+	if err := change(bob, "user-password", "brand-new-password"); err != nil {
+		t.Fatalf("change: %v", err)
+	}
+	if _, err := s.GetSession("current"); err != nil {
+		t.Errorf("current session revoked: %v", err)
+	}
+	if _, err := s.GetSession("laptop"); err == nil {
+		t.Error("other session survived the password change")
+	}
+	if _, cookie, err := passwordLogin(t, s, nil, nil, "bob@example.org", "brand-new-password"); err != nil || cookie == nil {
+		t.Errorf("login with the new password: err = %v", err)
+	}
+
+	sso := &store.User{Email: "sso@example.org", Role: store.RoleUser, OAuthProvider: "github", OAuthProviderUserID: "42"}
+	if err := s.CreateUser(sso); err != nil {
+		t.Fatal(err)
+	}
+	if err := change(sso, "", "brand-new-password"); !errors.Is(err, status.FailedPrecondition) {
+		t.Errorf("account without a password: err = %v", err)
+	}
+}
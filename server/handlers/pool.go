@@ -22,6 +22,10 @@ func NewSuggestPoolBlockCIDRUseCase(s store.Storer) usecase.Interactor {
 		if input.Prefix < 9 || input.Prefix > 32 {
 			return status.Wrap(errors.New("prefix must be between 9 and 32"), status.InvalidArgument)
 		}
+		placement, err := placementFromInput(input.Strategy, input.AlignTo)
+		if err != nil {
+			return err
+		}
 		pool, err := s.GetPool(input.ID)
 		if err != nil {
 			return status.Wrap(errors.New("pool not found"), status.NotFound)
@@ -49,20 +53,9 @@ func NewSuggestPoolBlockCIDRUseCase(s store.Storer) usecase.Interactor {
 			if err != nil {
 				return status.Wrap(err, status.Internal)
 			}
-			for _, r := range reserved {
-				overlap, _ := network.Overlaps(pool.CIDR, r.CIDR)
-				if !overlap {
-					continue
-				}
-				contained, _ := network.Contains(pool.CIDR, r.CIDR)
-				if contained {
-					existingCIDRs = append(existingCIDRs, r.CIDR)
-				} else {
-					existingCIDRs = append(existingCIDRs, pool.CIDR)
-				}
-			}
+			existingCIDRs = append(existingCIDRs, reservedWithin(pool.CIDR, reserved)...)
 		}
-		cidr, err := network.NextAvailableCIDRWithAllocations(pool.CIDR, input.Prefix, existingCIDRs)
+		cidr, err := network.NextAvailableCIDRWithStrategy(pool.CIDR, input.Prefix, existingCIDRs, placement)
 		if err != nil {
 			return status.Wrap(err, status.InvalidArgument)
 		}
@@ -70,7 +63,7 @@ func NewSuggestPoolBlockCIDRUseCase(s store.Storer) usecase.Interactor {
 		return nil
 	})
 	u.SetTitle("Suggest Pool Block CIDR")
-	u.SetDescription("Suggests a CIDR for a new block in the pool at the given prefix length, considering existing blocks in that pool")
+	u.SetDescription("Suggests a CIDR for a new block in the pool at the given prefix length, considering existing blocks in that pool and the placement strategy (default best_fit)")
 	u.SetExpectedErrors(status.NotFound, status.InvalidArgument, status.Internal)
 	return u
 }
@@ -82,7 +75,7 @@ func NewCreatePoolUseCase(s store.Storer) usecase.Interactor {
 			return status.Wrap(errors.New("name and CIDR are required"), status.InvalidArgument)
 		}
 		if valid := network.ValidateCIDR(input.CIDR); !valid {
-			return status.Wrap(errors.New("invalid CIDR format"), status.InvalidArgument)
+			return status.Wrap(errInvalidCIDR, status.InvalidArgument)
 		}
 		user := auth.UserFromContext(ctx)
 		if user == nil {
@@ -90,10 +83,10 @@ func NewCreatePoolUseCase(s store.Storer) usecase.Interactor {
 		}
 		env, err := s.GetEnvironment(input.EnvironmentID)
 		if err != nil {
-			return status.Wrap(errors.New("environment not found"), status.NotFound)
+			return status.Wrap(errEnvironmentNotFound, status.NotFound)
 		}
 		if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-			return status.Wrap(errors.New("environment not found"), status.NotFound)
+			return status.Wrap(errEnvironmentNotFound, status.NotFound)
 		}
 		var parentPool *network.Pool
 		if input.ParentPoolID != nil && *input.ParentPoolID != uuid.Nil {
@@ -113,7 +106,7 @@ func NewCreatePoolUseCase(s store.Storer) usecase.Interactor {
 			}
 			if !contained {
 				return status.Wrap(
-					fmt.Errorf("child pool CIDR %s must be contained in parent pool %q (%s)", input.CIDR, parent.Name, parent.CIDR),
+					conflictf(errNotContained, "child pool CIDR %s must be contained in parent pool %q (%s)", input.CIDR, parent.Name, parent.CIDR),
 					status.InvalidArgument,
 				)
 			}
@@ -133,7 +126,7 @@ func NewCreatePoolUseCase(s store.Storer) usecase.Interactor {
 			}
 			if overlap {
 				return status.Wrap(
-					fmt.Errorf("pool CIDR %s overlaps with existing pool %q (%s)", input.CIDR, other.Name, other.CIDR),
+					conflictf(errOverlap, "pool CIDR %s overlaps with existing pool %q (%s)", input.CIDR, other.Name, other.CIDR),
 					status.InvalidArgument,
 				)
 			}
@@ -263,10 +256,10 @@ func NewListPoolsUseCase(s store.Storer) usecase.Interactor {
 		} else if input.EnvironmentID != uuid.Nil {
 			env, err := s.GetEnvironment(input.EnvironmentID)
 			if err != nil {
-				return status.Wrap(errors.New("environment not found"), status.NotFound)
+				return status.Wrap(errEnvironmentNotFound, status.NotFound)
 			}
 			if userOrg := auth.UserOrgForAccess(ctx, user); userOrg != uuid.Nil && env.OrganizationID != userOrg {
-				return status.Wrap(errors.New("environment not found"), status.NotFound)
+				return status.Wrap(errEnvironmentNotFound, status.NotFound)
 			}
 			pools, err = s.ListPoolsByEnvironment(input.EnvironmentID)
 			if err != nil {
@@ -319,7 +312,7 @@ func NewUpdatePoolUseCase(s store.Storer) usecase.Interactor {
 			}
 		}
 		if valid := network.ValidateCIDR(input.CIDR); !valid {
-			return status.Wrap(errors.New("invalid CIDR format"), status.InvalidArgument)
+			return status.Wrap(errInvalidCIDR, status.InvalidArgument)
 		}
 		existingPools, err := s.ListPoolsByOrganization(pool.OrganizationID)
 		if err != nil {
@@ -335,7 +328,7 @@ func NewUpdatePoolUseCase(s store.Storer) usecase.Interactor {
 			}
 			if overlap {
 				return status.Wrap(
-					fmt.Errorf("pool CIDR %s overlaps with existing pool %q (%s)", input.CIDR, other.Name, other.CIDR),
+					conflictf(errOverlap, "pool CIDR %s overlaps with existing pool %q (%s)", input.CIDR, other.Name, other.CIDR),
 					status.InvalidArgument,
 				)
 			}
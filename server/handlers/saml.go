@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/JakeNeyer/ipam/internal/logger"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/server/oauth"
+	"github.com/JakeNeyer/ipam/store"
+)
+
+// samlProviderFromPath returns the SAML provider id in /api/auth/saml/<id>/<suffix>.
+func samlProviderFromPath(r *http.Request, suffix string) string {
+	provider := strings.TrimPrefix(r.URL.Path, "/api/auth/saml/")
+	provider = strings.TrimSuffix(provider, "/"+suffix)
+	return config.NormalizeOAuthProviderID(strings.Trim(provider, "/"))
+}
+
+// SAMLMetadataHandler serves the SP metadata document to register with the IdP.
+func SAMLMetadataHandler(cfg *config.Config, registry *oauth.ProviderRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		provider := samlProviderFromPath(r, "metadata")
+		sp, ok := registry.SAML(provider)
+		if cfg.SAMLProvider(provider) == nil || !ok {
+			auth.WriteJSONError(w, "SAML provider not enabled", http.StatusNotFound)
+			return
+		}
+		md, err := sp.Metadata()
+		if err != nil {
+			logger.Error("failed to build SAML metadata", logger.ErrAttr(err))
+			auth.WriteJSONError(w, "could not build metadata", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/samlmetadata+xml")
+		_, _ = w.Write(md)
+	}
+}
+
+// SAMLStartHandler redirects the browser to the IdP with a signed AuthnRequest.
+func SAMLStartHandler(cfg *config.Config, registry *oauth.ProviderRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		provider := samlProviderFromPath(r, "start")
+		sp, ok := registry.SAML(provider)
+		if cfg.SAMLProvider(provider) == nil || !ok {
+			auth.WriteJSONError(w, "SAML provider not enabled", http.StatusNotFound)
+			return
+		}
+		relayState, err := auth.NewOAuthStateNonce()
+		if err != nil {
+			auth.WriteJSONError(w, "could not start SAML sign-in", http.StatusInternalServerError)
+			return
+		}
+		authURL, requestID, err := sp.AuthnRequestURL(r.Context(), relayState)
+		if err != nil {
+			logger.Error("failed to build SAML AuthnRequest", logger.ErrAttr(err))
+			auth.WriteJSONError(w, "identity provider unavailable", http.StatusBadGateway)
+			return
+		}
+		state := auth.SAMLStatePayload{
+			RelayState:  relayState,
+			RequestID:   requestID,
+			Provider:    provider,
+			InviteToken: strings.TrimSpace(r.URL.Query().Get("invite_token")),
+		}
+		if err := auth.SetSAMLStateCookie(w, state, requestSecure(r)); err != nil {
+			auth.WriteJSONError(w, "could not start SAML sign-in", http.StatusInternalServerError)
+			return
+		}
+		// #nosec G710 -- SSO URL comes from the operator-configured IdP metadata.
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// SAMLACSHandler is the assertion consumer service: it verifies the IdP's response (HTTP-POST binding) and signs the
+// user in with the same session cookie as password login.
+func SAMLACSHandler(s store.Storer, cfg *config.Config, registry *oauth.ProviderRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		provider := samlProviderFromPath(r, "acs")
+		pc := cfg.SAMLProvider(provider)
+		sp, ok := registry.SAML(provider)
+		if pc == nil || !ok {
+			redirectWithError(w, r, "SAML provider not enabled", cfg.AppOrigin)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			redirectWithError(w, r, "invalid SAML response", cfg.AppOrigin)
+			return
+		}
+		samlResponse := r.PostForm.Get("SAMLResponse")
+		if samlResponse == "" {
+			redirectWithError(w, r, "missing SAML response", cfg.AppOrigin)
+			return
+		}
+		secure := requestSecure(r)
+		stored, ok := auth.SAMLStateFromRequest(r)
+		if !ok || stored.RelayState != r.PostForm.Get("RelayState") || stored.Provider != provider {
+			redirectWithError(w, r, "invalid or expired SAML state", cfg.AppOrigin)
+			return
+		}
+		auth.ClearSAMLStateCookie(w, secure)
+		identity, err := sp.ParseResponse(r.Context(), samlResponse, stored.RequestID)
+		if err != nil {
+			logger.Error("rejected SAML response", logger.ErrAttr(err))
+			redirectWithError(w, r, "invalid SAML response", cfg.AppOrigin)
+			return
+		}
+		completeSSOLogin(w, r, s, cfg, ssoLogin{
+			provider:        "saml:" + provider,
+			providerUserID:  identity.ProviderUserID,
+			email:           identity.Email,
+			groups:          identity.Groups,
+			inviteToken:     stored.InviteToken,
+			allowEmailMatch: pc.AllowEmailMatch,
+			groupMappings:   pc.GroupMappings,
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/server/oauth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+func samlTestKeyPair(t *testing.T, cn string) (tls.Certificate, []byte, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: cn},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair, certPEM, keyPEM
+}
+
+// samlTestIdP signs assertions for provider "corp" served at https://example.com.
+type samlTestIdP struct {
+	cert tls.Certificate
+}
+
+const (
+	samlTestIdPEntityID = "https://idp.example/metadata"
+	samlTestSPBase      = "https://example.com" // APP_ORIGIN
+)
+
+// config returns provider "corp" trusting the IdP, with a generated SP key pair.
+func (idp *samlTestIdP) config(t *testing.T) config.SAMLProviderConfig {
+	t.Helper()
+	dir := t.TempDir()
+	_, certPEM, keyPEM := samlTestKeyPair(t, "ipam.example")
+	md, _ := xml.Marshal(saml.EntityDescriptor{
+		EntityID: samlTestIdPEntityID,
+		IDPSSODescriptors: []saml.IDPSSODescriptor{{
+			SSODescriptor: saml.SSODescriptor{RoleDescriptor: saml.RoleDescriptor{
+				KeyDescriptors: []saml.KeyDescriptor{{Use: "signing", KeyInfo: saml.KeyInfo{X509Data: saml.X509Data{
+					X509Certificates: []saml.X509Certificate{{Data: base64.StdEncoding.EncodeToString(idp.cert.Leaf.Raw)}},
+				}}}},
+			}},
+			SingleSignOnServices: []saml.Endpoint{{Binding: saml.HTTPRedirectBinding, Location: "https://idp.example/sso"}},
+		}},
+	})
+	pc := config.SAMLProviderConfig{
+		EntityID:        samlTestSPBase + "/api/auth/saml/corp/metadata",
+		CertFile:        filepath.Join(dir, "sp.crt"),
+		KeyFile:         filepath.Join(dir, "sp.key"),
+		IDPMetadataFile: filepath.Join(dir, "idp.xml"),
+	}
+	for name, data := range map[string][]byte{pc.CertFile: certPEM, pc.KeyFile: keyPEM, pc.IDPMetadataFile: md} {
+		if err := os.WriteFile(name, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pc
+}
+
+// response returns a SAMLResponse for requestID asserting email and groups, signed with signer.
+func (idp *samlTestIdP) response(t *testing.T, signer tls.Certificate, requestID, email string, groups ...string) string {
+	t.Helper()
+	now := time.Now()
+	attrs := []saml.Attribute{{Name: "mail", Values: []saml.AttributeValue{{Value: email}}}}
+	if len(groups) > 0 {
+		a := saml.Attribute{Name: "groups"}
+		for _, g := range groups {
+			a.Values = append(a.Values, saml.AttributeValue{Value: g})
+		}
+		attrs = append(attrs, a)
+	}
+	assertion := &saml.Assertion{
+		ID: "id-" + uuid.NewString(), IssueInstant: now, Version: "2.0",
+		Issuer: saml.Issuer{Value: samlTestIdPEntityID},
+		Subject: &saml.Subject{
+			NameID: &saml.NameID{Format: string(saml.PersistentNameIDFormat), Value: "saml-user"},
+			SubjectConfirmations: []saml.SubjectConfirmation{{
+				Method: "urn:oasis:names:tc:SAML:2.0:cm:bearer",
+				SubjectConfirmationData: &saml.SubjectConfirmationData{
+					InResponseTo: requestID, NotOnOrAfter: now.Add(5 * time.Minute),
+					Recipient: samlTestSPBase + "/api/auth/saml/corp/acs",
+				},
+			}},
+		},
+		Conditions: &saml.Conditions{
+			NotBefore: now.Add(-time.Minute), NotOnOrAfter: now.Add(5 * time.Minute),
+			AudienceRestrictions: []saml.AudienceRestriction{{Audience: saml.Audience{Value: samlTestSPBase + "/api/auth/saml/corp/metadata"}}},
+		},
+		AttributeStatements: []saml.AttributeStatement{{Attributes: attrs}},
+	}
+	ctx := dsig.NewDefaultSigningContext(dsig.TLSCertKeyStore(signer))
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	if err := ctx.SetSignatureMethod(dsig.RSASHA256SignatureMethod); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := ctx.SignEnveloped(assertion.Element())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := (&saml.Response{
+		ID: "id-" + uuid.NewString(), InResponseTo: requestID, Version: "2.0", IssueInstant: now,
+		Issuer: &saml.Issuer{Value: samlTestIdPEntityID},
+		Status: saml.Status{StatusCode: saml.StatusCode{Value: saml.StatusSuccess}},
+	}).Element()
+	resp.AddChild(signed)
+	doc := etree.NewDocument()
+	doc.SetRoot(resp)
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// samlLogin runs the start and ACS handlers over HTTPS; respond builds the IdP's response to the AuthnRequest.
+func samlLogin(t *testing.T, s store.Storer, cfg *config.Config, registry *oauth.ProviderRegistry, respond func(requestID string) string) *httptest.ResponseRecorder {
+	t.Helper()
+	start := httptest.NewRequest(http.MethodGet, "/api/auth/saml/corp/start", nil)
+	start.Header.Set("X-Forwarded-Proto", "https")
+	rr := httptest.NewRecorder()
+	SAMLStartHandler(cfg, registry)(rr, start)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("start status = %d: %s", rr.Code, rr.Body)
+	}
+	authURL, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil || authURL.Host != "idp.example" || authURL.Query().Get("Signature") == "" {
+		t.Fatalf("AuthnRequest URL = %v, %v", authURL, err)
+	}
+	var state auth.SAMLStatePayload
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == auth.SAMLStateCookieName {
+			if c.SameSite != http.SameSiteNoneMode || !c.Secure {
+				t.Errorf("state cookie must be SameSite=None; Secure to survive the IdP's cross-site POST")
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(c)
+			state, _ = auth.SAMLStateFromRequest(r)
+		}
+	}
+	if state.RequestID == "" {
+		t.Fatal("no SAML state cookie")
+	}
+
+	form := url.Values{"SAMLResponse": {respond(state.RequestID)}, "RelayState": {authURL.Query().Get("RelayState")}}
+	acs := httptest.NewRequest(http.MethodPost, "/api/auth/saml/corp/acs", strings.NewReader(form.Encode()))
+	acs.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	acs.Header.Set("X-Forwarded-Proto", "https")
+	for _, c := range rr.Result().Cookies() {
+		acs.AddCookie(c)
+	}
+	cb := httptest.NewRecorder()
+	SAMLACSHandler(s, cfg, registry)(cb, acs)
+	return cb
+}
+
+func TestSAML_LoginSetsSessionCookie(t *testing.T) {
+	idpCert, _, _ := samlTestKeyPair(t, "idp.example")
+	idp := &samlTestIdP{cert: idpCert}
+	pc := idp.config(t)
+	pc.AllowEmailMatch = true
+	cfg := &config.Config{AppOrigin: samlTestSPBase, SAML: config.SAMLConfig{Providers: map[string]config.SAMLProviderConfig{"corp": pc}}}
+	registry, err := oauth.NewProviderRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := store.NewStore()
+	user := &store.User{Email: "saml@example.com", Role: store.RoleUser, OrganizationID: uuid.New()}
+	if err := s.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	cb := samlLogin(t, s, cfg, registry, func(id string) string { return idp.response(t, idpCert, id, "SAML@example.com") })
+	if loc := cb.Header().Get("Location"); loc != samlTestSPBase+"/#dashboard" {
+		t.Fatalf("ACS Location = %s", loc)
+	}
+	var session *http.Cookie
+	for _, c := range cb.Result().Cookies() {
+		if c.Name == auth.SessionCookieName && c.Value != "" {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("no session cookie after SAML login")
+	}
+	if sess, err := s.GetSession(session.Value); err != nil || sess.UserID != user.ID {
+		t.Errorf("session = %+v, %v; want user %s", sess, err, user.ID)
+	}
+	if got, _ := s.GetUser(user.ID); got.OAuthProvider != "saml:corp" || got.OAuthProviderUserID != "saml-user" {
+		t.Errorf("user not linked to SAML identity: %+v", got)
+	}
+
+	// An assertion signed by a key the IdP metadata does not list is rejected, as is one for another request.
+	forged, _, _ := samlTestKeyPair(t, "attacker.example")
+	for name, respond := range map[string]func(string) string{
+		"forged":      func(id string) string { return idp.response(t, forged, id, "saml@example.com") },
+		"unsolicited": func(string) string { return idp.response(t, idpCert, "id-other", "saml@example.com") },
+	} {
+		cb := samlLogin(t, s, cfg, registry, respond)
+		if loc := cb.Header().Get("Location"); !strings.Contains(loc, "error=") {
+			t.Errorf("%s: Location = %s", name, loc)
+		}
+		for _, c := range cb.Result().Cookies() {
+			if c.Name == auth.SessionCookieName && c.Value != "" {
+				t.Errorf("%s: session cookie set", name)
+			}
+		}
+	}
+}
+
+func TestSAML_GroupMappingsProvision(t *testing.T) {
+	idpCert, _, _ := samlTestKeyPair(t, "idp.example")
+	idp := &samlTestIdP{cert: idpCert}
+	s := store.NewStore()
+	acme := &store.Organization{Name: "Acme"}
+	if err := s.CreateOrganization(acme); err != nil {
+		t.Fatal(err)
+	}
+	pc := idp.config(t)
+	pc.GroupMappings = testGroupMappings(t, "net-admins=acme:admin")
+	cfg := &config.Config{AppOrigin: samlTestSPBase, SAML: config.SAMLConfig{Providers: map[string]config.SAMLProviderConfig{"corp": pc}}}
+	registry, err := oauth.NewProviderRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cb := samlLogin(t, s, cfg, registry, func(id string) string {
+		return idp.response(t, idpCert, id, "new@example.com", "net-admins")
+	})
+	if loc := cb.Header().Get("Location"); loc != samlTestSPBase+"/#dashboard" {
+		t.Fatalf("ACS Location = %s", loc)
+	}
+	u, err := s.GetUserByOAuth("saml:corp", "saml-user")
+	if err != nil || u.OrganizationID != acme.ID || u.Role != store.RoleAdmin || u.Email != "new@example.com" {
+		t.Errorf("provisioned user = %+v, %v", u, err)
+	}
+}
+
+func TestSAMLMetadataHandler(t *testing.T) {
+	idpCert, _, _ := samlTestKeyPair(t, "idp.example")
+	cfg := &config.Config{AppOrigin: samlTestSPBase, SAML: config.SAMLConfig{Providers: map[string]config.SAMLProviderConfig{
+		"corp": (&samlTestIdP{cert: idpCert}).config(t),
+	}}}
+	registry, err := oauth.NewProviderRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/saml/corp/metadata", nil)
+	req.Header.Set("X-Forwarded-Host", "evil.example.net")
+	SAMLMetadataHandler(cfg, registry)(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), samlTestSPBase+"/api/auth/saml/corp/acs") {
+		t.Errorf("metadata = %d %s", rr.Code, rr.Body)
+	}
+	if strings.Contains(rr.Body.String(), "evil.example.net") {
+		t.Error("metadata built from X-Forwarded-Host")
+	}
+	rr = httptest.NewRecorder()
+	SAMLMetadataHandler(cfg, registry)(rr, httptest.NewRequest(http.MethodGet, "/api/auth/saml/other/metadata", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown provider status = %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	AuthConfigHandler(cfg)(rr, httptest.NewRequest(http.MethodGet, "/api/auth/config", nil))
+	if !strings.Contains(rr.Body.String(), `"start_path":"/api/auth/saml/corp/start"`) {
+		t.Errorf("auth config does not offer the SAML provider: %s", rr.Body)
+	}
+}
@@ -0,0 +1,990 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JakeNeyer/ipam/internal/logger"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/server/validation"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+)
+
+// SCIM 2.0 (RFC 7643, RFC 7644) provisioning API. Users are IPAM users keyed by email (userName); the global admin is
+// not visible. Groups are stored as pushed and only matter through SCIM_GROUP_MAPPINGS, which set their members'
+// organization and role.
+const (
+	scimUserSchema    = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema   = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema    = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema   = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimSPConfSchema  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimResTypeSchema = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	scimContentType   = "application/scim+json"
+
+	scimDefaultPageSize = 100
+	scimMaxPageSize     = 200
+)
+
+type scimMeta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// scimRef is a group's member or a user's group.
+type scimRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type scimUser struct {
+	Schemas    []string    `json:"schemas"`
+	ID         string      `json:"id,omitempty"`
+	ExternalID string      `json:"externalId,omitempty"`
+	UserName   string      `json:"userName"`
+	Active     *bool       `json:"active,omitempty"`
+	Emails     []scimEmail `json:"emails,omitempty"`
+	Groups     []scimRef   `json:"groups,omitempty"`
+	Meta       *scimMeta   `json:"meta,omitempty"`
+}
+
+type scimGroup struct {
+	Schemas     []string  `json:"schemas"`
+	ID          string    `json:"id,omitempty"`
+	ExternalID  string    `json:"externalId,omitempty"`
+	DisplayName string    `json:"displayName"`
+	Members     []scimRef `json:"members,omitempty"`
+	Meta        *scimMeta `json:"meta,omitempty"`
+}
+
+type scimListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+type scimPatchRequest struct {
+	Schemas    []string `json:"schemas"`
+	Operations []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	} `json:"Operations"`
+}
+
+// scimError is an error response; scimType is empty or one of the RFC 7644 section 3.12 detail codes.
+type scimError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *scimError) Error() string { return e.detail }
+
+func scimBadRequest(scimType, format string, args ...any) *scimError {
+	return &scimError{status: http.StatusBadRequest, scimType: scimType, detail: fmt.Sprintf(format, args...)}
+}
+
+var errSCIMNotFound = &scimError{status: http.StatusNotFound, detail: "resource not found"}
+
+func writeSCIM(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeSCIMError(w http.ResponseWriter, err error) {
+	var se *scimError
+	if !errors.As(err, &se) {
+		logger.Error("scim request failed", logger.ErrAttr(err))
+		se = &scimError{status: http.StatusInternalServerError, detail: "internal error"}
+	}
+	body := map[string]any{"schemas": []string{scimErrorSchema}, "status": strconv.Itoa(se.status), "detail": se.detail}
+	if se.scimType != "" {
+		body["scimType"] = se.scimType
+	}
+	writeSCIM(w, se.status, body)
+}
+
+// scimAuthorized checks the bearer token in constant time (hashing first so the token length does not leak either).
+func scimAuthorized(r *http.Request, token string) bool {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	got := sha256.Sum256([]byte(strings.TrimSpace(raw)))
+	want := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(got[:], want[:]) == 1
+}
+
+// SCIMHandler serves /scim/v2/ for an identity provider holding cfg.SCIM.Token.
+func SCIMHandler(s store.Storer, cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !scimAuthorized(r, cfg.SCIM.Token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			writeSCIMError(w, &scimError{status: http.StatusUnauthorized, detail: "invalid SCIM token"})
+			return
+		}
+		api := &scimAPI{s: s, cfg: cfg, base: redirectBase(r) + "/scim/v2"}
+		resource, id, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/scim/v2"), "/"), "/")
+		var err error
+		switch {
+		case resource == "ServiceProviderConfig" && id == "" && r.Method == http.MethodGet:
+			api.serviceProviderConfig(w)
+		case resource == "ResourceTypes" && id == "" && r.Method == http.MethodGet:
+			api.resourceTypes(w)
+		case resource == "Users" && id == "" && r.Method == http.MethodGet:
+			err = api.listUsers(w, r)
+		case resource == "Users" && id == "" && r.Method == http.MethodPost:
+			err = api.createUser(w, r)
+		case resource == "Users" && id != "":
+			err = api.user(w, r, id)
+		case resource == "Groups" && id == "" && r.Method == http.MethodGet:
+			err = api.listGroups(w, r)
+		case resource == "Groups" && id == "" && r.Method == http.MethodPost:
+			err = api.createGroup(w, r)
+		case resource == "Groups" && id != "":
+			err = api.group(w, r, id)
+		case resource == "Users" || resource == "Groups":
+			err = &scimError{status: http.StatusMethodNotAllowed, detail: "method not allowed"}
+		default:
+			err = errSCIMNotFound
+		}
+		if err != nil {
+			writeSCIMError(w, err)
+		}
+	}
+}
+
+type scimAPI struct {
+	s    store.Storer
+	cfg  *config.Config
+	base string             // absolute URL of /scim/v2, for meta.location
+	orgs map[uuid.UUID]bool // scimOrganizations, loaded once per request
+}
+
+func (a *scimAPI) serviceProviderConfig(w http.ResponseWriter) {
+	supported := func(ok bool) map[string]bool { return map[string]bool{"supported": ok} }
+	writeSCIM(w, http.StatusOK, map[string]any{
+		"schemas":        []string{scimSPConfSchema},
+		"patch":          supported(true),
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": scimMaxPageSize},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]any{{
+			"type": "oauthbearertoken", "name": "Bearer token", "description": "The SCIM_TOKEN configured on the server", "primary": true,
+		}},
+		"meta": scimMeta{ResourceType: "ServiceProviderConfig", Location: a.base + "/ServiceProviderConfig"},
+	})
+}
+
+func (a *scimAPI) resourceTypes(w http.ResponseWriter) {
+	types := []any{
+		map[string]any{"schemas": []string{scimResTypeSchema}, "id": "User", "name": "User", "endpoint": "/Users", "schema": scimUserSchema,
+			"meta": scimMeta{ResourceType: "ResourceType", Location: a.base + "/ResourceTypes/User"}},
+		map[string]any{"schemas": []string{scimResTypeSchema}, "id": "Group", "name": "Group", "endpoint": "/Groups", "schema": scimGroupSchema,
+			"meta": scimMeta{ResourceType: "ResourceType", Location: a.base + "/ResourceTypes/Group"}},
+	}
+	writeSCIM(w, http.StatusOK, scimListResponse{Schemas: []string{scimListSchema}, TotalResults: len(types), StartIndex: 1, ItemsPerPage: len(types), Resources: types})
+}
+
+// scimFilterPattern matches the one filter form identity providers use to look resources up: attr eq "value".
+var scimFilterPattern = regexp.MustCompile(`(?i)^\s*([a-z][a-z0-9.]*)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+// parseSCIMFilter returns the attribute (lowercased) and value of an eq filter; attr is empty when there is no filter.
+func parseSCIMFilter(filter string, allowed ...string) (attr, value string, err error) {
+	if strings.TrimSpace(filter) == "" {
+		return "", "", nil
+	}
+	m := scimFilterPattern.FindStringSubmatch(filter)
+	if m == nil {
+		return "", "", scimBadRequest("invalidFilter", `only filters of the form attribute eq "value" are supported`)
+	}
+	attr = strings.ToLower(m[1])
+	if !slices.Contains(allowed, attr) {
+		return "", "", scimBadRequest("invalidFilter", "cannot filter on %s", m[1])
+	}
+	if err := json.Unmarshal([]byte(`"`+m[2]+`"`), &value); err != nil {
+		return "", "", scimBadRequest("invalidFilter", "invalid filter value")
+	}
+	return attr, value, nil
+}
+
+// scimPage applies startIndex (1-based) and count to n results and returns the slice bounds.
+func scimPage(r *http.Request, n int) (start, end int, err error) {
+	startIndex, count := 1, scimDefaultPageSize
+	if v := r.URL.Query().Get("startIndex"); v != "" {
+		if startIndex, err = strconv.Atoi(v); err != nil {
+			return 0, 0, scimBadRequest("invalidValue", "startIndex must be an integer")
+		}
+		startIndex = max(startIndex, 1)
+	}
+	if v := r.URL.Query().Get("count"); v != "" {
+		if count, err = strconv.Atoi(v); err != nil {
+			return 0, 0, scimBadRequest("invalidValue", "count must be an integer")
+		}
+		count = min(max(count, 0), scimMaxPageSize)
+	}
+	start = min(startIndex-1, n)
+	return start, min(start+count, n), nil
+}
+
+func decodeSCIM(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return scimBadRequest("invalidSyntax", "invalid JSON body")
+	}
+	return nil
+}
+
+// --- Users ---
+
+// scimOrganizations returns the organizations the identity provider manages: SCIM_ORGANIZATION and those the SCIM
+// group mappings name. Mappings naming an unknown organization are skipped.
+func (a *scimAPI) scimOrganizations() (map[uuid.UUID]bool, error) {
+	if a.orgs != nil {
+		return a.orgs, nil
+	}
+	orgID, err := lookupOrganization(a.s, a.cfg.SCIM.Organization)
+	if err != nil {
+		return nil, fmt.Errorf("SCIM_ORGANIZATION: %w", err)
+	}
+	a.orgs = map[uuid.UUID]bool{orgID: true}
+	for _, m := range a.cfg.SCIM.GroupMappings {
+		if id, err := lookupOrganization(a.s, m.Organization); err == nil {
+			a.orgs[id] = true
+		}
+	}
+	return a.orgs, nil
+}
+
+// visible reports whether the identity provider may see and change u. The global admin and users of organizations
+// SCIM does not manage (local accounts elsewhere) are never exposed.
+func (a *scimAPI) visible(u *store.User) (bool, error) {
+	if auth.IsGlobalAdmin(u) {
+		return false, nil
+	}
+	orgs, err := a.scimOrganizations()
+	if err != nil {
+		return false, err
+	}
+	return orgs[u.OrganizationID], nil
+}
+
+// scimUserByID returns the visible user with id.
+func (a *scimAPI) scimUserByID(id string) (*store.User, error) {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errSCIMNotFound
+	}
+	u, err := a.s.GetUser(uid)
+	if err != nil {
+		return nil, errSCIMNotFound
+	}
+	ok, err := a.visible(u)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errSCIMNotFound
+	}
+	return u, nil
+}
+
+func (a *scimAPI) userResource(u *store.User, groups []*store.SCIMGroup) scimUser {
+	active := !u.Disabled
+	out := scimUser{
+		Schemas:    []string{scimUserSchema},
+		ID:         u.ID.String(),
+		ExternalID: u.ExternalID,
+		UserName:   u.Email,
+		Active:     &active,
+		Emails:     []scimEmail{{Value: u.Email, Type: "work", Primary: true}},
+		Meta:       &scimMeta{ResourceType: "User", Location: a.base + "/Users/" + u.ID.String()},
+	}
+	for _, g := range groups {
+		if slices.Contains(g.Members, u.ID) {
+			out.Groups = append(out.Groups, scimRef{Value: g.ID.String(), Display: g.DisplayName, Ref: a.base + "/Groups/" + g.ID.String()})
+		}
+	}
+	return out
+}
+
+func (a *scimAPI) writeUser(w http.ResponseWriter, status int, u *store.User) error {
+	groups, err := a.s.ListSCIMGroups()
+	if err != nil {
+		return err
+	}
+	res := a.userResource(u, groups)
+	if status == http.StatusCreated {
+		w.Header().Set("Location", res.Meta.Location)
+	}
+	writeSCIM(w, status, res)
+	return nil
+}
+
+func (a *scimAPI) listUsers(w http.ResponseWriter, r *http.Request) error {
+	attr, value, err := parseSCIMFilter(r.URL.Query().Get("filter"), "username", "externalid", "id", "emails.value")
+	if err != nil {
+		return err
+	}
+	users, err := a.s.ListUsers(nil)
+	if err != nil {
+		return err
+	}
+	groups, err := a.s.ListSCIMGroups()
+	if err != nil {
+		return err
+	}
+	var matched []*store.User
+	for _, u := range users {
+		ok, err := a.visible(u)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		switch attr {
+		case "username", "emails.value":
+			if !strings.EqualFold(u.Email, value) {
+				continue
+			}
+		case "externalid":
+			if u.ExternalID != value {
+				continue
+			}
+		case "id":
+			if u.ID.String() != strings.ToLower(value) {
+				continue
+			}
+		}
+		matched = append(matched, u)
+	}
+	start, end, err := scimPage(r, len(matched))
+	if err != nil {
+		return err
+	}
+	resources := make([]any, 0, end-start)
+	for _, u := range matched[start:end] {
+		resources = append(resources, a.userResource(u, groups))
+	}
+	writeSCIM(w, http.StatusOK, scimListResponse{
+		Schemas: []string{scimListSchema}, TotalResults: len(matched), StartIndex: start + 1, ItemsPerPage: len(resources), Resources: resources,
+	})
+	return nil
+}
+
+// scimUserChanges are the user attributes a request sets; nil fields are left alone.
+type scimUserChanges struct {
+	email      *string
+	externalID *string
+	active     *bool
+}
+
+func (c *scimUserChanges) setUserName(userName string) error {
+	email := strings.ToLower(strings.TrimSpace(userName))
+	if !validation.ValidateEmail(email) {
+		return scimBadRequest("invalidValue", "userName must be an email address")
+	}
+	c.email = &email
+	return nil
+}
+
+// apply saves the changes. Deactivating a user also revokes their sessions and API tokens.
+func (a *scimAPI) apply(u *store.User, c scimUserChanges) error {
+	if c.email != nil && !strings.EqualFold(*c.email, u.Email) {
+		if err := a.s.SetUserEmail(u.ID, *c.email); err != nil {
+			if strings.Contains(err.Error(), "already exists") {
+				return &scimError{status: http.StatusConflict, scimType: "uniqueness", detail: "userName is already taken"}
+			}
+			return err
+		}
+		u.Email = *c.email
+	}
+	if c.externalID != nil && *c.externalID != u.ExternalID {
+		if err := a.s.SetUserExternalID(u.ID, *c.externalID); err != nil {
+			return err
+		}
+		u.ExternalID = *c.externalID
+	}
+	if c.active != nil && *c.active == u.Disabled {
+		if err := a.s.SetUserDisabled(u.ID, !*c.active); err != nil {
+			return err
+		}
+		u.Disabled = !*c.active
+		logger.Info("scim: user active changed", logger.KeyUserID, u.ID.String(), logger.KeyEmail, u.Email, "active", *c.active)
+	}
+	return nil
+}
+
+func (a *scimAPI) createUser(w http.ResponseWriter, r *http.Request) error {
+	var in scimUser
+	if err := decodeSCIM(r, &in); err != nil {
+		return err
+	}
+	var c scimUserChanges
+	if err := c.setUserName(in.UserName); err != nil {
+		return err
+	}
+	if _, err := a.s.GetUserByEmail(*c.email); err == nil {
+		return &scimError{status: http.StatusConflict, scimType: "uniqueness", detail: "userName is already taken"}
+	}
+	orgID, err := lookupOrganization(a.s, a.cfg.SCIM.Organization)
+	if err != nil {
+		return fmt.Errorf("SCIM_ORGANIZATION: %w", err)
+	}
+	u := &store.User{
+		Email:          *c.email,
+		Role:           store.RoleUser,
+		OrganizationID: orgID,
+		ExternalID:     in.ExternalID,
+		Disabled:       in.Active != nil && !*in.Active,
+	}
+	if err := a.s.CreateUser(u); err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			return &scimError{status: http.StatusConflict, scimType: "uniqueness", detail: "userName is already taken"}
+		}
+		return err
+	}
+	logger.Info("scim: user created", logger.KeyUserID, u.ID.String(), logger.KeyEmail, u.Email)
+	return a.writeUser(w, http.StatusCreated, u)
+}
+
+func (a *scimAPI) user(w http.ResponseWriter, r *http.Request, id string) error {
+	u, err := a.scimUserByID(id)
+	if err != nil {
+		return err
+	}
+	switch r.Method {
+	case http.MethodGet:
+		return a.writeUser(w, http.StatusOK, u)
+	case http.MethodPut:
+		var in scimUser
+		if err := decodeSCIM(r, &in); err != nil {
+			return err
+		}
+		c := scimUserChanges{externalID: &in.ExternalID, active: in.Active}
+		if err := c.setUserName(in.UserName); err != nil {
+			return err
+		}
+		if err := a.apply(u, c); err != nil {
+			return err
+		}
+		return a.writeUser(w, http.StatusOK, u)
+	case http.MethodPatch:
+		c, err := parseSCIMUserPatch(r)
+		if err != nil {
+			return err
+		}
+		if err := a.apply(u, c); err != nil {
+			return err
+		}
+		return a.writeUser(w, http.StatusOK, u)
+	case http.MethodDelete:
+		if err := a.s.DeleteUser(u.ID); err != nil {
+			return err
+		}
+		logger.Info("scim: user deleted", logger.KeyUserID, u.ID.String(), logger.KeyEmail, u.Email)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return &scimError{status: http.StatusMethodNotAllowed, detail: "method not allowed"}
+}
+
+// scimBool reads a boolean that some identity providers (Entra ID) send as the string "True" or "False".
+func scimBool(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b, nil
+		}
+	}
+	return false, scimBadRequest("invalidValue", "active must be a boolean")
+}
+
+func scimString(raw json.RawMessage, attr string) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", scimBadRequest("invalidValue", "%s must be a string", attr)
+	}
+	return s, nil
+}
+
+// parseSCIMUserPatch reads a PatchOp for a user. Only userName, externalId and active are stored; operations on other
+// attributes (names, phone numbers, ...) are accepted and ignored.
+func parseSCIMUserPatch(r *http.Request) (scimUserChanges, error) {
+	var c scimUserChanges
+	var req scimPatchRequest
+	if err := decodeSCIM(r, &req); err != nil {
+		return c, err
+	}
+	set := func(attr string, op string, raw json.RawMessage) error {
+		switch strings.ToLower(attr) {
+		case "active":
+			if op == "remove" {
+				return nil
+			}
+			b, err := scimBool(raw)
+			if err != nil {
+				return err
+			}
+			c.active = &b
+		case "username":
+			if op == "remove" {
+				return scimBadRequest("mutability", "userName is required")
+			}
+			v, err := scimString(raw, "userName")
+			if err != nil {
+				return err
+			}
+			return c.setUserName(v)
+		case "externalid":
+			v := ""
+			if op != "remove" {
+				var err error
+				if v, err = scimString(raw, "externalId"); err != nil {
+					return err
+				}
+			}
+			c.externalID = &v
+		}
+		return nil
+	}
+	for _, o := range req.Operations {
+		op := strings.ToLower(o.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return c, scimBadRequest("invalidSyntax", "unknown op %q", o.Op)
+		}
+		if o.Path != "" {
+			if err := set(o.Path, op, o.Value); err != nil {
+				return c, err
+			}
+			continue
+		}
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(o.Value, &attrs); err != nil {
+			return c, scimBadRequest("invalidValue", "operation without path needs an object value")
+		}
+		for attr, raw := range attrs {
+			if err := set(attr, op, raw); err != nil {
+				return c, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// --- Groups ---
+
+func (a *scimAPI) groupResource(g *store.SCIMGroup, users map[uuid.UUID]*store.User, withMembers bool) scimGroup {
+	created, modified := g.CreatedAt, g.UpdatedAt
+	out := scimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          g.ID.String(),
+		ExternalID:  g.ExternalID,
+		DisplayName: g.DisplayName,
+		Meta:        &scimMeta{ResourceType: "Group", Created: &created, LastModified: &modified, Location: a.base + "/Groups/" + g.ID.String()},
+	}
+	if withMembers {
+		for _, id := range g.Members {
+			ref := scimRef{Value: id.String(), Ref: a.base + "/Users/" + id.String()}
+			if u := users[id]; u != nil {
+				ref.Display = u.Email
+			}
+			out.Members = append(out.Members, ref)
+		}
+	}
+	return out
+}
+
+func (a *scimAPI) usersByID() (map[uuid.UUID]*store.User, error) {
+	users, err := a.s.ListUsers(nil)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[uuid.UUID]*store.User, len(users))
+	for _, u := range users {
+		out[u.ID] = u
+	}
+	return out, nil
+}
+
+func (a *scimAPI) writeGroup(w http.ResponseWriter, r *http.Request, status int, g *store.SCIMGroup) error {
+	users, err := a.usersByID()
+	if err != nil {
+		return err
+	}
+	res := a.groupResource(g, users, !scimExcludesMembers(r))
+	if status == http.StatusCreated {
+		w.Header().Set("Location", res.Meta.Location)
+	}
+	writeSCIM(w, status, res)
+	return nil
+}
+
+// scimExcludesMembers reports whether the request asked to leave members out, as identity providers do to keep
+// responses for large groups small.
+func scimExcludesMembers(r *http.Request) bool {
+	for _, attr := range strings.Split(r.URL.Query().Get("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attr), "members") {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *scimAPI) listGroups(w http.ResponseWriter, r *http.Request) error {
+	attr, value, err := parseSCIMFilter(r.URL.Query().Get("filter"), "displayname", "externalid", "id")
+	if err != nil {
+		return err
+	}
+	groups, err := a.s.ListSCIMGroups()
+	if err != nil {
+		return err
+	}
+	var matched []*store.SCIMGroup
+	for _, g := range groups {
+		switch attr {
+		case "displayname":
+			if !strings.EqualFold(g.DisplayName, value) {
+				continue
+			}
+		case "externalid":
+			if g.ExternalID != value {
+				continue
+			}
+		case "id":
+			if g.ID.String() != strings.ToLower(value) {
+				continue
+			}
+		}
+		matched = append(matched, g)
+	}
+	start, end, err := scimPage(r, len(matched))
+	if err != nil {
+		return err
+	}
+	users, err := a.usersByID()
+	if err != nil {
+		return err
+	}
+	withMembers := !scimExcludesMembers(r)
+	resources := make([]any, 0, end-start)
+	for _, g := range matched[start:end] {
+		resources = append(resources, a.groupResource(g, users, withMembers))
+	}
+	writeSCIM(w, http.StatusOK, scimListResponse{
+		Schemas: []string{scimListSchema}, TotalResults: len(matched), StartIndex: start + 1, ItemsPerPage: len(resources), Resources: resources,
+	})
+	return nil
+}
+
+// scimMembers resolves member references to visible users, dropping repeats.
+func (a *scimAPI) scimMembers(refs []scimRef) ([]uuid.UUID, error) {
+	out := make([]uuid.UUID, 0, len(refs))
+	for _, ref := range refs {
+		u, err := a.scimUserByID(ref.Value)
+		if err != nil {
+			return nil, scimBadRequest("invalidValue", "unknown member %q", ref.Value)
+		}
+		if !slices.Contains(out, u.ID) {
+			out = append(out, u.ID)
+		}
+	}
+	return out, nil
+}
+
+// saveGroup creates g (when create) or replaces it, then re-applies the group mappings to its old and new members.
+func (a *scimAPI) saveGroup(g *store.SCIMGroup, create bool, previousMembers []uuid.UUID) error {
+	if strings.TrimSpace(g.DisplayName) == "" {
+		return scimBadRequest("invalidValue", "displayName is required")
+	}
+	before, err := a.s.ListSCIMGroups()
+	if err != nil {
+		return err
+	}
+	if create {
+		err = a.s.CreateSCIMGroup(g)
+	} else {
+		err = a.s.UpdateSCIMGroup(g.ID, g)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			return &scimError{status: http.StatusConflict, scimType: "uniqueness", detail: "displayName is already taken"}
+		}
+		if strings.Contains(err.Error(), "user not found") {
+			return scimBadRequest("invalidValue", "unknown member")
+		}
+		return err
+	}
+	// Re-sync old and new members alike: a rename can start or stop matching a mapping for everyone in the group.
+	changed := slices.Clone(g.Members)
+	for _, id := range previousMembers {
+		if !slices.Contains(changed, id) {
+			changed = append(changed, id)
+		}
+	}
+	return syncSCIMAccess(a.s, a.cfg.SCIM.GroupMappings, before, changed)
+}
+
+func (a *scimAPI) createGroup(w http.ResponseWriter, r *http.Request) error {
+	var in scimGroup
+	if err := decodeSCIM(r, &in); err != nil {
+		return err
+	}
+	members, err := a.scimMembers(in.Members)
+	if err != nil {
+		return err
+	}
+	g := &store.SCIMGroup{DisplayName: strings.TrimSpace(in.DisplayName), ExternalID: in.ExternalID, Members: members}
+	if err := a.saveGroup(g, true, nil); err != nil {
+		return err
+	}
+	logger.Info("scim: group created", "group", g.DisplayName, "members", len(g.Members))
+	return a.writeGroup(w, r, http.StatusCreated, g)
+}
+
+func (a *scimAPI) group(w http.ResponseWriter, r *http.Request, id string) error {
+	gid, err := uuid.Parse(id)
+	if err != nil {
+		return errSCIMNotFound
+	}
+	existing, err := a.s.GetSCIMGroup(gid)
+	if err != nil {
+		return errSCIMNotFound
+	}
+	switch r.Method {
+	case http.MethodGet:
+		return a.writeGroup(w, r, http.StatusOK, existing)
+	case http.MethodPut:
+		var in scimGroup
+		if err := decodeSCIM(r, &in); err != nil {
+			return err
+		}
+		members, err := a.scimMembers(in.Members)
+		if err != nil {
+			return err
+		}
+		g := &store.SCIMGroup{ID: gid, DisplayName: strings.TrimSpace(in.DisplayName), ExternalID: in.ExternalID, Members: members}
+		if err := a.saveGroup(g, false, existing.Members); err != nil {
+			return err
+		}
+		return a.writeGroup(w, r, http.StatusOK, g)
+	case http.MethodPatch:
+		g := &store.SCIMGroup{ID: gid, DisplayName: existing.DisplayName, ExternalID: existing.ExternalID, Members: slices.Clone(existing.Members)}
+		if err := a.patchGroup(r, g); err != nil {
+			return err
+		}
+		if err := a.saveGroup(g, false, existing.Members); err != nil {
+			return err
+		}
+		// RFC 7644 allows 204 for PATCH; identity providers are happy either way, and the body helps debugging.
+		return a.writeGroup(w, r, http.StatusOK, g)
+	case http.MethodDelete:
+		before, err := a.s.ListSCIMGroups()
+		if err != nil {
+			return err
+		}
+		if err := a.s.DeleteSCIMGroup(gid); err != nil {
+			return err
+		}
+		if err := syncSCIMAccess(a.s, a.cfg.SCIM.GroupMappings, before, existing.Members); err != nil {
+			return err
+		}
+		logger.Info("scim: group deleted", "group", existing.DisplayName)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return &scimError{status: http.StatusMethodNotAllowed, detail: "method not allowed"}
+}
+
+// scimMemberPathPattern matches members[value eq "<id>"], the path identity providers use to remove one member.
+var scimMemberPathPattern = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+"([^"]+)"\s*\]$`)
+
+// patchGroup applies a PatchOp to g in memory.
+func (a *scimAPI) patchGroup(r *http.Request, g *store.SCIMGroup) error {
+	var req scimPatchRequest
+	if err := decodeSCIM(r, &req); err != nil {
+		return err
+	}
+	members := func(raw json.RawMessage) ([]uuid.UUID, error) {
+		var refs []scimRef
+		if len(raw) == 0 || string(raw) == "null" {
+			return nil, nil
+		}
+		if err := json.Unmarshal(raw, &refs); err != nil {
+			return nil, scimBadRequest("invalidValue", "members must be a list of {\"value\": id}")
+		}
+		return a.scimMembers(refs)
+	}
+	set := func(op, path string, raw json.RawMessage) error {
+		if m := scimMemberPathPattern.FindStringSubmatch(path); m != nil && op == "remove" {
+			g.Members = slices.DeleteFunc(g.Members, func(id uuid.UUID) bool { return strings.EqualFold(id.String(), m[1]) })
+			return nil
+		}
+		switch strings.ToLower(path) {
+		case "members":
+			ids, err := members(raw)
+			if err != nil {
+				return err
+			}
+			switch op {
+			case "add":
+				for _, id := range ids {
+					if !slices.Contains(g.Members, id) {
+						g.Members = append(g.Members, id)
+					}
+				}
+			case "replace":
+				g.Members = ids
+			case "remove":
+				if ids == nil {
+					g.Members = nil
+				} else {
+					g.Members = slices.DeleteFunc(g.Members, func(id uuid.UUID) bool { return slices.Contains(ids, id) })
+				}
+			}
+		case "displayname":
+			if op == "remove" {
+				return scimBadRequest("mutability", "displayName is required")
+			}
+			v, err := scimString(raw, "displayName")
+			if err != nil {
+				return err
+			}
+			g.DisplayName = strings.TrimSpace(v)
+		case "externalid":
+			v := ""
+			if op != "remove" {
+				var err error
+				if v, err = scimString(raw, "externalId"); err != nil {
+					return err
+				}
+			}
+			g.ExternalID = v
+		default:
+			return scimBadRequest("invalidPath", "unsupported path %q", path)
+		}
+		return nil
+	}
+	for _, o := range req.Operations {
+		op := strings.ToLower(o.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return scimBadRequest("invalidSyntax", "unknown op %q", o.Op)
+		}
+		if o.Path != "" {
+			if err := set(op, o.Path, o.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(o.Value, &attrs); err != nil {
+			return scimBadRequest("invalidValue", "operation without path needs an object value")
+		}
+		for attr, raw := range attrs {
+			if err := set(op, attr, raw); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scimGroupNames returns the display names of the groups in groups that userID belongs to.
+func scimGroupNames(groups []*store.SCIMGroup, userID uuid.UUID) []string {
+	var names []string
+	for _, g := range groups {
+		if slices.Contains(g.Members, userID) {
+			names = append(names, g.DisplayName)
+		}
+	}
+	return names
+}
+
+// syncSCIMAccess re-applies the SCIM group mappings to userIDs from their current group memberships; before is the
+// group list from ahead of the change. A user who matched a mapping before and matches none now has left their last
+// mapped group and is deactivated, which also revokes their sessions and API tokens. Users who matched no mapping
+// before keep the access an admin gave them.
+func syncSCIMAccess(s store.Storer, mappings []config.GroupMapping, before []*store.SCIMGroup, userIDs []uuid.UUID) error {
+	if len(mappings) == 0 || len(userIDs) == 0 {
+		return nil
+	}
+	groups, err := s.ListSCIMGroups()
+	if err != nil {
+		return err
+	}
+	for _, id := range userIDs {
+		user, err := s.GetUser(id)
+		if err != nil {
+			continue // deleted meanwhile
+		}
+		access, err := resolveGroupMappings(s, mappings, scimGroupNames(groups, id))
+		if errors.Is(err, errNoGroupAccess) {
+			if _, err := resolveGroupMappings(s, mappings, scimGroupNames(before, id)); err != nil || user.Disabled || auth.IsGlobalAdmin(user) {
+				continue
+			}
+			if err := s.SetUserDisabled(id, true); err != nil {
+				return err
+			}
+			logger.Info("scim: user deactivated: left last mapped group", logger.KeyUserID, id.String(), logger.KeyEmail, user.Email)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := applyGroupAccess(s, user, access); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupOrganization returns the id of the organization named ref, or with id ref.
+func lookupOrganization(s store.Storer, ref string) (uuid.UUID, error) {
+	ref = strings.TrimSpace(ref)
+	if id, err := uuid.Parse(ref); err == nil {
+		if _, err := s.GetOrganization(id); err != nil {
+			return uuid.Nil, fmt.Errorf("unknown organization %q", ref)
+		}
+		return id, nil
+	}
+	orgs, err := s.ListOrganizations()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	for _, o := range orgs {
+		if strings.EqualFold(o.Name, ref) {
+			return o.ID, nil
+		}
+	}
+	return uuid.Nil, fmt.Errorf("unknown organization %q", ref)
+}
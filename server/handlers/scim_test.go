@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/swaggest/usecase/status"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testSCIMToken = "0123456789abcdef0123456789abcdef"
+
+type scimClient struct {
+	t *testing.T
+	h http.Handler
+}
+
+// do sends a SCIM request and decodes the JSON response into out (when non-nil).
+func (c scimClient) do(method, path, body string, out any) int {
+	c.t.Helper()
+	req := httptest.NewRequest(method, "/scim/v2"+path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testSCIMToken)
+	req.Header.Set("Content-Type", scimContentType)
+	rec := httptest.NewRecorder()
+	c.h.ServeHTTP(rec, req)
+	if out != nil && rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			c.t.Fatalf("%s %s: decode %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func newSCIMTest(t *testing.T, mappings string) (*store.Store, *store.Organization, scimClient) {
+	t.Helper()
+	s := store.NewStore()
+	acme := &store.Organization{Name: "Acme"}
+	if err := s.CreateOrganization(acme); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateUser(&store.User{Email: "root@example.org", Role: store.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{SCIM: config.SCIMConfig{
+		Token:         testSCIMToken,
+		Organization:  "acme",
+		GroupMappings: testGroupMappings(t, mappings),
+	}}
+	return s, acme, scimClient{t: t, h: SCIMHandler(s, cfg)}
+}
+
+func TestSCIM_Unauthorized(t *testing.T) {
+	_, _, c := newSCIMTest(t, "")
+	for _, header := range []string{"", "Bearer wrong-token", "Basic " + testSCIMToken} {
+		req := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		c.h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Authorization %q: status = %d, want 401 with WWW-Authenticate", header, rec.Code)
+		}
+	}
+}
+
+func TestSCIM_Users(t *testing.T) {
+	s, acme, c := newSCIMTest(t, "")
+
+	var created scimUser
+	if code := c.do(http.MethodPost, "/Users", `{"schemas":["`+scimUserSchema+`"],"userName":"Alice@Example.org","externalId":"00u1","active":true}`, &created); code != http.StatusCreated {
+		t.Fatalf("create: status = %d", code)
+	}
+	if created.UserName != "alice@example.org" || created.ExternalID != "00u1" || created.Active == nil || !*created.Active {
+		t.Errorf("created = %+v", created)
+	}
+	alice, err := s.GetUserByEmail("alice@example.org")
+	if err != nil || alice.OrganizationID != acme.ID || alice.Role != store.RoleUser || alice.ID.String() != created.ID {
+		t.Fatalf("stored user = %+v, %v; want user in Acme", alice, err)
+	}
+	if code := c.do(http.MethodPost, "/Users", `{"userName":"alice@example.org"}`, nil); code != http.StatusConflict {
+		t.Errorf("duplicate create: status = %d, want 409", code)
+	}
+	if code := c.do(http.MethodPost, "/Users", `{"userName":"not-an-email"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("invalid userName: status = %d, want 400", code)
+	}
+
+	// The global admin is not visible to the identity provider.
+	var list scimListResponse
+	if code := c.do(http.MethodGet, "/Users", "", &list); code != http.StatusOK || list.TotalResults != 1 {
+		t.Errorf("list: status = %d, total = %d, want 1", code, list.TotalResults)
+	}
+	if c.do(http.MethodGet, `/Users?filter=userName+eq+"ALICE@example.org"`, "", &list); list.TotalResults != 1 {
+		t.Errorf("filter by userName: total = %d, want 1", list.TotalResults)
+	}
+	if c.do(http.MethodGet, `/Users?filter=userName+eq+"root@example.org"`, "", &list); list.TotalResults != 0 {
+		t.Errorf("filter for global admin: total = %d, want 0", list.TotalResults)
+	}
+	if code := c.do(http.MethodGet, `/Users?filter=name.givenName+sw+"A"`, "", nil); code != http.StatusBadRequest {
+		t.Errorf("unsupported filter: status = %d, want 400", code)
+	}
+
+	// Deactivation signs the user out everywhere and keeps them out.
+	s.CreateSession("alice-session", alice.ID, time.Now().Add(time.Hour))
+	_, rawToken, err := s.CreateAPIToken(alice.ID, "cli", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patched scimUser
+	body := `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"Replace","value":{"active":"False"}}]}`
+	if code := c.do(http.MethodPatch, "/Users/"+created.ID, body, &patched); code != http.StatusOK || patched.Active == nil || *patched.Active {
+		t.Fatalf("deactivate: status = %d, user = %+v", code, patched)
+	}
+	if _, err := s.GetSession("alice-session"); err == nil {
+		t.Error("session survived deactivation")
+	}
+	if toks, _ := s.ListAPITokens(alice.ID); len(toks) != 0 {
+		t.Errorf("tokens after deactivation = %d, want 0", len(toks))
+	}
+	protected := auth.Middleware(s, config.SessionConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	s.CreateSession("late-session", alice.ID, time.Now().Add(time.Hour))
+	req := httptest.NewRequest(http.MethodGet, "/api/blocks", nil)
+	req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: "late-session"})
+	rec := httptest.NewRecorder()
+	protected.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("disabled user's session: status = %d, want 401", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/blocks", nil)
+	req.Header.Set("Authorization", "Bearer "+rawToken)
+	rec = httptest.NewRecorder()
+	protected.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: status = %d, want 401", rec.Code)
+	}
+
+	// PUT replaces userName and reactivates.
+	var put scimUser
+	if code := c.do(http.MethodPut, "/Users/"+created.ID, `{"userName":"alice.smith@example.org","externalId":"00u1","active":true}`, &put); code != http.StatusOK {
+		t.Fatalf("put: status = %d", code)
+	}
+	if u, _ := s.GetUser(alice.ID); u.Email != "alice.smith@example.org" || u.Disabled {
+		t.Errorf("after put = %+v", u)
+	}
+
+	if code := c.do(http.MethodDelete, "/Users/"+created.ID, "", nil); code != http.StatusNoContent {
+		t.Errorf("delete: status = %d, want 204", code)
+	}
+	if code := c.do(http.MethodGet, "/Users/"+created.ID, "", nil); code != http.StatusNotFound {
+		t.Errorf("get deleted: status = %d, want 404", code)
+	}
+}
+
+func TestSCIM_DisabledUserCannotLogIn(t *testing.T) {
+	s := store.NewStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("alice-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := &store.User{Email: "alice@example.org", PasswordHash: string(hash), Role: store.RoleUser, Disabled: true}
+	if err := s.CreateUser(alice); err != nil {
+		t.Fatal(err)
+	}
+	if _, cookie, err := passwordLogin(t, s, &config.Config{}, nil, "alice@example.org", "alice-password"); !errors.Is(err, status.PermissionDenied) || cookie != nil {
+		t.Errorf("disabled login: err = %v, cookie = %v", err, cookie)
+	}
+}
+
+func TestSCIM_GroupsMapToOrganizationAndRole(t *testing.T) {
+	s, acme, c := newSCIMTest(t, "ipam-admins=acme:admin,ipam-users=acme:user")
+	var alice scimUser
+	if code := c.do(http.MethodPost, "/Users", `{"userName":"alice@example.org"}`, &alice); code != http.StatusCreated {
+		t.Fatalf("create user: status = %d", code)
+	}
+	var group scimGroup
+	body := `{"schemas":["` + scimGroupSchema + `"],"displayName":"ipam-admins","members":[{"value":"` + alice.ID + `"}]}`
+	if code := c.do(http.MethodPost, "/Groups", body, &group); code != http.StatusCreated {
+		t.Fatalf("create group: status = %d", code)
+	}
+	role := func() string {
+		t.Helper()
+		u, err := s.GetUserByEmail("alice@example.org")
+		if err != nil || u.OrganizationID != acme.ID {
+			t.Fatalf("user = %+v, %v; want in Acme", u, err)
+		}
+		return u.Role
+	}
+	if got := role(); got != store.RoleAdmin {
+		t.Errorf("role after joining ipam-admins = %q, want admin", got)
+	}
+	if len(group.Members) != 1 || group.Members[0].Display != "alice@example.org" {
+		t.Errorf("group members = %+v", group.Members)
+	}
+
+	var users scimGroup
+	if code := c.do(http.MethodPost, "/Groups", `{"displayName":"ipam-users","members":[{"value":"`+alice.ID+`"}]}`, &users); code != http.StatusCreated {
+		t.Fatalf("create ipam-users: status = %d", code)
+	}
+	patch := `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"remove","path":"members[value eq \"` + alice.ID + `\"]"}]}`
+	if code := c.do(http.MethodPatch, "/Groups/"+group.ID, patch, nil); code != http.StatusOK {
+		t.Fatalf("remove member: status = %d", code)
+	}
+	if got := role(); got != store.RoleUser {
+		t.Errorf("role after leaving ipam-admins = %q, want user", got)
+	}
+
+	// Adding a member twice keeps one membership.
+	add := `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"add","path":"members","value":[{"value":"` + alice.ID + `"},{"value":"` + alice.ID + `"}]}]}`
+	var readded scimGroup
+	if code := c.do(http.MethodPatch, "/Groups/"+users.ID, add, &readded); code != http.StatusOK || len(readded.Members) != 1 {
+		t.Errorf("add existing member: status = %d, members = %+v, want 1", code, readded.Members)
+	}
+
+	var list scimListResponse
+	if c.do(http.MethodGet, `/Groups?filter=displayName+eq+"ipam-users"&excludedAttributes=members`, "", &list); list.TotalResults != 1 {
+		t.Fatalf("filter groups: total = %d, want 1", list.TotalResults)
+	}
+	if res, _ := list.Resources[0].(map[string]any); res["members"] != nil {
+		t.Errorf("members returned despite excludedAttributes: %v", res["members"])
+	}
+	if code := c.do(http.MethodPost, "/Groups", `{"displayName":"IPAM-Users"}`, nil); code != http.StatusConflict {
+		t.Errorf("duplicate group: status = %d, want 409", code)
+	}
+	if code := c.do(http.MethodPost, "/Groups", `{"displayName":"x","members":[{"value":"not-a-user"}]}`, nil); code != http.StatusBadRequest {
+		t.Errorf("unknown member: status = %d, want 400", code)
+	}
+	// Leaving the last mapped group deactivates the user and revokes their sessions.
+	u, _ := s.GetUserByEmail("alice@example.org")
+	s.CreateSession("alice-session", u.ID, time.Now().Add(time.Hour))
+	if code := c.do(http.MethodDelete, "/Groups/"+users.ID, "", nil); code != http.StatusNoContent {
+		t.Errorf("delete group: status = %d, want 204", code)
+	}
+	if u, _ := s.GetUserByEmail("alice@example.org"); !u.Disabled {
+		t.Error("user still active after leaving the last mapped group")
+	}
+	if _, err := s.GetSession("alice-session"); err == nil {
+		t.Error("session survived losing group access")
+	}
+}
+
+func TestSCIM_UnmappedGroupKeepsAccess(t *testing.T) {
+	s, _, c := newSCIMTest(t, "ipam-admins=acme:admin")
+	var alice scimUser
+	if code := c.do(http.MethodPost, "/Users", `{"userName":"alice@example.org"}`, &alice); code != http.StatusCreated {
+		t.Fatalf("create user: status = %d", code)
+	}
+	var group scimGroup
+	if code := c.do(http.MethodPost, "/Groups", `{"displayName":"everyone","members":[{"value":"`+alice.ID+`"}]}`, &group); code != http.StatusCreated {
+		t.Fatalf("create group: status = %d", code)
+	}
+	if code := c.do(http.MethodDelete, "/Groups/"+group.ID, "", nil); code != http.StatusNoContent {
+		t.Fatalf("delete group: status = %d", code)
+	}
+	if u, _ := s.GetUserByEmail("alice@example.org"); u.Disabled || u.Role != store.RoleUser {
+		t.Errorf("user never in a mapped group = %+v, want active user", u)
+	}
+}
+
+func TestSCIM_UsersOutsideManagedOrganizations(t *testing.T) {
+	s, _, c := newSCIMTest(t, "")
+	other := &store.Organization{Name: "Other"}
+	if err := s.CreateOrganization(other); err != nil {
+		t.Fatal(err)
+	}
+	bob := &store.User{Email: "bob@example.org", PasswordHash: "local", Role: store.RoleAdmin, OrganizationID: other.ID}
+	if err := s.CreateUser(bob); err != nil {
+		t.Fatal(err)
+	}
+	id := bob.ID.String()
+	for _, req := range []struct{ method, body string }{
+		{http.MethodGet, ""},
+		{http.MethodPut, `{"userName":"attacker@example.org","active":true}`},
+		{http.MethodPatch, `{"Operations":[{"op":"replace","value":{"active":false}}]}`},
+		{http.MethodDelete, ""},
+	} {
+		if code := c.do(req.method, "/Users/"+id, req.body, nil); code != http.StatusNotFound {
+			t.Errorf("%s user in another organization: status = %d, want 404", req.method, code)
+		}
+	}
+	var list scimListResponse
+	if c.do(http.MethodGet, "/Users", "", &list); list.TotalResults != 0 {
+		t.Errorf("list: total = %d, want 0", list.TotalResults)
+	}
+	if code := c.do(http.MethodPost, "/Groups", `{"displayName":"x","members":[{"value":"`+id+`"}]}`, nil); code != http.StatusBadRequest {
+		t.Errorf("group with member in another organization: status = %d, want 400", code)
+	}
+	if u, err := s.GetUser(bob.ID); err != nil || u.Email != "bob@example.org" || u.Disabled {
+		t.Errorf("user in another organization changed: %+v, %v", u, err)
+	}
+}
@@ -9,12 +9,17 @@ import (
 	"strings"
 )
 
+// servedByAPI reports whether path is handled by the API server rather than the SPA: the API, its docs and SCIM.
+func servedByAPI(path string) bool {
+	return strings.HasPrefix(path, "/api") || strings.HasPrefix(path, "/docs") || strings.HasPrefix(path, "/scim/")
+}
+
 // Unauthorized returns 401 Unauthorized with a simple HTML body for non-API, non-docs requests (used when APP_ORIGIN is set so the app is served from another origin).
 func Unauthorized(appOrigin string, next http.Handler) http.Handler {
 	origin := strings.TrimSuffix(appOrigin, "/")
 	body := "<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Unauthorized</title></head><body><h1>Unauthorized</h1><p>This is the API server. Use the app at <a href=\"" + origin + "\">" + origin + "</a>.</p></body></html>"
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasPrefix(r.URL.Path, "/api") || strings.HasPrefix(r.URL.Path, "/docs") {
+		if servedByAPI(r.URL.Path) {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -27,7 +32,7 @@ func Unauthorized(appOrigin string, next http.Handler) http.Handler {
 // Static serves API/docs from next, everything else from dir (SPA fallback to index.html).
 func Static(dir string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasPrefix(r.URL.Path, "/api") || strings.HasPrefix(r.URL.Path, "/docs") {
+		if servedByAPI(r.URL.Path) {
 			next.ServeHTTP(w, r)
 			return
 		}
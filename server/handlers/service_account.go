@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/JakeNeyer/ipam/internal/logger"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+// defaultTokenGracePeriod is how long a rotated service account token keeps working when the request does not say.
+const defaultTokenGracePeriod = 24 * time.Hour
+
+// maxTokenGracePeriodHours caps how long a rotated token may overlap with its replacement.
+const maxTokenGracePeriodHours = 720
+
+type serviceAccountResponse struct {
+	ID             string `json:"id"`
+	OrganizationID string `json:"organization_id"`
+	Name           string `json:"name"`
+	Description    string `json:"description,omitempty"`
+	Role           string `json:"role"`
+	Disabled       bool   `json:"disabled"`
+	CreatedAt      string `json:"created_at"`
+}
+
+func serviceAccountToResponse(sa *store.ServiceAccount) serviceAccountResponse {
+	return serviceAccountResponse{
+		ID:             sa.ID.String(),
+		OrganizationID: sa.OrganizationID.String(),
+		Name:           sa.Name,
+		Description:    sa.Description,
+		Role:           sa.Role,
+		Disabled:       sa.Disabled,
+		CreatedAt:      sa.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// createServiceAccountInput is the body for POST /api/admin/service-accounts.
+type createServiceAccountInput struct {
+	Name           string    `json:"name"`
+	Description    string    `json:"description,omitempty"`
+	Role           string    `json:"role,omitempty"`            // user (default) or admin
+	OrganizationID uuid.UUID `json:"organization_id,omitempty"` // global admin only; org admins create in their own org
+}
+
+// updateServiceAccountInput is the body for PUT /api/admin/service-accounts/{id}.
+type updateServiceAccountInput struct {
+	ID          uuid.UUID `path:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Role        string    `json:"role"`
+	Disabled    bool      `json:"disabled"`
+}
+
+type serviceAccountPathInput struct {
+	ID uuid.UUID `path:"id"`
+}
+
+type serviceAccountOutput struct {
+	ServiceAccount serviceAccountResponse `json:"service_account"`
+}
+
+type listServiceAccountsOutput struct {
+	ServiceAccounts []serviceAccountResponse `json:"service_accounts"`
+}
+
+// createServiceAccountTokenInput is the body for POST /api/admin/service-accounts/{id}/tokens.
+type createServiceAccountTokenInput struct {
+	ID        uuid.UUID `path:"id"`
+	Name      string    `json:"name"`
+	ExpiresAt *string   `json:"expires_at,omitempty"`
+}
+
+// rotateServiceAccountTokenInput is the body for POST /api/admin/service-accounts/{id}/tokens/{tokenId}/rotate.
+type rotateServiceAccountTokenInput struct {
+	ID      uuid.UUID `path:"id"`
+	TokenID uuid.UUID `path:"tokenId"`
+	// GracePeriodHours is how long the old token keeps working next to the new one (default 24, 0 revokes it now).
+	GracePeriodHours *int    `json:"grace_period_hours,omitempty"`
+	ExpiresAt        *string `json:"expires_at,omitempty"` // expiry of the new token; default never
+}
+
+type serviceAccountTokenPathInput struct {
+	ID      uuid.UUID `path:"id"`
+	TokenID uuid.UUID `path:"tokenId"`
+}
+
+// rotateServiceAccountTokenOutput is the new token (raw value shown once) and the old one with its shortened expiry.
+type rotateServiceAccountTokenOutput struct {
+	Token    createTokenResponse `json:"token"`
+	Previous apiTokenResponse    `json:"previous"`
+}
+
+// requireServiceAccountAdmin returns the requesting admin. Service accounts never manage service accounts, even
+// with the admin role.
+func requireServiceAccountAdmin(ctx context.Context) (*store.User, error) {
+	user := auth.UserFromContext(ctx)
+	if user == nil {
+		return nil, status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
+	}
+	if user.Role != store.RoleAdmin || auth.ServiceAccountFromContext(ctx) != nil {
+		return nil, status.Wrap(errors.New("only admins can manage service accounts"), status.PermissionDenied)
+	}
+	return user, nil
+}
+
+// loadServiceAccount returns the service account if the requesting admin may manage it: org admins only see their
+// own organization's accounts.
+func loadServiceAccount(ctx context.Context, s store.Storer, id uuid.UUID) (*store.ServiceAccount, *store.User, error) {
+	user, err := requireServiceAccountAdmin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	sa, err := s.GetServiceAccount(id)
+	if err != nil || (!auth.IsGlobalAdminRequest(ctx, user) && sa.OrganizationID != auth.UserOrgForAccess(ctx, user)) {
+		return nil, nil, status.Wrap(errors.New("service account not found"), status.NotFound)
+	}
+	return sa, user, nil
+}
+
+// serviceAccountStoreErr maps store errors on service account writes to API status codes.
+func serviceAccountStoreErr(err error) error {
+	switch err.Error() {
+	case "service account name already exists":
+		return status.Wrap(err, status.AlreadyExists)
+	case "service account not found", "token not found", "organization not found":
+		return status.Wrap(err, status.NotFound)
+	case "name is required", "invalid role", "organization is required":
+		return status.Wrap(err, status.InvalidArgument)
+	}
+	return status.Wrap(err, status.Internal)
+}
+
+// parseTokenExpiry parses an optional RFC3339 expiry that must lie in the future.
+func parseTokenExpiry(raw *string) (*time.Time, error) {
+	if raw == nil || *raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, *raw)
+	if err != nil {
+		return nil, status.Wrap(errors.New("expires_at must be RFC3339"), status.InvalidArgument)
+	}
+	if t.Before(time.Now()) {
+		return nil, status.Wrap(errors.New("expires_at must be in the future"), status.InvalidArgument)
+	}
+	return &t, nil
+}
+
+func tokenToResponse(t *store.APIToken) apiTokenResponse {
+	resp := apiTokenResponse{ID: t.ID.String(), Name: t.Name, CreatedAt: t.CreatedAt.Format(time.RFC3339)}
+	if t.ExpiresAt != nil {
+		s := t.ExpiresAt.Format(time.RFC3339)
+		resp.ExpiresAt = &s
+	}
+	if t.OrganizationID != uuid.Nil {
+		resp.OrganizationID = t.OrganizationID.String()
+	}
+	return resp
+}
+
+func newTokenToResponse(t *store.APIToken, rawToken string) createTokenResponse {
+	resp := tokenToResponse(t)
+	return createTokenResponse{
+		ID:             resp.ID,
+		Name:           resp.Name,
+		Token:          rawToken,
+		CreatedAt:      resp.CreatedAt,
+		ExpiresAt:      resp.ExpiresAt,
+		OrganizationID: resp.OrganizationID,
+	}
+}
+
+// NewListServiceAccountsUseCase returns a use case for GET /api/admin/service-accounts.
+func NewListServiceAccountsUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input struct {
+		OrganizationID uuid.UUID `query:"organization_id"`
+	}, output *listServiceAccountsOutput) error {
+		user, err := requireServiceAccountAdmin(ctx)
+		if err != nil {
+			return err
+		}
+		list, err := s.ListServiceAccounts(auth.ResolveOrgID(ctx, user, input.OrganizationID))
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		output.ServiceAccounts = make([]serviceAccountResponse, 0, len(list))
+		for _, sa := range list {
+			output.ServiceAccounts = append(output.ServiceAccounts, serviceAccountToResponse(sa))
+		}
+		return nil
+	})
+	u.SetTitle("List service accounts")
+	u.SetDescription("List the organization's service accounts. Global admins see every organization unless organization_id is set")
+	u.SetExpectedErrors(status.Unauthenticated, status.PermissionDenied, status.Internal)
+	return u
+}
+
+// NewCreateServiceAccountUseCase returns a use case for POST /api/admin/service-accounts.
+func NewCreateServiceAccountUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input createServiceAccountInput, output *serviceAccountOutput) error {
+		user, err := requireServiceAccountAdmin(ctx)
+		if err != nil {
+			return err
+		}
+		orgID := auth.UserOrgForAccess(ctx, user)
+		if auth.IsGlobalAdminRequest(ctx, user) {
+			orgID = input.OrganizationID
+		}
+		if orgID == uuid.Nil {
+			return status.Wrap(errors.New("organization_id is required"), status.InvalidArgument)
+		}
+		role := strings.TrimSpace(strings.ToLower(input.Role))
+		if role == "" {
+			role = store.RoleUser
+		}
+		sa := &store.ServiceAccount{
+			OrganizationID: orgID,
+			Name:           input.Name,
+			Description:    strings.TrimSpace(input.Description),
+			Role:           role,
+		}
+		if err := s.CreateServiceAccount(sa); err != nil {
+			return serviceAccountStoreErr(err)
+		}
+		logger.Info("service account created", logger.KeyOperation, "service_account", "service_account_id", sa.ID.String(), "name", sa.Name, "admin", user.Email)
+		output.ServiceAccount = serviceAccountToResponse(sa)
+		return nil
+	})
+	u.SetTitle("Create service account")
+	u.SetDescription("Create an organization-owned service account. It has a role but no password and authenticates only with its API tokens")
+	u.SetExpectedErrors(status.Unauthenticated, status.PermissionDenied, status.InvalidArgument, status.AlreadyExists, status.NotFound, status.Internal)
+	return u
+}
+
+// NewUpdateServiceAccountUseCase returns a use case for PUT /api/admin/service-accounts/{id}. Unlike a user's, a
+// service account's tokens survive role changes; disable the account to stop them working.
+func NewUpdateServiceAccountUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input updateServiceAccountInput, output *serviceAccountOutput) error {
+		sa, user, err := loadServiceAccount(ctx, s, input.ID)
+		if err != nil {
+			return err
+		}
+		updated := *sa
+		updated.Name = input.Name
+		updated.Description = strings.TrimSpace(input.Description)
+		updated.Role = strings.TrimSpace(strings.ToLower(input.Role))
+		updated.Disabled = input.Disabled
+		if err := s.UpdateServiceAccount(&updated); err != nil {
+			return serviceAccountStoreErr(err)
+		}
+		logger.Info("service account updated", logger.KeyOperation, "service_account", "service_account_id", sa.ID.String(), "name", updated.Name, "admin", user.Email)
+		output.ServiceAccount = serviceAccountToResponse(&updated)
+		return nil
+	})
+	u.SetTitle("Update service account")
+	u.SetDescription("Rename, change the role of, or disable a service account. Disabled accounts' tokens are rejected")
+	u.SetExpectedErrors(status.Unauthenticated, status.PermissionDenied, status.InvalidArgument, status.AlreadyExists, status.NotFound, status.Internal)
+	return u
+}
+
+// NewDeleteServiceAccountUseCase returns a use case for DELETE /api/admin/service-accounts/{id}.
+func NewDeleteServiceAccountUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input serviceAccountPathInput, _ *struct{}) error {
+		sa, user, err := loadServiceAccount(ctx, s, input.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.DeleteServiceAccount(sa.ID); err != nil {
+			return serviceAccountStoreErr(err)
+		}
+		logger.Info("service account deleted", logger.KeyOperation, "service_account", "service_account_id", sa.ID.String(), "name", sa.Name, "admin", user.Email)
+		return nil
+	})
+	u.SetTitle("Delete service account")
+	u.SetDescription("Delete a service account and all of its tokens")
+	u.SetExpectedErrors(status.Unauthenticated, status.PermissionDenied, status.NotFound, status.Internal)
+	return u
+}
+
+// NewListServiceAccountTokensUseCase returns a use case for GET /api/admin/service-accounts/{id}/tokens.
+func NewListServiceAccountTokensUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input serviceAccountPathInput, output *listTokensOutput) error {
+		sa, _, err := loadServiceAccount(ctx, s, input.ID)
+		if err != nil {
+			return err
+		}
+		tokens, err := s.ListServiceAccountTokens(sa.ID)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		output.Tokens = make([]apiTokenResponse, 0, len(tokens))
+		for _, t := range tokens {
+			output.Tokens = append(output.Tokens, tokenToResponse(t))
+		}
+		return nil
+	})
+	u.SetTitle("List service account tokens")
+	u.SetDescription("List a service account's API tokens, including expired ones")
+	u.SetExpectedErrors(status.Unauthenticated, status.PermissionDenied, status.NotFound, status.Internal)
+	return u
+}
+
+// NewCreateServiceAccountTokenUseCase returns a use case for POST /api/admin/service-accounts/{id}/tokens.
+func NewCreateServiceAccountTokenUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input createServiceAccountTokenInput, output *createTokenOutput) error {
+		sa, user, err := loadServiceAccount(ctx, s, input.ID)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSpace(input.Name)
+		if name == "" {
+			return status.Wrap(errors.New("name is required"), status.InvalidArgument)
+		}
+		expiresAt, err := parseTokenExpiry(input.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		token, rawToken, err := s.CreateServiceAccountToken(sa.ID, name, expiresAt)
+		if err != nil {
+			return serviceAccountStoreErr(err)
+		}
+		logger.Info("service account token created", logger.KeyOperation, "service_account", "service_account_id", sa.ID.String(), "token_id", token.ID.String(), "admin", user.Email)
+		output.Token = newTokenToResponse(token, rawToken)
+		return nil
+	})
+	u.SetTitle("Create service account token")
+	u.SetDescription("Create an API token for a service account. The raw token is returned once")
+	u.SetExpectedErrors(status.Unauthenticated, status.PermissionDenied, status.InvalidArgument, status.NotFound, status.Internal)
+	return u
+}
+
+// NewRotateServiceAccountTokenUseCase returns a use case for POST /api/admin/service-accounts/{id}/tokens/{tokenId}/rotate.
+// It creates a replacement token with the same name and makes the old one expire after a grace period, so clients
+// can switch over without downtime.
+func NewRotateServiceAccountTokenUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input rotateServiceAccountTokenInput, output *rotateServiceAccountTokenOutput) error {
+		sa, user, err := loadServiceAccount(ctx, s, input.ID)
+		if err != nil {
+			return err
+		}
+		old, err := s.GetAPIToken(input.TokenID)
+		if err != nil || old.ServiceAccountID != sa.ID {
+			return status.Wrap(errors.New("token not found"), status.NotFound)
+		}
+		grace := defaultTokenGracePeriod
+		if input.GracePeriodHours != nil {
+			if *input.GracePeriodHours < 0 || *input.GracePeriodHours > maxTokenGracePeriodHours {
+				return status.Wrap(errors.New("grace_period_hours must be between 0 and 720"), status.InvalidArgument)
+			}
+			grace = time.Duration(*input.GracePeriodHours) * time.Hour
+		}
+		expiresAt, err := parseTokenExpiry(input.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		token, rawToken, err := s.CreateServiceAccountToken(sa.ID, old.Name, expiresAt)
+		if err != nil {
+			return serviceAccountStoreErr(err)
+		}
+		// Never extend the old token: it stops at the end of the grace period or its own expiry, whichever is first.
+		oldExpiry := time.Now().Add(grace)
+		if old.ExpiresAt != nil && old.ExpiresAt.Before(oldExpiry) {
+			oldExpiry = *old.ExpiresAt
+		}
+		if err := s.SetServiceAccountTokenExpiry(sa.ID, old.ID, &oldExpiry); err != nil {
+			return serviceAccountStoreErr(err)
+		}
+		previous := *old
+		previous.ExpiresAt = &oldExpiry
+		logger.Info("service account token rotated", logger.KeyOperation, "service_account", "service_account_id", sa.ID.String(),
+			"token_id", token.ID.String(), "previous_token_id", old.ID.String(), "previous_expires_at", oldExpiry.Format(time.RFC3339), "admin", user.Email)
+		output.Token = newTokenToResponse(token, rawToken)
+		output.Previous = tokenToResponse(&previous)
+		return nil
+	})
+	u.SetTitle("Rotate service account token")
+	u.SetDescription("Replace a service account token. The old token keeps working for grace_period_hours (default 24) so clients can switch over")
+	u.SetExpectedErrors(status.Unauthenticated, status.PermissionDenied, status.InvalidArgument, status.NotFound, status.Internal)
+	return u
+}
+
+// NewDeleteServiceAccountTokenUseCase returns a use case for DELETE /api/admin/service-accounts/{id}/tokens/{tokenId}.
+func NewDeleteServiceAccountTokenUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input serviceAccountTokenPathInput, _ *struct{}) error {
+		sa, user, err := loadServiceAccount(ctx, s, input.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.DeleteServiceAccountToken(sa.ID, input.TokenID); err != nil {
+			return serviceAccountStoreErr(err)
+		}
+		logger.Info("service account token revoked", logger.KeyOperation, "service_account", "service_account_id", sa.ID.String(), "token_id", input.TokenID.String(), "admin", user.Email)
+		return nil
+	})
+	u.SetTitle("Revoke service account token")
+	u.SetDescription("Delete a service account token; it stops working immediately")
+	u.SetExpectedErrors(status.Unauthenticated, status.PermissionDenied, status.NotFound, status.Internal)
+	return u
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JakeNeyer/ipam/internal/logger"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/usecase/status"
+)
+
+func TestServiceAccounts(t *testing.T) {
+	s, globalAdmin, org, orgAdmin := setupGlobalAdminTest(t)
+	asAdmin := auth.WithUser(context.Background(), orgAdmin)
+
+	var created serviceAccountOutput
+	if err := NewCreateServiceAccountUseCase(s).Interact(asAdmin, createServiceAccountInput{Name: "terraform", Role: store.RoleAdmin}, &created); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if created.ServiceAccount.OrganizationID != org.ID.String() || created.ServiceAccount.Role != store.RoleAdmin {
+		t.Errorf("created %+v", created.ServiceAccount)
+	}
+	saID := uuid.MustParse(created.ServiceAccount.ID)
+	if err := NewCreateServiceAccountUseCase(s).Interact(asAdmin, createServiceAccountInput{Name: "Terraform"}, &serviceAccountOutput{}); !errors.Is(err, status.AlreadyExists) {
+		t.Errorf("duplicate name: err = %v", err)
+	}
+	if err := NewCreateServiceAccountUseCase(s).Interact(auth.WithUser(context.Background(), globalAdmin), createServiceAccountInput{Name: "global"}, &serviceAccountOutput{}); !errors.Is(err, status.InvalidArgument) {
+		t.Errorf("global admin without organization_id: err = %v", err)
+	}
+
+	// Admins of other organizations cannot see the account.
+	other := &store.Organization{Name: "Other"}
+	if err := s.CreateOrganization(other); err != nil {
+		t.Fatal(err)
+	}
+	otherAdmin := &store.User{Email: "admin@other.example.com", Role: store.RoleAdmin, OrganizationID: other.ID}
+	if err := s.CreateUser(otherAdmin); err != nil {
+		t.Fatal(err)
+	}
+	err := NewCreateServiceAccountTokenUseCase(s).Interact(auth.WithUser(context.Background(), otherAdmin), createServiceAccountTokenInput{ID: saID, Name: "steal"}, &createTokenOutput{})
+	if !errors.Is(err, status.NotFound) {
+		t.Errorf("other org admin: err = %v", err)
+	}
+
+	var tok createTokenOutput
+	if err := NewCreateServiceAccountTokenUseCase(s).Interact(asAdmin, createServiceAccountTokenInput{ID: saID, Name: "ci"}, &tok); err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+
+	var logs bytes.Buffer
+	prevLog := logger.Log
+	logger.Log = slog.New(slog.NewJSONHandler(&logs, nil))
+	t.Cleanup(func() { logger.Log = prevLog })
+	var seen *store.User
+	protected := auth.Middleware(s, config.SessionConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = auth.UserFromContext(r.Context())
+		if auth.ServiceAccountFromContext(r.Context()) == nil {
+			t.Error("request not marked as made by a service account")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	call := func(method, path, rawToken string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+rawToken)
+		rec := httptest.NewRecorder()
+		protected.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// The token belongs to the account, not to the admin who created it.
+	if err := s.DeleteUser(orgAdmin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := call(http.MethodPost, "/api/blocks", tok.Token.Token); code != http.StatusCreated {
+		t.Fatalf("service account request: status = %d", code)
+	}
+	if seen.ID != saID || seen.Role != store.RoleAdmin || seen.OrganizationID != org.ID || auth.IsGlobalAdmin(seen) {
+		t.Errorf("principal = %+v", seen)
+	}
+	for _, want := range []string{`"msg":"audit"`, `"actor_type":"service_account"`, `"actor_id":"` + saID.String() + `"`, `"actor":"terraform"`, `"status":201`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("audit log %s: missing %s", logs.String(), want)
+		}
+	}
+	for _, path := range []string{"/api/admin/users", "/api/admin/service-accounts", "/api/auth/me/tokens"} {
+		if code := call(http.MethodGet, path, tok.Token.Token); code != http.StatusForbidden {
+			t.Errorf("GET %s: status = %d, want 403", path, code)
+		}
+	}
+
+	// Rotation: both tokens work during the grace period.
+	asGlobal := auth.WithUser(context.Background(), globalAdmin)
+	grace := 2
+	var rotated rotateServiceAccountTokenOutput
+	oldID := uuid.MustParse(tok.Token.ID)
+	if err := NewRotateServiceAccountTokenUseCase(s).Interact(asGlobal, rotateServiceAccountTokenInput{ID: saID, TokenID: oldID, GracePeriodHours: &grace}, &rotated); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if rotated.Token.Name != "ci" || rotated.Previous.ExpiresAt == nil {
+		t.Fatalf("rotated = %+v", rotated)
+	}
+	if exp, _ := time.Parse(time.RFC3339, *rotated.Previous.ExpiresAt); exp.Before(time.Now().Add(time.Hour)) || exp.After(time.Now().Add(3*time.Hour)) {
+		t.Errorf("old token expires at %s, want in about 2 hours", exp)
+	}
+	for _, raw := range []string{tok.Token.Token, rotated.Token.Token} {
+		if code := call(http.MethodGet, "/api/blocks", raw); code != http.StatusCreated {
+			t.Errorf("token during grace period: status = %d", code)
+		}
+	}
+	// grace_period_hours 0 ends the old token now.
+	none := 0
+	previous := rotated.Token.Token
+	if err := NewRotateServiceAccountTokenUseCase(s).Interact(asGlobal, rotateServiceAccountTokenInput{ID: saID, TokenID: uuid.MustParse(rotated.Token.ID), GracePeriodHours: &none}, &rotated); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if code := call(http.MethodGet, "/api/blocks", previous); code != http.StatusUnauthorized {
+		t.Errorf("token rotated without grace period: status = %d, want 401", code)
+	}
+	if code := call(http.MethodGet, "/api/blocks", rotated.Token.Token); code != http.StatusCreated {
+		t.Errorf("newest token: status = %d", code)
+	}
+	var listed listTokensOutput
+	if err := NewListServiceAccountTokensUseCase(s).Interact(asGlobal, serviceAccountPathInput{ID: saID}, &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Tokens) != 3 {
+		t.Errorf("listed %d tokens, want 3", len(listed.Tokens))
+	}
+
+	// Disabled accounts are rejected; their role can change without losing tokens.
+	var updated serviceAccountOutput
+	if err := NewUpdateServiceAccountUseCase(s).Interact(asGlobal, updateServiceAccountInput{ID: saID, Name: "terraform", Role: store.RoleUser, Disabled: true}, &updated); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if code := call(http.MethodGet, "/api/blocks", rotated.Token.Token); code != http.StatusUnauthorized {
+		t.Errorf("disabled account: status = %d, want 401", code)
+	}
+	if err := NewUpdateServiceAccountUseCase(s).Interact(asGlobal, updateServiceAccountInput{ID: saID, Name: "terraform", Role: store.RoleUser}, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if code := call(http.MethodGet, "/api/blocks", rotated.Token.Token); code != http.StatusCreated || seen.Role != store.RoleUser {
+		t.Errorf("re-enabled account: status = %d, role %q", code, seen.Role)
+	}
+
+	if err := NewDeleteServiceAccountUseCase(s).Interact(asGlobal, serviceAccountPathInput{ID: saID}, &struct{}{}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if code := call(http.MethodGet, "/api/blocks", rotated.Token.Token); code != http.StatusUnauthorized {
+		t.Errorf("deleted account: status = %d, want 401", code)
+	}
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JakeNeyer/ipam/internal/logger"
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+	"github.com/swaggest/usecase"
+	"github.com/swaggest/usecase/status"
+)
+
+// sessionResponse is a signed-in browser in GET /api/auth/me/sessions. ID is derived from the session and cannot be
+// used to authenticate.
+type sessionResponse struct {
+	ID         string `json:"id"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at,omitempty"`
+	ExpiresAt  string `json:"expires_at"`
+	IP         string `json:"ip,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	Current    bool   `json:"current"` // the session making this request
+}
+
+type listSessionsOutput struct {
+	Sessions []sessionResponse `json:"sessions"`
+}
+
+// NewListSessionsUseCase returns a use case for GET /api/auth/me/sessions.
+func NewListSessionsUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input struct{}, output *listSessionsOutput) error {
+		user := auth.UserFromContext(ctx)
+		if user == nil {
+			return status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
+		}
+		sessions, err := s.ListSessions(user.ID)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		current := auth.SessionIDFromContext(ctx)
+		out := make([]sessionResponse, 0, len(sessions))
+		for _, sess := range sessions {
+			resp := sessionResponse{
+				ID:        auth.PublicSessionID(sess.ID),
+				CreatedAt: sess.CreatedAt.Format(time.RFC3339),
+				ExpiresAt: sess.Expiry.Format(time.RFC3339),
+				IP:        sess.IP,
+				UserAgent: sess.UserAgent,
+				Current:   current != "" && sess.ID == current,
+			}
+			if !sess.LastSeenAt.IsZero() {
+				resp.LastSeenAt = sess.LastSeenAt.Format(time.RFC3339)
+			}
+			out = append(out, resp)
+		}
+		output.Sessions = out
+		return nil
+	})
+	u.SetTitle("List sessions")
+	u.SetDescription("List the current user's active sessions, most recently used first")
+	u.SetExpectedErrors(status.Unauthenticated, status.Internal)
+	return u
+}
+
+// NewRevokeSessionUseCase returns a use case for DELETE /api/auth/me/sessions/:id.
+func NewRevokeSessionUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input struct {
+		ID string `path:"id"`
+	}, output *struct{}) error {
+		user := auth.UserFromContext(ctx)
+		if user == nil {
+			return status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
+		}
+		sessions, err := s.ListSessions(user.ID)
+		if err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		for _, sess := range sessions {
+			if auth.PublicSessionID(sess.ID) == input.ID {
+				if err := s.DeleteSession(sess.ID); err != nil {
+					return status.Wrap(err, status.Internal)
+				}
+				logger.Info("session revoked", logger.KeyUserID, user.ID.String(), logger.KeyEmail, user.Email)
+				return nil
+			}
+		}
+		return status.Wrap(errors.New("session not found"), status.NotFound)
+	})
+	u.SetTitle("Revoke session")
+	u.SetDescription("Sign out one of the current user's sessions")
+	u.SetExpectedErrors(status.Unauthenticated, status.NotFound, status.Internal)
+	return u
+}
+
+// NewRevokeOtherSessionsUseCase returns a use case for DELETE /api/auth/me/sessions.
+func NewRevokeOtherSessionsUseCase(s store.Storer) usecase.Interactor {
+	u := usecase.NewInteractor(func(ctx context.Context, input struct{}, output *struct{}) error {
+		user := auth.UserFromContext(ctx)
+		if user == nil {
+			return status.Wrap(errors.New("unauthorized"), status.Unauthenticated)
+		}
+		if err := s.DeleteUserSessions(user.ID, auth.SessionIDFromContext(ctx)); err != nil {
+			return status.Wrap(err, status.Internal)
+		}
+		logger.Info("other sessions revoked", logger.KeyUserID, user.ID.String(), logger.KeyEmail, user.Email)
+		return nil
+	})
+	u.SetTitle("Revoke other sessions")
+	u.SetDescription("Sign out all of the current user's sessions except the one making the request")
+	u.SetExpectedErrors(status.Unauthenticated, status.Internal)
+	return u
+}
+
+// RevokeUserSessionsHandler handles DELETE /api/admin/users/:id/sessions: it signs the user out everywhere. API
+// tokens are not affected. Admins may sign out users in their organization; global admins anyone. Only a global admin
+// may sign out a global admin.
+func RevokeUserSessionsHandler(s store.Storer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		requester := auth.UserFromContext(r.Context())
+		if requester == nil || requester.Role != store.RoleAdmin {
+			auth.WriteJSONError(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		idStr := strings.Trim(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/admin/users/"), "/sessions"), "/")
+		userID, err := uuid.Parse(idStr)
+		if err != nil {
+			auth.WriteJSONError(w, "invalid user id", http.StatusBadRequest)
+			return
+		}
+		target, err := s.GetUser(userID)
+		if err != nil {
+			auth.WriteJSONError(w, "user not found", http.StatusNotFound)
+			return
+		}
+		globalAdmin := auth.IsGlobalAdminRequest(r.Context(), requester)
+		if auth.IsGlobalAdmin(target) && !globalAdmin {
+			auth.WriteJSONError(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if !globalAdmin && target.OrganizationID != auth.UserOrgForAccess(r.Context(), requester) {
+			auth.WriteJSONError(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err := s.DeleteUserSessions(target.ID, ""); err != nil {
+			auth.WriteJSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("sessions revoked by admin", logger.KeyUserID, target.ID.String(), logger.KeyEmail, target.Email, "admin", requester.Email)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/JakeNeyer/ipam/server/auth"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/swaggest/usecase/status"
+)
+
+func TestSessions_ListAndRevoke(t *testing.T) {
+	s := store.NewStore()
+	alice := passwordUser(t, s, "alice@example.org", store.RoleUser, [16]byte{})
+	var cookies []*http.Cookie
+	for i := 0; i < 3; i++ {
+		_, cookie, err := passwordLogin(t, s, &config.Config{}, nil, "alice@example.org", "user-password")
+		if err != nil || cookie == nil {
+			t.Fatalf("login: err = %v, cookie = %v", err, cookie)
+		}
+		cookies = append(cookies, cookie)
+	}
+	ctx := auth.WithSessionID(auth.WithUser(context.Background(), alice), cookies[0].Value)
+
+	var listed listSessionsOutput
+	if err := NewListSessionsUseCase(s).Interact(ctx, struct{}{}, &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Sessions) != 3 {
+		t.Fatalf("sessions = %d, want 3", len(listed.Sessions))
+	}
+	current := 0
+	for _, sess := range listed.Sessions {
+		if sess.ID == cookies[0].Value || sess.ID == cookies[1].Value || sess.ID == cookies[2].Value {
+			t.Fatal("session id in the response is the cookie value")
+		}
+		if sess.Current {
+			current++
+		}
+		if sess.LastSeenAt == "" || sess.IP == "" {
+			t.Errorf("session %+v: want last seen and IP from the login request", sess)
+		}
+	}
+	if current != 1 {
+		t.Errorf("current sessions = %d, want 1", current)
+	}
+
+	// Revoke one session by its public id.
+	target := auth.PublicSessionID(cookies[1].Value)
+	del := NewRevokeSessionUseCase(s)
+	if err := del.Interact(ctx, struct {
+		ID string `path:"id"`
+	}{ID: target}, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetSession(cookies[1].Value); err == nil {
+		t.Error("revoked session still valid")
+	}
+	if err := del.Interact(ctx, struct {
+		ID string `path:"id"`
+	}{ID: target}, &struct{}{}); !errors.Is(err, status.NotFound) {
+		t.Errorf("revoke twice: err = %v, want NotFound", err)
+	}
+	failing := NewRevokeSessionUseCase(failingSessionStore{s})
+	if err := failing.Interact(ctx, struct {
+		ID string `path:"id"`
+	}{ID: auth.PublicSessionID(cookies[2].Value)}, &struct{}{}); !errors.Is(err, status.Internal) {
+		t.Errorf("revoke with store error: err = %v, want Internal", err)
+	}
+
+	// Revoke all but the current one.
+	if err := NewRevokeOtherSessionsUseCase(s).Interact(ctx, struct{}{}, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetSession(cookies[0].Value); err != nil {
+		t.Errorf("current session revoked: %v", err)
+	}
+	if _, err := s.GetSession(cookies[2].Value); err == nil {
+		t.Error("other session still valid")
+	}
+}
+
+func TestSessions_IdleTimeout(t *testing.T) {
+	s := store.NewStore()
+	alice := passwordUser(t, s, "alice@example.org", store.RoleUser, [16]byte{})
+	protected := auth.Middleware(s, config.SessionConfig{IdleTimeout: 10 * time.Minute})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	get := func(sessionID string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/blocks", nil)
+		req.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: sessionID})
+		rec := httptest.NewRecorder()
+		protected.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	s.CreateSession("active", alice.ID, time.Now().Add(time.Hour))
+	s.TouchSession("active", time.Now().Add(-5*time.Minute), "192.0.2.1", "test")
+	if code := get("active"); code != http.StatusOK {
+		t.Errorf("recently used session: status = %d, want 200", code)
+	}
+	if sess, err := s.GetSession("active"); err != nil || time.Since(sess.LastSeenAt) > time.Minute {
+		t.Errorf("last seen not refreshed: %+v, %v", sess, err)
+	}
+
+	s.CreateSession("idle", alice.ID, time.Now().Add(time.Hour))
+	s.TouchSession("idle", time.Now().Add(-11*time.Minute), "192.0.2.1", "test")
+	if code := get("idle"); code != http.StatusUnauthorized {
+		t.Errorf("idle session: status = %d, want 401", code)
+	}
+	if _, err := s.GetSession("idle"); err == nil {
+		t.Error("idle session not deleted")
+	}
+}
+
+// failingSessionStore cannot delete sessions.
+type failingSessionStore struct {
+	store.Storer
+}
+
+func (failingSessionStore) DeleteSession(string) error {
+	return errors.New("database unavailable")
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	s := store.NewStore()
+	acme := &store.Organization{Name: "Acme"}
+	other := &store.Organization{Name: "Other"}
+	for _, o := range []*store.Organization{acme, other} {
+		if err := s.CreateOrganization(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	carol := passwordUser(t, s, "carol@example.org", store.RoleUser, acme.ID)
+	acmeAdmin := passwordUser(t, s, "admin@acme.example", store.RoleAdmin, acme.ID)
+	otherAdmin := passwordUser(t, s, "admin@other.example", store.RoleAdmin, other.ID)
+	s.CreateSession("carol-laptop", carol.ID, time.Now().Add(time.Hour))
+	s.CreateSession("carol-phone", carol.ID, time.Now().Add(time.Hour))
+	_, _, err := s.CreateAPIToken(carol.ID, "ci", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := revokeUserSessions(s, otherAdmin, carol); code != http.StatusNotFound {
+		t.Errorf("admin of another organization: status = %d, want 404", code)
+	}
+	if code := revokeUserSessions(s, acmeAdmin, carol); code != http.StatusNoContent {
+		t.Fatalf("admin of the organization: status = %d, want 204", code)
+	}
+	if sessions, _ := s.ListSessions(carol.ID); len(sessions) != 0 {
+		t.Errorf("sessions after force logout = %d, want 0", len(sessions))
+	}
+	if tokens, _ := s.ListAPITokens(carol.ID); len(tokens) != 1 {
+		t.Errorf("API tokens after force logout = %d, want 1 (not affected)", len(tokens))
+	}
+}
+
+// revokeUserSessions sends DELETE /api/admin/users/{target}/sessions as admin and returns the status code.
+func revokeUserSessions(s store.Storer, admin, target *store.User) int {
+	req := httptest.NewRequest(http.MethodDelete, "/api/admin/users/"+target.ID.String()+"/sessions", nil)
+	req = req.WithContext(auth.WithUser(req.Context(), admin))
+	rec := httptest.NewRecorder()
+	RevokeUserSessionsHandler(s).ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestRevokeUserSessions_GlobalAdmin(t *testing.T) {
+	s := store.NewStore()
+	acme := &store.Organization{Name: "Acme"}
+	if err := s.CreateOrganization(acme); err != nil {
+		t.Fatal(err)
+	}
+	root := passwordUser(t, s, "root@example.org", store.RoleAdmin, [16]byte{})
+	otherRoot := passwordUser(t, s, "root2@example.org", store.RoleAdmin, [16]byte{})
+	acmeAdmin := passwordUser(t, s, "admin@acme.example", store.RoleAdmin, acme.ID)
+	s.CreateSession("root-laptop", root.ID, time.Now().Add(time.Hour))
+
+	if code := revokeUserSessions(s, acmeAdmin, root); code != http.StatusForbidden {
+		t.Errorf("org admin signing out the global admin: status = %d, want 403", code)
+	}
+	if _, err := s.GetSession("root-laptop"); err != nil {
+		t.Errorf("global admin signed out by an org admin: %v", err)
+	}
+	if code := revokeUserSessions(s, otherRoot, root); code != http.StatusNoContent {
+		t.Errorf("global admin signing out a global admin: status = %d, want 204", code)
+	}
+}
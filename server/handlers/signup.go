@@ -126,14 +126,7 @@ func createSignupInvite(s store.Storer, user *store.User, w http.ResponseWriter,
 		return
 	}
 	_ = inv
-	var baseURL string
-	if cfg != nil && strings.TrimSpace(cfg.AppOrigin) != "" {
-		baseURL = strings.TrimSuffix(strings.TrimSpace(cfg.AppOrigin), "/")
-	} else {
-		baseURL = baseURLFromRequest(r)
-		baseURL = baseURL + strings.TrimSuffix(appBasePathFromRequest(r.URL.Path), "/")
-	}
-	inviteURL := baseURL + "/#signup?token=" + rawToken
+	inviteURL := appURL(r, cfg) + "/#signup?token=" + rawToken
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	_ = json.NewEncoder(w).Encode(CreateSignupInviteResponse{
@@ -174,6 +167,15 @@ func RevokeSignupInviteHandler(s store.Storer) http.HandlerFunc {
 	}
 }
 
+// appURL returns the web app's URL without a trailing slash, for links sent to users: cfg.AppOrigin when set, else
+// the origin and base path of r.
+func appURL(r *http.Request, cfg *config.Config) string {
+	if cfg != nil && strings.TrimSpace(cfg.AppOrigin) != "" {
+		return strings.TrimSuffix(strings.TrimSpace(cfg.AppOrigin), "/")
+	}
+	return baseURLFromRequest(r) + strings.TrimSuffix(appBasePathFromRequest(r.URL.Path), "/")
+}
+
 // baseURLFromRequest returns the origin (scheme + host) for building absolute URLs.
 func baseURLFromRequest(r *http.Request) string {
 	scheme := r.Header.Get("X-Forwarded-Proto")
@@ -234,7 +236,7 @@ func ValidateSignupInviteHandler(s store.Storer) http.HandlerFunc {
 }
 
 // RegisterWithInviteHandler handles POST /api/signup/register. No auth. Creates user, consumes invite, sets session.
-func RegisterWithInviteHandler(s store.Storer) http.HandlerFunc {
+func RegisterWithInviteHandler(s store.Storer, cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -295,13 +297,11 @@ func RegisterWithInviteHandler(s store.Storer) http.HandlerFunc {
 		if err := s.MarkSignupInviteUsed(inv.ID, newUser.ID); err != nil {
 			_ = err
 		}
-		sessionID := auth.NewSessionID()
-		s.CreateSession(sessionID, newUser.ID, time.Now().Add(auth.SessionDuration))
 		secure := r.TLS != nil
 		if r.Header.Get("X-Forwarded-Proto") == "https" {
 			secure = true
 		}
-		auth.SetSessionCookie(w, sessionID, secure)
+		auth.StartSession(w, r, s, newUser.ID, sessionConfig(cfg), secure)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		_ = json.NewEncoder(w).Encode(map[string]UserResponse{
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/JakeNeyer/ipam/internal/logger"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+)
+
+const (
+	syncTickInterval = time.Minute
+	syncRunTimeout   = 10 * time.Minute
+)
+
+// syncScheduler runs background syncs on a fixed pool of workers. A connection is queued at most once at a time,
+// after a random delay (jitter) so connections sharing an interval do not all start on the same tick.
+type syncScheduler struct {
+	s       store.Storer
+	cfg     config.SyncConfig
+	queue   chan uuid.UUID
+	mu      sync.Mutex
+	pending map[uuid.UUID]bool // queued, waiting on jitter, or running
+
+	now    func() time.Time
+	jitter func(max time.Duration) time.Duration
+	run    func(ctx context.Context, s store.Storer, connID uuid.UUID) error
+}
+
+func newSyncScheduler(s store.Storer, cfg config.SyncConfig) *syncScheduler {
+	if cfg.MaxConcurrency <= 0 {
+		cfg.MaxConcurrency = config.DefaultSyncMaxConcurrency
+	}
+	if cfg.BackoffMax <= 0 {
+		cfg.BackoffMax = config.DefaultSyncBackoffMax
+	}
+	return &syncScheduler{
+		s:       s,
+		cfg:     cfg,
+		queue:   make(chan uuid.UUID, cfg.MaxConcurrency),
+		pending: make(map[uuid.UUID]bool),
+		now:     time.Now,
+		jitter:  randomJitter,
+		run:     RunSyncForConnection,
+	}
+}
+
+func randomJitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return rand.N(max)
+}
+
+// StartBackgroundSync syncs cloud connections on their configured interval (default 5 min) using at most
+// cfg.MaxConcurrency workers. Paused connections are skipped; failing ones back off exponentially.
+func StartBackgroundSync(s store.Storer, cfg config.SyncConfig) {
+	sch := newSyncScheduler(s, cfg)
+	for i := 0; i < sch.cfg.MaxConcurrency; i++ {
+		go sch.worker()
+	}
+	go func() {
+		ticker := time.NewTicker(syncTickInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			sch.dispatch()
+		}
+	}()
+}
+
+// syncDue reports whether background sync should run for c now.
+func syncDue(c *store.CloudConnection, now time.Time) bool {
+	if c.SyncIntervalMinutes <= 0 || c.SyncPaused {
+		return false
+	}
+	if c.SyncBackoffUntil != nil {
+		return !now.Before(*c.SyncBackoffUntil)
+	}
+	interval := time.Duration(c.SyncIntervalMinutes) * time.Minute
+	return c.LastSyncAt == nil || now.Sub(*c.LastSyncAt) >= interval
+}
+
+// syncBackoffDelay returns the wait before retrying after failures consecutive failures:
+// interval doubled per failure, capped at max.
+func syncBackoffDelay(interval time.Duration, failures int, max time.Duration) time.Duration {
+	delay := interval
+	for i := 0; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// dispatch queues every due connection that is not already pending.
+func (sch *syncScheduler) dispatch() {
+	list, err := sch.s.ListCloudConnections()
+	if err != nil {
+		logger.Error("sync background: list connections", logger.ErrAttr(err))
+		return
+	}
+	now := sch.now()
+	for _, c := range list {
+		if !syncDue(c, now) {
+			continue
+		}
+		sch.mu.Lock()
+		if sch.pending[c.ID] {
+			sch.mu.Unlock()
+			continue
+		}
+		sch.pending[c.ID] = true
+		sch.mu.Unlock()
+
+		connID := c.ID
+		if delay := sch.jitter(sch.cfg.Jitter); delay > 0 {
+			time.AfterFunc(delay, func() { sch.queue <- connID })
+		} else {
+			go func() { sch.queue <- connID }()
+		}
+	}
+}
+
+func (sch *syncScheduler) worker() {
+	for connID := range sch.queue {
+		sch.runOne(connID)
+	}
+}
+
+// runOne syncs one connection under the store's sync lock and, on failure, schedules the next retry.
+func (sch *syncScheduler) runOne(connID uuid.UUID) {
+	defer func() {
+		sch.mu.Lock()
+		delete(sch.pending, connID)
+		sch.mu.Unlock()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), syncRunTimeout)
+	defer cancel()
+	// Back off while still holding the sync lock so no other sync writes the sync state in between.
+	_, _ = sch.s.WithSyncLock(ctx, connID, func() error {
+		if err := sch.run(ctx, sch.s, connID); err != nil {
+			logger.Error("sync background failed", slog.String("connection_id", connID.String()), logger.ErrAttr(err))
+			sch.backOff(connID)
+		}
+		return nil
+	})
+}
+
+// backOff sets SyncBackoffUntil from the connection's consecutive failure count. Half the delay is
+// randomized so connections that failed together do not retry together.
+func (sch *syncScheduler) backOff(connID uuid.UUID) {
+	c, err := sch.s.GetCloudConnection(connID)
+	if err != nil || c.ConsecutiveSyncFailures == 0 {
+		return
+	}
+	interval := time.Duration(c.SyncIntervalMinutes) * time.Minute
+	delay := syncBackoffDelay(interval, c.ConsecutiveSyncFailures, sch.cfg.BackoffMax)
+	delay = delay/2 + sch.jitter(delay/2)
+	until := sch.now().Add(delay)
+	st := c.SyncState()
+	st.SyncBackoffUntil = &until
+	if err := sch.s.UpdateCloudConnectionSyncState(connID, st); err != nil {
+		logger.Error("sync background: record backoff", slog.String("connection_id", connID.String()), logger.ErrAttr(err))
+		return
+	}
+	logger.Info("sync background backing off",
+		slog.String("connection_id", connID.String()),
+		slog.Int("consecutive_failures", c.ConsecutiveSyncFailures),
+		slog.Time("retry_after", until))
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/store"
+	"github.com/google/uuid"
+)
+
+func TestSyncDue(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) *time.Time { t := now.Add(-d); return &t }
+	in := func(d time.Duration) *time.Time { t := now.Add(d); return &t }
+	tests := []struct {
+		name string
+		conn store.CloudConnection
+		want bool
+	}{
+		{name: "never synced", conn: store.CloudConnection{SyncIntervalMinutes: 5}, want: true},
+		{name: "interval elapsed", conn: store.CloudConnection{SyncIntervalMinutes: 5, LastSyncAt: ago(5 * time.Minute)}, want: true},
+		{name: "interval not elapsed", conn: store.CloudConnection{SyncIntervalMinutes: 5, LastSyncAt: ago(time.Minute)}, want: false},
+		{name: "sync disabled", conn: store.CloudConnection{SyncIntervalMinutes: 0}, want: false},
+		{name: "paused", conn: store.CloudConnection{SyncIntervalMinutes: 5, SyncPaused: true}, want: false},
+		{name: "backing off", conn: store.CloudConnection{SyncIntervalMinutes: 5, LastSyncAt: ago(time.Hour), SyncBackoffUntil: in(time.Minute)}, want: false},
+		{name: "backoff over", conn: store.CloudConnection{SyncIntervalMinutes: 5, LastSyncAt: ago(time.Hour), SyncBackoffUntil: ago(time.Second)}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := syncDue(&tt.conn, now); got != tt.want {
+				t.Errorf("syncDue(%s) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncBackoffDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 5 * time.Minute},
+		{1, 10 * time.Minute},
+		{3, 40 * time.Minute},
+		{6, time.Hour},
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := syncBackoffDelay(5*time.Minute, tt.failures, time.Hour); got != tt.want {
+			t.Errorf("syncBackoffDelay(5m, %d, 1h) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func createSyncTestConnections(t *testing.T, s store.Storer, n int) []uuid.UUID {
+	t.Helper()
+	ids := make([]uuid.UUID, n)
+	for i := range ids {
+		c := &store.CloudConnection{OrganizationID: uuid.New(), Provider: "aws", Name: "conn", SyncIntervalMinutes: 5}
+		if err := s.CreateCloudConnection(c); err != nil {
+			t.Fatalf("create connection: %v", err)
+		}
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+func TestSyncScheduler_MaxConcurrency(t *testing.T) {
+	s := store.NewStore()
+	createSyncTestConnections(t, s, 6)
+	sch := newSyncScheduler(s, config.SyncConfig{MaxConcurrency: 2})
+	var running, peak, done atomic.Int32
+	var wg sync.WaitGroup
+	wg.Add(6)
+	sch.run = func(ctx context.Context, s store.Storer, connID uuid.UUID) error {
+		defer wg.Done()
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+		done.Add(1)
+		return nil
+	}
+	for i := 0; i < sch.cfg.MaxConcurrency; i++ {
+		go sch.worker()
+	}
+	sch.dispatch()
+	sch.dispatch() // still pending: must not queue anything twice
+	wg.Wait()
+	if got := peak.Load(); got > 2 {
+		t.Errorf("peak concurrent syncs = %d, want <= 2", got)
+	}
+	if got := done.Load(); got != 6 {
+		t.Errorf("syncs run = %d, want 6", got)
+	}
+}
+
+func TestSyncScheduler_BackoffAndPause(t *testing.T) {
+	s := store.NewStore()
+	ids := createSyncTestConnections(t, s, 2)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	sch := newSyncScheduler(s, config.SyncConfig{MaxConcurrency: 1, BackoffMax: time.Hour})
+	sch.now = func() time.Time { return now }
+	sch.jitter = func(max time.Duration) time.Duration { return max } // deterministic: full delay
+	sch.run = func(ctx context.Context, s store.Storer, connID uuid.UUID) error {
+		return recordSyncFailure(s, connID, errors.New("credentials expired"))
+	}
+
+	sch.runOne(ids[0])
+	sch.runOne(ids[0])
+	c, _ := s.GetCloudConnection(ids[0])
+	if c.ConsecutiveSyncFailures != 2 {
+		t.Errorf("ConsecutiveSyncFailures = %d, want 2", c.ConsecutiveSyncFailures)
+	}
+	if c.SyncBackoffUntil == nil || !c.SyncBackoffUntil.Equal(now.Add(20*time.Minute)) {
+		t.Errorf("SyncBackoffUntil = %v, want now+20m", c.SyncBackoffUntil)
+	}
+	if syncDue(c, now.Add(19*time.Minute)) || !syncDue(c, now.Add(20*time.Minute)) {
+		t.Error("connection should be due exactly when backoff ends")
+	}
+
+	if err := recordSyncSuccess(s, ids[0]); err != nil {
+		t.Fatalf("recordSyncSuccess: %v", err)
+	}
+	c, _ = s.GetCloudConnection(ids[0])
+	if c.ConsecutiveSyncFailures != 0 || c.SyncBackoffUntil != nil {
+		t.Errorf("after success: failures = %d, backoff = %v; want cleared", c.ConsecutiveSyncFailures, c.SyncBackoffUntil)
+	}
+
+	paused, _ := s.GetCloudConnection(ids[1])
+	paused.SyncPaused = true
+	_ = s.UpdateCloudConnection(ids[1], paused)
+	sch.jitter = func(time.Duration) time.Duration { return 0 }
+	sch.dispatch()
+	if got := <-sch.queue; got != ids[0] {
+		t.Errorf("queued %s, want only the unpaused connection %s", got, ids[0])
+	}
+	sch.mu.Lock()
+	defer sch.mu.Unlock()
+	if sch.pending[ids[1]] {
+		t.Error("paused connection was queued")
+	}
+}
+
+func TestSyncState_ConcurrentSettingsUpdate(t *testing.T) {
+	s := store.NewStore()
+	id := createSyncTestConnections(t, s, 1)[0]
+	c, _ := s.GetCloudConnection(id)
+	edit := *c // a PATCH that read the connection before the sync finished
+	_ = recordSyncFailure(s, id, errors.New("credentials expired"))
+	edit.SyncPaused = true
+	if err := s.UpdateCloudConnection(id, &edit); err != nil {
+		t.Fatalf("UpdateCloudConnection: %v", err)
+	}
+	c, _ = s.GetCloudConnection(id)
+	if !c.SyncPaused {
+		t.Error("SyncPaused lost")
+	}
+	if c.ConsecutiveSyncFailures != 1 || c.LastSyncStatus == nil || *c.LastSyncStatus != "failed" {
+		t.Errorf("sync state overwritten by settings update: failures = %d, status = %v", c.ConsecutiveSyncFailures, c.LastSyncStatus)
+	}
+	if err := recordSyncSuccess(s, id); err != nil {
+		t.Fatalf("recordSyncSuccess: %v", err)
+	}
+	if c, _ = s.GetCloudConnection(id); !c.SyncPaused {
+		t.Error("SyncPaused overwritten by sync state update")
+	}
+}
@@ -0,0 +1,164 @@
+// Package ldapauth authenticates users against an LDAP directory or Active Directory with a search-then-bind.
+package ldapauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/JakeNeyer/ipam/internal/logger"
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const dialTimeout = 10 * time.Second
+
+// ErrInvalidCredentials means the directory has no such user or rejected the password. The caller may try other
+// authentication methods; any other error means the directory could not be asked.
+var ErrInvalidCredentials = errors.New("invalid directory credentials")
+
+// User is a directory user who bound successfully.
+type User struct {
+	DN     string
+	Email  string
+	Groups []string
+}
+
+// Authenticator checks passwords against the directory described by an LDAPConfig.
+type Authenticator struct {
+	cfg config.LDAPConfig
+	tls *tls.Config
+}
+
+// New returns an authenticator for cfg. It does not connect; each Authenticate call opens its own connection.
+func New(cfg config.LDAPConfig) (*Authenticator, error) {
+	cfg = cfg.WithDefaults()
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Hostname() == "" {
+		return nil, fmt.Errorf("LDAP_URL %q must be ldap://host[:port] or ldaps://host[:port]", cfg.URL)
+	}
+	if cfg.StartTLS && u.Scheme == "ldaps" {
+		return nil, errors.New("LDAP_START_TLS applies to ldap:// URLs; ldaps:// is already encrypted")
+	}
+	if u.Scheme == "ldap" && !cfg.StartTLS {
+		if !cfg.AllowInsecure {
+			return nil, errors.New("LDAP_URL ldap:// sends passwords in cleartext; use ldaps://, set LDAP_START_TLS=true, or set LDAP_ALLOW_INSECURE=true")
+		}
+		logger.Warn("LDAP_ALLOW_INSECURE is set: directory passwords are sent in cleartext", "url", cfg.URL)
+	}
+	if !strings.Contains(cfg.UserFilter, "{email}") {
+		return nil, errors.New("LDAP_USER_FILTER must contain {email}")
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read LDAP_CA_FILE: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("LDAP_CA_FILE has no PEM certificates")
+		}
+	}
+	return &Authenticator{cfg: cfg, tls: tlsConfig}, nil
+}
+
+func (a *Authenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: dialTimeout}),
+		ldap.DialWithTLSConfig(a.tls))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(dialTimeout)
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(a.tls); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// bindService binds as the search account, or stays anonymous when none is configured.
+func (a *Authenticator) bindService(conn *ldap.Conn) error {
+	if a.cfg.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+		return fmt.Errorf("ldap service bind: %w", err)
+	}
+	return nil
+}
+
+// Authenticate finds the user whose entry matches email, binds as that user with password, and looks up the groups
+// they belong to.
+func (a *Authenticator) Authenticate(email, password string) (*User, error) {
+	// An empty password is an unauthenticated bind (RFC 4513, section 5.1.2), which servers accept for any DN.
+	if email == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := a.bindService(conn); err != nil {
+		return nil, err
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		a.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(dialTimeout.Seconds()), false,
+		strings.ReplaceAll(a.cfg.UserFilter, "{email}", ldap.EscapeFilter(email)),
+		[]string{a.cfg.EmailAttribute}, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap user search: %w", err)
+	}
+	switch {
+	case res == nil || len(res.Entries) == 0:
+		return nil, ErrInvalidCredentials
+	case len(res.Entries) > 1:
+		return nil, fmt.Errorf("ldap user search: %q matches more than one entry", email)
+	}
+	entry := res.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap user bind: %w", err)
+	}
+	user := &User{DN: entry.DN, Email: strings.ToLower(strings.TrimSpace(entry.GetAttributeValue(a.cfg.EmailAttribute)))}
+	if user.Email == "" {
+		user.Email = strings.ToLower(strings.TrimSpace(email))
+	}
+	if len(a.cfg.GroupMappings) == 0 {
+		return user, nil
+	}
+
+	// Look groups up as the service account: the user may not be allowed to read group entries.
+	if err := a.bindService(conn); err != nil {
+		return nil, err
+	}
+	groups, err := conn.Search(ldap.NewSearchRequest(
+		a.cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(dialTimeout.Seconds()), false,
+		strings.ReplaceAll(a.cfg.GroupFilter, "{dn}", ldap.EscapeFilter(entry.DN)),
+		[]string{a.cfg.GroupAttribute}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ldap group search: %w", err)
+	}
+	for _, g := range groups.Entries {
+		if name := g.GetAttributeValue(a.cfg.GroupAttribute); name != "" {
+			user.Groups = append(user.Groups, name)
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,193 @@
+package ldapauth
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/JakeNeyer/ipam/server/ldapauth/ldaptest"
+)
+
+const (
+	serviceDN = "cn=ipam,ou=services,dc=example,dc=org"
+	aliceDN   = "uid=alice,ou=people,dc=example,dc=org"
+)
+
+func testDirectory(t *testing.T) *ldaptest.Server {
+	t.Helper()
+	return ldaptest.NewServer(t,
+		ldaptest.Entry{DN: serviceDN, Password: "service-secret"},
+		ldaptest.Entry{DN: aliceDN, Password: "alice-password", Attributes: map[string][]string{
+			"uid": {"alice"}, "mail": {"Alice@Example.org"},
+		}},
+		ldaptest.Entry{DN: "uid=bob,ou=people,dc=example,dc=org", Password: "bob-password", Attributes: map[string][]string{
+			"uid": {"bob"}, "mail": {"shared@example.org"},
+		}},
+		ldaptest.Entry{DN: "uid=carol,ou=people,dc=example,dc=org", Password: "carol-password", Attributes: map[string][]string{
+			"uid": {"carol"}, "mail": {"shared@example.org"},
+		}},
+		ldaptest.Entry{DN: "cn=netops,ou=groups,dc=example,dc=org", Attributes: map[string][]string{
+			"cn": {"netops"}, "member": {aliceDN},
+		}},
+		ldaptest.Entry{DN: "cn=dba,ou=groups,dc=example,dc=org", Attributes: map[string][]string{
+			"cn": {"dba"}, "uniqueMember": {aliceDN},
+		}},
+		ldaptest.Entry{DN: "cn=hr,ou=groups,dc=example,dc=org", Attributes: map[string][]string{
+			"cn": {"hr"}, "member": {"uid=bob,ou=people,dc=example,dc=org"},
+		}},
+	)
+}
+
+func testConfig(dir *ldaptest.Server) config.LDAPConfig {
+	return config.LDAPConfig{
+		URL:           dir.URL,
+		BindDN:        serviceDN,
+		BindPassword:  "service-secret",
+		BaseDN:        "dc=example,dc=org",
+		AllowInsecure: true,
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	dir := testDirectory(t)
+	a, err := New(testConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := a.Authenticate("alice@example.org", "alice-password")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if u.DN != aliceDN || u.Email != "alice@example.org" {
+		t.Errorf("user = %+v, want DN %s and email alice@example.org", u, aliceDN)
+	}
+	if len(u.Groups) != 0 {
+		t.Errorf("groups = %v, want none looked up without group mappings", u.Groups)
+	}
+	if got, want := dir.Binds(), []string{serviceDN, aliceDN}; !slices.Equal(got, want) {
+		t.Errorf("binds = %v, want %v", got, want)
+	}
+}
+
+func TestAuthenticate_Groups(t *testing.T) {
+	dir := testDirectory(t)
+	cfg := testConfig(dir)
+	cfg.GroupMappings = []config.GroupMapping{{Group: "netops", Organization: "Acme", Role: "admin"}}
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := a.Authenticate("alice@example.org", "alice-password")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	slices.Sort(u.Groups)
+	if want := []string{"dba", "netops"}; !slices.Equal(u.Groups, want) {
+		t.Errorf("groups = %v, want %v", u.Groups, want)
+	}
+}
+
+func TestAuthenticate_InvalidCredentials(t *testing.T) {
+	dir := testDirectory(t)
+	a, err := New(testConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, email, password string
+	}{
+		{"wrong password", "alice@example.org", "not-alices-password"},
+		{"unknown user", "nobody@example.org", "whatever-password"},
+		{"empty password", "alice@example.org", ""},
+		{"filter injection", "*", "alice-password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := a.Authenticate(tt.email, tt.password); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("err = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticate_AmbiguousUser(t *testing.T) {
+	dir := testDirectory(t)
+	a, err := New(testConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.Authenticate("shared@example.org", "bob-password")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("err = %v, want a directory error", err)
+	}
+}
+
+func TestAuthenticate_ServiceBindFails(t *testing.T) {
+	dir := testDirectory(t)
+	cfg := testConfig(dir)
+	cfg.BindPassword = "wrong"
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A broken service account is a misconfiguration, not a wrong user password.
+	if _, err := a.Authenticate("alice@example.org", "alice-password"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("err = %v, want a directory error", err)
+	}
+}
+
+func TestAuthenticate_StartTLS(t *testing.T) {
+	dir := testDirectory(t)
+	cfg := testConfig(dir)
+	cfg.StartTLS = true
+	cfg.AllowInsecure = false
+	cfg.CAFile = dir.CAFile
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate("alice@example.org", "alice-password"); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if dir.StartTLSCount() != 1 {
+		t.Errorf("StartTLS upgrades = %d, want 1", dir.StartTLSCount())
+	}
+}
+
+func TestAuthenticate_StartTLSUntrustedCertificate(t *testing.T) {
+	dir := testDirectory(t)
+	cfg := testConfig(dir)
+	cfg.StartTLS = true
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate("alice@example.org", "alice-password"); err == nil {
+		t.Fatal("Authenticate succeeded over a certificate not signed by a trusted CA")
+	}
+	if len(dir.Binds()) != 0 {
+		t.Errorf("binds = %v, want none before TLS is established", dir.Binds())
+	}
+}
+
+func TestNew_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.LDAPConfig
+	}{
+		{"bad scheme", config.LDAPConfig{URL: "http://dc.example.org", BaseDN: "dc=example,dc=org"}},
+		{"no host", config.LDAPConfig{URL: "ldap://", BaseDN: "dc=example,dc=org"}},
+		{"cleartext ldap", config.LDAPConfig{URL: "ldap://dc.example.org", BaseDN: "dc=example,dc=org"}},
+		{"starttls over ldaps", config.LDAPConfig{URL: "ldaps://dc.example.org", BaseDN: "dc=example,dc=org", StartTLS: true}},
+		{"filter without email", config.LDAPConfig{URL: "ldap://dc.example.org", BaseDN: "dc=example,dc=org", UserFilter: "(uid=alice)"}},
+		{"missing CA file", config.LDAPConfig{URL: "ldaps://dc.example.org", BaseDN: "dc=example,dc=org", CAFile: "/nonexistent/ca.pem"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg); err == nil {
+				t.Error("New succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"strings"
+	"testing"
+
+	"github.com/JakeNeyer/ipam/server/config"
+)
+
+// smtpSession is what the fake server received in one connection.
+type smtpSession struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP accepts one connection on localhost, speaks just enough SMTP for net/smtp, and reports what it received.
+func fakeSMTP(t *testing.T) (host string, port int, done <-chan smtpSession) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	ch := make(chan smtpSession, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = io.WriteString(conn, s+"\r\n") }
+		var sess smtpSession
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch cmd {
+			case "EHLO", "HELO":
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				parts := strings.Fields(line)
+				if len(parts) == 3 {
+					b, _ := base64.StdEncoding.DecodeString(parts[2])
+					sess.auth = string(b)
+				}
+				reply("235 2.7.0 Authentication successful")
+			case "MAIL":
+				sess.from = line
+				reply("250 OK")
+			case "RCPT":
+				sess.to = append(sess.to, line)
+				reply("250 OK")
+			case "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				sess.data = data.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				ch <- sess
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return "127.0.0.1", addr.Port, ch
+}
+
+func TestSMTPSender(t *testing.T) {
+	host, port, done := fakeSMTP(t)
+	sender := New(config.MailConfig{
+		SMTPHost:     host,
+		SMTPPort:     port,
+		SMTPUsername: "relay-user",
+		SMTPPassword: "relay-password",
+		From:         "IPAM <ipam@example.com>",
+	})
+	if _, ok := sender.(*SMTPSender); !ok {
+		t.Fatalf("New = %T, want *SMTPSender", sender)
+	}
+	err := sender.Send(context.Background(), Message{
+		To:      "alice@example.org",
+		Subject: "Réinitialiser",
+		Body:    "Open this link:\nhttps://ipam.example.com/#reset-password?token=reset_" + strings.Repeat("ab", 40),
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	sess := <-done
+	if sess.auth != "\x00relay-user\x00relay-password" {
+		t.Errorf("auth = %q", sess.auth)
+	}
+	if sess.from != "MAIL FROM:<ipam@example.com>" || len(sess.to) != 1 || sess.to[0] != "RCPT TO:<alice@example.org>" {
+		t.Errorf("envelope = %q %q", sess.from, sess.to)
+	}
+	m, err := netmail.ReadMessage(strings.NewReader(sess.data))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got := m.Header.Get("Subject"); got != "=?utf-8?q?R=C3=A9initialiser?=" {
+		t.Errorf("Subject = %q", got)
+	}
+	if got := m.Header.Get("From"); got != `"IPAM" <ipam@example.com>` {
+		t.Errorf("From = %q", got)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(m.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(body, []byte("token=reset_"+strings.Repeat("ab", 40))) {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestSMTPSender_RejectsHeaderInjection(t *testing.T) {
+	sender := NewSMTPSender(config.MailConfig{SMTPHost: "127.0.0.1", SMTPPort: 1, From: "ipam@example.com"})
+	for _, msg := range []Message{
+		{To: "alice@example.org\r\nBcc: mallory@example.org", Subject: "Hi"},
+		{To: "alice@example.org", Subject: "Hi\r\nBcc: mallory@example.org"},
+	} {
+		// Port 1 is closed: the message must be refused before anything is dialed.
+		var opErr *net.OpError
+		if err := sender.Send(context.Background(), msg); err == nil || errors.As(err, &opErr) {
+			t.Errorf("Send(%q, %q) = %v, want a validation error", msg.To, msg.Subject, err)
+		}
+	}
+}
+
+func TestLogSender(t *testing.T) {
+	var buf bytes.Buffer
+	sender := &LogSender{Logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+	if err := sender.Send(context.Background(), Message{To: "alice@example.org", Subject: "Hi", Body: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"to":"alice@example.org"`, `"subject":"Hi"`, `"body":"hello"`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log = %s, missing %s", buf.String(), want)
+		}
+	}
+	if New(config.MailConfig{}) != nil {
+		t.Error("New without configuration should return nil")
+	}
+	if _, ok := New(config.MailConfig{LogOnly: true}).(*LogSender); !ok {
+		t.Error("New with LogOnly should return a LogSender")
+	}
+}
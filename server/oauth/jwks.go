@@ -0,0 +1,256 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksMaxAge is how long a fetched key set is used before it is fetched again.
+	jwksMaxAge = time.Hour
+	// jwksMinRefresh limits refetches triggered by an unknown key id, so tokens with made-up kids cannot make us hammer
+	// the issuer.
+	jwksMinRefresh = time.Minute
+)
+
+// jsonWebKey is one entry of a JWKS document (RFC 7517). Only the fields needed for signature keys are decoded.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verificationKey is a parsed signature key.
+type verificationKey struct {
+	kid string
+	alg string // empty when the JWK does not pin one
+	key crypto.PublicKey
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil || len(n) == 0 {
+			return nil, errors.New("invalid RSA modulus")
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key too short (%d bits)", pub.N.BitLen())
+		}
+		return pub, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		x, errX := decodeSegment(k.X)
+		y, errY := decodeSegment(k.Y)
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC point")
+		}
+		point := append(append([]byte{4}, x...), y...)
+		return ecdsa.ParseUncompressedPublicKey(curve, point)
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifyJWS checks sig over signingInput with key for the JWS algorithm alg. Symmetric algorithms and "none" are not
+// accepted: an ID token must be signed by the issuer's published keys.
+func verifyJWS(alg string, key crypto.PublicKey, signingInput, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("key type does not match alg EdDSA")
+		}
+		if !ed25519.Verify(pub, signingInput, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match alg %s", alg)
+		}
+		if alg[0] == 'R' {
+			return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+		}
+		return rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	default: // ES
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match alg %s", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+}
+
+// keySet caches an issuer's JWKS. Keys are refetched when the cache is older than jwksMaxAge, or when a token names a
+// key id the cache does not have (the issuer rotated its keys), at most once per jwksMinRefresh.
+type keySet struct {
+	uri    string
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	keys    []verificationKey
+	fetched time.Time
+}
+
+func newKeySet(uri string, client *http.Client, now func() time.Time) *keySet {
+	return &keySet{uri: uri, client: client, now: now}
+}
+
+// verify checks a JWS signature against the issuer's keys, matching by key id when the token has one.
+func (ks *keySet) verify(ctx context.Context, kid, alg string, signingInput, sig []byte) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.fetched.IsZero() || ks.now().Sub(ks.fetched) > jwksMaxAge {
+		if err := ks.refresh(ctx); err != nil {
+			return err
+		}
+	}
+	found, err := ks.verifyCached(kid, alg, signingInput, sig)
+	if found || ks.now().Sub(ks.fetched) < jwksMinRefresh {
+		return err
+	}
+	if err := ks.refresh(ctx); err != nil {
+		return err
+	}
+	_, err = ks.verifyCached(kid, alg, signingInput, sig)
+	return err
+}
+
+// verifyCached reports whether a candidate key was found and, if so, the verification result. Caller holds ks.mu.
+func (ks *keySet) verifyCached(kid, alg string, signingInput, sig []byte) (bool, error) {
+	found := false
+	err := fmt.Errorf("no signing key for kid %q", kid)
+	for _, k := range ks.keys {
+		if (kid != "" && k.kid != kid) || (k.alg != "" && k.alg != alg) {
+			continue
+		}
+		found = true
+		if err = verifyJWS(alg, k.key, signingInput, sig); err == nil {
+			return true, nil
+		}
+	}
+	return found, err
+}
+
+// refresh fetches the JWKS. Keys that are not for signatures or cannot be parsed are skipped. Caller holds ks.mu.
+func (ks *keySet) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	// #nosec G704 -- jwks_uri comes from the discovery document of the operator-configured issuer.
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxOAuthResponseBytes))
+	if err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks %s: %s", ks.uri, resp.Status)
+	}
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("decode jwks: %w", err)
+	}
+	keys := make([]verificationKey, 0, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, verificationKey{kid: k.Kid, alg: k.Alg, key: pub})
+	}
+	if len(keys) == 0 {
+		return errors.New("jwks has no usable signing keys")
+	}
+	ks.keys = keys
+	ks.fetched = ks.now()
+	return nil
+}
@@ -22,9 +22,17 @@ type oauthUserInfo struct {
 	emailsPrimaryClaim  string
 	emailVerifiedClaim  string
 	emailsVerifiedClaim string
+	groupsClaim         string
 	client              *http.Client
 }
 
+// Identity is the user a provider authenticated.
+type Identity struct {
+	ProviderUserID string
+	Email          string
+	Groups         []string // values of the provider's groups claim
+}
+
 func newOAuthUserInfoFromConfig(pc config.OAuthProviderConfig, h *http.Client) *oauthUserInfo {
 	emailVerifiedClaim := pc.EmailVerifiedClaim
 	if emailVerifiedClaim == "" {
@@ -34,12 +42,17 @@ func newOAuthUserInfoFromConfig(pc config.OAuthProviderConfig, h *http.Client) *
 	if emailsVerifiedClaim == "" && strings.TrimSpace(pc.EmailsURL) != "" {
 		emailsVerifiedClaim = "verified"
 	}
-	return newOAuthUserInfo(
+	u := newOAuthUserInfo(
 		pc.UserInfoURL, pc.UserIDClaim, pc.EmailClaim,
 		pc.EmailsURL, pc.EmailsPrimaryClaim,
 		emailVerifiedClaim, emailsVerifiedClaim,
 		h,
 	)
+	u.groupsClaim = strings.TrimSpace(pc.GroupsClaim)
+	if u.groupsClaim == "" {
+		u.groupsClaim = "groups"
+	}
+	return u
 }
 
 func newOAuthUserInfo(
@@ -68,33 +81,44 @@ func newOAuthUserInfo(
 }
 
 func (u *oauthUserInfo) FetchUser(ctx context.Context, token *oauth2.Token) (providerUserID, email string, err error) {
+	id, err := u.FetchIdentity(ctx, token)
+	return id.ProviderUserID, id.Email, err
+}
+
+// FetchIdentity reads the user from the userinfo endpoint. The returned identity is never nil; on error it holds
+// what was read before the failure.
+func (u *oauthUserInfo) FetchIdentity(ctx context.Context, token *oauth2.Token) (*Identity, error) {
+	id := &Identity{}
 	claims, err := u.fetchJSON(ctx, token, u.userInfoURL)
 	if err != nil {
-		return "", "", err
+		return id, err
 	}
-	providerUserID = strings.TrimSpace(claimString(claims, u.userIDClaim))
-	email = strings.TrimSpace(strings.ToLower(claimString(claims, u.emailClaim)))
+	providerUserID := strings.TrimSpace(claimString(claims, u.userIDClaim))
+	email := strings.TrimSpace(strings.ToLower(claimString(claims, u.emailClaim)))
 	if providerUserID == "" {
-		return "", "", fmt.Errorf("missing %q in userinfo response", u.userIDClaim)
+		return id, fmt.Errorf("missing %q in userinfo response", u.userIDClaim)
 	}
 	if email != "" {
 		if err := u.requireVerifiedOnClaims(claims, u.emailVerifiedClaim); err != nil {
-			return "", "", err
+			return id, err
 		}
 	}
+	id.ProviderUserID = providerUserID
+	id.Groups = claimStrings(claims, u.groupsClaim)
 	if email == "" && u.emailsURL != "" {
 		email, err = u.fetchEmailFromList(ctx, token)
 		if err != nil {
-			return providerUserID, "", err
+			return id, err
 		}
 	}
 	if email == "" {
 		if u.emailsURL != "" {
-			return providerUserID, "", fmt.Errorf("no verified email in userinfo or emails list")
+			return id, fmt.Errorf("no verified email in userinfo or emails list")
 		}
-		return providerUserID, "", fmt.Errorf("missing %q in userinfo response", u.emailClaim)
+		return id, fmt.Errorf("missing %q in userinfo response", u.emailClaim)
 	}
-	return providerUserID, email, nil
+	id.Email = email
+	return id, nil
 }
 
 func (u *oauthUserInfo) requireVerifiedOnClaims(claims map[string]any, verifiedClaim string) error {
@@ -198,6 +222,26 @@ func claimString(claims map[string]any, key string) string {
 	}
 }
 
+// claimStrings reads a claim holding one value or a list of values, such as groups.
+func claimStrings(claims map[string]any, key string) []string {
+	var out []string
+	add := func(v any) {
+		if s := strings.TrimSpace(claimString(map[string]any{key: v}, key)); s != "" {
+			out = append(out, s)
+		}
+	}
+	switch v := claims[key].(type) {
+	case nil:
+	case []any:
+		for _, item := range v {
+			add(item)
+		}
+	default:
+		add(v)
+	}
+	return out
+}
+
 func claimBool(claims map[string]any, key string) bool {
 	v, ok := claims[key]
 	if !ok || v == nil {
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/JakeNeyer/ipam/server/config"
 	"golang.org/x/oauth2"
 )
 
@@ -147,3 +148,26 @@ func TestClaimBool(t *testing.T) {
 		t.Error("primary false = true")
 	}
 }
+
+func TestOAuthUserInfo_FetchIdentity_Groups(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"sub": "user-123", "email": "user@example.com", "email_verified": true,
+			"roles": []any{"ipam-admins", 42, ""}, "department": "network",
+		})
+	}))
+	defer srv.Close()
+
+	f := newOAuthUserInfoFromConfig(config.OAuthProviderConfig{UserInfoURL: srv.URL, GroupsClaim: "roles"}, srv.Client())
+	id, err := f.FetchIdentity(context.Background(), &oauth2.Token{AccessToken: "access-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id.Groups) != 2 || id.Groups[0] != "ipam-admins" || id.Groups[1] != "42" {
+		t.Errorf("Groups = %v", id.Groups)
+	}
+	f = newOAuthUserInfoFromConfig(config.OAuthProviderConfig{UserInfoURL: srv.URL, GroupsClaim: "department"}, srv.Client())
+	if id, _ := f.FetchIdentity(context.Background(), &oauth2.Token{}); len(id.Groups) != 1 || id.Groups[0] != "network" {
+		t.Errorf("single-valued claim Groups = %v", id.Groups)
+	}
+}
@@ -0,0 +1,260 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JakeNeyer/ipam/server/config"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+	// idTokenLeeway allows for clock skew between us and the issuer when checking exp and iat.
+	idTokenLeeway = time.Minute
+)
+
+// oidcDiscovery is the part of an OpenID Provider configuration document we use.
+type oidcDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserInfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// OIDCProvider is an OpenID Connect provider configured by issuer URL. Endpoints are loaded from the issuer's
+// discovery document on first use, and users are identified by the claims of a verified ID token.
+type OIDCProvider struct {
+	issuer   string
+	clientID string
+	pc       config.OAuthProviderConfig
+	client   *http.Client
+	now      func() time.Time
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      *keySet
+}
+
+// NewOIDCProvider returns a provider for pc.IssuerURL. Nothing is fetched until the provider is used.
+func NewOIDCProvider(pc config.OAuthProviderConfig, client *http.Client) *OIDCProvider {
+	return &OIDCProvider{
+		issuer:   strings.TrimSpace(pc.IssuerURL),
+		clientID: strings.TrimSpace(pc.ClientID),
+		pc:       pc,
+		client:   client,
+		now:      time.Now,
+	}
+}
+
+// discover returns the issuer's configuration, fetching it once. A failed fetch is retried on the next call.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	url := strings.TrimSuffix(p.issuer, "/") + oidcDiscoveryPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	// #nosec G704 -- URL is derived from the operator-configured issuer.
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxOAuthResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery %s: %s", url, resp.Status)
+	}
+	var doc oidcDiscovery
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	// The issuer in the document must be exactly the one configured (OpenID Connect Discovery 1.0, section 4.3).
+	if doc.Issuer != p.issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match configured %q", doc.Issuer, p.issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: authorization_endpoint, token_endpoint and jwks_uri are required")
+	}
+	p.discovery = &doc
+	p.keys = newKeySet(doc.JWKSURI, p.client, p.now)
+	return p.discovery, nil
+}
+
+// Endpoint returns the issuer's authorization and token endpoints.
+func (p *OIDCProvider) Endpoint(ctx context.Context) (oauth2.Endpoint, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return oauth2.Endpoint{}, err
+	}
+	return oauth2.Endpoint{AuthURL: doc.AuthorizationEndpoint, TokenURL: doc.TokenEndpoint}, nil
+}
+
+// VerifyIDToken checks the signature of a compact-serialized ID token against the issuer's keys and validates its
+// iss, aud, azp, exp, iat and nonce claims (OpenID Connect Core 1.0, section 3.1.3.7). It returns the token's claims.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (map[string]any, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id token is not a compact JWS")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	headerJSON, err := decodeSegment(parts[0])
+	if err != nil || json.Unmarshal(headerJSON, &header) != nil {
+		return nil, errors.New("invalid id token header")
+	}
+	if len(doc.SigningAlgs) > 0 && !slices.Contains(doc.SigningAlgs, header.Alg) {
+		return nil, fmt.Errorf("id token alg %q is not one the issuer advertises", header.Alg)
+	}
+	sig, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid id token signature encoding")
+	}
+	if err := p.keys.verify(ctx, header.Kid, header.Alg, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, fmt.Errorf("id token signature: %w", err)
+	}
+
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, errors.New("invalid id token payload")
+	}
+	var claims map[string]any
+	dec := json.NewDecoder(strings.NewReader(string(payload)))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, errors.New("invalid id token payload")
+	}
+	if err := p.validateClaims(claims, nonce); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) validateClaims(claims map[string]any, nonce string) error {
+	if iss := claimString(claims, "iss"); iss != p.issuer {
+		return fmt.Errorf("id token issuer %q does not match %q", iss, p.issuer)
+	}
+	var aud []string
+	switch v := claims["aud"].(type) {
+	case string:
+		aud = []string{v}
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+	}
+	if !slices.Contains(aud, p.clientID) {
+		return fmt.Errorf("id token audience %v does not include client %q", aud, p.clientID)
+	}
+	if azp := claimString(claims, "azp"); azp != "" && azp != p.clientID {
+		return fmt.Errorf("id token azp %q is not client %q", azp, p.clientID)
+	}
+	now := p.now()
+	exp, ok := claimTime(claims, "exp")
+	if !ok {
+		return errors.New("id token has no exp")
+	}
+	if now.After(exp.Add(idTokenLeeway)) {
+		return errors.New("id token expired")
+	}
+	if iat, ok := claimTime(claims, "iat"); ok && iat.After(now.Add(idTokenLeeway)) {
+		return errors.New("id token issued in the future")
+	}
+	if nonce == "" || claimString(claims, "nonce") != nonce {
+		return errors.New("id token nonce does not match")
+	}
+	if claimString(claims, "sub") == "" {
+		return errors.New("id token has no sub")
+	}
+	return nil
+}
+
+// Identify returns the user from the verified ID token in token; nonce is the one sent with the authorization
+// request. When the ID token carries no email, the email is read from the userinfo endpoint, which must report the
+// same subject. Groups always come from the ID token.
+func (p *OIDCProvider) Identify(ctx context.Context, token *oauth2.Token, nonce string) (*Identity, error) {
+	raw, _ := token.Extra("id_token").(string)
+	if raw == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+	claims, err := p.VerifyIDToken(ctx, raw, nonce)
+	if err != nil {
+		return nil, err
+	}
+	ui := newOAuthUserInfoFromConfig(p.pc, p.client)
+	id := &Identity{
+		ProviderUserID: strings.TrimSpace(claimString(claims, ui.userIDClaim)),
+		Email:          strings.TrimSpace(strings.ToLower(claimString(claims, ui.emailClaim))),
+		Groups:         claimStrings(claims, ui.groupsClaim),
+	}
+	if id.ProviderUserID == "" {
+		return nil, fmt.Errorf("missing %q in id token", ui.userIDClaim)
+	}
+	if id.Email != "" {
+		if ui.emailVerifiedClaim != "" && !claimBool(claims, ui.emailVerifiedClaim) {
+			return nil, fmt.Errorf("email not verified (%q is missing or false in id token)", ui.emailVerifiedClaim)
+		}
+		return id, nil
+	}
+
+	if ui.userInfoURL == "" {
+		doc, err := p.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ui.userInfoURL = doc.UserInfoEndpoint
+	}
+	if ui.userInfoURL == "" {
+		return nil, fmt.Errorf("missing %q in id token and issuer has no userinfo endpoint", ui.emailClaim)
+	}
+	info, err := ui.FetchIdentity(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if info.ProviderUserID != id.ProviderUserID {
+		return nil, errors.New("userinfo subject does not match id token")
+	}
+	id.Email = info.Email
+	return id, nil
+}
+
+// claimTime reads a NumericDate claim.
+func claimTime(claims map[string]any, key string) (time.Time, bool) {
+	switch v := claims[key].(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(int64(f), 0), true
+	case float64:
+		return time.Unix(int64(v), 0), true
+	}
+	return time.Time{}, false
+}
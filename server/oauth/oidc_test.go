@@ -0,0 +1,356 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JakeNeyer/ipam/server/config"
+	"golang.org/x/oauth2"
+)
+
+// mockIssuer is a minimal OpenID provider: discovery, a JWKS that can be rotated, and userinfo.
+type mockIssuer struct {
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	keys        map[string]crypto.Signer // kid -> key, all published
+	jwksFetches int
+	userinfo    map[string]any
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	m := &mockIssuer{keys: map[string]crypto.Signer{}}
+	m.addRSAKey(t, "k1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.srv.URL,
+			"authorization_endpoint":                m.srv.URL + "/authorize",
+			"token_endpoint":                        m.srv.URL + "/token",
+			"userinfo_endpoint":                     m.srv.URL + "/userinfo",
+			"jwks_uri":                              m.srv.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256", "ES256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.jwksFetches++
+		var keys []map[string]string
+		for kid, k := range m.keys {
+			switch pub := k.Public().(type) {
+			case *rsa.PublicKey:
+				keys = append(keys, map[string]string{
+					"kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+					"n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes()),
+				})
+			case *ecdsa.PublicKey:
+				raw, _ := pub.Bytes()
+				size := (len(raw) - 1) / 2
+				keys = append(keys, map[string]string{
+					"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(raw[1 : 1+size]), "y": b64(raw[1+size:]),
+				})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(m.userinfo)
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func (m *mockIssuer) addRSAKey(t *testing.T, kid string) {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.keys[kid] = k
+	m.mu.Unlock()
+}
+
+func (m *mockIssuer) addECKey(t *testing.T, kid string) {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.keys[kid] = k
+	m.mu.Unlock()
+}
+
+// rotate replaces every published key with a new RSA key kid.
+func (m *mockIssuer) rotate(t *testing.T, kid string) {
+	m.mu.Lock()
+	m.keys = map[string]crypto.Signer{}
+	m.mu.Unlock()
+	m.addRSAKey(t, kid)
+}
+
+func (m *mockIssuer) fetches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksFetches
+}
+
+// claims returns valid ID token claims for client "cid".
+func (m *mockIssuer) claims(nonce string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss": m.srv.URL, "aud": "cid", "sub": "user-1", "nonce": nonce,
+		"iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix(),
+		"email": "User@Example.com", "email_verified": true,
+	}
+}
+
+// sign signs claims with the published key kid.
+func (m *mockIssuer) sign(t *testing.T, kid string, claims map[string]any) string {
+	t.Helper()
+	m.mu.Lock()
+	key := m.keys[kid]
+	m.mu.Unlock()
+	return signJWT(t, key, kid, claims)
+}
+
+func signJWT(t *testing.T, key crypto.Signer, kid string, claims map[string]any) string {
+	t.Helper()
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return input + "." + b64(sig)
+}
+
+func (m *mockIssuer) provider() *OIDCProvider {
+	return NewOIDCProvider(config.OAuthProviderConfig{ClientID: "cid", ClientSecret: "s", IssuerURL: m.srv.URL}, m.srv.Client())
+}
+
+func TestOIDCProvider_Discovery(t *testing.T) {
+	m := newMockIssuer(t)
+	e, err := m.provider().Endpoint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.AuthURL != m.srv.URL+"/authorize" || e.TokenURL != m.srv.URL+"/token" {
+		t.Errorf("Endpoint = %+v", e)
+	}
+
+	wrong := NewOIDCProvider(config.OAuthProviderConfig{ClientID: "cid", IssuerURL: m.srv.URL + "/other"}, m.srv.Client())
+	if _, err := wrong.Endpoint(context.Background()); err == nil {
+		t.Error("discovery of a different issuer: want error")
+	}
+}
+
+func TestOIDCProvider_VerifyIDToken(t *testing.T) {
+	m := newMockIssuer(t)
+	m.addECKey(t, "ec1")
+	p := m.provider()
+	ctx := context.Background()
+
+	for _, kid := range []string{"k1", "ec1"} {
+		claims, err := p.VerifyIDToken(ctx, m.sign(t, kid, m.claims("n1")), "n1")
+		if err != nil {
+			t.Fatalf("%s: %v", kid, err)
+		}
+		if claimString(claims, "sub") != "user-1" {
+			t.Errorf("%s: claims = %v", kid, claims)
+		}
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr string
+	}{
+		{"wrong nonce", func() string { return m.sign(t, "k1", m.claims("other")) }, "nonce"},
+		{"wrong audience", func() string {
+			c := m.claims("n1")
+			c["aud"] = []string{"someone-else"}
+			return m.sign(t, "k1", c)
+		}, "audience"},
+		{"foreign azp", func() string {
+			c := m.claims("n1")
+			c["aud"], c["azp"] = []string{"cid", "api"}, "api"
+			return m.sign(t, "k1", c)
+		}, "azp"},
+		{"wrong issuer", func() string {
+			c := m.claims("n1")
+			c["iss"] = "https://evil.example"
+			return m.sign(t, "k1", c)
+		}, "issuer"},
+		{"expired", func() string {
+			c := m.claims("n1")
+			c["exp"] = time.Now().Add(-2 * time.Minute).Unix()
+			return m.sign(t, "k1", c)
+		}, "expired"},
+		{"issued in the future", func() string {
+			c := m.claims("n1")
+			c["iat"] = time.Now().Add(10 * time.Minute).Unix()
+			return m.sign(t, "k1", c)
+		}, "future"},
+		{"forged signature", func() string { return signJWT(t, other, "k1", m.claims("n1")) }, "signature"},
+		{"alg none", func() string {
+			h, _ := json.Marshal(map[string]string{"alg": "none"})
+			c, _ := json.Marshal(m.claims("n1"))
+			return b64(h) + "." + b64(c) + "."
+		}, "alg"},
+		{"not a jws", func() string { return "abc" }, "compact"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.VerifyIDToken(ctx, tt.token(), "n1")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCProvider_KeyRotation(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := p.VerifyIDToken(ctx, m.sign(t, "k1", m.claims("n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.VerifyIDToken(ctx, m.sign(t, "k1", m.claims("n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.fetches(); got != 1 {
+		t.Fatalf("jwks fetched %d times, want 1 (cached)", got)
+	}
+
+	// A new kid right after a fetch is not refetched; once the throttle passes it is, and the new key verifies.
+	m.rotate(t, "k2")
+	token := m.sign(t, "k2", m.claims("n"))
+	if _, err := p.VerifyIDToken(ctx, token, "n"); err == nil {
+		t.Fatal("unknown kid within refresh throttle: want error")
+	}
+	now = now.Add(jwksMinRefresh)
+	if _, err := p.VerifyIDToken(ctx, token, "n"); err != nil {
+		t.Fatalf("after rotation: %v", err)
+	}
+	if got := m.fetches(); got != 2 {
+		t.Errorf("jwks fetched %d times, want 2", got)
+	}
+
+	// Cached keys expire after jwksMaxAge even without an unknown kid.
+	now = now.Add(jwksMaxAge + time.Second)
+	later := m.claims("n")
+	later["iat"], later["exp"] = now.Unix(), now.Add(time.Minute).Unix()
+	if _, err := p.VerifyIDToken(ctx, m.sign(t, "k2", later), "n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.fetches(); got != 3 {
+		t.Errorf("jwks fetched %d times, want 3", got)
+	}
+}
+
+func TestOIDCProvider_Identify(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider()
+	ctx := context.Background()
+	withIDToken := func(raw string) *oauth2.Token {
+		return (&oauth2.Token{AccessToken: "at"}).WithExtra(map[string]any{"id_token": raw})
+	}
+
+	withGroups := m.claims("n")
+	withGroups["groups"] = []string{"ipam-admins", "everyone"}
+	id, err := p.Identify(ctx, withIDToken(m.sign(t, "k1", withGroups)), "n")
+	if err != nil || id.ProviderUserID != "user-1" || id.Email != "user@example.com" || len(id.Groups) != 2 || id.Groups[0] != "ipam-admins" {
+		t.Fatalf("Identify = %+v, %v", id, err)
+	}
+
+	unverified := m.claims("n")
+	unverified["email_verified"] = false
+	if _, err := p.Identify(ctx, withIDToken(m.sign(t, "k1", unverified)), "n"); err == nil || !strings.Contains(err.Error(), "not verified") {
+		t.Errorf("unverified email: err = %v", err)
+	}
+
+	// Without an email in the ID token, userinfo supplies it, but only for the same subject.
+	noEmail := m.claims("n")
+	delete(noEmail, "email")
+	delete(noEmail, "email_verified")
+	m.userinfo = map[string]any{"sub": "user-1", "email": "info@example.com", "email_verified": true}
+	if id, err := p.Identify(ctx, withIDToken(m.sign(t, "k1", noEmail)), "n"); err != nil || id.Email != "info@example.com" {
+		t.Errorf("userinfo fallback = %+v, %v", id, err)
+	}
+	m.userinfo["sub"] = "someone-else"
+	if _, err := p.Identify(ctx, withIDToken(m.sign(t, "k1", noEmail)), "n"); err == nil || !strings.Contains(err.Error(), "subject") {
+		t.Errorf("userinfo subject mismatch: err = %v", err)
+	}
+
+	if _, err := p.Identify(ctx, &oauth2.Token{AccessToken: "at"}, "n"); err == nil {
+		t.Error("token without id_token: want error")
+	}
+}
+
+func TestNewProviderRegistry_OIDC(t *testing.T) {
+	m := newMockIssuer(t)
+	cfg := &config.Config{OAuth: config.OAuthConfig{Providers: map[string]config.OAuthProviderConfig{
+		"sso": {ClientID: "cid", ClientSecret: "s", IssuerURL: m.srv.URL},
+	}}}
+	r, err := NewProviderRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.IsOIDC("sso") {
+		t.Fatal("IsOIDC(sso) = false")
+	}
+	r.oidc["sso"].client = m.srv.Client()
+	e, err := r.ResolveEndpoint(context.Background(), "sso")
+	if err != nil || e.TokenURL != m.srv.URL+"/token" {
+		t.Errorf("ResolveEndpoint = %+v, %v", e, err)
+	}
+	if _, err := r.ResolveEndpoint(context.Background(), "nope"); err != ErrUnknownProvider {
+		t.Errorf("ResolveEndpoint(nope) err = %v", err)
+	}
+}
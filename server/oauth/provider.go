@@ -2,16 +2,23 @@ package oauth
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/JakeNeyer/ipam/server/config"
 	"golang.org/x/oauth2"
 )
 
+// ErrUnknownProvider is returned for a provider id that is not registered.
+var ErrUnknownProvider = errors.New("unknown OAuth provider")
+
 type ProviderRegistry struct {
 	endpoints map[string]oauth2.Endpoint
 	userInfos map[string]UserInfoFetcher
 	clients   map[string]*http.Client
+	oidc      map[string]*OIDCProvider
+	saml      map[string]*SAMLProvider
 }
 
 type UserInfoFetcher interface {
@@ -23,10 +30,23 @@ func NewProviderRegistry(cfg *config.Config) (*ProviderRegistry, error) {
 		endpoints: make(map[string]oauth2.Endpoint),
 		userInfos: make(map[string]UserInfoFetcher),
 		clients:   make(map[string]*http.Client),
+		oidc:      make(map[string]*OIDCProvider),
+		saml:      make(map[string]*SAMLProvider),
 	}
-	if cfg == nil || cfg.OAuth.Providers == nil {
+	if cfg == nil {
 		return r, nil
 	}
+	for id, pc := range cfg.SAML.Providers {
+		id = config.NormalizeOAuthProviderID(id)
+		if !pc.Enabled() {
+			continue
+		}
+		p, err := NewSAMLProvider(id, pc, cfg.AppOrigin, &http.Client{Timeout: 15 * time.Second})
+		if err != nil {
+			return nil, err
+		}
+		r.saml[id] = p
+	}
 	for id, pc := range cfg.OAuth.Providers {
 		id = config.NormalizeOAuthProviderID(id)
 		if !pc.Enabled() {
@@ -38,6 +58,10 @@ func NewProviderRegistry(cfg *config.Config) (*ProviderRegistry, error) {
 			return nil, err
 		}
 
+		if pc.IsOIDC() {
+			r.RegisterOIDC(id, NewOIDCProvider(pc, httpClient), httpClient)
+			continue
+		}
 		r.Register(id, oauth2.Endpoint{
 			AuthURL:  pc.AuthURL,
 			TokenURL: pc.TokenURL,
@@ -56,11 +80,45 @@ func (r *ProviderRegistry) Register(providerID string, endpoint oauth2.Endpoint,
 	r.clients[providerID] = client
 }
 
+// RegisterOIDC adds a provider whose endpoints come from OIDC discovery.
+func (r *ProviderRegistry) RegisterOIDC(providerID string, p *OIDCProvider, client *http.Client) {
+	if r.oidc == nil {
+		r.oidc = make(map[string]*OIDCProvider)
+	}
+	r.oidc[providerID] = p
+	r.clients[providerID] = client
+}
+
+// SAML returns the SAML provider registered as providerID.
+func (r *ProviderRegistry) SAML(providerID string) (*SAMLProvider, bool) {
+	p, ok := r.saml[providerID]
+	return p, ok
+}
+
+// IsOIDC reports whether providerID was registered by OIDC issuer.
+func (r *ProviderRegistry) IsOIDC(providerID string) bool {
+	_, ok := r.oidc[providerID]
+	return ok
+}
+
+// Endpoint returns the statically configured endpoint of a provider. OIDC providers have none until discovery; use
+// ResolveEndpoint for them.
 func (r *ProviderRegistry) Endpoint(providerID string) (oauth2.Endpoint, bool) {
 	e, ok := r.endpoints[providerID]
 	return e, ok
 }
 
+// ResolveEndpoint returns the provider's endpoint, running OIDC discovery if it has not run yet.
+func (r *ProviderRegistry) ResolveEndpoint(ctx context.Context, providerID string) (oauth2.Endpoint, error) {
+	if p, ok := r.oidc[providerID]; ok {
+		return p.Endpoint(ctx)
+	}
+	if e, ok := r.endpoints[providerID]; ok {
+		return e, nil
+	}
+	return oauth2.Endpoint{}, ErrUnknownProvider
+}
+
 func (r *ProviderRegistry) UserInfo(ctx context.Context, providerID string, token *oauth2.Token) (providerUserID, email string, err error) {
 	f, ok := r.userInfos[providerID]
 	if !ok || f == nil {
@@ -68,3 +126,22 @@ func (r *ProviderRegistry) UserInfo(ctx context.Context, providerID string, toke
 	}
 	return f.FetchUser(ctx, token)
 }
+
+// Identify returns the user a completed authorization is for: from the verified ID token for OIDC providers (nonce is
+// the one sent with the authorization request), otherwise from the userinfo endpoint.
+func (r *ProviderRegistry) Identify(ctx context.Context, providerID string, token *oauth2.Token, nonce string) (*Identity, error) {
+	if p, ok := r.oidc[providerID]; ok {
+		return p.Identify(ctx, token, nonce)
+	}
+	f, ok := r.userInfos[providerID]
+	if !ok || f == nil {
+		return &Identity{}, nil
+	}
+	if u, ok := f.(interface {
+		FetchIdentity(context.Context, *oauth2.Token) (*Identity, error)
+	}); ok {
+		return u.FetchIdentity(ctx, token)
+	}
+	providerUserID, email, err := f.FetchUser(ctx, token)
+	return &Identity{ProviderUserID: providerUserID, Email: email}, err
+}
@@ -0,0 +1,282 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/crewjam/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	// samlMetadataMaxAge is how long fetched IdP metadata is used before it is fetched again, so that rotated IdP
+	// signing certificates are picked up.
+	samlMetadataMaxAge   = 24 * time.Hour
+	maxSAMLMetadataBytes = 1 << 20
+)
+
+// defaultSAMLEmailAttributes are tried in order when a provider does not name its email attribute: the LDAP mail
+// attribute by name and OID, and the claim type ADFS and Entra ID use.
+var defaultSAMLEmailAttributes = []string{
+	"email",
+	"mail",
+	"urn:oid:0.9.2342.19200300.100.1.3",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+}
+
+// SAMLProvider is this server acting as a SAML 2.0 service provider for one IdP. AuthnRequests are sent with the
+// HTTP-Redirect binding and signed with the SP key; responses are accepted with the HTTP-POST binding only.
+type SAMLProvider struct {
+	id      string
+	pc      config.SAMLProviderConfig
+	baseURL string // this server's external origin (APP_ORIGIN); SP metadata and ACS URLs are built from it
+	cert    tls.Certificate
+	client  *http.Client
+	now     func() time.Time
+
+	mu      sync.Mutex
+	idp     *saml.EntityDescriptor
+	fetched time.Time
+}
+
+// NewSAMLProvider loads the SP key pair and, when configured by file, the IdP metadata. Metadata configured by URL is
+// fetched on first use. baseURL is this server's external origin; the SP entity ID and baseURL are required so that
+// nothing the IdP is told or checked against comes from request headers.
+func NewSAMLProvider(id string, pc config.SAMLProviderConfig, baseURL string, client *http.Client) (*SAMLProvider, error) {
+	if strings.TrimSpace(pc.EntityID) == "" {
+		return nil, fmt.Errorf("saml provider %s: entity ID is required", id)
+	}
+	baseURL = strings.TrimSuffix(strings.TrimSpace(baseURL), "/")
+	if u, err := url.Parse(baseURL); baseURL == "" || err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("saml provider %s: APP_ORIGIN must be set to this server's external URL", id)
+	}
+	cert, err := tls.LoadX509KeyPair(pc.CertFile, pc.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("saml provider %s: load SP key pair: %w", id, err)
+	}
+	if _, ok := cert.PrivateKey.(*rsa.PrivateKey); !ok {
+		return nil, fmt.Errorf("saml provider %s: SP key must be RSA", id)
+	}
+	p := &SAMLProvider{id: id, pc: pc, baseURL: baseURL, cert: cert, client: client, now: time.Now}
+	if pc.IDPMetadataFile != "" {
+		data, err := os.ReadFile(pc.IDPMetadataFile)
+		if err != nil {
+			return nil, fmt.Errorf("saml provider %s: %w", id, err)
+		}
+		if p.idp, err = parseIDPMetadata(data); err != nil {
+			return nil, fmt.Errorf("saml provider %s: %w", id, err)
+		}
+	}
+	return p, nil
+}
+
+// parseIDPMetadata reads an EntityDescriptor, or the first IdP in an EntitiesDescriptor (federation metadata).
+func parseIDPMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	var ed saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &ed); err == nil && len(ed.IDPSSODescriptors) > 0 {
+		return &ed, nil
+	}
+	var eds saml.EntitiesDescriptor
+	if err := xml.Unmarshal(data, &eds); err != nil {
+		return nil, fmt.Errorf("parse IdP metadata: %w", err)
+	}
+	for i := range eds.EntityDescriptors {
+		if len(eds.EntityDescriptors[i].IDPSSODescriptors) > 0 {
+			return &eds.EntityDescriptors[i], nil
+		}
+	}
+	return nil, errors.New("IdP metadata has no IDPSSODescriptor")
+}
+
+// idpMetadata returns the IdP's metadata, fetching it when configured by URL and the cached copy is missing or older
+// than samlMetadataMaxAge. A failed refetch keeps using the cached copy.
+func (p *SAMLProvider) idpMetadata(ctx context.Context) (*saml.EntityDescriptor, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pc.IDPMetadataFile != "" || (p.idp != nil && p.now().Sub(p.fetched) < samlMetadataMaxAge) {
+		return p.idp, nil
+	}
+	idp, err := p.fetchMetadata(ctx)
+	if err != nil {
+		if p.idp != nil {
+			return p.idp, nil
+		}
+		return nil, err
+	}
+	p.idp = idp
+	p.fetched = p.now()
+	return idp, nil
+}
+
+func (p *SAMLProvider) fetchMetadata(ctx context.Context) (*saml.EntityDescriptor, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.pc.IDPMetadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G704 -- URL is the operator-configured IdP metadata URL.
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch IdP metadata: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSAMLMetadataBytes))
+	if err != nil {
+		return nil, fmt.Errorf("fetch IdP metadata: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch IdP metadata %s: %s", p.pc.IDPMetadataURL, resp.Status)
+	}
+	return parseIDPMetadata(data)
+}
+
+// serviceProvider returns the SP at p.baseURL. idp may be nil when only the SP's own metadata is needed.
+func (p *SAMLProvider) serviceProvider(idp *saml.EntityDescriptor) *saml.ServiceProvider {
+	prefix := p.baseURL + "/api/auth/saml/" + p.id
+	metadataURL, _ := url.Parse(prefix + "/metadata")
+	acsURL, _ := url.Parse(prefix + "/acs")
+	return &saml.ServiceProvider{
+		EntityID:          p.pc.EntityID,
+		Key:               p.cert.PrivateKey.(*rsa.PrivateKey),
+		Certificate:       p.cert.Leaf,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idp,
+		SignatureMethod:   dsig.RSASHA256SignatureMethod,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+		HTTPClient:        p.client,
+	}
+}
+
+// Metadata returns the SP metadata document.
+func (p *SAMLProvider) Metadata() ([]byte, error) {
+	md := p.serviceProvider(nil).Metadata()
+	// Only the HTTP-POST binding is accepted at the ACS; do not advertise artifact resolution.
+	for i := range md.SPSSODescriptors {
+		acs := md.SPSSODescriptors[i].AssertionConsumerServices
+		md.SPSSODescriptors[i].AssertionConsumerServices = slices.DeleteFunc(acs, func(e saml.IndexedEndpoint) bool {
+			return e.Binding != saml.HTTPPostBinding
+		})
+	}
+	out, err := xml.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// AuthnRequestURL returns the IdP URL to redirect the browser to with a signed AuthnRequest, and the request's ID, which
+// the response must be InResponseTo.
+func (p *SAMLProvider) AuthnRequestURL(ctx context.Context, relayState string) (string, string, error) {
+	idp, err := p.idpMetadata(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	sp := p.serviceProvider(idp)
+	dest := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if dest == "" {
+		return "", "", errors.New("IdP metadata has no HTTP-Redirect SingleSignOnService")
+	}
+	req, err := sp.MakeAuthenticationRequest(dest, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", "", err
+	}
+	u, err := req.Redirect(url.QueryEscape(relayState), sp)
+	if err != nil {
+		return "", "", err
+	}
+	return u.String(), req.ID, nil
+}
+
+// ParseResponse verifies a base64-encoded SAMLResponse posted to the ACS: the IdP's signature on the response or
+// assertion, the issuer, InResponseTo against requestID, the recipient, the validity window and the audience. It returns
+// the user the assertion is for.
+func (p *SAMLProvider) ParseResponse(ctx context.Context, samlResponse, requestID string) (*Identity, error) {
+	idp, err := p.idpMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return nil, errors.New("SAMLResponse is not base64")
+	}
+	sp := p.serviceProvider(idp)
+	assertion, err := sp.ParseXMLResponse(raw, []string{requestID}, sp.AcsURL)
+	if err != nil {
+		var ire *saml.InvalidResponseError
+		if errors.As(err, &ire) {
+			return nil, fmt.Errorf("invalid SAML response: %w", ire.PrivateErr)
+		}
+		return nil, err
+	}
+	return p.identity(assertion)
+}
+
+func (p *SAMLProvider) identity(a *saml.Assertion) (*Identity, error) {
+	var nameID *saml.NameID
+	if a.Subject != nil {
+		nameID = a.Subject.NameID
+	}
+	id := &Identity{}
+	emailAttrs := defaultSAMLEmailAttributes
+	if p.pc.EmailAttribute != "" {
+		emailAttrs = []string{p.pc.EmailAttribute}
+	}
+	for _, name := range emailAttrs {
+		if v := samlAttributeValues(a, name); len(v) > 0 {
+			id.Email = strings.TrimSpace(strings.ToLower(v[0]))
+			break
+		}
+	}
+	if id.Email == "" && p.pc.EmailAttribute == "" && nameID != nil && nameID.Format == string(saml.EmailAddressNameIDFormat) {
+		id.Email = strings.TrimSpace(strings.ToLower(nameID.Value))
+	}
+	if id.Email == "" {
+		return nil, fmt.Errorf("assertion has no email (attribute %s)", strings.Join(emailAttrs, ", "))
+	}
+	groupsAttr := p.pc.GroupsAttribute
+	if groupsAttr == "" {
+		groupsAttr = "groups"
+	}
+	id.Groups = samlAttributeValues(a, groupsAttr)
+
+	// A transient NameID is different at every sign-in, so it cannot identify an account; use the email instead.
+	if nameID != nil && nameID.Format != string(saml.TransientNameIDFormat) {
+		id.ProviderUserID = strings.TrimSpace(nameID.Value)
+	}
+	if id.ProviderUserID == "" {
+		id.ProviderUserID = id.Email
+	}
+	return id, nil
+}
+
+// samlAttributeValues returns the non-empty values of the attribute with the given Name or FriendlyName.
+func samlAttributeValues(a *saml.Assertion, name string) []string {
+	var out []string
+	for _, stmt := range a.AttributeStatements {
+		for _, attr := range stmt.Attributes {
+			if attr.Name != name && attr.FriendlyName != name {
+				continue
+			}
+			for _, v := range attr.Values {
+				if s := strings.TrimSpace(v.Value); s != "" {
+					out = append(out, s)
+				}
+			}
+		}
+	}
+	return out
+}
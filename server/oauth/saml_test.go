@@ -0,0 +1,427 @@
+package oauth
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JakeNeyer/ipam/server/config"
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const testSPBase = "https://ipam.example"
+
+// newTestKeyPair generates an RSA key and self-signed certificate, as an operator would with openssl.
+func newTestKeyPair(t *testing.T, cn string) (tls.Certificate, []byte, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair, certPEM, keyPEM
+}
+
+// testIdP is a SAML identity provider that signs assertions with a locally generated key.
+type testIdP struct {
+	entityID string
+	cert     tls.Certificate
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	cert, _, _ := newTestKeyPair(t, "idp.example")
+	return &testIdP{entityID: "https://idp.example/metadata", cert: cert}
+}
+
+func (idp *testIdP) metadata() []byte {
+	ed := saml.EntityDescriptor{
+		EntityID: idp.entityID,
+		IDPSSODescriptors: []saml.IDPSSODescriptor{{
+			SSODescriptor: saml.SSODescriptor{RoleDescriptor: saml.RoleDescriptor{
+				ProtocolSupportEnumeration: "urn:oasis:names:tc:SAML:2.0:protocol",
+				KeyDescriptors: []saml.KeyDescriptor{{Use: "signing", KeyInfo: saml.KeyInfo{X509Data: saml.X509Data{
+					X509Certificates: []saml.X509Certificate{{Data: base64.StdEncoding.EncodeToString(idp.cert.Leaf.Raw)}},
+				}}}},
+			}},
+			SingleSignOnServices: []saml.Endpoint{{Binding: saml.HTTPRedirectBinding, Location: "https://idp.example/sso"}},
+		}},
+	}
+	out, _ := xml.Marshal(ed)
+	return out
+}
+
+// assertionOpts describes the assertion to issue; zero values are filled in for a valid sign-in to provider "corp".
+type assertionOpts struct {
+	inResponseTo string
+	audience     string
+	recipient    string
+	issuer       string
+	notOnOrAfter time.Time
+	nameID       saml.NameID
+	attributes   map[string][]string
+	signer       *tls.Certificate
+	tamper       func(*etree.Element)
+}
+
+// response returns a base64 SAMLResponse with a signed assertion, as posted to the ACS.
+func (idp *testIdP) response(t *testing.T, o assertionOpts) string {
+	t.Helper()
+	now := time.Now()
+	if o.audience == "" {
+		o.audience = testSPBase + "/api/auth/saml/corp/metadata"
+	}
+	if o.recipient == "" {
+		o.recipient = testSPBase + "/api/auth/saml/corp/acs"
+	}
+	if o.issuer == "" {
+		o.issuer = idp.entityID
+	}
+	if o.notOnOrAfter.IsZero() {
+		o.notOnOrAfter = now.Add(5 * time.Minute)
+	}
+	if o.nameID.Value == "" {
+		o.nameID = saml.NameID{Format: string(saml.PersistentNameIDFormat), Value: "u-123"}
+	}
+	if o.signer == nil {
+		o.signer = &idp.cert
+	}
+	var attrs []saml.Attribute
+	for name, values := range o.attributes {
+		a := saml.Attribute{Name: name, NameFormat: "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"}
+		for _, v := range values {
+			a.Values = append(a.Values, saml.AttributeValue{Type: "xs:string", Value: v})
+		}
+		attrs = append(attrs, a)
+	}
+	assertion := &saml.Assertion{
+		ID:           "id-assertion-" + o.nameID.Value,
+		IssueInstant: now,
+		Version:      "2.0",
+		Issuer:       saml.Issuer{Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity", Value: o.issuer},
+		Subject: &saml.Subject{
+			NameID: &o.nameID,
+			SubjectConfirmations: []saml.SubjectConfirmation{{
+				Method: "urn:oasis:names:tc:SAML:2.0:cm:bearer",
+				SubjectConfirmationData: &saml.SubjectConfirmationData{
+					InResponseTo: o.inResponseTo,
+					NotOnOrAfter: o.notOnOrAfter,
+					Recipient:    o.recipient,
+				},
+			}},
+		},
+		Conditions: &saml.Conditions{
+			NotBefore:            now.Add(-time.Minute),
+			NotOnOrAfter:         o.notOnOrAfter,
+			AudienceRestrictions: []saml.AudienceRestriction{{Audience: saml.Audience{Value: o.audience}}},
+		},
+		AttributeStatements: []saml.AttributeStatement{{Attributes: attrs}},
+	}
+	ctx := dsig.NewDefaultSigningContext(dsig.TLSCertKeyStore(*o.signer))
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	if err := ctx.SetSignatureMethod(dsig.RSASHA256SignatureMethod); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := ctx.SignEnveloped(assertion.Element())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.tamper != nil {
+		o.tamper(signed)
+	}
+	resp := &saml.Response{
+		ID:           "id-response",
+		InResponseTo: o.inResponseTo,
+		Version:      "2.0",
+		IssueInstant: now,
+		Destination:  o.recipient,
+		Issuer:       &saml.Issuer{Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity", Value: o.issuer},
+		Status:       saml.Status{StatusCode: saml.StatusCode{Value: saml.StatusSuccess}},
+	}
+	respEl := resp.Element()
+	respEl.AddChild(signed)
+	doc := etree.NewDocument()
+	doc.SetRoot(respEl)
+	raw, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+// newTestSAMLProvider returns provider "corp" with a generated SP key pair, trusting idp via a metadata file.
+func newTestSAMLProvider(t *testing.T, idp *testIdP, pc config.SAMLProviderConfig) *SAMLProvider {
+	t.Helper()
+	dir := t.TempDir()
+	_, certPEM, keyPEM := newTestKeyPair(t, "ipam.example")
+	pc.CertFile = filepath.Join(dir, "sp.crt")
+	pc.KeyFile = filepath.Join(dir, "sp.key")
+	pc.IDPMetadataFile = filepath.Join(dir, "idp.xml")
+	if pc.EntityID == "" {
+		pc.EntityID = testSPBase + "/api/auth/saml/corp/metadata"
+	}
+	for name, data := range map[string][]byte{pc.CertFile: certPEM, pc.KeyFile: keyPEM, pc.IDPMetadataFile: idp.metadata()} {
+		if err := os.WriteFile(name, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := NewSAMLProvider("corp", pc, testSPBase, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestSAMLProvider_Metadata(t *testing.T) {
+	p := newTestSAMLProvider(t, newTestIdP(t), config.SAMLProviderConfig{})
+	raw, err := p.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var md saml.EntityDescriptor
+	if err := xml.Unmarshal(raw, &md); err != nil {
+		t.Fatalf("metadata does not parse: %v", err)
+	}
+	if md.EntityID != testSPBase+"/api/auth/saml/corp/metadata" {
+		t.Errorf("EntityID = %q", md.EntityID)
+	}
+	sp := md.SPSSODescriptors[0]
+	if sp.AuthnRequestsSigned == nil || !*sp.AuthnRequestsSigned || sp.WantAssertionsSigned == nil || !*sp.WantAssertionsSigned {
+		t.Error("metadata should declare signed AuthnRequests and want signed assertions")
+	}
+	if len(sp.AssertionConsumerServices) != 1 || sp.AssertionConsumerServices[0].Binding != saml.HTTPPostBinding ||
+		sp.AssertionConsumerServices[0].Location != testSPBase+"/api/auth/saml/corp/acs" {
+		t.Errorf("ACS = %+v, want only HTTP-POST at the acs path", sp.AssertionConsumerServices)
+	}
+	var signing bool
+	for _, kd := range sp.KeyDescriptors {
+		signing = signing || kd.Use == "signing"
+	}
+	if !signing {
+		t.Error("metadata has no signing key")
+	}
+
+	p.pc.EntityID = "urn:ipam:corp"
+	raw, _ = p.Metadata()
+	if !bytes.Contains(raw, []byte(`entityID="urn:ipam:corp"`)) {
+		t.Error("configured entity ID not used in metadata")
+	}
+}
+
+func TestNewSAMLProvider_RequiresEntityIDAndBaseURL(t *testing.T) {
+	pc := config.SAMLProviderConfig{EntityID: "urn:ipam:corp"}
+	for name, tc := range map[string]struct {
+		pc      config.SAMLProviderConfig
+		baseURL string
+	}{
+		"no entity ID": {config.SAMLProviderConfig{}, testSPBase},
+		"no base URL":  {pc, ""},
+		"relative":     {pc, "ipam.example"},
+	} {
+		if _, err := NewSAMLProvider("corp", tc.pc, tc.baseURL, http.DefaultClient); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
+
+func TestSAMLProvider_AuthnRequestIsSigned(t *testing.T) {
+	p := newTestSAMLProvider(t, newTestIdP(t), config.SAMLProviderConfig{})
+	authURL, requestID, err := p.AuthnRequestURL(context.Background(), "relay-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "idp.example" || u.Path != "/sso" {
+		t.Errorf("AuthnRequest sent to %s", authURL)
+	}
+	q := u.Query()
+	if q.Get("RelayState") != "relay-123" || q.Get("SigAlg") != dsig.RSASHA256SignatureMethod {
+		t.Errorf("query = %v", q)
+	}
+
+	// HTTP-Redirect binding: the signature covers the raw query up to &Signature.
+	signed := u.RawQuery[:strings.Index(u.RawQuery, "&Signature=")]
+	sig, err := base64.StdEncoding.DecodeString(q.Get("Signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(signed))
+	if err := rsa.VerifyPKCS1v15(p.cert.Leaf.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], sig); err != nil {
+		t.Errorf("AuthnRequest signature does not verify with the SP certificate: %v", err)
+	}
+
+	deflated, _ := base64.StdEncoding.DecodeString(q.Get("SAMLRequest"))
+	xmlReq, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req saml.AuthnRequest
+	if err := xml.Unmarshal(xmlReq, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.ID != requestID || req.AssertionConsumerServiceURL != testSPBase+"/api/auth/saml/corp/acs" {
+		t.Errorf("AuthnRequest = ID %q ACS %q, want ID %q", req.ID, req.AssertionConsumerServiceURL, requestID)
+	}
+}
+
+func TestSAMLProvider_ParseResponse(t *testing.T) {
+	idp := newTestIdP(t)
+	p := newTestSAMLProvider(t, idp, config.SAMLProviderConfig{})
+	ctx := context.Background()
+
+	id, err := p.ParseResponse(ctx, idp.response(t, assertionOpts{
+		inResponseTo: "req-1",
+		attributes:   map[string][]string{"mail": {"Alice@Example.com"}, "groups": {"ipam-admins", "staff"}},
+	}), "req-1")
+	if err != nil {
+		t.Fatalf("valid response rejected: %v", err)
+	}
+	if id.ProviderUserID != "u-123" || id.Email != "alice@example.com" || len(id.Groups) != 2 {
+		t.Errorf("identity = %+v", id)
+	}
+
+	other, _, _ := newTestKeyPair(t, "attacker.example")
+	cases := []struct {
+		name string
+		opts assertionOpts
+		req  string
+	}{
+		{"signed by another key", assertionOpts{signer: &other}, "req-1"},
+		{"tampered after signing", assertionOpts{tamper: func(el *etree.Element) {
+			el.FindElement("//AttributeValue").SetText("mallory@example.com")
+		}}, "req-1"},
+		{"wrong audience", assertionOpts{audience: "https://other-sp.example/metadata"}, "req-1"},
+		{"wrong recipient", assertionOpts{recipient: "https://other-sp.example/acs"}, "req-1"},
+		{"wrong issuer", assertionOpts{issuer: "https://evil.example"}, "req-1"},
+		{"expired", assertionOpts{notOnOrAfter: time.Now().Add(-10 * time.Minute)}, "req-1"},
+		{"not in response to our request", assertionOpts{inResponseTo: "req-other"}, "req-1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.opts.inResponseTo == "" {
+				tc.opts.inResponseTo = "req-1"
+			}
+			tc.opts.attributes = map[string][]string{"mail": {"alice@example.com"}}
+			if _, err := p.ParseResponse(ctx, idp.response(t, tc.opts), tc.req); err == nil {
+				t.Error("response accepted")
+			}
+		})
+	}
+}
+
+func TestSAMLProvider_EmailMapping(t *testing.T) {
+	idp := newTestIdP(t)
+	ctx := context.Background()
+
+	p := newTestSAMLProvider(t, idp, config.SAMLProviderConfig{EmailAttribute: "upn", GroupsAttribute: "memberOf"})
+	id, err := p.ParseResponse(ctx, idp.response(t, assertionOpts{
+		inResponseTo: "r",
+		attributes:   map[string][]string{"mail": {"wrong@example.com"}, "upn": {"bob@example.com"}, "memberOf": {"net-ops"}},
+	}), "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Email != "bob@example.com" || len(id.Groups) != 1 || id.Groups[0] != "net-ops" {
+		t.Errorf("identity = %+v", id)
+	}
+	if _, err := p.ParseResponse(ctx, idp.response(t, assertionOpts{
+		inResponseTo: "r", attributes: map[string][]string{"mail": {"bob@example.com"}},
+	}), "r"); err == nil {
+		t.Error("response without the configured email attribute accepted")
+	}
+
+	// With no attributes, an emailAddress NameID is the email. A transient NameID cannot identify the account.
+	p = newTestSAMLProvider(t, idp, config.SAMLProviderConfig{})
+	id, err = p.ParseResponse(ctx, idp.response(t, assertionOpts{
+		inResponseTo: "r",
+		nameID:       saml.NameID{Format: string(saml.EmailAddressNameIDFormat), Value: "carol@example.com"},
+	}), "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Email != "carol@example.com" || id.ProviderUserID != "carol@example.com" {
+		t.Errorf("identity = %+v", id)
+	}
+	id, err = p.ParseResponse(ctx, idp.response(t, assertionOpts{
+		inResponseTo: "r",
+		nameID:       saml.NameID{Format: string(saml.TransientNameIDFormat), Value: "_tmp42"},
+		attributes:   map[string][]string{"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress": {"dan@example.com"}},
+	}), "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Email != "dan@example.com" || id.ProviderUserID != "dan@example.com" {
+		t.Errorf("identity = %+v", id)
+	}
+}
+
+func TestSAMLProvider_MetadataURL(t *testing.T) {
+	idp := newTestIdP(t)
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write(idp.metadata())
+	}))
+	defer srv.Close()
+
+	p := newTestSAMLProvider(t, idp, config.SAMLProviderConfig{})
+	p.pc.IDPMetadataFile, p.pc.IDPMetadataURL, p.idp = "", srv.URL, nil
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	ctx := context.Background()
+	for range 2 {
+		if _, _, err := p.AuthnRequestURL(ctx, "r"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("metadata fetched %d times, want 1", fetches.Load())
+	}
+	now = now.Add(samlMetadataMaxAge + time.Minute)
+	if _, _, err := p.AuthnRequestURL(ctx, "r"); err != nil {
+		t.Fatal(err)
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("stale metadata not refetched (%d fetches)", fetches.Load())
+	}
+}
@@ -1,9 +1,14 @@
 package server
 
 import (
+	"fmt"
+	"net/netip"
+
 	"github.com/JakeNeyer/ipam/server/auth"
 	"github.com/JakeNeyer/ipam/server/config"
 	"github.com/JakeNeyer/ipam/server/handlers"
+	"github.com/JakeNeyer/ipam/server/ldapauth"
+	"github.com/JakeNeyer/ipam/server/mail"
 	"github.com/JakeNeyer/ipam/server/oauth"
 	"github.com/JakeNeyer/ipam/store"
 	"github.com/swaggest/openapi-go/openapi31"
@@ -20,8 +25,15 @@ func NewServer(s store.Storer, cfg *config.Config) (*web.Service, error) {
 	svc.OpenAPISchema().SetTitle("IPAM Service")
 	svc.OpenAPISchema().SetVersion("1.0.0")
 
+	var sessions config.SessionConfig
+	var rateLimits config.RateLimitConfig
+	if cfg != nil {
+		sessions = cfg.Session
+		rateLimits = cfg.RateLimit
+	}
 	svc.Wrap(
-		auth.Middleware(s),
+		auth.Middleware(s, sessions),
+		auth.RateLimitMiddleware(auth.NewRateLimiter(rateLimits, s)),
 		gzip.Middleware,
 	)
 
@@ -33,15 +45,35 @@ func NewServer(s store.Storer, cfg *config.Config) (*web.Service, error) {
 	svc.Post("/api/setup/status", postSetupUC)
 
 	svc.Handle("/api/signup/validate", handlers.ValidateSignupInviteHandler(s))
-	svc.Handle("/api/signup/register", handlers.RegisterWithInviteHandler(s))
+	svc.Handle("/api/signup/register", handlers.RegisterWithInviteHandler(s, cfg))
 
 	svc.Handle("/api/auth/config", handlers.AuthConfigHandler(cfg))
-	loginLimiter := auth.NewLoginAttemptLimiter(auth.DefaultLoginMaxAttempts, auth.DefaultLoginWindow)
-	loginUC := handlers.NewLoginUseCase(s, loginLimiter, cfg)
+	var trustedProxies []netip.Prefix
+	if cfg != nil {
+		trustedProxies = cfg.RateLimit.TrustedProxies
+	}
+	loginLimiter := auth.NewLoginAttemptLimiter(auth.DefaultLoginMaxAttempts, auth.DefaultLoginWindow, trustedProxies)
+	var directory *ldapauth.Authenticator
+	if cfg != nil && cfg.LDAP.Enabled() {
+		var err error
+		if directory, err = ldapauth.New(cfg.LDAP); err != nil {
+			return nil, err
+		}
+	}
+	loginUC := handlers.NewLoginUseCase(s, loginLimiter, cfg, directory)
 	svc.Post("/api/auth/login", loginUC)
+	svc.Post("/api/auth/login/mfa", handlers.NewLoginMFAUseCase(s, loginLimiter, cfg))
 	logoutUC := handlers.NewLogoutUseCase(s)
 	svc.Post("/api/auth/logout", logoutUC, nethttp.SuccessStatus(204))
-	if cfg != nil && len(cfg.EnabledOAuthProviders()) > 0 {
+
+	var sender mail.Sender
+	if cfg != nil {
+		sender = mail.New(cfg.Mail)
+	}
+	resetLimiter := auth.NewLoginAttemptLimiter(auth.DefaultLoginMaxAttempts, auth.DefaultLoginWindow, trustedProxies)
+	svc.Post("/api/auth/password-reset", handlers.NewRequestPasswordResetUseCase(s, sender, resetLimiter, cfg), nethttp.SuccessStatus(204))
+	svc.Post("/api/auth/password-reset/confirm", handlers.NewResetPasswordUseCase(s), nethttp.SuccessStatus(204))
+	if cfg != nil && len(cfg.EnabledOAuthProviders())+len(cfg.EnabledSAMLProviders()) > 0 {
 		registry, err := oauth.NewProviderRegistry(cfg)
 		if err != nil {
 			return nil, err
@@ -50,14 +82,38 @@ func NewServer(s store.Storer, cfg *config.Config) (*web.Service, error) {
 			svc.Handle("/api/auth/oauth/"+provider+"/start", handlers.OAuthStartHandler(cfg, registry))
 			svc.Handle("/api/auth/oauth/"+provider+"/callback", handlers.OAuthCallbackHandler(s, cfg, registry))
 		}
+		for _, provider := range cfg.EnabledSAMLProviders() {
+			svc.Handle("/api/auth/saml/"+provider+"/metadata", handlers.SAMLMetadataHandler(cfg, registry))
+			svc.Handle("/api/auth/saml/"+provider+"/start", handlers.SAMLStartHandler(cfg, registry))
+			svc.Handle("/api/auth/saml/"+provider+"/acs", handlers.SAMLACSHandler(s, cfg, registry))
+		}
 	}
 
-	meUC := handlers.NewMeUseCase()
+	if cfg != nil && cfg.SCIM.Enabled() {
+		if len(cfg.SCIM.Token) < config.MinSCIMTokenLength {
+			return nil, fmt.Errorf("SCIM_TOKEN must be at least %d characters", config.MinSCIMTokenLength)
+		}
+		svc.Handle("/scim/v2/*", handlers.SCIMHandler(s, cfg))
+	}
+
+	meUC := handlers.NewMeUseCase(s)
 	svc.Get("/api/auth/me", meUC)
 
 	tourCompletedUC := handlers.NewTourCompletedUseCase(s)
 	svc.Post("/api/auth/me/tour-completed", tourCompletedUC)
 
+	svc.Post("/api/auth/me/password", handlers.NewChangePasswordUseCase(s, loginLimiter), nethttp.SuccessStatus(204))
+
+	svc.Get("/api/auth/me/mfa", handlers.NewGetMFAStatusUseCase(s))
+	svc.Post("/api/auth/me/mfa/enroll", handlers.NewEnrollMFAUseCase(s))
+	svc.Post("/api/auth/me/mfa/verify", handlers.NewVerifyMFAUseCase(s))
+	svc.Post("/api/auth/me/mfa/recovery-codes", handlers.NewRegenerateRecoveryCodesUseCase(s))
+	svc.Post("/api/auth/me/mfa/disable", handlers.NewDisableMFAUseCase(s), nethttp.SuccessStatus(204))
+
+	svc.Get("/api/auth/me/sessions", handlers.NewListSessionsUseCase(s))
+	svc.Delete("/api/auth/me/sessions", handlers.NewRevokeOtherSessionsUseCase(s), nethttp.SuccessStatus(204))
+	svc.Delete("/api/auth/me/sessions/{id}", handlers.NewRevokeSessionUseCase(s), nethttp.SuccessStatus(204))
+
 	listTokensUC := handlers.NewListTokensUseCase(s)
 	svc.Get("/api/auth/me/tokens", listTokensUC)
 	createTokenUC := handlers.NewCreateTokenUseCase(s)
@@ -68,11 +124,21 @@ func NewServer(s store.Storer, cfg *config.Config) (*web.Service, error) {
 	svc.Handle("/api/admin/users", handlers.AdminUsersHandler(s, cfg))
 	svc.Handle("/api/admin/users/{id}/role", handlers.UpdateUserRoleHandler(s))
 	svc.Handle("/api/admin/users/{id}/organization", handlers.UpdateUserOrganizationHandler(s))
+	svc.Handle("/api/admin/users/{id}/mfa", handlers.ResetUserMFAHandler(s))
+	svc.Handle("/api/admin/users/{id}/sessions", handlers.RevokeUserSessionsHandler(s))
 	svc.Handle("/api/admin/users/{id}", handlers.DeleteUserHandler(s))
 	svc.Handle("/api/admin/organizations", handlers.AdminOrganizationsHandler(s))
 	svc.Handle("/api/admin/organizations/{id}", handlers.AdminOrganizationByIDHandler(s))
 	svc.Handle("/api/admin/signup-invites", handlers.AdminSignupInvitesHandler(s, cfg))
 	svc.Handle("/api/admin/signup-invites/{id}", handlers.RevokeSignupInviteHandler(s))
+	svc.Get("/api/admin/service-accounts", handlers.NewListServiceAccountsUseCase(s))
+	svc.Post("/api/admin/service-accounts", handlers.NewCreateServiceAccountUseCase(s))
+	svc.Put("/api/admin/service-accounts/{id}", handlers.NewUpdateServiceAccountUseCase(s))
+	svc.Delete("/api/admin/service-accounts/{id}", handlers.NewDeleteServiceAccountUseCase(s), nethttp.SuccessStatus(204))
+	svc.Get("/api/admin/service-accounts/{id}/tokens", handlers.NewListServiceAccountTokensUseCase(s))
+	svc.Post("/api/admin/service-accounts/{id}/tokens", handlers.NewCreateServiceAccountTokenUseCase(s))
+	svc.Post("/api/admin/service-accounts/{id}/tokens/{tokenId}/rotate", handlers.NewRotateServiceAccountTokenUseCase(s))
+	svc.Delete("/api/admin/service-accounts/{id}/tokens/{tokenId}", handlers.NewDeleteServiceAccountTokenUseCase(s), nethttp.SuccessStatus(204))
 
 	listReservedUC := handlers.NewListReservedBlocksUseCase(s)
 	svc.Get("/api/reserved-blocks", listReservedUC)
@@ -83,6 +149,13 @@ func NewServer(s store.Storer, cfg *config.Config) (*web.Service, error) {
 	deleteReservedUC := handlers.NewDeleteReservedBlockUseCase(s)
 	svc.Delete("/api/reserved-blocks/{id}", deleteReservedUC)
 
+	svc.Post("/api/blueprints", handlers.NewCreateBlueprintUseCase(s))
+	svc.Get("/api/blueprints", handlers.NewListBlueprintsUseCase(s))
+	svc.Get("/api/blueprints/{id}", handlers.NewGetBlueprintUseCase(s))
+	svc.Put("/api/blueprints/{id}", handlers.NewUpdateBlueprintUseCase(s))
+	svc.Delete("/api/blueprints/{id}", handlers.NewDeleteBlueprintUseCase(s))
+	svc.Post("/api/blueprints/{id}/instantiate", handlers.NewInstantiateBlueprintUseCase(s))
+
 	createEnvUC := handlers.NewCreateEnvironmentUseCase(s)
 	svc.Post("/api/environments", createEnvUC)
 
@@ -106,6 +179,8 @@ func NewServer(s store.Storer, cfg *config.Config) (*web.Service, error) {
 	svc.Get("/api/pools/{id}", getPoolUC)
 	suggestPoolBlockCIDRUC := handlers.NewSuggestPoolBlockCIDRUseCase(s)
 	svc.Get("/api/pools/{id}/suggest-block-cidr", suggestPoolBlockCIDRUC)
+	poolFreeSpaceUC := handlers.NewGetPoolFreeSpaceUseCase(s)
+	svc.Get("/api/pools/{id}/free", poolFreeSpaceUC)
 	updatePoolUC := handlers.NewUpdatePoolUseCase(s)
 	svc.Put("/api/pools/{id}", updatePoolUC)
 	deletePoolUC := handlers.NewDeletePoolUseCase(s)
@@ -145,12 +220,18 @@ func NewServer(s store.Storer, cfg *config.Config) (*web.Service, error) {
 	suggestBlockCIDRUC := handlers.NewSuggestBlockCIDRUseCase(s)
 	svc.Get("/api/blocks/{id}/suggest-cidr", suggestBlockCIDRUC)
 
+	blockFreeSpaceUC := handlers.NewGetBlockFreeSpaceUseCase(s)
+	svc.Get("/api/blocks/{id}/free", blockFreeSpaceUC)
+
 	createAllocUC := handlers.NewCreateAllocationUseCase(s)
 	svc.Post("/api/allocations", createAllocUC)
 
 	autoAllocUC := handlers.NewAutoAllocateUseCase(s)
 	svc.Post("/api/allocations/auto", autoAllocUC)
 
+	autoAllocBatchUC := handlers.NewAutoAllocateBatchUseCase(s)
+	svc.Post("/api/allocations/auto/batch", autoAllocBatchUC)
+
 	listAllocUC := handlers.NewListAllocationsUseCase(s)
 	svc.Get("/api/allocations", listAllocUC)
 
@@ -163,7 +244,35 @@ func NewServer(s store.Storer, cfg *config.Config) (*web.Service, error) {
 	deleteAllocUC := handlers.NewDeleteAllocationUseCase(s)
 	svc.Delete("/api/allocations/{id}", deleteAllocUC)
 
+	resizeBlockUC := handlers.NewResizeBlockUseCase(s)
+	svc.Post("/api/blocks/{id}/resize", resizeBlockUC)
+
+	splitBlockUC := handlers.NewSplitBlockUseCase(s)
+	svc.Post("/api/blocks/{id}/split", splitBlockUC)
+
+	mergeBlocksUC := handlers.NewMergeBlocksUseCase(s)
+	svc.Post("/api/blocks/merge", mergeBlocksUC)
+
+	moveBlockUC := handlers.NewMoveBlockUseCase(s)
+	svc.Post("/api/blocks/{id}/move", moveBlockUC)
+
+	moveAllocUC := handlers.NewMoveAllocationUseCase(s)
+	svc.Post("/api/allocations/{id}/move", moveAllocUC)
+
+	bulkUC := handlers.NewBulkUseCase(s)
+	svc.Post("/api/bulk", bulkUC)
+
+	importUC := handlers.NewImportUseCase(s)
+	svc.Post("/api/import", importUC)
+
+	overlapReportUC := handlers.NewOverlapReportUseCase(s)
+	svc.Get("/api/analysis/overlaps", overlapReportUC)
+
+	connectivityCheckUC := handlers.NewConnectivityCheckUseCase(s)
+	svc.Post("/api/analysis/connectivity", connectivityCheckUC)
+
 	svc.Method("GET", "/api/export/csv", handlers.ExportCSVHandler(s))
+	svc.Method("GET", "/api/export", handlers.ExportHandler(s))
 
 	svc.Docs("/docs", swgui.NewWithConfig(swguicfg.Config{
 		AppendHead: swaggerThemeCSS(),
@@ -0,0 +1,29 @@
+package store
+
+import (
+	"time"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/google/uuid"
+)
+
+// Blueprint is a reusable, organization-scoped network layout (pools, blocks and allocations by prefix length and
+// naming pattern) that can be instantiated into a parent pool.
+type Blueprint struct {
+	ID             uuid.UUID
+	OrganizationID uuid.UUID
+	Name           string
+	Description    string
+	Spec           network.BlueprintSpec
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type BlueprintStore interface {
+	// ListBlueprints returns blueprints ordered by name; all organizations when organizationID is nil.
+	ListBlueprints(organizationID *uuid.UUID) ([]*Blueprint, error)
+	CreateBlueprint(b *Blueprint) error
+	GetBlueprint(id uuid.UUID) (*Blueprint, error)
+	UpdateBlueprint(id uuid.UUID, b *Blueprint) error
+	DeleteBlueprint(id uuid.UUID) error
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/JakeNeyer/ipam/network"
+	"github.com/google/uuid"
+)
+
+// BulkChanges is a validated set of environment, pool, block, allocation and reserved block writes applied
+// all-or-nothing by ApplyBulk. Deletes run first, then updates, then creates (environments and pools before the
+// blocks they contain). SoftDelete* mark rows for deletion in the cloud on the next sync.
+//
+// Versions holds the RowVersions the changes were computed from. ApplyBulk fails with ErrBulkConflict when any of
+// those blocks, allocations or reserved blocks has been written or deleted since, so concurrent edits are never
+// silently overwritten. Scopes holds every set of rows the changes were validated against (e.g. an environment's
+// blocks for an overlap check); ApplyBulk fails with ErrBulkConflict when a row was added to, changed in or removed
+// from any of them, so a row inserted concurrently cannot slip past validation.
+type BulkChanges struct {
+	Versions              map[uuid.UUID]int64
+	Scopes                []BulkScope
+	DeleteAllocations     []uuid.UUID
+	SoftDeleteAllocations []uuid.UUID
+	DeleteBlocks          []uuid.UUID
+	SoftDeleteBlocks      []uuid.UUID
+	DeleteReservedBlocks  []uuid.UUID
+	UpdateReservedBlocks  []*ReservedBlock
+	UpdateBlocks          []*network.Block
+	UpdateAllocations     []*network.Allocation
+	CreateEnvironments    []*network.Environment
+	CreatePools           []*network.Pool
+	CreateReservedBlocks  []*ReservedBlock
+	CreateBlocks          []*network.Block
+	CreateAllocations     []*network.Allocation
+}
+
+// Empty reports whether c has no writes.
+func (c *BulkChanges) Empty() bool {
+	return len(c.DeleteAllocations)+len(c.SoftDeleteAllocations)+len(c.DeleteBlocks)+len(c.SoftDeleteBlocks)+
+		len(c.DeleteReservedBlocks)+len(c.UpdateReservedBlocks)+len(c.UpdateBlocks)+len(c.UpdateAllocations)+
+		len(c.CreateEnvironments)+len(c.CreatePools)+len(c.CreateReservedBlocks)+len(c.CreateBlocks)+len(c.CreateAllocations) == 0
+}
+
+// Kinds of BulkScope.
+const (
+	BulkScopeEnvironmentBlocks = "environment_blocks" // blocks in EnvironmentID
+	BulkScopeOrphanBlocks      = "orphan_blocks"      // blocks without an environment in OrganizationID
+	BulkScopeReservedBlocks    = "reserved_blocks"    // reserved blocks of OrganizationID
+	BulkScopeBlockAllocations  = "block_allocations"  // allocations of the blocks named BlockName in OrganizationID
+)
+
+// BulkScope is a set of rows read to validate a batch, with the version of each row when it was read.
+// OrganizationID is set for every kind; ApplyBulk serializes batches of the same organization on it.
+type BulkScope struct {
+	Kind           string
+	OrganizationID uuid.UUID
+	EnvironmentID  uuid.UUID
+	BlockName      string
+	Rows           map[uuid.UUID]int64
+}
+
+// ErrBulkConflict is returned by ApplyBulk when a row in BulkChanges.Versions or BulkChanges.Scopes changed after it
+// was read.
+var ErrBulkConflict = errors.New("changed since it was read; retry the request")
+
+type BulkStore interface {
+	// ApplyBulk applies every change or none of them.
+	ApplyBulk(c *BulkChanges) error
+	// RowVersions returns the version of each block, allocation and reserved block in ids; missing rows are left out.
+	// Every write to a row changes its version. Read versions before the rows themselves, so a write in between
+	// makes ApplyBulk fail instead of going unnoticed.
+	RowVersions(ids []uuid.UUID) (map[uuid.UUID]int64, error)
+	// BulkScopeRows returns the rows currently in sc (sc.Rows is ignored) with their versions. Like RowVersions,
+	// read it before the rows themselves.
+	BulkScopeRows(sc BulkScope) (map[uuid.UUID]int64, error)
+}
@@ -9,22 +9,51 @@ import (
 
 // CloudConnection is a per-organization link to a cloud provider (AWS, Azure, GCP).
 // Config holds provider-specific settings (e.g. role ARN, regions); no raw secrets.
+// CredentialsRef optionally names where the connection's credentials live ("env:<name>", "file:<name>",
+// "vault:<path>"); it is resolved at sync time by internal/secrets. Nil = provider's ambient credentials.
 // SyncIntervalMinutes: 0 = background sync disabled; 1–1440 = minutes between syncs. Default 5.
 // SyncMode: "read_only" = pull only; "read_write" = bi-directional (push allowed).
 // ConflictResolution: "cloud" = overwrite with cloud on pull; "ipam" = never overwrite existing IPAM resource on pull.
+// SyncPaused stops background sync until cleared; manual sync still runs.
+// ConsecutiveSyncFailures and SyncBackoffUntil are maintained by sync: background sync skips the connection
+// until SyncBackoffUntil, and both are cleared by the next successful sync.
 type CloudConnection struct {
-	ID                  uuid.UUID       `json:"id"`
-	OrganizationID      uuid.UUID       `json:"organization_id"`
-	Provider            string          `json:"provider"` // "aws", "azure", "gcp"
-	Name                string          `json:"name"`
-	Config              json.RawMessage `json:"config"`
-	CredentialsRef      *string         `json:"credentials_ref,omitempty"`
-	SyncIntervalMinutes int             `json:"sync_interval_minutes"` // 0 = off; default 5
-	SyncMode            string          `json:"sync_mode"`             // "read_only" | "read_write"; default "read_only"
-	ConflictResolution  string          `json:"conflict_resolution"`   // "cloud" | "ipam"; default "cloud"
-	LastSyncAt          *time.Time      `json:"last_sync_at,omitempty"`
-	LastSyncStatus      *string         `json:"last_sync_status,omitempty"`
-	LastSyncError       *string         `json:"last_sync_error,omitempty"`
-	CreatedAt           time.Time       `json:"created_at"`
-	UpdatedAt           time.Time       `json:"updated_at"`
+	ID                      uuid.UUID       `json:"id"`
+	OrganizationID          uuid.UUID       `json:"organization_id"`
+	Provider                string          `json:"provider"` // "aws", "azure", "gcp"
+	Name                    string          `json:"name"`
+	Config                  json.RawMessage `json:"config"`
+	CredentialsRef          *string         `json:"credentials_ref,omitempty"`
+	SyncIntervalMinutes     int             `json:"sync_interval_minutes"` // 0 = off; default 5
+	SyncMode                string          `json:"sync_mode"`             // "read_only" | "read_write"; default "read_only"
+	ConflictResolution      string          `json:"conflict_resolution"`   // "cloud" | "ipam"; default "cloud"
+	LastSyncAt              *time.Time      `json:"last_sync_at,omitempty"`
+	LastSyncStatus          *string         `json:"last_sync_status,omitempty"`
+	LastSyncError           *string         `json:"last_sync_error,omitempty"`
+	SyncPaused              bool            `json:"sync_paused"`
+	ConsecutiveSyncFailures int             `json:"consecutive_sync_failures"`
+	SyncBackoffUntil        *time.Time      `json:"sync_backoff_until,omitempty"`
+	CreatedAt               time.Time       `json:"created_at"`
+	UpdatedAt               time.Time       `json:"updated_at"`
+}
+
+// CloudConnectionSyncState is the part of a CloudConnection written by sync. UpdateCloudConnection
+// leaves it unchanged so settings edits and sync status updates do not overwrite each other.
+type CloudConnectionSyncState struct {
+	LastSyncAt              *time.Time
+	LastSyncStatus          *string
+	LastSyncError           *string
+	ConsecutiveSyncFailures int
+	SyncBackoffUntil        *time.Time
+}
+
+// SyncState returns c's current sync state.
+func (c *CloudConnection) SyncState() CloudConnectionSyncState {
+	return CloudConnectionSyncState{
+		LastSyncAt:              c.LastSyncAt,
+		LastSyncStatus:          c.LastSyncStatus,
+		LastSyncError:           c.LastSyncError,
+		ConsecutiveSyncFailures: c.ConsecutiveSyncFailures,
+		SyncBackoffUntil:        c.SyncBackoffUntil,
+	}
 }
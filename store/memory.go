@@ -1,11 +1,15 @@
 package store
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"maps"
+	"math/big"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -21,6 +25,9 @@ const apiTokenSecretBytes = 32
 const signupInviteTokenPrefix = "invite_"
 const signupInviteSecretBytes = 32
 
+const passwordResetTokenPrefix = "reset_"
+const passwordResetSecretBytes = 32
+
 // Store manages all IPAM data
 type Store struct {
 	organizations    map[uuid.UUID]*Organization
@@ -30,14 +37,27 @@ type Store struct {
 	allocations      map[uuid.UUID]*network.Allocation
 	reservedBlocks   map[uuid.UUID]*ReservedBlock
 	cloudConnections map[uuid.UUID]*CloudConnection
+	blueprints       map[uuid.UUID]*Blueprint
+	scimGroups       map[uuid.UUID]*SCIMGroup
 	users            map[uuid.UUID]*User
 	usersByEmail     map[string]uuid.UUID
 	sessions         map[string]*Session
+	mfaChallenges    map[string]*MFAChallenge
+	recoveryCodes    map[uuid.UUID][]string // user id -> code hashes
+	totpLastStep     map[uuid.UUID]int64
 	tokens           map[uuid.UUID]*APIToken
 	tokenByHash      map[string]uuid.UUID
+	serviceAccounts  map[uuid.UUID]*ServiceAccount
 	signupInvites    map[uuid.UUID]*SignupInvite
 	inviteByHash     map[string]uuid.UUID
+	passwordResets   map[uuid.UUID]*PasswordReset
+	resetByHash      map[string]uuid.UUID
 	mu               sync.RWMutex
+	syncLocksMu      sync.Mutex
+	syncLocks        map[uuid.UUID]bool    // connections currently held by WithSyncLock
+	usedByBlock      map[string][]blockUse // allocations per lowercased block name; nil until built or after allocations change
+	rateLimits       *TokenBuckets
+	rowVersions      map[uuid.UUID]int64 // writes per block, allocation and reserved block; see RowVersions
 }
 
 // NewStore creates a new store
@@ -52,11 +72,22 @@ func NewStore() *Store {
 		users:            make(map[uuid.UUID]*User),
 		usersByEmail:     make(map[string]uuid.UUID),
 		sessions:         make(map[string]*Session),
+		mfaChallenges:    make(map[string]*MFAChallenge),
+		recoveryCodes:    make(map[uuid.UUID][]string),
+		totpLastStep:     make(map[uuid.UUID]int64),
 		tokens:           make(map[uuid.UUID]*APIToken),
 		tokenByHash:      make(map[string]uuid.UUID),
+		serviceAccounts:  make(map[uuid.UUID]*ServiceAccount),
 		signupInvites:    make(map[uuid.UUID]*SignupInvite),
 		inviteByHash:     make(map[string]uuid.UUID),
+		passwordResets:   make(map[uuid.UUID]*PasswordReset),
+		resetByHash:      make(map[string]uuid.UUID),
 		cloudConnections: make(map[uuid.UUID]*CloudConnection),
+		blueprints:       make(map[uuid.UUID]*Blueprint),
+		scimGroups:       make(map[uuid.UUID]*SCIMGroup),
+		syncLocks:        make(map[uuid.UUID]bool),
+		rateLimits:       NewTokenBuckets(),
+		rowVersions:      make(map[uuid.UUID]int64),
 	}
 }
 
@@ -108,16 +139,18 @@ func (s *Store) UpdateOrganization(org *Organization) error {
 		return fmt.Errorf("organization not found")
 	}
 	existing.Name = org.Name
+	existing.RequireMFA = org.RequireMFA
 	return nil
 }
 
 func (s *Store) DeleteOrganization(id uuid.UUID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.usedByBlock = nil
 	if _, exists := s.organizations[id]; !exists {
 		return fmt.Errorf("organization not found")
 	}
-	// Cascade: environments (and their blocks, allocations) → reserved blocks → signup invites → api tokens → users → organization
+	// Cascade: environments (and their blocks, allocations) → reserved blocks → signup invites → service accounts → api tokens → users → organization
 	var orgEnvIDs []uuid.UUID
 	for _, env := range s.environments {
 		if env.OrganizationID == id {
@@ -182,6 +215,11 @@ func (s *Store) DeleteOrganization(id uuid.UUID) error {
 	for _, cid := range connIDsToDelete {
 		delete(s.cloudConnections, cid)
 	}
+	for bid, b := range s.blueprints {
+		if b.OrganizationID == id {
+			delete(s.blueprints, bid)
+		}
+	}
 	var inviteIDsToDelete []uuid.UUID
 	for invID, inv := range s.signupInvites {
 		if inv != nil && inv.OrganizationID == id {
@@ -194,6 +232,11 @@ func (s *Store) DeleteOrganization(id uuid.UUID) error {
 		}
 		delete(s.signupInvites, invID)
 	}
+	for saID, sa := range s.serviceAccounts {
+		if sa.OrganizationID == id {
+			s.deleteServiceAccount(saID)
+		}
+	}
 	var orgUserIDs []uuid.UUID
 	for uid, u := range s.users {
 		if u.OrganizationID == id {
@@ -230,6 +273,11 @@ func (s *Store) DeleteOrganization(id uuid.UUID) error {
 		if u != nil {
 			delete(s.usersByEmail, strings.ToLower(strings.TrimSpace(u.Email)))
 		}
+		s.forgetUserMFA(uid)
+		s.deletePasswordResets(uid, true)
+		for _, g := range s.scimGroups {
+			g.Members = slices.DeleteFunc(g.Members, func(id uuid.UUID) bool { return id == uid })
+		}
 		delete(s.users, uid)
 	}
 	delete(s.organizations, id)
@@ -300,6 +348,7 @@ func (s *Store) UpdateEnvironment(id uuid.UUID, env *network.Environment) error
 func (s *Store) DeleteEnvironment(id uuid.UUID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.usedByBlock = nil
 	if _, exists := s.environments[id]; !exists {
 		return fmt.Errorf("environment not found")
 	}
@@ -500,15 +549,31 @@ func (s *Store) ListCloudConnections() ([]*CloudConnection, error) {
 func (s *Store) UpdateCloudConnection(id uuid.UUID, c *CloudConnection) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, exists := s.cloudConnections[id]; !exists {
+	existing, exists := s.cloudConnections[id]
+	if !exists {
 		return fmt.Errorf("cloud connection not found")
 	}
+	c.LastSyncAt, c.LastSyncStatus, c.LastSyncError = existing.LastSyncAt, existing.LastSyncStatus, existing.LastSyncError
+	c.ConsecutiveSyncFailures, c.SyncBackoffUntil = existing.ConsecutiveSyncFailures, existing.SyncBackoffUntil
 	c.UpdatedAt = time.Now()
 	c.ID = id
 	s.cloudConnections[id] = c
 	return nil
 }
 
+func (s *Store) UpdateCloudConnectionSyncState(id uuid.UUID, st CloudConnectionSyncState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, exists := s.cloudConnections[id]
+	if !exists {
+		return fmt.Errorf("cloud connection not found")
+	}
+	c.LastSyncAt, c.LastSyncStatus, c.LastSyncError = st.LastSyncAt, st.LastSyncStatus, st.LastSyncError
+	c.ConsecutiveSyncFailures, c.SyncBackoffUntil = st.ConsecutiveSyncFailures, st.SyncBackoffUntil
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
 func (s *Store) DeleteCloudConnection(id uuid.UUID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -519,13 +584,37 @@ func (s *Store) DeleteCloudConnection(id uuid.UUID) error {
 	return nil
 }
 
+// WithSyncLock mirrors the Postgres advisory lock within this process: if connectionID is already
+// being synced, it returns (false, nil) without running fn.
 func (s *Store) WithSyncLock(ctx context.Context, connectionID uuid.UUID, fn func() error) (acquired bool, err error) {
-	_ = ctx
-	_ = connectionID
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.syncLocksMu.Lock()
+	if s.syncLocks[connectionID] {
+		s.syncLocksMu.Unlock()
+		return false, nil
+	}
+	s.syncLocks[connectionID] = true
+	s.syncLocksMu.Unlock()
+	defer func() {
+		s.syncLocksMu.Lock()
+		delete(s.syncLocks, connectionID)
+		s.syncLocksMu.Unlock()
+	}()
 	err = fn()
 	return true, err
 }
 
+// TakeRateLimitToken keeps rate limit buckets in this process; they are not shared with other replicas.
+func (s *Store) TakeRateLimitToken(key string, perSecond float64, burst int) (bool, float64, error) {
+	return s.rateLimits.TakeRateLimitToken(key, perSecond, burst)
+}
+
+func (s *Store) DeleteIdleRateLimitBuckets(idle time.Duration) error {
+	return s.rateLimits.DeleteIdleRateLimitBuckets(idle)
+}
+
 // Block operations
 func (s *Store) CreateBlock(block *network.Block) error {
 	s.mu.Lock()
@@ -651,6 +740,7 @@ func (s *Store) UpdateBlock(id uuid.UUID, block *network.Block) error {
 		return fmt.Errorf("block not found")
 	}
 	s.blocks[id] = block
+	s.rowVersions[id]++
 	return nil
 }
 
@@ -673,6 +763,7 @@ func (s *Store) SoftDeleteBlock(id uuid.UUID) error {
 	}
 	t := time.Now()
 	block.DeletedAt = &t
+	s.rowVersions[id]++
 	return nil
 }
 
@@ -755,6 +846,7 @@ func (s *Store) ListBlocksFilteredIncludingDeleted(name string, environmentID *u
 func (s *Store) CreateAllocation(id uuid.UUID, alloc *network.Allocation) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.usedByBlock = nil
 	s.allocations[id] = alloc
 	return nil
 }
@@ -851,19 +943,115 @@ func (s *Store) ListAllocationsFiltered(name string, blockName string, environme
 	return matched[offset:end], total, nil
 }
 
+// ListAllocationsAfter returns up to limit allocations with ID greater than after, ordered by ID.
+func (s *Store) ListAllocationsAfter(organizationID *uuid.UUID, after uuid.UUID, limit int) ([]*network.Allocation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var blockNamesOK map[string]bool
+	if organizationID != nil {
+		blockNamesOK = make(map[string]bool)
+		for _, block := range s.blocks {
+			if block.DeletedAt != nil {
+				continue
+			}
+			orgID := block.OrganizationID
+			if block.EnvironmentID != uuid.Nil {
+				env, exists := s.environments[block.EnvironmentID]
+				if !exists {
+					continue
+				}
+				orgID = env.OrganizationID
+			}
+			if orgID != *organizationID {
+				continue
+			}
+			blockNamesOK[strings.ToLower(strings.TrimSpace(block.Name))] = true
+		}
+	}
+	var ids []uuid.UUID
+	for id, alloc := range s.allocations {
+		if alloc.DeletedAt != nil || bytes.Compare(id[:], after[:]) <= 0 {
+			continue
+		}
+		if blockNamesOK != nil && !blockNamesOK[strings.ToLower(strings.TrimSpace(alloc.Block.Name))] {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	out := make([]*network.Allocation, len(ids))
+	for i, id := range ids {
+		out[i] = s.allocations[id]
+	}
+	return out, nil
+}
+
+// blockUse is one allocation in the usedByBlock index.
+type blockUse struct {
+	cidr  string
+	count *big.Int
+}
+
+// BlockUsedAddresses returns the allocated address count of each block from an index of allocations by block name.
+// The index is built on first use and dropped whenever allocations change.
+func (s *Store) BlockUsedAddresses(blocks []*network.Block) (map[uuid.UUID]*big.Int, error) {
+	s.mu.RLock()
+	index := s.usedByBlock
+	s.mu.RUnlock()
+	if index == nil {
+		s.mu.Lock()
+		if s.usedByBlock == nil {
+			s.usedByBlock = make(map[string][]blockUse)
+			for _, alloc := range s.allocations {
+				if alloc.DeletedAt != nil {
+					continue
+				}
+				count, err := network.CIDRAddressCount(alloc.Block.CIDR)
+				if err != nil {
+					continue
+				}
+				key := strings.ToLower(strings.TrimSpace(alloc.Block.Name))
+				s.usedByBlock[key] = append(s.usedByBlock[key], blockUse{cidr: alloc.Block.CIDR, count: count})
+			}
+		}
+		index = s.usedByBlock
+		s.mu.Unlock()
+	}
+	out := make(map[uuid.UUID]*big.Int, len(blocks))
+	for _, b := range blocks {
+		for _, use := range index[strings.ToLower(strings.TrimSpace(b.Name))] {
+			if ok, err := network.Contains(b.CIDR, use.cidr); err != nil || !ok {
+				continue
+			}
+			if sum, ok := out[b.ID]; ok {
+				sum.Add(sum, use.count)
+			} else {
+				out[b.ID] = new(big.Int).Set(use.count)
+			}
+		}
+	}
+	return out, nil
+}
+
 func (s *Store) UpdateAllocation(id uuid.UUID, alloc *network.Allocation) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.usedByBlock = nil
 	if _, exists := s.allocations[id]; !exists {
 		return fmt.Errorf("allocation not found")
 	}
 	s.allocations[id] = alloc
+	s.rowVersions[id]++
 	return nil
 }
 
 func (s *Store) DeleteAllocation(id uuid.UUID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.usedByBlock = nil
 	if _, exists := s.allocations[id]; !exists {
 		return fmt.Errorf("allocation not found")
 	}
@@ -874,12 +1062,14 @@ func (s *Store) DeleteAllocation(id uuid.UUID) error {
 func (s *Store) SoftDeleteAllocation(id uuid.UUID) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.usedByBlock = nil
 	alloc, exists := s.allocations[id]
 	if !exists {
 		return fmt.Errorf("allocation not found")
 	}
 	t := time.Now()
 	alloc.DeletedAt = &t
+	s.rowVersions[id]++
 	return nil
 }
 
@@ -1014,6 +1204,7 @@ func (s *Store) UpdateReservedBlock(id uuid.UUID, r *ReservedBlock) error {
 		return fmt.Errorf("reserved block not found")
 	}
 	s.reservedBlocks[id] = r
+	s.rowVersions[id]++
 	return nil
 }
 
@@ -1045,6 +1236,169 @@ func (s *Store) OverlapsReservedBlock(cidr string, organizationID *uuid.UUID) (*
 	return nil, nil
 }
 
+// Blueprint operations. Names are unique per organization, ignoring case.
+func (s *Store) ListBlueprints(organizationID *uuid.UUID) ([]*Blueprint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []*Blueprint
+	for _, b := range s.blueprints {
+		if organizationID != nil && b.OrganizationID != *organizationID {
+			continue
+		}
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool { return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name) })
+	return out, nil
+}
+
+// blueprintNameTaken reports whether another blueprint in b's organization has b's name. Caller holds s.mu.
+func (s *Store) blueprintNameTaken(b *Blueprint) bool {
+	for _, other := range s.blueprints {
+		if other.ID != b.ID && other.OrganizationID == b.OrganizationID && strings.EqualFold(strings.TrimSpace(other.Name), strings.TrimSpace(b.Name)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) CreateBlueprint(b *Blueprint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.ID == uuid.Nil {
+		b.ID = s.GenerateID()
+	}
+	if s.blueprintNameTaken(b) {
+		return fmt.Errorf("blueprint name already exists")
+	}
+	if b.CreatedAt.IsZero() {
+		b.CreatedAt = time.Now()
+	}
+	b.UpdatedAt = b.CreatedAt
+	s.blueprints[b.ID] = b
+	return nil
+}
+
+func (s *Store) GetBlueprint(id uuid.UUID) (*Blueprint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, exists := s.blueprints[id]
+	if !exists {
+		return nil, fmt.Errorf("blueprint not found")
+	}
+	return b, nil
+}
+
+func (s *Store) UpdateBlueprint(id uuid.UUID, b *Blueprint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.blueprints[id]; !exists {
+		return fmt.Errorf("blueprint not found")
+	}
+	b.ID = id
+	if s.blueprintNameTaken(b) {
+		return fmt.Errorf("blueprint name already exists")
+	}
+	b.UpdatedAt = time.Now()
+	s.blueprints[id] = b
+	return nil
+}
+
+func (s *Store) DeleteBlueprint(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.blueprints[id]; !exists {
+		return fmt.Errorf("blueprint not found")
+	}
+	delete(s.blueprints, id)
+	return nil
+}
+
+// SCIM group operations. Display names are unique, ignoring case.
+func (s *Store) ListSCIMGroups() ([]*SCIMGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]*SCIMGroup, 0, len(s.scimGroups))
+	for _, g := range s.scimGroups {
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool { return strings.ToLower(out[i].DisplayName) < strings.ToLower(out[j].DisplayName) })
+	return out, nil
+}
+
+// checkSCIMGroup rejects a duplicate display name or an unknown member, and drops repeated members. Caller holds s.mu.
+func (s *Store) checkSCIMGroup(g *SCIMGroup) error {
+	for _, other := range s.scimGroups {
+		if other.ID != g.ID && strings.EqualFold(strings.TrimSpace(other.DisplayName), strings.TrimSpace(g.DisplayName)) {
+			return fmt.Errorf("scim group name already exists")
+		}
+	}
+	members := make([]uuid.UUID, 0, len(g.Members))
+	for _, id := range g.Members {
+		if _, exists := s.users[id]; !exists {
+			return fmt.Errorf("user not found")
+		}
+		if !slices.Contains(members, id) {
+			members = append(members, id)
+		}
+	}
+	g.Members = members
+	return nil
+}
+
+func (s *Store) CreateSCIMGroup(g *SCIMGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g.ID == uuid.Nil {
+		g.ID = s.GenerateID()
+	}
+	if err := s.checkSCIMGroup(g); err != nil {
+		return err
+	}
+	if g.CreatedAt.IsZero() {
+		g.CreatedAt = time.Now()
+	}
+	g.UpdatedAt = g.CreatedAt
+	s.scimGroups[g.ID] = g
+	return nil
+}
+
+func (s *Store) GetSCIMGroup(id uuid.UUID) (*SCIMGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g, exists := s.scimGroups[id]
+	if !exists {
+		return nil, fmt.Errorf("scim group not found")
+	}
+	return g, nil
+}
+
+func (s *Store) UpdateSCIMGroup(id uuid.UUID, g *SCIMGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, exists := s.scimGroups[id]
+	if !exists {
+		return fmt.Errorf("scim group not found")
+	}
+	g.ID = id
+	if err := s.checkSCIMGroup(g); err != nil {
+		return err
+	}
+	g.CreatedAt = existing.CreatedAt
+	g.UpdatedAt = time.Now()
+	s.scimGroups[id] = g
+	return nil
+}
+
+func (s *Store) DeleteSCIMGroup(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.scimGroups[id]; !exists {
+		return fmt.Errorf("scim group not found")
+	}
+	delete(s.scimGroups, id)
+	return nil
+}
+
 // User operations
 func (s *Store) CreateUser(u *User) error {
 	s.mu.Lock()
@@ -1133,18 +1487,13 @@ func (s *Store) DeleteUser(userID uuid.UUID) error {
 	delete(s.users, userID)
 	delete(s.usersByEmail, strings.ToLower(strings.TrimSpace(u.Email)))
 
-	for sid, sess := range s.sessions {
-		if sess != nil && sess.UserID == userID {
-			delete(s.sessions, sid)
-		}
-	}
+	s.revokeUserCredentials(userID)
 
-	for tokenID, tok := range s.tokens {
-		if tok != nil && tok.UserID == userID {
-			delete(s.tokenByHash, tok.KeyHash)
-			delete(s.tokens, tokenID)
-		}
+	for _, g := range s.scimGroups {
+		g.Members = slices.DeleteFunc(g.Members, func(id uuid.UUID) bool { return id == userID })
 	}
+	s.forgetUserMFA(userID)
+	s.deletePasswordResets(userID, true)
 
 	for inviteID, inv := range s.signupInvites {
 		if inv == nil {
@@ -1172,7 +1521,10 @@ func (s *Store) SetUserRole(userID uuid.UUID, role string) error {
 	if role != RoleUser && role != RoleAdmin {
 		return fmt.Errorf("invalid role")
 	}
-	u.Role = role
+	if u.Role != role {
+		u.Role = role
+		s.revokeUserCredentials(userID)
+	}
 	return nil
 }
 
@@ -1183,10 +1535,71 @@ func (s *Store) SetUserOrganization(userID uuid.UUID, organizationID uuid.UUID)
 	if !exists {
 		return fmt.Errorf("user not found")
 	}
-	u.OrganizationID = organizationID
+	if u.OrganizationID != organizationID {
+		u.OrganizationID = organizationID
+		s.revokeUserCredentials(userID)
+	}
+	return nil
+}
+
+func (s *Store) SetUserEmail(userID uuid.UUID, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, exists := s.users[userID]
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
+	newKey := strings.ToLower(strings.TrimSpace(email))
+	if other, taken := s.usersByEmail[newKey]; taken && other != userID {
+		return fmt.Errorf("user with email already exists")
+	}
+	delete(s.usersByEmail, strings.ToLower(strings.TrimSpace(u.Email)))
+	u.Email = strings.TrimSpace(email)
+	s.usersByEmail[newKey] = userID
+	return nil
+}
+
+func (s *Store) SetUserExternalID(userID uuid.UUID, externalID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, exists := s.users[userID]
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
+	u.ExternalID = externalID
+	return nil
+}
+
+// SetUserDisabled blocks or restores sign-in. Disabling deletes the user's sessions and API tokens.
+func (s *Store) SetUserDisabled(userID uuid.UUID, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, exists := s.users[userID]
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
+	u.Disabled = disabled
+	if disabled {
+		s.revokeUserCredentials(userID)
+	}
 	return nil
 }
 
+// revokeUserCredentials deletes everything userID could authenticate with: sessions and API tokens. Callers hold s.mu.
+func (s *Store) revokeUserCredentials(userID uuid.UUID) {
+	for sid, sess := range s.sessions {
+		if sess != nil && sess.UserID == userID {
+			delete(s.sessions, sid)
+		}
+	}
+	for tokenID, tok := range s.tokens {
+		if tok != nil && tok.UserID == userID {
+			delete(s.tokenByHash, tok.KeyHash)
+			delete(s.tokens, tokenID)
+		}
+	}
+}
+
 // SetUserTourCompleted marks the onboarding tour as completed for the user.
 func (s *Store) SetUserTourCompleted(userID uuid.UUID, completed bool) error {
 	s.mu.Lock()
@@ -1203,7 +1616,7 @@ func (s *Store) SetUserTourCompleted(userID uuid.UUID, completed bool) error {
 func (s *Store) CreateSession(sessionID string, userID uuid.UUID, expiry time.Time) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.sessions[sessionID] = &Session{UserID: userID, Expiry: expiry}
+	s.sessions[sessionID] = &Session{ID: sessionID, UserID: userID, Expiry: expiry, CreatedAt: time.Now()}
 }
 
 func (s *Store) GetSession(sessionID string) (*Session, error) {
@@ -1213,52 +1626,202 @@ func (s *Store) GetSession(sessionID string) (*Session, error) {
 	if !exists || sess.Expired() {
 		return nil, fmt.Errorf("session not found or expired")
 	}
-	return sess, nil
+	cp := *sess // TouchSession updates sessions in place
+	return &cp, nil
 }
 
-func (s *Store) DeleteSession(sessionID string) {
+func (s *Store) DeleteSession(sessionID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.sessions, sessionID)
+	return nil
 }
 
-// hashToken returns the SHA-256 hex hash of the raw token.
-func hashToken(raw string) string {
-	h := sha256.Sum256([]byte(raw))
-	return hex.EncodeToString(h[:])
+func (s *Store) TouchSession(sessionID string, lastSeen time.Time, ip, userAgent string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess, ok := s.sessions[sessionID]; ok {
+		sess.LastSeenAt = lastSeen
+		sess.IP = ip
+		sess.UserAgent = userAgent
+	}
 }
 
-// CreateAPIToken creates a new API token for the user. Returns the raw token (only shown once).
-// expiresAt is optional; nil means the token never expires.
-// organizationID is optional; when set (global admin only), the token is scoped to that org.
-func (s *Store) CreateAPIToken(userID uuid.UUID, name string, expiresAt *time.Time, organizationID *uuid.UUID) (token *APIToken, rawToken string, err error) {
-	secret := make([]byte, apiTokenSecretBytes)
-	if _, err := rand.Read(secret); err != nil {
-		return nil, "", err
+func (s *Store) ListSessions(userID uuid.UUID) ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []*Session
+	for _, sess := range s.sessions {
+		if sess.UserID == userID && !sess.Expired() {
+			cp := *sess
+			out = append(out, &cp)
+		}
 	}
-	rawToken = apiTokenPrefix + hex.EncodeToString(secret)
-	token, err = s.CreateAPITokenWithRaw(userID, name, rawToken, expiresAt, organizationID)
-	if err != nil {
-		return nil, "", err
+	sort.Slice(out, func(i, j int) bool { return sessionLastActive(out[i]).After(sessionLastActive(out[j])) })
+	return out, nil
+}
+
+func sessionLastActive(sess *Session) time.Time {
+	if sess.LastSeenAt.IsZero() {
+		return sess.CreatedAt
 	}
-	return token, rawToken, nil
+	return sess.LastSeenAt
 }
 
-// CreateAPITokenWithRaw stores a caller-supplied raw token (hashed at rest).
-func (s *Store) CreateAPITokenWithRaw(userID uuid.UUID, name string, rawToken string, expiresAt *time.Time, organizationID *uuid.UUID) (*APIToken, error) {
-	rawToken = strings.TrimSpace(rawToken)
-	if rawToken == "" {
-		return nil, fmt.Errorf("raw token is required")
+func (s *Store) DeleteUserSessions(userID uuid.UUID, keepSessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sid, sess := range s.sessions {
+		if sess.UserID == userID && sid != keepSessionID {
+			delete(s.sessions, sid)
+		}
 	}
-	keyHash := hashToken(rawToken)
+	return nil
+}
 
+// MFA operations
+
+func (s *Store) SetUserTOTP(userID uuid.UUID, secret string, enabled bool) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, exists := s.users[userID]; !exists {
-		return nil, fmt.Errorf("user not found")
+	u, exists := s.users[userID]
+	if !exists {
+		return fmt.Errorf("user not found")
 	}
-	if _, exists := s.tokenByHash[keyHash]; exists {
-		return nil, fmt.Errorf("token already exists")
+	u.TOTPSecret = secret
+	u.TOTPEnabled = enabled && secret != ""
+	if secret == "" {
+		delete(s.recoveryCodes, userID)
+		delete(s.totpLastStep, userID)
+	}
+	return nil
+}
+
+func (s *Store) UseTOTPStep(userID uuid.UUID, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.users[userID]; !exists {
+		return fmt.Errorf("user not found")
+	}
+	if step <= s.totpLastStep[userID] {
+		return fmt.Errorf("totp code already used")
+	}
+	s.totpLastStep[userID] = step
+	return nil
+}
+
+func (s *Store) SetRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.users[userID]; !exists {
+		return fmt.Errorf("user not found")
+	}
+	s.recoveryCodes[userID] = slices.Clone(codeHashes)
+	return nil
+}
+
+func (s *Store) UseRecoveryCode(userID uuid.UUID, codeHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	codes := s.recoveryCodes[userID]
+	i := slices.Index(codes, codeHash)
+	if i < 0 {
+		return fmt.Errorf("recovery code not found")
+	}
+	s.recoveryCodes[userID] = slices.Delete(codes, i, i+1)
+	return nil
+}
+
+func (s *Store) CountRecoveryCodes(userID uuid.UUID) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.recoveryCodes[userID]), nil
+}
+
+func (s *Store) CreateMFAChallenge(challengeID string, userID uuid.UUID, expiry time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mfaChallenges[challengeID] = &MFAChallenge{UserID: userID, Expiry: expiry}
+	return nil
+}
+
+func (s *Store) GetMFAChallenge(challengeID string) (*MFAChallenge, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, exists := s.mfaChallenges[challengeID]
+	if !exists || c.Expired() {
+		return nil, fmt.Errorf("mfa challenge not found or expired")
+	}
+	return c, nil
+}
+
+func (s *Store) AttemptMFAChallenge(challengeID string, maxAttempts int) (*MFAChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, exists := s.mfaChallenges[challengeID]
+	if !exists || c.Expired() || c.Attempts >= maxAttempts {
+		delete(s.mfaChallenges, challengeID)
+		return nil, fmt.Errorf("mfa challenge not found or expired")
+	}
+	c.Attempts++
+	out := *c
+	return &out, nil
+}
+
+func (s *Store) DeleteMFAChallenge(challengeID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mfaChallenges, challengeID)
+}
+
+// forgetUserMFA drops a deleted user's recovery codes and pending logins. Caller holds s.mu.
+func (s *Store) forgetUserMFA(userID uuid.UUID) {
+	delete(s.recoveryCodes, userID)
+	delete(s.totpLastStep, userID)
+	for id, c := range s.mfaChallenges {
+		if c.UserID == userID {
+			delete(s.mfaChallenges, id)
+		}
+	}
+}
+
+// hashToken returns the SHA-256 hex hash of the raw token.
+func hashToken(raw string) string {
+	h := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(h[:])
+}
+
+// CreateAPIToken creates a new API token for the user. Returns the raw token (only shown once).
+// expiresAt is optional; nil means the token never expires.
+// organizationID is optional; when set (global admin only), the token is scoped to that org.
+func (s *Store) CreateAPIToken(userID uuid.UUID, name string, expiresAt *time.Time, organizationID *uuid.UUID) (token *APIToken, rawToken string, err error) {
+	secret := make([]byte, apiTokenSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	rawToken = apiTokenPrefix + hex.EncodeToString(secret)
+	token, err = s.CreateAPITokenWithRaw(userID, name, rawToken, expiresAt, organizationID)
+	if err != nil {
+		return nil, "", err
+	}
+	return token, rawToken, nil
+}
+
+// CreateAPITokenWithRaw stores a caller-supplied raw token (hashed at rest).
+func (s *Store) CreateAPITokenWithRaw(userID uuid.UUID, name string, rawToken string, expiresAt *time.Time, organizationID *uuid.UUID) (*APIToken, error) {
+	rawToken = strings.TrimSpace(rawToken)
+	if rawToken == "" {
+		return nil, fmt.Errorf("raw token is required")
+	}
+	keyHash := hashToken(rawToken)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.users[userID]; !exists {
+		return nil, fmt.Errorf("user not found")
+	}
+	if _, exists := s.tokenByHash[keyHash]; exists {
+		return nil, fmt.Errorf("token already exists")
 	}
 	orgID := uuid.Nil
 	if organizationID != nil {
@@ -1352,6 +1915,164 @@ func (s *Store) GetAPIToken(tokenID uuid.UUID) (*APIToken, error) {
 	return tok, nil
 }
 
+// CreateServiceAccount adds a service account to an existing organization. Names are unique per organization.
+func (s *Store) CreateServiceAccount(sa *ServiceAccount) error {
+	if err := validateServiceAccount(sa); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.organizations[sa.OrganizationID]; !exists {
+		return fmt.Errorf("organization not found")
+	}
+	if s.serviceAccountNameTaken(sa.OrganizationID, sa.Name, uuid.Nil) {
+		return fmt.Errorf("service account name already exists")
+	}
+	if sa.ID == uuid.Nil {
+		sa.ID = s.GenerateID()
+	}
+	if sa.CreatedAt.IsZero() {
+		sa.CreatedAt = time.Now()
+	}
+	s.serviceAccounts[sa.ID] = sa
+	return nil
+}
+
+// serviceAccountNameTaken reports whether another account than exceptID in orgID is called name. Caller holds s.mu.
+func (s *Store) serviceAccountNameTaken(orgID uuid.UUID, name string, exceptID uuid.UUID) bool {
+	for _, other := range s.serviceAccounts {
+		if other.ID != exceptID && other.OrganizationID == orgID && strings.EqualFold(other.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) GetServiceAccount(id uuid.UUID) (*ServiceAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sa, exists := s.serviceAccounts[id]
+	if !exists {
+		return nil, fmt.Errorf("service account not found")
+	}
+	return sa, nil
+}
+
+func (s *Store) ListServiceAccounts(organizationID *uuid.UUID) ([]*ServiceAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []*ServiceAccount
+	for _, sa := range s.serviceAccounts {
+		if organizationID == nil || sa.OrganizationID == *organizationID {
+			out = append(out, sa)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name) })
+	return out, nil
+}
+
+func (s *Store) UpdateServiceAccount(sa *ServiceAccount) error {
+	if err := validateServiceAccount(sa); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, exists := s.serviceAccounts[sa.ID]
+	if !exists {
+		return fmt.Errorf("service account not found")
+	}
+	if s.serviceAccountNameTaken(existing.OrganizationID, sa.Name, sa.ID) {
+		return fmt.Errorf("service account name already exists")
+	}
+	existing.Name = sa.Name
+	existing.Description = sa.Description
+	existing.Role = sa.Role
+	existing.Disabled = sa.Disabled
+	return nil
+}
+
+func (s *Store) DeleteServiceAccount(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.serviceAccounts[id]; !exists {
+		return fmt.Errorf("service account not found")
+	}
+	s.deleteServiceAccount(id)
+	return nil
+}
+
+// deleteServiceAccount removes the account and its tokens. Caller holds s.mu.
+func (s *Store) deleteServiceAccount(id uuid.UUID) {
+	for tokenID, tok := range s.tokens {
+		if tok.ServiceAccountID == id {
+			delete(s.tokenByHash, tok.KeyHash)
+			delete(s.tokens, tokenID)
+		}
+	}
+	delete(s.serviceAccounts, id)
+}
+
+func (s *Store) CreateServiceAccountToken(serviceAccountID uuid.UUID, name string, expiresAt *time.Time) (*APIToken, string, error) {
+	secret := make([]byte, apiTokenSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	rawToken := apiTokenPrefix + hex.EncodeToString(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.serviceAccounts[serviceAccountID]; !exists {
+		return nil, "", fmt.Errorf("service account not found")
+	}
+	token := &APIToken{
+		ID:               s.GenerateID(),
+		ServiceAccountID: serviceAccountID,
+		Name:             strings.TrimSpace(name),
+		KeyHash:          hashToken(rawToken),
+		CreatedAt:        time.Now(),
+		ExpiresAt:        expiresAt,
+	}
+	s.tokens[token.ID] = token
+	s.tokenByHash[token.KeyHash] = token.ID
+	return token, rawToken, nil
+}
+
+func (s *Store) ListServiceAccountTokens(serviceAccountID uuid.UUID) ([]*APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []*APIToken
+	for _, t := range s.tokens {
+		if t.ServiceAccountID == serviceAccountID {
+			out = append(out, t)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *Store) SetServiceAccountTokenExpiry(serviceAccountID, tokenID uuid.UUID, expiresAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tok, exists := s.tokens[tokenID]
+	if !exists || tok.ServiceAccountID != serviceAccountID {
+		return fmt.Errorf("token not found")
+	}
+	tok.ExpiresAt = expiresAt
+	return nil
+}
+
+func (s *Store) DeleteServiceAccountToken(serviceAccountID, tokenID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tok, exists := s.tokens[tokenID]
+	if !exists || tok.ServiceAccountID != serviceAccountID {
+		return fmt.Errorf("token not found")
+	}
+	delete(s.tokens, tokenID)
+	delete(s.tokenByHash, tok.KeyHash)
+	return nil
+}
+
 // CreateSignupInvite creates a time-bound signup invite. Returns the invite and raw token (only shown once).
 func (s *Store) CreateSignupInvite(createdBy uuid.UUID, expiresAt time.Time, organizationID uuid.UUID, role string) (*SignupInvite, string, error) {
 	secret := make([]byte, signupInviteSecretBytes)
@@ -1449,3 +2170,306 @@ func (s *Store) ListSignupInvites(createdBy uuid.UUID) ([]*SignupInvite, error)
 	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
 	return out, nil
 }
+
+// Password operations
+
+func (s *Store) SetUserPassword(userID uuid.UUID, passwordHash string, keepSessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, exists := s.users[userID]
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
+	s.setUserPassword(u, passwordHash, keepSessionID)
+	return nil
+}
+
+// setUserPassword stores the hash and ends what the old password started. Caller holds s.mu.
+func (s *Store) setUserPassword(u *User, passwordHash string, keepSessionID string) {
+	u.PasswordHash = passwordHash
+	for sid, sess := range s.sessions {
+		if sess.UserID == u.ID && sid != keepSessionID {
+			delete(s.sessions, sid)
+		}
+	}
+	for id, c := range s.mfaChallenges {
+		if c.UserID == u.ID {
+			delete(s.mfaChallenges, id)
+		}
+	}
+	s.deletePasswordResets(u.ID, false)
+}
+
+func (s *Store) CreatePasswordReset(userID uuid.UUID, expiresAt time.Time) (*PasswordReset, string, error) {
+	secret := make([]byte, passwordResetSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	rawToken := passwordResetTokenPrefix + hex.EncodeToString(secret)
+	tokenHash := hashToken(rawToken)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.users[userID]; !exists {
+		return nil, "", fmt.Errorf("user not found")
+	}
+	now := time.Now()
+	if expiresAt.Before(now) {
+		return nil, "", fmt.Errorf("expires_at must be in the future")
+	}
+	s.deletePasswordResets(userID, false)
+	pr := &PasswordReset{
+		ID:        s.GenerateID(),
+		UserID:    userID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+	s.passwordResets[pr.ID] = pr
+	s.resetByHash[tokenHash] = pr.ID
+	return pr, rawToken, nil
+}
+
+func (s *Store) GetPasswordResetByToken(rawToken string) (*PasswordReset, error) {
+	if rawToken == "" || !strings.HasPrefix(rawToken, passwordResetTokenPrefix) {
+		return nil, fmt.Errorf("invalid token")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pr, exists := s.passwordResets[s.resetByHash[hashToken(rawToken)]]
+	if !exists {
+		return nil, fmt.Errorf("password reset not found")
+	}
+	if pr.UsedAt != nil {
+		return nil, fmt.Errorf("password reset already used")
+	}
+	if time.Now().After(pr.ExpiresAt) {
+		return nil, fmt.Errorf("password reset expired")
+	}
+	out := *pr
+	return &out, nil
+}
+
+func (s *Store) UsePasswordReset(resetID uuid.UUID, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pr, exists := s.passwordResets[resetID]
+	if !exists || pr.UsedAt != nil || time.Now().After(pr.ExpiresAt) {
+		return fmt.Errorf("password reset not found or expired")
+	}
+	u, exists := s.users[pr.UserID]
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
+	now := time.Now()
+	pr.UsedAt = &now
+	s.setUserPassword(u, passwordHash, "")
+	return nil
+}
+
+// deletePasswordResets drops the user's unused reset links, or all of them. Caller holds s.mu.
+func (s *Store) deletePasswordResets(userID uuid.UUID, all bool) {
+	for id, pr := range s.passwordResets {
+		if pr.UserID == userID && (all || pr.UsedAt == nil) {
+			delete(s.resetByHash, pr.TokenHash)
+			delete(s.passwordResets, id)
+		}
+	}
+}
+
+func (s *Store) RowVersions(ids []uuid.UUID) (map[uuid.UUID]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[uuid.UUID]int64, len(ids))
+	for _, id := range ids {
+		if v, ok := s.rowVersion(id); ok {
+			out[id] = v
+		}
+	}
+	return out, nil
+}
+
+// rowVersion returns the version of the block, allocation or reserved block id and whether it exists.
+// Caller holds s.mu.
+func (s *Store) rowVersion(id uuid.UUID) (int64, bool) {
+	_, isBlock := s.blocks[id]
+	_, isAlloc := s.allocations[id]
+	_, isReserved := s.reservedBlocks[id]
+	if !isBlock && !isAlloc && !isReserved {
+		return 0, false
+	}
+	return s.rowVersions[id], true
+}
+
+// bulkScopeRows returns the current rows of sc with their versions, using the same filters as the list methods
+// the scope was read with. Caller holds s.mu.
+func (s *Store) bulkScopeRows(sc BulkScope) map[uuid.UUID]int64 {
+	out := make(map[uuid.UUID]int64)
+	inOrg := func(b *network.Block) bool {
+		if b.EnvironmentID == uuid.Nil {
+			return b.OrganizationID == sc.OrganizationID
+		}
+		env, exists := s.environments[b.EnvironmentID]
+		return exists && env.OrganizationID == sc.OrganizationID
+	}
+	switch sc.Kind {
+	case BulkScopeEnvironmentBlocks:
+		for id, b := range s.blocks {
+			if b.DeletedAt == nil && b.EnvironmentID == sc.EnvironmentID {
+				out[id] = s.rowVersions[id]
+			}
+		}
+	case BulkScopeOrphanBlocks:
+		for id, b := range s.blocks {
+			if b.DeletedAt == nil && b.EnvironmentID == uuid.Nil && b.OrganizationID == sc.OrganizationID {
+				out[id] = s.rowVersions[id]
+			}
+		}
+	case BulkScopeReservedBlocks:
+		for id, r := range s.reservedBlocks {
+			if r.OrganizationID == sc.OrganizationID {
+				out[id] = s.rowVersions[id]
+			}
+		}
+	case BulkScopeBlockAllocations:
+		name := strings.ToLower(strings.TrimSpace(sc.BlockName))
+		named := false
+		for _, b := range s.blocks {
+			if b.DeletedAt == nil && strings.ToLower(strings.TrimSpace(b.Name)) == name && inOrg(b) {
+				named = true
+				break
+			}
+		}
+		if !named {
+			return out
+		}
+		for id, a := range s.allocations {
+			if a.DeletedAt == nil && strings.ToLower(strings.TrimSpace(a.Block.Name)) == name {
+				out[id] = s.rowVersions[id]
+			}
+		}
+	}
+	return out
+}
+
+func (s *Store) BulkScopeRows(sc BulkScope) (map[uuid.UUID]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bulkScopeRows(sc), nil
+}
+
+// ApplyBulk applies c under a single write lock. Every referenced row is checked before anything changes.
+func (s *Store) ApplyBulk(c *BulkChanges) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usedByBlock = nil
+	for id, version := range c.Versions {
+		if v, ok := s.rowVersion(id); !ok || v != version {
+			return ErrBulkConflict
+		}
+	}
+	for _, sc := range c.Scopes {
+		if !maps.Equal(s.bulkScopeRows(sc), sc.Rows) {
+			return ErrBulkConflict
+		}
+	}
+	for _, ids := range [][]uuid.UUID{c.DeleteAllocations, c.SoftDeleteAllocations} {
+		for _, id := range ids {
+			if _, exists := s.allocations[id]; !exists {
+				return fmt.Errorf("allocation not found")
+			}
+		}
+	}
+	for _, ids := range [][]uuid.UUID{c.DeleteBlocks, c.SoftDeleteBlocks} {
+		for _, id := range ids {
+			if _, exists := s.blocks[id]; !exists {
+				return fmt.Errorf("block not found")
+			}
+		}
+	}
+	for _, id := range c.DeleteReservedBlocks {
+		if _, exists := s.reservedBlocks[id]; !exists {
+			return fmt.Errorf("reserved block not found")
+		}
+	}
+	for _, r := range c.UpdateReservedBlocks {
+		if _, exists := s.reservedBlocks[r.ID]; !exists {
+			return fmt.Errorf("reserved block not found")
+		}
+	}
+	for _, b := range c.UpdateBlocks {
+		if _, exists := s.blocks[b.ID]; !exists {
+			return fmt.Errorf("block not found")
+		}
+	}
+	for _, a := range c.UpdateAllocations {
+		if _, exists := s.allocations[a.Id]; !exists {
+			return fmt.Errorf("allocation not found")
+		}
+	}
+
+	now := time.Now()
+	for _, id := range c.DeleteAllocations {
+		delete(s.allocations, id)
+	}
+	for _, id := range c.SoftDeleteAllocations {
+		s.allocations[id].DeletedAt = &now
+		s.rowVersions[id]++
+	}
+	for _, id := range c.DeleteBlocks {
+		delete(s.blocks, id)
+	}
+	for _, id := range c.SoftDeleteBlocks {
+		s.blocks[id].DeletedAt = &now
+		s.rowVersions[id]++
+	}
+	for _, id := range c.DeleteReservedBlocks {
+		delete(s.reservedBlocks, id)
+	}
+	for _, r := range c.UpdateReservedBlocks {
+		s.reservedBlocks[r.ID] = r
+		s.rowVersions[r.ID]++
+	}
+	for _, b := range c.UpdateBlocks {
+		s.blocks[b.ID] = b
+		s.rowVersions[b.ID]++
+	}
+	for _, a := range c.UpdateAllocations {
+		s.allocations[a.Id] = a
+		s.rowVersions[a.Id]++
+	}
+	for _, env := range c.CreateEnvironments {
+		if env.Id == uuid.Nil {
+			env.Id = s.GenerateID()
+		}
+		s.environments[env.Id] = env
+	}
+	for _, pool := range c.CreatePools {
+		if pool.ID == uuid.Nil {
+			pool.ID = s.GenerateID()
+		}
+		s.pools[pool.ID] = pool
+	}
+	for _, r := range c.CreateReservedBlocks {
+		if r.ID == uuid.Nil {
+			r.ID = s.GenerateID()
+		}
+		if r.CreatedAt.IsZero() {
+			r.CreatedAt = now
+		}
+		s.reservedBlocks[r.ID] = r
+	}
+	for _, b := range c.CreateBlocks {
+		if b.ID == uuid.Nil {
+			b.ID = s.GenerateID()
+		}
+		s.blocks[b.ID] = b
+	}
+	for _, a := range c.CreateAllocations {
+		if a.Id == uuid.Nil {
+			a.Id = s.GenerateID()
+		}
+		s.allocations[a.Id] = a
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MFAChallenge is a password login waiting for the user's TOTP or recovery code.
+type MFAChallenge struct {
+	UserID   uuid.UUID
+	Expiry   time.Time
+	Attempts int // codes tried against this challenge, including the current one
+}
+
+func (c *MFAChallenge) Expired() bool {
+	return time.Now().After(c.Expiry)
+}
+
+// MFAStore keeps TOTP state on users, their hashed recovery codes and pending two-step logins.
+type MFAStore interface {
+	// SetUserTOTP stores the user's TOTP secret; enabled stays false until enrollment is confirmed with a code.
+	// Clearing the secret turns MFA off and deletes the recovery codes.
+	SetUserTOTP(userID uuid.UUID, secret string, enabled bool) error
+	// UseTOTPStep records the time step of an accepted code. It fails when step is not after the last one used, so
+	// a code cannot be replayed.
+	UseTOTPStep(userID uuid.UUID, step int64) error
+	// SetRecoveryCodes replaces the user's recovery codes with codeHashes.
+	SetRecoveryCodes(userID uuid.UUID, codeHashes []string) error
+	// UseRecoveryCode deletes the user's recovery code with codeHash, or fails when there is none.
+	UseRecoveryCode(userID uuid.UUID, codeHash string) error
+	CountRecoveryCodes(userID uuid.UUID) (int, error)
+
+	CreateMFAChallenge(challengeID string, userID uuid.UUID, expiry time.Time) error
+	GetMFAChallenge(challengeID string) (*MFAChallenge, error)
+	// AttemptMFAChallenge counts one code attempt against the challenge and returns it with the new count. When
+	// maxAttempts have already been made the challenge is deleted and an error returned, as for an expired one.
+	AttemptMFAChallenge(challengeID string, maxAttempts int) (*MFAChallenge, error)
+	DeleteMFAChallenge(challengeID string)
+}
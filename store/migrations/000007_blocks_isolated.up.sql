@@ -0,0 +1,3 @@
+-- Isolated blocks are intentional duplicates (e.g. lab VPCs that are never peered);
+-- cross-connection overlap analysis ignores them and their allocations.
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS isolated BOOLEAN NOT NULL DEFAULT FALSE;
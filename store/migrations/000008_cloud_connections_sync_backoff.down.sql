@@ -0,0 +1,3 @@
+ALTER TABLE cloud_connections DROP COLUMN IF EXISTS sync_backoff_until;
+ALTER TABLE cloud_connections DROP COLUMN IF EXISTS consecutive_sync_failures;
+ALTER TABLE cloud_connections DROP COLUMN IF EXISTS sync_paused;
@@ -0,0 +1,4 @@
+-- Background sync controls: manual pause and exponential backoff after consecutive failures.
+ALTER TABLE cloud_connections ADD COLUMN IF NOT EXISTS sync_paused BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE cloud_connections ADD COLUMN IF NOT EXISTS consecutive_sync_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE cloud_connections ADD COLUMN IF NOT EXISTS sync_backoff_until TIMESTAMPTZ;
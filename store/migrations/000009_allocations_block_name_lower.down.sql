@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_allocations_block_name_lower;
@@ -0,0 +1,2 @@
+-- Block usage aggregates group live allocations by case-insensitive block name.
+CREATE INDEX IF NOT EXISTS idx_allocations_block_name_lower ON allocations (LOWER(block_name)) WHERE deleted_at IS NULL;
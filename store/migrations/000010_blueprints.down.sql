@@ -0,0 +1,4 @@
+-- Reverse network blueprints.
+
+DROP INDEX IF EXISTS idx_blueprints_organization_name;
+DROP TABLE IF EXISTS blueprints;
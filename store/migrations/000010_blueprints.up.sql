@@ -0,0 +1,12 @@
+-- Network blueprints: reusable, organization-scoped layouts of pools, blocks and allocations by prefix length.
+CREATE TABLE IF NOT EXISTS blueprints (
+    id UUID PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    spec JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_blueprints_organization_name ON blueprints(organization_id, LOWER(name));
@@ -0,0 +1,8 @@
+-- Reverse SCIM provisioning.
+
+DROP INDEX IF EXISTS idx_scim_group_members_user_id;
+DROP TABLE IF EXISTS scim_group_members;
+DROP INDEX IF EXISTS idx_scim_groups_display_name;
+DROP TABLE IF EXISTS scim_groups;
+ALTER TABLE users DROP COLUMN IF EXISTS external_id;
+ALTER TABLE users DROP COLUMN IF EXISTS disabled;
@@ -0,0 +1,9 @@
+-- Reverse TOTP multi-factor authentication.
+
+DROP INDEX IF EXISTS idx_mfa_challenges_user_id;
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS user_recovery_codes;
+ALTER TABLE organizations DROP COLUMN IF EXISTS require_mfa;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
@@ -0,0 +1,4 @@
+-- Reverse password reset links.
+
+DROP INDEX IF EXISTS idx_password_resets_user_id;
+DROP TABLE IF EXISTS password_resets;
@@ -0,0 +1,8 @@
+-- Reverse service accounts. Their tokens are deleted so user_id can be required again.
+DELETE FROM api_tokens WHERE service_account_id IS NOT NULL;
+DROP INDEX IF EXISTS idx_api_tokens_service_account_id;
+ALTER TABLE api_tokens DROP CONSTRAINT IF EXISTS api_tokens_owner_check;
+ALTER TABLE api_tokens DROP COLUMN IF EXISTS service_account_id;
+ALTER TABLE api_tokens ALTER COLUMN user_id SET NOT NULL;
+DROP INDEX IF EXISTS idx_service_accounts_org_name;
+DROP TABLE IF EXISTS service_accounts;
@@ -0,0 +1,18 @@
+-- Organization-owned service accounts. Their API tokens reference the account instead of a user, so exactly one
+-- of user_id and service_account_id is set on each token.
+CREATE TABLE IF NOT EXISTS service_accounts (
+    id UUID PRIMARY KEY,
+    organization_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    role TEXT NOT NULL DEFAULT 'user',
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_service_accounts_org_name ON service_accounts(organization_id, LOWER(name));
+
+ALTER TABLE api_tokens ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE api_tokens ADD COLUMN IF NOT EXISTS service_account_id UUID REFERENCES service_accounts(id) ON DELETE CASCADE;
+ALTER TABLE api_tokens ADD CONSTRAINT api_tokens_owner_check CHECK ((user_id IS NULL) <> (service_account_id IS NULL));
+CREATE INDEX IF NOT EXISTS idx_api_tokens_service_account_id ON api_tokens(service_account_id);
@@ -0,0 +1,4 @@
+-- Reverse rate limit buckets.
+
+DROP INDEX IF EXISTS idx_rate_limit_buckets_updated_at;
+DROP TABLE IF EXISTS rate_limit_buckets;
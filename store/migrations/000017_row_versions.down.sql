@@ -0,0 +1,9 @@
+-- Reverse row versions.
+
+DROP TRIGGER IF EXISTS reserved_blocks_bump_version ON reserved_blocks;
+DROP TRIGGER IF EXISTS allocations_bump_version ON allocations;
+DROP TRIGGER IF EXISTS blocks_bump_version ON blocks;
+DROP FUNCTION IF EXISTS bump_row_version();
+ALTER TABLE reserved_blocks DROP COLUMN IF EXISTS version;
+ALTER TABLE allocations DROP COLUMN IF EXISTS version;
+ALTER TABLE blocks DROP COLUMN IF EXISTS version;
@@ -0,0 +1,19 @@
+-- Row versions for blocks, allocations and reserved blocks, bumped by a trigger on every update so bulk writes can
+-- detect rows changed since they were read.
+ALTER TABLE blocks ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE allocations ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE reserved_blocks ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION bump_row_version() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS blocks_bump_version ON blocks;
+CREATE TRIGGER blocks_bump_version BEFORE UPDATE ON blocks FOR EACH ROW EXECUTE FUNCTION bump_row_version();
+DROP TRIGGER IF EXISTS allocations_bump_version ON allocations;
+CREATE TRIGGER allocations_bump_version BEFORE UPDATE ON allocations FOR EACH ROW EXECUTE FUNCTION bump_row_version();
+DROP TRIGGER IF EXISTS reserved_blocks_bump_version ON reserved_blocks;
+CREATE TRIGGER reserved_blocks_bump_version BEFORE UPDATE ON reserved_blocks FOR EACH ROW EXECUTE FUNCTION bump_row_version();
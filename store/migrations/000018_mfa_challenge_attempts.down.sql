@@ -0,0 +1,3 @@
+-- Reverse MFA challenge attempt counts.
+
+ALTER TABLE mfa_challenges DROP COLUMN IF EXISTS attempts;
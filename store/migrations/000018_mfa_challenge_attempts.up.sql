@@ -0,0 +1,2 @@
+-- Count code attempts per pending MFA login so a challenge is dropped after too many wrong codes.
+ALTER TABLE mfa_challenges ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
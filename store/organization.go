@@ -6,9 +6,11 @@ import (
 	"github.com/google/uuid"
 )
 
-// Organization represents a tenant. Users and environments belong to an organization.
+// Organization represents a tenant. Users and environments belong to an organization. RequireMFA makes members who
+// sign in with a local password enroll TOTP before they can use the API.
 type Organization struct {
-	ID        uuid.UUID
-	Name      string
-	CreatedAt time.Time
+	ID         uuid.UUID
+	Name       string
+	RequireMFA bool
+	CreatedAt  time.Time
 }
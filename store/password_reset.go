@@ -0,0 +1,32 @@
+package store
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordReset is a single-use link for setting a forgotten password. Like a SignupInvite, the token is hashed in
+// storage and the raw token is only returned at creation.
+type PasswordReset struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+// PasswordResetStore changes local passwords and keeps pending password reset links.
+type PasswordResetStore interface {
+	// SetUserPassword replaces the user's password hash. It deletes the user's sessions except keepSessionID (empty
+	// deletes all), pending MFA logins and unused reset links.
+	SetUserPassword(userID uuid.UUID, passwordHash string, keepSessionID string) error
+	// CreatePasswordReset returns a new reset link for the user and its raw token. Earlier unused links stop working.
+	CreatePasswordReset(userID uuid.UUID, expiresAt time.Time) (*PasswordReset, string, error)
+	// GetPasswordResetByToken returns the reset for rawToken if it is unused and not expired.
+	GetPasswordResetByToken(rawToken string) (*PasswordReset, error)
+	// UsePasswordReset consumes the reset and sets the user's password hash as SetUserPassword does, deleting all of
+	// the user's sessions. It fails when the reset was already used or has expired.
+	UsePasswordReset(resetID uuid.UUID, passwordHash string) error
+}
@@ -7,7 +7,12 @@ import (
 	"embed"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"math/big"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,6 +24,16 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+// sqlExecer is satisfied by *sql.DB and *sql.Tx so writes can run standalone or inside ApplyBulk's transaction.
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqlQueryer is satisfied by *sql.DB and *sql.Tx.
+type sqlQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
 //go:embed migrations/*.sql
 var migrationsFS embed.FS
 
@@ -137,15 +152,15 @@ func (s *PostgresStore) CreateOrganization(org *Organization) error {
 		org.CreatedAt = time.Now()
 	}
 	_, err := s.db.Exec(
-		`INSERT INTO organizations (id, name, created_at) VALUES ($1, $2, $3)`,
-		org.ID, org.Name, org.CreatedAt,
+		`INSERT INTO organizations (id, name, require_mfa, created_at) VALUES ($1, $2, $3, $4)`,
+		org.ID, org.Name, org.RequireMFA, org.CreatedAt,
 	)
 	return err
 }
 
 func (s *PostgresStore) GetOrganization(id uuid.UUID) (*Organization, error) {
 	var o Organization
-	err := s.db.QueryRow(`SELECT id, name, created_at FROM organizations WHERE id = $1`, id).Scan(&o.ID, &o.Name, &o.CreatedAt)
+	err := s.db.QueryRow(`SELECT id, name, require_mfa, created_at FROM organizations WHERE id = $1`, id).Scan(&o.ID, &o.Name, &o.RequireMFA, &o.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("organization not found")
 	}
@@ -156,7 +171,7 @@ func (s *PostgresStore) GetOrganization(id uuid.UUID) (*Organization, error) {
 }
 
 func (s *PostgresStore) ListOrganizations() ([]*Organization, error) {
-	rows, err := s.db.Query(`SELECT id, name, created_at FROM organizations ORDER BY name`)
+	rows, err := s.db.Query(`SELECT id, name, require_mfa, created_at FROM organizations ORDER BY name`)
 	if err != nil {
 		return nil, err
 	}
@@ -164,7 +179,7 @@ func (s *PostgresStore) ListOrganizations() ([]*Organization, error) {
 	var out []*Organization
 	for rows.Next() {
 		var o Organization
-		if err := rows.Scan(&o.ID, &o.Name, &o.CreatedAt); err != nil {
+		if err := rows.Scan(&o.ID, &o.Name, &o.RequireMFA, &o.CreatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, &o)
@@ -173,7 +188,7 @@ func (s *PostgresStore) ListOrganizations() ([]*Organization, error) {
 }
 
 func (s *PostgresStore) UpdateOrganization(org *Organization) error {
-	res, err := s.db.Exec(`UPDATE organizations SET name = $1 WHERE id = $2`, org.Name, org.ID)
+	res, err := s.db.Exec(`UPDATE organizations SET name = $1, require_mfa = $2 WHERE id = $3`, org.Name, org.RequireMFA, org.ID)
 	if err != nil {
 		return err
 	}
@@ -223,6 +238,15 @@ func (s *PostgresStore) DeleteOrganization(id uuid.UUID) error {
 	if err != nil {
 		return err
 	}
+	_, err = s.db.Exec(`DELETE FROM blueprints WHERE organization_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	// Service account tokens are CASCADE when service accounts are deleted
+	_, err = s.db.Exec(`DELETE FROM service_accounts WHERE organization_id = $1`, id)
+	if err != nil {
+		return err
+	}
 	// Sessions and api_tokens are CASCADE when users are deleted
 	_, err = s.db.Exec(`DELETE FROM users WHERE organization_id = $1`, id)
 	if err != nil {
@@ -240,7 +264,14 @@ func (s *PostgresStore) DeleteOrganization(id uuid.UUID) error {
 }
 
 func (s *PostgresStore) CreateEnvironment(env *network.Environment) error {
-	_, err := s.db.Exec(
+	return createEnvironment(s.db, env)
+}
+
+func createEnvironment(q sqlExecer, env *network.Environment) error {
+	if env.Id == uuid.Nil {
+		env.Id = uuid.New()
+	}
+	_, err := q.Exec(
 		`INSERT INTO environments (id, name, organization_id) VALUES ($1, $2, $3)`,
 		env.Id, env.Name, uuidPtr(env.OrganizationID),
 	)
@@ -397,14 +428,18 @@ func scanPools(rows *sql.Rows) ([]*network.Pool, error) {
 }
 
 func (s *PostgresStore) CreatePool(pool *network.Pool) error {
+	return createPool(s.db, pool)
+}
+
+func createPool(q sqlExecer, pool *network.Pool) error {
 	if pool.ID == uuid.Nil {
-		pool.ID = s.GenerateID()
+		pool.ID = uuid.New()
 	}
 	provider := pool.Provider
 	if provider == "" {
 		provider = "native"
 	}
-	_, err := s.db.Exec(
+	_, err := q.Exec(
 		`INSERT INTO pools (id, organization_id, environment_id, name, cidr, provider, external_id, connection_id, parent_pool_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
 		pool.ID, pool.OrganizationID, pool.EnvironmentID, pool.Name, pool.CIDR, provider, nullStr(pool.ExternalID), uuidPtrOptional(pool.ConnectionID), uuidPtrOptional(pool.ParentPoolID),
 	)
@@ -554,8 +589,8 @@ func (s *PostgresStore) CreateCloudConnection(c *CloudConnection) error {
 		conflictRes = "cloud"
 	}
 	_, err := s.db.Exec(
-		`INSERT INTO cloud_connections (id, organization_id, provider, name, config, credentials_ref, sync_interval_minutes, sync_mode, conflict_resolution, last_sync_at, last_sync_status, last_sync_error, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
-		c.ID, c.OrganizationID, c.Provider, c.Name, config, c.CredentialsRef, c.SyncIntervalMinutes, syncMode, conflictRes, c.LastSyncAt, c.LastSyncStatus, c.LastSyncError, c.CreatedAt, c.UpdatedAt,
+		`INSERT INTO cloud_connections (id, organization_id, provider, name, config, credentials_ref, sync_interval_minutes, sync_mode, conflict_resolution, last_sync_at, last_sync_status, last_sync_error, sync_paused, consecutive_sync_failures, sync_backoff_until, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)`,
+		c.ID, c.OrganizationID, c.Provider, c.Name, config, c.CredentialsRef, c.SyncIntervalMinutes, syncMode, conflictRes, c.LastSyncAt, c.LastSyncStatus, c.LastSyncError, c.SyncPaused, c.ConsecutiveSyncFailures, c.SyncBackoffUntil, c.CreatedAt, c.UpdatedAt,
 	)
 	return err
 }
@@ -564,11 +599,11 @@ func (s *PostgresStore) GetCloudConnection(id uuid.UUID) (*CloudConnection, erro
 	var c CloudConnection
 	var config []byte
 	var credRef, lastStatus, lastErr sql.NullString
-	var lastSyncAt sql.NullTime
+	var lastSyncAt, backoffUntil sql.NullTime
 	err := s.db.QueryRow(
-		`SELECT id, organization_id, provider, name, config, credentials_ref, sync_interval_minutes, sync_mode, conflict_resolution, last_sync_at, last_sync_status, last_sync_error, created_at, updated_at FROM cloud_connections WHERE id = $1`,
+		`SELECT id, organization_id, provider, name, config, credentials_ref, sync_interval_minutes, sync_mode, conflict_resolution, last_sync_at, last_sync_status, last_sync_error, sync_paused, consecutive_sync_failures, sync_backoff_until, created_at, updated_at FROM cloud_connections WHERE id = $1`,
 		id,
-	).Scan(&c.ID, &c.OrganizationID, &c.Provider, &c.Name, &config, &credRef, &c.SyncIntervalMinutes, &c.SyncMode, &c.ConflictResolution, &lastSyncAt, &lastStatus, &lastErr, &c.CreatedAt, &c.UpdatedAt)
+	).Scan(&c.ID, &c.OrganizationID, &c.Provider, &c.Name, &config, &credRef, &c.SyncIntervalMinutes, &c.SyncMode, &c.ConflictResolution, &lastSyncAt, &lastStatus, &lastErr, &c.SyncPaused, &c.ConsecutiveSyncFailures, &backoffUntil, &c.CreatedAt, &c.UpdatedAt)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("cloud connection not found")
 	}
@@ -588,6 +623,9 @@ func (s *PostgresStore) GetCloudConnection(id uuid.UUID) (*CloudConnection, erro
 	if lastErr.Valid {
 		c.LastSyncError = &lastErr.String
 	}
+	if backoffUntil.Valid {
+		c.SyncBackoffUntil = &backoffUntil.Time
+	}
 	if c.SyncMode == "" {
 		c.SyncMode = "read_only"
 	}
@@ -599,7 +637,7 @@ func (s *PostgresStore) GetCloudConnection(id uuid.UUID) (*CloudConnection, erro
 
 func (s *PostgresStore) ListCloudConnectionsByOrganization(orgID uuid.UUID) ([]*CloudConnection, error) {
 	rows, err := s.db.Query(
-		`SELECT id, organization_id, provider, name, config, credentials_ref, sync_interval_minutes, sync_mode, conflict_resolution, last_sync_at, last_sync_status, last_sync_error, created_at, updated_at FROM cloud_connections WHERE organization_id = $1 ORDER BY name`,
+		`SELECT id, organization_id, provider, name, config, credentials_ref, sync_interval_minutes, sync_mode, conflict_resolution, last_sync_at, last_sync_status, last_sync_error, sync_paused, consecutive_sync_failures, sync_backoff_until, created_at, updated_at FROM cloud_connections WHERE organization_id = $1 ORDER BY name`,
 		orgID,
 	)
 	if err != nil {
@@ -611,8 +649,8 @@ func (s *PostgresStore) ListCloudConnectionsByOrganization(orgID uuid.UUID) ([]*
 		var c CloudConnection
 		var config []byte
 		var credRef, lastStatus, lastErr sql.NullString
-		var lastSyncAt sql.NullTime
-		if err := rows.Scan(&c.ID, &c.OrganizationID, &c.Provider, &c.Name, &config, &credRef, &c.SyncIntervalMinutes, &c.SyncMode, &c.ConflictResolution, &lastSyncAt, &lastStatus, &lastErr, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		var lastSyncAt, backoffUntil sql.NullTime
+		if err := rows.Scan(&c.ID, &c.OrganizationID, &c.Provider, &c.Name, &config, &credRef, &c.SyncIntervalMinutes, &c.SyncMode, &c.ConflictResolution, &lastSyncAt, &lastStatus, &lastErr, &c.SyncPaused, &c.ConsecutiveSyncFailures, &backoffUntil, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, err
 		}
 		c.Config = config
@@ -628,6 +666,9 @@ func (s *PostgresStore) ListCloudConnectionsByOrganization(orgID uuid.UUID) ([]*
 		if lastErr.Valid {
 			c.LastSyncError = &lastErr.String
 		}
+		if backoffUntil.Valid {
+			c.SyncBackoffUntil = &backoffUntil.Time
+		}
 		if c.SyncMode == "" {
 			c.SyncMode = "read_only"
 		}
@@ -653,8 +694,23 @@ func (s *PostgresStore) UpdateCloudConnection(id uuid.UUID, c *CloudConnection)
 		conflictRes = "cloud"
 	}
 	res, err := s.db.Exec(
-		`UPDATE cloud_connections SET name = $1, config = $2, credentials_ref = $3, sync_interval_minutes = $4, sync_mode = $5, conflict_resolution = $6, last_sync_at = $7, last_sync_status = $8, last_sync_error = $9, updated_at = $10 WHERE id = $11`,
-		c.Name, config, c.CredentialsRef, c.SyncIntervalMinutes, syncMode, conflictRes, c.LastSyncAt, c.LastSyncStatus, c.LastSyncError, c.UpdatedAt, id,
+		`UPDATE cloud_connections SET name = $1, config = $2, credentials_ref = $3, sync_interval_minutes = $4, sync_mode = $5, conflict_resolution = $6, sync_paused = $7, updated_at = $8 WHERE id = $9`,
+		c.Name, config, c.CredentialsRef, c.SyncIntervalMinutes, syncMode, conflictRes, c.SyncPaused, c.UpdatedAt, id,
+	)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("cloud connection not found")
+	}
+	return nil
+}
+
+func (s *PostgresStore) UpdateCloudConnectionSyncState(id uuid.UUID, st CloudConnectionSyncState) error {
+	res, err := s.db.Exec(
+		`UPDATE cloud_connections SET last_sync_at = $1, last_sync_status = $2, last_sync_error = $3, consecutive_sync_failures = $4, sync_backoff_until = $5, updated_at = $6 WHERE id = $7`,
+		st.LastSyncAt, st.LastSyncStatus, st.LastSyncError, st.ConsecutiveSyncFailures, st.SyncBackoffUntil, time.Now(), id,
 	)
 	if err != nil {
 		return err
@@ -668,7 +724,7 @@ func (s *PostgresStore) UpdateCloudConnection(id uuid.UUID, c *CloudConnection)
 
 func (s *PostgresStore) ListCloudConnections() ([]*CloudConnection, error) {
 	rows, err := s.db.Query(
-		`SELECT id, organization_id, provider, name, config, credentials_ref, sync_interval_minutes, sync_mode, conflict_resolution, last_sync_at, last_sync_status, last_sync_error, created_at, updated_at FROM cloud_connections ORDER BY name`,
+		`SELECT id, organization_id, provider, name, config, credentials_ref, sync_interval_minutes, sync_mode, conflict_resolution, last_sync_at, last_sync_status, last_sync_error, sync_paused, consecutive_sync_failures, sync_backoff_until, created_at, updated_at FROM cloud_connections ORDER BY name`,
 	)
 	if err != nil {
 		return nil, err
@@ -679,8 +735,8 @@ func (s *PostgresStore) ListCloudConnections() ([]*CloudConnection, error) {
 		var c CloudConnection
 		var config []byte
 		var credRef, lastStatus, lastErr sql.NullString
-		var lastSyncAt sql.NullTime
-		if err := rows.Scan(&c.ID, &c.OrganizationID, &c.Provider, &c.Name, &config, &credRef, &c.SyncIntervalMinutes, &c.SyncMode, &c.ConflictResolution, &lastSyncAt, &lastStatus, &lastErr, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		var lastSyncAt, backoffUntil sql.NullTime
+		if err := rows.Scan(&c.ID, &c.OrganizationID, &c.Provider, &c.Name, &config, &credRef, &c.SyncIntervalMinutes, &c.SyncMode, &c.ConflictResolution, &lastSyncAt, &lastStatus, &lastErr, &c.SyncPaused, &c.ConsecutiveSyncFailures, &backoffUntil, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, err
 		}
 		c.Config = config
@@ -696,6 +752,9 @@ func (s *PostgresStore) ListCloudConnections() ([]*CloudConnection, error) {
 		if lastErr.Valid {
 			c.LastSyncError = &lastErr.String
 		}
+		if backoffUntil.Valid {
+			c.SyncBackoffUntil = &backoffUntil.Time
+		}
 		if c.SyncMode == "" {
 			c.SyncMode = "read_only"
 		}
@@ -746,18 +805,52 @@ func (s *PostgresStore) WithSyncLock(ctx context.Context, connectionID uuid.UUID
 	return true, err
 }
 
+// rateLimitRefill is the token count of an existing rate_limit_buckets row after refilling it at $2 per second up to
+// $3 using the database clock, so replicas with skewed clocks agree.
+const rateLimitRefill = "LEAST($3::double precision, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at)::double precision * $2::double precision)"
+
+// TakeRateLimitToken refills and takes from the bucket in one statement, so concurrent requests on any replica
+// cannot take the same token. SET expressions all see the row before the update.
+func (s *PostgresStore) TakeRateLimitToken(key string, perSecond float64, burst int) (bool, float64, error) {
+	var allowed bool
+	var tokens float64
+	err := s.db.QueryRow(`
+		INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+		VALUES ($1, $3::double precision - 1, TRUE, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = `+rateLimitRefill+` - CASE WHEN `+rateLimitRefill+` >= 1 THEN 1 ELSE 0 END,
+			allowed = `+rateLimitRefill+` >= 1,
+			updated_at = NOW()
+		RETURNING allowed, tokens`,
+		key, perSecond, float64(burst),
+	).Scan(&allowed, &tokens)
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed, tokens, nil
+}
+
+func (s *PostgresStore) DeleteIdleRateLimitBuckets(idle time.Duration) error {
+	_, err := s.db.Exec("DELETE FROM rate_limit_buckets WHERE updated_at < NOW() - make_interval(secs => $1)", idle.Seconds())
+	return err
+}
+
 func (s *PostgresStore) CreateBlock(block *network.Block) error {
+	return createBlock(s.db, block)
+}
+
+func createBlock(q sqlExecer, block *network.Block) error {
 	if block.ID == uuid.Nil {
-		block.ID = s.GenerateID()
+		block.ID = uuid.New()
 	}
 	provider := block.Provider
 	if provider == "" {
 		provider = "native"
 	}
 	total := network.CIDRAddressCountInt64(block.CIDR)
-	_, err := s.db.Exec(
-		`INSERT INTO blocks (id, name, cidr, environment_id, organization_id, pool_id, total_ips, provider, external_id, connection_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
-		block.ID, block.Name, block.CIDR, uuidPtr(block.EnvironmentID), uuidPtr(block.OrganizationID), uuidPtrOptional(block.PoolID), total, provider, nullStr(block.ExternalID), uuidPtrOptional(block.ConnectionID),
+	_, err := q.Exec(
+		`INSERT INTO blocks (id, name, cidr, environment_id, organization_id, pool_id, total_ips, provider, external_id, connection_id, isolated) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		block.ID, block.Name, block.CIDR, uuidPtr(block.EnvironmentID), uuidPtr(block.OrganizationID), uuidPtrOptional(block.PoolID), total, provider, nullStr(block.ExternalID), uuidPtrOptional(block.ConnectionID), block.Isolated,
 	)
 	return err
 }
@@ -767,10 +860,11 @@ func (s *PostgresStore) GetBlock(id uuid.UUID) (*network.Block, error) {
 	var envID, orgID, poolID, connID nullUUID
 	var totalIPs int64
 	var prov, extID sql.NullString
+	var isolated bool
 	err := s.db.QueryRow(
-		`SELECT id, name, cidr, environment_id, organization_id, pool_id, total_ips, COALESCE(provider, 'native'), external_id, connection_id FROM blocks WHERE id = $1 AND deleted_at IS NULL`,
+		`SELECT id, name, cidr, environment_id, organization_id, pool_id, total_ips, COALESCE(provider, 'native'), external_id, connection_id, isolated FROM blocks WHERE id = $1 AND deleted_at IS NULL`,
 		id,
-	).Scan(&id, &name, &cidr, &envID, &orgID, &poolID, &totalIPs, &prov, &extID, &connID)
+	).Scan(&id, &name, &cidr, &envID, &orgID, &poolID, &totalIPs, &prov, &extID, &connID, &isolated)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("block not found")
 	}
@@ -801,6 +895,7 @@ func (s *PostgresStore) GetBlock(id uuid.UUID) (*network.Block, error) {
 		OrganizationID: orgUUID,
 		PoolID:         poolUUID,
 		ConnectionID:   connUUID,
+		Isolated:       isolated,
 		Usage:          network.Usage{TotalIPs: int(totalIPs), UsedIPs: 0, AvailableIPs: int(totalIPs)},
 		Children:       []network.Block{},
 	}
@@ -865,7 +960,7 @@ func (s *PostgresStore) ListBlocksFiltered(name string, environmentID *uuid.UUID
 	if err := s.db.QueryRow(countQ, countArgs...).Scan(&total); err != nil {
 		return nil, 0, err
 	}
-	selQ := `SELECT id, name, cidr, environment_id, organization_id, pool_id, total_ips, COALESCE(provider, 'native'), external_id, connection_id FROM blocks WHERE 1=1 AND deleted_at IS NULL`
+	selQ := `SELECT id, name, cidr, environment_id, organization_id, pool_id, total_ips, COALESCE(provider, 'native'), external_id, connection_id, isolated FROM blocks WHERE 1=1 AND deleted_at IS NULL`
 	selArgs := []interface{}{}
 	i := 1
 	if name != "" {
@@ -924,7 +1019,8 @@ func (s *PostgresStore) ListBlocksFiltered(name string, environmentID *uuid.UUID
 		var envID, orgID, poolID, connID nullUUID
 		var totalIPs int64
 		var prov, extID sql.NullString
-		if err := rows.Scan(&id, &n, &cidr, &envID, &orgID, &poolID, &totalIPs, &prov, &extID, &connID); err != nil {
+		var isolated bool
+		if err := rows.Scan(&id, &n, &cidr, &envID, &orgID, &poolID, &totalIPs, &prov, &extID, &connID, &isolated); err != nil {
 			return nil, 0, err
 		}
 		envUUID := uuid.Nil
@@ -951,6 +1047,7 @@ func (s *PostgresStore) ListBlocksFiltered(name string, environmentID *uuid.UUID
 			OrganizationID: orgUUID,
 			PoolID:         poolUUID,
 			ConnectionID:   connUUID,
+			Isolated:       isolated,
 			Usage:          network.Usage{TotalIPs: int(totalIPs), UsedIPs: 0, AvailableIPs: int(totalIPs)},
 			Children:       []network.Block{},
 		}
@@ -972,7 +1069,7 @@ func (s *PostgresStore) ListBlocksByEnvironment(envID uuid.UUID) ([]*network.Blo
 
 func (s *PostgresStore) ListBlocksByPool(poolID uuid.UUID) ([]*network.Block, error) {
 	rows, err := s.db.Query(
-		`SELECT id, name, cidr, environment_id, organization_id, pool_id, total_ips, COALESCE(provider, 'native'), external_id, connection_id FROM blocks WHERE pool_id = $1 AND deleted_at IS NULL ORDER BY name`,
+		`SELECT id, name, cidr, environment_id, organization_id, pool_id, total_ips, COALESCE(provider, 'native'), external_id, connection_id, isolated FROM blocks WHERE pool_id = $1 AND deleted_at IS NULL ORDER BY name`,
 		poolID,
 	)
 	if err != nil {
@@ -986,7 +1083,8 @@ func (s *PostgresStore) ListBlocksByPool(poolID uuid.UUID) ([]*network.Block, er
 		var envID, orgID, poolIDCol, connID nullUUID
 		var totalIPs int64
 		var prov, extID sql.NullString
-		if err := rows.Scan(&id, &n, &cidr, &envID, &orgID, &poolIDCol, &totalIPs, &prov, &extID, &connID); err != nil {
+		var isolated bool
+		if err := rows.Scan(&id, &n, &cidr, &envID, &orgID, &poolIDCol, &totalIPs, &prov, &extID, &connID, &isolated); err != nil {
 			return nil, err
 		}
 		envUUID := uuid.Nil
@@ -1013,6 +1111,7 @@ func (s *PostgresStore) ListBlocksByPool(poolID uuid.UUID) ([]*network.Block, er
 			OrganizationID: orgUUID,
 			PoolID:         poolUUID,
 			ConnectionID:   connUUID,
+			Isolated:       isolated,
 			Usage:          network.Usage{TotalIPs: int(totalIPs), UsedIPs: 0, AvailableIPs: int(totalIPs)},
 			Children:       []network.Block{},
 		}
@@ -1028,14 +1127,18 @@ func (s *PostgresStore) ListBlocksByPool(poolID uuid.UUID) ([]*network.Block, er
 }
 
 func (s *PostgresStore) UpdateBlock(id uuid.UUID, block *network.Block) error {
+	return updateBlock(s.db, id, block)
+}
+
+func updateBlock(q sqlExecer, id uuid.UUID, block *network.Block) error {
 	provider := block.Provider
 	if provider == "" {
 		provider = "native"
 	}
 	total := network.CIDRAddressCountInt64(block.CIDR)
-	res, err := s.db.Exec(
-		`UPDATE blocks SET name = $1, cidr = $2, environment_id = $3, organization_id = $4, pool_id = $5, total_ips = $6, provider = $7, external_id = $8, connection_id = $9, deleted_at = $10 WHERE id = $11`,
-		block.Name, block.CIDR, uuidPtr(block.EnvironmentID), uuidPtr(block.OrganizationID), uuidPtrOptional(block.PoolID), total, provider, nullStr(block.ExternalID), uuidPtrOptional(block.ConnectionID), timePtrOptional(block.DeletedAt), id,
+	res, err := q.Exec(
+		`UPDATE blocks SET name = $1, cidr = $2, environment_id = $3, organization_id = $4, pool_id = $5, total_ips = $6, provider = $7, external_id = $8, connection_id = $9, deleted_at = $10, isolated = $11 WHERE id = $12`,
+		block.Name, block.CIDR, uuidPtr(block.EnvironmentID), uuidPtr(block.OrganizationID), uuidPtrOptional(block.PoolID), total, provider, nullStr(block.ExternalID), uuidPtrOptional(block.ConnectionID), timePtrOptional(block.DeletedAt), block.Isolated, id,
 	)
 	if err != nil {
 		return err
@@ -1048,7 +1151,11 @@ func (s *PostgresStore) UpdateBlock(id uuid.UUID, block *network.Block) error {
 }
 
 func (s *PostgresStore) DeleteBlock(id uuid.UUID) error {
-	res, err := s.db.Exec(`DELETE FROM blocks WHERE id = $1`, id)
+	return deleteBlock(s.db, id)
+}
+
+func deleteBlock(q sqlExecer, id uuid.UUID) error {
+	res, err := q.Exec(`DELETE FROM blocks WHERE id = $1`, id)
 	if err != nil {
 		return err
 	}
@@ -1060,7 +1167,11 @@ func (s *PostgresStore) DeleteBlock(id uuid.UUID) error {
 }
 
 func (s *PostgresStore) SoftDeleteBlock(id uuid.UUID) error {
-	res, err := s.db.Exec(`UPDATE blocks SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, id)
+	return softDeleteBlock(s.db, id)
+}
+
+func softDeleteBlock(q sqlExecer, id uuid.UUID) error {
+	res, err := q.Exec(`UPDATE blocks SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, id)
 	if err != nil {
 		return err
 	}
@@ -1073,7 +1184,7 @@ func (s *PostgresStore) SoftDeleteBlock(id uuid.UUID) error {
 
 func (s *PostgresStore) ListBlocksPendingCloudDelete(connID uuid.UUID) ([]*network.Block, error) {
 	rows, err := s.db.Query(
-		`SELECT id, name, cidr, environment_id, organization_id, pool_id, total_ips, COALESCE(provider, 'native'), external_id, connection_id FROM blocks WHERE connection_id = $1 AND external_id IS NOT NULL AND external_id != '' AND deleted_at IS NOT NULL ORDER BY name`,
+		`SELECT id, name, cidr, environment_id, organization_id, pool_id, total_ips, COALESCE(provider, 'native'), external_id, connection_id, isolated FROM blocks WHERE connection_id = $1 AND external_id IS NOT NULL AND external_id != '' AND deleted_at IS NOT NULL ORDER BY name`,
 		connID,
 	)
 	if err != nil {
@@ -1087,7 +1198,8 @@ func (s *PostgresStore) ListBlocksPendingCloudDelete(connID uuid.UUID) ([]*netwo
 		var envID, orgID, poolIDCol, connID nullUUID
 		var totalIPs int64
 		var prov, extID sql.NullString
-		if err := rows.Scan(&id, &n, &cidr, &envID, &orgID, &poolIDCol, &totalIPs, &prov, &extID, &connID); err != nil {
+		var isolated bool
+		if err := rows.Scan(&id, &n, &cidr, &envID, &orgID, &poolIDCol, &totalIPs, &prov, &extID, &connID, &isolated); err != nil {
 			return nil, err
 		}
 		envUUID := uuid.Nil
@@ -1114,6 +1226,7 @@ func (s *PostgresStore) ListBlocksPendingCloudDelete(connID uuid.UUID) ([]*netwo
 			OrganizationID: orgUUID,
 			PoolID:         poolUUID,
 			ConnectionID:   connUUID,
+			Isolated:       isolated,
 			Usage:          network.Usage{TotalIPs: int(totalIPs), UsedIPs: 0, AvailableIPs: int(totalIPs)},
 			Children:       []network.Block{},
 		}
@@ -1175,7 +1288,7 @@ func (s *PostgresStore) ListBlocksFilteredIncludingDeleted(name string, environm
 	if err := s.db.QueryRow(countQ, countArgs...).Scan(&total); err != nil {
 		return nil, 0, err
 	}
-	selQ := `SELECT id, name, cidr, environment_id, organization_id, pool_id, total_ips, COALESCE(provider, 'native'), external_id, connection_id FROM blocks WHERE 1=1`
+	selQ := `SELECT id, name, cidr, environment_id, organization_id, pool_id, total_ips, COALESCE(provider, 'native'), external_id, connection_id, isolated FROM blocks WHERE 1=1`
 	selArgs := []interface{}{}
 	i := 1
 	if name != "" {
@@ -1234,7 +1347,8 @@ func (s *PostgresStore) ListBlocksFilteredIncludingDeleted(name string, environm
 		var envID, orgID, poolIDCol, connID nullUUID
 		var totalIPs int64
 		var prov, extID sql.NullString
-		if err := rows.Scan(&id, &n, &cidr, &envID, &orgID, &poolIDCol, &totalIPs, &prov, &extID, &connID); err != nil {
+		var isolated bool
+		if err := rows.Scan(&id, &n, &cidr, &envID, &orgID, &poolIDCol, &totalIPs, &prov, &extID, &connID, &isolated); err != nil {
 			return nil, 0, err
 		}
 		envUUID := uuid.Nil
@@ -1261,6 +1375,7 @@ func (s *PostgresStore) ListBlocksFilteredIncludingDeleted(name string, environm
 			OrganizationID: orgUUID,
 			PoolID:         poolUUID,
 			ConnectionID:   connUUID,
+			Isolated:       isolated,
 			Usage:          network.Usage{TotalIPs: int(totalIPs), UsedIPs: 0, AvailableIPs: int(totalIPs)},
 			Children:       []network.Block{},
 		}
@@ -1276,11 +1391,15 @@ func (s *PostgresStore) ListBlocksFilteredIncludingDeleted(name string, environm
 }
 
 func (s *PostgresStore) CreateAllocation(id uuid.UUID, alloc *network.Allocation) error {
+	return createAllocation(s.db, id, alloc)
+}
+
+func createAllocation(q sqlExecer, id uuid.UUID, alloc *network.Allocation) error {
 	provider := alloc.Provider
 	if provider == "" {
 		provider = "native"
 	}
-	_, err := s.db.Exec(
+	_, err := q.Exec(
 		`INSERT INTO allocations (id, name, block_name, block_cidr, provider, external_id, connection_id) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
 		id, alloc.Name, alloc.Block.Name, alloc.Block.CIDR, provider, nullStr(alloc.ExternalID), uuidPtrOptional(alloc.ConnectionID),
 	)
@@ -1416,12 +1535,98 @@ func (s *PostgresStore) ListAllocationsFiltered(name string, blockName string, e
 	return out, total, rows.Err()
 }
 
+// ListAllocationsAfter returns up to limit allocations with ID greater than after, ordered by ID. It uses the primary
+// key index, so each page costs the same however deep the cursor is.
+func (s *PostgresStore) ListAllocationsAfter(organizationID *uuid.UUID, after uuid.UUID, limit int) ([]*network.Allocation, error) {
+	q := `SELECT id, name, block_name, block_cidr, provider, external_id, connection_id FROM allocations WHERE deleted_at IS NULL AND id > $1`
+	args := []interface{}{after}
+	if organizationID != nil {
+		q += ` AND LOWER(block_name) IN (SELECT LOWER(b.name) FROM blocks b LEFT JOIN environments e ON b.environment_id = e.id WHERE (e.organization_id = $2 OR (b.environment_id IS NULL AND b.organization_id = $2)) AND b.deleted_at IS NULL)`
+		args = append(args, *organizationID)
+	}
+	q += ` ORDER BY id`
+	if limit > 0 {
+		// #nosec G202 -- placeholder index only, no user input in query text
+		q += fmt.Sprintf(` LIMIT $%d`, len(args)+1)
+		args = append(args, limit)
+	}
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*network.Allocation
+	for rows.Next() {
+		var id uuid.UUID
+		var n, bn, bc string
+		var prov sql.NullString
+		var extID sql.NullString
+		var connID nullUUID
+		if err := rows.Scan(&id, &n, &bn, &bc, &prov, &extID, &connID); err != nil {
+			return nil, err
+		}
+		a := &network.Allocation{Id: id, Name: n, Block: network.Block{Name: bn, CIDR: bc}}
+		if prov.Valid {
+			a.Provider = prov.String
+		}
+		if extID.Valid {
+			a.ExternalID = extID.String
+		}
+		if connID.Valid {
+			a.ConnectionID = &connID.UUID
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// BlockUsedAddresses sums allocation sizes per block in one aggregate query (using idx_allocations_block_name_lower).
+// Sizes are computed as numeric so IPv6 counts do not overflow.
+func (s *PostgresStore) BlockUsedAddresses(blocks []*network.Block) (map[uuid.UUID]*big.Int, error) {
+	out := make(map[uuid.UUID]*big.Int, len(blocks))
+	if len(blocks) == 0 {
+		return out, nil
+	}
+	ids := make([]string, len(blocks))
+	names := make([]string, len(blocks))
+	cidrs := make([]string, len(blocks))
+	for i, b := range blocks {
+		ids[i], names[i], cidrs[i] = b.ID.String(), strings.ToLower(strings.TrimSpace(b.Name)), b.CIDR
+	}
+	rows, err := s.db.Query(`SELECT b.id,
+		ROUND(SUM(POWER(2::numeric, CASE WHEN family(a.block_cidr::inet) = 4 THEN 32 ELSE 128 END - masklen(a.block_cidr::inet))))::text
+		FROM unnest($1::uuid[], $2::text[], $3::text[]) AS b(id, name, cidr)
+		JOIN allocations a ON a.deleted_at IS NULL AND LOWER(a.block_name) = b.name AND a.block_cidr::inet <<= b.cidr::inet
+		GROUP BY b.id`, ids, names, cidrs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var sum string
+		if err := rows.Scan(&id, &sum); err != nil {
+			return nil, err
+		}
+		used, ok := new(big.Int).SetString(sum, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid address count %q for block %s", sum, id)
+		}
+		out[id] = used
+	}
+	return out, rows.Err()
+}
+
 func (s *PostgresStore) UpdateAllocation(id uuid.UUID, alloc *network.Allocation) error {
+	return updateAllocation(s.db, id, alloc)
+}
+
+func updateAllocation(q sqlExecer, id uuid.UUID, alloc *network.Allocation) error {
 	provider := alloc.Provider
 	if provider == "" {
 		provider = "native"
 	}
-	res, err := s.db.Exec(
+	res, err := q.Exec(
 		`UPDATE allocations SET name = $1, block_name = $2, block_cidr = $3, provider = $4, external_id = $5, connection_id = $6, deleted_at = $7 WHERE id = $8`,
 		alloc.Name, alloc.Block.Name, alloc.Block.CIDR, provider, nullStr(alloc.ExternalID), uuidPtrOptional(alloc.ConnectionID), timePtrOptional(alloc.DeletedAt), id,
 	)
@@ -1436,7 +1641,11 @@ func (s *PostgresStore) UpdateAllocation(id uuid.UUID, alloc *network.Allocation
 }
 
 func (s *PostgresStore) DeleteAllocation(id uuid.UUID) error {
-	res, err := s.db.Exec(`DELETE FROM allocations WHERE id = $1`, id)
+	return deleteAllocation(s.db, id)
+}
+
+func deleteAllocation(q sqlExecer, id uuid.UUID) error {
+	res, err := q.Exec(`DELETE FROM allocations WHERE id = $1`, id)
 	if err != nil {
 		return err
 	}
@@ -1448,7 +1657,11 @@ func (s *PostgresStore) DeleteAllocation(id uuid.UUID) error {
 }
 
 func (s *PostgresStore) SoftDeleteAllocation(id uuid.UUID) error {
-	res, err := s.db.Exec(`UPDATE allocations SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, id)
+	return softDeleteAllocation(s.db, id)
+}
+
+func softDeleteAllocation(q sqlExecer, id uuid.UUID) error {
+	res, err := q.Exec(`UPDATE allocations SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`, id)
 	if err != nil {
 		return err
 	}
@@ -1614,13 +1827,17 @@ func (s *PostgresStore) ListReservedBlocks(organizationID *uuid.UUID) ([]*Reserv
 }
 
 func (s *PostgresStore) CreateReservedBlock(r *ReservedBlock) error {
+	return createReservedBlock(s.db, r)
+}
+
+func createReservedBlock(q sqlExecer, r *ReservedBlock) error {
 	if r.ID == uuid.Nil {
 		r.ID = uuid.New()
 	}
 	if r.CreatedAt.IsZero() {
 		r.CreatedAt = time.Now()
 	}
-	_, err := s.db.Exec(
+	_, err := q.Exec(
 		`INSERT INTO reserved_blocks (id, name, cidr, reason, created_at, organization_id) VALUES ($1, $2, $3, $4, $5, $6)`,
 		r.ID, strings.TrimSpace(r.Name), r.CIDR, r.Reason, r.CreatedAt, r.OrganizationID,
 	)
@@ -1644,7 +1861,11 @@ func (s *PostgresStore) GetReservedBlock(id uuid.UUID) (*ReservedBlock, error) {
 }
 
 func (s *PostgresStore) UpdateReservedBlock(id uuid.UUID, r *ReservedBlock) error {
-	res, err := s.db.Exec(
+	return updateReservedBlock(s.db, id, r)
+}
+
+func updateReservedBlock(q sqlExecer, id uuid.UUID, r *ReservedBlock) error {
+	res, err := q.Exec(
 		`UPDATE reserved_blocks SET name = $1, cidr = $2, reason = $3 WHERE id = $4`,
 		strings.TrimSpace(r.Name), r.CIDR, r.Reason, id,
 	)
@@ -1659,7 +1880,11 @@ func (s *PostgresStore) UpdateReservedBlock(id uuid.UUID, r *ReservedBlock) erro
 }
 
 func (s *PostgresStore) DeleteReservedBlock(id uuid.UUID) error {
-	res, err := s.db.Exec(`DELETE FROM reserved_blocks WHERE id = $1`, id)
+	return deleteReservedBlock(s.db, id)
+}
+
+func deleteReservedBlock(q sqlExecer, id uuid.UUID) error {
+	res, err := q.Exec(`DELETE FROM reserved_blocks WHERE id = $1`, id)
 	if err != nil {
 		return err
 	}
@@ -1687,223 +1912,688 @@ func (s *PostgresStore) OverlapsReservedBlock(cidr string, organizationID *uuid.
 	return nil, nil
 }
 
-func (s *PostgresStore) CreateUser(u *User) error {
-	if u.ID == uuid.Nil {
-		u.ID = uuid.New()
-	}
-	email := strings.TrimSpace(u.Email)
-	_, err := s.db.Exec(
-		`INSERT INTO users (id, email, password_hash, role, tour_completed, organization_id, oauth_provider, oauth_provider_user_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		u.ID.String(), email, u.PasswordHash, u.Role, u.TourCompleted, uuidPtr(u.OrganizationID), nullStr(u.OAuthProvider), nullStr(u.OAuthProviderUserID),
-	)
-	if err != nil {
-		if strings.Contains(err.Error(), "unique") || strings.Contains(err.Error(), "duplicate") {
-			return fmt.Errorf("user with email already exists")
-		}
-		return fmt.Errorf("failed to create user")
-	}
-	return nil
-}
-
-func (s *PostgresStore) GetUser(id uuid.UUID) (*User, error) {
-	var u User
-	var orgID nullUUID
-	var oauthProvider, oauthProviderUserID sql.NullString
-	err := s.db.QueryRow(
-		`SELECT id, email, password_hash, role, tour_completed, organization_id, oauth_provider, oauth_provider_user_id FROM users WHERE id = $1`,
-		id,
-	).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Role, &u.TourCompleted, &orgID, &oauthProvider, &oauthProviderUserID)
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
-	}
-	if err != nil {
-		return nil, err
-	}
-	if orgID.Valid {
-		u.OrganizationID = orgID.UUID
-	}
-	if oauthProvider.Valid {
-		u.OAuthProvider = oauthProvider.String
-	}
-	if oauthProviderUserID.Valid {
-		u.OAuthProviderUserID = oauthProviderUserID.String
-	}
-	return &u, nil
-}
+const blueprintColumns = `id, organization_id, name, description, spec, created_at, updated_at`
 
-func (s *PostgresStore) GetUserByEmail(email string) (*User, error) {
-	email = strings.ToLower(strings.TrimSpace(email))
-	var u User
-	var orgID nullUUID
-	var oauthProvider, oauthProviderUserID sql.NullString
-	err := s.db.QueryRow(
-		`SELECT id, email, password_hash, role, tour_completed, organization_id, oauth_provider, oauth_provider_user_id FROM users WHERE LOWER(email) = $1`,
-		email,
-	).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Role, &u.TourCompleted, &orgID, &oauthProvider, &oauthProviderUserID)
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
-	}
-	if err != nil {
+func scanBlueprint(row interface{ Scan(...interface{}) error }) (*Blueprint, error) {
+	var b Blueprint
+	var spec []byte
+	if err := row.Scan(&b.ID, &b.OrganizationID, &b.Name, &b.Description, &spec, &b.CreatedAt, &b.UpdatedAt); err != nil {
 		return nil, err
 	}
-	if orgID.Valid {
-		u.OrganizationID = orgID.UUID
-	}
-	if oauthProvider.Valid {
-		u.OAuthProvider = oauthProvider.String
+	if err := json.Unmarshal(spec, &b.Spec); err != nil {
+		return nil, fmt.Errorf("blueprint %s: decode spec: %w", b.ID, err)
 	}
-	if oauthProviderUserID.Valid {
-		u.OAuthProviderUserID = oauthProviderUserID.String
-	}
-	return &u, nil
+	return &b, nil
 }
 
-func (s *PostgresStore) GetUserByOAuth(provider, providerUserID string) (*User, error) {
-	if provider == "" || providerUserID == "" {
-		return nil, fmt.Errorf("user not found")
-	}
-	var u User
-	var orgID nullUUID
-	var oauthProvider, oauthProviderUserID sql.NullString
-	err := s.db.QueryRow(
-		`SELECT id, email, password_hash, role, tour_completed, organization_id, oauth_provider, oauth_provider_user_id FROM users WHERE oauth_provider = $1 AND oauth_provider_user_id = $2`,
-		provider, providerUserID,
-	).Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Role, &u.TourCompleted, &orgID, &oauthProvider, &oauthProviderUserID)
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
-	}
-	if err != nil {
-		return nil, err
-	}
-	if orgID.Valid {
-		u.OrganizationID = orgID.UUID
-	}
-	if oauthProvider.Valid {
-		u.OAuthProvider = oauthProvider.String
+// blueprintWriteErr maps the per-organization name index violation to the error the memory store returns.
+func blueprintWriteErr(err error) error {
+	if err != nil && (strings.Contains(err.Error(), "unique") || strings.Contains(err.Error(), "duplicate")) {
+		return fmt.Errorf("blueprint name already exists")
 	}
-	if oauthProviderUserID.Valid {
-		u.OAuthProviderUserID = oauthProviderUserID.String
-	}
-	return &u, nil
+	return err
 }
 
-func (s *PostgresStore) ListUsers(organizationID *uuid.UUID) ([]*User, error) {
-	q := `SELECT id, email, password_hash, role, tour_completed, organization_id, oauth_provider, oauth_provider_user_id FROM users`
+func (s *PostgresStore) ListBlueprints(organizationID *uuid.UUID) ([]*Blueprint, error) {
+	q := `SELECT ` + blueprintColumns + ` FROM blueprints`
 	args := []interface{}{}
 	if organizationID != nil {
 		q += ` WHERE organization_id = $1`
 		args = append(args, *organizationID)
 	}
-	q += ` ORDER BY email`
+	q += ` ORDER BY LOWER(name)`
 	rows, err := s.db.Query(q, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var out []*User
+	var out []*Blueprint
 	for rows.Next() {
-		var u User
-		var orgID nullUUID
-		var oauthProvider, oauthProviderUserID sql.NullString
-		if err := rows.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Role, &u.TourCompleted, &orgID, &oauthProvider, &oauthProviderUserID); err != nil {
+		b, err := scanBlueprint(rows)
+		if err != nil {
 			return nil, err
 		}
-		if orgID.Valid {
-			u.OrganizationID = orgID.UUID
-		}
-		if oauthProvider.Valid {
-			u.OAuthProvider = oauthProvider.String
-		}
-		if oauthProviderUserID.Valid {
-			u.OAuthProviderUserID = oauthProviderUserID.String
-		}
-		out = append(out, &u)
+		out = append(out, b)
 	}
 	return out, rows.Err()
 }
 
-func (s *PostgresStore) DeleteUser(userID uuid.UUID) error {
-	res, err := s.db.Exec(`DELETE FROM users WHERE id = $1`, userID)
+func (s *PostgresStore) CreateBlueprint(b *Blueprint) error {
+	if b.ID == uuid.Nil {
+		b.ID = uuid.New()
+	}
+	if b.CreatedAt.IsZero() {
+		b.CreatedAt = time.Now()
+	}
+	b.UpdatedAt = b.CreatedAt
+	spec, err := json.Marshal(b.Spec)
 	if err != nil {
 		return err
 	}
-	n, _ := res.RowsAffected()
-	if n == 0 {
-		return fmt.Errorf("user not found")
-	}
-	return nil
+	_, err = s.db.Exec(
+		`INSERT INTO blueprints (`+blueprintColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		b.ID, b.OrganizationID, strings.TrimSpace(b.Name), b.Description, spec, b.CreatedAt, b.UpdatedAt,
+	)
+	return blueprintWriteErr(err)
 }
 
-func (s *PostgresStore) SetUserRole(userID uuid.UUID, role string) error {
-	if role != RoleUser && role != RoleAdmin {
-		return fmt.Errorf("invalid role")
+func (s *PostgresStore) GetBlueprint(id uuid.UUID) (*Blueprint, error) {
+	b, err := scanBlueprint(s.db.QueryRow(`SELECT `+blueprintColumns+` FROM blueprints WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("blueprint not found")
 	}
-	res, err := s.db.Exec(`UPDATE users SET role = $1 WHERE id = $2`, role, userID)
+	return b, err
+}
+
+func (s *PostgresStore) UpdateBlueprint(id uuid.UUID, b *Blueprint) error {
+	spec, err := json.Marshal(b.Spec)
 	if err != nil {
 		return err
 	}
+	b.ID = id
+	b.UpdatedAt = time.Now()
+	res, err := s.db.Exec(
+		`UPDATE blueprints SET name = $1, description = $2, spec = $3, updated_at = $4 WHERE id = $5`,
+		strings.TrimSpace(b.Name), b.Description, spec, b.UpdatedAt, id,
+	)
+	if err != nil {
+		return blueprintWriteErr(err)
+	}
 	n, _ := res.RowsAffected()
 	if n == 0 {
-		return fmt.Errorf("user not found")
+		return fmt.Errorf("blueprint not found")
 	}
 	return nil
 }
 
-func (s *PostgresStore) SetUserOrganization(userID uuid.UUID, organizationID uuid.UUID) error {
-	res, err := s.db.Exec(`UPDATE users SET organization_id = $1 WHERE id = $2`, uuidPtr(organizationID), userID)
+func (s *PostgresStore) DeleteBlueprint(id uuid.UUID) error {
+	res, err := s.db.Exec(`DELETE FROM blueprints WHERE id = $1`, id)
 	if err != nil {
 		return err
 	}
 	n, _ := res.RowsAffected()
 	if n == 0 {
-		return fmt.Errorf("user not found")
+		return fmt.Errorf("blueprint not found")
 	}
 	return nil
 }
 
-func (s *PostgresStore) SetUserTourCompleted(userID uuid.UUID, completed bool) error {
-	res, err := s.db.Exec(`UPDATE users SET tour_completed = $1 WHERE id = $2`, completed, userID)
-	if err != nil {
-		return err
+const userColumns = `id, email, password_hash, role, tour_completed, organization_id, oauth_provider, oauth_provider_user_id, disabled, external_id, totp_secret, totp_enabled`
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+	var u User
+	var orgID nullUUID
+	var oauthProvider, oauthProviderUserID, externalID, totpSecret sql.NullString
+	if err := row.Scan(&u.ID, &u.Email, &u.PasswordHash, &u.Role, &u.TourCompleted, &orgID, &oauthProvider, &oauthProviderUserID, &u.Disabled, &externalID, &totpSecret, &u.TOTPEnabled); err != nil {
+		return nil, err
 	}
-	n, _ := res.RowsAffected()
-	if n == 0 {
-		return fmt.Errorf("user not found")
+	if orgID.Valid {
+		u.OrganizationID = orgID.UUID
 	}
-	return nil
+	u.OAuthProvider = oauthProvider.String
+	u.OAuthProviderUserID = oauthProviderUserID.String
+	u.ExternalID = externalID.String
+	u.TOTPSecret = totpSecret.String
+	return &u, nil
 }
 
-func (s *PostgresStore) SetUserOAuth(userID uuid.UUID, provider, providerUserID string) error {
-	res, err := s.db.Exec(`UPDATE users SET oauth_provider = $1, oauth_provider_user_id = $2 WHERE id = $3`, provider, providerUserID, userID)
+// getUserWhere returns the one user matching where, or "user not found".
+func (s *PostgresStore) getUserWhere(where string, args ...interface{}) (*User, error) {
+	u, err := scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE `+where, args...))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found")
+	}
+	return u, err
+}
+
+const scimGroupColumns = `id, display_name, external_id, created_at, updated_at`
+
+// loadSCIMGroupMembers fills in Members for groups.
+func (s *PostgresStore) loadSCIMGroupMembers(groups ...*SCIMGroup) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	byID := make(map[uuid.UUID]*SCIMGroup, len(groups))
+	ids := make([]string, 0, len(groups))
+	for _, g := range groups {
+		g.Members = nil
+		byID[g.ID] = g
+		ids = append(ids, g.ID.String())
+	}
+	rows, err := s.db.Query(`SELECT group_id, user_id FROM scim_group_members WHERE group_id = ANY($1::uuid[]) ORDER BY user_id`, ids)
 	if err != nil {
 		return err
 	}
-	n, _ := res.RowsAffected()
-	if n == 0 {
-		return fmt.Errorf("user not found")
+	defer rows.Close()
+	for rows.Next() {
+		var groupID, userID uuid.UUID
+		if err := rows.Scan(&groupID, &userID); err != nil {
+			return err
+		}
+		byID[groupID].Members = append(byID[groupID].Members, userID)
 	}
-	return nil
+	return rows.Err()
 }
 
-func (s *PostgresStore) CreateSession(sessionID string, userID uuid.UUID, expiry time.Time) {
-	_, _ = s.db.Exec(`INSERT INTO sessions (session_id, user_id, expiry) VALUES ($1, $2, $3) ON CONFLICT (session_id) DO UPDATE SET user_id = $2, expiry = $3`, sessionID, userID, expiry)
+func scanSCIMGroup(row interface{ Scan(...interface{}) error }) (*SCIMGroup, error) {
+	var g SCIMGroup
+	var externalID sql.NullString
+	if err := row.Scan(&g.ID, &g.DisplayName, &externalID, &g.CreatedAt, &g.UpdatedAt); err != nil {
+		return nil, err
+	}
+	g.ExternalID = externalID.String
+	return &g, nil
+}
+
+// scimGroupWriteErr maps constraint violations to the errors the memory store returns.
+func scimGroupWriteErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "unique") || strings.Contains(msg, "duplicate"):
+		return fmt.Errorf("scim group name already exists")
+	case strings.Contains(msg, "foreign key"):
+		return fmt.Errorf("user not found")
+	}
+	return err
+}
+
+// writeSCIMGroupMembers replaces the group's members inside tx.
+func writeSCIMGroupMembers(tx *sql.Tx, g *SCIMGroup) error {
+	if _, err := tx.Exec(`DELETE FROM scim_group_members WHERE group_id = $1`, g.ID); err != nil {
+		return err
+	}
+	for _, userID := range g.Members {
+		if _, err := tx.Exec(`INSERT INTO scim_group_members (group_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, g.ID, userID); err != nil {
+			return scimGroupWriteErr(err)
+		}
+	}
+	return nil
+}
+
+func (s *PostgresStore) ListSCIMGroups() ([]*SCIMGroup, error) {
+	rows, err := s.db.Query(`SELECT ` + scimGroupColumns + ` FROM scim_groups ORDER BY LOWER(display_name)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*SCIMGroup
+	for rows.Next() {
+		g, err := scanSCIMGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, s.loadSCIMGroupMembers(out...)
+}
+
+func (s *PostgresStore) CreateSCIMGroup(g *SCIMGroup) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	if g.CreatedAt.IsZero() {
+		g.CreatedAt = time.Now()
+	}
+	g.UpdatedAt = g.CreatedAt
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(
+		`INSERT INTO scim_groups (`+scimGroupColumns+`) VALUES ($1, $2, $3, $4, $5)`,
+		g.ID, strings.TrimSpace(g.DisplayName), nullStr(g.ExternalID), g.CreatedAt, g.UpdatedAt,
+	); err != nil {
+		return scimGroupWriteErr(err)
+	}
+	if err := writeSCIMGroupMembers(tx, g); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return s.loadSCIMGroupMembers(g)
+}
+
+func (s *PostgresStore) GetSCIMGroup(id uuid.UUID) (*SCIMGroup, error) {
+	g, err := scanSCIMGroup(s.db.QueryRow(`SELECT `+scimGroupColumns+` FROM scim_groups WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("scim group not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, s.loadSCIMGroupMembers(g)
+}
+
+func (s *PostgresStore) UpdateSCIMGroup(id uuid.UUID, g *SCIMGroup) error {
+	g.ID = id
+	g.UpdatedAt = time.Now()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	err = tx.QueryRow(
+		`UPDATE scim_groups SET display_name = $1, external_id = $2, updated_at = $3 WHERE id = $4 RETURNING created_at`,
+		strings.TrimSpace(g.DisplayName), nullStr(g.ExternalID), g.UpdatedAt, id,
+	).Scan(&g.CreatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("scim group not found")
+	}
+	if err != nil {
+		return scimGroupWriteErr(err)
+	}
+	if err := writeSCIMGroupMembers(tx, g); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return s.loadSCIMGroupMembers(g)
+}
+
+func (s *PostgresStore) DeleteSCIMGroup(id uuid.UUID) error {
+	res, err := s.db.Exec(`DELETE FROM scim_groups WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("scim group not found")
+	}
+	return nil
+}
+
+func (s *PostgresStore) CreateUser(u *User) error {
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	email := strings.TrimSpace(u.Email)
+	_, err := s.db.Exec(
+		`INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		u.ID.String(), email, u.PasswordHash, u.Role, u.TourCompleted, uuidPtr(u.OrganizationID), nullStr(u.OAuthProvider), nullStr(u.OAuthProviderUserID),
+		u.Disabled, nullStr(u.ExternalID), nullStr(u.TOTPSecret), u.TOTPEnabled && u.TOTPSecret != "",
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "unique") || strings.Contains(err.Error(), "duplicate") {
+			return fmt.Errorf("user with email already exists")
+		}
+		return fmt.Errorf("failed to create user")
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetUser(id uuid.UUID) (*User, error) {
+	return s.getUserWhere(`id = $1`, id)
+}
+
+func (s *PostgresStore) GetUserByEmail(email string) (*User, error) {
+	return s.getUserWhere(`LOWER(email) = $1`, strings.ToLower(strings.TrimSpace(email)))
+}
+
+func (s *PostgresStore) GetUserByOAuth(provider, providerUserID string) (*User, error) {
+	if provider == "" || providerUserID == "" {
+		return nil, fmt.Errorf("user not found")
+	}
+	return s.getUserWhere(`oauth_provider = $1 AND oauth_provider_user_id = $2`, provider, providerUserID)
+}
+
+func (s *PostgresStore) ListUsers(organizationID *uuid.UUID) ([]*User, error) {
+	q := `SELECT ` + userColumns + ` FROM users`
+	args := []interface{}{}
+	if organizationID != nil {
+		q += ` WHERE organization_id = $1`
+		args = append(args, *organizationID)
+	}
+	q += ` ORDER BY email`
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+func (s *PostgresStore) DeleteUser(userID uuid.UUID) error {
+	res, err := s.db.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+// SetUserRole changes the user's role. A change deletes the user's sessions and API tokens in the same transaction,
+// so the new role applies from the next sign-in.
+func (s *PostgresStore) SetUserRole(userID uuid.UUID, role string) error {
+	if role != RoleUser && role != RoleAdmin {
+		return fmt.Errorf("invalid role")
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var current string
+	err = tx.QueryRow(`SELECT role FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return err
+	}
+	if current == role {
+		return nil
+	}
+	if _, err := tx.Exec(`UPDATE users SET role = $1 WHERE id = $2`, role, userID); err != nil {
+		return err
+	}
+	if err := revokeUserCredentials(tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetUserOrganization moves the user. A change deletes the user's sessions and API tokens in the same transaction.
+func (s *PostgresStore) SetUserOrganization(userID uuid.UUID, organizationID uuid.UUID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var current sql.NullString
+	err = tx.QueryRow(`SELECT organization_id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return err
+	}
+	if (!current.Valid && organizationID == uuid.Nil) || (current.Valid && current.String == organizationID.String()) {
+		return nil
+	}
+	if _, err := tx.Exec(`UPDATE users SET organization_id = $1 WHERE id = $2`, uuidPtr(organizationID), userID); err != nil {
+		return err
+	}
+	if err := revokeUserCredentials(tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// revokeUserCredentials deletes everything userID could authenticate with: sessions and API tokens.
+func revokeUserCredentials(tx *sql.Tx, userID uuid.UUID) error {
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM api_tokens WHERE user_id = $1`, userID)
+	return err
+}
+
+func (s *PostgresStore) SetUserTourCompleted(userID uuid.UUID, completed bool) error {
+	res, err := s.db.Exec(`UPDATE users SET tour_completed = $1 WHERE id = $2`, completed, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+func (s *PostgresStore) SetUserOAuth(userID uuid.UUID, provider, providerUserID string) error {
+	res, err := s.db.Exec(`UPDATE users SET oauth_provider = $1, oauth_provider_user_id = $2 WHERE id = $3`, provider, providerUserID, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+func (s *PostgresStore) SetUserEmail(userID uuid.UUID, email string) error {
+	res, err := s.db.Exec(`UPDATE users SET email = $1 WHERE id = $2`, strings.TrimSpace(email), userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "unique") || strings.Contains(err.Error(), "duplicate") {
+			return fmt.Errorf("user with email already exists")
+		}
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+func (s *PostgresStore) SetUserExternalID(userID uuid.UUID, externalID string) error {
+	res, err := s.db.Exec(`UPDATE users SET external_id = $1 WHERE id = $2`, nullStr(externalID), userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+// SetUserDisabled blocks or restores sign-in. Disabling deletes the user's sessions and API tokens in the same
+// transaction.
+func (s *PostgresStore) SetUserDisabled(userID uuid.UUID, disabled bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.Exec(`UPDATE users SET disabled = $1 WHERE id = $2`, disabled, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	if disabled {
+		if err := revokeUserCredentials(tx, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) CreateSession(sessionID string, userID uuid.UUID, expiry time.Time) {
+	_, _ = s.db.Exec(`INSERT INTO sessions (session_id, user_id, expiry, created_at) VALUES ($1, $2, $3, NOW()) ON CONFLICT (session_id) DO UPDATE SET user_id = $2, expiry = $3`, sessionID, userID, expiry)
+}
+
+const sessionColumns = `session_id, user_id, expiry, created_at, last_seen_at, ip, user_agent`
+
+func scanSession(row interface{ Scan(...interface{}) error }) (*Session, error) {
+	var sess Session
+	var lastSeen sql.NullTime
+	if err := row.Scan(&sess.ID, &sess.UserID, &sess.Expiry, &sess.CreatedAt, &lastSeen, &sess.IP, &sess.UserAgent); err != nil {
+		return nil, err
+	}
+	if lastSeen.Valid {
+		sess.LastSeenAt = lastSeen.Time
+	}
+	return &sess, nil
 }
 
 func (s *PostgresStore) GetSession(sessionID string) (*Session, error) {
-	var userID uuid.UUID
-	var expiry time.Time
-	err := s.db.QueryRow(`SELECT user_id, expiry FROM sessions WHERE session_id = $1 AND expiry > NOW()`, sessionID).Scan(&userID, &expiry)
+	sess, err := scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE session_id = $1 AND expiry > NOW()`, sessionID))
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("session not found or expired")
 	}
 	if err != nil {
 		return nil, err
 	}
-	return &Session{UserID: userID, Expiry: expiry}, nil
+	return sess, nil
+}
+
+func (s *PostgresStore) DeleteSession(sessionID string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE session_id = $1`, sessionID)
+	return err
+}
+
+func (s *PostgresStore) TouchSession(sessionID string, lastSeen time.Time, ip, userAgent string) {
+	_, _ = s.db.Exec(`UPDATE sessions SET last_seen_at = $2, ip = $3, user_agent = $4 WHERE session_id = $1`, sessionID, lastSeen, ip, userAgent)
 }
 
-func (s *PostgresStore) DeleteSession(sessionID string) {
-	_, _ = s.db.Exec(`DELETE FROM sessions WHERE session_id = $1`, sessionID)
+func (s *PostgresStore) ListSessions(userID uuid.UUID) ([]*Session, error) {
+	rows, err := s.db.Query(`SELECT `+sessionColumns+` FROM sessions WHERE user_id = $1 AND expiry > NOW()
+		ORDER BY COALESCE(last_seen_at, created_at) DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*Session
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sess)
+	}
+	return out, rows.Err()
+}
+
+func (s *PostgresStore) DeleteUserSessions(userID uuid.UUID, keepSessionID string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE user_id = $1 AND session_id <> $2`, userID, keepSessionID)
+	return err
+}
+
+// SetUserTOTP stores the TOTP secret. Clearing it turns MFA off and deletes the recovery codes in the same transaction.
+func (s *PostgresStore) SetUserTOTP(userID uuid.UUID, secret string, enabled bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.Exec(`UPDATE users SET totp_secret = $1, totp_enabled = $2, totp_last_step = CASE WHEN $1::text IS NULL THEN 0 ELSE totp_last_step END WHERE id = $3`,
+		nullStr(secret), enabled && secret != "", userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	if secret == "" {
+		if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) UseTOTPStep(userID uuid.UUID, step int64) error {
+	res, err := s.db.Exec(`UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1`, step, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("totp code already used")
+	}
+	return nil
+}
+
+func (s *PostgresStore) SetRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2) ON CONFLICT DO NOTHING`, userID, h); err != nil {
+			if strings.Contains(err.Error(), "foreign key") {
+				return fmt.Errorf("user not found")
+			}
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) UseRecoveryCode(userID uuid.UUID, codeHash string) error {
+	res, err := s.db.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1 AND code_hash = $2`, userID, codeHash)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("recovery code not found")
+	}
+	return nil
+}
+
+func (s *PostgresStore) CountRecoveryCodes(userID uuid.UUID) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1`, userID).Scan(&n)
+	return n, err
+}
+
+func (s *PostgresStore) CreateMFAChallenge(challengeID string, userID uuid.UUID, expiry time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO mfa_challenges (challenge_id, user_id, expiry) VALUES ($1, $2, $3)`, challengeID, userID, expiry)
+	return err
+}
+
+func (s *PostgresStore) GetMFAChallenge(challengeID string) (*MFAChallenge, error) {
+	c := &MFAChallenge{}
+	err := s.db.QueryRow(`SELECT user_id, expiry, attempts FROM mfa_challenges WHERE challenge_id = $1 AND expiry > NOW()`, challengeID).Scan(&c.UserID, &c.Expiry, &c.Attempts)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("mfa challenge not found or expired")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// AttemptMFAChallenge increments the attempt count in the same statement that checks it, so concurrent attempts
+// cannot exceed maxAttempts.
+func (s *PostgresStore) AttemptMFAChallenge(challengeID string, maxAttempts int) (*MFAChallenge, error) {
+	c := &MFAChallenge{}
+	err := s.db.QueryRow(`UPDATE mfa_challenges SET attempts = attempts + 1
+		WHERE challenge_id = $1 AND expiry > NOW() AND attempts < $2
+		RETURNING user_id, expiry, attempts`, challengeID, maxAttempts).Scan(&c.UserID, &c.Expiry, &c.Attempts)
+	if err == sql.ErrNoRows {
+		s.DeleteMFAChallenge(challengeID)
+		return nil, fmt.Errorf("mfa challenge not found or expired")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *PostgresStore) DeleteMFAChallenge(challengeID string) {
+	_, _ = s.db.Exec(`DELETE FROM mfa_challenges WHERE challenge_id = $1 OR expiry <= NOW()`, challengeID)
 }
 
 func (s *PostgresStore) CreateAPIToken(userID uuid.UUID, name string, expiresAt *time.Time, organizationID *uuid.UUID) (*APIToken, string, error) {
@@ -1961,93 +2651,241 @@ func (s *PostgresStore) GetUserByTokenHash(keyHash string) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
-	return s.GetUser(tok.UserID)
+	return s.GetUser(tok.UserID)
+}
+
+const apiTokenColumns = `id, user_id, service_account_id, name, key_hash, created_at, expires_at, organization_id`
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var t APIToken
+	var userID, serviceAccountID, orgID nullUUID
+	var expiresAt sql.NullTime
+	if err := row.Scan(&t.ID, &userID, &serviceAccountID, &t.Name, &t.KeyHash, &t.CreatedAt, &expiresAt, &orgID); err != nil {
+		return nil, err
+	}
+	if userID.Valid {
+		t.UserID = userID.UUID
+	}
+	if serviceAccountID.Valid {
+		t.ServiceAccountID = serviceAccountID.UUID
+	}
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if orgID.Valid {
+		t.OrganizationID = orgID.UUID
+	}
+	return &t, nil
+}
+
+func (s *PostgresStore) listAPITokens(query string, args ...interface{}) ([]*APIToken, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func (s *PostgresStore) GetAPITokenByKeyHash(keyHash string) (*APIToken, error) {
+	t, err := scanAPIToken(s.db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE key_hash = $1`, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("token not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt) {
+		return nil, fmt.Errorf("token expired")
+	}
+	return t, nil
+}
+
+func (s *PostgresStore) ListAPITokens(userID uuid.UUID) ([]*APIToken, error) {
+	return s.listAPITokens(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = $1 ORDER BY created_at`, userID)
+}
+
+func (s *PostgresStore) DeleteAPIToken(tokenID, userID uuid.UUID) error {
+	res, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("token not found")
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetAPIToken(tokenID uuid.UUID) (*APIToken, error) {
+	t, err := scanAPIToken(s.db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE id = $1`, tokenID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("token not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+const serviceAccountColumns = `id, organization_id, name, description, role, disabled, created_at`
+
+func scanServiceAccount(row interface{ Scan(...interface{}) error }) (*ServiceAccount, error) {
+	var sa ServiceAccount
+	if err := row.Scan(&sa.ID, &sa.OrganizationID, &sa.Name, &sa.Description, &sa.Role, &sa.Disabled, &sa.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &sa, nil
+}
+
+func (s *PostgresStore) CreateServiceAccount(sa *ServiceAccount) error {
+	if err := validateServiceAccount(sa); err != nil {
+		return err
+	}
+	if _, err := s.GetOrganization(sa.OrganizationID); err != nil {
+		return err
+	}
+	if sa.ID == uuid.Nil {
+		sa.ID = uuid.New()
+	}
+	if sa.CreatedAt.IsZero() {
+		sa.CreatedAt = time.Now()
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO service_accounts (`+serviceAccountColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		sa.ID, sa.OrganizationID, sa.Name, sa.Description, sa.Role, sa.Disabled, sa.CreatedAt,
+	)
+	if err != nil && (strings.Contains(err.Error(), "unique") || strings.Contains(err.Error(), "duplicate")) {
+		return fmt.Errorf("service account name already exists")
+	}
+	return err
 }
 
-func (s *PostgresStore) GetAPITokenByKeyHash(keyHash string) (*APIToken, error) {
-	var t APIToken
-	var expiresAt sql.NullTime
-	var orgID nullUUID
-	err := s.db.QueryRow(
-		`SELECT id, user_id, name, key_hash, created_at, expires_at, organization_id FROM api_tokens WHERE key_hash = $1`,
-		keyHash,
-	).Scan(&t.ID, &t.UserID, &t.Name, &t.KeyHash, &t.CreatedAt, &expiresAt, &orgID)
+func (s *PostgresStore) GetServiceAccount(id uuid.UUID) (*ServiceAccount, error) {
+	sa, err := scanServiceAccount(s.db.QueryRow(`SELECT `+serviceAccountColumns+` FROM service_accounts WHERE id = $1`, id))
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("token not found")
+		return nil, fmt.Errorf("service account not found")
 	}
 	if err != nil {
 		return nil, err
 	}
-	if expiresAt.Valid && time.Now().After(expiresAt.Time) {
-		return nil, fmt.Errorf("token expired")
-	}
-	if orgID.Valid {
-		t.OrganizationID = orgID.UUID
-	}
-	return &t, nil
+	return sa, nil
 }
 
-func (s *PostgresStore) ListAPITokens(userID uuid.UUID) ([]*APIToken, error) {
-	rows, err := s.db.Query(
-		`SELECT id, user_id, name, key_hash, created_at, expires_at, organization_id FROM api_tokens WHERE user_id = $1 ORDER BY created_at`,
-		userID,
-	)
+func (s *PostgresStore) ListServiceAccounts(organizationID *uuid.UUID) ([]*ServiceAccount, error) {
+	query := `SELECT ` + serviceAccountColumns + ` FROM service_accounts`
+	var args []interface{}
+	if organizationID != nil {
+		query += ` WHERE organization_id = $1`
+		args = append(args, *organizationID)
+	}
+	rows, err := s.db.Query(query+` ORDER BY LOWER(name)`, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var out []*APIToken
+	var out []*ServiceAccount
 	for rows.Next() {
-		var t APIToken
-		var expiresAt sql.NullTime
-		var orgID nullUUID
-		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.KeyHash, &t.CreatedAt, &expiresAt, &orgID); err != nil {
+		sa, err := scanServiceAccount(rows)
+		if err != nil {
 			return nil, err
 		}
-		if expiresAt.Valid {
-			t.ExpiresAt = &expiresAt.Time
-		}
-		if orgID.Valid {
-			t.OrganizationID = orgID.UUID
-		}
-		out = append(out, &t)
+		out = append(out, sa)
 	}
 	return out, rows.Err()
 }
 
-func (s *PostgresStore) DeleteAPIToken(tokenID, userID uuid.UUID) error {
-	res, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = $1 AND user_id = $2`, tokenID, userID)
+func (s *PostgresStore) UpdateServiceAccount(sa *ServiceAccount) error {
+	if err := validateServiceAccount(sa); err != nil {
+		return err
+	}
+	res, err := s.db.Exec(
+		`UPDATE service_accounts SET name = $2, description = $3, role = $4, disabled = $5 WHERE id = $1`,
+		sa.ID, sa.Name, sa.Description, sa.Role, sa.Disabled,
+	)
+	if err != nil && (strings.Contains(err.Error(), "unique") || strings.Contains(err.Error(), "duplicate")) {
+		return fmt.Errorf("service account name already exists")
+	}
 	if err != nil {
 		return err
 	}
-	n, _ := res.RowsAffected()
-	if n == 0 {
-		return fmt.Errorf("token not found")
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("service account not found")
 	}
 	return nil
 }
 
-func (s *PostgresStore) GetAPIToken(tokenID uuid.UUID) (*APIToken, error) {
-	var t APIToken
-	var expiresAt sql.NullTime
-	var orgID nullUUID
-	err := s.db.QueryRow(
-		`SELECT id, user_id, name, key_hash, created_at, expires_at, organization_id FROM api_tokens WHERE id = $1`,
-		tokenID,
-	).Scan(&t.ID, &t.UserID, &t.Name, &t.KeyHash, &t.CreatedAt, &expiresAt, &orgID)
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("token not found")
+// DeleteServiceAccount deletes the account; its api_tokens rows are removed by ON DELETE CASCADE.
+func (s *PostgresStore) DeleteServiceAccount(id uuid.UUID) error {
+	res, err := s.db.Exec(`DELETE FROM service_accounts WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("service account not found")
+	}
+	return nil
+}
+
+func (s *PostgresStore) CreateServiceAccountToken(serviceAccountID uuid.UUID, name string, expiresAt *time.Time) (*APIToken, string, error) {
+	if _, err := s.GetServiceAccount(serviceAccountID); err != nil {
+		return nil, "", err
+	}
+	secret := make([]byte, apiTokenSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
 	}
+	rawToken := apiTokenPrefix + hex.EncodeToString(secret)
+	token := &APIToken{
+		ID:               uuid.New(),
+		ServiceAccountID: serviceAccountID,
+		Name:             strings.TrimSpace(name),
+		KeyHash:          hashToken(rawToken),
+		CreatedAt:        time.Now(),
+		ExpiresAt:        expiresAt,
+	}
+	_, err := s.db.Exec(
+		`INSERT INTO api_tokens (id, service_account_id, name, key_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+		token.ID, token.ServiceAccountID, token.Name, token.KeyHash, token.CreatedAt, token.ExpiresAt,
+	)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	if expiresAt.Valid {
-		t.ExpiresAt = &expiresAt.Time
+	return token, rawToken, nil
+}
+
+func (s *PostgresStore) ListServiceAccountTokens(serviceAccountID uuid.UUID) ([]*APIToken, error) {
+	return s.listAPITokens(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE service_account_id = $1 ORDER BY created_at`, serviceAccountID)
+}
+
+func (s *PostgresStore) SetServiceAccountTokenExpiry(serviceAccountID, tokenID uuid.UUID, expiresAt *time.Time) error {
+	res, err := s.db.Exec(`UPDATE api_tokens SET expires_at = $3 WHERE id = $1 AND service_account_id = $2`, tokenID, serviceAccountID, expiresAt)
+	if err != nil {
+		return err
 	}
-	if orgID.Valid {
-		t.OrganizationID = orgID.UUID
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("token not found")
 	}
-	return &t, nil
+	return nil
+}
+
+func (s *PostgresStore) DeleteServiceAccountToken(serviceAccountID, tokenID uuid.UUID) error {
+	res, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = $1 AND service_account_id = $2`, tokenID, serviceAccountID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("token not found")
+	}
+	return nil
 }
 
 func (s *PostgresStore) CreateSignupInvite(createdBy uuid.UUID, expiresAt time.Time, organizationID uuid.UUID, role string) (*SignupInvite, string, error) {
@@ -2177,3 +3015,332 @@ func (s *PostgresStore) ListSignupInvites(createdBy uuid.UUID) ([]*SignupInvite,
 	}
 	return out, rows.Err()
 }
+
+func (s *PostgresStore) SetUserPassword(userID uuid.UUID, passwordHash string, keepSessionID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := setUserPassword(tx, userID, passwordHash, keepSessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setUserPassword stores the hash and ends what the old password started: sessions except keepSessionID, pending MFA
+// logins and unused reset links.
+func setUserPassword(tx *sql.Tx, userID uuid.UUID, passwordHash string, keepSessionID string) error {
+	res, err := tx.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, passwordHash, userID)
+	if err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = $1 AND session_id <> $2`, userID, keepSessionID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM mfa_challenges WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM password_resets WHERE user_id = $1 AND used_at IS NULL`, userID)
+	return err
+}
+
+func (s *PostgresStore) CreatePasswordReset(userID uuid.UUID, expiresAt time.Time) (*PasswordReset, string, error) {
+	secret := make([]byte, passwordResetSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	rawToken := passwordResetTokenPrefix + hex.EncodeToString(secret)
+	now := time.Now()
+	if expiresAt.Before(now) {
+		return nil, "", fmt.Errorf("expires_at must be in the future")
+	}
+	pr := &PasswordReset{
+		ID:        uuid.New(),
+		UserID:    userID,
+		TokenHash: hashToken(rawToken),
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var n int
+	err = tx.QueryRow(`SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&n)
+	if err == sql.ErrNoRows {
+		return nil, "", fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := tx.Exec(`DELETE FROM password_resets WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		return nil, "", err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO password_resets (id, user_id, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		pr.ID, pr.UserID, pr.TokenHash, pr.ExpiresAt, pr.CreatedAt,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+	return pr, rawToken, nil
+}
+
+func (s *PostgresStore) GetPasswordResetByToken(rawToken string) (*PasswordReset, error) {
+	if rawToken == "" || !strings.HasPrefix(rawToken, passwordResetTokenPrefix) {
+		return nil, fmt.Errorf("invalid token")
+	}
+	var pr PasswordReset
+	var usedAt sql.NullTime
+	err := s.db.QueryRow(
+		`SELECT id, user_id, token_hash, expires_at, created_at, used_at FROM password_resets WHERE token_hash = $1`,
+		hashToken(rawToken),
+	).Scan(&pr.ID, &pr.UserID, &pr.TokenHash, &pr.ExpiresAt, &pr.CreatedAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("password reset not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		return nil, fmt.Errorf("password reset already used")
+	}
+	if time.Now().After(pr.ExpiresAt) {
+		return nil, fmt.Errorf("password reset expired")
+	}
+	return &pr, nil
+}
+
+func (s *PostgresStore) UsePasswordReset(resetID uuid.UUID, passwordHash string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var userID uuid.UUID
+	err = tx.QueryRow(
+		`UPDATE password_resets SET used_at = NOW() WHERE id = $1 AND used_at IS NULL AND expires_at > NOW() RETURNING user_id`,
+		resetID,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("password reset not found or expired")
+	}
+	if err != nil {
+		return err
+	}
+	if err := setUserPassword(tx, userID, passwordHash, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// versionedTables are the tables whose rows have a version column bumped by the bump_row_version trigger.
+var versionedTables = []string{"blocks", "allocations", "reserved_blocks"}
+
+func (s *PostgresStore) RowVersions(ids []uuid.UUID) (map[uuid.UUID]int64, error) {
+	return rowVersions(s.db, ids, false)
+}
+
+// rowVersions reads the versions of ids, locking the rows when forUpdate is set.
+func rowVersions(q sqlQueryer, ids []uuid.UUID, forUpdate bool) (map[uuid.UUID]int64, error) {
+	out := make(map[uuid.UUID]int64, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = id.String()
+	}
+	lock := ""
+	if forUpdate {
+		lock = " FOR UPDATE"
+	}
+	for _, table := range versionedTables {
+		// #nosec G202 -- table names are constants
+		rows, err := q.Query(`SELECT id, version FROM `+table+` WHERE id = ANY($1::uuid[])`+lock, keys)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id uuid.UUID
+			var version int64
+			if err := rows.Scan(&id, &version); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			out[id] = version
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (s *PostgresStore) BulkScopeRows(sc BulkScope) (map[uuid.UUID]int64, error) {
+	return bulkScopeRows(s.db, sc)
+}
+
+// bulkScopeRows reads the current rows of sc with their versions, using the same filters as the list methods the
+// scope was read with.
+func bulkScopeRows(q sqlQueryer, sc BulkScope) (map[uuid.UUID]int64, error) {
+	var rows *sql.Rows
+	var err error
+	switch sc.Kind {
+	case BulkScopeEnvironmentBlocks:
+		rows, err = q.Query(`SELECT id, version FROM blocks WHERE environment_id = $1 AND deleted_at IS NULL`, sc.EnvironmentID)
+	case BulkScopeOrphanBlocks:
+		rows, err = q.Query(`SELECT id, version FROM blocks WHERE environment_id IS NULL AND organization_id = $1 AND deleted_at IS NULL`, sc.OrganizationID)
+	case BulkScopeReservedBlocks:
+		rows, err = q.Query(`SELECT id, version FROM reserved_blocks WHERE organization_id = $1`, sc.OrganizationID)
+	case BulkScopeBlockAllocations:
+		rows, err = q.Query(
+			`SELECT id, version FROM allocations WHERE LOWER(block_name) = $1 AND deleted_at IS NULL AND EXISTS (SELECT 1 FROM blocks b LEFT JOIN environments e ON b.environment_id = e.id WHERE LOWER(b.name) = $1 AND (e.organization_id = $2 OR (b.environment_id IS NULL AND b.organization_id = $2)) AND b.deleted_at IS NULL)`,
+			strings.ToLower(strings.TrimSpace(sc.BlockName)), sc.OrganizationID,
+		)
+	default:
+		return nil, fmt.Errorf("unknown bulk scope %q", sc.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[uuid.UUID]int64)
+	for rows.Next() {
+		var id uuid.UUID
+		var version int64
+		if err := rows.Scan(&id, &version); err != nil {
+			return nil, err
+		}
+		out[id] = version
+	}
+	return out, rows.Err()
+}
+
+// ApplyBulk applies c in one transaction; any failure rolls back every change. Batches touching the same
+// organization are serialized with a transaction-scoped advisory lock, then rows in c.Versions are locked and checked
+// and every scope in c.Scopes is re-read before anything is written, so a concurrent insert into a scope the batch
+// was validated against is caught.
+func (s *PostgresStore) ApplyBulk(c *BulkChanges) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	orgs := make([]string, 0, len(c.Scopes))
+	for _, sc := range c.Scopes {
+		if org := sc.OrganizationID.String(); !slices.Contains(orgs, org) {
+			orgs = append(orgs, org)
+		}
+	}
+	sort.Strings(orgs) // lock in a fixed order so two batches cannot deadlock
+	for _, org := range orgs {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('ipam_bulk'), hashtext($1))`, org); err != nil {
+			return err
+		}
+	}
+	if len(c.Versions) > 0 {
+		ids := make([]uuid.UUID, 0, len(c.Versions))
+		for id := range c.Versions {
+			ids = append(ids, id)
+		}
+		current, err := rowVersions(tx, ids, true)
+		if err != nil {
+			return err
+		}
+		for id, version := range c.Versions {
+			if v, ok := current[id]; !ok || v != version {
+				return ErrBulkConflict
+			}
+		}
+	}
+	for _, sc := range c.Scopes {
+		current, err := bulkScopeRows(tx, sc)
+		if err != nil {
+			return err
+		}
+		if !maps.Equal(current, sc.Rows) {
+			return ErrBulkConflict
+		}
+	}
+	for _, id := range c.DeleteAllocations {
+		if err := deleteAllocation(tx, id); err != nil {
+			return err
+		}
+	}
+	for _, id := range c.SoftDeleteAllocations {
+		if err := softDeleteAllocation(tx, id); err != nil {
+			return err
+		}
+	}
+	for _, id := range c.DeleteBlocks {
+		if err := deleteBlock(tx, id); err != nil {
+			return err
+		}
+	}
+	for _, id := range c.SoftDeleteBlocks {
+		if err := softDeleteBlock(tx, id); err != nil {
+			return err
+		}
+	}
+	for _, id := range c.DeleteReservedBlocks {
+		if err := deleteReservedBlock(tx, id); err != nil {
+			return err
+		}
+	}
+	for _, r := range c.UpdateReservedBlocks {
+		if err := updateReservedBlock(tx, r.ID, r); err != nil {
+			return err
+		}
+	}
+	for _, b := range c.UpdateBlocks {
+		if err := updateBlock(tx, b.ID, b); err != nil {
+			return err
+		}
+	}
+	for _, a := range c.UpdateAllocations {
+		if err := updateAllocation(tx, a.Id, a); err != nil {
+			return err
+		}
+	}
+	for _, env := range c.CreateEnvironments {
+		if err := createEnvironment(tx, env); err != nil {
+			return err
+		}
+	}
+	for _, pool := range c.CreatePools {
+		if err := createPool(tx, pool); err != nil {
+			return err
+		}
+	}
+	for _, r := range c.CreateReservedBlocks {
+		if err := createReservedBlock(tx, r); err != nil {
+			return err
+		}
+	}
+	for _, b := range c.CreateBlocks {
+		if err := createBlock(tx, b); err != nil {
+			return err
+		}
+	}
+	for _, a := range c.CreateAllocations {
+		if a.Id == uuid.Nil {
+			a.Id = s.GenerateID()
+		}
+		if err := createAllocation(tx, a.Id, a); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
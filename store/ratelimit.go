@@ -0,0 +1,68 @@
+package store
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimitStore keeps the token buckets of the API rate limiter. The Postgres store shares them between every
+// replica using the same database; the memory store keeps them in this process.
+type RateLimitStore interface {
+	// TakeRateLimitToken refills the bucket key at perSecond tokens per second, up to burst, and takes one token
+	// when it has one. It returns whether a token was taken and the tokens left in the bucket afterwards. A bucket
+	// seen for the first time starts full.
+	TakeRateLimitToken(key string, perSecond float64, burst int) (allowed bool, tokens float64, err error)
+	// DeleteIdleRateLimitBuckets deletes buckets not used for longer than idle. Callers pick an idle time after which
+	// every bucket has refilled, so deleting one is the same as keeping it full.
+	DeleteIdleRateLimitBuckets(idle time.Duration) error
+}
+
+// TokenBuckets is a RateLimitStore held in memory. The memory store uses one, and the API rate limiter uses its own
+// when buckets are not shared between replicas.
+type TokenBuckets struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// NewTokenBuckets returns an empty set of token buckets.
+func NewTokenBuckets() *TokenBuckets {
+	return &TokenBuckets{buckets: make(map[string]*tokenBucket)}
+}
+
+func (b *TokenBuckets) TakeRateLimitToken(key string, perSecond float64, burst int) (bool, float64, error) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bucket, ok := b.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(burst), updatedAt: now}
+		b.buckets[key] = bucket
+	}
+	if elapsed := now.Sub(bucket.updatedAt).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(float64(burst), bucket.tokens+elapsed*perSecond)
+	}
+	bucket.updatedAt = now
+	if bucket.tokens < 1 {
+		return false, bucket.tokens, nil
+	}
+	bucket.tokens--
+	return true, bucket.tokens, nil
+}
+
+func (b *TokenBuckets) DeleteIdleRateLimitBuckets(idle time.Duration) error {
+	cutoff := time.Now().Add(-idle)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, bucket := range b.buckets {
+		if bucket.updatedAt.Before(cutoff) {
+			delete(b.buckets, key)
+		}
+	}
+	return nil
+}
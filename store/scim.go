@@ -0,0 +1,28 @@
+package store
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SCIMGroup is a group pushed by an identity provider over SCIM. Groups do not grant anything by themselves; their
+// display names are matched against the SCIM group mappings to set members' organization and role.
+type SCIMGroup struct {
+	ID          uuid.UUID
+	DisplayName string
+	ExternalID  string
+	Members     []uuid.UUID // user ids
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type SCIMGroupStore interface {
+	// ListSCIMGroups returns groups ordered by display name.
+	ListSCIMGroups() ([]*SCIMGroup, error)
+	CreateSCIMGroup(g *SCIMGroup) error
+	GetSCIMGroup(id uuid.UUID) (*SCIMGroup, error)
+	// UpdateSCIMGroup replaces the group's display name, external id and members.
+	UpdateSCIMGroup(id uuid.UUID, g *SCIMGroup) error
+	DeleteSCIMGroup(id uuid.UUID) error
+}
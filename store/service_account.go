@@ -0,0 +1,57 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ServiceAccount is a non-human identity owned by an organization. It has a role but no password and never signs
+// in; it authenticates only with its API tokens, which belong to the account rather than to the admin who created
+// them, so automation keeps working when people leave.
+type ServiceAccount struct {
+	ID             uuid.UUID
+	OrganizationID uuid.UUID // required; service accounts are never global
+	Name           string    // unique within the organization
+	Description    string
+	Role           string
+	Disabled       bool // disabled accounts are rejected without deleting their tokens
+	CreatedAt      time.Time
+}
+
+// ServiceAccountStore manages service accounts and their API tokens. Service account tokens are APITokens with
+// ServiceAccountID set and UserID uuid.Nil; they are looked up with GetAPITokenByKeyHash like user tokens.
+type ServiceAccountStore interface {
+	CreateServiceAccount(sa *ServiceAccount) error
+	GetServiceAccount(id uuid.UUID) (*ServiceAccount, error)
+	// ListServiceAccounts returns the accounts of organizationID, or of every organization when it is nil, by name.
+	ListServiceAccounts(organizationID *uuid.UUID) ([]*ServiceAccount, error)
+	// UpdateServiceAccount saves Name, Description, Role and Disabled. Unlike SetUserRole it keeps the account's tokens.
+	UpdateServiceAccount(sa *ServiceAccount) error
+	// DeleteServiceAccount deletes the account and all of its tokens.
+	DeleteServiceAccount(id uuid.UUID) error
+	// CreateServiceAccountToken returns a new token for the account and its raw value (only returned here).
+	CreateServiceAccountToken(serviceAccountID uuid.UUID, name string, expiresAt *time.Time) (*APIToken, string, error)
+	// ListServiceAccountTokens returns the account's tokens, including expired ones, oldest first.
+	ListServiceAccountTokens(serviceAccountID uuid.UUID) ([]*APIToken, error)
+	// SetServiceAccountTokenExpiry changes when one of the account's tokens stops working; nil means never.
+	SetServiceAccountTokenExpiry(serviceAccountID, tokenID uuid.UUID, expiresAt *time.Time) error
+	DeleteServiceAccountToken(serviceAccountID, tokenID uuid.UUID) error
+}
+
+// validateServiceAccount trims sa.Name and checks the fields both stores require.
+func validateServiceAccount(sa *ServiceAccount) error {
+	sa.Name = strings.TrimSpace(sa.Name)
+	if sa.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if sa.OrganizationID == uuid.Nil {
+		return fmt.Errorf("organization is required")
+	}
+	if sa.Role != RoleUser && sa.Role != RoleAdmin {
+		return fmt.Errorf("invalid role")
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"math/big"
 	"time"
 
 	"github.com/JakeNeyer/ipam/network"
@@ -16,7 +17,7 @@ type OrganizationStore interface {
 	CreateOrganization(org *Organization) error
 	GetOrganization(id uuid.UUID) (*Organization, error)
 	ListOrganizations() ([]*Organization, error)
-	UpdateOrganization(org *Organization) error
+	UpdateOrganization(org *Organization) error // name and RequireMFA
 	DeleteOrganization(id uuid.UUID) error
 }
 
@@ -61,6 +62,14 @@ type AllocationStore interface {
 	ListAllocations() ([]*network.Allocation, error)
 	ListAllocationsFiltered(name string, blockName string, environmentID uuid.UUID, organizationID *uuid.UUID, provider string, connectionID *uuid.UUID, limit, offset int) ([]*network.Allocation, int, error)
 	ListAllocationsFilteredIncludingDeleted(name string, blockName string, environmentID uuid.UUID, organizationID *uuid.UUID, provider string, connectionID *uuid.UUID, limit, offset int) ([]*network.Allocation, int, error)
+	// ListAllocationsAfter returns up to limit allocations (all when limit <= 0) with an ID greater than after, ordered by
+	// ID, optionally restricted to an organization. It is the keyset-pagination counterpart of ListAllocationsFiltered.
+	ListAllocationsAfter(organizationID *uuid.UUID, after uuid.UUID, limit int) ([]*network.Allocation, error)
+	// BlockUsedAddresses returns the number of addresses allocated in each of blocks, keyed by block ID. Allocations
+	// reference their block by name only, so one counts toward a block when the names match (case-insensitively) and
+	// its CIDR lies within the block's; same-named blocks of other organizations are not counted. Blocks without
+	// allocations are omitted.
+	BlockUsedAddresses(blocks []*network.Block) (map[uuid.UUID]*big.Int, error)
 	UpdateAllocation(id uuid.UUID, alloc *network.Allocation) error
 	DeleteAllocation(id uuid.UUID) error
 	SoftDeleteAllocation(id uuid.UUID) error
@@ -83,16 +92,27 @@ type UserStore interface {
 	GetUserByOAuth(provider, providerUserID string) (*User, error)
 	ListUsers(organizationID *uuid.UUID) ([]*User, error)
 	DeleteUser(userID uuid.UUID) error
+	// SetUserRole and SetUserOrganization delete the user's sessions and API tokens when the value changes.
 	SetUserRole(userID uuid.UUID, role string) error
 	SetUserOrganization(userID uuid.UUID, organizationID uuid.UUID) error
 	SetUserTourCompleted(userID uuid.UUID, completed bool) error
 	SetUserOAuth(userID uuid.UUID, provider, providerUserID string) error
+	SetUserEmail(userID uuid.UUID, email string) error
+	SetUserExternalID(userID uuid.UUID, externalID string) error
+	// SetUserDisabled blocks or restores sign-in; disabling also deletes the user's sessions and API tokens.
+	SetUserDisabled(userID uuid.UUID, disabled bool) error
 }
 
 type SessionStore interface {
 	CreateSession(sessionID string, userID uuid.UUID, expiry time.Time)
 	GetSession(sessionID string) (*Session, error)
-	DeleteSession(sessionID string)
+	DeleteSession(sessionID string) error
+	// TouchSession records the latest request seen on a session.
+	TouchSession(sessionID string, lastSeen time.Time, ip, userAgent string)
+	// ListSessions returns the user's unexpired sessions, most recently used first.
+	ListSessions(userID uuid.UUID) ([]*Session, error)
+	// DeleteUserSessions deletes all of the user's sessions except keepSessionID (empty deletes all).
+	DeleteUserSessions(userID uuid.UUID, keepSessionID string) error
 }
 
 type APITokenStore interface {
@@ -118,8 +138,9 @@ type CloudConnectionStore interface {
 	CreateCloudConnection(c *CloudConnection) error
 	GetCloudConnection(id uuid.UUID) (*CloudConnection, error)
 	ListCloudConnectionsByOrganization(orgID uuid.UUID) ([]*CloudConnection, error)
-	ListCloudConnections() ([]*CloudConnection, error) // all connections, for background sync
-	UpdateCloudConnection(id uuid.UUID, c *CloudConnection) error
+	ListCloudConnections() ([]*CloudConnection, error)                              // all connections, for background sync
+	UpdateCloudConnection(id uuid.UUID, c *CloudConnection) error                   // settings only; sync state is kept
+	UpdateCloudConnectionSyncState(id uuid.UUID, st CloudConnectionSyncState) error // sync state only
 	DeleteCloudConnection(id uuid.UUID) error
 	WithSyncLock(ctx context.Context, connectionID uuid.UUID, fn func() error) (acquired bool, err error)
 }
@@ -137,6 +158,13 @@ type Storer interface {
 	UserStore
 	SessionStore
 	APITokenStore
+	ServiceAccountStore
 	SignupInviteStore
+	PasswordResetStore
 	CloudConnectionStore
+	BulkStore
+	BlueprintStore
+	SCIMGroupStore
+	MFAStore
+	RateLimitStore
 }
@@ -1,6 +1,9 @@
 package store
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -785,3 +788,617 @@ func TestCreateAPITokenWithRaw(t *testing.T) {
 func uuidPtrTest(id uuid.UUID) *uuid.UUID {
 	return &id
 }
+
+func TestStore_WithSyncLock(t *testing.T) {
+	s := NewStore()
+	id := uuid.New()
+	var innerAcquired bool
+	acquired, err := s.WithSyncLock(context.Background(), id, func() error {
+		var innerErr error
+		innerAcquired, innerErr = s.WithSyncLock(context.Background(), id, func() error {
+			t.Error("fn ran while the connection was locked")
+			return nil
+		})
+		if innerErr != nil {
+			t.Errorf("inner WithSyncLock err = %v, want nil", innerErr)
+		}
+		// Other connections are independent.
+		other, _ := s.WithSyncLock(context.Background(), uuid.New(), func() error { return nil })
+		if !other {
+			t.Error("lock on another connection was not acquired")
+		}
+		return nil
+	})
+	if !acquired || err != nil || innerAcquired {
+		t.Errorf("WithSyncLock = %v, %v (inner acquired %v); want true, nil (inner false)", acquired, err, innerAcquired)
+	}
+	if acquired, _ := s.WithSyncLock(context.Background(), id, func() error { return nil }); !acquired {
+		t.Error("lock not released after fn returned")
+	}
+}
+
+func TestStore_ApplyBulk(t *testing.T) {
+	s := NewStore()
+	keep := &network.Block{Name: "keep", CIDR: "10.0.0.0/16"}
+	gone := &network.Block{Name: "gone", CIDR: "10.1.0.0/16"}
+	for _, b := range []*network.Block{keep, gone} {
+		if err := s.CreateBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A missing row fails the whole batch before anything changes.
+	err := s.ApplyBulk(&BulkChanges{
+		DeleteBlocks: []uuid.UUID{gone.ID},
+		UpdateBlocks: []*network.Block{{ID: uuid.New(), Name: "missing"}},
+	})
+	if err == nil {
+		t.Fatal("ApplyBulk with missing block: want error")
+	}
+	if _, err := s.GetBlock(gone.ID); err != nil {
+		t.Errorf("block deleted by failed batch: %v", err)
+	}
+
+	updated := *keep
+	updated.Name = "kept"
+	created := &network.Allocation{Name: "a", Block: network.Block{Name: "kept", CIDR: "10.0.0.0/24"}}
+	env := &network.Environment{Name: "imported"}
+	pool := &network.Pool{Name: "imported-pool", CIDR: "172.16.0.0/12"}
+	err = s.ApplyBulk(&BulkChanges{
+		SoftDeleteBlocks:   []uuid.UUID{gone.ID},
+		UpdateBlocks:       []*network.Block{&updated},
+		CreateEnvironments: []*network.Environment{env},
+		CreatePools:        []*network.Pool{pool},
+		CreateAllocations:  []*network.Allocation{created},
+	})
+	if err != nil {
+		t.Fatalf("ApplyBulk: %v", err)
+	}
+	if b, _ := s.GetBlock(keep.ID); b == nil || b.Name != "kept" {
+		t.Errorf("updated block = %v", b)
+	}
+	if _, err := s.GetBlock(gone.ID); err == nil {
+		t.Error("soft-deleted block still visible")
+	}
+	if created.Id == uuid.Nil {
+		t.Error("created allocation was not assigned an ID")
+	}
+	if _, err := s.GetEnvironment(env.Id); err != nil {
+		t.Errorf("created environment: %v", err)
+	}
+	if _, err := s.GetPool(pool.ID); err != nil {
+		t.Errorf("created pool: %v", err)
+	}
+}
+
+func TestStore_ApplyBulkVersions(t *testing.T) {
+	s := NewStore()
+	b := &network.Block{Name: "vpc", CIDR: "10.0.0.0/16"}
+	if err := s.CreateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	read, err := s.RowVersions([]uuid.UUID{b.ID, uuid.New()})
+	if err != nil || len(read) != 1 {
+		t.Fatalf("RowVersions = %v, %v; want only the existing block", read, err)
+	}
+	other := *b
+	other.Name = "theirs"
+	if err := s.UpdateBlock(b.ID, &other); err != nil {
+		t.Fatal(err)
+	}
+	mine := *b
+	mine.Name = "mine"
+	err = s.ApplyBulk(&BulkChanges{Versions: read, UpdateBlocks: []*network.Block{&mine}})
+	if !errors.Is(err, ErrBulkConflict) {
+		t.Fatalf("ApplyBulk with stale version = %v, want ErrBulkConflict", err)
+	}
+	if got, _ := s.GetBlock(b.ID); got.Name != "theirs" {
+		t.Errorf("block name = %q, want the concurrent write kept", got.Name)
+	}
+	current, _ := s.RowVersions([]uuid.UUID{b.ID})
+	if err := s.ApplyBulk(&BulkChanges{Versions: current, UpdateBlocks: []*network.Block{&mine}}); err != nil {
+		t.Fatalf("ApplyBulk with current version: %v", err)
+	}
+}
+
+func TestStore_ListAllocationsAfter(t *testing.T) {
+	s := NewStore()
+	org := &Organization{Name: "org"}
+	if err := s.CreateOrganization(org); err != nil {
+		t.Fatal(err)
+	}
+	mine := &network.Block{Name: "Mine", CIDR: "10.0.0.0/16", OrganizationID: org.ID}
+	other := &network.Block{Name: "other", CIDR: "10.1.0.0/16", OrganizationID: uuid.New()}
+	for _, b := range []*network.Block{mine, other} {
+		if err := s.CreateBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		a := &network.Allocation{Id: uuid.New(), Name: fmt.Sprintf("a%d", i), Block: network.Block{Name: "mine", CIDR: fmt.Sprintf("10.0.%d.0/24", i)}}
+		if err := s.CreateAllocation(a.Id, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	foreign := &network.Allocation{Id: uuid.New(), Name: "x", Block: network.Block{Name: "other", CIDR: "10.1.0.0/24"}}
+	if err := s.CreateAllocation(foreign.Id, foreign); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []uuid.UUID
+	cursor := uuid.Nil
+	for {
+		page, err := s.ListAllocationsAfter(&org.ID, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range page {
+			seen = append(seen, a.Id)
+		}
+		if len(page) < 2 {
+			break
+		}
+		cursor = page[len(page)-1].Id
+	}
+	if len(seen) != 5 {
+		t.Fatalf("paged %d allocations, want 5", len(seen))
+	}
+	for i := 1; i < len(seen); i++ {
+		if seen[i-1].String() >= seen[i].String() {
+			t.Errorf("page order not by ID: %s before %s", seen[i-1], seen[i])
+		}
+	}
+	if all, _ := s.ListAllocationsAfter(nil, uuid.Nil, 0); len(all) != 6 {
+		t.Errorf("unscoped = %d, want 6", len(all))
+	}
+}
+
+func TestStore_BlockUsedAddresses(t *testing.T) {
+	s := NewStore()
+	add := func(block, cidr string) *network.Allocation {
+		a := &network.Allocation{Id: uuid.New(), Name: cidr, Block: network.Block{Name: block, CIDR: cidr}}
+		if err := s.CreateAllocation(a.Id, a); err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	vpc := &network.Block{ID: uuid.New(), Name: "vpc", CIDR: "10.0.0.0/16"}
+	v6 := &network.Block{ID: uuid.New(), Name: "v6", CIDR: "2001:db8::/48"}
+	empty := &network.Block{ID: uuid.New(), Name: "empty", CIDR: "10.9.0.0/16"}
+	add("VPC", "10.0.0.0/24")
+	second := add("vpc ", "10.0.1.0/25")
+	add("other", "10.1.0.0/16")
+	add("v6", "2001:db8::/64")
+
+	used, err := s.BlockUsedAddresses([]*network.Block{vpc, v6, empty})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used[vpc.ID].String() != "384" || used[v6.ID].String() != "18446744073709551616" {
+		t.Errorf("used = %v", used)
+	}
+	if _, ok := used[empty.ID]; ok {
+		t.Error("block without allocations reported")
+	}
+	if len(used) != 2 {
+		t.Errorf("unrequested block reported: %v", used)
+	}
+
+	// Returned counts are copies, and the cached index follows allocation changes.
+	used[vpc.ID].SetInt64(0)
+	if err := s.SoftDeleteAllocation(second.Id); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := s.BlockUsedAddresses([]*network.Block{vpc}); used[vpc.ID].String() != "256" {
+		t.Errorf("after soft delete used = %v, want 256", used[vpc.ID])
+	}
+	add("vpc", "10.0.2.0/26")
+	if used, _ := s.BlockUsedAddresses([]*network.Block{vpc}); used[vpc.ID].String() != "320" {
+		t.Errorf("after create used = %v, want 320", used[vpc.ID])
+	}
+
+	// Another organization's block with the same name only counts its own allocations.
+	orgA, orgB := &Organization{Name: "A"}, &Organization{Name: "B"}
+	for _, org := range []*Organization{orgA, orgB} {
+		if err := s.CreateOrganization(org); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blockA := &network.Block{ID: uuid.New(), Name: "shared", CIDR: "172.16.0.0/16", OrganizationID: orgA.ID}
+	blockB := &network.Block{ID: uuid.New(), Name: "Shared", CIDR: "172.17.0.0/16", OrganizationID: orgB.ID}
+	for _, b := range []*network.Block{blockA, blockB} {
+		if err := s.CreateBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("shared", "172.16.0.0/24")
+	add("Shared", "172.17.0.0/28")
+	used, err = s.BlockUsedAddresses([]*network.Block{blockA, blockB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used[blockA.ID].String() != "256" || used[blockB.ID].String() != "16" {
+		t.Errorf("two organizations: used = %v, want 256 and 16", used)
+	}
+}
+
+func TestStore_Blueprints(t *testing.T) {
+	s := NewStore()
+	org := &Organization{Name: "org"}
+	other := &Organization{Name: "other"}
+	for _, o := range []*Organization{org, other} {
+		if err := s.CreateOrganization(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spec := network.BlueprintSpec{Blocks: []network.BlueprintBlock{{Name: "{name}-vpc", PrefixLength: 20}}}
+	a := &Blueprint{OrganizationID: org.ID, Name: "Web", Spec: spec}
+	if err := s.CreateBlueprint(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateBlueprint(&Blueprint{OrganizationID: org.ID, Name: "web", Spec: spec}); err == nil {
+		t.Error("duplicate name in same org: want error")
+	}
+	if err := s.CreateBlueprint(&Blueprint{OrganizationID: other.ID, Name: "web", Spec: spec}); err != nil {
+		t.Errorf("same name in other org: %v", err)
+	}
+	b := &Blueprint{OrganizationID: org.ID, Name: "db", Spec: spec}
+	if err := s.CreateBlueprint(b); err != nil {
+		t.Fatal(err)
+	}
+	renamed := *b
+	renamed.Name = "WEB"
+	if err := s.UpdateBlueprint(b.ID, &renamed); err == nil {
+		t.Error("rename onto existing name: want error")
+	}
+	if list, _ := s.ListBlueprints(&org.ID); len(list) != 2 {
+		t.Errorf("org blueprints = %d, want 2", len(list))
+	}
+	if err := s.DeleteOrganization(org.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetBlueprint(a.ID); err == nil {
+		t.Error("blueprint survived organization delete")
+	}
+	if list, _ := s.ListBlueprints(nil); len(list) != 1 {
+		t.Errorf("all blueprints = %d, want 1", len(list))
+	}
+}
+
+func TestStore_SCIM(t *testing.T) {
+	s := NewStore()
+	alice := &User{Email: "alice@example.org", Role: RoleUser}
+	bob := &User{Email: "bob@example.org", Role: RoleUser}
+	for _, u := range []*User{alice, bob} {
+		if err := s.CreateUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := &SCIMGroup{DisplayName: "NetOps", Members: []uuid.UUID{alice.ID, alice.ID, bob.ID}}
+	if err := s.CreateSCIMGroup(g); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Members) != 2 {
+		t.Errorf("members = %v, want duplicates removed", g.Members)
+	}
+	if err := s.CreateSCIMGroup(&SCIMGroup{DisplayName: "netops"}); err == nil {
+		t.Error("duplicate display name: want error")
+	}
+	if err := s.CreateSCIMGroup(&SCIMGroup{DisplayName: "Ghosts", Members: []uuid.UUID{uuid.New()}}); err == nil {
+		t.Error("unknown member: want error")
+	}
+	if err := s.DeleteUser(bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetSCIMGroup(g.ID); len(got.Members) != 1 || got.Members[0] != alice.ID {
+		t.Errorf("members after user delete = %v, want [alice]", got.Members)
+	}
+
+	// Disabling a user revokes everything they could sign in with.
+	s.CreateSession("sess", alice.ID, time.Now().Add(time.Hour))
+	_, raw, err := s.CreateAPIToken(alice.ID, "t", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUserDisabled(alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := s.GetUser(alice.ID); !u.Disabled {
+		t.Error("user not disabled")
+	}
+	if _, err := s.GetSession("sess"); err == nil {
+		t.Error("session survived disable")
+	}
+	if _, err := s.GetAPITokenByKeyHash(hashToken(raw)); err == nil {
+		t.Error("API token survived disable")
+	}
+	if err := s.SetUserDisabled(alice.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := s.GetUser(alice.ID); u.Disabled {
+		t.Error("user still disabled")
+	}
+}
+
+func TestStore_MFA(t *testing.T) {
+	s := NewStore()
+	u := &User{Email: "alice@example.org", Role: RoleUser}
+	if err := s.CreateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUserTOTP(u.ID, "SECRET", true); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetUser(u.ID); got.TOTPSecret != "SECRET" || !got.TOTPEnabled {
+		t.Errorf("user = %+v, want TOTP enabled", got)
+	}
+	if err := s.UseTOTPStep(u.ID, 10); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []int64{10, 9} {
+		if err := s.UseTOTPStep(u.ID, step); err == nil {
+			t.Errorf("step %d after 10: want error", step)
+		}
+	}
+
+	if err := s.SetRecoveryCodes(u.ID, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UseRecoveryCode(u.ID, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UseRecoveryCode(u.ID, "a"); err == nil {
+		t.Error("reused recovery code: want error")
+	}
+	if n, _ := s.CountRecoveryCodes(u.ID); n != 1 {
+		t.Errorf("recovery codes = %d, want 1", n)
+	}
+
+	if err := s.CreateMFAChallenge("c1", u.ID, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateMFAChallenge("c2", u.ID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := s.GetMFAChallenge("c1"); err != nil || c.UserID != u.ID {
+		t.Errorf("GetMFAChallenge(c1) = %+v, %v", c, err)
+	}
+	if _, err := s.GetMFAChallenge("c2"); err == nil {
+		t.Error("expired challenge: want error")
+	}
+	for want := 1; want <= 2; want++ {
+		if c, err := s.AttemptMFAChallenge("c1", 2); err != nil || c.Attempts != want {
+			t.Fatalf("attempt %d = %+v, %v", want, c, err)
+		}
+	}
+	if _, err := s.AttemptMFAChallenge("c1", 2); err == nil {
+		t.Error("attempt over the limit: want error")
+	}
+	if _, err := s.GetMFAChallenge("c1"); err == nil {
+		t.Error("challenge out of attempts: want it deleted")
+	}
+	if err := s.CreateMFAChallenge("c1", u.ID, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	s.DeleteMFAChallenge("c1")
+	if _, err := s.GetMFAChallenge("c1"); err == nil {
+		t.Error("deleted challenge: want error")
+	}
+
+	// Clearing the secret turns MFA off and drops the recovery codes.
+	if err := s.SetUserTOTP(u.ID, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetUser(u.ID); got.TOTPSecret != "" || got.TOTPEnabled {
+		t.Errorf("user = %+v, want TOTP cleared", got)
+	}
+	if n, _ := s.CountRecoveryCodes(u.ID); n != 0 {
+		t.Errorf("recovery codes after reset = %d, want 0", n)
+	}
+	if err := s.UseTOTPStep(u.ID, 5); err != nil {
+		t.Errorf("step after reset: %v", err)
+	}
+}
+
+func TestStore_Sessions(t *testing.T) {
+	s := NewStore()
+	acme := &Organization{Name: "Acme"}
+	if err := s.CreateOrganization(acme); err != nil {
+		t.Fatal(err)
+	}
+	alice := &User{Email: "alice@example.org", Role: RoleUser, OrganizationID: acme.ID}
+	if err := s.CreateUser(alice); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.CreateSession("old", alice.ID, now.Add(time.Hour))
+	s.CreateSession("new", alice.ID, now.Add(time.Hour))
+	s.CreateSession("expired", alice.ID, now.Add(-time.Minute))
+	s.TouchSession("old", now.Add(-time.Hour), "192.0.2.1", "curl")
+	s.TouchSession("new", now, "192.0.2.2", "firefox")
+
+	sessions, err := s.ListSessions(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != "new" || sessions[1].ID != "old" {
+		t.Fatalf("sessions = %+v, want [new old]", sessions)
+	}
+	if sessions[0].IP != "192.0.2.2" || sessions[0].UserAgent != "firefox" || sessions[0].CreatedAt.IsZero() {
+		t.Errorf("session = %+v", sessions[0])
+	}
+	if err := s.DeleteUserSessions(alice.ID, "new"); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ := s.ListSessions(alice.ID); len(sessions) != 1 || sessions[0].ID != "new" {
+		t.Errorf("sessions after revoking others = %+v", sessions)
+	}
+
+	// Setting the same role keeps credentials; a change revokes sessions and API tokens.
+	if _, _, err := s.CreateAPIToken(alice.ID, "ci", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUserRole(alice.ID, RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetSession("new"); err != nil {
+		t.Errorf("unchanged role revoked the session: %v", err)
+	}
+	if err := s.SetUserRole(alice.ID, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetSession("new"); err == nil {
+		t.Error("session survived a role change")
+	}
+	if tokens, _ := s.ListAPITokens(alice.ID); len(tokens) != 0 {
+		t.Errorf("API tokens after role change = %d, want 0", len(tokens))
+	}
+	s.CreateSession("again", alice.ID, now.Add(time.Hour))
+	if err := s.SetUserOrganization(alice.ID, uuid.Nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetSession("again"); err == nil {
+		t.Error("session survived an organization change")
+	}
+}
+
+func TestStore_PasswordReset(t *testing.T) {
+	s := NewStore()
+	alice := &User{Email: "alice@example.org", PasswordHash: "old-hash", Role: RoleUser}
+	if err := s.CreateUser(alice); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, _, err := s.CreatePasswordReset(alice.ID, now.Add(-time.Minute)); err == nil {
+		t.Error("created a reset that already expired")
+	}
+	_, first, err := s.CreatePasswordReset(alice.ID, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, raw, err := s.CreatePasswordReset(alice.ID, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.TokenHash == raw || !strings.HasPrefix(raw, passwordResetTokenPrefix) {
+		t.Errorf("token %q stored as %q", raw, pr.TokenHash)
+	}
+	if _, err := s.GetPasswordResetByToken(first); err == nil {
+		t.Error("an earlier reset still works after a new one was created")
+	}
+	got, err := s.GetPasswordResetByToken(raw)
+	if err != nil || got.ID != pr.ID || got.UserID != alice.ID {
+		t.Fatalf("GetPasswordResetByToken = %+v, %v", got, err)
+	}
+
+	s.CreateSession("browser", alice.ID, now.Add(time.Hour))
+	if err := s.CreateMFAChallenge("pending", alice.ID, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UsePasswordReset(pr.ID, "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := s.GetUser(alice.ID); u.PasswordHash != "new-hash" {
+		t.Errorf("password hash = %q", u.PasswordHash)
+	}
+	if _, err := s.GetSession("browser"); err == nil {
+		t.Error("session survived a password reset")
+	}
+	if _, err := s.GetMFAChallenge("pending"); err == nil {
+		t.Error("pending MFA login survived a password reset")
+	}
+	if err := s.UsePasswordReset(pr.ID, "third-hash"); err == nil {
+		t.Error("reset used twice")
+	}
+	if _, err := s.GetPasswordResetByToken(raw); err == nil {
+		t.Error("used reset still found")
+	}
+
+	// Changing the password keeps the session it was changed from.
+	s.CreateSession("keep", alice.ID, now.Add(time.Hour))
+	s.CreateSession("other", alice.ID, now.Add(time.Hour))
+	_, raw, _ = s.CreatePasswordReset(alice.ID, now.Add(time.Hour))
+	if err := s.SetUserPassword(alice.ID, "changed-hash", "keep"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetSession("keep"); err != nil {
+		t.Errorf("current session revoked: %v", err)
+	}
+	if _, err := s.GetSession("other"); err == nil {
+		t.Error("other session survived a password change")
+	}
+	if _, err := s.GetPasswordResetByToken(raw); err == nil {
+		t.Error("reset link still works after a password change")
+	}
+}
+
+func TestStore_ServiceAccounts(t *testing.T) {
+	s := NewStore()
+	org := &Organization{Name: "Acme"}
+	if err := s.CreateOrganization(org); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateServiceAccount(&ServiceAccount{Name: "ci", Role: RoleUser}); err == nil {
+		t.Error("created a service account without an organization")
+	}
+	ci := &ServiceAccount{OrganizationID: org.ID, Name: " ci ", Role: RoleAdmin}
+	if err := s.CreateServiceAccount(ci); err != nil {
+		t.Fatal(err)
+	}
+	if ci.Name != "ci" || ci.ID == uuid.Nil {
+		t.Errorf("created %+v", ci)
+	}
+	if err := s.CreateServiceAccount(&ServiceAccount{OrganizationID: org.ID, Name: "CI", Role: RoleUser}); err == nil {
+		t.Error("duplicate name in the same organization was accepted")
+	}
+
+	old, oldRaw, err := s.CreateServiceAccountToken(ci.ID, "deploy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.UserID != uuid.Nil || old.ServiceAccountID != ci.ID {
+		t.Errorf("token owner = user %s, service account %s", old.UserID, old.ServiceAccountID)
+	}
+	_, newRaw, err := s.CreateServiceAccountToken(ci.ID, "deploy", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range []string{oldRaw, newRaw} {
+		if tok, err := s.GetAPITokenByKeyHash(hashToken(raw)); err != nil || tok.ServiceAccountID != ci.ID {
+			t.Errorf("GetAPITokenByKeyHash = %+v, %v", tok, err)
+		}
+	}
+	past := time.Now().Add(-time.Second)
+	if err := s.SetServiceAccountTokenExpiry(ci.ID, old.ID, &past); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAPITokenByKeyHash(hashToken(oldRaw)); err == nil {
+		t.Error("expired token still works")
+	}
+	if err := s.SetServiceAccountTokenExpiry(uuid.New(), old.ID, nil); err == nil {
+		t.Error("changed the expiry of another account's token")
+	}
+	if tokens, _ := s.ListServiceAccountTokens(ci.ID); len(tokens) != 2 {
+		t.Errorf("ListServiceAccountTokens = %d tokens, want 2", len(tokens))
+	}
+
+	// A role change keeps the tokens, unlike SetUserRole.
+	update := *ci
+	update.Role = RoleUser
+	if err := s.UpdateServiceAccount(&update); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAPITokenByKeyHash(hashToken(newRaw)); err != nil {
+		t.Errorf("token revoked by a role change: %v", err)
+	}
+
+	if err := s.DeleteOrganization(org.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetServiceAccount(ci.ID); err == nil {
+		t.Error("service account survived its organization")
+	}
+	if _, err := s.GetAPITokenByKeyHash(hashToken(newRaw)); err == nil {
+		t.Error("service account token survived its organization")
+	}
+}
@@ -12,6 +12,9 @@ const (
 )
 
 // OrganizationID is uuid.Nil for the global admin (created at setup); otherwise the user belongs to that organization.
+// Disabled users cannot sign in or use API tokens. ExternalID is the identity provider's id for users provisioned
+// through SCIM. TOTPEnabled is set once the user has confirmed a TOTP secret; from then on password logins need a
+// second factor.
 type User struct {
 	ID                  uuid.UUID
 	Email               string
@@ -21,11 +24,22 @@ type User struct {
 	OrganizationID      uuid.UUID
 	OAuthProvider       string
 	OAuthProviderUserID string
+	Disabled            bool
+	ExternalID          string
+	TOTPSecret          string
+	TOTPEnabled         bool
 }
 
+// Session is a signed-in browser. ID is the cookie value and must never leave the server. Expiry is the absolute
+// timeout; LastSeenAt, IP and UserAgent come from the latest request (throttled), for idle timeouts and for listing.
 type Session struct {
-	UserID uuid.UUID
-	Expiry time.Time
+	ID         string
+	UserID     uuid.UUID
+	Expiry     time.Time
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	IP         string
+	UserAgent  string
 }
 
 func (s *Session) Expired() bool {
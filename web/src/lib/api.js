@@ -51,6 +51,7 @@ async function post(path, body) {
     body: JSON.stringify(body),
   })
   if (!res.ok) await handleError(res)
+  if (res.status === 204) return
   return res.json()
 }
 
@@ -268,7 +269,7 @@ export async function deleteAllocation(id) {
 
 /**
  * Auth config (no auth required).
- * @returns {{ oauthProviders: string[], oauthProviderOptions: { id: string, displayName: string }[] }}
+ * @returns {{ oauthProviders: string[], oauthProviderOptions: { id: string, displayName: string, startPath: string }[], ldapEnabled: boolean }}
  */
 export async function getAuthConfig() {
   const data = await get('/auth/config')
@@ -278,21 +279,46 @@ export async function getAuthConfig() {
         .map((o) => ({
           id: String(o?.id ?? ''),
           displayName: String(o?.display_name ?? ''),
+          startPath: String(o?.start_path || `/api/auth/oauth/${encodeURIComponent(String(o?.id ?? ''))}/start`),
         }))
         .filter((o) => o.id)
     : providers.map((id) => ({
         id,
         displayName: `Sign in with ${id.charAt(0).toUpperCase()}${id.slice(1)}`,
+        startPath: `/api/auth/oauth/${encodeURIComponent(id)}/start`,
       }))
   return {
     oauthProviders: providers,
     oauthProviderOptions: options,
+    ldapEnabled: data?.ldap_enabled === true,
+    passwordResetEnabled: data?.password_reset_enabled === true,
   }
 }
 
+/** User from a login or /auth/me response, flagged when the organization requires MFA enrollment first. */
+function sessionUser(data) {
+  if (!data?.user) return null
+  return { ...data.user, mfa_enrollment_required: data.mfa_enrollment_required === true }
+}
+
+/**
+ * Sign in with email and password. When the account has MFA on, no session is started yet and
+ * { mfa_required: true, mfa_token } is returned; pass the token to loginMfa with the user's code.
+ */
 export async function login(email, password) {
   const data = await post('/auth/login', { email, password })
-  return data?.user ?? null
+  if (data?.mfa_required) return { mfa_required: true, mfa_token: data.mfa_token }
+  return sessionUser(data)
+}
+
+/**
+ * Second login step for accounts with MFA.
+ * @param {string} mfaToken - mfa_token returned by login
+ * @param {string} code - TOTP code or recovery code
+ */
+export async function loginMfa(mfaToken, code) {
+  const data = await post('/auth/login/mfa', { mfa_token: mfaToken, code })
+  return sessionUser(data)
 }
 
 export async function logout() {
@@ -304,7 +330,7 @@ export async function getMe() {
   if (res.status === 401) return null
   if (!res.ok) throw new Error(parseErrorMessage(await res.text()))
   const data = await res.json()
-  return data?.user ?? null
+  return sessionUser(data)
 }
 
 /**
@@ -320,6 +346,94 @@ export async function completeTour() {
   return res.json()
 }
 
+/**
+ * MFA status of the current user.
+ * @returns {{ enabled: boolean, required: boolean, recovery_codes_remaining: number }}
+ */
+export async function getMfaStatus() {
+  return get('/auth/me/mfa')
+}
+
+/**
+ * Start (or restart) TOTP enrollment. MFA stays off until verifyMfa succeeds.
+ * @returns {{ secret: string, uri: string, qr_code: string }}
+ */
+export async function enrollMfa() {
+  return post('/auth/me/mfa/enroll', {})
+}
+
+/**
+ * Confirm enrollment with a code from the authenticator app.
+ * @returns {{ recovery_codes: string[] }}
+ */
+export async function verifyMfa(code) {
+  return post('/auth/me/mfa/verify', { code })
+}
+
+/**
+ * Replace the recovery codes; requires a current TOTP code.
+ * @returns {{ recovery_codes: string[] }}
+ */
+export async function regenerateRecoveryCodes(code) {
+  return post('/auth/me/mfa/recovery-codes', { code })
+}
+
+/** Turn MFA off with a TOTP or recovery code. */
+export async function disableMfa(code) {
+  const res = await fetch(`${API_BASE}/auth/me/mfa/disable`, {
+    ...FETCH_OPTS,
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ code }),
+  })
+  if (!res.ok) await handleError(res)
+}
+
+/**
+ * Change the current user's password. Their other sessions are signed out.
+ * @param {string} currentPassword
+ * @param {string} newPassword
+ */
+export async function changePassword(currentPassword, newPassword) {
+  await post('/auth/me/password', { current_password: currentPassword, new_password: newPassword })
+}
+
+/**
+ * Email a password reset link (no auth). Succeeds whether or not the email has an account.
+ * @param {string} email
+ */
+export async function requestPasswordReset(email) {
+  await post('/auth/password-reset', { email })
+}
+
+/**
+ * Set a new password with the token from a reset link (no auth). Signs the user out everywhere.
+ * @param {string} token
+ * @param {string} password
+ */
+export async function resetPassword(token, password) {
+  await post('/auth/password-reset/confirm', { token, password })
+}
+
+/**
+ * Active sessions of the current user, most recently used first.
+ * @returns {{ sessions: Array<{ id: string, created_at: string, last_seen_at?: string, expires_at: string, ip?: string, user_agent?: string, current: boolean }> }}
+ */
+export async function listSessions() {
+  const data = await get('/auth/me/sessions')
+  return { sessions: data.sessions ?? [] }
+}
+
+/** Sign out one of the current user's sessions. */
+export async function revokeSession(id) {
+  await del('/auth/me/sessions/' + encodeURIComponent(id))
+}
+
+/** Sign out all of the current user's sessions except this one. */
+export async function revokeOtherSessions() {
+  await del('/auth/me/sessions')
+}
+
 /**
  * List API tokens for the current user.
  * @returns {{ tokens: Array<{ id: string, name: string, created_at: string, expires_at?: string | null, organization_id?: string }> }}
@@ -542,17 +656,21 @@ export async function createOrganization(name) {
 }
 
 /**
- * Update an organization's name. Global admin only.
+ * Update an organization's name and/or MFA policy. Global admin only.
  * @param {string} id - Organization UUID
- * @param {string} name
- * @returns {{ organization: { id: string, name: string, created_at: string } }}
+ * @param {string|null} name - null keeps the current name
+ * @param {boolean} [requireMfa] - When set, requires (or stops requiring) MFA for the org's password users
+ * @returns {{ organization: { id: string, name: string, created_at: string, require_mfa: boolean } }}
  */
-export async function updateOrganization(id, name) {
+export async function updateOrganization(id, name, requireMfa) {
+  const body = {}
+  if (name != null) body.name = name
+  if (requireMfa !== undefined) body.require_mfa = requireMfa
   const data = await fetch(`${API_BASE}/admin/organizations/${encodeURIComponent(id)}`, {
     ...FETCH_OPTS,
     method: 'PATCH',
     headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify({ name }),
+    body: JSON.stringify(body),
   })
   if (!data.ok) await handleError(data)
   return data.json()
@@ -601,6 +719,22 @@ export async function deleteUser(id) {
   await del('/admin/users/' + encodeURIComponent(id))
 }
 
+/**
+ * Sign a user out of all sessions. Admins: users in their organization.
+ * @param {string} id - User UUID
+ */
+export async function revokeUserSessions(id) {
+  await del('/admin/users/' + encodeURIComponent(id) + '/sessions')
+}
+
+/**
+ * Turn MFA off for a user who lost their authenticator. Admins: users in their organization.
+ * @param {string} id - User UUID
+ */
+export async function resetUserMfa(id) {
+  await del('/admin/users/' + encodeURIComponent(id) + '/mfa')
+}
+
 /**
  * Update a user's organization. Global admin only.
  * @param {string} userId
@@ -622,6 +756,86 @@ export async function updateUserOrganization(userId, organizationId) {
   return res?.user ?? null
 }
 
+/**
+ * List service accounts (admin only). Global admin sees every organization.
+ * @returns {{ service_accounts: Array<{ id: string, organization_id: string, name: string, description?: string, role: string, disabled: boolean, created_at: string }> }}
+ */
+export async function listServiceAccounts() {
+  const data = await get('/admin/service-accounts')
+  return { service_accounts: data?.service_accounts ?? [] }
+}
+
+/**
+ * Create a service account (admin only). Org admins create it in their own organization.
+ * @param {{ name: string, description?: string, role?: 'user' | 'admin', organization_id?: string }} body - organization_id: global admin only, required for them
+ */
+export async function createServiceAccount(body) {
+  const data = await post('/admin/service-accounts', body)
+  return data?.service_account ?? null
+}
+
+/**
+ * Update a service account's name, description, role or disabled flag (admin only). Its tokens are kept.
+ * @param {string} id
+ * @param {{ name: string, description?: string, role: string, disabled: boolean }} body
+ */
+export async function updateServiceAccount(id, body) {
+  const data = await put('/admin/service-accounts/' + encodeURIComponent(id), body)
+  return data?.service_account ?? null
+}
+
+/**
+ * Delete a service account and all of its tokens (admin only).
+ * @param {string} id
+ */
+export async function deleteServiceAccount(id) {
+  await del('/admin/service-accounts/' + encodeURIComponent(id))
+}
+
+/**
+ * List a service account's tokens, including expired ones.
+ * @param {string} id
+ * @returns {{ tokens: Array<{ id: string, name: string, created_at: string, expires_at?: string | null }> }}
+ */
+export async function listServiceAccountTokens(id) {
+  const data = await get('/admin/service-accounts/' + encodeURIComponent(id) + '/tokens')
+  return { tokens: data?.tokens ?? [] }
+}
+
+/**
+ * Create a token for a service account. The raw token is only returned once.
+ * @param {string} id
+ * @param {string} name
+ * @param {string|null} [expiresAt] - RFC3339
+ */
+export async function createServiceAccountToken(id, name, expiresAt = null) {
+  const body = { name }
+  if (expiresAt) body.expires_at = expiresAt
+  return post('/admin/service-accounts/' + encodeURIComponent(id) + '/tokens', body)
+}
+
+/**
+ * Replace a service account token. The old token keeps working for gracePeriodHours so clients can switch over.
+ * @param {string} id
+ * @param {string} tokenId
+ * @param {number} [gracePeriodHours=24] - 0 revokes the old token immediately
+ * @returns {{ token: { id: string, name: string, token: string, created_at: string, expires_at?: string | null }, previous: { id: string, expires_at: string } }}
+ */
+export async function rotateServiceAccountToken(id, tokenId, gracePeriodHours = 24) {
+  return post('/admin/service-accounts/' + encodeURIComponent(id) + '/tokens/' + encodeURIComponent(tokenId) + '/rotate', {
+    grace_period_hours: gracePeriodHours,
+  })
+}
+
+/**
+ * Revoke a service account token immediately.
+ * @param {string} id
+ * @param {string} tokenId
+ */
+export async function deleteServiceAccountToken(id, tokenId) {
+  await del('/admin/service-accounts/' + encodeURIComponent(id) + '/tokens/' + encodeURIComponent(tokenId))
+}
+
 /**
  * List signup invites (admin only).
  * @returns {{ invites: Array<{ id, created_at, expires_at, used_at?, used_by_email? }> }}